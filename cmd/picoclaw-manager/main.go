@@ -1,15 +1,23 @@
 package main
 
 import (
+	"context"
 	"database/sql"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/sipeed/picoclaw/pkg/manager/access"
 	"github.com/sipeed/picoclaw/pkg/manager/api"
+	"github.com/sipeed/picoclaw/pkg/manager/archive"
 	k8sclient "github.com/sipeed/picoclaw/pkg/manager/k8s"
+	"github.com/sipeed/picoclaw/pkg/manager/local"
 	"github.com/sipeed/picoclaw/pkg/manager/templates"
 	"github.com/sipeed/picoclaw/pkg/manager/tenant"
 )
@@ -21,47 +29,200 @@ func main() {
 	image := envOrDefault("PICOCLAW_IMAGE", "huy2408/picoclaw:latest")
 	templateDir := envOrDefault("TEMPLATE_DIR", "k8s/base")
 	kubeconfig := os.Getenv("KUBECONFIG")
+	reconcileInterval, err := time.ParseDuration(envOrDefault("RECONCILE_INTERVAL", "30s"))
+	if err != nil || reconcileInterval <= 0 {
+		log.Fatalf("invalid RECONCILE_INTERVAL: %q", os.Getenv("RECONCILE_INTERVAL"))
+	}
+	idleAfter, err := time.ParseDuration(envOrDefault("IDLE_SUSPEND_AFTER", "0"))
+	if err != nil || idleAfter < 0 {
+		log.Fatalf("invalid IDLE_SUSPEND_AFTER: %q", os.Getenv("IDLE_SUSPEND_AFTER"))
+	}
+	healthTimeout, err := time.ParseDuration(envOrDefault("UPGRADE_HEALTH_TIMEOUT", tenant.DefaultHealthTimeout.String()))
+	if err != nil || healthTimeout <= 0 {
+		log.Fatalf("invalid UPGRADE_HEALTH_TIMEOUT: %q", os.Getenv("UPGRADE_HEALTH_TIMEOUT"))
+	}
+
+	store, accessStore, closeStore := openStore(envOrDefault("STORE", "postgres"), dbURL)
+	defer closeStore()
+	if n, err := store.FailInterrupted(); err != nil {
+		log.Fatalf("%v", err)
+	} else if n > 0 {
+		log.Printf("marked %d operations interrupted by the last shutdown as failed", n)
+	}
+	if n, err := store.PauseInterruptedUpgrades(); err != nil {
+		log.Fatalf("%v", err)
+	} else if n > 0 {
+		log.Printf("paused %d upgrades interrupted by the last shutdown; resume or roll them back", n)
+	}
+
+	runtime, closeRuntime := newRuntime(envOrDefault("RUNTIME", "kubernetes"), image, templateDir, kubeconfig)
+	defer closeRuntime()
+
+	// Tenant service.
+	svc := tenant.NewService(store, runtime)
+	svc.Archives = newArchiveStore()
+	svc.HealthTimeout = healthTimeout
+	if err := svc.MigrateSecrets(context.Background()); err != nil {
+		log.Fatalf("migrate tenant secrets: %v", err)
+	}
+	if err := svc.MigrateVersions(context.Background()); err != nil {
+		log.Fatalf("record tenant versions: %v", err)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Reconciler: reports real tenant status and repairs drift.
+	reconciler := tenant.NewReconciler(store, runtime)
+	reconciler.Hold = svc.TryReserve
+	go reconciler.Run(ctx, reconcileInterval)
+	log.Printf("reconciling tenants every %s", reconcileInterval)
+
+	// Idle policy: suspends tenants without inbound traffic.
+	if idleAfter > 0 {
+		go svc.RunIdleSuspend(ctx, idleAfter, reconcileInterval)
+		log.Printf("suspending tenants idle for %s", idleAfter)
+	}
+
+	// API keys and audit log. API_KEY stays usable as an admin key.
+	keys := access.NewService(accessStore, apiKey)
+
+	// HTTP server.
+	server := &http.Server{Addr: listenAddr, Handler: api.NewServer(svc, keys)}
+	go func() {
+		<-ctx.Done()
+		log.Println("shutting down, waiting for running operations")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		server.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("picoclaw-manager listening on %s", listenAddr)
+	if err := server.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		log.Fatalf("server error: %v", err)
+	}
+	svc.Wait()
+	fmt.Println()
+}
+
+// openStore opens the tenant store and the API key store of the given
+// kind: "postgres" (at dbURL), "sqlite" (at SQLITE_PATH) or "memory".
+func openStore(kind, dbURL string) (tenant.TenantStore, access.Store, func()) {
+	var driver, dsn string
+	switch kind {
+	case "memory":
+		log.Println("using the in-memory store: tenants and API keys are lost on restart")
+		return tenant.NewMemoryStore(), access.NewMemoryStore(), func() {}
+	case "postgres":
+		driver, dsn = "postgres", dbURL
+	case "sqlite":
+		driver, dsn = "sqlite", envOrDefault("SQLITE_PATH", "picoclaw-manager.db")
+	default:
+		log.Fatalf("invalid STORE: %q (want postgres, sqlite or memory)", kind)
+	}
 
-	// Database.
-	db, err := sql.Open("postgres", dbURL)
+	db, err := sql.Open(driver, dsn)
 	if err != nil {
 		log.Fatalf("open database: %v", err)
 	}
-	defer db.Close()
 	if err := db.Ping(); err != nil {
 		log.Fatalf("ping database: %v", err)
 	}
-	log.Println("connected to database")
+	log.Printf("connected to %s database", kind)
 
-	store, err := tenant.NewStore(db)
+	secretsKey := os.Getenv("SECRETS_KEY")
+	if secretsKey == "" {
+		log.Fatalf("SECRETS_KEY is required (base64 of 32 random bytes, e.g. openssl rand -base64 32)")
+	}
+	box, err := tenant.NewSecretBox(secretsKey)
 	if err != nil {
-		log.Fatalf("init tenant store: %v", err)
+		log.Fatalf("init secrets encryption: %v", err)
 	}
 
-	// Template renderer.
-	renderer, err := templates.NewRendererFromDir(templateDir)
+	var store *tenant.Store
+	var accessStore *access.SQLStore
+	if kind == "sqlite" {
+		store, err = tenant.NewSQLiteStore(db, box)
+	} else {
+		store, err = tenant.NewStore(db, box)
+	}
 	if err != nil {
-		log.Fatalf("init template renderer: %v", err)
+		log.Fatalf("init tenant store: %v", err)
+	}
+	if kind == "sqlite" {
+		accessStore, err = access.NewSQLiteStore(db)
+	} else {
+		accessStore, err = access.NewStore(db)
 	}
-
-	// K8s client.
-	k8sClient, err := k8sclient.NewClient(kubeconfig)
 	if err != nil {
-		log.Fatalf("init k8s client: %v", err)
+		log.Fatalf("init api key store: %v", err)
 	}
-	applier := k8sclient.NewApplier(k8sClient)
-
-	// Tenant service.
-	svc := tenant.NewService(store, renderer, applier, image)
+	return store, accessStore, func() { db.Close() }
+}
 
-	// HTTP server.
-	handler := api.NewServer(svc, apiKey)
+// newRuntime creates the runtime of the given kind: "kubernetes" or
+// "local".
+func newRuntime(kind, image, templateDir, kubeconfig string) (tenant.Runtime, func()) {
+	switch kind {
+	case "kubernetes":
+		catalog, err := templates.LoadCatalog(templateDir, os.Getenv("TEMPLATE_VERSION"))
+		if err != nil {
+			log.Fatalf("load templates: %v", err)
+		}
+		log.Printf("template versions %s, new tenants get %s", strings.Join(catalog.Versions(), ", "), catalog.Default())
+		k8sClient, err := k8sclient.NewClient(kubeconfig)
+		if err != nil {
+			log.Fatalf("init k8s client: %v", err)
+		}
+		return tenant.NewKubernetesRuntime(catalog, k8sclient.NewApplier(k8sClient), image), func() {}
+	case "local":
+		basePort, err := strconv.Atoi(envOrDefault("LOCAL_BASE_PORT", "18800"))
+		if err != nil {
+			log.Fatalf("invalid LOCAL_BASE_PORT: %q", os.Getenv("LOCAL_BASE_PORT"))
+		}
+		runtime, err := local.New(local.Config{
+			Dir:      envOrDefault("LOCAL_DIR", "tenants"),
+			Binary:   envOrDefault("LOCAL_BINARY", "picoclaw"),
+			BasePort: basePort,
+			Env:      splitList(os.Getenv("LOCAL_ENV")),
+		})
+		if err != nil {
+			log.Fatalf("init local runtime: %v", err)
+		}
+		log.Println("running tenants as local gateway processes")
+		return runtime, runtime.Close
+	default:
+		log.Fatalf("invalid RUNTIME: %q (want kubernetes or local)", kind)
+		return nil, nil
+	}
+}
 
-	log.Printf("picoclaw-manager listening on %s", listenAddr)
-	if err := http.ListenAndServe(listenAddr, handler); err != nil {
-		log.Fatalf("server error: %v", err)
+// newArchiveStore creates where workspace snapshots are kept: an
+// S3-compatible bucket when SNAPSHOT_S3_BUCKET is set, otherwise the
+// directory SNAPSHOT_DIR.
+func newArchiveStore() tenant.ArchiveStore {
+	if bucket := os.Getenv("SNAPSHOT_S3_BUCKET"); bucket != "" {
+		store, err := archive.NewS3(archive.S3Config{
+			Endpoint:  envOrDefault("SNAPSHOT_S3_ENDPOINT", "https://s3.amazonaws.com"),
+			Region:    os.Getenv("SNAPSHOT_S3_REGION"),
+			Bucket:    bucket,
+			Prefix:    os.Getenv("SNAPSHOT_S3_PREFIX"),
+			AccessKey: os.Getenv("SNAPSHOT_S3_ACCESS_KEY"),
+			SecretKey: os.Getenv("SNAPSHOT_S3_SECRET_KEY"),
+		})
+		if err != nil {
+			log.Fatalf("init snapshot storage: %v", err)
+		}
+		log.Printf("storing snapshots in bucket %s", bucket)
+		return store
 	}
-	fmt.Println()
+	dir := envOrDefault("SNAPSHOT_DIR", "snapshots")
+	store, err := archive.NewDir(dir)
+	if err != nil {
+		log.Fatalf("init snapshot storage: %v", err)
+	}
+	log.Printf("storing snapshots in %s", dir)
+	return store
 }
 
 func envOrDefault(key, fallback string) string {
@@ -70,3 +231,14 @@ func envOrDefault(key, fallback string) string {
 	}
 	return fallback
 }
+
+// splitList splits a comma-separated setting, dropping empty entries.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -10,6 +10,7 @@ import (
 	"bufio"
 	"context"
 	"embed"
+	"encoding/json"
 	"fmt"
 	"io"
 	"io/fs"
@@ -18,6 +19,7 @@ import (
 	"os/signal"
 	"path/filepath"
 	"runtime"
+	"sort"
 	"strings"
 	"time"
 
@@ -32,11 +34,13 @@ import (
 	"github.com/sipeed/picoclaw/pkg/health"
 	"github.com/sipeed/picoclaw/pkg/heartbeat"
 	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/memory"
 	"github.com/sipeed/picoclaw/pkg/migrate"
 	"github.com/sipeed/picoclaw/pkg/providers"
 	"github.com/sipeed/picoclaw/pkg/skills"
 	"github.com/sipeed/picoclaw/pkg/state"
 	"github.com/sipeed/picoclaw/pkg/tools"
+	"github.com/sipeed/picoclaw/pkg/utils"
 	"github.com/sipeed/picoclaw/pkg/voice"
 )
 
@@ -142,6 +146,10 @@ func main() {
 		authCmd()
 	case "cron":
 		cronCmd()
+	case "queue":
+		queueCmd()
+	case "memory":
+		memoryCmd()
 	case "skills":
 		if len(os.Args) < 3 {
 			skillsHelp()
@@ -175,6 +183,12 @@ func main() {
 				return
 			}
 			skillsRemoveCmd(installer, os.Args[3])
+		case "update":
+			skillsUpdateCmd(installer, os.Args[3:])
+		case "outdated":
+			skillsOutdatedCmd(installer)
+		case "verify":
+			skillsVerifyCmd(installer)
 		case "install-builtin":
 			skillsInstallBuiltinCmd(workspace)
 		case "list-builtin":
@@ -211,6 +225,8 @@ func printHelp() {
 	fmt.Println("  gateway     Start picoclaw gateway")
 	fmt.Println("  status      Show picoclaw status")
 	fmt.Println("  cron        Manage scheduled tasks")
+	fmt.Println("  queue       Inspect and replay queued inbound messages")
+	fmt.Println("  memory      Consolidate memory and review changes")
 	fmt.Println("  migrate     Migrate from OpenClaw to PicoClaw")
 	fmt.Println("  skills      Manage skills (install, list, remove)")
 	fmt.Println("  version     Show version information")
@@ -534,13 +550,35 @@ func gatewayCmd() {
 		os.Exit(1)
 	}
 
+	// The health server reports traffic and LLM usage to the manager.
+	healthServer := health.NewServer(cfg.Gateway.Host, cfg.Gateway.Port)
+	countUsage := func(p providers.LLMProvider) providers.LLMProvider {
+		return providers.Observe(p, func(resp *providers.LLMResponse) {
+			var prompt, completion int
+			if resp.Usage != nil {
+				prompt, completion = resp.Usage.PromptTokens, resp.Usage.CompletionTokens
+			}
+			healthServer.CountLLMCall(prompt, completion, len(resp.ToolCalls))
+		})
+	}
+
 	provider, err := providers.CreateProvider(cfg)
 	if err != nil {
 		fmt.Printf("Error creating provider: %v\n", err)
 		os.Exit(1)
 	}
+	provider = countUsage(provider)
 
 	msgBus := bus.NewMessageBus()
+	var inboundQueue *bus.Queue
+	if cfg.Gateway.Queue.Enabled {
+		inboundQueue, err = bus.OpenQueue(queueDir(cfg), cfg.Gateway.Queue.MaxAttempts)
+		if err != nil {
+			fmt.Printf("Error opening inbound queue: %v\n", err)
+			os.Exit(1)
+		}
+		msgBus.SetQueue(inboundQueue)
+	}
 	agentLoop := agent.NewAgentLoop(cfg, msgBus, provider)
 
 	// Print agent startup info
@@ -561,8 +599,14 @@ func gatewayCmd() {
 			"skills_available": skillsInfo["available"],
 		})
 
+	// Additional agents with their own workspaces, routed by chat
+	router, agentLoops := setupAgents(cfg, msgBus, agentLoop, countUsage)
+	if router != nil {
+		fmt.Printf("  • Agents: %s\n", strings.Join(router.Names(), ", "))
+	}
+
 	// Setup cron tool and service
-	cronService := setupCronTool(agentLoop, msgBus, cfg.WorkspacePath(), cfg.Agents.Defaults.RestrictToWorkspace)
+	cronService, cronTool := setupCronTool(agentLoop, msgBus, cfg.WorkspacePath(), cfg.Agents.Defaults.RestrictToWorkspace)
 
 	heartbeatService := heartbeat.NewHeartbeatService(
 		cfg.WorkspacePath(),
@@ -588,14 +632,26 @@ func gatewayCmd() {
 		return tools.SilentResult(response)
 	})
 
+	// Consolidate old daily notes and session summaries into MEMORY.md,
+	// whether or not the heartbeat is enabled
+	var consolidator *memory.Consolidator
+	if cfg.Memory.Consolidate {
+		consolidator = memory.NewConsolidator(cfg.WorkspacePath(), provider, cfg.Agents.Defaults.Model)
+		consolidator.SetAfterDays(cfg.Memory.ConsolidateAfterDays)
+		consolidator.SetIncludeSessions(!cfg.Memory.PerUser)
+		consolidator.SetIndex(agentLoop.MemoryIndex())
+	}
+
 	channelManager, err := channels.NewManager(cfg, msgBus)
 	if err != nil {
 		fmt.Printf("Error creating channel manager: %v\n", err)
 		os.Exit(1)
 	}
 
-	// Inject channel manager into agent loop for command handling
-	agentLoop.SetChannelManager(channelManager)
+	// Inject channel manager into agent loops for command handling
+	for _, al := range agentLoops {
+		al.SetChannelManager(channelManager)
+	}
 
 	var transcriber *voice.GroqTranscriber
 	if cfg.Providers.Groq.APIKey != "" {
@@ -647,23 +703,41 @@ func gatewayCmd() {
 	}
 	fmt.Println("✓ Heartbeat service started")
 
+	if consolidator != nil {
+		consolidator.Start(ctx)
+	}
+
 	stateManager := state.NewManager(cfg.WorkspacePath())
 	deviceService := devices.NewService(devices.Config{
 		Enabled:    cfg.Devices.Enabled,
 		MonitorUSB: cfg.Devices.MonitorUSB,
 	}, stateManager)
 	deviceService.SetBus(msgBus)
+	deviceService.SetEventHandler(cronTool.HandleDeviceEvent)
 	if err := deviceService.Start(ctx); err != nil {
 		fmt.Printf("Error starting device service: %v\n", err)
 	} else if cfg.Devices.Enabled {
 		fmt.Println("✓ Device event service started")
 	}
 
+	healthServer.Handle("/hooks/", cronService.WebhookHandler())
+	msgBus.OnInbound(func(msg bus.InboundMessage) {
+		// Subagent results are internal, not traffic.
+		if msg.Channel != "system" {
+			healthServer.RecordActivity(time.Now())
+			healthServer.CountMessage(true)
+		}
+	})
+	msgBus.OnOutbound(func(msg bus.OutboundMessage) {
+		if msg.Channel != "system" {
+			healthServer.CountMessage(false)
+		}
+	})
+
 	if err := channelManager.StartAll(ctx); err != nil {
 		fmt.Printf("Error starting channels: %v\n", err)
 	}
 
-	healthServer := health.NewServer(cfg.Gateway.Host, cfg.Gateway.Port)
 	go func() {
 		if err := healthServer.Start(); err != nil && err != http.ErrServerClosed {
 			logger.ErrorCF("health", "Health server error", map[string]interface{}{"error": err.Error()})
@@ -671,7 +745,16 @@ func gatewayCmd() {
 	}()
 	fmt.Printf("✓ Health endpoints available at http://%s:%d/health and /ready\n", cfg.Gateway.Host, cfg.Gateway.Port)
 
-	go agentLoop.Run(ctx)
+	if inboundQueue != nil {
+		go msgBus.RunQueue(ctx)
+		fmt.Println("✓ Durable inbound queue enabled")
+	}
+
+	if router != nil {
+		go router.Run(ctx)
+	} else {
+		go agentLoop.Run(ctx)
+	}
 
 	sigChan := make(chan os.Signal, 1)
 	signal.Notify(sigChan, os.Interrupt)
@@ -683,11 +766,54 @@ func gatewayCmd() {
 	deviceService.Stop()
 	heartbeatService.Stop()
 	cronService.Stop()
-	agentLoop.Stop()
+	for _, al := range agentLoops {
+		al.Stop()
+	}
 	channelManager.StopAll(ctx)
+	if inboundQueue != nil {
+		inboundQueue.Close()
+	}
 	fmt.Println("✓ Gateway stopped")
 }
 
+// setupAgents creates the agents configured under agents.instances next to
+// the default agent and a router that dispatches inbound messages between
+// them. It returns a nil router when only the default agent is configured.
+// Cron jobs and the heartbeat always run on the default agent. wrap is
+// applied to the provider of each additional agent.
+func setupAgents(cfg *config.Config, msgBus *bus.MessageBus, mainLoop *agent.AgentLoop, wrap func(providers.LLMProvider) providers.LLMProvider) (*agent.Router, []*agent.AgentLoop) {
+	loops := []*agent.AgentLoop{mainLoop}
+	if len(cfg.Agents.Instances) == 0 {
+		return nil, loops
+	}
+
+	router := agent.NewRouter(msgBus, cfg.Agents.Routes, filepath.Join(cfg.WorkspacePath(), "state", "agent_routes.json"))
+	router.Add(config.DefaultAgentName, mainLoop)
+
+	names := make([]string, 0, len(cfg.Agents.Instances))
+	for name := range cfg.Agents.Instances {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		agentCfg, err := cfg.ForAgent(name)
+		if err != nil {
+			fmt.Printf("Error configuring agent %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		provider, err := providers.CreateProvider(agentCfg)
+		if err != nil {
+			fmt.Printf("Error creating provider for agent %s: %v\n", name, err)
+			os.Exit(1)
+		}
+		al := agent.NewAgentLoop(agentCfg, msgBus, wrap(provider))
+		router.Add(name, al)
+		loops = append(loops, al)
+	}
+	return router, loops
+}
+
 func statusCmd() {
 	cfg, err := loadConfig()
 	if err != nil {
@@ -987,7 +1113,7 @@ func getConfigPath() string {
 	return filepath.Join(home, ".picoclaw", "config.json")
 }
 
-func setupCronTool(agentLoop *agent.AgentLoop, msgBus *bus.MessageBus, workspace string, restrict bool) *cron.CronService {
+func setupCronTool(agentLoop *agent.AgentLoop, msgBus *bus.MessageBus, workspace string, restrict bool) (*cron.CronService, *tools.CronTool) {
 	cronStorePath := filepath.Join(workspace, "cron", "jobs.json")
 
 	// Create cron service
@@ -998,12 +1124,15 @@ func setupCronTool(agentLoop *agent.AgentLoop, msgBus *bus.MessageBus, workspace
 	agentLoop.RegisterTool(cronTool)
 
 	// Set the onJob handler
-	cronService.SetOnJob(func(job *cron.CronJob) (string, error) {
-		result := cronTool.ExecuteJob(context.Background(), job)
-		return result, nil
+	cronService.SetOnJob(func(ctx context.Context, job *cron.CronJob) (string, error) {
+		return cronTool.ExecuteJob(ctx, job)
 	})
 
-	return cronService
+	// Event triggers: workspace files, keywords and MaixCam detections
+	cronService.SetWorkspace(workspace)
+	msgBus.OnInbound(cronTool.ObserveInbound)
+
+	return cronService, cronTool
 }
 
 func loadConfig() (*config.Config, error) {
@@ -1042,6 +1171,12 @@ func cronCmd() {
 		cronEnableCmd(cronStorePath, false)
 	case "disable":
 		cronEnableCmd(cronStorePath, true)
+	case "history":
+		if len(os.Args) < 4 {
+			fmt.Println("Usage: picoclaw cron history <job_id>")
+			return
+		}
+		cronHistoryCmd(cronStorePath, os.Args[3])
 	default:
 		fmt.Printf("Unknown cron command: %s\n", subcommand)
 		cronHelp()
@@ -1055,6 +1190,7 @@ func cronHelp() {
 	fmt.Println("  remove <id>       Remove a job by ID")
 	fmt.Println("  enable <id>      Enable a job")
 	fmt.Println("  disable <id>     Disable a job")
+	fmt.Println("  history <id>      Show recent runs of a job")
 	fmt.Println()
 	fmt.Println("Add options:")
 	fmt.Println("  -n, --name       Job name")
@@ -1064,6 +1200,12 @@ func cronHelp() {
 	fmt.Println("  -d, --deliver     Deliver response to channel")
 	fmt.Println("  --to             Recipient for delivery")
 	fmt.Println("  --channel        Channel for delivery")
+	fmt.Println("  --output         Result routing: chat, on_change, on_error, file, silent")
+	fmt.Println("  --output-file    Workspace file for --output file")
+	fmt.Println("  --timeout        Abort a run after N seconds (default 600)")
+	fmt.Println("  --retries        Retry a failed run up to N times with backoff")
+	fmt.Println("  --concurrency    If still running when due: skip (default) or queue")
+	fmt.Println("  --missed         Runs missed while offline: skip (default) or run_once")
 }
 
 func cronListCmd(storePath string) {
@@ -1083,6 +1225,14 @@ func cronListCmd(storePath string) {
 			schedule = fmt.Sprintf("every %ds", *job.Schedule.EveryMS/1000)
 		} else if job.Schedule.Kind == "cron" {
 			schedule = job.Schedule.Expr
+		} else if job.Schedule.Kind == "event" && job.Schedule.Trigger != nil {
+			schedule = "on " + job.Schedule.Trigger.Type
+			if job.Schedule.Trigger.Path != "" {
+				schedule += " " + job.Schedule.Trigger.Path
+			}
+			if job.Schedule.Trigger.Match != "" {
+				schedule += fmt.Sprintf(" %q", job.Schedule.Trigger.Match)
+			}
 		} else {
 			schedule = "one-time"
 		}
@@ -1098,10 +1248,29 @@ func cronListCmd(storePath string) {
 			status = "disabled"
 		}
 
+		output := job.Payload.Output
+		if output == "" {
+			output = cron.OutputChat
+		}
+		if output == cron.OutputFile {
+			output += " (" + job.Payload.OutputFile + ")"
+		}
+
 		fmt.Printf("  %s (%s)\n", job.Name, job.ID)
 		fmt.Printf("    Schedule: %s\n", schedule)
 		fmt.Printf("    Status: %s\n", status)
+		fmt.Printf("    Output: %s\n", output)
 		fmt.Printf("    Next run: %s\n", nextRun)
+		if job.State.LastRunAtMS != nil {
+			lastRun := time.UnixMilli(*job.State.LastRunAtMS).Format("2006-01-02 15:04")
+			fmt.Printf("    Last run: %s (%s)\n", lastRun, job.State.LastStatus)
+			if job.State.LastError != "" {
+				fmt.Printf("    Last error: %s\n", utils.Truncate(job.State.LastError, 200))
+			}
+			if job.State.LastOutput != "" {
+				fmt.Printf("    Last output: %s\n", utils.Truncate(strings.ReplaceAll(job.State.LastOutput, "\n", " "), 200))
+			}
+		}
 	}
 }
 
@@ -1113,6 +1282,9 @@ func cronAddCmd(storePath string) {
 	deliver := false
 	channel := ""
 	to := ""
+	output := ""
+	outputFile := ""
+	var policy cron.CronPolicy
 
 	args := os.Args[3:]
 	for i := 0; i < len(args); i++ {
@@ -1151,6 +1323,36 @@ func cronAddCmd(storePath string) {
 				channel = args[i+1]
 				i++
 			}
+		case "--output":
+			if i+1 < len(args) {
+				output = args[i+1]
+				i++
+			}
+		case "--output-file":
+			if i+1 < len(args) {
+				outputFile = args[i+1]
+				i++
+			}
+		case "--timeout":
+			if i+1 < len(args) {
+				fmt.Sscanf(args[i+1], "%d", &policy.TimeoutSec)
+				i++
+			}
+		case "--retries":
+			if i+1 < len(args) {
+				fmt.Sscanf(args[i+1], "%d", &policy.MaxRetries)
+				i++
+			}
+		case "--concurrency":
+			if i+1 < len(args) {
+				policy.Concurrency = args[i+1]
+				i++
+			}
+		case "--missed":
+			if i+1 < len(args) {
+				policy.MissedRun = args[i+1]
+				i++
+			}
 		}
 	}
 
@@ -1169,6 +1371,21 @@ func cronAddCmd(storePath string) {
 		return
 	}
 
+	if !cron.ValidOutputMode(output) {
+		fmt.Printf("Error: unknown --output %q\n", output)
+		return
+	}
+
+	if output == cron.OutputFile && outputFile == "" {
+		fmt.Println("Error: --output-file is required with --output file")
+		return
+	}
+
+	if err := policy.Validate(); err != nil {
+		fmt.Printf("Error: %v\n", err)
+		return
+	}
+
 	var schedule cron.CronSchedule
 	if everySec != nil {
 		everyMS := *everySec * 1000
@@ -1190,6 +1407,16 @@ func cronAddCmd(storePath string) {
 		return
 	}
 
+	if output != "" || policy != (cron.CronPolicy{}) {
+		job.Payload.Output = output
+		job.Payload.OutputFile = outputFile
+		job.Policy = policy
+		if err := cs.UpdateJob(job); err != nil {
+			fmt.Printf("Error saving job output settings: %v\n", err)
+			return
+		}
+	}
+
 	fmt.Printf("✓ Added job '%s' (%s)\n", job.Name, job.ID)
 }
 
@@ -1202,6 +1429,36 @@ func cronRemoveCmd(storePath, jobID string) {
 	}
 }
 
+func cronHistoryCmd(storePath, jobID string) {
+	cs := cron.NewCronService(storePath, nil)
+	runs, err := cs.History(jobID, 0)
+	if err != nil {
+		fmt.Printf("Error reading history: %v\n", err)
+		return
+	}
+	if len(runs) == 0 {
+		fmt.Printf("No runs recorded for job %s.\n", jobID)
+		return
+	}
+
+	fmt.Printf("\nRun history for %s (newest first):\n", jobID)
+	fmt.Println("----------------")
+	for _, run := range runs {
+		started := time.UnixMilli(run.StartedAtMS).Format("2006-01-02 15:04:05")
+		fmt.Printf("  %s  %-8s %6dms", started, run.Status, run.DurationMS)
+		if run.Attempt > 1 {
+			fmt.Printf("  attempt %d", run.Attempt)
+		}
+		fmt.Println()
+		if run.Error != "" {
+			fmt.Printf("    Error: %s\n", utils.Truncate(run.Error, 200))
+		}
+		if run.Output != "" {
+			fmt.Printf("    Output: %s\n", utils.Truncate(strings.ReplaceAll(run.Output, "\n", " "), 200))
+		}
+	}
+}
+
 func cronEnableCmd(storePath string, disable bool) {
 	if len(os.Args) < 4 {
 		fmt.Println("Usage: picoclaw cron enable/disable <job_id>")
@@ -1224,10 +1481,231 @@ func cronEnableCmd(storePath string, disable bool) {
 	}
 }
 
+func queueDir(cfg *config.Config) string {
+	return filepath.Join(cfg.WorkspacePath(), "queue")
+}
+
+func queueCmd() {
+	if len(os.Args) < 3 {
+		queueHelp()
+		return
+	}
+
+	subcommand := os.Args[2]
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		return
+	}
+
+	dir := queueDir(cfg)
+
+	switch subcommand {
+	case "list":
+		queueListCmd(dir)
+	case "dead":
+		queueDeadCmd(dir)
+	case "show":
+		if len(os.Args) < 4 {
+			fmt.Println("Usage: picoclaw queue show <message_id>")
+			return
+		}
+		queueShowCmd(dir, os.Args[3])
+	case "replay":
+		if len(os.Args) < 4 {
+			fmt.Println("Usage: picoclaw queue replay <message_id|--all>")
+			return
+		}
+		queueReplayCmd(dir, os.Args[3])
+	case "drop":
+		if len(os.Args) < 4 {
+			fmt.Println("Usage: picoclaw queue drop <message_id>")
+			return
+		}
+		if err := bus.RemoveDeadLetter(dir, os.Args[3]); err != nil {
+			fmt.Printf("✗ %v\n", err)
+			return
+		}
+		fmt.Printf("✓ Dropped message %s\n", os.Args[3])
+	default:
+		fmt.Printf("Unknown queue command: %s\n", subcommand)
+		queueHelp()
+	}
+}
+
+func memoryCmd() {
+	if len(os.Args) < 3 {
+		memoryHelp()
+		return
+	}
+
+	cfg, err := loadConfig()
+	if err != nil {
+		fmt.Printf("Error loading config: %v\n", err)
+		return
+	}
+	workspace := cfg.WorkspacePath()
+
+	switch os.Args[2] {
+	case "consolidate":
+		provider, err := providers.CreateProvider(cfg)
+		if err != nil {
+			fmt.Printf("Error creating provider: %v\n", err)
+			return
+		}
+		consolidator := memory.NewConsolidator(workspace, provider, cfg.Agents.Defaults.Model)
+		consolidator.SetAfterDays(cfg.Memory.ConsolidateAfterDays)
+		consolidator.SetIncludeSessions(!cfg.Memory.PerUser)
+		consolidator.SetIndex(memory.NewIndex(workspace))
+
+		report, err := consolidator.Run(context.Background())
+		if err != nil {
+			fmt.Printf("✗ Consolidation failed: %v\n", err)
+			return
+		}
+		if len(report.Notes) == 0 && len(report.Sessions) == 0 {
+			fmt.Println("Nothing to consolidate.")
+			return
+		}
+		fmt.Printf("✓ Consolidated %d daily notes and %d session summaries\n", len(report.Notes), len(report.Sessions))
+		if report.DiffFile == "" {
+			fmt.Println("MEMORY.md unchanged.")
+			return
+		}
+		fmt.Printf("Changes to MEMORY.md (saved in %s):\n\n%s", filepath.Join(workspace, report.DiffFile), report.Diff)
+	case "history":
+		diffs, _ := filepath.Glob(filepath.Join(workspace, memory.DiffDir, "*.diff"))
+		if len(diffs) == 0 {
+			fmt.Println("No consolidation runs yet.")
+			return
+		}
+		sort.Sort(sort.Reverse(sort.StringSlice(diffs)))
+		for _, diff := range diffs {
+			fmt.Println(diff)
+		}
+	default:
+		fmt.Printf("Unknown memory command: %s\n", os.Args[2])
+		memoryHelp()
+	}
+}
+
+func memoryHelp() {
+	fmt.Println("\nMemory commands:")
+	fmt.Println("  consolidate       Distill old daily notes and session summaries into MEMORY.md now")
+	fmt.Println("  history           List the diffs of past consolidations, newest first")
+	fmt.Println()
+	fmt.Println("The gateway consolidates once a day when memory.consolidate is enabled.")
+}
+
+func queueHelp() {
+	fmt.Println("\nQueue commands:")
+	fmt.Println("  list              List messages waiting to be processed")
+	fmt.Println("  dead              List dead-lettered messages")
+	fmt.Println("  show <id>         Show a pending or dead-lettered message")
+	fmt.Println("  replay <id>       Redeliver a dead-lettered message (--all for every one)")
+	fmt.Println("  drop <id>         Delete a dead-lettered message")
+	fmt.Println()
+	fmt.Println("Enable the queue with gateway.queue.enabled in config.json.")
+}
+
+func queueListCmd(dir string) {
+	pending, err := bus.ReadPending(dir)
+	if err != nil {
+		fmt.Printf("Error reading queue: %v\n", err)
+		return
+	}
+	dead, _ := bus.ListDeadLetters(dir)
+
+	if len(pending) == 0 {
+		fmt.Println("No pending messages.")
+	} else {
+		fmt.Println("\nPending Messages:")
+		fmt.Println("-----------------")
+		for _, entry := range pending {
+			printQueueEntry(entry)
+		}
+	}
+	if len(dead) > 0 {
+		fmt.Printf("\n%d dead-lettered message(s). Run 'picoclaw queue dead' to inspect.\n", len(dead))
+	}
+}
+
+func queueDeadCmd(dir string) {
+	dead, err := bus.ListDeadLetters(dir)
+	if err != nil {
+		fmt.Printf("Error reading dead letters: %v\n", err)
+		return
+	}
+	if len(dead) == 0 {
+		fmt.Println("No dead-lettered messages.")
+		return
+	}
+
+	fmt.Println("\nDead Letters:")
+	fmt.Println("-------------")
+	for _, entry := range dead {
+		printQueueEntry(entry)
+	}
+}
+
+func queueShowCmd(dir, id string) {
+	pending, _ := bus.ReadPending(dir)
+	dead, _ := bus.ListDeadLetters(dir)
+	for _, entry := range append(pending, dead...) {
+		if entry.ID == id {
+			data, _ := json.MarshalIndent(entry, "", "  ")
+			fmt.Println(string(data))
+			return
+		}
+	}
+	fmt.Printf("✗ Message %s not found\n", id)
+}
+
+func queueReplayCmd(dir, id string) {
+	ids := []string{id}
+	if id == "--all" {
+		dead, err := bus.ListDeadLetters(dir)
+		if err != nil {
+			fmt.Printf("Error reading dead letters: %v\n", err)
+			return
+		}
+		ids = ids[:0]
+		for _, entry := range dead {
+			ids = append(ids, entry.ID)
+		}
+	}
+
+	for _, id := range ids {
+		if err := bus.ReplayDeadLetter(dir, id); err != nil {
+			fmt.Printf("✗ %v\n", err)
+			continue
+		}
+		fmt.Printf("✓ Queued %s for replay\n", id)
+	}
+}
+
+func printQueueEntry(entry bus.QueueEntry) {
+	content := entry.Message.Content
+	if len([]rune(content)) > 60 {
+		content = string([]rune(content)[:60]) + "..."
+	}
+	fmt.Printf("  %s  %s:%s\n", entry.ID, entry.Message.Channel, entry.Message.ChatID)
+	fmt.Printf("    Received: %s\n", time.UnixMilli(entry.EnqueuedAtMS).Format("2006-01-02 15:04:05"))
+	fmt.Printf("    Attempts: %d\n", entry.Attempts)
+	if entry.LastError != "" {
+		fmt.Printf("    Last error: %s\n", entry.LastError)
+	}
+	fmt.Printf("    Content: %s\n", content)
+}
+
 func skillsHelp() {
 	fmt.Println("\nSkills commands:")
 	fmt.Println("  list                    List installed skills")
-	fmt.Println("  install <repo>          Install skill from GitHub")
+	fmt.Println("  install <source>        Install skill from GitHub, a git URL, a tarball or a local path")
+	fmt.Println("  update [name]           Update installed skills from their source")
+	fmt.Println("  outdated                List skills with newer versions")
+	fmt.Println("  verify                  Check installed skills against skills.lock")
 	fmt.Println("  install-builtin          Install all builtin skills to workspace")
 	fmt.Println("  list-builtin             List available builtin skills")
 	fmt.Println("  remove <name>           Remove installed skill")
@@ -1237,6 +1715,10 @@ func skillsHelp() {
 	fmt.Println("Examples:")
 	fmt.Println("  picoclaw skills list")
 	fmt.Println("  picoclaw skills install sipeed/picoclaw-skills/weather")
+	fmt.Println("  picoclaw skills install sipeed/picoclaw-skills/weather@v1.2.0")
+	fmt.Println("  picoclaw skills install https://example.com/weather.tar.gz")
+	fmt.Println("  picoclaw skills install ./my-skill")
+	fmt.Println("  picoclaw skills update")
 	fmt.Println("  picoclaw skills install-builtin")
 	fmt.Println("  picoclaw skills list-builtin")
 	fmt.Println("  picoclaw skills remove weather")
@@ -1257,28 +1739,124 @@ func skillsListCmd(loader *skills.SkillsLoader) {
 		if skill.Description != "" {
 			fmt.Printf("    %s\n", skill.Description)
 		}
+		manifest, err := skills.LoadManifest(filepath.Dir(skill.Path))
+		if err != nil {
+			fmt.Printf("    ✗ invalid %s: %v\n", skills.ManifestFile, err)
+		} else if manifest != nil {
+			names := make([]string, 0, len(manifest.Tools))
+			for _, tool := range manifest.Tools {
+				names = append(names, tool.Name)
+			}
+			fmt.Printf("    Tools: %s\n", strings.Join(names, ", "))
+		}
 	}
 }
 
 func skillsInstallCmd(installer *skills.SkillInstaller) {
 	if len(os.Args) < 4 {
-		fmt.Println("Usage: picoclaw skills install <github-repo>")
-		fmt.Println("Example: picoclaw skills install sipeed/picoclaw-skills/weather")
+		fmt.Println("Usage: picoclaw skills install <source>")
+		fmt.Println("Sources:")
+		fmt.Println("  owner/repo[/path][@tag]                GitHub repository")
+		fmt.Println("  https://host/repo.git[//path][@tag]    Git repository")
+		fmt.Println("  https://host/skill.tar.gz[//path]      Tarball")
+		fmt.Println("  ./path/to/skill                        Local directory")
+		fmt.Println("Example: picoclaw skills install sipeed/picoclaw-skills/weather@v1.0.0")
 		return
 	}
 
-	repo := os.Args[3]
-	fmt.Printf("Installing skill from %s...\n", repo)
+	source := os.Args[3]
+	fmt.Printf("Installing skill from %s...\n", source)
 
-	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
 	defer cancel()
 
-	if err := installer.InstallFromGitHub(ctx, repo); err != nil {
+	name, err := installer.Install(ctx, source)
+	if err != nil {
 		fmt.Printf("✗ Failed to install skill: %v\n", err)
 		os.Exit(1)
 	}
 
-	fmt.Printf("✓ Skill '%s' installed successfully!\n", filepath.Base(repo))
+	fmt.Printf("✓ Skill '%s' installed successfully!\n", name)
+}
+
+func skillsUpdateCmd(installer *skills.SkillInstaller, names []string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Minute)
+	defer cancel()
+
+	if len(names) == 0 {
+		lock, err := skills.LoadLock(installer.Workspace())
+		if err != nil {
+			fmt.Printf("✗ %v\n", err)
+			os.Exit(1)
+		}
+		for name := range lock.Skills {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) == 0 {
+			fmt.Println("No skills installed from a source.")
+			return
+		}
+	}
+
+	failed := false
+	for _, name := range names {
+		update, err := installer.Update(ctx, name)
+		switch {
+		case err != nil:
+			fmt.Printf("✗ %s: %v\n", name, err)
+			failed = true
+		case update == nil:
+			fmt.Printf("  %s is up to date\n", name)
+		default:
+			fmt.Printf("✓ %s updated: %s → %s\n", name, update.Current, update.Latest)
+		}
+	}
+	if failed {
+		os.Exit(1)
+	}
+}
+
+func skillsOutdatedCmd(installer *skills.SkillInstaller) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	updates, err := installer.Outdated(ctx)
+	for _, u := range updates {
+		fmt.Printf("  %s: %s → %s\n", u.Name, u.Current, u.Latest)
+	}
+	if err != nil {
+		fmt.Printf("✗ Some skills could not be checked:\n%v\n", err)
+		os.Exit(1)
+	}
+	if len(updates) == 0 {
+		fmt.Println("All skills are up to date.")
+	} else {
+		fmt.Println("\nRun 'picoclaw skills update' to update them.")
+	}
+}
+
+func skillsVerifyCmd(installer *skills.SkillInstaller) {
+	problems, err := installer.Verify()
+	if err != nil {
+		fmt.Printf("✗ %v\n", err)
+		os.Exit(1)
+	}
+	if len(problems) == 0 {
+		fmt.Printf("✓ All installed skills match %s\n", skills.LockFile)
+		return
+	}
+
+	names := make([]string, 0, len(problems))
+	for name := range problems {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("✗ %s: %v\n", name, problems[name])
+	}
+	fmt.Println("\nThese skills are not loaded. Reinstall them with 'picoclaw skills update <name>'.")
+	os.Exit(1)
 }
 
 func skillsRemoveCmd(installer *skills.SkillInstaller, skillName string) {
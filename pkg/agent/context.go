@@ -1,6 +1,7 @@
 package agent
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
@@ -9,6 +10,8 @@ import (
 	"time"
 
 	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/memory"
+	"github.com/sipeed/picoclaw/pkg/permissions"
 	"github.com/sipeed/picoclaw/pkg/providers"
 	"github.com/sipeed/picoclaw/pkg/skills"
 	"github.com/sipeed/picoclaw/pkg/tools"
@@ -29,32 +32,43 @@ func getGlobalConfigDir() string {
 	return filepath.Join(home, ".picoclaw")
 }
 
-func NewContextBuilder(workspace string) *ContextBuilder {
+// newSkillsLoader returns the loader for the workspace, global and builtin
+// skills available to an agent in workspace.
+func newSkillsLoader(workspace string) *skills.SkillsLoader {
 	// builtin skills: skills directory in current project
 	// Use the skills/ directory under the current working directory
 	wd, _ := os.Getwd()
 	builtinSkillsDir := filepath.Join(wd, "skills")
 	globalSkillsDir := filepath.Join(getGlobalConfigDir(), "skills")
 
+	return skills.NewSkillsLoader(workspace, globalSkillsDir, builtinSkillsDir)
+}
+
+func NewContextBuilder(workspace string) *ContextBuilder {
 	return &ContextBuilder{
 		workspace:    workspace,
-		skillsLoader: skills.NewSkillsLoader(workspace, globalSkillsDir, builtinSkillsDir),
+		skillsLoader: newSkillsLoader(workspace),
 		memory:       NewMemoryStore(workspace),
 	}
 }
 
+// Memory returns the memory store the prompt draws from.
+func (cb *ContextBuilder) Memory() *MemoryStore {
+	return cb.memory
+}
+
 // SetToolsRegistry sets the tools registry for dynamic tool summary generation.
 func (cb *ContextBuilder) SetToolsRegistry(registry *tools.ToolRegistry) {
 	cb.tools = registry
 }
 
-func (cb *ContextBuilder) getIdentity() string {
+func (cb *ContextBuilder) getIdentity(role *permissions.Role) string {
 	now := time.Now().Format("2006-01-02 15:04 (Monday)")
 	workspacePath, _ := filepath.Abs(filepath.Join(cb.workspace))
 	runtime := fmt.Sprintf("%s %s, Go %s", runtime.GOOS, runtime.GOARCH, runtime.Version())
 
 	// Build tools section dynamically
-	toolsSection := cb.buildToolsSection()
+	toolsSection := cb.buildToolsSection(role)
 
 	return fmt.Sprintf(`# picoclaw 🦞
 
@@ -80,16 +94,16 @@ Your workspace is at: %s
 
 2. **Be helpful and accurate** - When using tools, briefly explain what you're doing.
 
-3. **Memory** - When remembering something, write to %s/memory/MEMORY.md`,
+3. **Memory** - When something is worth remembering, use the remember tool (it writes to %s/memory/MEMORY.md). Use recall to search older memories and past conversations, and forget to delete a memory.`,
 		now, runtime, workspacePath, workspacePath, workspacePath, workspacePath, toolsSection, workspacePath)
 }
 
-func (cb *ContextBuilder) buildToolsSection() string {
+func (cb *ContextBuilder) buildToolsSection(role *permissions.Role) string {
 	if cb.tools == nil {
 		return ""
 	}
 
-	summaries := cb.tools.GetSummaries()
+	summaries := cb.tools.GetSummariesForRole(role)
 	if len(summaries) == 0 {
 		return ""
 	}
@@ -107,29 +121,52 @@ func (cb *ContextBuilder) buildToolsSection() string {
 }
 
 func (cb *ContextBuilder) BuildSystemPrompt() string {
+	return cb.BuildSystemPromptForRole(nil)
+}
+
+// BuildSystemPromptForRole builds the system prompt listing only the tools
+// and skills the role may use. A nil role lists everything.
+func (cb *ContextBuilder) BuildSystemPromptForRole(role *permissions.Role) string {
+	return cb.BuildSystemPromptFor(context.Background(), role, "", memory.Scope{})
+}
+
+// BuildSystemPromptFor builds the system prompt for role and adds the
+// memory snippets most relevant to query. With a memory scope, the
+// workspace USER.md is replaced by the profile of the scope's user, and only
+// memory visible in the scope is used. ctx bounds the memory search.
+func (cb *ContextBuilder) BuildSystemPromptFor(ctx context.Context, role *permissions.Role, query string, scope memory.Scope) string {
 	parts := []string{}
 
 	// Core identity section
-	parts = append(parts, cb.getIdentity())
+	parts = append(parts, cb.getIdentity(role))
 
 	// Bootstrap files
-	bootstrapContent := cb.LoadBootstrapFiles()
+	bootstrapContent := cb.loadBootstrapFiles(!scope.IsZero())
 	if bootstrapContent != "" {
 		parts = append(parts, bootstrapContent)
 	}
 
+	// Current user's profile
+	if !scope.IsZero() {
+		parts = append(parts, cb.buildUserSection(scope))
+	}
+
 	// Skills - show summary, AI can read full content with read_file tool
-	skillsSummary := cb.skillsLoader.BuildSkillsSummary()
+	var allowSkill func(string) bool
+	if role != nil {
+		allowSkill = role.AllowsSkill
+	}
+	skillsSummary := cb.skillsLoader.BuildSkillsSummaryFiltered(allowSkill)
 	if skillsSummary != "" {
 		parts = append(parts, fmt.Sprintf(`# Skills
 
-The following skills extend your capabilities. To use a skill, read its SKILL.md file using the read_file tool.
+The following skills extend your capabilities. To use a skill, read its SKILL.md file using the read_file tool. Tools listed under a skill can be called directly.
 
 %s`, skillsSummary))
 	}
 
 	// Memory context
-	memoryContext := cb.memory.GetMemoryContext()
+	memoryContext := cb.memory.GetMemoryContext(ctx, query, scope)
 	if memoryContext != "" {
 		parts = append(parts, "# Memory\n\n"+memoryContext)
 	}
@@ -138,7 +175,34 @@ The following skills extend your capabilities. To use a skill, read its SKILL.md
 	return strings.Join(parts, "\n\n---\n\n")
 }
 
+// buildUserSection describes the current sender and their profile.
+func (cb *ContextBuilder) buildUserSection(scope memory.Scope) string {
+	var sb strings.Builder
+	sb.WriteString("# Current User\n\n")
+	if scope.User == "" {
+		sb.WriteString("The sender of this message is unknown. Do not share what you know about other users.")
+		return sb.String()
+	}
+
+	workspacePath, _ := filepath.Abs(cb.workspace)
+	fmt.Fprintf(&sb, "You are talking to %s. Their profile is %s/%s/USER.md; update it with the file tools when you learn lasting preferences. The remember tool saves facts to their own memory. Never reveal one user's profile or memory to another.",
+		scope.User, workspacePath, scope.UserDir())
+	if scope.Chat != "" {
+		sb.WriteString(" This is a group chat: use remember with shared=true for facts that concern the whole group.")
+	}
+	if profile := cb.memory.ReadUserProfile(scope); profile != "" {
+		sb.WriteString("\n\n## USER.md\n\n" + profile)
+	}
+	return sb.String()
+}
+
 func (cb *ContextBuilder) LoadBootstrapFiles() string {
+	return cb.loadBootstrapFiles(false)
+}
+
+// loadBootstrapFiles reads the bootstrap files. perUser leaves out the
+// workspace USER.md, which describes a single user.
+func (cb *ContextBuilder) loadBootstrapFiles(perUser bool) string {
 	bootstrapFiles := []string{
 		"AGENTS.md",
 		"SOUL.md",
@@ -148,6 +212,9 @@ func (cb *ContextBuilder) LoadBootstrapFiles() string {
 
 	var result string
 	for _, filename := range bootstrapFiles {
+		if perUser && filename == "USER.md" {
+			continue
+		}
 		filePath := filepath.Join(cb.workspace, filename)
 		if data, err := os.ReadFile(filePath); err == nil {
 			result += fmt.Sprintf("## %s\n\n%s\n\n", filename, string(data))
@@ -157,10 +224,10 @@ func (cb *ContextBuilder) LoadBootstrapFiles() string {
 	return result
 }
 
-func (cb *ContextBuilder) BuildMessages(history []providers.Message, summary string, currentMessage string, media []string, channel, chatID string) []providers.Message {
+func (cb *ContextBuilder) BuildMessages(ctx context.Context, history []providers.Message, summary string, currentMessage string, media []string, channel, chatID string, role *permissions.Role, scope memory.Scope) []providers.Message {
 	messages := []providers.Message{}
 
-	systemPrompt := cb.BuildSystemPrompt()
+	systemPrompt := cb.BuildSystemPromptFor(ctx, role, currentMessage, scope)
 
 	// Add Current Session info if provided
 	if channel != "" && chatID != "" {
@@ -0,0 +1,52 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/memory"
+)
+
+func TestBuildMessages_AddsRelevantMemories(t *testing.T) {
+	workspace := t.TempDir()
+	memoryDir := filepath.Join(workspace, "memory")
+	os.MkdirAll(memoryDir, 0755)
+	os.WriteFile(filepath.Join(memoryDir, "MEMORY.md"), []byte("# Long-term Memory\n\n- The user is allergic to peanuts\n- The user's favourite colour is teal\n"), 0644)
+
+	cb := NewContextBuilder(workspace)
+	messages := cb.BuildMessages(context.Background(), nil, "", "Can you suggest a snack without peanuts?", nil, "", "", nil, memory.Scope{})
+	system := messages[0].Content
+
+	if !strings.Contains(system, "allergic to peanuts") {
+		t.Errorf("system prompt is missing the relevant memory:\n%s", system)
+	}
+	if strings.Contains(system, "favourite colour") {
+		t.Errorf("system prompt contains an unrelated memory:\n%s", system)
+	}
+	if strings.Count(system, "# Memory") != 1 {
+		t.Errorf("memory section header should appear once")
+	}
+
+	if prompt := cb.BuildSystemPrompt(); strings.Contains(prompt, "peanuts") {
+		t.Errorf("prompt without a query should not include memories")
+	}
+}
+
+func TestBuildMessages_MemorySearchFollowsContext(t *testing.T) {
+	workspace := t.TempDir()
+	memoryDir := filepath.Join(workspace, "memory")
+	os.MkdirAll(memoryDir, 0755)
+	os.WriteFile(filepath.Join(memoryDir, "MEMORY.md"), []byte("# Long-term Memory\n\n- The user is allergic to peanuts\n"), 0644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	cb := NewContextBuilder(workspace)
+	messages := cb.BuildMessages(ctx, nil, "", "Can you suggest a snack without peanuts?", nil, "", "", nil, memory.Scope{})
+	if strings.Contains(messages[0].Content, "allergic to peanuts") {
+		t.Error("expected no memory search once the request is cancelled")
+	}
+}
@@ -23,39 +23,53 @@ import (
 	"github.com/sipeed/picoclaw/pkg/config"
 	"github.com/sipeed/picoclaw/pkg/constants"
 	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/memory"
+	"github.com/sipeed/picoclaw/pkg/permissions"
 	"github.com/sipeed/picoclaw/pkg/providers"
 	"github.com/sipeed/picoclaw/pkg/session"
+	"github.com/sipeed/picoclaw/pkg/skills"
 	"github.com/sipeed/picoclaw/pkg/state"
 	"github.com/sipeed/picoclaw/pkg/tools"
 	"github.com/sipeed/picoclaw/pkg/utils"
 )
 
 type AgentLoop struct {
-	bus            *bus.MessageBus
-	provider       providers.LLMProvider
-	workspace      string
-	model          string
-	contextWindow  int // Maximum context window size in tokens
-	maxIterations  int
-	sessions       *session.SessionManager
-	state          *state.Manager
-	contextBuilder *ContextBuilder
-	tools          *tools.ToolRegistry
-	running        atomic.Bool
-	summarizing    sync.Map // Tracks which sessions are currently being summarized
-	channelManager *channels.Manager
+	bus              *bus.MessageBus
+	provider         providers.LLMProvider
+	workspace        string
+	model            string
+	contextWindow    int // Maximum context window size in tokens
+	maxIterations    int
+	maxParallelTools int // cap on concurrency-safe tool calls run at once
+	sessions         *session.SessionManager
+	state            *state.Manager
+	contextBuilder   *ContextBuilder
+	tools            *tools.ToolRegistry
+	running          atomic.Bool
+	summarizing      sync.Map // Tracks which sessions are currently being summarized
+	channelManager   *channels.Manager
+	permissions      *permissions.Policy // nil when role-based permissions are disabled
+	subagents        *tools.SubagentManager
+	profiles         map[string]*agentProfile // named agent profiles from config
+	bindings         []config.AgentBinding
+	name             string             // agent name when hosted by a Router
+	router           *Router            // nil when the gateway runs a single agent
+	identities       *memory.Identities // nil unless memory is kept per user
 }
 
 // processOptions configures how a message is processed
 type processOptions struct {
-	SessionKey      string // Session identifier for history/context
-	Channel         string // Target channel for tool execution
-	ChatID          string // Target chat ID for tool execution
-	UserMessage     string // User message content (may include prefix)
-	DefaultResponse string // Response when LLM returns empty
-	EnableSummary   bool   // Whether to trigger summarization
-	SendResponse    bool   // Whether to send response via bus
-	NoHistory       bool   // If true, don't load session history (for heartbeat)
+	SessionKey      string            // Session identifier for history/context
+	Channel         string            // Target channel for tool execution
+	ChatID          string            // Target chat ID for tool execution
+	UserMessage     string            // User message content (may include prefix)
+	DefaultResponse string            // Response when LLM returns empty
+	EnableSummary   bool              // Whether to trigger summarization
+	SendResponse    bool              // Whether to send response via bus
+	NoHistory       bool              // If true, don't load session history (for heartbeat)
+	Role            *permissions.Role // Sender's role; nil means unrestricted
+	Profile         *agentProfile     // Agent profile bound to the chat; nil for the default agent
+	Scope           memory.Scope      // Memory visible to the conversation; zero means all
 }
 
 // createToolRegistry creates a tool registry with common tools.
@@ -63,15 +77,10 @@ type processOptions struct {
 func createToolRegistry(workspace string, restrict bool, cfg *config.Config, msgBus *bus.MessageBus) *tools.ToolRegistry {
 	registry := tools.NewToolRegistry()
 
-	// File system tools
-	registry.Register(tools.NewReadFileTool(workspace, restrict))
-	registry.Register(tools.NewWriteFileTool(workspace, restrict))
-	registry.Register(tools.NewListDirTool(workspace, restrict))
-	registry.Register(tools.NewEditFileTool(workspace, restrict))
-	registry.Register(tools.NewAppendFileTool(workspace, restrict))
-
-	// Shell execution
-	registry.Register(tools.NewExecTool(workspace, restrict))
+	// File system tools and shell execution
+	for _, tool := range workspaceTools(workspace, restrict) {
+		registry.Register(tool)
+	}
 
 	if searchTool := tools.NewWebSearchTool(tools.WebSearchToolOptions{
 		BraveAPIKey:          cfg.Tools.Web.Brave.APIKey,
@@ -104,6 +113,34 @@ func createToolRegistry(workspace string, restrict bool, cfg *config.Config, msg
 	return registry
 }
 
+// workspaceTools returns the tools that work on the files in workspace.
+func workspaceTools(workspace string, restrict bool) []tools.Tool {
+	return []tools.Tool{
+		tools.NewReadFileTool(workspace, restrict),
+		tools.NewWriteFileTool(workspace, restrict),
+		tools.NewListDirTool(workspace, restrict),
+		tools.NewEditFileTool(workspace, restrict),
+		tools.NewAppendFileTool(workspace, restrict),
+		tools.NewExecTool(workspace, restrict),
+	}
+}
+
+// registerSkillTools registers the tools declared by installed skills that
+// role may use. Skill tools never replace tools already in the registry.
+func registerSkillTools(registry *tools.ToolRegistry, specs []skills.ToolSpec, role *permissions.Role, workspace string, restrict bool) {
+	for _, spec := range specs {
+		if !role.AllowsSkill(spec.Skill) {
+			continue
+		}
+		if _, exists := registry.Get(spec.Name); exists {
+			logger.WarnCF("agent", "Skill tool conflicts with an existing tool, skipped",
+				map[string]interface{}{"skill": spec.Skill, "tool": spec.Name})
+			continue
+		}
+		registry.Register(tools.NewSkillTool(spec, workspace, restrict))
+	}
+}
+
 func NewAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers.LLMProvider) *AgentLoop {
 	workspace := cfg.WorkspacePath()
 	os.MkdirAll(workspace, 0755)
@@ -118,6 +155,11 @@ func NewAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers
 	subagentTools := createToolRegistry(workspace, restrict, cfg, msgBus)
 	// Subagent doesn't need spawn/subagent tools to avoid recursion
 	subagentManager.SetTools(subagentTools)
+	subagentManager.SetMaxConcurrent(cfg.Agents.Defaults.MaxSubagents)
+	subagentManager.SetMaxParallelTools(cfg.Agents.Defaults.MaxParallelTools)
+	if err := subagentManager.SetStorage(filepath.Join(workspace, "subagents")); err != nil {
+		logger.WarnCF("agent", "Subagent tasks will not be persisted", map[string]interface{}{"error": err.Error()})
+	}
 
 	// Register spawn tool (for main agent)
 	spawnTool := tools.NewSpawnTool(subagentManager)
@@ -127,6 +169,13 @@ func NewAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers
 	subagentTool := tools.NewSubagentTool(subagentManager)
 	toolsRegistry.Register(subagentTool)
 
+	// Register subagents tool (list, inspect and cancel spawned tasks)
+	toolsRegistry.Register(tools.NewSubagentsTool(subagentManager))
+
+	// Named agent profiles, selectable by spawn/subagent and chat bindings
+	profiles := loadProfiles(cfg, workspace)
+	registerSubagentProfiles(subagentManager, profiles, cfg, workspace, msgBus)
+
 	sessionsManager := session.NewSessionManager(filepath.Join(workspace, "sessions"))
 
 	// Create state manager for atomic state persistence
@@ -136,18 +185,53 @@ func NewAgentLoop(cfg *config.Config, msgBus *bus.MessageBus, provider providers
 	contextBuilder := NewContextBuilder(workspace)
 	contextBuilder.SetToolsRegistry(toolsRegistry)
 
+	// Searchable memory: the remember/recall/forget tools and the snippets
+	// added to each prompt share one index
+	memoryStore := contextBuilder.Memory()
+	memoryStore.SetTopK(cfg.Memory.TopK)
+	if cfg.Memory.Embeddings {
+		if embedder, ok := provider.(providers.EmbeddingProvider); ok {
+			memoryStore.Index().SetEmbedder(memory.NewProviderEmbedder(embedder, cfg.Memory.EmbeddingModel), cfg.Memory.EmbeddingModel)
+		} else {
+			logger.WarnCF("agent", "Provider does not support embeddings, memory search is lexical only", nil)
+		}
+	}
+	toolsRegistry.Register(tools.NewRememberTool(memoryStore.Index()))
+	toolsRegistry.Register(tools.NewRecallTool(memoryStore.Index()))
+	toolsRegistry.Register(tools.NewForgetTool(memoryStore.Index()))
+
+	// Tools declared by skills come last so they cannot shadow built-ins
+	skillTools := contextBuilder.skillsLoader.ListTools()
+	registerSkillTools(toolsRegistry, skillTools, nil, workspace, restrict)
+	registerSkillTools(subagentTools, skillTools, nil, workspace, restrict)
+	for _, profile := range profiles {
+		profile.setWorkspaceTools(restrict, skillTools)
+	}
+
+	var identities *memory.Identities
+	if cfg.Memory.PerUser {
+		identities = memory.NewIdentities(workspace, cfg.Memory.Identities)
+	}
+
 	return &AgentLoop{
-		bus:            msgBus,
-		provider:       provider,
-		workspace:      workspace,
-		model:          cfg.Agents.Defaults.Model,
-		contextWindow:  cfg.Agents.Defaults.MaxTokens, // Restore context window for summarization
-		maxIterations:  cfg.Agents.Defaults.MaxToolIterations,
-		sessions:       sessionsManager,
-		state:          stateManager,
-		contextBuilder: contextBuilder,
-		tools:          toolsRegistry,
-		summarizing:    sync.Map{},
+		bus:              msgBus,
+		provider:         provider,
+		workspace:        workspace,
+		model:            cfg.Agents.Defaults.Model,
+		contextWindow:    cfg.Agents.Defaults.MaxTokens, // Restore context window for summarization
+		maxIterations:    cfg.Agents.Defaults.MaxToolIterations,
+		maxParallelTools: cfg.Agents.Defaults.MaxParallelTools,
+		sessions:         sessionsManager,
+		state:            stateManager,
+		contextBuilder:   contextBuilder,
+		tools:            toolsRegistry,
+		summarizing:      sync.Map{},
+		permissions:      permissions.NewPolicy(cfg.Permissions),
+		subagents:        subagentManager,
+		profiles:         profiles,
+		bindings:         cfg.Agents.Bindings,
+		name:             config.DefaultAgentName,
+		identities:       identities,
 	}
 }
 
@@ -164,39 +248,55 @@ func (al *AgentLoop) Run(ctx context.Context) error {
 				continue
 			}
 
-			response, err := al.processMessage(ctx, msg)
-			if err != nil {
-				response = fmt.Sprintf("Error processing message: %v", err)
-			}
+			al.handleInbound(ctx, msg)
+		}
+	}
 
-			if response != "" {
-				// Check if the message tool already sent a response during this round.
-				// If so, skip publishing to avoid duplicate messages to the user.
-				alreadySent := false
-				if tool, ok := al.tools.Get("message"); ok {
-					if mt, ok := tool.(*tools.MessageTool); ok {
-						alreadySent = mt.HasSentInRound()
-					}
-				}
+	return nil
+}
 
-				if !alreadySent {
-					al.bus.PublishOutbound(bus.OutboundMessage{
-						Channel: msg.Channel,
-						ChatID:  msg.ChatID,
-						Content: response,
-					})
-				}
-			}
+// handleInbound processes one inbound message, publishes the reply and
+// acknowledges the message on the durable queue.
+func (al *AgentLoop) handleInbound(ctx context.Context, msg bus.InboundMessage) {
+	response, err := al.processMessage(ctx, msg)
+	if err != nil {
+		if ctx.Err() != nil {
+			// Shutting down: leave the message unacknowledged so the
+			// durable queue redelivers it on the next start.
+			return
+		}
+		if al.bus.Nack(msg, err) {
+			return
 		}
+		response = fmt.Sprintf("Error processing message: %v", err)
 	}
 
-	return nil
+	if response != "" {
+		// Check if the message tool already sent a response during this round.
+		// If so, skip publishing to avoid duplicate messages to the user.
+		if !al.messageSentInRound() {
+			al.bus.PublishOutbound(bus.OutboundMessage{
+				Channel: msg.Channel,
+				ChatID:  msg.ChatID,
+				Content: response,
+			})
+		}
+	}
+
+	if err == nil {
+		al.bus.Ack(msg)
+	}
 }
 
 func (al *AgentLoop) Stop() {
 	al.running.Store(false)
 }
 
+// MemoryIndex returns the search index over the agent's memory.
+func (al *AgentLoop) MemoryIndex() *memory.Index {
+	return al.contextBuilder.Memory().Index()
+}
+
 func (al *AgentLoop) RegisterTool(tool tools.Tool) {
 	al.tools.Register(tool)
 }
@@ -221,7 +321,15 @@ func (al *AgentLoop) ProcessDirect(ctx context.Context, content, sessionKey stri
 	return al.ProcessDirectWithChannel(ctx, content, sessionKey, "cli", "direct")
 }
 
+// ProcessDirectWithChannel runs content as a trusted message from picoclaw
+// itself (the local CLI), so no role restrictions apply.
 func (al *AgentLoop) ProcessDirectWithChannel(ctx context.Context, content, sessionKey, channel, chatID string) (string, error) {
+	return al.ProcessJobWithChannel(ctx, content, sessionKey, channel, chatID, nil)
+}
+
+// ProcessJobWithChannel runs a scheduled cron job with the permissions of
+// role, the role of the sender who created the job.
+func (al *AgentLoop) ProcessJobWithChannel(ctx context.Context, content, sessionKey, channel, chatID string, role *permissions.Role) (string, error) {
 	msg := bus.InboundMessage{
 		Channel:    channel,
 		SenderID:   "cron",
@@ -230,7 +338,44 @@ func (al *AgentLoop) ProcessDirectWithChannel(ctx context.Context, content, sess
 		SessionKey: sessionKey,
 	}
 
-	return al.processMessage(ctx, msg)
+	return al.processUserMessage(ctx, msg, role)
+}
+
+// ProcessEventWithChannel runs an event-triggered cron job. The event text
+// in content comes from outside picoclaw, so the job runs with the role of
+// sender ("channel:sender_id") when a chat message fired it, and with the
+// configured trigger role otherwise. Either is limited to role, the role of
+// the job's creator.
+func (al *AgentLoop) ProcessEventWithChannel(ctx context.Context, content, sessionKey, channel, chatID, sender string, role *permissions.Role) (string, error) {
+	msg := bus.InboundMessage{
+		Channel:    channel,
+		SenderID:   "cron",
+		ChatID:     chatID,
+		Content:    content,
+		SessionKey: sessionKey,
+	}
+
+	return al.processUserMessage(ctx, msg, permissions.Intersect(al.eventRole(sender), role))
+}
+
+// JobRole returns the role a cron job runs with: the named role of the
+// sender who created it. Jobs without a role were created by picoclaw itself
+// and are trusted, unless they belong to a chat channel; those predate role
+// tracking and get the channel's default role.
+func (al *AgentLoop) JobRole(name, channel string) *permissions.Role {
+	if name != "" {
+		return al.permissions.Role(name)
+	}
+	return al.permissions.RoleFor(channel, "")
+}
+
+// eventRole returns the role an event job runs with: that of the chat
+// sender who fired it, or the trigger role for events without a sender.
+func (al *AgentLoop) eventRole(sender string) *permissions.Role {
+	if senderChannel, senderID, ok := strings.Cut(sender, ":"); ok {
+		return al.permissions.RoleFor(senderChannel, senderID)
+	}
+	return al.permissions.TriggerRole()
 }
 
 // ProcessHeartbeat processes a heartbeat request without session history.
@@ -248,7 +393,21 @@ func (al *AgentLoop) ProcessHeartbeat(ctx context.Context, content, channel, cha
 	})
 }
 
+// processMessage handles a message consumed from the bus. The sender's role
+// is resolved from the permissions policy.
 func (al *AgentLoop) processMessage(ctx context.Context, msg bus.InboundMessage) (string, error) {
+	// Route system messages to processSystemMessage
+	if msg.Channel == "system" {
+		return al.processSystemMessage(ctx, msg)
+	}
+
+	return al.processUserMessage(ctx, msg, al.resolveRole(msg))
+}
+
+// processUserMessage handles a command or runs the agent for msg with the
+// permissions of role. A nil role is unrestricted, so callers only pass nil
+// for messages picoclaw itself produced.
+func (al *AgentLoop) processUserMessage(ctx context.Context, msg bus.InboundMessage, role *permissions.Role) (string, error) {
 	// Add message preview to log (show full content for error messages)
 	var logContent string
 	if strings.Contains(msg.Content, "Error:") || strings.Contains(msg.Content, "error") {
@@ -264,13 +423,8 @@ func (al *AgentLoop) processMessage(ctx context.Context, msg bus.InboundMessage)
 			"session_key": msg.SessionKey,
 		})
 
-	// Route system messages to processSystemMessage
-	if msg.Channel == "system" {
-		return al.processSystemMessage(ctx, msg)
-	}
-
 	// Check for commands
-	if response, handled := al.handleCommand(ctx, msg); handled {
+	if response, handled := al.handleCommand(ctx, msg, role); handled {
 		return response, nil
 	}
 
@@ -283,9 +437,19 @@ func (al *AgentLoop) processMessage(ctx context.Context, msg bus.InboundMessage)
 		DefaultResponse: "I've completed processing but have no response to give.",
 		EnableSummary:   true,
 		SendResponse:    false,
+		Role:            role,
+		Scope:           al.memoryScope(msg),
 	})
 }
 
+// resolveRole returns the permission role for the sender of msg. The sender
+// ID is whatever the channel reports, so it never grants trust by itself;
+// messages picoclaw produces go through processUserMessage with an explicit
+// role instead.
+func (al *AgentLoop) resolveRole(msg bus.InboundMessage) *permissions.Role {
+	return al.permissions.RoleFor(msg.Channel, msg.SenderID)
+}
+
 func (al *AgentLoop) processSystemMessage(ctx context.Context, msg bus.InboundMessage) (string, error) {
 	// Verify this is a system message
 	if msg.Channel != "system" {
@@ -296,48 +460,93 @@ func (al *AgentLoop) processSystemMessage(ctx context.Context, msg bus.InboundMe
 		map[string]interface{}{
 			"sender_id": msg.SenderID,
 			"chat_id":   msg.ChatID,
+			"kind":      msg.Metadata["kind"],
 		})
 
-	// Parse origin channel from chat_id (format: "channel:chat_id")
-	var originChannel string
+	// Parse origin from chat_id (format: "channel:chat_id"), preferring the
+	// structured metadata when the sender provides it.
+	originChannel, originChatID := "cli", "direct"
 	if idx := strings.Index(msg.ChatID, ":"); idx > 0 {
-		originChannel = msg.ChatID[:idx]
-	} else {
-		// Fallback
-		originChannel = "cli"
+		originChannel, originChatID = msg.ChatID[:idx], msg.ChatID[idx+1:]
 	}
-
-	// Extract subagent result from message content
-	// Format: "Task 'label' completed.\n\nResult:\n<actual content>"
-	content := msg.Content
-	if idx := strings.Index(content, "Result:\n"); idx >= 0 {
-		content = content[idx+8:] // Extract just the result part
+	if ch := msg.Metadata["origin_channel"]; ch != "" {
+		originChannel, originChatID = ch, msg.Metadata["origin_chat_id"]
+	}
+	sessionKey := msg.Metadata["session_key"]
+	if sessionKey == "" {
+		sessionKey = fmt.Sprintf("%s:%s", originChannel, originChatID)
 	}
 
-	// Skip internal channels - only log, don't send to user
+	event := fmt.Sprintf("[System event from %s]\n%s", msg.SenderID, msg.Content)
+
+	// Internal channels have nobody to notify: record the event in the
+	// session so the agent sees it on the next turn.
 	if constants.IsInternalChannel(originChannel) {
-		logger.InfoCF("agent", "Subagent completed (internal channel)",
+		al.sessions.AddMessage(sessionKey, "user", event)
+		al.sessions.Save(sessionKey)
+		logger.InfoCF("agent", "Recorded system event in internal session",
 			map[string]interface{}{
 				"sender_id":   msg.SenderID,
-				"content_len": len(content),
-				"channel":     originChannel,
+				"session_key": sessionKey,
+				"status":      msg.Metadata["status"],
 			})
 		return "", nil
 	}
 
-	// Agent acts as dispatcher only - subagent handles user interaction via message tool
-	// Don't forward result here, subagent should use message tool to communicate with user
-	logger.InfoCF("agent", "Subagent completed",
-		map[string]interface{}{
-			"sender_id":   msg.SenderID,
-			"channel":     originChannel,
-			"content_len": len(content),
-		})
+	// Wake the agent in the originating session so it can summarize the
+	// result for the user or act on it. The result carries text from the
+	// original sender, so the agent acts with that sender's role.
+	response, err := al.runAgentLoop(ctx, processOptions{
+		SessionKey: sessionKey,
+		Channel:    originChannel,
+		ChatID:     originChatID,
+		UserMessage: event + "\n\nThis is not a message from the user. Summarize the outcome for the user, " +
+			"or take the next step if the result calls for it. Report failures and cancellations plainly.",
+		DefaultResponse: "A background task finished but produced no summary.",
+		EnableSummary:   true,
+		SendResponse:    false,
+		Role:            al.systemEventRole(msg, originChannel),
+		Scope:           al.sessionScope(sessionKey),
+	})
+	if err != nil {
+		return "", err
+	}
 
-	// Agent only logs, does not respond to user
+	// Deliver the reply to the origin chat ourselves; the caller would send
+	// it back to the "system" channel.
+	if response != "" && !al.messageSentInRound() {
+		al.bus.PublishOutbound(bus.OutboundMessage{
+			Channel: originChannel,
+			ChatID:  originChatID,
+			Content: response,
+		})
+	}
 	return "", nil
 }
 
+// systemEventRole returns the role for handling a system event: the role
+// recorded on the subagent task that produced it, or the default role of the
+// origin channel when the task is unknown.
+func (al *AgentLoop) systemEventRole(msg bus.InboundMessage, originChannel string) *permissions.Role {
+	if al.subagents != nil {
+		if task, ok := al.subagents.GetTask(msg.Metadata["task_id"]); ok {
+			return task.Role
+		}
+	}
+	return al.permissions.RoleFor(originChannel, "")
+}
+
+// messageSentInRound reports whether the message tool already sent a reply
+// during the current turn.
+func (al *AgentLoop) messageSentInRound() bool {
+	if tool, ok := al.tools.Get("message"); ok {
+		if mt, ok := tool.(*tools.MessageTool); ok {
+			return mt.HasSentInRound()
+		}
+	}
+	return false
+}
+
 // runAgentLoop is the core message processing logic.
 // It handles context building, LLM calls, tool execution, and response handling.
 func (al *AgentLoop) runAgentLoop(ctx context.Context, opts processOptions) (string, error) {
@@ -352,8 +561,16 @@ func (al *AgentLoop) runAgentLoop(ctx context.Context, opts processOptions) (str
 		}
 	}
 
-	// 1. Update tool contexts
+	// 1. Update tool contexts and restrict tools to the sender's role and
+	// the agent profile bound to the chat
 	al.updateToolContexts(opts.Channel, opts.ChatID)
+	if opts.Profile == nil {
+		opts.Profile = al.boundProfile(opts.Channel, opts.ChatID)
+		opts.Role = opts.Profile.restrict(opts.Role)
+	}
+	ctx = permissions.WithRole(ctx, opts.Role)
+	ctx = tools.WithSessionKey(ctx, opts.SessionKey)
+	ctx = memory.WithScope(ctx, opts.Scope)
 
 	// 2. Build messages (skip history for heartbeat)
 	var history []providers.Message
@@ -362,14 +579,7 @@ func (al *AgentLoop) runAgentLoop(ctx context.Context, opts processOptions) (str
 		history = al.sessions.GetHistory(opts.SessionKey)
 		summary = al.sessions.GetSummary(opts.SessionKey)
 	}
-	messages := al.contextBuilder.BuildMessages(
-		history,
-		summary,
-		opts.UserMessage,
-		nil,
-		opts.Channel,
-		opts.ChatID,
-	)
+	messages := al.buildMessages(ctx, history, summary, opts.UserMessage, opts)
 
 	// 3. Save user message to session
 	al.sessions.AddMessage(opts.SessionKey, "user", opts.UserMessage)
@@ -418,33 +628,55 @@ func (al *AgentLoop) runAgentLoop(ctx context.Context, opts processOptions) (str
 	return finalContent, nil
 }
 
+// buildMessages builds the LLM messages for a turn, adding the prompt of the
+// agent profile bound to the chat, if any.
+func (al *AgentLoop) buildMessages(ctx context.Context, history []providers.Message, summary, currentMessage string, opts processOptions) []providers.Message {
+	messages := al.contextBuilder.BuildMessages(ctx, history, summary, currentMessage, nil, opts.Channel, opts.ChatID, opts.Role, opts.Scope)
+	if p := opts.Profile; p != nil && p.prompt != "" {
+		messages[0].Content += fmt.Sprintf("\n\n---\n\n# Agent: %s\n\n%s", p.name, p.prompt)
+	}
+	return messages
+}
+
 // runLLMIteration executes the LLM call loop with tool handling.
 // Returns the final content, iteration count, and any error.
 func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.Message, opts processOptions) (string, int, error) {
 	iteration := 0
 	var finalContent string
 
-	for iteration < al.maxIterations {
+	model, maxIterations := al.model, al.maxIterations
+	if p := opts.Profile; p != nil {
+		if p.cfg.Model != "" {
+			model = p.cfg.Model
+		}
+		if p.cfg.MaxToolIterations > 0 {
+			maxIterations = p.cfg.MaxToolIterations
+		}
+	}
+	llmOpts := opts.Profile.llmOptions(8192, 0.7)
+	registry := opts.Profile.registry(al.tools)
+
+	for iteration < maxIterations {
 		iteration++
 
 		logger.DebugCF("agent", "LLM iteration",
 			map[string]interface{}{
 				"iteration": iteration,
-				"max":       al.maxIterations,
+				"max":       maxIterations,
 			})
 
 		// Build tool definitions
-		providerToolDefs := al.tools.ToProviderDefs()
+		providerToolDefs := registry.ToProviderDefsWithContext(ctx)
 
 		// Log LLM request details
 		logger.DebugCF("agent", "LLM request",
 			map[string]interface{}{
 				"iteration":         iteration,
-				"model":             al.model,
+				"model":             model,
 				"messages_count":    len(messages),
 				"tools_count":       len(providerToolDefs),
-				"max_tokens":        8192,
-				"temperature":       0.7,
+				"max_tokens":        llmOpts["max_tokens"],
+				"temperature":       llmOpts["temperature"],
 				"system_prompt_len": len(messages[0].Content),
 			})
 
@@ -462,10 +694,7 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 		// Retry loop for context/token errors
 		maxRetries := 2
 		for retry := 0; retry <= maxRetries; retry++ {
-			response, err = al.provider.Chat(ctx, messages, providerToolDefs, al.model, map[string]interface{}{
-				"max_tokens":  8192,
-				"temperature": 0.7,
-			})
+			response, err = al.provider.Chat(ctx, messages, providerToolDefs, model, llmOpts)
 
 			if err == nil {
 				break // Success
@@ -503,14 +732,7 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 
 				// Re-create messages for the next attempt
 				// We keep the current user message (opts.UserMessage) effectively
-				messages = al.contextBuilder.BuildMessages(
-					newHistory,
-					newSummary,
-					opts.UserMessage,
-					nil,
-					opts.Channel,
-					opts.ChatID,
-				)
+				messages = al.buildMessages(ctx, newHistory, newSummary, opts.UserMessage, opts)
 
 				// Important: If we are in the middle of a tool loop (iteration > 1),
 				// rebuilding messages from session history might duplicate the flow or miss context
@@ -566,14 +788,7 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 				// We pass empty string as "currentMessage" to BuildMessages
 				// because the "current message" is already saved in history (step 3).
 
-				messages = al.contextBuilder.BuildMessages(
-					newHistory,
-					newSummary,
-					"", // Empty because history already contains the relevant messages
-					nil,
-					opts.Channel,
-					opts.ChatID,
-				)
+				messages = al.buildMessages(ctx, newHistory, newSummary, "", opts) // Empty because history already contains the relevant messages
 
 				continue
 			}
@@ -635,8 +850,8 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 		// Save assistant message with tool calls to session
 		al.sessions.AddFullMessage(opts.SessionKey, assistantMsg)
 
-		// Execute tool calls
-		for _, tc := range response.ToolCalls {
+		// Execute tool calls, in parallel where the tools allow it
+		results := tools.ExecuteToolCalls(registry, response.ToolCalls, al.maxParallelTools, func(tc providers.ToolCall) *tools.ToolResult {
 			// Log tool call with arguments preview
 			argsJSON, _ := json.Marshal(tc.Arguments)
 			argsPreview := utils.Truncate(string(argsJSON), 200)
@@ -662,7 +877,12 @@ func (al *AgentLoop) runLLMIteration(ctx context.Context, messages []providers.M
 				}
 			}
 
-			toolResult := al.tools.ExecuteWithContext(ctx, tc.Name, tc.Arguments, opts.Channel, opts.ChatID, asyncCallback)
+			return registry.ExecuteWithContext(ctx, tc.Name, tc.Arguments, opts.Channel, opts.ChatID, asyncCallback)
+		})
+
+		// Handle results in the order of the calls
+		for i, tc := range response.ToolCalls {
+			toolResult := results[i]
 
 			// Send ForUser content to user immediately if not Silent
 			if !toolResult.Silent && toolResult.ForUser != "" && opts.SendResponse {
@@ -717,6 +937,11 @@ func (al *AgentLoop) updateToolContexts(channel, chatID string) {
 			st.SetContext(channel, chatID)
 		}
 	}
+	if tool, ok := al.tools.Get("subagents"); ok {
+		if st, ok := tool.(tools.ContextualTool); ok {
+			st.SetContext(channel, chatID)
+		}
+	}
 }
 
 // maybeSummarize triggers summarization if the session history exceeds thresholds.
@@ -979,7 +1204,15 @@ func (al *AgentLoop) estimateTokens(messages []providers.Message) int {
 	return totalChars * 2 / 5
 }
 
-func (al *AgentLoop) handleCommand(ctx context.Context, msg bus.InboundMessage) (string, bool) {
+// builtinCommands lists the slash commands handled by handleCommand.
+var builtinCommands = map[string]bool{
+	"/show":   true,
+	"/list":   true,
+	"/switch": true,
+	"/tasks":  true,
+}
+
+func (al *AgentLoop) handleCommand(ctx context.Context, msg bus.InboundMessage, role *permissions.Role) (string, bool) {
 	content := strings.TrimSpace(msg.Content)
 	if !strings.HasPrefix(content, "/") {
 		return "", false
@@ -993,23 +1226,49 @@ func (al *AgentLoop) handleCommand(ctx context.Context, msg bus.InboundMessage)
 	cmd := parts[0]
 	args := parts[1:]
 
+	if builtinCommands[cmd] && !role.AllowsCommand(cmd) {
+		return fmt.Sprintf("Permission denied: your role (%s) may not use %s", role.Name, cmd), true
+	}
+
 	switch cmd {
+	// /whoami, /link and /forget only concern the sender's own data, so
+	// they are open to every role.
+	case "/whoami":
+		return al.handleWhoamiCommand(msg, role), true
+
+	case "/link":
+		return al.handleLinkCommand(msg, args), true
+
+	case "/forget":
+		if len(args) < 1 || args[0] != "me" {
+			return "Usage: /forget me", true
+		}
+		return al.handleForgetMeCommand(msg, args[1:]), true
+
+	case "/tasks":
+		return al.handleTasksCommand(msg, role, args), true
+
 	case "/show":
 		if len(args) < 1 {
-			return "Usage: /show [model|channel]", true
+			return "Usage: /show [model|channel|agent]", true
 		}
 		switch args[0] {
 		case "model":
 			return fmt.Sprintf("Current model: %s", al.model), true
 		case "channel":
 			return fmt.Sprintf("Current channel: %s", msg.Channel), true
+		case "agent":
+			if profile := al.boundProfile(msg.Channel, msg.ChatID); profile != nil {
+				return fmt.Sprintf("Current agent: %s (profile: %s)", al.name, profile.name), true
+			}
+			return fmt.Sprintf("Current agent: %s", al.name), true
 		default:
 			return fmt.Sprintf("Unknown show target: %s", args[0]), true
 		}
 
 	case "/list":
 		if len(args) < 1 {
-			return "Usage: /list [models|channels]", true
+			return "Usage: /list [models|channels|agents]", true
 		}
 		switch args[0] {
 		case "models":
@@ -1024,22 +1283,40 @@ func (al *AgentLoop) handleCommand(ctx context.Context, msg bus.InboundMessage)
 				return "No channels enabled", true
 			}
 			return fmt.Sprintf("Enabled channels: %s", strings.Join(channels, ", ")), true
+		case "agents":
+			return al.listAgents(), true
 		default:
 			return fmt.Sprintf("Unknown list target: %s", args[0]), true
 		}
 
 	case "/switch":
 		if len(args) < 3 || args[1] != "to" {
-			return "Usage: /switch [model|channel] to <name>", true
+			return "Usage: /switch [model|channel|agent] to <name>", true
 		}
 		target := args[0]
 		value := args[2]
 
 		switch target {
 		case "model":
+			if !role.AllowsModel(value) {
+				return fmt.Sprintf("Permission denied: your role (%s) may not use model %s", role.Name, value), true
+			}
 			oldModel := al.model
 			al.model = value
 			return fmt.Sprintf("Switched model from %s to %s", oldModel, value), true
+		case "agent":
+			// Switching agents moves the chat to another workspace and
+			// memory, so it needs its own grant on top of /switch.
+			if !role.AllowsCommand("/switch agent") {
+				return fmt.Sprintf("Permission denied: your role (%s) may not use /switch agent", role.Name), true
+			}
+			if al.router == nil {
+				return "Only one agent is configured", true
+			}
+			if err := al.router.Switch(msg.Channel, msg.ChatID, value); err != nil {
+				return fmt.Sprintf("Failed to switch agent: %v", err), true
+			}
+			return fmt.Sprintf("Switched this chat from agent %s to %s", al.name, value), true
 		case "channel":
 			// This changes the 'default' channel for some operations, or effectively redirects output?
 			// For now, let's just validate if the channel exists
@@ -1061,3 +1338,62 @@ func (al *AgentLoop) handleCommand(ctx context.Context, msg bus.InboundMessage)
 
 	return "", false
 }
+
+// listAgents lists the agents hosted by the gateway, marking the one that
+// serves the current chat.
+func (al *AgentLoop) listAgents() string {
+	if al.router == nil {
+		return fmt.Sprintf("Agents: %s (current)", al.name)
+	}
+	names := al.router.Names()
+	for i, name := range names {
+		if name == al.name {
+			names[i] = name + " (current)"
+		}
+	}
+	return fmt.Sprintf("Agents: %s", strings.Join(names, ", "))
+}
+
+// handleTasksCommand implements /tasks [show|cancel <id>]. Callers only
+// see tasks spawned from their own chat, unless their role is granted
+// "/tasks all".
+func (al *AgentLoop) handleTasksCommand(msg bus.InboundMessage, role *permissions.Role, args []string) string {
+	if al.subagents == nil {
+		return "Subagent manager not initialized"
+	}
+	if len(args) == 0 {
+		var visible []*tools.SubagentTask
+		for _, task := range al.subagents.ListTasks() {
+			if tools.CanSeeTask(msg.Channel, msg.ChatID, role, task) {
+				visible = append(visible, task)
+			}
+		}
+		return tools.FormatSubagentTasks(visible)
+	}
+	if len(args) < 2 {
+		return "Usage: /tasks [show|cancel <id>]"
+	}
+
+	task, ok := al.subagents.GetTask(args[1])
+	if ok && !tools.CanSeeTask(msg.Channel, msg.ChatID, role, task) {
+		ok = false
+	}
+
+	switch args[0] {
+	case "show":
+		if !ok {
+			return fmt.Sprintf("Task %s not found", args[1])
+		}
+		return tools.FormatSubagentTranscript(task)
+	case "cancel":
+		if !ok {
+			return fmt.Sprintf("task %s not found", args[1])
+		}
+		if err := al.subagents.Cancel(args[1]); err != nil {
+			return err.Error()
+		}
+		return fmt.Sprintf("Cancelling %s", args[1])
+	default:
+		return "Usage: /tasks [show|cancel <id>]"
+	}
+}
@@ -5,11 +5,13 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
 	"github.com/sipeed/picoclaw/pkg/bus"
 	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/permissions"
 	"github.com/sipeed/picoclaw/pkg/providers"
 	"github.com/sipeed/picoclaw/pkg/tools"
 )
@@ -201,6 +203,46 @@ func TestToolRegistry_ToolRegistration(t *testing.T) {
 	}
 }
 
+// TestNewAgentLoop_RegistersSkillTools verifies tools declared by skills are
+// registered without replacing built-in tools
+func TestNewAgentLoop_RegistersSkillTools(t *testing.T) {
+	tmpDir := t.TempDir()
+	skillDir := filepath.Join(tmpDir, "skills", "weather")
+	os.MkdirAll(skillDir, 0755)
+	os.WriteFile(filepath.Join(skillDir, "SKILL.md"), []byte("---\nname: weather\ndescription: Weather forecasts\n---\n"), 0644)
+	os.WriteFile(filepath.Join(skillDir, "forecast.sh"), []byte("#!/bin/sh\necho '\"sunny\"'\n"), 0755)
+	os.WriteFile(filepath.Join(skillDir, "skill.json"), []byte(`{"tools": [
+		{"name": "forecast", "description": "Get the forecast", "entrypoint": "sh forecast.sh"},
+		{"name": "exec", "description": "Shadows the built-in", "entrypoint": "sh forecast.sh"}
+	]}`), 0644)
+
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         tmpDir,
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &mockProvider{})
+
+	tool, ok := al.tools.Get("forecast")
+	if !ok {
+		t.Fatal("Expected skill tool to be registered")
+	}
+	if _, isSkill := tool.(tools.SkillProvidedTool); !isSkill {
+		t.Errorf("forecast is %T, want a skill tool", tool)
+	}
+	if exec, _ := al.tools.Get("exec"); exec.Description() == "Shadows the built-in" {
+		t.Error("Skill tool replaced the built-in exec tool")
+	}
+	if !strings.Contains(al.contextBuilder.BuildSystemPrompt(), "<tools>forecast, exec</tools>") {
+		t.Error("Expected the skills summary to list the skill's tools")
+	}
+}
+
 // TestToolContext_Updates verifies tool context is updated with channel/chatID
 func TestToolContext_Updates(t *testing.T) {
 	tmpDir, err := os.MkdirTemp("", "agent-test-*")
@@ -624,3 +666,300 @@ func TestAgentLoop_ContextExhaustionRetry(t *testing.T) {
 		t.Errorf("Expected history to be compressed (len < 8), got %d", len(finalHistory))
 	}
 }
+
+func TestProcessSystemMessage_SubagentResultWakesOriginSession(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+
+	msgBus := bus.NewMessageBus()
+	al := NewAgentLoop(cfg, msgBus, &simpleMockProvider{response: "The report is ready."})
+
+	msg := bus.InboundMessage{
+		Channel:  "system",
+		SenderID: "subagent:subagent-1",
+		ChatID:   "telegram:42",
+		Content:  "[Subagent task subagent-1]\nStatus: failed\n\nResult:\nError: boom",
+		Metadata: map[string]string{
+			"kind":           tools.SubagentResultKind,
+			"status":         tools.SubagentFailed,
+			"session_key":    "telegram:42",
+			"origin_channel": "telegram",
+			"origin_chat_id": "42",
+		},
+	}
+
+	response := testHelper{al: al}.executeAndGetResponse(t, context.Background(), msg)
+	if response != "" {
+		t.Errorf("expected the reply to be delivered directly, got response %q", response)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	out, ok := msgBus.SubscribeOutbound(ctx)
+	if !ok {
+		t.Fatal("expected a reply to the origin chat")
+	}
+	if out.Channel != "telegram" || out.ChatID != "42" || out.Content != "The report is ready." {
+		t.Errorf("unexpected outbound message: %+v", out)
+	}
+
+	history := al.sessions.GetHistory("telegram:42")
+	if len(history) != 2 || !strings.Contains(history[0].Content, "Error: boom") {
+		t.Errorf("expected the event and reply in the origin session, got %+v", history)
+	}
+}
+
+func TestProcessSystemMessage_InternalChannelRecordsEvent(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+
+	msgBus := bus.NewMessageBus()
+	al := NewAgentLoop(cfg, msgBus, &simpleMockProvider{response: "unused"})
+
+	msg := bus.InboundMessage{
+		Channel:  "system",
+		SenderID: "subagent:subagent-2",
+		ChatID:   "cli:direct",
+		Content:  "[Subagent task subagent-2]\nStatus: cancelled",
+		Metadata: map[string]string{"session_key": "cli:default"},
+	}
+	testHelper{al: al}.executeAndGetResponse(t, context.Background(), msg)
+
+	history := al.sessions.GetHistory("cli:default")
+	if len(history) != 1 || !strings.Contains(history[0].Content, "Status: cancelled") {
+		t.Errorf("expected the event recorded in the cli session, got %+v", history)
+	}
+}
+
+func TestHandleCommand_Tasks(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &simpleMockProvider{response: "done"})
+
+	tests := []struct {
+		content string
+		want    string
+	}{
+		{"/tasks", "No subagent tasks"},
+		{"/tasks show subagent-9", "Task subagent-9 not found"},
+		{"/tasks cancel subagent-9", "task subagent-9 not found"},
+		{"/tasks cancel", "Usage: /tasks"},
+	}
+	for _, tt := range tests {
+		response, handled := al.handleCommand(context.Background(), bus.InboundMessage{Content: tt.content}, nil)
+		if !handled || !strings.Contains(response, tt.want) {
+			t.Errorf("%s: got (%q, %v), want %q", tt.content, response, handled, tt.want)
+		}
+	}
+}
+
+func TestResolveRole_CronSenderIDIsNotTrusted(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+		Permissions: config.PermissionsConfig{
+			Enabled:     true,
+			DefaultRole: "guest",
+			Roles: map[string]config.RoleConfig{
+				"guest": {Tools: []string{"web_search"}},
+			},
+		},
+	}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &simpleMockProvider{response: "done"})
+
+	role := al.resolveRole(bus.InboundMessage{Channel: "telegram", SenderID: "cron", ChatID: "42"})
+	if role == nil || role.Name != "guest" {
+		t.Fatalf("expected a channel sender named cron to get the guest role, got %+v", role)
+	}
+	if role.AllowsTool("exec") {
+		t.Error("guest role should not allow exec")
+	}
+}
+
+func TestEventRole_UsesSenderOrTriggerRole(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+		Permissions: config.PermissionsConfig{
+			Enabled:     true,
+			DefaultRole: "guest",
+			TriggerRole: "automation",
+			Roles: map[string]config.RoleConfig{
+				"admin":      {Members: config.FlexibleStringSlice{"telegram:1"}, Tools: []string{"*"}},
+				"guest":      {Tools: []string{"web_search"}},
+				"automation": {Tools: []string{"message"}},
+			},
+		},
+	}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &simpleMockProvider{response: "done"})
+
+	tests := []struct {
+		sender string
+		want   string
+	}{
+		{"telegram:1", "admin"},
+		{"telegram:2", "guest"},
+		{"", "automation"},
+	}
+	for _, tt := range tests {
+		role := al.eventRole(tt.sender)
+		if role == nil || role.Name != tt.want {
+			t.Errorf("eventRole(%q) = %+v, want %s", tt.sender, role, tt.want)
+		}
+	}
+}
+
+func TestJobRole_UsesCreatorRole(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+		Permissions: config.PermissionsConfig{
+			Enabled:     true,
+			DefaultRole: "guest",
+			Roles: map[string]config.RoleConfig{
+				"scheduler": {Tools: []string{"cron"}},
+				"guest":     {Tools: []string{"web_search"}},
+			},
+		},
+	}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &simpleMockProvider{response: "done"})
+
+	if role := al.JobRole("scheduler", "telegram"); role == nil || role.Name != "scheduler" {
+		t.Errorf("expected the creator's role, got %+v", role)
+	}
+	if role := al.JobRole("removed", "telegram"); role == nil || role.AllowsTool("cron") {
+		t.Errorf("expected a removed role to grant nothing, got %+v", role)
+	}
+	if role := al.JobRole("", "telegram"); role == nil || role.Name != "guest" {
+		t.Errorf("expected a chat job without a role to get the default role, got %+v", role)
+	}
+	if role := al.JobRole("", "cli"); role != nil {
+		t.Errorf("expected a CLI job without a role to be trusted, got %+v", role)
+	}
+}
+
+func TestSystemEventRole_UsesSpawningSenderRole(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+		Permissions: config.PermissionsConfig{
+			Enabled:     true,
+			DefaultRole: "guest",
+			Roles: map[string]config.RoleConfig{
+				"guest": {Tools: []string{"web_search", "spawn"}},
+			},
+		},
+	}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &simpleMockProvider{response: "done"})
+
+	guest := al.resolveRole(bus.InboundMessage{Channel: "telegram", SenderID: "7", ChatID: "42"})
+	ctx := permissions.WithRole(context.Background(), guest)
+	if _, err := al.subagents.Spawn(ctx, "look it up", "", "", "telegram", "42", nil); err != nil {
+		t.Fatalf("Spawn failed: %v", err)
+	}
+
+	msg := bus.InboundMessage{Channel: "system", Metadata: map[string]string{"task_id": "subagent-1"}}
+	if role := al.systemEventRole(msg, "telegram"); role != guest {
+		t.Errorf("expected the spawning sender's role, got %+v", role)
+	}
+
+	msg.Metadata["task_id"] = "subagent-99"
+	if role := al.systemEventRole(msg, "telegram"); role == nil || role.Name != "guest" {
+		t.Errorf("expected the default role for an unknown task, got %+v", role)
+	}
+}
+
+func TestHandleCommand_TasksScopedToCallerChat(t *testing.T) {
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &simpleMockProvider{response: "done"})
+
+	al.subagents.Spawn(context.Background(), "mine", "mine", "", "telegram", "42", nil)
+	al.subagents.Spawn(context.Background(), "theirs", "theirs", "", "telegram", "43", nil)
+
+	caller := bus.InboundMessage{Channel: "telegram", ChatID: "42", Content: "/tasks"}
+	response, _ := al.handleCommand(context.Background(), caller, nil)
+	if !strings.Contains(response, "subagent-1") || strings.Contains(response, "subagent-2") {
+		t.Errorf("expected only the caller's task, got %q", response)
+	}
+
+	caller.Content = "/tasks show subagent-2"
+	if response, _ := al.handleCommand(context.Background(), caller, nil); !strings.Contains(response, "not found") {
+		t.Errorf("expected another chat's task to be hidden, got %q", response)
+	}
+	caller.Content = "/tasks cancel subagent-2"
+	if response, _ := al.handleCommand(context.Background(), caller, nil); !strings.Contains(response, "not found") {
+		t.Errorf("expected another chat's task to be protected, got %q", response)
+	}
+
+	policy := permissions.NewPolicy(config.PermissionsConfig{
+		Enabled: true,
+		Roles: map[string]config.RoleConfig{
+			"operator": {Commands: []string{"/tasks", "/tasks all"}},
+			"admin":    {Commands: []string{"/tasks"}},
+		},
+	})
+	caller.Content = "/tasks"
+	if response, _ := al.handleCommand(context.Background(), caller, policy.Role("operator")); !strings.Contains(response, "subagent-2") {
+		t.Errorf("expected a role granted /tasks all to see every task, got %q", response)
+	}
+	if response, _ := al.handleCommand(context.Background(), caller, policy.Role("admin")); strings.Contains(response, "subagent-2") {
+		t.Errorf("expected the role name alone to grant nothing, got %q", response)
+	}
+}
@@ -7,19 +7,35 @@
 package agent
 
 import (
+	"context"
 	"fmt"
 	"os"
 	"path/filepath"
 	"time"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/memory"
+	"github.com/sipeed/picoclaw/pkg/tools"
 )
 
+// defaultMemoryTopK is how many memory snippets are added to the prompt
+// when not configured.
+const defaultMemoryTopK = 5
+
+// memorySearchTimeout bounds the memory search done for every prompt,
+// which may call the embeddings API.
+const memorySearchTimeout = 10 * time.Second
+
 // MemoryStore manages persistent memory for the agent.
 // - Long-term memory: memory/MEMORY.md
 // - Daily notes: memory/YYYYMM/YYYYMMDD.md
+// Both, together with past session summaries, are searchable through index.
 type MemoryStore struct {
 	workspace  string
 	memoryDir  string
 	memoryFile string
+	index      *memory.Index
+	topK       int
 }
 
 // NewMemoryStore creates a new MemoryStore with the given workspace path.
@@ -35,6 +51,33 @@ func NewMemoryStore(workspace string) *MemoryStore {
 		workspace:  workspace,
 		memoryDir:  memoryDir,
 		memoryFile: memoryFile,
+		index:      memory.NewIndex(workspace),
+		topK:       defaultMemoryTopK,
+	}
+}
+
+// Index returns the search index over the workspace's memory.
+func (ms *MemoryStore) Index() *memory.Index {
+	return ms.index
+}
+
+// ReadUserProfile reads the USER.md profile of the scope's user.
+// Returns empty string if there is none.
+func (ms *MemoryStore) ReadUserProfile(scope memory.Scope) string {
+	dir := scope.UserDir()
+	if dir == "" {
+		return ""
+	}
+	if data, err := os.ReadFile(filepath.Join(ms.workspace, filepath.FromSlash(dir), "USER.md")); err == nil {
+		return string(data)
+	}
+	return ""
+}
+
+// SetTopK sets how many memory snippets GetMemoryContext returns.
+func (ms *MemoryStore) SetTopK(k int) {
+	if k > 0 {
+		ms.topK = k
 	}
 }
 
@@ -128,34 +171,24 @@ func (ms *MemoryStore) GetRecentDailyNotes(days int) string {
 	return result
 }
 
-// GetMemoryContext returns formatted memory context for the agent prompt.
-// Includes long-term memory and recent daily notes.
-func (ms *MemoryStore) GetMemoryContext() string {
-	var parts []string
-
-	// Long-term memory
-	longTerm := ms.ReadLongTerm()
-	if longTerm != "" {
-		parts = append(parts, "## Long-term Memory\n\n"+longTerm)
+// GetMemoryContext returns the memory snippets most relevant to query that
+// are visible in scope, formatted for the agent prompt. It returns "" when
+// nothing matches. The search stops when ctx is cancelled.
+func (ms *MemoryStore) GetMemoryContext(ctx context.Context, query string, scope memory.Scope) string {
+	if query == "" {
+		return ""
 	}
 
-	// Recent daily notes (last 3 days)
-	recentNotes := ms.GetRecentDailyNotes(3)
-	if recentNotes != "" {
-		parts = append(parts, "## Recent Daily Notes\n\n"+recentNotes)
-	}
+	ctx, cancel := context.WithTimeout(ctx, memorySearchTimeout)
+	defer cancel()
 
-	if len(parts) == 0 {
+	results, err := ms.index.SearchScoped(ctx, scope, query, ms.topK)
+	if err != nil {
+		logger.WarnCF("agent", "Memory search failed", map[string]interface{}{"error": err.Error()})
 		return ""
 	}
-
-	// Join parts with separator
-	var result string
-	for i, part := range parts {
-		if i > 0 {
-			result += "\n\n---\n\n"
-		}
-		result += part
+	if len(results) == 0 {
+		return ""
 	}
-	return fmt.Sprintf("# Memory\n\n%s", result)
+	return "## Relevant Memories\n\nRetrieved for the current message. Use the recall tool to search for more.\n\n" + tools.FormatMemories(results)
 }
@@ -0,0 +1,178 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/permissions"
+	"github.com/sipeed/picoclaw/pkg/skills"
+	"github.com/sipeed/picoclaw/pkg/tools"
+)
+
+// agentProfile is a named agent profile from config with its prompt loaded.
+type agentProfile struct {
+	name      string
+	cfg       config.AgentProfile
+	prompt    string
+	workspace string       // directory the agent works in; "" for the main workspace
+	tools     []tools.Tool // file, exec and skill tools rooted in workspace
+}
+
+// restrict narrows role to the tools and skills the profile allows.
+func (p *agentProfile) restrict(role *permissions.Role) *permissions.Role {
+	if p == nil {
+		return role
+	}
+	return permissions.Restrict(role, p.name, p.cfg.Tools, p.cfg.Skills)
+}
+
+// llmOptions returns the generation options for the profile, falling back
+// to the given defaults.
+func (p *agentProfile) llmOptions(maxTokens int, temperature float64) map[string]interface{} {
+	if p != nil && p.cfg.MaxTokens > 0 {
+		maxTokens = p.cfg.MaxTokens
+	}
+	if p != nil && p.cfg.Temperature != nil {
+		temperature = *p.cfg.Temperature
+	}
+	return map[string]interface{}{
+		"max_tokens":  maxTokens,
+		"temperature": temperature,
+	}
+}
+
+// setWorkspaceTools creates the file, exec and skill tools of a profile
+// that has its own workspace.
+func (p *agentProfile) setWorkspaceTools(restrict bool, specs []skills.ToolSpec) {
+	if p.workspace == "" {
+		return
+	}
+	p.tools = workspaceTools(p.workspace, restrict)
+	for _, spec := range specs {
+		p.tools = append(p.tools, tools.NewSkillTool(spec, p.workspace, restrict))
+	}
+}
+
+// registry returns the tools for a chat served by the profile: base, with
+// the tools that work on files rooted in the profile's workspace.
+func (p *agentProfile) registry(base *tools.ToolRegistry) *tools.ToolRegistry {
+	if p == nil || len(p.tools) == 0 {
+		return base
+	}
+	registry := base.Filter(func(string) bool { return true })
+	for _, tool := range p.tools {
+		existing, ok := registry.Get(tool.Name())
+		if !ok {
+			continue
+		}
+		// Skill tools never replace built-ins, as in registerSkillTools.
+		if _, isSkill := tool.(tools.SkillProvidedTool); isSkill {
+			if _, ok := existing.(tools.SkillProvidedTool); !ok {
+				continue
+			}
+		}
+		registry.Register(tool)
+	}
+	return registry
+}
+
+// loadProfiles reads the configured agent profiles and their prompt files.
+// A profile with a workspace outside the main workspace is left out.
+func loadProfiles(cfg *config.Config, workspace string) map[string]*agentProfile {
+	profiles := make(map[string]*agentProfile, len(cfg.Agents.Profiles))
+	for name, pc := range cfg.Agents.Profiles {
+		profile := &agentProfile{name: name, cfg: pc}
+		if sub := pc.Workspace; sub != "" {
+			if !filepath.IsLocal(sub) {
+				logger.WarnCF("agent", "Agent workspace must be a subdirectory of the workspace",
+					map[string]interface{}{"agent": name, "workspace": sub})
+				continue
+			}
+			profile.workspace = filepath.Join(workspace, sub)
+			os.MkdirAll(profile.workspace, 0755)
+		}
+		if pc.PromptFile != "" {
+			path := pc.PromptFile
+			if !filepath.IsAbs(path) {
+				path = filepath.Join(workspace, path)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				logger.WarnCF("agent", "Failed to read agent prompt file",
+					map[string]interface{}{"agent": name, "path": path, "error": err.Error()})
+			} else {
+				profile.prompt = string(data)
+			}
+		}
+		profiles[name] = profile
+	}
+
+	for _, b := range cfg.Agents.Bindings {
+		if _, ok := profiles[b.Agent]; !ok {
+			logger.WarnCF("agent", "Binding refers to an unknown agent",
+				map[string]interface{}{"agent": b.Agent, "channel": b.Channel, "chat_id": b.ChatID})
+		}
+	}
+	return profiles
+}
+
+// boundProfile returns the profile bound to a chat, preferring a binding for
+// the exact chat over one for the whole channel. It returns nil when the chat
+// is served by the default agent.
+func (al *AgentLoop) boundProfile(channel, chatID string) *agentProfile {
+	var channelWide *agentProfile
+	for _, b := range al.bindings {
+		profile, ok := al.profiles[b.Agent]
+		if !ok || b.Channel != channel {
+			continue
+		}
+		if b.ChatID == chatID {
+			return profile
+		}
+		if (b.ChatID == "" || b.ChatID == "*") && channelWide == nil {
+			channelWide = profile
+		}
+	}
+	return channelWide
+}
+
+// registerSubagentProfiles makes the profiles available to spawn and
+// subagent. Each gets a tool registry rooted in its workspace and limited to
+// its allowed tools.
+func registerSubagentProfiles(manager *tools.SubagentManager, profiles map[string]*agentProfile, cfg *config.Config, workspace string, msgBus *bus.MessageBus) {
+	defaults := cfg.Agents.Defaults
+	loader := newSkillsLoader(workspace)
+	skillTools := loader.ListTools()
+
+	for name, profile := range profiles {
+		profileWorkspace := workspace
+		if profile.workspace != "" {
+			profileWorkspace = profile.workspace
+		}
+
+		role := profile.restrict(nil)
+		registry := createToolRegistry(profileWorkspace, defaults.RestrictToWorkspace, cfg, msgBus)
+		registerSkillTools(registry, skillTools, role, profileWorkspace, defaults.RestrictToWorkspace)
+		registry = registry.Filter(role.AllowsTool)
+
+		prompt := profile.prompt
+		if len(profile.cfg.Skills) > 0 {
+			if summary := loader.BuildSkillsSummaryFiltered(role.AllowsSkill); summary != "" {
+				prompt += fmt.Sprintf("\n\n# Skills\n\nTo use a skill, read its SKILL.md file using the read_file tool. Tools listed under a skill can be called directly.\n\n%s", summary)
+			}
+		}
+
+		manager.SetProfile(name, tools.SubagentProfile{
+			Description:   profile.cfg.Description,
+			SystemPrompt:  prompt,
+			Model:         profile.cfg.Model,
+			LLMOptions:    profile.llmOptions(4096, 0.7),
+			MaxIterations: profile.cfg.MaxToolIterations,
+			Tools:         registry,
+		})
+	}
+}
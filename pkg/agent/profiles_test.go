@@ -0,0 +1,183 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// recordingProvider remembers the model, tools and system prompt of the
+// last call.
+type recordingProvider struct {
+	model        string
+	tools        []string
+	systemPrompt string
+	options      map[string]interface{}
+}
+
+func (m *recordingProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	m.model = model
+	m.systemPrompt = messages[0].Content
+	m.options = opts
+	m.tools = nil
+	for _, tool := range tools {
+		m.tools = append(m.tools, tool.Function.Name)
+	}
+	return &providers.LLMResponse{Content: "ok"}, nil
+}
+
+func (m *recordingProvider) GetDefaultModel() string {
+	return "mock-model"
+}
+
+func profileConfig(t *testing.T) *config.Config {
+	t.Helper()
+	workspace := t.TempDir()
+	os.WriteFile(filepath.Join(workspace, "researcher.md"), []byte("You research things thoroughly."), 0644)
+
+	temperature := 0.2
+	return &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         workspace,
+				Model:             "strong-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+			Profiles: map[string]config.AgentProfile{
+				"researcher": {
+					Description: "Web research",
+					PromptFile:  "researcher.md",
+					Model:       "cheap-model",
+					Temperature: &temperature,
+					Tools:       []string{"web_*"},
+					Workspace:   "research",
+				},
+				"coder": {Model: "code-model", Workspace: "code"},
+			},
+			Bindings: []config.AgentBinding{
+				{Channel: "telegram", Agent: "coder"},
+				{Channel: "telegram", ChatID: "42", Agent: "researcher"},
+			},
+		},
+	}
+}
+
+func TestBoundProfile(t *testing.T) {
+	al := NewAgentLoop(profileConfig(t), bus.NewMessageBus(), &mockProvider{})
+
+	tests := []struct {
+		channel, chatID string
+		want            string
+	}{
+		{"telegram", "42", "researcher"},
+		{"telegram", "7", "coder"},
+		{"discord", "42", ""},
+	}
+	for _, tt := range tests {
+		got := ""
+		if p := al.boundProfile(tt.channel, tt.chatID); p != nil {
+			got = p.name
+		}
+		if got != tt.want {
+			t.Errorf("boundProfile(%s, %s) = %q, want %q", tt.channel, tt.chatID, got, tt.want)
+		}
+	}
+}
+
+func TestRunAgentLoop_UsesBoundProfile(t *testing.T) {
+	provider := &recordingProvider{}
+	al := NewAgentLoop(profileConfig(t), bus.NewMessageBus(), provider)
+
+	testHelper{al: al}.executeAndGetResponse(t, context.Background(), bus.InboundMessage{
+		Channel:    "telegram",
+		SenderID:   "user1",
+		ChatID:     "42",
+		Content:    "find papers on llm routing",
+		SessionKey: "telegram:42",
+	})
+
+	if provider.model != "cheap-model" {
+		t.Errorf("expected profile model, got %q", provider.model)
+	}
+	if provider.options["temperature"] != 0.2 {
+		t.Errorf("expected profile temperature, got %v", provider.options["temperature"])
+	}
+	if !strings.Contains(provider.systemPrompt, "You research things thoroughly.") {
+		t.Error("expected the profile prompt in the system prompt")
+	}
+	for _, name := range provider.tools {
+		if !strings.HasPrefix(name, "web_") {
+			t.Errorf("tool %q should be hidden from the researcher", name)
+		}
+	}
+
+	testHelper{al: al}.executeAndGetResponse(t, context.Background(), bus.InboundMessage{
+		Channel:    "discord",
+		SenderID:   "user1",
+		ChatID:     "42",
+		Content:    "hello",
+		SessionKey: "discord:42",
+	})
+	if provider.model != "strong-model" {
+		t.Errorf("expected default model for unbound chats, got %q", provider.model)
+	}
+}
+
+func TestRegisterSubagentProfiles(t *testing.T) {
+	cfg := profileConfig(t)
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &mockProvider{})
+
+	profiles := al.subagents.Profiles()
+	if len(profiles) != 2 || profiles["researcher"] != "Web research" {
+		t.Errorf("unexpected subagent profiles: %v", profiles)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.WorkspacePath(), "research")); err != nil {
+		t.Errorf("expected the researcher workspace to be created: %v", err)
+	}
+}
+
+// writingProvider asks for a write_file call, then answers.
+type writingProvider struct{ calls int }
+
+func (m *writingProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	m.calls++
+	if m.calls == 1 {
+		return &providers.LLMResponse{ToolCalls: []providers.ToolCall{{
+			ID:        "call_1",
+			Name:      "write_file",
+			Arguments: map[string]interface{}{"path": "notes.txt", "content": "todo"},
+		}}}, nil
+	}
+	return &providers.LLMResponse{Content: "written"}, nil
+}
+
+func (m *writingProvider) GetDefaultModel() string {
+	return "mock-model"
+}
+
+func TestRunAgentLoop_BoundProfileUsesItsWorkspace(t *testing.T) {
+	cfg := profileConfig(t)
+	al := NewAgentLoop(cfg, bus.NewMessageBus(), &writingProvider{})
+
+	testHelper{al: al}.executeAndGetResponse(t, context.Background(), bus.InboundMessage{
+		Channel:    "telegram",
+		SenderID:   "user1",
+		ChatID:     "7",
+		Content:    "write down my todo",
+		SessionKey: "telegram:7",
+	})
+
+	if _, err := os.Stat(filepath.Join(cfg.WorkspacePath(), "code", "notes.txt")); err != nil {
+		t.Errorf("expected the coder to write in its workspace: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(cfg.WorkspacePath(), "notes.txt")); err == nil {
+		t.Error("the coder wrote to the main workspace")
+	}
+}
@@ -0,0 +1,236 @@
+package agent
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/logger"
+)
+
+// routerQueueSize is the number of messages buffered per agent, so a busy
+// agent does not hold up the others.
+const routerQueueSize = 100
+
+// Router hosts several agents on one gateway. It consumes inbound messages
+// from the bus and hands each to the agent that serves its chat, chosen by
+// a /switch agent override, then the routing table, then the default agent.
+type Router struct {
+	bus       *bus.MessageBus
+	agents    map[string]*AgentLoop
+	routes    []config.AgentRoute
+	overrides map[string]string // "channel:chat_id" -> agent, set by /switch agent
+	stateFile string
+	mu        sync.RWMutex
+}
+
+// NewRouter creates a router. Chat overrides are persisted in stateFile.
+func NewRouter(msgBus *bus.MessageBus, routes []config.AgentRoute, stateFile string) *Router {
+	r := &Router{
+		bus:       msgBus,
+		agents:    make(map[string]*AgentLoop),
+		routes:    routes,
+		overrides: make(map[string]string),
+		stateFile: stateFile,
+	}
+	if data, err := os.ReadFile(stateFile); err == nil {
+		if err := json.Unmarshal(data, &r.overrides); err != nil {
+			logger.WarnCF("agent", "Failed to load agent overrides", map[string]interface{}{"error": err.Error()})
+		}
+	}
+	return r
+}
+
+// Add registers an agent under name.
+func (r *Router) Add(name string, al *AgentLoop) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	al.name = name
+	al.router = r
+	if al.subagents != nil {
+		al.subagents.SetOwner(name)
+	}
+	r.agents[name] = al
+}
+
+// Agent returns the agent registered under name.
+func (r *Router) Agent(name string) (*AgentLoop, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	al, ok := r.agents[name]
+	return al, ok
+}
+
+// Names returns the registered agent names in sorted order.
+func (r *Router) Names() []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	names := make([]string, 0, len(r.agents))
+	for name := range r.agents {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Resolve returns the name of the agent that serves msg. System messages,
+// such as subagent results, go back to the agent named in their metadata,
+// or else to the agent of the chat they report to.
+func (r *Router) Resolve(msg bus.InboundMessage) string {
+	channel, chatID, senderID := msg.Channel, msg.ChatID, msg.SenderID
+	if channel == "system" {
+		if _, ok := r.Agent(msg.Metadata["agent"]); ok {
+			return msg.Metadata["agent"]
+		}
+		channel, chatID, senderID = "", "", ""
+		if idx := strings.Index(msg.ChatID, ":"); idx > 0 {
+			channel, chatID = msg.ChatID[:idx], msg.ChatID[idx+1:]
+		}
+		if ch := msg.Metadata["origin_channel"]; ch != "" {
+			channel, chatID = ch, msg.Metadata["origin_chat_id"]
+		}
+	}
+	return r.resolve(channel, chatID, senderID)
+}
+
+func (r *Router) resolve(channel, chatID, senderID string) string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if name, ok := r.overrides[channel+":"+chatID]; ok {
+		if _, exists := r.agents[name]; exists {
+			return name
+		}
+	}
+	for _, route := range r.routes {
+		if _, exists := r.agents[route.Agent]; exists && routeMatches(route, channel, chatID, senderID) {
+			return route.Agent
+		}
+	}
+	return config.DefaultAgentName
+}
+
+func routeMatches(route config.AgentRoute, channel, chatID, senderID string) bool {
+	if route.Channel != "" && route.Channel != "*" && route.Channel != channel {
+		return false
+	}
+	if route.ChatID != "" && route.ChatID != chatID {
+		return false
+	}
+	if route.Sender != "" {
+		// Sender IDs may be "id|username"; either part matches.
+		want := strings.TrimPrefix(route.Sender, "@")
+		idPart, userPart, _ := strings.Cut(senderID, "|")
+		if senderID != want && idPart != want && userPart != want {
+			return false
+		}
+	}
+	return true
+}
+
+// Switch makes agent serve a chat from now on, overriding the routing table.
+func (r *Router) Switch(channel, chatID, agent string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.agents[agent]; !ok {
+		return fmt.Errorf("unknown agent %q", agent)
+	}
+	r.overrides[channel+":"+chatID] = agent
+	return r.saveLocked()
+}
+
+func (r *Router) saveLocked() error {
+	if r.stateFile == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(r.overrides, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(r.stateFile), 0755); err != nil {
+		return err
+	}
+	tmp := r.stateFile + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, r.stateFile)
+}
+
+// Run dispatches inbound messages until ctx is cancelled. Each agent
+// processes its own messages in order, independently of the others.
+func (r *Router) Run(ctx context.Context) error {
+	queues := make(map[string]chan bus.InboundMessage)
+	var wg sync.WaitGroup
+	for _, name := range r.Names() {
+		al, _ := r.Agent(name)
+		queue := make(chan bus.InboundMessage, routerQueueSize)
+		queues[name] = queue
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			al.running.Store(true)
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case msg := <-queue:
+					al.handleInbound(ctx, msg)
+				}
+			}
+		}()
+	}
+
+	defer wg.Wait()
+	for {
+		msg, ok := r.bus.ConsumeInbound(ctx)
+		if !ok {
+			return nil
+		}
+
+		name := r.Resolve(msg)
+		queue, ok := queues[name]
+		if !ok {
+			name = config.DefaultAgentName
+			queue = queues[name]
+		}
+		select {
+		case queue <- msg:
+		default:
+			r.reject(name, msg)
+		}
+	}
+}
+
+// reject turns away a message whose agent's queue is full, rather than
+// holding up the messages of every other agent. A message on the durable
+// queue is retried later; otherwise the sender is asked to resend it.
+func (r *Router) reject(name string, msg bus.InboundMessage) {
+	err := fmt.Errorf("agent %q is busy", name)
+	if r.bus.Nack(msg, err) {
+		return
+	}
+	logger.WarnCF("agent", "Agent queue full, message dropped",
+		map[string]interface{}{
+			"agent":   name,
+			"channel": msg.Channel,
+			"chat_id": msg.ChatID,
+		})
+	if msg.Channel == "system" {
+		return
+	}
+	r.bus.PublishOutbound(bus.OutboundMessage{
+		Channel: msg.Channel,
+		ChatID:  msg.ChatID,
+		Content: fmt.Sprintf("Agent %s is busy; please send your message again shortly.", name),
+	})
+}
@@ -0,0 +1,215 @@
+package agent
+
+import (
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/permissions"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+func newTestAgent(t *testing.T, msgBus *bus.MessageBus, response string) *AgentLoop {
+	t.Helper()
+	return newTestAgentWith(t, msgBus, &simpleMockProvider{response: response})
+}
+
+func newTestAgentWith(t *testing.T, msgBus *bus.MessageBus, provider providers.LLMProvider) *AgentLoop {
+	t.Helper()
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         t.TempDir(),
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+	}
+	return NewAgentLoop(cfg, msgBus, provider)
+}
+
+func newTestRouter(t *testing.T, stateFile string) (*Router, *bus.MessageBus) {
+	t.Helper()
+	msgBus := bus.NewMessageBus()
+	router := NewRouter(msgBus, []config.AgentRoute{
+		{Channel: "telegram", ChatID: "-100", Agent: "family"},
+		{Channel: "slack", Agent: "work"},
+		{Sender: "@alice", Agent: "family"},
+		{Channel: "discord", Agent: "missing"},
+	}, stateFile)
+	router.Add(config.DefaultAgentName, newTestAgent(t, msgBus, "from main"))
+	router.Add("family", newTestAgent(t, msgBus, "from family"))
+	router.Add("work", newTestAgent(t, msgBus, "from work"))
+	return router, msgBus
+}
+
+func TestRouter_Resolve(t *testing.T) {
+	router, _ := newTestRouter(t, "")
+
+	tests := []struct {
+		name string
+		msg  bus.InboundMessage
+		want string
+	}{
+		{"chat route", bus.InboundMessage{Channel: "telegram", ChatID: "-100", SenderID: "1"}, "family"},
+		{"channel route", bus.InboundMessage{Channel: "slack", ChatID: "C1", SenderID: "U1"}, "work"},
+		{"sender route", bus.InboundMessage{Channel: "telegram", ChatID: "5", SenderID: "5|alice"}, "family"},
+		{"unknown agent falls through", bus.InboundMessage{Channel: "discord", ChatID: "1", SenderID: "1"}, "main"},
+		{"default", bus.InboundMessage{Channel: "telegram", ChatID: "5", SenderID: "5|bob"}, "main"},
+		{"system message follows its origin chat", bus.InboundMessage{
+			Channel: "system", ChatID: "slack:C1", SenderID: "subagent:subagent-1",
+		}, "work"},
+		{"system message returns to the agent that spawned it", bus.InboundMessage{
+			Channel: "system", ChatID: "telegram:5", SenderID: "subagent:subagent-1",
+			Metadata: map[string]string{"agent": "family"},
+		}, "family"},
+		{"system message for an unknown agent follows its chat", bus.InboundMessage{
+			Channel: "system", ChatID: "slack:C1", SenderID: "subagent:subagent-1",
+			Metadata: map[string]string{"agent": "gone"},
+		}, "work"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := router.Resolve(tt.msg); got != tt.want {
+				t.Errorf("Resolve() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRouter_SwitchIsPersisted(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "agent_routes.json")
+	router, _ := newTestRouter(t, stateFile)
+
+	if err := router.Switch("slack", "C1", "nobody"); err == nil {
+		t.Error("expected switching to an unknown agent to fail")
+	}
+	if err := router.Switch("slack", "C1", "family"); err != nil {
+		t.Fatalf("Switch failed: %v", err)
+	}
+
+	restarted, _ := newTestRouter(t, stateFile)
+	if got := restarted.Resolve(bus.InboundMessage{Channel: "slack", ChatID: "C1"}); got != "family" {
+		t.Errorf("expected the override to survive a restart, got %q", got)
+	}
+	if got := restarted.Resolve(bus.InboundMessage{Channel: "slack", ChatID: "C2"}); got != "work" {
+		t.Errorf("expected other chats to keep their route, got %q", got)
+	}
+}
+
+func TestRouter_RunDispatchesToAgents(t *testing.T) {
+	router, msgBus := newTestRouter(t, "")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go router.Run(ctx)
+
+	msgBus.PublishInbound(bus.InboundMessage{Channel: "slack", ChatID: "C1", SenderID: "U1", Content: "hi", SessionKey: "slack:C1"})
+	msgBus.PublishInbound(bus.InboundMessage{Channel: "telegram", ChatID: "9", SenderID: "9", Content: "/list agents", SessionKey: "telegram:9"})
+
+	got := map[string]string{}
+	for i := 0; i < 2; i++ {
+		recvCtx, recvCancel := context.WithTimeout(ctx, 2*time.Second)
+		out, ok := msgBus.SubscribeOutbound(recvCtx)
+		recvCancel()
+		if !ok {
+			t.Fatalf("expected 2 replies, got %v", got)
+		}
+		got[out.Channel] = out.Content
+	}
+
+	if got["slack"] != "from work" {
+		t.Errorf("expected the work agent to answer on slack, got %q", got["slack"])
+	}
+	if got["telegram"] != "Agents: family, main (current), work" {
+		t.Errorf("unexpected /list agents reply: %q", got["telegram"])
+	}
+}
+
+// stuckProvider never answers until the context is cancelled.
+type stuckProvider struct{ simpleMockProvider }
+
+func (p *stuckProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, opts map[string]interface{}) (*providers.LLMResponse, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestRouter_RunNotHeldUpByBusyAgent(t *testing.T) {
+	router, msgBus := newTestRouter(t, "")
+	router.Add("family", newTestAgentWith(t, msgBus, &stuckProvider{}))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go router.Run(ctx)
+
+	// One message in progress, a full queue and one more for the stuck agent.
+	for i := 0; i < routerQueueSize+2; i++ {
+		msgBus.PublishInbound(bus.InboundMessage{Channel: "telegram", ChatID: "-100", SenderID: "7", Content: "hi", SessionKey: "telegram:-100"})
+	}
+	msgBus.PublishInbound(bus.InboundMessage{Channel: "slack", ChatID: "C1", SenderID: "U1", Content: "hi", SessionKey: "slack:C1"})
+
+	got := map[string]string{}
+	for len(got) < 2 {
+		recvCtx, recvCancel := context.WithTimeout(ctx, 2*time.Second)
+		out, ok := msgBus.SubscribeOutbound(recvCtx)
+		recvCancel()
+		if !ok {
+			t.Fatalf("expected replies on telegram and slack, got %v", got)
+		}
+		got[out.Channel] = out.Content
+	}
+
+	if got["slack"] != "from work" {
+		t.Errorf("expected the work agent to answer despite the busy family agent, got %q", got["slack"])
+	}
+	if !strings.Contains(got["telegram"], "busy") {
+		t.Errorf("expected the overflowing message to be turned away, got %q", got["telegram"])
+	}
+}
+
+func TestHandleCommand_SwitchAgent(t *testing.T) {
+	router, _ := newTestRouter(t, "")
+	mainAgent, _ := router.Agent(config.DefaultAgentName)
+	msg := bus.InboundMessage{Channel: "telegram", ChatID: "9", Content: "/switch agent to work"}
+
+	response, handled := mainAgent.handleCommand(context.Background(), msg, nil)
+	if !handled || response != "Switched this chat from agent main to work" {
+		t.Errorf("unexpected response: %q", response)
+	}
+	if got := router.Resolve(msg); got != "work" {
+		t.Errorf("expected the chat to be served by work, got %q", got)
+	}
+
+	single := newTestAgent(t, bus.NewMessageBus(), "")
+	if response, _ := single.handleCommand(context.Background(), msg, nil); response != "Only one agent is configured" {
+		t.Errorf("unexpected response without a router: %q", response)
+	}
+}
+
+func TestSwitchAgent_RequiresItsOwnGrant(t *testing.T) {
+	router, _ := newTestRouter(t, "")
+	al, _ := router.Agent(config.DefaultAgentName)
+	msg := bus.InboundMessage{Channel: "telegram", ChatID: "5", Content: "/switch agent to family"}
+
+	switchOnly := permissions.NewPolicy(config.PermissionsConfig{
+		Enabled: true,
+		Roles: map[string]config.RoleConfig{
+			"member": {Members: config.FlexibleStringSlice{"*"}, Commands: []string{"/switch"}, Models: []string{"*"}},
+		},
+	}).RoleFor("telegram", "5")
+	response, _ := al.handleCommand(context.Background(), msg, switchOnly)
+	if !strings.Contains(response, "Permission denied") {
+		t.Errorf("expected /switch alone not to allow switching agents, got %q", response)
+	}
+	if got := router.Resolve(msg); got != config.DefaultAgentName {
+		t.Errorf("expected the chat to stay on main, got %s", got)
+	}
+
+	response, _ = al.handleCommand(context.Background(), msg, nil)
+	if !strings.Contains(response, "Switched this chat") {
+		t.Errorf("expected an unrestricted caller to switch, got %q", response)
+	}
+}
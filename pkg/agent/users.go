@@ -0,0 +1,156 @@
+package agent
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/constants"
+	"github.com/sipeed/picoclaw/pkg/memory"
+	"github.com/sipeed/picoclaw/pkg/permissions"
+)
+
+// memoryScope returns the memory a message may see: with per-user memory,
+// the sender's own, their group chat's and the shared workspace memory.
+// Messages from internal channels are the operator's and see everything.
+func (al *AgentLoop) memoryScope(msg bus.InboundMessage) memory.Scope {
+	if al.identities == nil || constants.IsInternalChannel(msg.Channel) {
+		return memory.Scope{}
+	}
+	scope := memory.Scope{Session: msg.SessionKey}
+	if msg.SenderID != "" {
+		scope.User = al.identities.Resolve(msg.Channel, msg.SenderID)
+	}
+	if isGroupChat(msg) {
+		scope.Chat = msg.Channel + ":" + msg.ChatID
+	}
+	return scope
+}
+
+// sessionScope returns the memory scope for events without a sender, such as
+// subagent results, delivered to a chat.
+func (al *AgentLoop) sessionScope(sessionKey string) memory.Scope {
+	if al.identities == nil {
+		return memory.Scope{}
+	}
+	return memory.Scope{Session: sessionKey}
+}
+
+// isGroupChat reports whether msg comes from a chat with several people.
+// Channels that do not say are judged by whether the chat is the sender's
+// own direct chat.
+func isGroupChat(msg bus.InboundMessage) bool {
+	if v, ok := msg.Metadata["is_group"]; ok {
+		return v == "true"
+	}
+	if v, ok := msg.Metadata["is_dm"]; ok {
+		return v != "true"
+	}
+	if v, ok := msg.Metadata["chat_type"]; ok {
+		return v != "p2p" && v != "private"
+	}
+	id, _, _ := strings.Cut(msg.SenderID, "|")
+	return msg.ChatID != id
+}
+
+// handleWhoamiCommand describes the sender's identity and what is stored
+// about them.
+func (al *AgentLoop) handleWhoamiCommand(msg bus.InboundMessage, role *permissions.Role) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Sender: %s on %s\n", msg.SenderID, msg.Channel)
+	if role != nil {
+		fmt.Fprintf(&sb, "Role: %s\n", role.Name)
+	}
+	if al.identities == nil {
+		sb.WriteString("Per-user memory is disabled: memory is shared by everyone using this agent.")
+		return sb.String()
+	}
+
+	scope := al.memoryScope(msg)
+	fmt.Fprintf(&sb, "Identity: %s\n", scope.User)
+	fmt.Fprintf(&sb, "Linked accounts: %s\n", strings.Join(al.identities.Accounts(scope.User), ", "))
+	if scope.Chat != "" {
+		fmt.Fprintf(&sb, "Group chat: %s (shared memory: %d facts)\n", scope.Chat, al.countFacts(scope.ChatDir()))
+	}
+
+	profile := "not set"
+	if _, err := os.Stat(filepath.Join(al.workspace, filepath.FromSlash(scope.UserDir()), "USER.md")); err == nil {
+		profile = scope.UserDir() + "/USER.md"
+	}
+	fmt.Fprintf(&sb, "Profile: %s\n", profile)
+	fmt.Fprintf(&sb, "Memory: %d facts\n", al.countFacts(scope.UserDir()))
+	sb.WriteString("\nUse /link to connect another account, /forget me to delete your profile and memory.")
+	return sb.String()
+}
+
+// countFacts counts the list items in the MEMORY.md of a user or chat
+// directory.
+func (al *AgentLoop) countFacts(dir string) int {
+	data, err := os.ReadFile(filepath.Join(al.workspace, filepath.FromSlash(dir), "MEMORY.md"))
+	if err != nil {
+		return 0
+	}
+	n := 0
+	for _, line := range strings.Split(string(data), "\n") {
+		if strings.HasPrefix(line, "- ") {
+			n++
+		}
+	}
+	return n
+}
+
+// handleLinkCommand links accounts on different channels to one identity:
+// "/link" issues a code, "/link <code>" sent from the other account uses it.
+func (al *AgentLoop) handleLinkCommand(msg bus.InboundMessage, args []string) string {
+	if al.identities == nil {
+		return "Per-user memory is disabled, there is nothing to link."
+	}
+	if constants.IsInternalChannel(msg.Channel) || msg.SenderID == "" {
+		return "Accounts can only be linked from a chat channel."
+	}
+
+	if len(args) == 0 {
+		identity := al.identities.Resolve(msg.Channel, msg.SenderID)
+		code := al.identities.NewLinkCode(identity)
+		return fmt.Sprintf("To link another account to %s, send this from it within 10 minutes:\n/link %s", identity, code)
+	}
+
+	identity, err := al.identities.Link(strings.Join(args, ""), msg.Channel, msg.SenderID)
+	if err != nil {
+		return fmt.Sprintf("Link failed: %v", err)
+	}
+	return fmt.Sprintf("Linked. This account now shares the profile and memory of %s.", identity)
+}
+
+// handleForgetMeCommand deletes the sender's profile and memory after
+// confirmation. In a direct chat the conversation history goes too.
+func (al *AgentLoop) handleForgetMeCommand(msg bus.InboundMessage, args []string) string {
+	if al.identities == nil {
+		return "Per-user memory is disabled: memory is shared and cannot be deleted per user."
+	}
+	if constants.IsInternalChannel(msg.Channel) || msg.SenderID == "" {
+		return "/forget me only works from a chat channel."
+	}
+
+	scope := al.memoryScope(msg)
+	if len(args) == 0 || args[0] != "confirm" {
+		what := fmt.Sprintf("your profile and memory (%d facts) and the links between your accounts (%s)",
+			al.countFacts(scope.UserDir()), strings.Join(al.identities.Accounts(scope.User), ", "))
+		if scope.Chat == "" {
+			what += ", and the history of this chat"
+		}
+		return fmt.Sprintf("This deletes %s. It cannot be undone.\nSend /forget me confirm to proceed.", what)
+	}
+
+	if err := al.identities.Forget(scope.User); err != nil {
+		return fmt.Sprintf("Failed to delete your data: %v", err)
+	}
+	if scope.Chat == "" {
+		al.sessions.SetHistory(msg.SessionKey, nil)
+		al.sessions.SetSummary(msg.SessionKey, "")
+		al.sessions.Save(msg.SessionKey)
+	}
+	return "Done. Your profile and memory have been deleted."
+}
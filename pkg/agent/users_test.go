@@ -0,0 +1,148 @@
+package agent
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func newPerUserAgentLoop(t *testing.T) (*AgentLoop, string) {
+	t.Helper()
+	workspace := t.TempDir()
+	cfg := &config.Config{
+		Agents: config.AgentsConfig{
+			Defaults: config.AgentDefaults{
+				Workspace:         workspace,
+				Model:             "test-model",
+				MaxTokens:         4096,
+				MaxToolIterations: 10,
+			},
+		},
+		Memory: config.MemoryConfig{
+			PerUser:    true,
+			Identities: map[string][]string{"alice": {"telegram:1", "slack:UA"}},
+		},
+	}
+	os.WriteFile(filepath.Join(workspace, "USER.md"), []byte("Global user profile"), 0644)
+	return NewAgentLoop(cfg, bus.NewMessageBus(), &simpleMockProvider{response: "ok"}), workspace
+}
+
+func writeUserFile(t *testing.T, workspace, dir, name, content string) {
+	t.Helper()
+	os.MkdirAll(filepath.Join(workspace, dir), 0755)
+	if err := os.WriteFile(filepath.Join(workspace, dir, name), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPerUserMemory_Prompt(t *testing.T) {
+	al, workspace := newPerUserAgentLoop(t)
+	writeUserFile(t, workspace, "users/alice", "USER.md", "Alice prefers short answers")
+	writeUserFile(t, workspace, "users/alice", "MEMORY.md", "- Alice's bike is a red road bike\n")
+	writeUserFile(t, workspace, "users/telegram_2", "MEMORY.md", "- Bob's bike is a blue mountain bike\n")
+
+	alice := bus.InboundMessage{Channel: "slack", SenderID: "UA", ChatID: "DA", SessionKey: "slack:DA", Content: "what bike do I have?"}
+	scope := al.memoryScope(alice)
+	if scope.User != "alice" {
+		t.Fatalf("slack account resolved to %q", scope.User)
+	}
+
+	prompt := al.contextBuilder.BuildSystemPromptFor(context.Background(), nil, alice.Content, scope)
+	for _, want := range []string{"Alice prefers short answers", "red road bike", "You are talking to alice"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt is missing %q", want)
+		}
+	}
+	for _, leak := range []string{"blue mountain bike", "Global user profile"} {
+		if strings.Contains(prompt, leak) {
+			t.Errorf("prompt leaks %q", leak)
+		}
+	}
+
+	// Without per-user memory the workspace USER.md is used as before.
+	if prompt := al.contextBuilder.BuildSystemPrompt(); !strings.Contains(prompt, "Global user profile") {
+		t.Error("unscoped prompt is missing the workspace USER.md")
+	}
+}
+
+func TestPerUserMemory_GroupScope(t *testing.T) {
+	al, _ := newPerUserAgentLoop(t)
+
+	tests := []struct {
+		msg      bus.InboundMessage
+		wantChat string
+	}{
+		{bus.InboundMessage{Channel: "telegram", SenderID: "1|alice", ChatID: "1", Metadata: map[string]string{"is_group": "false"}}, ""},
+		{bus.InboundMessage{Channel: "telegram", SenderID: "1|alice", ChatID: "-100", Metadata: map[string]string{"is_group": "true"}}, "telegram:-100"},
+		{bus.InboundMessage{Channel: "discord", SenderID: "7", ChatID: "9", Metadata: map[string]string{"is_dm": "true"}}, ""},
+		{bus.InboundMessage{Channel: "qq", SenderID: "7", ChatID: "group-1"}, "qq:group-1"},
+		{bus.InboundMessage{Channel: "cli", SenderID: "user", ChatID: "direct"}, ""},
+	}
+	for _, tt := range tests {
+		if got := al.memoryScope(tt.msg).Chat; got != tt.wantChat {
+			t.Errorf("%s/%s: chat scope = %q, want %q", tt.msg.Channel, tt.msg.ChatID, got, tt.wantChat)
+		}
+	}
+	if !al.memoryScope(bus.InboundMessage{Channel: "cli", SenderID: "user"}).IsZero() {
+		t.Error("internal channels should be unscoped")
+	}
+}
+
+func TestPerUserMemory_Commands(t *testing.T) {
+	al, workspace := newPerUserAgentLoop(t)
+	writeUserFile(t, workspace, "users/telegram_2", "MEMORY.md", "# Memory\n\n- Bob likes chess\n")
+
+	run := func(msg bus.InboundMessage, content string) string {
+		msg.Content = content
+		response, handled := al.handleCommand(context.Background(), msg, nil)
+		if !handled {
+			t.Fatalf("%s not handled", content)
+		}
+		return response
+	}
+	bobTelegram := bus.InboundMessage{Channel: "telegram", SenderID: "2|bob", ChatID: "2", SessionKey: "telegram:2"}
+	bobDiscord := bus.InboundMessage{Channel: "discord", SenderID: "42", ChatID: "42", SessionKey: "discord:42"}
+
+	whoami := run(bobTelegram, "/whoami")
+	for _, want := range []string{"Identity: telegram:2", "Memory: 1 facts", "Profile: not set"} {
+		if !strings.Contains(whoami, want) {
+			t.Errorf("/whoami missing %q:\n%s", want, whoami)
+		}
+	}
+
+	// Link the Discord account to Bob's Telegram identity.
+	reply := run(bobTelegram, "/link")
+	code := strings.TrimPrefix(reply[strings.LastIndex(reply, "\n")+1:], "/link ")
+	if reply := run(bobDiscord, "/link "+code); !strings.Contains(reply, "Linked") {
+		t.Fatalf("/link %s = %q", code, reply)
+	}
+	if whoami := run(bobDiscord, "/whoami"); !strings.Contains(whoami, "Identity: telegram:2") || !strings.Contains(whoami, "discord:42, telegram:2") {
+		t.Errorf("/whoami after link:\n%s", whoami)
+	}
+
+	// /forget me asks first, then deletes the memory and the link.
+	al.sessions.AddMessage("discord:42", "user", "hello")
+	if reply := run(bobDiscord, "/forget me"); !strings.Contains(reply, "/forget me confirm") {
+		t.Fatalf("/forget me = %q", reply)
+	}
+	if _, err := os.Stat(filepath.Join(workspace, "users", "telegram_2")); err != nil {
+		t.Fatal("data deleted before confirmation")
+	}
+	if reply := run(bobDiscord, "/forget me confirm"); !strings.Contains(reply, "deleted") {
+		t.Fatalf("/forget me confirm = %q", reply)
+	}
+	if _, err := os.Stat(filepath.Join(workspace, "users", "telegram_2")); !os.IsNotExist(err) {
+		t.Error("user memory not deleted")
+	}
+	if len(al.sessions.GetHistory("discord:42")) != 0 {
+		t.Error("direct chat history not cleared")
+	}
+	if got := al.memoryScope(bobDiscord).User; got != "discord:42" {
+		t.Errorf("after forget the discord account resolves to %s", got)
+	}
+}
@@ -3,49 +3,241 @@ package bus
 import (
 	"context"
 	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
 )
 
+// replayPollInterval is how often RunQueue checks for replayed dead letters.
+const replayPollInterval = 5 * time.Second
+
 type MessageBus struct {
-	inbound  chan InboundMessage
-	outbound chan OutboundMessage
-	handlers map[string]MessageHandler
-	closed   bool
-	mu       sync.RWMutex
+	inbound      chan InboundMessage
+	outbound     chan OutboundMessage
+	handlers     map[string]MessageHandler
+	queue        *Queue
+	observers    []func(InboundMessage)
+	outObservers []func(OutboundMessage)
+	retryDelay   time.Duration
+	redeliver    []QueueEntry // pending when the queue was set, for RunQueue
+	closed       bool
+	done         chan struct{} // closed by Close, to unblock senders
+	closeOnce    sync.Once
+	mu           sync.RWMutex
 }
 
 func NewMessageBus() *MessageBus {
 	return &MessageBus{
-		inbound:  make(chan InboundMessage, 100),
-		outbound: make(chan OutboundMessage, 100),
-		handlers: make(map[string]MessageHandler),
+		inbound:    make(chan InboundMessage, 100),
+		outbound:   make(chan OutboundMessage, 100),
+		handlers:   make(map[string]MessageHandler),
+		retryDelay: 5 * time.Second,
+		done:       make(chan struct{}),
+	}
+}
+
+// SetQueue makes inbound messages durable. Messages are written to q before
+// they are delivered and must be acknowledged with Ack or Nack once handled.
+// Call RunQueue to redeliver messages left over from a previous run; those
+// are the ones pending now, so call SetQueue before any channel publishes.
+func (mb *MessageBus) SetQueue(q *Queue) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.queue = q
+	mb.redeliver = q.Pending()
+}
+
+// RunQueue redelivers messages that were pending when the gateway last
+// stopped, then watches for replayed dead letters until ctx is done.
+func (mb *MessageBus) RunQueue(ctx context.Context) {
+	mb.mu.Lock()
+	q, pending := mb.queue, mb.redeliver
+	mb.redeliver = nil
+	mb.mu.Unlock()
+	if q == nil {
+		return
+	}
+
+	if len(pending) > 0 {
+		logger.InfoCF("bus", "Redelivering pending inbound messages",
+			map[string]interface{}{
+				"count": len(pending),
+			})
+	}
+	for _, entry := range pending {
+		mb.push(entry.Message)
+	}
+
+	ticker := time.NewTicker(replayPollInterval)
+	defer ticker.Stop()
+	for {
+		replayed, err := q.ImportReplays()
+		if err != nil {
+			logger.ErrorCF("bus", "Failed to import replayed messages",
+				map[string]interface{}{
+					"error": err.Error(),
+				})
+		}
+		for _, entry := range replayed {
+			logger.InfoCF("bus", "Replaying dead-lettered message",
+				map[string]interface{}{
+					"id":      entry.ID,
+					"channel": entry.Message.Channel,
+				})
+			mb.push(entry.Message)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
 	}
 }
 
+// OnInbound registers fn to be called with every newly published inbound
+// message, before it is queued for the agent. fn must not block.
+func (mb *MessageBus) OnInbound(fn func(InboundMessage)) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.observers = append(mb.observers, fn)
+}
+
 func (mb *MessageBus) PublishInbound(msg InboundMessage) {
 	mb.mu.RLock()
 	defer mb.mu.RUnlock()
+	for _, fn := range mb.observers {
+		fn(msg)
+	}
 	if mb.closed {
+		logger.WarnCF("bus", "Dropping inbound message: bus is closed",
+			map[string]interface{}{
+				"channel": msg.Channel,
+				"chat_id": msg.ChatID,
+			})
 		return
 	}
-	mb.inbound <- msg
+	if mb.queue != nil {
+		if id, err := mb.queue.Enqueue(msg); err != nil {
+			// Still deliver the message; it just won't survive a restart.
+			logger.ErrorCF("bus", "Failed to persist inbound message",
+				map[string]interface{}{
+					"channel": msg.Channel,
+					"error":   err.Error(),
+				})
+		} else {
+			msg.ID = id
+		}
+	}
+	mb.send(msg)
+}
+
+// send hands msg to the consumer, giving up when the bus is closed. Callers
+// hold mb.mu for reading; Close closes mb.done first so that they return.
+func (mb *MessageBus) send(msg InboundMessage) {
+	select {
+	case mb.inbound <- msg:
+	case <-mb.done:
+		logger.WarnCF("bus", "Dropping inbound message: bus is closed",
+			map[string]interface{}{
+				"channel": msg.Channel,
+				"chat_id": msg.ChatID,
+			})
+	}
 }
 
 func (mb *MessageBus) ConsumeInbound(ctx context.Context) (InboundMessage, bool) {
 	select {
 	case msg := <-mb.inbound:
+		if msg.ID != "" && mb.queue != nil {
+			if err := mb.queue.Deliver(msg.ID); err != nil {
+				logger.ErrorCF("bus", "Failed to record delivery",
+					map[string]interface{}{
+						"id":    msg.ID,
+						"error": err.Error(),
+					})
+			}
+		}
 		return msg, true
 	case <-ctx.Done():
 		return InboundMessage{}, false
 	}
 }
 
+// Ack marks an inbound message as fully processed. It is a no-op when the
+// bus has no durable queue.
+func (mb *MessageBus) Ack(msg InboundMessage) {
+	if msg.ID == "" || mb.queue == nil {
+		return
+	}
+	if err := mb.queue.Ack(msg.ID); err != nil {
+		logger.ErrorCF("bus", "Failed to acknowledge message",
+			map[string]interface{}{
+				"id":    msg.ID,
+				"error": err.Error(),
+			})
+	}
+}
+
+// Nack records that processing msg failed. It returns true if the message
+// will be redelivered, and false if it was dead-lettered or the bus has no
+// durable queue, in which case the caller should report the error.
+func (mb *MessageBus) Nack(msg InboundMessage, cause error) bool {
+	if msg.ID == "" || mb.queue == nil {
+		return false
+	}
+	retry, err := mb.queue.Fail(msg.ID, cause)
+	if err != nil {
+		logger.ErrorCF("bus", "Failed to record message failure",
+			map[string]interface{}{
+				"id":    msg.ID,
+				"error": err.Error(),
+			})
+	}
+	if !retry {
+		logger.WarnCF("bus", "Message moved to dead-letter queue",
+			map[string]interface{}{
+				"id":      msg.ID,
+				"channel": msg.Channel,
+			})
+		return false
+	}
+
+	time.AfterFunc(mb.retryDelay, func() { mb.push(msg) })
+	return true
+}
+
+// push hands an already-queued message to the consumer.
+func (mb *MessageBus) push(msg InboundMessage) {
+	mb.mu.RLock()
+	defer mb.mu.RUnlock()
+	if mb.closed {
+		return
+	}
+	mb.send(msg)
+}
+
+// OnOutbound registers fn to be called with every outbound message before
+// it is handed to the channels. fn must not block.
+func (mb *MessageBus) OnOutbound(fn func(OutboundMessage)) {
+	mb.mu.Lock()
+	defer mb.mu.Unlock()
+	mb.outObservers = append(mb.outObservers, fn)
+}
+
 func (mb *MessageBus) PublishOutbound(msg OutboundMessage) {
 	mb.mu.RLock()
 	defer mb.mu.RUnlock()
+	for _, fn := range mb.outObservers {
+		fn(msg)
+	}
 	if mb.closed {
 		return
 	}
-	mb.outbound <- msg
+	select {
+	case mb.outbound <- msg:
+	case <-mb.done:
+	}
 }
 
 func (mb *MessageBus) SubscribeOutbound(ctx context.Context) (OutboundMessage, bool) {
@@ -71,6 +263,8 @@ func (mb *MessageBus) GetHandler(channel string) (MessageHandler, bool) {
 }
 
 func (mb *MessageBus) Close() {
+	// Wake senders blocked on a full channel first: they hold mb.mu.
+	mb.closeOnce.Do(func() { close(mb.done) })
 	mb.mu.Lock()
 	defer mb.mu.Unlock()
 	if mb.closed {
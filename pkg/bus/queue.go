@@ -0,0 +1,431 @@
+package bus
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	walFile     = "inbound.wal"
+	deadDir     = "dead"
+	replayDir   = "replay"
+	compactSize = 1000 // WAL records written before the log is rewritten
+)
+
+// QueueEntry is an inbound message tracked by the durable queue.
+type QueueEntry struct {
+	ID           string         `json:"id"`
+	Message      InboundMessage `json:"message"`
+	Attempts     int            `json:"attempts"`
+	EnqueuedAtMS int64          `json:"enqueued_at_ms"`
+	LastError    string         `json:"last_error,omitempty"`
+	FailedAtMS   int64          `json:"failed_at_ms,omitempty"`
+}
+
+// walRecord is one line of the write-ahead log.
+type walRecord struct {
+	Op    string      `json:"op"` // enqueue, deliver, fail, ack, dead
+	ID    string      `json:"id"`
+	Entry *QueueEntry `json:"entry,omitempty"`
+	Error string      `json:"error,omitempty"`
+}
+
+// Queue is a durable store for inbound messages. Every message is appended to
+// a write-ahead log before the agent sees it and stays there until the turn
+// that handles it is acknowledged, so messages that were queued or being
+// processed when the gateway stopped are redelivered on the next start.
+// Messages that fail maxAttempts times are moved to the dead-letter directory.
+type Queue struct {
+	dir         string
+	maxAttempts int
+	wal         *os.File
+	pending     map[string]*QueueEntry
+	records     int
+	closed      bool
+	mu          sync.Mutex
+}
+
+// OpenQueue opens (or creates) the queue stored in dir. Pending entries that
+// already reached maxAttempts are moved to the dead-letter directory.
+func OpenQueue(dir string, maxAttempts int) (*Queue, error) {
+	if maxAttempts <= 0 {
+		maxAttempts = 3
+	}
+	for _, d := range []string{dir, filepath.Join(dir, deadDir), filepath.Join(dir, replayDir)} {
+		if err := os.MkdirAll(d, 0755); err != nil {
+			return nil, fmt.Errorf("failed to create queue directory: %w", err)
+		}
+	}
+
+	pending, err := readWAL(filepath.Join(dir, walFile))
+	if err != nil {
+		return nil, err
+	}
+
+	q := &Queue{
+		dir:         dir,
+		maxAttempts: maxAttempts,
+		pending:     pending,
+	}
+
+	for id, entry := range q.pending {
+		if entry.Attempts >= maxAttempts {
+			if entry.LastError == "" {
+				entry.LastError = "gateway stopped while processing"
+			}
+			if err := q.writeDeadLetter(entry); err != nil {
+				return nil, err
+			}
+			delete(q.pending, id)
+		}
+	}
+
+	if err := q.compact(); err != nil {
+		return nil, err
+	}
+	return q, nil
+}
+
+// Enqueue durably records a new message and returns its queue ID.
+func (q *Queue) Enqueue(msg InboundMessage) (string, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entry := &QueueEntry{
+		ID:           newQueueID(),
+		Message:      msg,
+		EnqueuedAtMS: time.Now().UnixMilli(),
+	}
+	entry.Message.ID = entry.ID
+	if err := q.append(walRecord{Op: "enqueue", ID: entry.ID, Entry: entry}); err != nil {
+		return "", err
+	}
+	q.pending[entry.ID] = entry
+	return entry.ID, nil
+}
+
+// Deliver records that a message was handed to the agent. Attempts are
+// counted on delivery so a message that crashes the gateway is eventually
+// dead-lettered instead of being redelivered forever.
+func (q *Queue) Deliver(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entry, ok := q.pending[id]
+	if !ok {
+		return nil
+	}
+	entry.Attempts++
+	return q.append(walRecord{Op: "deliver", ID: id})
+}
+
+// Ack removes a successfully processed message from the queue.
+func (q *Queue) Ack(id string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.pending[id]; !ok {
+		return nil
+	}
+	delete(q.pending, id)
+	if err := q.append(walRecord{Op: "ack", ID: id}); err != nil {
+		return err
+	}
+	if q.records >= compactSize {
+		return q.compact()
+	}
+	return nil
+}
+
+// Fail records a failed attempt. It returns true if the message should be
+// retried, or false if it was moved to the dead-letter directory.
+func (q *Queue) Fail(id string, cause error) (bool, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	entry, ok := q.pending[id]
+	if !ok {
+		return false, nil
+	}
+	if cause != nil {
+		entry.LastError = cause.Error()
+	}
+
+	if entry.Attempts < q.maxAttempts {
+		return true, q.append(walRecord{Op: "fail", ID: id, Error: entry.LastError})
+	}
+
+	if err := q.writeDeadLetter(entry); err != nil {
+		return false, err
+	}
+	delete(q.pending, id)
+	return false, q.append(walRecord{Op: "dead", ID: id, Error: entry.LastError})
+}
+
+// Pending returns the messages that have not been acknowledged yet, oldest first.
+func (q *Queue) Pending() []QueueEntry {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return sortedEntries(q.pending)
+}
+
+// ImportReplays moves dead letters queued for replay (see ReplayDeadLetter)
+// back into the pending set with a fresh attempt count and returns them.
+func (q *Queue) ImportReplays() ([]QueueEntry, error) {
+	files, err := filepath.Glob(filepath.Join(q.dir, replayDir, "*.json"))
+	if err != nil || len(files) == 0 {
+		return nil, err
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var imported []QueueEntry
+	for _, file := range files {
+		entry, err := readEntryFile(file)
+		if err != nil {
+			return imported, err
+		}
+		entry.Attempts = 0
+		entry.LastError = ""
+		entry.FailedAtMS = 0
+		entry.Message.ID = entry.ID
+		if err := q.append(walRecord{Op: "enqueue", ID: entry.ID, Entry: entry}); err != nil {
+			return imported, err
+		}
+		q.pending[entry.ID] = entry
+		os.Remove(file)
+		imported = append(imported, *entry)
+	}
+	return imported, nil
+}
+
+// Close flushes and closes the write-ahead log.
+func (q *Queue) Close() error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	if q.closed {
+		return nil
+	}
+	q.closed = true
+	return q.wal.Close()
+}
+
+// append writes a record to the WAL and syncs it to disk.
+// Must be called with the lock held.
+func (q *Queue) append(rec walRecord) error {
+	if q.closed {
+		return fmt.Errorf("queue is closed")
+	}
+	data, err := json.Marshal(rec)
+	if err != nil {
+		return fmt.Errorf("failed to marshal queue record: %w", err)
+	}
+	if _, err := q.wal.Write(append(data, '\n')); err != nil {
+		return fmt.Errorf("failed to write queue record: %w", err)
+	}
+	q.records++
+	return q.wal.Sync()
+}
+
+// compact rewrites the WAL so it only contains pending entries, using a temp
+// file + rename so a crash during compaction never loses the log.
+// Must be called with the lock held.
+func (q *Queue) compact() error {
+	path := filepath.Join(q.dir, walFile)
+	tmp := path + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to compact queue: %w", err)
+	}
+	w := bufio.NewWriter(f)
+	for _, entry := range sortedEntries(q.pending) {
+		data, _ := json.Marshal(walRecord{Op: "enqueue", ID: entry.ID, Entry: &entry})
+		w.Write(append(data, '\n'))
+	}
+	if err := w.Flush(); err == nil {
+		err = f.Sync()
+	}
+	f.Close()
+	if err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to compact queue: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to compact queue: %w", err)
+	}
+
+	if q.wal != nil {
+		q.wal.Close()
+	}
+	q.wal, err = os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open queue log: %w", err)
+	}
+	q.records = 0
+	return nil
+}
+
+func (q *Queue) writeDeadLetter(entry *QueueEntry) error {
+	entry.FailedAtMS = time.Now().UnixMilli()
+	data, err := json.MarshalIndent(entry, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal dead letter: %w", err)
+	}
+	path := filepath.Join(q.dir, deadDir, entry.ID+".json")
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return fmt.Errorf("failed to write dead letter: %w", err)
+	}
+	return nil
+}
+
+// ReadPending returns the unacknowledged messages recorded in the queue
+// directory without opening it for writing, so it is safe to call while the
+// gateway is running.
+func ReadPending(dir string) ([]QueueEntry, error) {
+	pending, err := readWAL(filepath.Join(dir, walFile))
+	if err != nil {
+		return nil, err
+	}
+	return sortedEntries(pending), nil
+}
+
+// ListDeadLetters returns the messages in the dead-letter directory, oldest
+// failure first.
+func ListDeadLetters(dir string) ([]QueueEntry, error) {
+	files, err := filepath.Glob(filepath.Join(dir, deadDir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	entries := make([]QueueEntry, 0, len(files))
+	for _, file := range files {
+		entry, err := readEntryFile(file)
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].FailedAtMS < entries[j].FailedAtMS
+	})
+	return entries, nil
+}
+
+// ReplayDeadLetter schedules a dead letter for redelivery. A running gateway
+// picks it up within a few seconds; otherwise it is delivered on next start.
+func ReplayDeadLetter(dir, id string) error {
+	src, err := deadLetterPath(dir, id)
+	if err != nil {
+		return err
+	}
+	return os.Rename(src, filepath.Join(dir, replayDir, filepath.Base(src)))
+}
+
+// RemoveDeadLetter permanently deletes a dead letter.
+func RemoveDeadLetter(dir, id string) error {
+	path, err := deadLetterPath(dir, id)
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+func deadLetterPath(dir, id string) (string, error) {
+	if id == "" || strings.ContainsAny(id, `/\.`) {
+		return "", fmt.Errorf("invalid message id: %q", id)
+	}
+	path := filepath.Join(dir, deadDir, id+".json")
+	if _, err := os.Stat(path); err != nil {
+		return "", fmt.Errorf("dead letter %s not found", id)
+	}
+	return path, nil
+}
+
+// readWAL replays the log and returns the pending entries. A truncated last
+// line (from a crash mid-write) is ignored.
+func readWAL(path string) (map[string]*QueueEntry, error) {
+	pending := make(map[string]*QueueEntry)
+
+	f, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return pending, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open queue log: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+	for scanner.Scan() {
+		var rec walRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			continue
+		}
+		switch rec.Op {
+		case "enqueue":
+			if rec.Entry != nil {
+				pending[rec.ID] = rec.Entry
+			}
+		case "deliver":
+			if entry, ok := pending[rec.ID]; ok {
+				entry.Attempts++
+			}
+		case "fail":
+			if entry, ok := pending[rec.ID]; ok {
+				entry.LastError = rec.Error
+			}
+		case "ack", "dead":
+			delete(pending, rec.ID)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("failed to read queue log: %w", err)
+	}
+	return pending, nil
+}
+
+func readEntryFile(path string) (*QueueEntry, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var entry QueueEntry
+	if err := json.Unmarshal(data, &entry); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", filepath.Base(path), err)
+	}
+	return &entry, nil
+}
+
+func sortedEntries(m map[string]*QueueEntry) []QueueEntry {
+	entries := make([]QueueEntry, 0, len(m))
+	for _, entry := range m {
+		entries = append(entries, *entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].EnqueuedAtMS != entries[j].EnqueuedAtMS {
+			return entries[i].EnqueuedAtMS < entries[j].EnqueuedAtMS
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	return entries
+}
+
+func newQueueID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%d", time.Now().UnixNano())
+	}
+	return hex.EncodeToString(b)
+}
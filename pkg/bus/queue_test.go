@@ -0,0 +1,208 @@
+package bus
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestQueue_RedeliversUnackedAfterReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	q, err := OpenQueue(dir, 3)
+	if err != nil {
+		t.Fatalf("OpenQueue failed: %v", err)
+	}
+	done, _ := q.Enqueue(InboundMessage{Channel: "telegram", ChatID: "1", Content: "done"})
+	inFlight, _ := q.Enqueue(InboundMessage{Channel: "telegram", ChatID: "1", Content: "in flight"})
+	q.Deliver(done)
+	q.Deliver(inFlight)
+	q.Ack(done)
+	q.Close()
+
+	q, err = OpenQueue(dir, 3)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer q.Close()
+
+	pending := q.Pending()
+	if len(pending) != 1 {
+		t.Fatalf("expected 1 pending message, got %d", len(pending))
+	}
+	if pending[0].ID != inFlight || pending[0].Message.Content != "in flight" {
+		t.Errorf("unexpected pending entry: %+v", pending[0])
+	}
+	if pending[0].Attempts != 1 {
+		t.Errorf("expected 1 attempt to survive restart, got %d", pending[0].Attempts)
+	}
+	if pending[0].Message.ID != inFlight {
+		t.Errorf("redelivered message should carry its queue ID")
+	}
+}
+
+func TestQueue_DeadLetterAndReplay(t *testing.T) {
+	dir := t.TempDir()
+
+	q, err := OpenQueue(dir, 2)
+	if err != nil {
+		t.Fatalf("OpenQueue failed: %v", err)
+	}
+	defer q.Close()
+
+	id, _ := q.Enqueue(InboundMessage{Channel: "slack", Content: "boom"})
+
+	q.Deliver(id)
+	if retry, _ := q.Fail(id, errors.New("first")); !retry {
+		t.Fatal("first failure should be retried")
+	}
+	q.Deliver(id)
+	if retry, _ := q.Fail(id, errors.New("second")); retry {
+		t.Fatal("failure at max attempts should dead-letter")
+	}
+
+	if len(q.Pending()) != 0 {
+		t.Fatal("dead-lettered message should not be pending")
+	}
+	dead, err := ListDeadLetters(dir)
+	if err != nil || len(dead) != 1 {
+		t.Fatalf("expected 1 dead letter, got %d (err=%v)", len(dead), err)
+	}
+	if dead[0].LastError != "second" {
+		t.Errorf("expected last error %q, got %q", "second", dead[0].LastError)
+	}
+
+	if err := ReplayDeadLetter(dir, id); err != nil {
+		t.Fatalf("ReplayDeadLetter failed: %v", err)
+	}
+	replayed, err := q.ImportReplays()
+	if err != nil || len(replayed) != 1 {
+		t.Fatalf("expected 1 replayed message, got %d (err=%v)", len(replayed), err)
+	}
+	if replayed[0].Attempts != 0 {
+		t.Errorf("replay should reset attempts, got %d", replayed[0].Attempts)
+	}
+	if dead, _ := ListDeadLetters(dir); len(dead) != 0 {
+		t.Errorf("replayed message should leave the dead-letter directory")
+	}
+}
+
+func TestQueue_CrashLoopIsDeadLetteredOnOpen(t *testing.T) {
+	dir := t.TempDir()
+
+	q, _ := OpenQueue(dir, 1)
+	id, _ := q.Enqueue(InboundMessage{Content: "crashes the gateway"})
+	q.Deliver(id)
+	q.Close()
+
+	q, err := OpenQueue(dir, 1)
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer q.Close()
+
+	if len(q.Pending()) != 0 {
+		t.Fatal("message at max attempts should not be redelivered")
+	}
+	if dead, _ := ListDeadLetters(dir); len(dead) != 1 {
+		t.Fatalf("expected message in dead-letter directory, got %d", len(dead))
+	}
+}
+
+func TestMessageBus_NackRedelivers(t *testing.T) {
+	q, err := OpenQueue(t.TempDir(), 3)
+	if err != nil {
+		t.Fatalf("OpenQueue failed: %v", err)
+	}
+	defer q.Close()
+
+	mb := NewMessageBus()
+	mb.retryDelay = time.Millisecond
+	mb.SetQueue(q)
+
+	mb.PublishInbound(InboundMessage{Channel: "telegram", Content: "hi"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	msg, ok := mb.ConsumeInbound(ctx)
+	if !ok || msg.ID == "" {
+		t.Fatalf("expected queued message with ID, got %+v", msg)
+	}
+	if !mb.Nack(msg, errors.New("provider down")) {
+		t.Fatal("expected message to be retried")
+	}
+
+	again, ok := mb.ConsumeInbound(ctx)
+	if !ok || again.ID != msg.ID {
+		t.Fatalf("expected redelivery of %s, got %+v", msg.ID, again)
+	}
+	mb.Ack(again)
+
+	if len(q.Pending()) != 0 {
+		t.Error("acked message should not be pending")
+	}
+}
+
+func TestMessageBus_RunQueueRedeliversOnlyEarlierMessages(t *testing.T) {
+	dir := t.TempDir()
+	q, err := OpenQueue(dir, 3)
+	if err != nil {
+		t.Fatalf("OpenQueue failed: %v", err)
+	}
+	q.Enqueue(InboundMessage{Channel: "telegram", Content: "left over"})
+	q.Close()
+	if q, err = OpenQueue(dir, 3); err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+	defer q.Close()
+
+	mb := NewMessageBus()
+	mb.SetQueue(q)
+	// A channel publishes before RunQueue starts.
+	mb.PublishInbound(InboundMessage{Channel: "telegram", Content: "new"})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go mb.RunQueue(ctx)
+
+	seen := map[string]int{}
+	for {
+		readCtx, readCancel := context.WithTimeout(ctx, 100*time.Millisecond)
+		msg, ok := mb.ConsumeInbound(readCtx)
+		readCancel()
+		if !ok {
+			break
+		}
+		seen[msg.Content]++
+	}
+	if seen["left over"] != 1 || seen["new"] != 1 {
+		t.Errorf("expected each message once, got %v", seen)
+	}
+}
+
+func TestMessageBus_CloseUnblocksPublishers(t *testing.T) {
+	mb := NewMessageBus()
+	for i := 0; i < cap(mb.inbound); i++ {
+		mb.PublishInbound(InboundMessage{Channel: "telegram"})
+	}
+	blocked := make(chan struct{})
+	go func() {
+		mb.PublishInbound(InboundMessage{Channel: "telegram"})
+		close(blocked)
+	}()
+	time.Sleep(20 * time.Millisecond)
+
+	closed := make(chan struct{})
+	go func() {
+		mb.Close()
+		close(closed)
+	}()
+	select {
+	case <-closed:
+	case <-time.After(time.Second):
+		t.Fatal("Close blocked behind a publisher waiting on a full channel")
+	}
+	<-blocked
+}
@@ -1,6 +1,7 @@
 package bus
 
 type InboundMessage struct {
+	ID         string            `json:"id,omitempty"` // durable queue ID; empty when the queue is disabled
 	Channel    string            `json:"channel"`
 	SenderID   string            `json:"sender_id"`
 	ChatID     string            `json:"chat_id"`
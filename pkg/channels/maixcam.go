@@ -165,13 +165,14 @@ func (c *MaixCamChannel) handlePersonDetection(msg MaixCamMessage) {
 		classInfo, score*100, x, y, w, h)
 
 	metadata := map[string]string{
-		"timestamp": fmt.Sprintf("%.0f", msg.Timestamp),
-		"class_id":  fmt.Sprintf("%.0f", msg.Data["class_id"]),
-		"score":     fmt.Sprintf("%.2f", score),
-		"x":         fmt.Sprintf("%.0f", x),
-		"y":         fmt.Sprintf("%.0f", y),
-		"w":         fmt.Sprintf("%.0f", w),
-		"h":         fmt.Sprintf("%.0f", h),
+		"timestamp":  fmt.Sprintf("%.0f", msg.Timestamp),
+		"class_id":   fmt.Sprintf("%.0f", msg.Data["class_id"]),
+		"class_name": classInfo,
+		"score":      fmt.Sprintf("%.2f", score),
+		"x":          fmt.Sprintf("%.0f", x),
+		"y":          fmt.Sprintf("%.0f", y),
+		"w":          fmt.Sprintf("%.0f", w),
+		"h":          fmt.Sprintf("%.0f", h),
 	}
 
 	c.HandleMessage(senderID, chatID, content, []string{}, metadata)
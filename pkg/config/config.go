@@ -44,18 +44,76 @@ func (f *FlexibleStringSlice) UnmarshalJSON(data []byte) error {
 }
 
 type Config struct {
-	Agents    AgentsConfig    `json:"agents"`
-	Channels  ChannelsConfig  `json:"channels"`
-	Providers ProvidersConfig `json:"providers"`
-	Gateway   GatewayConfig   `json:"gateway"`
-	Tools     ToolsConfig     `json:"tools"`
-	Heartbeat HeartbeatConfig `json:"heartbeat"`
-	Devices   DevicesConfig   `json:"devices"`
-	mu        sync.RWMutex
+	Agents      AgentsConfig      `json:"agents"`
+	Channels    ChannelsConfig    `json:"channels"`
+	Providers   ProvidersConfig   `json:"providers"`
+	Gateway     GatewayConfig     `json:"gateway"`
+	Tools       ToolsConfig       `json:"tools"`
+	Heartbeat   HeartbeatConfig   `json:"heartbeat"`
+	Devices     DevicesConfig     `json:"devices"`
+	Permissions PermissionsConfig `json:"permissions"`
+	Memory      MemoryConfig      `json:"memory"`
+	mu          sync.RWMutex
 }
 
 type AgentsConfig struct {
-	Defaults AgentDefaults `json:"defaults"`
+	Defaults AgentDefaults           `json:"defaults"`
+	Profiles map[string]AgentProfile `json:"profiles,omitempty"`
+	Bindings []AgentBinding          `json:"bindings,omitempty"`
+	// Instances are additional agents hosted by the gateway next to the
+	// default one ("main"), each with its own workspace, memory, skills,
+	// sessions and provider. Routes decide which agent serves a message.
+	Instances map[string]AgentInstance `json:"instances,omitempty"`
+	Routes    []AgentRoute             `json:"routes,omitempty"`
+}
+
+// DefaultAgentName is the name of the agent configured by agents.defaults.
+const DefaultAgentName = "main"
+
+// AgentInstance overrides the agent defaults for a separate agent.
+// Unset fields are inherited from agents.defaults.
+type AgentInstance struct {
+	Workspace         string   `json:"workspace,omitempty"` // default: "workspace-<name>" next to the main workspace
+	Provider          string   `json:"provider,omitempty"`
+	Model             string   `json:"model,omitempty"`
+	MaxTokens         int      `json:"max_tokens,omitempty"`
+	Temperature       *float64 `json:"temperature,omitempty"`
+	MaxToolIterations int      `json:"max_tool_iterations,omitempty"`
+	MaxSubagents      int      `json:"max_subagents,omitempty"`
+}
+
+// AgentRoute sends messages matching every set field to an agent.
+// Routes are checked in order; the first match wins.
+type AgentRoute struct {
+	Channel string `json:"channel,omitempty"` // empty or "*" matches any channel
+	ChatID  string `json:"chat_id,omitempty"`
+	Sender  string `json:"sender,omitempty"` // sender ID or username
+	Agent   string `json:"agent"`
+}
+
+// AgentProfile is a named agent with its own prompt, model and toolset.
+// Unset fields fall back to the agent defaults.
+type AgentProfile struct {
+	Description       string   `json:"description,omitempty"`
+	PromptFile        string   `json:"prompt_file,omitempty"` // relative to the workspace
+	Model             string   `json:"model,omitempty"`
+	MaxTokens         int      `json:"max_tokens,omitempty"`
+	Temperature       *float64 `json:"temperature,omitempty"`
+	MaxToolIterations int      `json:"max_tool_iterations,omitempty"`
+	// Tools and Skills limit what the agent can use; empty allows everything.
+	// Entries accept exact names, "prefix*" patterns, or "*".
+	Tools  []string `json:"tools,omitempty"`
+	Skills []string `json:"skills,omitempty"`
+	// Workspace is a subdirectory of the main workspace that this agent
+	// works in, in bound chats and when spawned.
+	Workspace string `json:"workspace,omitempty"`
+}
+
+// AgentBinding routes a channel, or a single chat on it, to a named agent.
+type AgentBinding struct {
+	Channel string `json:"channel"`
+	ChatID  string `json:"chat_id,omitempty"` // empty or "*" binds the whole channel
+	Agent   string `json:"agent"`
 }
 
 type AgentDefaults struct {
@@ -66,6 +124,8 @@ type AgentDefaults struct {
 	MaxTokens           int     `json:"max_tokens" env:"PICOCLAW_AGENTS_DEFAULTS_MAX_TOKENS"`
 	Temperature         float64 `json:"temperature" env:"PICOCLAW_AGENTS_DEFAULTS_TEMPERATURE"`
 	MaxToolIterations   int     `json:"max_tool_iterations" env:"PICOCLAW_AGENTS_DEFAULTS_MAX_TOOL_ITERATIONS"`
+	MaxSubagents        int     `json:"max_subagents" env:"PICOCLAW_AGENTS_DEFAULTS_MAX_SUBAGENTS"`           // concurrent spawn tasks; 0 = unlimited
+	MaxParallelTools    int     `json:"max_parallel_tools" env:"PICOCLAW_AGENTS_DEFAULTS_MAX_PARALLEL_TOOLS"` // 0 = default (4), 1 = sequential
 }
 
 type ChannelsConfig struct {
@@ -166,33 +226,82 @@ type DevicesConfig struct {
 	MonitorUSB bool `json:"monitor_usb" env:"PICOCLAW_DEVICES_MONITOR_USB"`
 }
 
+// PermissionsConfig maps senders to named roles. When disabled, every sender
+// that passes a channel's allow_from list has full access.
+type PermissionsConfig struct {
+	Enabled     bool                  `json:"enabled" env:"PICOCLAW_PERMISSIONS_ENABLED"`
+	DefaultRole string                `json:"default_role" env:"PICOCLAW_PERMISSIONS_DEFAULT_ROLE"`
+	TriggerRole string                `json:"trigger_role,omitempty" env:"PICOCLAW_PERMISSIONS_TRIGGER_ROLE"`
+	Roles       map[string]RoleConfig `json:"roles,omitempty"`
+}
+
+// RoleConfig describes what members of a role may use. Members are either a
+// bare sender ID (any channel) or "channel:sender_id"; "*" matches anything.
+// Each grant list accepts exact names, "prefix*" patterns, or "*" for all.
+type RoleConfig struct {
+	Members  FlexibleStringSlice `json:"members"`
+	Tools    []string            `json:"tools"`
+	Skills   []string            `json:"skills"`
+	Commands []string            `json:"commands"`
+	Models   []string            `json:"models"`
+}
+
 type ProvidersConfig struct {
-	Anthropic     ProviderConfig `json:"anthropic"`
-	OpenAI        ProviderConfig `json:"openai"`
-	OpenRouter    ProviderConfig `json:"openrouter"`
-	Groq          ProviderConfig `json:"groq"`
-	Zhipu         ProviderConfig `json:"zhipu"`
-	VLLM          ProviderConfig `json:"vllm"`
-	Gemini        ProviderConfig `json:"gemini"`
-	Nvidia        ProviderConfig `json:"nvidia"`
-	Ollama        ProviderConfig `json:"ollama"`
-	Moonshot      ProviderConfig `json:"moonshot"`
-	ShengSuanYun  ProviderConfig `json:"shengsuanyun"`
-	DeepSeek      ProviderConfig `json:"deepseek"`
-	GitHubCopilot ProviderConfig `json:"github_copilot"`
+	Anthropic     ProviderConfig `json:"anthropic" envPrefix:"PICOCLAW_PROVIDERS_ANTHROPIC_"`
+	OpenAI        ProviderConfig `json:"openai" envPrefix:"PICOCLAW_PROVIDERS_OPENAI_"`
+	OpenRouter    ProviderConfig `json:"openrouter" envPrefix:"PICOCLAW_PROVIDERS_OPENROUTER_"`
+	Groq          ProviderConfig `json:"groq" envPrefix:"PICOCLAW_PROVIDERS_GROQ_"`
+	Zhipu         ProviderConfig `json:"zhipu" envPrefix:"PICOCLAW_PROVIDERS_ZHIPU_"`
+	VLLM          ProviderConfig `json:"vllm" envPrefix:"PICOCLAW_PROVIDERS_VLLM_"`
+	Gemini        ProviderConfig `json:"gemini" envPrefix:"PICOCLAW_PROVIDERS_GEMINI_"`
+	Nvidia        ProviderConfig `json:"nvidia" envPrefix:"PICOCLAW_PROVIDERS_NVIDIA_"`
+	Ollama        ProviderConfig `json:"ollama" envPrefix:"PICOCLAW_PROVIDERS_OLLAMA_"`
+	Moonshot      ProviderConfig `json:"moonshot" envPrefix:"PICOCLAW_PROVIDERS_MOONSHOT_"`
+	ShengSuanYun  ProviderConfig `json:"shengsuanyun" envPrefix:"PICOCLAW_PROVIDERS_SHENGSUANYUN_"`
+	DeepSeek      ProviderConfig `json:"deepseek" envPrefix:"PICOCLAW_PROVIDERS_DEEPSEEK_"`
+	GitHubCopilot ProviderConfig `json:"github_copilot" envPrefix:"PICOCLAW_PROVIDERS_GITHUB_COPILOT_"`
 }
 
+// ProviderConfig is read from PICOCLAW_PROVIDERS_<NAME>_API_KEY and so on,
+// with the prefix set on each ProvidersConfig field.
 type ProviderConfig struct {
-	APIKey      string `json:"api_key" env:"PICOCLAW_PROVIDERS_{{.Name}}_API_KEY"`
-	APIBase     string `json:"api_base" env:"PICOCLAW_PROVIDERS_{{.Name}}_API_BASE"`
-	Proxy       string `json:"proxy,omitempty" env:"PICOCLAW_PROVIDERS_{{.Name}}_PROXY"`
-	AuthMethod  string `json:"auth_method,omitempty" env:"PICOCLAW_PROVIDERS_{{.Name}}_AUTH_METHOD"`
-	ConnectMode string `json:"connect_mode,omitempty" env:"PICOCLAW_PROVIDERS_{{.Name}}_CONNECT_MODE"` //only for Github Copilot, `stdio` or `grpc`
+	APIKey      string `json:"api_key" env:"API_KEY"`
+	APIBase     string `json:"api_base" env:"API_BASE"`
+	Proxy       string `json:"proxy,omitempty" env:"PROXY"`
+	AuthMethod  string `json:"auth_method,omitempty" env:"AUTH_METHOD"`
+	ConnectMode string `json:"connect_mode,omitempty" env:"CONNECT_MODE"` //only for Github Copilot, `stdio` or `grpc`
+}
+
+// MemoryConfig controls memory retrieval. Instead of the whole of MEMORY.md,
+// the snippets most relevant to the current message are added to the
+// prompt, found in memory files, daily notes and past session summaries.
+type MemoryConfig struct {
+	TopK           int    `json:"top_k" env:"PICOCLAW_MEMORY_TOP_K"`
+	Embeddings     bool   `json:"embeddings" env:"PICOCLAW_MEMORY_EMBEDDINGS"` // combine BM25 with provider embeddings
+	EmbeddingModel string `json:"embedding_model" env:"PICOCLAW_MEMORY_EMBEDDING_MODEL"`
+	// Consolidate distills daily notes older than ConsolidateAfterDays and
+	// new session summaries into MEMORY.md once a day, with the heartbeat.
+	Consolidate          bool `json:"consolidate" env:"PICOCLAW_MEMORY_CONSOLIDATE"`
+	ConsolidateAfterDays int  `json:"consolidate_after_days" env:"PICOCLAW_MEMORY_CONSOLIDATE_AFTER_DAYS"`
+	// PerUser keeps a profile and memory per person (and per group chat)
+	// instead of one for the whole workspace. Identities links the accounts
+	// of one person across channels: name -> ["channel:sender_id", ...].
+	PerUser    bool                `json:"per_user" env:"PICOCLAW_MEMORY_PER_USER"`
+	Identities map[string][]string `json:"identities,omitempty"`
 }
 
 type GatewayConfig struct {
-	Host string `json:"host" env:"PICOCLAW_GATEWAY_HOST"`
-	Port int    `json:"port" env:"PICOCLAW_GATEWAY_PORT"`
+	Host  string      `json:"host" env:"PICOCLAW_GATEWAY_HOST"`
+	Port  int         `json:"port" env:"PICOCLAW_GATEWAY_PORT"`
+	Queue QueueConfig `json:"queue"`
+}
+
+// QueueConfig controls the durable inbound queue. When enabled, inbound
+// messages are logged to <workspace>/queue before processing and survive a
+// gateway crash or restart.
+type QueueConfig struct {
+	Enabled     bool `json:"enabled" env:"PICOCLAW_GATEWAY_QUEUE_ENABLED"`
+	MaxAttempts int  `json:"max_attempts" env:"PICOCLAW_GATEWAY_QUEUE_MAX_ATTEMPTS"`
 }
 
 type BraveConfig struct {
@@ -226,6 +335,8 @@ func DefaultConfig() *Config {
 				MaxTokens:           8192,
 				Temperature:         0.7,
 				MaxToolIterations:   20,
+				MaxSubagents:        4,
+				MaxParallelTools:    4,
 			},
 		},
 		Channels: ChannelsConfig{
@@ -309,6 +420,10 @@ func DefaultConfig() *Config {
 		Gateway: GatewayConfig{
 			Host: "0.0.0.0",
 			Port: 18790,
+			Queue: QueueConfig{
+				Enabled:     false,
+				MaxAttempts: 3,
+			},
 		},
 		Tools: ToolsConfig{
 			Web: WebToolsConfig{
@@ -331,6 +446,33 @@ func DefaultConfig() *Config {
 			Enabled:    false,
 			MonitorUSB: true,
 		},
+		Memory: MemoryConfig{
+			TopK:                 5,
+			Embeddings:           false,
+			EmbeddingModel:       "text-embedding-3-small",
+			Consolidate:          true,
+			ConsolidateAfterDays: 7,
+		},
+		Permissions: PermissionsConfig{
+			Enabled:     false,
+			DefaultRole: "guest",
+			Roles: map[string]RoleConfig{
+				"admin": {
+					Members:  FlexibleStringSlice{},
+					Tools:    []string{"*"},
+					Skills:   []string{"*"},
+					Commands: []string{"*"},
+					Models:   []string{"*"},
+				},
+				"guest": {
+					Members:  FlexibleStringSlice{},
+					Tools:    []string{"web_search", "web_fetch", "message"},
+					Skills:   []string{},
+					Commands: []string{"/show", "/list"},
+					Models:   []string{},
+				},
+			},
+		},
 	}
 }
 
@@ -373,6 +515,64 @@ func SaveConfig(path string, cfg *Config) error {
 	return os.WriteFile(path, data, 0600)
 }
 
+// ForAgent returns the configuration of a named agent: a copy of c whose
+// agent defaults are overridden by the agent's instance settings. The
+// default agent's configuration is c itself.
+func (c *Config) ForAgent(name string) (*Config, error) {
+	if name == DefaultAgentName {
+		return c, nil
+	}
+
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	inst, ok := c.Agents.Instances[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown agent %q", name)
+	}
+	if name == "" || !filepath.IsLocal(name) {
+		return nil, fmt.Errorf("invalid agent name %q", name)
+	}
+
+	out := &Config{
+		Agents:      c.Agents,
+		Channels:    c.Channels,
+		Providers:   c.Providers,
+		Gateway:     c.Gateway,
+		Tools:       c.Tools,
+		Heartbeat:   c.Heartbeat,
+		Devices:     c.Devices,
+		Permissions: c.Permissions,
+		Memory:      c.Memory,
+	}
+
+	d := &out.Agents.Defaults
+	if inst.Workspace != "" {
+		d.Workspace = inst.Workspace
+	} else {
+		d.Workspace = filepath.Join(filepath.Dir(expandHome(c.Agents.Defaults.Workspace)), "workspace-"+name)
+	}
+	if inst.Provider != "" {
+		d.Provider = inst.Provider
+	}
+	if inst.Model != "" {
+		d.Model = inst.Model
+	}
+	if inst.MaxTokens > 0 {
+		d.MaxTokens = inst.MaxTokens
+	}
+	if inst.Temperature != nil {
+		d.Temperature = *inst.Temperature
+	}
+	if inst.MaxToolIterations > 0 {
+		d.MaxToolIterations = inst.MaxToolIterations
+	}
+	if inst.MaxSubagents > 0 {
+		d.MaxSubagents = inst.MaxSubagents
+	}
+	return out, nil
+}
+
 func (c *Config) WorkspacePath() string {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
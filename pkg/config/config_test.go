@@ -174,6 +174,32 @@ func TestSaveConfig_FilePermissions(t *testing.T) {
 	}
 }
 
+// TestLoadConfig_ProviderEnv verifies each provider reads its own
+// environment variables.
+func TestLoadConfig_ProviderEnv(t *testing.T) {
+	t.Setenv("PICOCLAW_PROVIDERS_OPENAI_API_KEY", "sk-openai")
+	t.Setenv("PICOCLAW_PROVIDERS_GITHUB_COPILOT_CONNECT_MODE", "grpc")
+
+	path := filepath.Join(t.TempDir(), "config.json")
+	if err := os.WriteFile(path, []byte(`{"providers": {"anthropic": {"api_key": "from-file"}}}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		t.Fatalf("LoadConfig failed: %v", err)
+	}
+
+	if cfg.Providers.OpenAI.APIKey != "sk-openai" {
+		t.Errorf("OpenAI.APIKey = %q, want sk-openai", cfg.Providers.OpenAI.APIKey)
+	}
+	if cfg.Providers.Anthropic.APIKey != "from-file" {
+		t.Errorf("Anthropic.APIKey = %q, want the value from the file", cfg.Providers.Anthropic.APIKey)
+	}
+	if cfg.Providers.GitHubCopilot.ConnectMode != "grpc" {
+		t.Errorf("GitHubCopilot.ConnectMode = %q, want grpc", cfg.Providers.GitHubCopilot.ConnectMode)
+	}
+}
+
 // TestConfig_Complete verifies all config fields are set
 func TestConfig_Complete(t *testing.T) {
 	cfg := DefaultConfig()
@@ -204,3 +230,42 @@ func TestConfig_Complete(t *testing.T) {
 		t.Error("Heartbeat should be enabled by default")
 	}
 }
+
+// TestForAgent verifies instance settings override the agent defaults
+func TestForAgent(t *testing.T) {
+	cfg := DefaultConfig()
+	cfg.Agents.Defaults.Workspace = "/data/workspace"
+	temperature := 0.1
+	cfg.Agents.Instances = map[string]AgentInstance{
+		"family": {Model: "cheap-model", Temperature: &temperature},
+		"work":   {Workspace: "/srv/work", Provider: "openai"},
+	}
+
+	if main, err := cfg.ForAgent(DefaultAgentName); err != nil || main != cfg {
+		t.Errorf("expected the default agent to use the config itself, got %v (err=%v)", main, err)
+	}
+
+	family, err := cfg.ForAgent("family")
+	if err != nil {
+		t.Fatalf("ForAgent failed: %v", err)
+	}
+	if got := family.WorkspacePath(); got != filepath.Join("/data", "workspace-family") {
+		t.Errorf("expected a sibling workspace, got %q", got)
+	}
+	d := family.Agents.Defaults
+	if d.Model != "cheap-model" || d.Temperature != 0.1 || d.MaxTokens != cfg.Agents.Defaults.MaxTokens {
+		t.Errorf("unexpected family defaults: %+v", d)
+	}
+
+	work, _ := cfg.ForAgent("work")
+	if work.WorkspacePath() != "/srv/work" || work.Agents.Defaults.Provider != "openai" || work.Agents.Defaults.Model != cfg.Agents.Defaults.Model {
+		t.Errorf("unexpected work defaults: %+v", work.Agents.Defaults)
+	}
+	if cfg.Agents.Defaults.Model == "cheap-model" || cfg.WorkspacePath() != "/data/workspace" {
+		t.Error("ForAgent must not modify the original config")
+	}
+
+	if _, err := cfg.ForAgent("nobody"); err == nil {
+		t.Error("expected an error for an unknown agent")
+	}
+}
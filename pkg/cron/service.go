@@ -1,9 +1,12 @@
 package cron
 
 import (
+	"context"
 	"crypto/rand"
+	"crypto/sha256"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"os"
@@ -12,14 +15,75 @@ import (
 	"time"
 
 	"github.com/adhocore/gronx"
+
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+// Output routing modes for a job's result.
+const (
+	OutputChat     = "chat"      // always send the result to the job's chat (default)
+	OutputOnChange = "on_change" // send only when the result differs from the previous run
+	OutputOnError  = "on_error"  // send only when the run fails
+	OutputFile     = "file"      // append the result to a file in the workspace
+	OutputSilent   = "silent"    // keep the result in the job state only
+)
+
+// maxStoredOutput caps how much of a run's output is kept in CronJobState.
+const maxStoredOutput = 4000
+
+// Concurrency policies: what to do when a job is due while its previous run
+// is still going.
+const (
+	ConcurrencySkip  = "skip"  // drop the new run (default)
+	ConcurrencyQueue = "queue" // run again as soon as the current run finishes
+)
+
+// Missed-run policies: what to do with runs that fell due while the gateway
+// was not running.
+const (
+	MissedRunSkip = "skip"     // wait for the next scheduled time (default)
+	MissedRunOnce = "run_once" // run once immediately on startup
 )
 
+const (
+	// DefaultJobTimeout bounds a run when the job sets no timeout.
+	DefaultJobTimeout = 10 * time.Minute
+	// defaultRetryBackoff is the first retry delay; it doubles on each retry.
+	defaultRetryBackoff = 30 * time.Second
+	// maxHistory is the number of runs kept per job.
+	maxHistory = 50
+	// maxHistoryOutput caps the output excerpt stored with each run.
+	maxHistoryOutput = 500
+)
+
+// ErrJobTimeout is returned for runs that exceed their timeout.
+var ErrJobTimeout = errors.New("job timed out")
+
+// ValidOutputMode reports whether mode is a known output routing mode.
+// The empty string is valid and means OutputChat.
+func ValidOutputMode(mode string) bool {
+	switch mode {
+	case "", OutputChat, OutputOnChange, OutputOnError, OutputFile, OutputSilent:
+		return true
+	}
+	return false
+}
+
+// OutputHash returns the fingerprint used to detect changed output.
+func OutputHash(output string) string {
+	sum := sha256.Sum256([]byte(output))
+	return hex.EncodeToString(sum[:])
+}
+
+// CronSchedule says when a job runs. Kind is "at", "every", "cron", or
+// "event" for jobs fired by a Trigger instead of the clock.
 type CronSchedule struct {
-	Kind    string `json:"kind"`
-	AtMS    *int64 `json:"atMs,omitempty"`
-	EveryMS *int64 `json:"everyMs,omitempty"`
-	Expr    string `json:"expr,omitempty"`
-	TZ      string `json:"tz,omitempty"`
+	Kind    string       `json:"kind"`
+	AtMS    *int64       `json:"atMs,omitempty"`
+	EveryMS *int64       `json:"everyMs,omitempty"`
+	Expr    string       `json:"expr,omitempty"`
+	TZ      string       `json:"tz,omitempty"`
+	Trigger *CronTrigger `json:"trigger,omitempty"`
 }
 
 type CronPayload struct {
@@ -29,13 +93,90 @@ type CronPayload struct {
 	Deliver bool   `json:"deliver"`
 	Channel string `json:"channel,omitempty"`
 	To      string `json:"to,omitempty"`
+
+	// Output selects where the result goes; see the Output* constants.
+	Output string `json:"output,omitempty"`
+	// OutputFile is the workspace-relative file used when Output is "file".
+	OutputFile string `json:"outputFile,omitempty"`
+	// Role is the permission role of the sender who created the job. The job
+	// runs with it; empty means picoclaw itself created the job.
+	Role string `json:"role,omitempty"`
+}
+
+// CronPolicy controls how a job is run. The zero value uses the defaults.
+type CronPolicy struct {
+	TimeoutSec      int    `json:"timeoutSec,omitempty"`
+	MaxRetries      int    `json:"maxRetries,omitempty"`
+	RetryBackoffSec int    `json:"retryBackoffSec,omitempty"`
+	Concurrency     string `json:"concurrency,omitempty"`
+	MissedRun       string `json:"missedRun,omitempty"`
+}
+
+// Validate checks the policy's enum values and limits.
+func (p CronPolicy) Validate() error {
+	if p.TimeoutSec < 0 || p.MaxRetries < 0 || p.RetryBackoffSec < 0 {
+		return fmt.Errorf("timeout, retries and backoff must not be negative")
+	}
+	switch p.Concurrency {
+	case "", ConcurrencySkip, ConcurrencyQueue:
+	default:
+		return fmt.Errorf("unknown concurrency policy: %s", p.Concurrency)
+	}
+	switch p.MissedRun {
+	case "", MissedRunSkip, MissedRunOnce:
+	default:
+		return fmt.Errorf("unknown missed-run policy: %s", p.MissedRun)
+	}
+	return nil
+}
+
+func (p CronPolicy) timeout() time.Duration {
+	if p.TimeoutSec > 0 {
+		return time.Duration(p.TimeoutSec) * time.Second
+	}
+	return DefaultJobTimeout
+}
+
+// retryDelay returns the delay before retry number n (starting at 1).
+func (p CronPolicy) retryDelay(n int) time.Duration {
+	base := defaultRetryBackoff
+	if p.RetryBackoffSec > 0 {
+		base = time.Duration(p.RetryBackoffSec) * time.Second
+	}
+	if n > 10 {
+		n = 10
+	}
+	return base << (n - 1)
 }
 
 type CronJobState struct {
-	NextRunAtMS *int64 `json:"nextRunAtMs,omitempty"`
-	LastRunAtMS *int64 `json:"lastRunAtMs,omitempty"`
-	LastStatus  string `json:"lastStatus,omitempty"`
-	LastError   string `json:"lastError,omitempty"`
+	NextRunAtMS    *int64 `json:"nextRunAtMs,omitempty"`
+	LastRunAtMS    *int64 `json:"lastRunAtMs,omitempty"`
+	LastStatus     string `json:"lastStatus,omitempty"`
+	LastError      string `json:"lastError,omitempty"`
+	LastOutput     string `json:"lastOutput,omitempty"`
+	LastOutputHash string `json:"lastOutputHash,omitempty"`
+	LastDurationMS int64  `json:"lastDurationMs,omitempty"`
+	// RetryCount is the number of consecutive failed attempts being retried.
+	RetryCount int `json:"retryCount,omitempty"`
+	// LastEvent describes the event that last fired an event job; retries
+	// of that run reuse it.
+	LastEvent string `json:"lastEvent,omitempty"`
+	// LastEventSender is the "channel:sender_id" of the chat message behind
+	// LastEvent, empty for events that do not come from a chat. The handler
+	// sees the values of the run in progress, so it can run the job with
+	// that sender's permissions.
+	LastEventSender string `json:"lastEventSender,omitempty"`
+}
+
+// CronRun is one entry in a job's run history.
+type CronRun struct {
+	StartedAtMS int64  `json:"startedAtMs"`
+	DurationMS  int64  `json:"durationMs"`
+	Status      string `json:"status"` // ok, error, timeout, skipped, missed
+	Attempt     int    `json:"attempt,omitempty"`
+	Error       string `json:"error,omitempty"`
+	Output      string `json:"output,omitempty"`
 }
 
 type CronJob struct {
@@ -44,6 +185,7 @@ type CronJob struct {
 	Enabled        bool         `json:"enabled"`
 	Schedule       CronSchedule `json:"schedule"`
 	Payload        CronPayload  `json:"payload"`
+	Policy         CronPolicy   `json:"policy,omitempty"`
 	State          CronJobState `json:"state"`
 	CreatedAtMS    int64        `json:"createdAtMs"`
 	UpdatedAtMS    int64        `json:"updatedAtMs"`
@@ -55,23 +197,32 @@ type CronStore struct {
 	Jobs    []CronJob `json:"jobs"`
 }
 
-type JobHandler func(job *CronJob) (string, error)
+// JobHandler runs a job. ctx is cancelled when the job's timeout expires.
+type JobHandler func(ctx context.Context, job *CronJob) (string, error)
 
 type CronService struct {
-	storePath string
-	store     *CronStore
-	onJob     JobHandler
-	mu        sync.RWMutex
-	running   bool
-	stopChan  chan struct{}
-	gronx     *gronx.Gronx
+	storePath  string
+	historyDir string
+	store      *CronStore
+	onJob      JobHandler
+	mu         sync.RWMutex
+	historyMu  sync.Mutex
+	running    bool
+	stopChan   chan struct{}
+	gronx      *gronx.Gronx
+	active     map[string]bool         // jobs with a run in progress
+	queued     map[string]TriggerEvent // jobs to run again when the current run ends, with their event
+	workspace  string
 }
 
 func NewCronService(storePath string, onJob JobHandler) *CronService {
 	cs := &CronService{
-		storePath: storePath,
-		onJob:     onJob,
-		gronx:     gronx.New(),
+		storePath:  storePath,
+		historyDir: filepath.Join(filepath.Dir(storePath), "history"),
+		onJob:      onJob,
+		gronx:      gronx.New(),
+		active:     make(map[string]bool),
+		queued:     make(map[string]TriggerEvent),
 	}
 	// Initialize and load store on creation
 	cs.loadStore()
@@ -98,6 +249,9 @@ func (cs *CronService) Start() error {
 	cs.stopChan = make(chan struct{})
 	cs.running = true
 	go cs.runLoop(cs.stopChan)
+	if cs.workspace != "" {
+		go cs.watchFiles(cs.stopChan)
+	}
 
 	return nil
 }
@@ -140,25 +294,30 @@ func (cs *CronService) checkJobs() {
 	}
 
 	now := time.Now().UnixMilli()
-	var dueJobIDs []string
+	var toRun []string
+	var skipped []string
 
-	// Collect jobs that are due (we need to copy them to execute outside lock)
 	for i := range cs.store.Jobs {
 		job := &cs.store.Jobs[i]
-		if job.Enabled && job.State.NextRunAtMS != nil && *job.State.NextRunAtMS <= now {
-			dueJobIDs = append(dueJobIDs, job.ID)
+		if !job.Enabled || job.State.NextRunAtMS == nil || *job.State.NextRunAtMS > now {
+			continue
 		}
-	}
 
-	// Reset next run for due jobs before unlocking to avoid duplicate execution.
-	dueMap := make(map[string]bool, len(dueJobIDs))
-	for _, jobID := range dueJobIDs {
-		dueMap[jobID] = true
-	}
-	for i := range cs.store.Jobs {
-		if dueMap[cs.store.Jobs[i].ID] {
-			cs.store.Jobs[i].State.NextRunAtMS = nil
+		// Reset next run before unlocking to avoid duplicate execution.
+		job.State.NextRunAtMS = nil
+
+		if cs.active[job.ID] {
+			if job.Policy.Concurrency == ConcurrencyQueue {
+				cs.queued[job.ID] = TriggerEvent{}
+			} else {
+				job.State.NextRunAtMS = cs.computeNextRun(&job.Schedule, now)
+				skipped = append(skipped, job.ID)
+			}
+			continue
 		}
+
+		cs.active[job.ID] = true
+		toRun = append(toRun, job.ID)
 	}
 
 	if err := cs.saveStoreUnsafe(); err != nil {
@@ -167,14 +326,57 @@ func (cs *CronService) checkJobs() {
 
 	cs.mu.Unlock()
 
-	// Execute jobs outside lock.
-	for _, jobID := range dueJobIDs {
-		cs.executeJobByID(jobID)
+	for _, jobID := range skipped {
+		cs.appendHistory(jobID, CronRun{
+			StartedAtMS: now,
+			Status:      "skipped",
+			Error:       "previous run still in progress",
+		})
+	}
+
+	// Each job runs in its own goroutine so a slow job cannot hold up others.
+	for _, jobID := range toRun {
+		go cs.runJob(jobID, TriggerEvent{})
+	}
+}
+
+// runJob executes a job, then runs it again while the concurrency policy
+// queued further runs during execution.
+func (cs *CronService) runJob(jobID string, event TriggerEvent) {
+	for {
+		// A run that timed out is recorded at once, but the job stays
+		// active until its handler has returned, so that retries and
+		// later runs never overlap with it.
+		<-cs.executeJob(jobID, event)
+
+		cs.mu.Lock()
+		next, ok := cs.queued[jobID]
+		if !ok {
+			delete(cs.active, jobID)
+			cs.mu.Unlock()
+			return
+		}
+		delete(cs.queued, jobID)
+		cs.mu.Unlock()
+		event = next
 	}
 }
 
 func (cs *CronService) executeJobByID(jobID string) {
-	startTime := time.Now().UnixMilli()
+	cs.executeJob(jobID, TriggerEvent{})
+}
+
+// executeJob runs a job once. For event jobs, event describes what fired it
+// and is quoted in the message the job hands to the agent. The returned
+// channel is closed once the job's handler has returned, which is later
+// than executeJob for a run that timed out.
+func (cs *CronService) executeJob(jobID string, event TriggerEvent) <-chan struct{} {
+	done := make(chan struct{})
+	close(done)
+	var finished <-chan struct{} = done
+
+	start := time.Now()
+	startTime := start.UnixMilli()
 
 	cs.mu.RLock()
 	var callbackJob *CronJob
@@ -186,16 +388,47 @@ func (cs *CronService) executeJobByID(jobID string) {
 			break
 		}
 	}
+	handler := cs.onJob
 	cs.mu.RUnlock()
 
 	if callbackJob == nil {
-		return
+		return finished
 	}
 
+	if callbackJob.Schedule.Kind == "event" {
+		if event.Summary == "" {
+			event.Summary = callbackJob.State.LastEvent
+			event.Sender = callbackJob.State.LastEventSender
+		}
+		callbackJob.State.LastEvent = event.Summary
+		callbackJob.State.LastEventSender = event.Sender
+		if event.Summary != "" {
+			callbackJob.Payload.Message += "\n\n" + quoteEvent(event.Summary)
+		}
+	}
+
+	var output string
 	var err error
-	if cs.onJob != nil {
-		_, err = cs.onJob(callbackJob)
+	if handler != nil {
+		output, finished, err = runWithTimeout(handler, callbackJob)
+	}
+	duration := time.Since(start).Milliseconds()
+
+	run := CronRun{
+		StartedAtMS: startTime,
+		DurationMS:  duration,
+		Status:      "ok",
+		Attempt:     callbackJob.State.RetryCount + 1,
+		Output:      utils.Truncate(output, maxHistoryOutput),
+	}
+	if err != nil {
+		run.Status = "error"
+		if errors.Is(err, ErrJobTimeout) {
+			run.Status = "timeout"
+		}
+		run.Error = err.Error()
 	}
+	cs.appendHistory(jobID, run)
 
 	// Now acquire lock to update state
 	cs.mu.Lock()
@@ -210,20 +443,40 @@ func (cs *CronService) executeJobByID(jobID string) {
 	}
 	if job == nil {
 		log.Printf("[cron] job %s disappeared before state update", jobID)
-		return
+		return finished
 	}
 
 	job.State.LastRunAtMS = &startTime
+	job.State.LastDurationMS = duration
+	if job.Schedule.Kind == "event" {
+		job.State.LastEvent = event.Summary
+		job.State.LastEventSender = event.Sender
+	}
 	job.UpdatedAtMS = time.Now().UnixMilli()
 
 	if err != nil {
-		job.State.LastStatus = "error"
+		job.State.LastStatus = run.Status
 		job.State.LastError = err.Error()
 	} else {
 		job.State.LastStatus = "ok"
 		job.State.LastError = ""
+		job.State.LastOutput = utils.Truncate(output, maxStoredOutput)
+		job.State.LastOutputHash = OutputHash(output)
 	}
 
+	// Retry failed runs with exponential backoff before falling back to the
+	// regular schedule.
+	if err != nil && job.State.RetryCount < job.Policy.MaxRetries {
+		job.State.RetryCount++
+		retryAt := time.Now().Add(job.Policy.retryDelay(job.State.RetryCount)).UnixMilli()
+		job.State.NextRunAtMS = &retryAt
+		if err := cs.saveStoreUnsafe(); err != nil {
+			log.Printf("[cron] failed to save store: %v", err)
+		}
+		return finished
+	}
+	job.State.RetryCount = 0
+
 	// Compute next run time
 	if job.Schedule.Kind == "at" {
 		if job.DeleteAfterRun {
@@ -240,6 +493,36 @@ func (cs *CronService) executeJobByID(jobID string) {
 	if err := cs.saveStoreUnsafe(); err != nil {
 		log.Printf("[cron] failed to save store: %v", err)
 	}
+	return finished
+}
+
+// runWithTimeout calls handler and gives up waiting once the job's timeout
+// expires, cancelling the handler's context. The returned channel is closed
+// when the handler has returned, which a handler that ignores cancellation
+// may do long after runWithTimeout.
+func runWithTimeout(handler JobHandler, job *CronJob) (string, <-chan struct{}, error) {
+	timeout := job.Policy.timeout()
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+
+	type result struct {
+		output string
+		err    error
+	}
+	done := make(chan result, 1)
+	finished := make(chan struct{})
+	go func() {
+		defer close(finished)
+		defer cancel()
+		output, err := handler(ctx, job)
+		done <- result{output, err}
+	}()
+
+	select {
+	case r := <-done:
+		return r.output, finished, r.err
+	case <-ctx.Done():
+		return "", finished, fmt.Errorf("%w after %s", ErrJobTimeout, timeout)
+	}
 }
 
 func (cs *CronService) computeNextRun(schedule *CronSchedule, nowMS int64) *int64 {
@@ -278,14 +561,98 @@ func (cs *CronService) computeNextRun(schedule *CronSchedule, nowMS int64) *int6
 	return nil
 }
 
+// recomputeNextRuns schedules every enabled job from now, applying each job's
+// missed-run policy to runs that fell due while the service was stopped.
 func (cs *CronService) recomputeNextRuns() {
 	now := time.Now().UnixMilli()
 	for i := range cs.store.Jobs {
 		job := &cs.store.Jobs[i]
-		if job.Enabled {
-			job.State.NextRunAtMS = cs.computeNextRun(&job.Schedule, now)
+		if !job.Enabled {
+			continue
+		}
+
+		missed := job.State.NextRunAtMS != nil && *job.State.NextRunAtMS < now
+		next := cs.computeNextRun(&job.Schedule, now)
+		if missed {
+			if job.Policy.MissedRun == MissedRunOnce {
+				next = &now
+			} else {
+				cs.appendHistory(job.ID, CronRun{
+					StartedAtMS: *job.State.NextRunAtMS,
+					Status:      "missed",
+					Error:       "gateway was not running",
+				})
+			}
+		}
+		job.State.NextRunAtMS = next
+	}
+}
+
+// History returns up to limit of the job's most recent runs, newest first.
+// A limit of 0 returns the whole retained history.
+func (cs *CronService) History(jobID string, limit int) ([]CronRun, error) {
+	cs.historyMu.Lock()
+	defer cs.historyMu.Unlock()
+
+	runs, err := cs.loadHistory(jobID)
+	if err != nil {
+		return nil, err
+	}
+
+	newestFirst := make([]CronRun, 0, len(runs))
+	for i := len(runs) - 1; i >= 0; i-- {
+		newestFirst = append(newestFirst, runs[i])
+		if limit > 0 && len(newestFirst) == limit {
+			break
 		}
 	}
+	return newestFirst, nil
+}
+
+func (cs *CronService) historyPath(jobID string) string {
+	return filepath.Join(cs.historyDir, jobID+".json")
+}
+
+func (cs *CronService) loadHistory(jobID string) ([]CronRun, error) {
+	data, err := os.ReadFile(cs.historyPath(jobID))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var runs []CronRun
+	if err := json.Unmarshal(data, &runs); err != nil {
+		return nil, err
+	}
+	return runs, nil
+}
+
+// appendHistory records a run, keeping only the last maxHistory entries.
+func (cs *CronService) appendHistory(jobID string, run CronRun) {
+	cs.historyMu.Lock()
+	defer cs.historyMu.Unlock()
+
+	runs, err := cs.loadHistory(jobID)
+	if err != nil {
+		log.Printf("[cron] failed to load history for %s: %v", jobID, err)
+	}
+	runs = append(runs, run)
+	if len(runs) > maxHistory {
+		runs = runs[len(runs)-maxHistory:]
+	}
+
+	if err := os.MkdirAll(cs.historyDir, 0755); err != nil {
+		log.Printf("[cron] failed to create history dir: %v", err)
+		return
+	}
+	data, err := json.MarshalIndent(runs, "", "  ")
+	if err != nil {
+		return
+	}
+	if err := os.WriteFile(cs.historyPath(jobID), data, 0600); err != nil {
+		log.Printf("[cron] failed to save history for %s: %v", jobID, err)
+	}
 }
 
 func (cs *CronService) getNextWakeMS() *int64 {
@@ -352,6 +719,18 @@ func (cs *CronService) AddJob(name string, schedule CronSchedule, message string
 	// One-time tasks (at) should be deleted after execution
 	deleteAfterRun := (schedule.Kind == "at")
 
+	if schedule.Kind == "event" {
+		if schedule.Trigger == nil {
+			return nil, fmt.Errorf("event schedule requires a trigger")
+		}
+		if err := schedule.Trigger.Validate(); err != nil {
+			return nil, err
+		}
+		if schedule.Trigger.Type == TriggerWebhook && schedule.Trigger.Token == "" {
+			schedule.Trigger.Token = generateID() + generateID()
+		}
+	}
+
 	job := CronJob{
 		ID:       generateID(),
 		Name:     name,
@@ -413,6 +792,7 @@ func (cs *CronService) removeJobUnsafe(jobID string) bool {
 	removed := len(cs.store.Jobs) < before
 
 	if removed {
+		os.Remove(cs.historyPath(jobID))
 		if err := cs.saveStoreUnsafe(); err != nil {
 			log.Printf("[cron] failed to save store after remove: %v", err)
 		}
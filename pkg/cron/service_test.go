@@ -1,10 +1,13 @@
 package cron
 
 import (
+	"context"
 	"os"
 	"path/filepath"
 	"runtime"
+	"sync/atomic"
 	"testing"
+	"time"
 )
 
 func TestSaveStore_FilePermissions(t *testing.T) {
@@ -36,3 +39,232 @@ func TestSaveStore_FilePermissions(t *testing.T) {
 func int64Ptr(v int64) *int64 {
 	return &v
 }
+
+func TestExecuteJob_StoresLastOutput(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "jobs.json")
+	cs := NewCronService(storePath, func(ctx context.Context, job *CronJob) (string, error) {
+		return "report for " + job.Name, nil
+	})
+
+	job, err := cs.AddJob("daily", CronSchedule{Kind: "every", EveryMS: int64Ptr(60000)}, "hello", false, "cli", "direct")
+	if err != nil {
+		t.Fatalf("AddJob failed: %v", err)
+	}
+
+	cs.executeJobByID(job.ID)
+
+	jobs := cs.ListJobs(true)
+	if len(jobs) != 1 {
+		t.Fatalf("expected 1 job, got %d", len(jobs))
+	}
+	state := jobs[0].State
+	if state.LastStatus != "ok" || state.LastOutput != "report for daily" {
+		t.Errorf("unexpected state: status=%q output=%q", state.LastStatus, state.LastOutput)
+	}
+	if state.LastOutputHash != OutputHash("report for daily") {
+		t.Error("expected LastOutputHash to match the output")
+	}
+}
+
+func TestExecuteJob_TimeoutAndRetry(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "jobs.json")
+	cs := NewCronService(storePath, func(ctx context.Context, job *CronJob) (string, error) {
+		<-ctx.Done()
+		return "", ctx.Err()
+	})
+
+	job, _ := cs.AddJob("hangs", CronSchedule{Kind: "every", EveryMS: int64Ptr(3600000)}, "hello", false, "cli", "direct")
+	job.Policy = CronPolicy{TimeoutSec: 1, MaxRetries: 2, RetryBackoffSec: 60}
+	cs.UpdateJob(job)
+
+	before := time.Now()
+	cs.executeJobByID(job.ID)
+	if time.Since(before) > 3*time.Second {
+		t.Fatal("job ran past its timeout")
+	}
+
+	state := cs.ListJobs(true)[0].State
+	if state.LastStatus != "timeout" {
+		t.Errorf("expected status timeout, got %q", state.LastStatus)
+	}
+	if state.RetryCount != 1 {
+		t.Errorf("expected retry count 1, got %d", state.RetryCount)
+	}
+	if state.NextRunAtMS == nil || *state.NextRunAtMS > before.Add(2*time.Minute).UnixMilli() {
+		t.Error("expected retry to be scheduled with backoff instead of the hourly schedule")
+	}
+
+	runs, err := cs.History(job.ID, 0)
+	if err != nil || len(runs) != 1 {
+		t.Fatalf("expected 1 history entry, got %d (err=%v)", len(runs), err)
+	}
+	if runs[0].Status != "timeout" || runs[0].Attempt != 1 {
+		t.Errorf("unexpected history entry: %+v", runs[0])
+	}
+}
+
+func TestHistory_IsBounded(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "jobs.json")
+	cs := NewCronService(storePath, func(ctx context.Context, job *CronJob) (string, error) {
+		return "ok", nil
+	})
+	job, _ := cs.AddJob("often", CronSchedule{Kind: "every", EveryMS: int64Ptr(1000)}, "hello", false, "cli", "direct")
+
+	for i := 0; i < maxHistory+5; i++ {
+		cs.executeJobByID(job.ID)
+	}
+
+	runs, _ := cs.History(job.ID, 0)
+	if len(runs) != maxHistory {
+		t.Errorf("expected history capped at %d, got %d", maxHistory, len(runs))
+	}
+	if limited, _ := cs.History(job.ID, 3); len(limited) != 3 {
+		t.Errorf("expected 3 runs with limit, got %d", len(limited))
+	}
+}
+
+func TestCheckJobs_SkipsWhileRunning(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "jobs.json")
+	release := make(chan struct{})
+	var calls atomic.Int32
+	cs := NewCronService(storePath, func(ctx context.Context, job *CronJob) (string, error) {
+		calls.Add(1)
+		<-release
+		return "done", nil
+	})
+	job, _ := cs.AddJob("slow", CronSchedule{Kind: "every", EveryMS: int64Ptr(1000)}, "hello", false, "cli", "direct")
+
+	cs.mu.Lock()
+	cs.running = true
+	cs.mu.Unlock()
+
+	due := func() {
+		cs.mu.Lock()
+		past := time.Now().Add(-time.Second).UnixMilli()
+		cs.store.Jobs[0].State.NextRunAtMS = &past
+		cs.mu.Unlock()
+		cs.checkJobs()
+	}
+
+	due()
+	waitFor(t, func() bool { return calls.Load() == 1 })
+	due()
+	close(release)
+	waitFor(t, func() bool {
+		cs.mu.RLock()
+		defer cs.mu.RUnlock()
+		return !cs.active[job.ID]
+	})
+
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected overlapping run to be skipped, handler called %d times", n)
+	}
+	runs, _ := cs.History(job.ID, 0)
+	if len(runs) != 2 || runs[1].Status != "skipped" {
+		t.Errorf("expected a skipped entry followed by the completed run, got %+v", runs)
+	}
+}
+
+func TestRunJob_StaysActiveUntilTimedOutHandlerReturns(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "jobs.json")
+	release := make(chan struct{})
+	var running atomic.Int32
+	var overlapped atomic.Bool
+	cs := NewCronService(storePath, func(ctx context.Context, job *CronJob) (string, error) {
+		if running.Add(1) > 1 {
+			overlapped.Store(true)
+		}
+		defer running.Add(-1)
+		<-release // ignores ctx
+		return "late", nil
+	})
+	job, _ := cs.AddJob("stuck", CronSchedule{Kind: "every", EveryMS: int64Ptr(1000)}, "hello", false, "cli", "direct")
+	job.Policy = CronPolicy{TimeoutSec: 1}
+	cs.UpdateJob(job)
+
+	cs.mu.Lock()
+	cs.running = true
+	cs.active[job.ID] = true
+	cs.mu.Unlock()
+	go cs.runJob(job.ID, TriggerEvent{})
+
+	waitFor(t, func() bool {
+		cs.mu.RLock()
+		defer cs.mu.RUnlock()
+		return cs.store.Jobs[0].State.LastStatus == "timeout"
+	})
+	// Give runJob time to move on from the timed-out run. The handler is
+	// still running, so a due run must be skipped.
+	time.Sleep(50 * time.Millisecond)
+	cs.mu.Lock()
+	past := time.Now().Add(-time.Second).UnixMilli()
+	cs.store.Jobs[0].State.NextRunAtMS = &past
+	cs.mu.Unlock()
+	cs.checkJobs()
+	time.Sleep(20 * time.Millisecond) // let a wrongly started run reach the handler
+
+	cs.mu.RLock()
+	active := cs.active[job.ID]
+	cs.mu.RUnlock()
+	if !active {
+		t.Error("expected the job to stay active while its handler runs")
+	}
+
+	close(release)
+	waitFor(t, func() bool {
+		cs.mu.RLock()
+		defer cs.mu.RUnlock()
+		return !cs.active[job.ID]
+	})
+	if overlapped.Load() {
+		t.Error("a second run started while the timed-out one was still going")
+	}
+}
+
+func TestRecomputeNextRuns_MissedRunPolicy(t *testing.T) {
+	storePath := filepath.Join(t.TempDir(), "jobs.json")
+	cs := NewCronService(storePath, nil)
+
+	skipJob, _ := cs.AddJob("skip", CronSchedule{Kind: "every", EveryMS: int64Ptr(3600000)}, "a", false, "cli", "direct")
+	onceJob, _ := cs.AddJob("once", CronSchedule{Kind: "every", EveryMS: int64Ptr(3600000)}, "b", false, "cli", "direct")
+	onceJob.Policy.MissedRun = MissedRunOnce
+	cs.UpdateJob(onceJob)
+
+	past := time.Now().Add(-2 * time.Hour).UnixMilli()
+	cs.mu.Lock()
+	for i := range cs.store.Jobs {
+		cs.store.Jobs[i].State.NextRunAtMS = &past
+	}
+	cs.recomputeNextRuns()
+	cs.mu.Unlock()
+
+	now := time.Now().UnixMilli()
+	for _, j := range cs.ListJobs(true) {
+		switch j.ID {
+		case skipJob.ID:
+			if *j.State.NextRunAtMS <= now {
+				t.Error("skip policy should wait for the next scheduled time")
+			}
+		case onceJob.ID:
+			if *j.State.NextRunAtMS > now {
+				t.Error("run_once policy should run immediately")
+			}
+		}
+	}
+
+	if runs, _ := cs.History(skipJob.ID, 0); len(runs) != 1 || runs[0].Status != "missed" {
+		t.Errorf("expected a missed entry in history, got %+v", runs)
+	}
+}
+
+func waitFor(t *testing.T, cond func() bool) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if cond() {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatal("condition not met in time")
+}
@@ -17,6 +17,7 @@ type Service struct {
 	bus     *bus.MessageBus
 	state   *state.Manager
 	sources []events.EventSource
+	onEvent func(*events.DeviceEvent)
 	enabled bool
 	ctx     context.Context
 	cancel  context.CancelFunc
@@ -49,6 +50,14 @@ func (s *Service) SetBus(msgBus *bus.MessageBus) {
 	s.bus = msgBus
 }
 
+// SetEventHandler registers a callback for every device event, in addition
+// to the chat notification. Used to fire event-triggered cron jobs.
+func (s *Service) SetEventHandler(fn func(*events.DeviceEvent)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.onEvent = fn
+}
+
 func (s *Service) Start(ctx context.Context) error {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -101,6 +110,13 @@ func (s *Service) handleEvents(kind events.Kind, eventCh <-chan *events.DeviceEv
 			continue
 		}
 		s.sendNotification(ev)
+
+		s.mu.RLock()
+		onEvent := s.onEvent
+		s.mu.RUnlock()
+		if onEvent != nil {
+			onEvent(ev)
+		}
 	}
 }
 
@@ -10,11 +10,14 @@ import (
 )
 
 type Server struct {
-	server    *http.Server
-	mu        sync.RWMutex
-	ready     bool
-	checks    map[string]Check
-	startTime time.Time
+	server       *http.Server
+	mux          *http.ServeMux
+	mu           sync.RWMutex
+	ready        bool
+	checks       map[string]Check
+	startTime    time.Time
+	lastActivity time.Time
+	usage        Usage
 }
 
 type Check struct {
@@ -28,15 +31,33 @@ type StatusResponse struct {
 	Status string           `json:"status"`
 	Uptime string           `json:"uptime"`
 	Checks map[string]Check `json:"checks,omitempty"`
+	// LastActivity is when the last inbound message arrived, if any
+	// arrived since the server started.
+	LastActivity *time.Time `json:"last_activity,omitempty"`
+	Usage        *Usage     `json:"usage,omitempty"`
+}
+
+// Usage counts the gateway's traffic since Since, when the server started.
+// The manager adds it up across restarts.
+type Usage struct {
+	Since            time.Time `json:"since"`
+	MessagesIn       int64     `json:"messages_in"`
+	MessagesOut      int64     `json:"messages_out"`
+	LLMCalls         int64     `json:"llm_calls"`
+	PromptTokens     int64     `json:"prompt_tokens"`
+	CompletionTokens int64     `json:"completion_tokens"`
+	ToolCalls        int64     `json:"tool_calls"`
 }
 
 func NewServer(host string, port int) *Server {
 	mux := http.NewServeMux()
 	s := &Server{
+		mux:       mux,
 		ready:     false,
 		checks:    make(map[string]Check),
 		startTime: time.Now(),
 	}
+	s.usage.Since = s.startTime.UTC()
 
 	mux.HandleFunc("/health", s.healthHandler)
 	mux.HandleFunc("/ready", s.readyHandler)
@@ -84,12 +105,50 @@ func (s *Server) Stop(ctx context.Context) error {
 	return s.server.Shutdown(ctx)
 }
 
+// Handle registers an extra handler on the gateway's HTTP server, such as
+// the cron webhook endpoint. It must be called before Start.
+func (s *Server) Handle(pattern string, handler http.Handler) {
+	s.mux.Handle(pattern, handler)
+}
+
 func (s *Server) SetReady(ready bool) {
 	s.mu.Lock()
 	s.ready = ready
 	s.mu.Unlock()
 }
 
+// RecordActivity notes that an inbound message arrived at t. /health
+// reports the latest one, which the manager uses to suspend idle tenants.
+func (s *Server) RecordActivity(t time.Time) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if t.After(s.lastActivity) {
+		s.lastActivity = t
+	}
+}
+
+// CountMessage counts an inbound or an outbound message.
+func (s *Server) CountMessage(inbound bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if inbound {
+		s.usage.MessagesIn++
+	} else {
+		s.usage.MessagesOut++
+	}
+}
+
+// CountLLMCall counts an LLM response, its tokens and the tool calls it
+// asked for.
+func (s *Server) CountLLMCall(promptTokens, completionTokens, toolCalls int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.usage.LLMCalls++
+	s.usage.PromptTokens += int64(promptTokens)
+	s.usage.CompletionTokens += int64(completionTokens)
+	s.usage.ToolCalls += int64(toolCalls)
+}
+
 func (s *Server) RegisterCheck(name string, checkFn func() (bool, string)) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
@@ -112,6 +171,14 @@ func (s *Server) healthHandler(w http.ResponseWriter, r *http.Request) {
 		Status: "ok",
 		Uptime: uptime.String(),
 	}
+	s.mu.RLock()
+	if !s.lastActivity.IsZero() {
+		lastActivity := s.lastActivity
+		resp.LastActivity = &lastActivity
+	}
+	usage := s.usage
+	resp.Usage = &usage
+	s.mu.RUnlock()
 
 	json.NewEncoder(w).Encode(resp)
 }
@@ -37,6 +37,7 @@ type HeartbeatService struct {
 	bus       *bus.MessageBus
 	state     *state.Manager
 	handler   HeartbeatHandler
+	tasks     []func()
 	interval  time.Duration
 	enabled   bool
 	mu        sync.RWMutex
@@ -76,6 +77,15 @@ func (hs *HeartbeatService) SetHandler(handler HeartbeatHandler) {
 	hs.handler = handler
 }
 
+// AddMaintenanceTask adds a task started in the background on every
+// heartbeat, even when HEARTBEAT.md is empty. The task decides itself
+// whether it is due.
+func (hs *HeartbeatService) AddMaintenanceTask(task func()) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+	hs.tasks = append(hs.tasks, task)
+}
+
 // Start begins the heartbeat service
 func (hs *HeartbeatService) Start() error {
 	hs.mu.Lock()
@@ -147,6 +157,7 @@ func (hs *HeartbeatService) executeHeartbeat() {
 	hs.mu.RLock()
 	enabled := hs.enabled
 	handler := hs.handler
+	tasks := hs.tasks
 	if !hs.enabled || hs.stopChan == nil {
 		hs.mu.RUnlock()
 		return
@@ -159,6 +170,10 @@ func (hs *HeartbeatService) executeHeartbeat() {
 
 	logger.DebugC("heartbeat", "Executing heartbeat")
 
+	for _, task := range tasks {
+		go task()
+	}
+
 	prompt := hs.buildPrompt()
 	if prompt == "" {
 		logger.InfoC("heartbeat", "No heartbeat prompt (HEARTBEAT.md empty or missing)")
@@ -219,3 +219,22 @@ func TestHeartbeatFilePath(t *testing.T) {
 		t.Errorf("Expected HEARTBEAT.md at %s, but it doesn't exist", expectedPath)
 	}
 }
+
+func TestExecuteHeartbeat_MaintenanceTasks(t *testing.T) {
+	tmpDir := t.TempDir()
+
+	hs := NewHeartbeatService(tmpDir, 30, true)
+	hs.stopChan = make(chan struct{}) // Enable for testing
+
+	done := make(chan struct{})
+	hs.AddMaintenanceTask(func() { close(done) })
+
+	// No HEARTBEAT.md: the prompt is skipped but maintenance still runs.
+	hs.executeHeartbeat()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("maintenance task did not run")
+	}
+}
@@ -0,0 +1,124 @@
+// Package access manages the manager's API keys and its audit log. Keys
+// are stored hashed; each has scopes and may be limited to some tenants.
+package access
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/manager/tenant"
+)
+
+// Scopes, each including the ones before it.
+const (
+	ScopeRead  = "read"  // list and inspect tenants, operations and snapshots
+	ScopeWrite = "write" // create, change and delete tenants
+	ScopeAdmin = "admin" // manage API keys and read the audit log
+)
+
+var scopeRank = map[string]int{ScopeRead: 1, ScopeWrite: 2, ScopeAdmin: 3}
+
+// Key is a named API key. Only a hash of its token is stored.
+type Key struct {
+	ID        string
+	Name      string
+	Hash      string   // hex SHA-256 of the token
+	Scopes    []string // ScopeRead, ScopeWrite or ScopeAdmin
+	Tenants   []string // tenant IDs the key may act on; empty means all
+	CreatedAt time.Time
+	RotatedAt *time.Time
+	RevokedAt *time.Time
+}
+
+// Principal is who made a request: the key it was authenticated with.
+type Principal struct {
+	KeyID   string
+	Name    string
+	Scopes  []string
+	Tenants []string
+}
+
+// Allows reports whether the principal has scope, directly or through a
+// broader one.
+func (p *Principal) Allows(scope string) bool {
+	for _, s := range p.Scopes {
+		if scopeRank[s] >= scopeRank[scope] {
+			return true
+		}
+	}
+	return false
+}
+
+// CanAccess reports whether the principal may act on a tenant.
+func (p *Principal) CanAccess(tenantID string) bool {
+	return len(p.Tenants) == 0 || slices.Contains(p.Tenants, tenantID)
+}
+
+// Restricted reports whether the principal is limited to some tenants.
+func (p *Principal) Restricted() bool {
+	return len(p.Tenants) > 0
+}
+
+// tokenPrefix starts every generated token, so leaked tokens are easy to
+// recognize.
+const tokenPrefix = "pcm_"
+
+// newToken returns a random token "pcm_<key id>_<secret>", so that a key
+// can be looked up by the ID in its token.
+func newToken(keyID string) (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", fmt.Errorf("generate token: %w", err)
+	}
+	return tokenPrefix + keyID + "_" + hex.EncodeToString(b), nil
+}
+
+func newKeyID() string {
+	b := make([]byte, 6)
+	rand.Read(b)
+	return "key-" + hex.EncodeToString(b)
+}
+
+// keyIDOf extracts the key ID from a generated token.
+func keyIDOf(token string) (string, bool) {
+	rest, ok := strings.CutPrefix(token, tokenPrefix)
+	if !ok {
+		return "", false
+	}
+	i := strings.LastIndexByte(rest, '_')
+	if i <= 0 {
+		return "", false
+	}
+	return rest[:i], true
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func hashMatches(hash, token string) bool {
+	return subtle.ConstantTimeCompare([]byte(hash), []byte(hashToken(token))) == 1
+}
+
+func validateScopes(scopes []string) error {
+	if len(scopes) == 0 {
+		return fmt.Errorf("%w: at least one scope is required", tenant.ErrInvalid)
+	}
+	for _, s := range scopes {
+		if _, ok := scopeRank[s]; !ok {
+			return fmt.Errorf("%w: unknown scope %q (want read, write or admin)", tenant.ErrInvalid, s)
+		}
+	}
+	return nil
+}
+
+// ErrUnauthorized is returned for tokens that match no active key.
+var ErrUnauthorized = errors.New("invalid api key")
@@ -0,0 +1,99 @@
+package access
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"sync"
+)
+
+// MemoryStore keeps keys and the audit log in memory, alongside the
+// tenant package's MemoryStore. Nothing survives a restart.
+type MemoryStore struct {
+	mu    sync.Mutex
+	keys  map[string]*Key
+	audit []AuditEntry
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{keys: make(map[string]*Key)}
+}
+
+func cloneKey(k *Key) *Key {
+	c := *k
+	c.Scopes = slices.Clone(k.Scopes)
+	c.Tenants = slices.Clone(k.Tenants)
+	return &c
+}
+
+// CreateKey inserts a new key.
+func (s *MemoryStore) CreateKey(k *Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[k.ID]; ok {
+		return fmt.Errorf("key %q exists", k.ID)
+	}
+	s.keys[k.ID] = cloneKey(k)
+	return nil
+}
+
+// GetKey retrieves a key by ID.
+func (s *MemoryStore) GetKey(id string) (*Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	k, ok := s.keys[id]
+	if !ok {
+		return nil, nil
+	}
+	return cloneKey(k), nil
+}
+
+// ListKeys returns every key, oldest first.
+func (s *MemoryStore) ListKeys() ([]Key, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	keys := make([]Key, 0, len(s.keys))
+	for _, k := range s.keys {
+		keys = append(keys, *cloneKey(k))
+	}
+	sort.Slice(keys, func(i, j int) bool { return keys[i].CreatedAt.Before(keys[j].CreatedAt) })
+	return keys, nil
+}
+
+// UpdateKey stores a rotated or revoked key.
+func (s *MemoryStore) UpdateKey(k *Key) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.keys[k.ID]; !ok {
+		return fmt.Errorf("key %q not found", k.ID)
+	}
+	s.keys[k.ID] = cloneKey(k)
+	return nil
+}
+
+// RecordAudit appends an entry to the audit log and sets its ID.
+func (s *MemoryStore) RecordAudit(e *AuditEntry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e.ID = int64(len(s.audit) + 1)
+	s.audit = append(s.audit, *e)
+	return nil
+}
+
+// ListAudit returns the entries matching f, newest first.
+func (s *MemoryStore) ListAudit(f AuditFilter) ([]AuditEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var entries []AuditEntry
+	for i := len(s.audit) - 1; i >= 0 && len(entries) < f.Limit; i-- {
+		e := s.audit[i]
+		if (f.TenantID != "" && e.TenantID != f.TenantID) ||
+			(f.Actor != "" && e.Actor != f.Actor) ||
+			(!f.Since.IsZero() && e.Time.Before(f.Since)) {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	return entries, nil
+}
@@ -0,0 +1,196 @@
+package access
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/manager/tenant"
+)
+
+// Audit results.
+const (
+	AuditSucceeded = "succeeded" // 2xx: done, or accepted as an operation
+	AuditDenied    = "denied"    // 401 or 403
+	AuditFailed    = "failed"    // any other error
+)
+
+// AuditEntry records one mutating API call.
+type AuditEntry struct {
+	ID          int64
+	Time        time.Time
+	Actor       string // name of the key used; empty if it was not recognized
+	KeyID       string
+	Action      string // method and route, e.g. "POST /tenants/{id}/suspend"
+	TenantID    string
+	OperationID string // the operation started, if any
+	RequestID   string
+	Status      int // HTTP status code
+	Result      string
+	Error       string
+}
+
+// AuditFilter selects audit entries. Zero fields match everything.
+type AuditFilter struct {
+	TenantID string
+	Actor    string
+	Since    time.Time
+	Limit    int // defaults to DefaultAuditLimit
+}
+
+// Audit list limits.
+const (
+	DefaultAuditLimit = 100
+	MaxAuditLimit     = 1000
+)
+
+// Store persists API keys and the audit log. *SQLStore and *MemoryStore
+// implement it.
+type Store interface {
+	CreateKey(k *Key) error
+	GetKey(id string) (*Key, error)
+	ListKeys() ([]Key, error)
+	UpdateKey(k *Key) error
+
+	RecordAudit(e *AuditEntry) error
+	ListAudit(f AuditFilter) ([]AuditEntry, error)
+}
+
+// BootstrapKeyName names the key configured through API_KEY.
+const BootstrapKeyName = "API_KEY"
+
+// Service authenticates requests and manages keys and the audit log.
+type Service struct {
+	store     Store
+	bootstrap string
+}
+
+// NewService creates a Service. bootstrapKey, when not empty, is accepted
+// as an admin key that is not stored, so that the first named keys can be
+// created.
+func NewService(store Store, bootstrapKey string) *Service {
+	return &Service{store: store, bootstrap: bootstrapKey}
+}
+
+// Authenticate returns the principal of an active key matching token.
+func (s *Service) Authenticate(token string) (*Principal, error) {
+	if s.bootstrap != "" && subtle.ConstantTimeCompare([]byte(token), []byte(s.bootstrap)) == 1 {
+		return &Principal{KeyID: BootstrapKeyName, Name: BootstrapKeyName, Scopes: []string{ScopeAdmin}}, nil
+	}
+	id, ok := keyIDOf(token)
+	if !ok {
+		return nil, ErrUnauthorized
+	}
+	k, err := s.store.GetKey(id)
+	if err != nil {
+		return nil, err
+	}
+	if k == nil || k.RevokedAt != nil || !hashMatches(k.Hash, token) {
+		return nil, ErrUnauthorized
+	}
+	return &Principal{KeyID: k.ID, Name: k.Name, Scopes: k.Scopes, Tenants: k.Tenants}, nil
+}
+
+// CreateKey stores a new key and returns it with its token. The token is
+// not stored and cannot be retrieved later.
+func (s *Service) CreateKey(name string, scopes, tenants []string) (*Key, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return nil, "", fmt.Errorf("%w: name is required", tenant.ErrInvalid)
+	}
+	if err := validateScopes(scopes); err != nil {
+		return nil, "", err
+	}
+	if len(tenants) > 0 && slices.Contains(scopes, ScopeAdmin) {
+		// An admin key could create itself an unrestricted one.
+		return nil, "", fmt.Errorf("%w: admin keys cannot be limited to tenants", tenant.ErrInvalid)
+	}
+
+	k := &Key{
+		ID:        newKeyID(),
+		Name:      name,
+		Scopes:    slices.Compact(slices.Sorted(slices.Values(scopes))),
+		Tenants:   slices.Compact(slices.Sorted(slices.Values(tenants))),
+		CreatedAt: time.Now().UTC(),
+	}
+	token, err := newToken(k.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	k.Hash = hashToken(token)
+	if err := s.store.CreateKey(k); err != nil {
+		return nil, "", err
+	}
+	return k, token, nil
+}
+
+// RotateKey replaces a key's token. The old token stops working at once.
+func (s *Service) RotateKey(id string) (*Key, string, error) {
+	k, err := s.activeKey(id)
+	if err != nil {
+		return nil, "", err
+	}
+	token, err := newToken(k.ID)
+	if err != nil {
+		return nil, "", err
+	}
+	now := time.Now().UTC()
+	k.Hash = hashToken(token)
+	k.RotatedAt = &now
+	if err := s.store.UpdateKey(k); err != nil {
+		return nil, "", err
+	}
+	return k, token, nil
+}
+
+// RevokeKey disables a key for good. It stays listed, marked revoked, so
+// the audit log keeps making sense.
+func (s *Service) RevokeKey(id string) (*Key, error) {
+	k, err := s.activeKey(id)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	k.RevokedAt = &now
+	if err := s.store.UpdateKey(k); err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+// ListKeys returns every key, revoked ones included, oldest first.
+func (s *Service) ListKeys() ([]Key, error) {
+	return s.store.ListKeys()
+}
+
+func (s *Service) activeKey(id string) (*Key, error) {
+	k, err := s.store.GetKey(id)
+	if err != nil {
+		return nil, err
+	}
+	if k == nil {
+		return nil, fmt.Errorf("%w: key %q not found", tenant.ErrNotFound, id)
+	}
+	if k.RevokedAt != nil {
+		return nil, fmt.Errorf("%w: key %q is revoked", tenant.ErrConflict, id)
+	}
+	return k, nil
+}
+
+// Record adds an entry to the audit log.
+func (s *Service) Record(e *AuditEntry) error {
+	return s.store.RecordAudit(e)
+}
+
+// Audit returns audit entries matching f, newest first.
+func (s *Service) Audit(f AuditFilter) ([]AuditEntry, error) {
+	if f.Limit <= 0 {
+		f.Limit = DefaultAuditLimit
+	}
+	if f.Limit > MaxAuditLimit {
+		return nil, fmt.Errorf("%w: limit must be at most %d", tenant.ErrInvalid, MaxAuditLimit)
+	}
+	return s.store.ListAudit(f)
+}
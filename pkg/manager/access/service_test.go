@@ -0,0 +1,116 @@
+package access
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/manager/tenant"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestService_CreateAndAuthenticate(t *testing.T) {
+	svc := NewService(NewMemoryStore(), "")
+
+	key, token, err := svc.CreateKey(" ci ", []string{ScopeWrite, ScopeWrite}, []string{"beta", "acme"})
+	require.NoError(t, err)
+	assert.Equal(t, "ci", key.Name)
+	assert.Equal(t, []string{ScopeWrite}, key.Scopes)
+	assert.Equal(t, []string{"acme", "beta"}, key.Tenants)
+	assert.True(t, strings.HasPrefix(token, "pcm_"+key.ID+"_"))
+	assert.NotContains(t, key.Hash, token)
+
+	p, err := svc.Authenticate(token)
+	require.NoError(t, err)
+	assert.Equal(t, key.ID, p.KeyID)
+	assert.Equal(t, "ci", p.Name)
+	assert.True(t, p.Allows(ScopeRead))
+	assert.True(t, p.Allows(ScopeWrite))
+	assert.False(t, p.Allows(ScopeAdmin))
+	assert.True(t, p.CanAccess("acme"))
+	assert.False(t, p.CanAccess("other"))
+	assert.True(t, p.Restricted())
+
+	for _, bad := range []string{"", "nope", token + "0", "pcm_" + key.ID + "_" + strings.Repeat("0", 64), "pcm_missing_00"} {
+		_, err := svc.Authenticate(bad)
+		assert.ErrorIs(t, err, ErrUnauthorized, bad)
+	}
+}
+
+func TestService_CreateKeyValidation(t *testing.T) {
+	svc := NewService(NewMemoryStore(), "")
+
+	for name, tc := range map[string]struct {
+		name    string
+		scopes  []string
+		tenants []string
+	}{
+		"no name":            {"", []string{ScopeRead}, nil},
+		"no scopes":          {"ci", nil, nil},
+		"unknown scope":      {"ci", []string{"root"}, nil},
+		"restricted admin":   {"ci", []string{ScopeAdmin}, []string{"acme"}},
+		"blank name trimmed": {"  ", []string{ScopeRead}, nil},
+	} {
+		_, _, err := svc.CreateKey(tc.name, tc.scopes, tc.tenants)
+		assert.ErrorIs(t, err, tenant.ErrInvalid, name)
+	}
+}
+
+func TestService_Bootstrap(t *testing.T) {
+	svc := NewService(NewMemoryStore(), "bootstrap-secret")
+
+	p, err := svc.Authenticate("bootstrap-secret")
+	require.NoError(t, err)
+	assert.Equal(t, BootstrapKeyName, p.Name)
+	assert.True(t, p.Allows(ScopeAdmin))
+	assert.False(t, p.Restricted())
+
+	_, err = NewService(NewMemoryStore(), "").Authenticate("")
+	assert.ErrorIs(t, err, ErrUnauthorized)
+}
+
+func TestService_RotateAndRevoke(t *testing.T) {
+	svc := NewService(NewMemoryStore(), "")
+	key, oldToken, err := svc.CreateKey("ci", []string{ScopeRead}, nil)
+	require.NoError(t, err)
+
+	rotated, newToken, err := svc.RotateKey(key.ID)
+	require.NoError(t, err)
+	assert.NotEqual(t, oldToken, newToken)
+	assert.NotNil(t, rotated.RotatedAt)
+	_, err = svc.Authenticate(oldToken)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+	_, err = svc.Authenticate(newToken)
+	require.NoError(t, err)
+
+	revoked, err := svc.RevokeKey(key.ID)
+	require.NoError(t, err)
+	assert.NotNil(t, revoked.RevokedAt)
+	_, err = svc.Authenticate(newToken)
+	assert.ErrorIs(t, err, ErrUnauthorized)
+
+	_, _, err = svc.RotateKey(key.ID)
+	assert.ErrorIs(t, err, tenant.ErrConflict)
+	_, err = svc.RevokeKey("key-missing")
+	assert.ErrorIs(t, err, tenant.ErrNotFound)
+
+	keys, err := svc.ListKeys()
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.NotNil(t, keys[0].RevokedAt)
+}
+
+func TestService_AuditLimit(t *testing.T) {
+	svc := NewService(NewMemoryStore(), "")
+	for range DefaultAuditLimit + 5 {
+		require.NoError(t, svc.Record(&AuditEntry{Actor: "ci", Action: "POST /tenants", Result: AuditSucceeded}))
+	}
+
+	entries, err := svc.Audit(AuditFilter{})
+	require.NoError(t, err)
+	assert.Len(t, entries, DefaultAuditLimit)
+	assert.Equal(t, int64(DefaultAuditLimit+5), entries[0].ID)
+
+	_, err = svc.Audit(AuditFilter{Limit: MaxAuditLimit + 1})
+	assert.ErrorIs(t, err, tenant.ErrInvalid)
+}
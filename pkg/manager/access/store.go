@@ -0,0 +1,247 @@
+package access
+
+import (
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const postgresSchema = `
+CREATE TABLE IF NOT EXISTS api_keys (
+    id         TEXT PRIMARY KEY,
+    name       TEXT NOT NULL,
+    hash       TEXT NOT NULL,
+    scopes     JSONB NOT NULL,
+    tenants    JSONB NOT NULL,
+    created_at TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    rotated_at TIMESTAMPTZ,
+    revoked_at TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id           BIGSERIAL PRIMARY KEY,
+    time         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    actor        TEXT NOT NULL,
+    key_id       TEXT NOT NULL,
+    action       TEXT NOT NULL,
+    tenant_id    TEXT NOT NULL,
+    operation_id TEXT NOT NULL,
+    request_id   TEXT NOT NULL,
+    status       INTEGER NOT NULL,
+    result       TEXT NOT NULL,
+    error        TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS audit_log_time_idx ON audit_log (time);
+CREATE INDEX IF NOT EXISTS audit_log_tenant_idx ON audit_log (tenant_id, time);`
+
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS api_keys (
+    id         TEXT PRIMARY KEY,
+    name       TEXT NOT NULL,
+    hash       TEXT NOT NULL,
+    scopes     TEXT NOT NULL,
+    tenants    TEXT NOT NULL,
+    created_at TIMESTAMP NOT NULL,
+    rotated_at TIMESTAMP,
+    revoked_at TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS audit_log (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    time         TIMESTAMP NOT NULL,
+    actor        TEXT NOT NULL,
+    key_id       TEXT NOT NULL,
+    action       TEXT NOT NULL,
+    tenant_id    TEXT NOT NULL,
+    operation_id TEXT NOT NULL,
+    request_id   TEXT NOT NULL,
+    status       INTEGER NOT NULL,
+    result       TEXT NOT NULL,
+    error        TEXT NOT NULL DEFAULT ''
+);
+CREATE INDEX IF NOT EXISTS audit_log_time_idx ON audit_log (time);
+CREATE INDEX IF NOT EXISTS audit_log_tenant_idx ON audit_log (tenant_id, time);`
+
+// SQLStore keeps keys and the audit log in the manager's PostgreSQL or
+// SQLite database, next to the tenants.
+type SQLStore struct {
+	db *sql.DB
+}
+
+// NewStore creates a SQLStore on a PostgreSQL database and initializes
+// the schema.
+func NewStore(db *sql.DB) (*SQLStore, error) {
+	return newSQLStore(db, postgresSchema)
+}
+
+// NewSQLiteStore creates a SQLStore on a SQLite database opened with the
+// "sqlite" driver and initializes the schema.
+func NewSQLiteStore(db *sql.DB) (*SQLStore, error) {
+	return newSQLStore(db, sqliteSchema)
+}
+
+func newSQLStore(db *sql.DB, schema string) (*SQLStore, error) {
+	if _, err := db.Exec(schema); err != nil {
+		return nil, fmt.Errorf("init access schema: %w", err)
+	}
+	return &SQLStore{db: db}, nil
+}
+
+const keyColumns = `id, name, hash, scopes, tenants, created_at, rotated_at, revoked_at`
+
+// CreateKey inserts a new key.
+func (s *SQLStore) CreateKey(k *Key) error {
+	scopes, tenants, err := marshalLists(k)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO api_keys (`+keyColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
+		k.ID, k.Name, k.Hash, scopes, tenants, k.CreatedAt, k.RotatedAt, k.RevokedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("insert key: %w", err)
+	}
+	return nil
+}
+
+// GetKey retrieves a key by ID.
+func (s *SQLStore) GetKey(id string) (*Key, error) {
+	k, err := scanKey(s.db.QueryRow(`SELECT `+keyColumns+` FROM api_keys WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get key: %w", err)
+	}
+	return k, nil
+}
+
+// ListKeys returns every key, oldest first.
+func (s *SQLStore) ListKeys() ([]Key, error) {
+	rows, err := s.db.Query(`SELECT ` + keyColumns + ` FROM api_keys ORDER BY created_at`)
+	if err != nil {
+		return nil, fmt.Errorf("list keys: %w", err)
+	}
+	defer rows.Close()
+
+	var keys []Key
+	for rows.Next() {
+		k, err := scanKey(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan key: %w", err)
+		}
+		keys = append(keys, *k)
+	}
+	return keys, rows.Err()
+}
+
+// UpdateKey stores a rotated or revoked key.
+func (s *SQLStore) UpdateKey(k *Key) error {
+	_, err := s.db.Exec(`UPDATE api_keys SET hash=$1, rotated_at=$2, revoked_at=$3 WHERE id=$4`,
+		k.Hash, k.RotatedAt, k.RevokedAt, k.ID)
+	if err != nil {
+		return fmt.Errorf("update key: %w", err)
+	}
+	return nil
+}
+
+func marshalLists(k *Key) ([]byte, []byte, error) {
+	scopes, err := json.Marshal(k.Scopes)
+	if err != nil {
+		return nil, nil, fmt.Errorf("marshal scopes: %w", err)
+	}
+	tenants := k.Tenants
+	if tenants == nil {
+		tenants = []string{}
+	}
+	tenantsJSON, err := json.Marshal(tenants)
+	if err != nil {
+		return nil, nil, fmt.Errorf("marshal tenants: %w", err)
+	}
+	return scopes, tenantsJSON, nil
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanKey(row rowScanner) (*Key, error) {
+	k := &Key{}
+	var scopes, tenants []byte
+	if err := row.Scan(&k.ID, &k.Name, &k.Hash, &scopes, &tenants, &k.CreatedAt, &k.RotatedAt, &k.RevokedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(scopes, &k.Scopes); err != nil {
+		return nil, fmt.Errorf("unmarshal scopes: %w", err)
+	}
+	if err := json.Unmarshal(tenants, &k.Tenants); err != nil {
+		return nil, fmt.Errorf("unmarshal tenants: %w", err)
+	}
+	if len(k.Tenants) == 0 {
+		k.Tenants = nil
+	}
+	return k, nil
+}
+
+const auditColumns = `time, actor, key_id, action, tenant_id, operation_id, request_id, status, result, error`
+
+// RecordAudit appends an entry to the audit log and sets its ID.
+func (s *SQLStore) RecordAudit(e *AuditEntry) error {
+	err := s.db.QueryRow(`
+		INSERT INTO audit_log (`+auditColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)
+		RETURNING id`,
+		e.Time, e.Actor, e.KeyID, e.Action, e.TenantID, e.OperationID, e.RequestID, e.Status, e.Result, e.Error,
+	).Scan(&e.ID)
+	if err != nil {
+		return fmt.Errorf("insert audit entry: %w", err)
+	}
+	return nil
+}
+
+// ListAudit returns the entries matching f, newest first.
+func (s *SQLStore) ListAudit(f AuditFilter) ([]AuditEntry, error) {
+	var where []string
+	var args []any
+	add := func(cond string, arg any) {
+		args = append(args, arg)
+		where = append(where, strings.Replace(cond, "?", "$"+strconv.Itoa(len(args)), 1))
+	}
+	if f.TenantID != "" {
+		add("tenant_id = ?", f.TenantID)
+	}
+	if f.Actor != "" {
+		add("actor = ?", f.Actor)
+	}
+	if !f.Since.IsZero() {
+		add("time >= ?", f.Since)
+	}
+	query := `SELECT id, ` + auditColumns + ` FROM audit_log`
+	if len(where) > 0 {
+		query += ` WHERE ` + strings.Join(where, " AND ")
+	}
+	args = append(args, f.Limit)
+	query += ` ORDER BY time DESC, id DESC LIMIT $` + strconv.Itoa(len(args))
+
+	rows, err := s.db.Query(query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("list audit log: %w", err)
+	}
+	defer rows.Close()
+
+	var entries []AuditEntry
+	for rows.Next() {
+		var e AuditEntry
+		err := rows.Scan(&e.ID, &e.Time, &e.Actor, &e.KeyID, &e.Action, &e.TenantID, &e.OperationID, &e.RequestID,
+			&e.Status, &e.Result, &e.Error)
+		if err != nil {
+			return nil, fmt.Errorf("scan audit entry: %w", err)
+		}
+		entries = append(entries, e)
+	}
+	return entries, rows.Err()
+}
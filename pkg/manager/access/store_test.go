@@ -0,0 +1,96 @@
+package access
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSQLiteStore(t *testing.T) *SQLStore {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	store, err := NewSQLiteStore(db)
+	require.NoError(t, err)
+	return store
+}
+
+func TestSQLiteStore_Keys(t *testing.T) {
+	store := newSQLiteStore(t)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	require.NoError(t, store.CreateKey(&Key{
+		ID: "key-a", Name: "ci", Hash: "h1", Scopes: []string{ScopeWrite}, Tenants: []string{"acme"}, CreatedAt: now,
+	}))
+	require.NoError(t, store.CreateKey(&Key{
+		ID: "key-b", Name: "ops", Hash: "h2", Scopes: []string{ScopeAdmin}, CreatedAt: now.Add(time.Second),
+	}))
+
+	got, err := store.GetKey("key-a")
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, "ci", got.Name)
+	assert.Equal(t, []string{ScopeWrite}, got.Scopes)
+	assert.Equal(t, []string{"acme"}, got.Tenants)
+	assert.True(t, got.CreatedAt.Equal(now))
+	assert.Nil(t, got.RotatedAt)
+
+	missing, err := store.GetKey("key-missing")
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+
+	revoked := now.Add(time.Minute)
+	got.Hash = "h3"
+	got.RevokedAt = &revoked
+	require.NoError(t, store.UpdateKey(got))
+
+	keys, err := store.ListKeys()
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	assert.Equal(t, "key-a", keys[0].ID)
+	assert.Equal(t, "h3", keys[0].Hash)
+	require.NotNil(t, keys[0].RevokedAt)
+	assert.True(t, keys[0].RevokedAt.Equal(revoked))
+	assert.Nil(t, keys[1].Tenants)
+}
+
+func TestSQLiteStore_Audit(t *testing.T) {
+	store := newSQLiteStore(t)
+	base := time.Now().UTC().Truncate(time.Second)
+
+	for i, e := range []AuditEntry{
+		{Actor: "ci", Action: "POST /tenants", TenantID: "acme", OperationID: "op-1", Status: 202, Result: AuditSucceeded},
+		{Actor: "ci", Action: "DELETE /tenants/{id}", TenantID: "beta", Status: 403, Result: AuditDenied, Error: "nope"},
+		{Actor: "ops", Action: "POST /tenants/{id}/suspend", TenantID: "acme", Status: 202, Result: AuditSucceeded},
+	} {
+		e.Time = base.Add(time.Duration(i) * time.Minute)
+		e.RequestID = "req"
+		require.NoError(t, store.RecordAudit(&e))
+		assert.Equal(t, int64(i+1), e.ID)
+	}
+
+	all, err := store.ListAudit(AuditFilter{Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, all, 3)
+	assert.Equal(t, "ops", all[0].Actor)
+	assert.Equal(t, "nope", all[1].Error)
+	assert.Equal(t, "op-1", all[2].OperationID)
+
+	acme, err := store.ListAudit(AuditFilter{TenantID: "acme", Limit: 10})
+	require.NoError(t, err)
+	assert.Len(t, acme, 2)
+
+	ci, err := store.ListAudit(AuditFilter{Actor: "ci", Since: base.Add(time.Minute), Limit: 10})
+	require.NoError(t, err)
+	require.Len(t, ci, 1)
+	assert.Equal(t, "beta", ci[0].TenantID)
+
+	limited, err := store.ListAudit(AuditFilter{Limit: 1})
+	require.NoError(t, err)
+	require.Len(t, limited, 1)
+	assert.Equal(t, int64(3), limited[0].ID)
+}
@@ -1,22 +1,31 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
 	"log"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/go-chi/chi/v5"
+	"github.com/sipeed/picoclaw/pkg/manager/access"
 	"github.com/sipeed/picoclaw/pkg/manager/tenant"
 )
 
 // Handlers implements the tenant management REST API.
 type Handlers struct {
-	svc *tenant.Service
+	svc  *tenant.Service
+	keys *access.Service
 }
 
-// NewHandlers creates Handlers with a tenant service.
-func NewHandlers(svc *tenant.Service) *Handlers {
-	return &Handlers{svc: svc}
+// NewHandlers creates Handlers with a tenant service and the API keys.
+func NewHandlers(svc *tenant.Service, keys *access.Service) *Handlers {
+	return &Handlers{svc: svc, keys: keys}
 }
 
 // CreateTenant handles POST /api/v1/tenants.
@@ -26,6 +35,9 @@ func (h *Handlers) CreateTenant(w http.ResponseWriter, r *http.Request) {
 		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid json: " + err.Error()})
 		return
 	}
+	if !allowTenant(w, r, req.TenantID) {
+		return
+	}
 
 	var resources *tenant.Resources
 	if req.Resources != nil {
@@ -38,35 +50,38 @@ func (h *Handlers) CreateTenant(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	t, err := h.svc.Create(r.Context(), tenant.CreateRequest{
-		TenantID:    req.TenantID,
-		DisplayName: req.DisplayName,
-		Providers:   req.Providers,
-		Agents:      req.Agents,
-		Channels:    req.Channels,
-		Resources:   resources,
+	op, err := h.svc.Create(r.Context(), tenant.CreateRequest{
+		TenantID:       req.TenantID,
+		DisplayName:    req.DisplayName,
+		Providers:      req.Providers,
+		Agents:         req.Agents,
+		Channels:       req.Channels,
+		Resources:      resources,
+		Labels:         req.Labels,
+		IdempotencyKey: r.Header.Get("Idempotency-Key"),
 	})
 	if err != nil {
-		log.Printf("create tenant error: %v", err)
-		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		writeError(w, "create tenant", err)
 		return
 	}
-
-	writeJSON(w, http.StatusCreated, toTenantResponse(t))
+	writeOperation(w, op)
 }
 
-// ListTenants handles GET /api/v1/tenants.
+// ListTenants handles GET /api/v1/tenants. Keys limited to some tenants
+// only see those.
 func (h *Handlers) ListTenants(w http.ResponseWriter, r *http.Request) {
 	tenants, err := h.svc.List(r.Context())
 	if err != nil {
-		log.Printf("list tenants error: %v", err)
-		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		writeError(w, "list tenants", err)
 		return
 	}
 
-	resp := make([]TenantResponse, len(tenants))
+	p := principalFrom(r.Context())
+	resp := make([]TenantResponse, 0, len(tenants))
 	for i := range tenants {
-		resp[i] = toTenantResponse(&tenants[i])
+		if p.CanAccess(tenants[i].ID) {
+			resp = append(resp, toTenantResponse(&tenants[i]))
+		}
 	}
 	writeJSON(w, http.StatusOK, resp)
 }
@@ -76,8 +91,7 @@ func (h *Handlers) GetTenant(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
 	t, err := h.svc.Get(r.Context(), id)
 	if err != nil {
-		log.Printf("get tenant error: %v", err)
-		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		writeError(w, "get tenant", err)
 		return
 	}
 	if t == nil {
@@ -108,41 +122,458 @@ func (h *Handlers) UpdateTenant(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	t, err := h.svc.Update(r.Context(), id, tenant.UpdateRequest{
+	op, err := h.svc.Update(r.Context(), id, tenant.UpdateRequest{
 		DisplayName: req.DisplayName,
 		Providers:   req.Providers,
 		Agents:      req.Agents,
 		Channels:    req.Channels,
 		Resources:   resources,
+		Labels:      req.Labels,
 	})
 	if err != nil {
-		log.Printf("update tenant error: %v", err)
-		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+		writeError(w, "update tenant", err)
 		return
 	}
-	writeJSON(w, http.StatusOK, toTenantResponse(t))
+	writeOperation(w, op)
 }
 
-// DeleteTenant handles DELETE /api/v1/tenants/:id.
+// DeleteTenant handles DELETE /api/v1/tenants/:id. A final snapshot is
+// taken first unless ?skip_snapshot=true.
 func (h *Handlers) DeleteTenant(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	if err := h.svc.Delete(r.Context(), id); err != nil {
-		log.Printf("delete tenant error: %v", err)
-		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+	op, err := h.svc.Delete(r.Context(), id, tenant.DeleteRequest{
+		SkipSnapshot: r.URL.Query().Get("skip_snapshot") == "true",
+	})
+	if err != nil {
+		writeError(w, "delete tenant", err)
 		return
 	}
-	w.WriteHeader(http.StatusNoContent)
+	writeOperation(w, op)
 }
 
 // RestartTenant handles POST /api/v1/tenants/:id/restart.
 func (h *Handlers) RestartTenant(w http.ResponseWriter, r *http.Request) {
 	id := chi.URLParam(r, "id")
-	if err := h.svc.Restart(r.Context(), id); err != nil {
-		log.Printf("restart tenant error: %v", err)
-		writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: err.Error()})
+	op, err := h.svc.Restart(r.Context(), id)
+	if err != nil {
+		writeError(w, "restart tenant", err)
+		return
+	}
+	writeOperation(w, op)
+}
+
+// SuspendTenant handles POST /api/v1/tenants/:id/suspend.
+func (h *Handlers) SuspendTenant(w http.ResponseWriter, r *http.Request) {
+	op, err := h.svc.Suspend(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, "suspend tenant", err)
+		return
+	}
+	writeOperation(w, op)
+}
+
+// ResumeTenant handles POST /api/v1/tenants/:id/resume.
+func (h *Handlers) ResumeTenant(w http.ResponseWriter, r *http.Request) {
+	op, err := h.svc.Resume(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, "resume tenant", err)
+		return
+	}
+	writeOperation(w, op)
+}
+
+// SnapshotTenant handles POST /api/v1/tenants/:id/snapshots.
+func (h *Handlers) SnapshotTenant(w http.ResponseWriter, r *http.Request) {
+	op, err := h.svc.Snapshot(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, "snapshot tenant", err)
+		return
+	}
+	writeOperation(w, op)
+}
+
+// ListSnapshots handles GET /api/v1/tenants/:id/snapshots.
+func (h *Handlers) ListSnapshots(w http.ResponseWriter, r *http.Request) {
+	snapshots, err := h.svc.ListSnapshots(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, "list snapshots", err)
+		return
+	}
+	resp := make([]SnapshotResponse, len(snapshots))
+	for i, sn := range snapshots {
+		resp[i] = SnapshotResponse{
+			ID:          sn.ID,
+			TenantID:    sn.TenantID,
+			OperationID: sn.OperationID,
+			Reason:      sn.Reason,
+			SizeBytes:   sn.SizeBytes,
+			CreatedAt:   sn.CreatedAt,
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// RestoreTenant handles POST /api/v1/tenants/:id/restore. A key limited
+// to some tenants may only restore their snapshots.
+func (h *Handlers) RestoreTenant(w http.ResponseWriter, r *http.Request) {
+	var req RestoreTenantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid json: " + err.Error()})
+		return
+	}
+	if principalFrom(r.Context()).Restricted() {
+		sn, err := h.svc.GetSnapshot(r.Context(), req.SnapshotID)
+		if err != nil {
+			writeError(w, "restore tenant", err)
+			return
+		}
+		if !allowTenant(w, r, sn.TenantID) {
+			return
+		}
+	}
+	op, err := h.svc.Restore(r.Context(), chi.URLParam(r, "id"), req.SnapshotID)
+	if err != nil {
+		writeError(w, "restore tenant", err)
+		return
+	}
+	writeOperation(w, op)
+}
+
+// CloneTenant handles POST /api/v1/tenants/:id/clone.
+func (h *Handlers) CloneTenant(w http.ResponseWriter, r *http.Request) {
+	var req CloneTenantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid json: " + err.Error()})
+		return
+	}
+	if !allowTenant(w, r, req.TenantID) {
+		return
+	}
+	op, err := h.svc.Clone(r.Context(), chi.URLParam(r, "id"), tenant.CloneRequest{
+		TenantID:    req.TenantID,
+		DisplayName: req.DisplayName,
+		SnapshotID:  req.SnapshotID,
+		Suspended:   req.Suspended,
+	})
+	if err != nil {
+		writeError(w, "clone tenant", err)
+		return
+	}
+	writeOperation(w, op)
+}
+
+// GetOperation handles GET /api/v1/operations/:id.
+func (h *Handlers) GetOperation(w http.ResponseWriter, r *http.Request) {
+	op, err := h.svc.GetOperation(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, "get operation", err)
+		return
+	}
+	if !allowTenant(w, r, op.TenantID) {
+		return
+	}
+	writeJSON(w, http.StatusOK, toOperationResponse(op))
+}
+
+// ListTenantOperations handles GET /api/v1/tenants/:id/operations.
+func (h *Handlers) ListTenantOperations(w http.ResponseWriter, r *http.Request) {
+	ops, err := h.svc.ListOperations(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, "list operations", err)
+		return
+	}
+	resp := make([]OperationResponse, len(ops))
+	for i := range ops {
+		resp[i] = toOperationResponse(&ops[i])
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// Log tail limits.
+const (
+	defaultLogTail = 100
+	maxLogTail     = 10000
+)
+
+// TenantLogs handles GET /api/v1/tenants/:id/logs?workload=&tail=&follow=.
+// The last tail lines of the workload are returned as text, or streamed as
+// server-sent events with follow=true or Accept: text/event-stream.
+// Following keeps the stream open for new lines.
+func (h *Handlers) TenantLogs(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	opts := tenant.LogOptions{Workload: q.Get("workload"), TailLines: defaultLogTail, Follow: q.Get("follow") == "true"}
+	if tail := q.Get("tail"); tail != "" {
+		n, err := strconv.ParseInt(tail, 10, 64)
+		if err != nil || n <= 0 || n > maxLogTail {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: fmt.Sprintf("tail must be a number from 1 to %d", maxLogTail)})
+			return
+		}
+		opts.TailLines = n
+	}
+
+	logs, err := h.svc.Logs(r.Context(), chi.URLParam(r, "id"), opts)
+	if err != nil {
+		writeError(w, "get tenant logs", err)
+		return
+	}
+	defer logs.Close()
+
+	if opts.Follow || strings.Contains(r.Header.Get("Accept"), "text/event-stream") {
+		streamLines(r.Context(), w, logs)
+		return
+	}
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	io.Copy(w, logs)
+}
+
+// TenantEvents handles GET /api/v1/tenants/:id/events.
+func (h *Handlers) TenantEvents(w http.ResponseWriter, r *http.Request) {
+	events, err := h.svc.Events(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, "get tenant events", err)
+		return
+	}
+	resp := make([]EventResponse, len(events))
+	for i, e := range events {
+		resp[i] = EventResponse{
+			Time:    e.Time,
+			Type:    e.Type,
+			Reason:  e.Reason,
+			Object:  e.Object,
+			Message: e.Message,
+			Count:   e.Count,
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// TenantUsage handles GET /api/v1/tenants/:id/usage?from=&to=, with days
+// as YYYY-MM-DD.
+func (h *Handlers) TenantUsage(w http.ResponseWriter, r *http.Request) {
+	var from, to time.Time
+	for _, p := range []struct {
+		name string
+		t    *time.Time
+	}{{"from", &from}, {"to", &to}} {
+		v := r.URL.Query().Get(p.name)
+		if v == "" {
+			continue
+		}
+		t, err := time.Parse(time.DateOnly, v)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: p.name + " must be a day as YYYY-MM-DD"})
+			return
+		}
+		*p.t = t
+	}
+
+	report, err := h.svc.Usage(r.Context(), chi.URLParam(r, "id"), from, to)
+	if err != nil {
+		writeError(w, "get tenant usage", err)
+		return
+	}
+	resp := UsageResponse{
+		TenantID: report.TenantID,
+		From:     report.From,
+		To:       report.To,
+		Total:    toUsageCounters(report.Total),
+		Days:     make([]UsageDayResponse, len(report.Days)),
+	}
+	for i, d := range report.Days {
+		resp.Days[i] = UsageDayResponse{Day: d.Day, UsageCountersResponse: toUsageCounters(d.UsageCounters)}
+	}
+	if last := report.Last; last != nil {
+		resp.Gateway = &GatewayUsageResponse{
+			StartedAt:             last.Since,
+			ReportedAt:            last.SampledAt,
+			UsageCountersResponse: toUsageCounters(last.UsageCounters),
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// GetSchema handles GET /api/v1/schema.
+func (h *Handlers) GetSchema(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/schema+json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(tenant.ConfigSchema())
+}
+
+// StartUpgrade handles POST /api/v1/upgrades.
+func (h *Handlers) StartUpgrade(w http.ResponseWriter, r *http.Request) {
+	var req UpgradeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid json: " + err.Error()})
+		return
+	}
+	u, err := h.svc.StartUpgrade(r.Context(), tenant.UpgradeRequest{
+		Image:            req.Image,
+		TemplateVersion:  req.TemplateVersion,
+		Selector:         req.Selector,
+		Canary:           req.Canary,
+		BatchSize:        req.BatchSize,
+		PauseAfterCanary: req.PauseAfterCanary,
+	})
+	if err != nil {
+		writeError(w, "start upgrade", err)
+		return
+	}
+	writeUpgrade(w, u)
+}
+
+// ListUpgrades handles GET /api/v1/upgrades.
+func (h *Handlers) ListUpgrades(w http.ResponseWriter, r *http.Request) {
+	upgrades, err := h.svc.ListUpgrades(r.Context())
+	if err != nil {
+		writeError(w, "list upgrades", err)
+		return
+	}
+	resp := make([]UpgradeResponse, len(upgrades))
+	for i := range upgrades {
+		resp[i] = toUpgradeResponse(&upgrades[i])
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// GetUpgrade handles GET /api/v1/upgrades/:id.
+func (h *Handlers) GetUpgrade(w http.ResponseWriter, r *http.Request) {
+	u, err := h.svc.GetUpgrade(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, "get upgrade", err)
+		return
+	}
+	writeJSON(w, http.StatusOK, toUpgradeResponse(u))
+}
+
+// PauseUpgrade handles POST /api/v1/upgrades/:id/pause.
+func (h *Handlers) PauseUpgrade(w http.ResponseWriter, r *http.Request) {
+	h.controlUpgrade(w, r, "pause upgrade", h.svc.PauseUpgrade)
+}
+
+// ResumeUpgrade handles POST /api/v1/upgrades/:id/resume.
+func (h *Handlers) ResumeUpgrade(w http.ResponseWriter, r *http.Request) {
+	h.controlUpgrade(w, r, "resume upgrade", h.svc.ResumeUpgrade)
+}
+
+// AbortUpgrade handles POST /api/v1/upgrades/:id/abort.
+func (h *Handlers) AbortUpgrade(w http.ResponseWriter, r *http.Request) {
+	h.controlUpgrade(w, r, "abort upgrade", h.svc.AbortUpgrade)
+}
+
+// RollbackUpgrade handles POST /api/v1/upgrades/:id/rollback.
+func (h *Handlers) RollbackUpgrade(w http.ResponseWriter, r *http.Request) {
+	h.controlUpgrade(w, r, "roll back upgrade", h.svc.RollbackUpgrade)
+}
+
+func (h *Handlers) controlUpgrade(w http.ResponseWriter, r *http.Request, action string,
+	control func(ctx context.Context, id string) (*tenant.Upgrade, error),
+) {
+	u, err := control(r.Context(), chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, action, err)
+		return
+	}
+	writeUpgrade(w, u)
+}
+
+// CreateKey handles POST /api/v1/keys. The token is only returned here and
+// by RotateKey.
+func (h *Handlers) CreateKey(w http.ResponseWriter, r *http.Request) {
+	var req CreateKeyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "invalid json: " + err.Error()})
+		return
+	}
+	key, token, err := h.keys.CreateKey(req.Name, req.Scopes, req.Tenants)
+	if err != nil {
+		writeError(w, "create key", err)
+		return
+	}
+	resp := toKeyResponse(key)
+	resp.Token = token
+	writeJSON(w, http.StatusCreated, resp)
+}
+
+// ListKeys handles GET /api/v1/keys.
+func (h *Handlers) ListKeys(w http.ResponseWriter, r *http.Request) {
+	keys, err := h.keys.ListKeys()
+	if err != nil {
+		writeError(w, "list keys", err)
+		return
+	}
+	resp := make([]KeyResponse, len(keys))
+	for i := range keys {
+		resp[i] = toKeyResponse(&keys[i])
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// RotateKey handles POST /api/v1/keys/:id/rotate.
+func (h *Handlers) RotateKey(w http.ResponseWriter, r *http.Request) {
+	key, token, err := h.keys.RotateKey(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, "rotate key", err)
+		return
+	}
+	resp := toKeyResponse(key)
+	resp.Token = token
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// RevokeKey handles DELETE /api/v1/keys/:id.
+func (h *Handlers) RevokeKey(w http.ResponseWriter, r *http.Request) {
+	key, err := h.keys.RevokeKey(chi.URLParam(r, "id"))
+	if err != nil {
+		writeError(w, "revoke key", err)
 		return
 	}
-	writeJSON(w, http.StatusOK, map[string]string{"status": "restarting"})
+	writeJSON(w, http.StatusOK, toKeyResponse(key))
+}
+
+// ListAudit handles GET /api/v1/audit?tenant_id=&actor=&since=&limit=.
+func (h *Handlers) ListAudit(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	filter := access.AuditFilter{TenantID: q.Get("tenant_id"), Actor: q.Get("actor")}
+	if since := q.Get("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "since must be an RFC 3339 time"})
+			return
+		}
+		filter.Since = t
+	}
+	if limit := q.Get("limit"); limit != "" {
+		n, err := strconv.Atoi(limit)
+		if err != nil || n <= 0 {
+			writeJSON(w, http.StatusBadRequest, ErrorResponse{Error: "limit must be a positive number"})
+			return
+		}
+		filter.Limit = n
+	}
+
+	entries, err := h.keys.Audit(filter)
+	if err != nil {
+		writeError(w, "list audit log", err)
+		return
+	}
+	resp := make([]AuditEntryResponse, len(entries))
+	for i, e := range entries {
+		resp[i] = AuditEntryResponse{
+			ID:          e.ID,
+			Time:        e.Time,
+			Actor:       e.Actor,
+			KeyID:       e.KeyID,
+			Action:      e.Action,
+			TenantID:    e.TenantID,
+			OperationID: e.OperationID,
+			RequestID:   e.RequestID,
+			Status:      e.Status,
+			Result:      e.Result,
+			Error:       e.Error,
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
 }
 
 // HealthCheck handles GET /health.
@@ -151,11 +582,24 @@ func (h *Handlers) HealthCheck(w http.ResponseWriter, r *http.Request) {
 }
 
 func toTenantResponse(t *tenant.Tenant) TenantResponse {
+	conditions := make([]ConditionResponse, len(t.Conditions))
+	for i, c := range t.Conditions {
+		conditions[i] = ConditionResponse{
+			Type:               c.Type,
+			Status:             c.Status,
+			Reason:             c.Reason,
+			Message:            c.Message,
+			LastTransitionTime: c.LastTransitionTime,
+		}
+	}
 	return TenantResponse{
 		ID:          t.ID,
 		DisplayName: t.DisplayName,
 		Namespace:   t.Namespace,
 		Status:      t.Status,
+		Conditions:  conditions,
+		Config:      t.ConfigJSON,
+		Secrets:     t.SecretPaths(),
 		Resources: ResourcesResponse{
 			AgentCPU:      t.Resources.AgentCPU,
 			AgentMemory:   t.Resources.AgentMemory,
@@ -163,9 +607,132 @@ func toTenantResponse(t *tenant.Tenant) TenantResponse {
 			GatewayMemory: t.Resources.GatewayMemory,
 			WorkspaceSize: t.Resources.WorkspaceSize,
 		},
-		CreatedAt: t.CreatedAt,
-		UpdatedAt: t.UpdatedAt,
+		Image:        t.Image,
+		Template:     t.TemplateVersion,
+		Labels:       t.Labels,
+		Suspended:    t.Suspended,
+		LastActivity: t.LastActivityAt,
+		CreatedAt:    t.CreatedAt,
+		UpdatedAt:    t.UpdatedAt,
+	}
+}
+
+func toKeyResponse(k *access.Key) KeyResponse {
+	return KeyResponse{
+		ID:        k.ID,
+		Name:      k.Name,
+		Scopes:    k.Scopes,
+		Tenants:   k.Tenants,
+		CreatedAt: k.CreatedAt,
+		RotatedAt: k.RotatedAt,
+		RevokedAt: k.RevokedAt,
+	}
+}
+
+func toUpgradeResponse(u *tenant.Upgrade) UpgradeResponse {
+	tenants := make([]UpgradeTenantResponse, len(u.Tenants))
+	for i, ut := range u.Tenants {
+		tenants[i] = UpgradeTenantResponse{
+			TenantID:            ut.TenantID,
+			Batch:               ut.Batch,
+			FromImage:           ut.From.Image,
+			FromTemplateVersion: ut.From.TemplateVersion,
+			Status:              ut.Status,
+			OperationID:         ut.OperationID,
+			Error:               ut.Error,
+		}
+	}
+	return UpgradeResponse{
+		ID:               u.ID,
+		Image:            u.Target.Image,
+		TemplateVersion:  u.Target.TemplateVersion,
+		Selector:         u.Selector,
+		Canary:           u.Canary,
+		BatchSize:        u.BatchSize,
+		PauseAfterCanary: u.PauseAfterCanary,
+		Status:           u.Status,
+		Progress:         u.Progress(),
+		Tenants:          tenants,
+		Error:            u.Error,
+		CreatedAt:        u.CreatedAt,
+		UpdatedAt:        u.UpdatedAt,
+		FinishedAt:       u.FinishedAt,
+	}
+}
+
+func toUsageCounters(c tenant.UsageCounters) UsageCountersResponse {
+	return UsageCountersResponse{
+		MessagesIn:       c.MessagesIn,
+		MessagesOut:      c.MessagesOut,
+		LLMCalls:         c.LLMCalls,
+		PromptTokens:     c.PromptTokens,
+		CompletionTokens: c.CompletionTokens,
+		ToolCalls:        c.ToolCalls,
+	}
+}
+
+func toOperationResponse(op *tenant.Operation) OperationResponse {
+	steps := make([]StepResponse, len(op.Steps))
+	for i, st := range op.Steps {
+		steps[i] = StepResponse{
+			Name:       st.Name,
+			Status:     st.Status,
+			Error:      st.Error,
+			StartedAt:  st.StartedAt,
+			FinishedAt: st.FinishedAt,
+		}
+	}
+	return OperationResponse{
+		ID:         op.ID,
+		TenantID:   op.TenantID,
+		Type:       op.Type,
+		Status:     op.Status,
+		Progress:   op.Progress(),
+		Steps:      steps,
+		Error:      op.Error,
+		RolledBack: op.RolledBack,
+		CreatedAt:  op.CreatedAt,
+		UpdatedAt:  op.UpdatedAt,
+		FinishedAt: op.FinishedAt,
+	}
+}
+
+// writeOperation answers a mutating call with 202 and the operation that
+// carries it out.
+func writeOperation(w http.ResponseWriter, op *tenant.Operation) {
+	w.Header().Set("Location", "/api/v1/operations/"+op.ID)
+	writeJSON(w, http.StatusAccepted, toOperationResponse(op))
+}
+
+// writeUpgrade answers a call that starts or steers an upgrade with 202:
+// the upgrade goes on in the background.
+func writeUpgrade(w http.ResponseWriter, u *tenant.Upgrade) {
+	w.Header().Set("Location", "/api/v1/upgrades/"+u.ID)
+	writeJSON(w, http.StatusAccepted, toUpgradeResponse(u))
+}
+
+// writeError maps service errors to status codes. Unexpected errors are
+// logged.
+func writeError(w http.ResponseWriter, action string, err error) {
+	status := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, tenant.ErrInvalid):
+		status = http.StatusBadRequest
+	case errors.Is(err, tenant.ErrNotFound):
+		status = http.StatusNotFound
+	case errors.Is(err, tenant.ErrConflict):
+		status = http.StatusConflict
+	default:
+		log.Printf("%s error: %v", action, err)
+	}
+	resp := ErrorResponse{Error: err.Error()}
+	var invalid *tenant.ValidationError
+	if errors.As(err, &invalid) {
+		for _, f := range invalid.Fields {
+			resp.Fields = append(resp.Fields, FieldErrorResponse{Field: f.Field, Message: f.Message})
+		}
 	}
+	writeJSON(w, status, resp)
 }
 
 func writeJSON(w http.ResponseWriter, status int, v interface{}) {
@@ -1,13 +1,37 @@
 package api
 
 import (
-	"crypto/subtle"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
 	"net/http"
 	"strings"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/sipeed/picoclaw/pkg/manager/access"
+)
+
+type contextKey int
+
+const (
+	principalKey contextKey = iota
+	auditKey
 )
 
-// APIKeyAuth returns middleware that validates the Authorization: Bearer <key> header.
-func APIKeyAuth(apiKey string) func(http.Handler) http.Handler {
+// principalFrom returns the principal set by Authenticate.
+func principalFrom(ctx context.Context) *access.Principal {
+	p, _ := ctx.Value(principalKey).(*access.Principal)
+	return p
+}
+
+// Authenticate returns middleware that validates the Authorization:
+// Bearer <key> header and makes the key's principal available to the
+// handlers.
+func Authenticate(keys *access.Service) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			auth := r.Header.Get("Authorization")
@@ -22,12 +46,157 @@ func APIKeyAuth(apiKey string) func(http.Handler) http.Handler {
 				return
 			}
 
-			if subtle.ConstantTimeCompare([]byte(token), []byte(apiKey)) != 1 {
+			p, err := keys.Authenticate(token)
+			if errors.Is(err, access.ErrUnauthorized) {
 				writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "invalid api key"})
 				return
 			}
+			if err != nil {
+				log.Printf("authenticate: %v", err)
+				writeJSON(w, http.StatusInternalServerError, ErrorResponse{Error: "authentication failed"})
+				return
+			}
+			if rec, ok := r.Context().Value(auditKey).(*auditRecord); ok {
+				rec.principal = p
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey, p)))
+		})
+	}
+}
 
+// RequireScope returns middleware that rejects keys without scope.
+func RequireScope(scope string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if p := principalFrom(r.Context()); p == nil || !p.Allows(scope) {
+				writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "api key lacks the " + scope + " scope"})
+				return
+			}
 			next.ServeHTTP(w, r)
 		})
 	}
 }
+
+// TenantAccess rejects keys limited to other tenants than the one in the
+// {id} URL parameter.
+func TenantAccess(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !allowTenant(w, r, chi.URLParam(r, "id")) {
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// allowTenant answers 403 and returns false if the request's key may not
+// act on the tenant.
+func allowTenant(w http.ResponseWriter, r *http.Request, tenantID string) bool {
+	if p := principalFrom(r.Context()); p == nil || !p.CanAccess(tenantID) {
+		writeJSON(w, http.StatusForbidden, ErrorResponse{Error: "api key is not allowed on tenant " + tenantID})
+		return false
+	}
+	return true
+}
+
+// auditRecord collects what Audit records about a request.
+type auditRecord struct {
+	principal *access.Principal
+}
+
+// maxAuditBody bounds how much of a response Audit keeps to find the
+// tenant of a create or the message of an error.
+const maxAuditBody = 64 << 10
+
+// auditWriter records the status and the start of the body of a response.
+type auditWriter struct {
+	http.ResponseWriter
+	status int
+	body   bytes.Buffer
+}
+
+func (w *auditWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if room := maxAuditBody - w.body.Len(); room > 0 {
+		w.body.Write(b[:min(len(b), room)])
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Audit returns middleware that records every mutating request in the
+// audit log: who made it, the route, the tenant, the operation started,
+// the request ID and how it ended. Reads are not recorded.
+func Audit(keys *access.Service) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method == http.MethodGet || r.Method == http.MethodHead || r.Method == http.MethodOptions {
+				next.ServeHTTP(w, r)
+				return
+			}
+			rec := &auditRecord{}
+			aw := &auditWriter{ResponseWriter: w}
+			started := time.Now().UTC()
+			next.ServeHTTP(aw, r.WithContext(context.WithValue(r.Context(), auditKey, rec)))
+
+			entry := auditEntry(r, aw, rec)
+			entry.Time = started
+			if err := keys.Record(entry); err != nil {
+				log.Printf("record audit entry for %s: %v", entry.Action, err)
+			}
+		})
+	}
+}
+
+func auditEntry(r *http.Request, w *auditWriter, rec *auditRecord) *access.AuditEntry {
+	// Requests rejected before routing have no pattern: keep their path.
+	path := strings.TrimPrefix(r.URL.Path, "/api/v1")
+	entry := &access.AuditEntry{
+		Action:    r.Method + " " + path,
+		RequestID: middleware.GetReqID(r.Context()),
+		Status:    w.status,
+	}
+	if rest, ok := strings.CutPrefix(path, "/tenants/"); ok {
+		entry.TenantID, _, _ = strings.Cut(rest, "/")
+	}
+	if rctx := chi.RouteContext(r.Context()); rctx != nil && rctx.RoutePattern() != "" && !strings.HasSuffix(rctx.RoutePattern(), "*") {
+		pattern := strings.TrimSuffix(strings.TrimPrefix(rctx.RoutePattern(), "/api/v1"), "/")
+		entry.Action = r.Method + " " + pattern
+	}
+	if rec.principal != nil {
+		entry.Actor, entry.KeyID = rec.principal.Name, rec.principal.KeyID
+	}
+	if loc := w.Header().Get("Location"); strings.HasPrefix(loc, "/api/v1/operations/") {
+		entry.OperationID = strings.TrimPrefix(loc, "/api/v1/operations/")
+	}
+
+	switch {
+	case w.status < 400:
+		entry.Result = access.AuditSucceeded
+		if entry.TenantID == "" {
+			// A create names its tenant in the body only.
+			var op OperationResponse
+			if json.Unmarshal(w.body.Bytes(), &op) == nil {
+				entry.TenantID = op.TenantID
+			}
+		}
+	case w.status == http.StatusUnauthorized || w.status == http.StatusForbidden:
+		entry.Result = access.AuditDenied
+	default:
+		entry.Result = access.AuditFailed
+	}
+	if w.status >= 400 {
+		var resp ErrorResponse
+		if json.Unmarshal(w.body.Bytes(), &resp) == nil {
+			entry.Error = resp.Error
+		}
+	}
+	return entry
+}
@@ -1,6 +1,9 @@
 package api
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 // CreateTenantRequest is the JSON body for POST /api/v1/tenants.
 type CreateTenantRequest struct {
@@ -10,6 +13,7 @@ type CreateTenantRequest struct {
 	Agents      map[string]interface{} `json:"agents"`
 	Channels    map[string]interface{} `json:"channels"`
 	Resources   *ResourcesRequest      `json:"resources,omitempty"`
+	Labels      map[string]string      `json:"labels,omitempty"` // used to pick tenants for upgrades
 }
 
 // UpdateTenantRequest is the JSON body for PUT /api/v1/tenants/:id.
@@ -19,6 +23,20 @@ type UpdateTenantRequest struct {
 	Agents      map[string]interface{} `json:"agents,omitempty"`
 	Channels    map[string]interface{} `json:"channels,omitempty"`
 	Resources   *ResourcesRequest      `json:"resources,omitempty"`
+	Labels      map[string]string      `json:"labels,omitempty"` // replaces all labels when set
+}
+
+// RestoreTenantRequest is the JSON body for POST /api/v1/tenants/:id/restore.
+type RestoreTenantRequest struct {
+	SnapshotID string `json:"snapshot_id"`
+}
+
+// CloneTenantRequest is the JSON body for POST /api/v1/tenants/:id/clone.
+type CloneTenantRequest struct {
+	TenantID    string `json:"tenant_id"`
+	DisplayName string `json:"display_name,omitempty"` // defaults to the source's
+	SnapshotID  string `json:"snapshot_id,omitempty"`  // defaults to a new snapshot
+	Suspended   bool   `json:"suspended,omitempty"`    // create the clone without starting it
 }
 
 // ResourcesRequest mirrors tenant.Resources for API input.
@@ -32,13 +50,54 @@ type ResourcesRequest struct {
 
 // TenantResponse is the JSON response for tenant operations.
 type TenantResponse struct {
-	ID          string            `json:"id"`
-	DisplayName string            `json:"display_name"`
-	Namespace   string            `json:"namespace"`
-	Status      string            `json:"status"`
-	Resources   ResourcesResponse `json:"resources"`
-	CreatedAt   time.Time         `json:"created_at"`
-	UpdatedAt   time.Time         `json:"updated_at"`
+	ID           string              `json:"id"`
+	DisplayName  string              `json:"display_name"`
+	Namespace    string              `json:"namespace"`
+	Status       string              `json:"status"`
+	Conditions   []ConditionResponse `json:"conditions"`
+	Config       json.RawMessage     `json:"config"`
+	Secrets      []string            `json:"secrets"` // config paths of stored secrets; values are never returned
+	Resources    ResourcesResponse   `json:"resources"`
+	Image        string              `json:"image"`
+	Template     string              `json:"template_version"`
+	Labels       map[string]string   `json:"labels,omitempty"`
+	Suspended    bool                `json:"suspended"`
+	LastActivity *time.Time          `json:"last_activity_at,omitempty"` // last inbound traffic seen, or the last resume
+	CreatedAt    time.Time           `json:"created_at"`
+	UpdatedAt    time.Time           `json:"updated_at"`
+}
+
+// ConditionResponse mirrors tenant.Condition for API output.
+type ConditionResponse struct {
+	Type               string    `json:"type"`
+	Status             string    `json:"status"`
+	Reason             string    `json:"reason,omitempty"`
+	Message            string    `json:"message,omitempty"`
+	LastTransitionTime time.Time `json:"last_transition_time"`
+}
+
+// OperationResponse is the JSON response for an asynchronous operation.
+type OperationResponse struct {
+	ID         string         `json:"id"`
+	TenantID   string         `json:"tenant_id"`
+	Type       string         `json:"type"`
+	Status     string         `json:"status"`
+	Progress   int            `json:"progress"` // percent of steps finished
+	Steps      []StepResponse `json:"steps"`
+	Error      string         `json:"error,omitempty"`
+	RolledBack bool           `json:"rolled_back"`
+	CreatedAt  time.Time      `json:"created_at"`
+	UpdatedAt  time.Time      `json:"updated_at"`
+	FinishedAt *time.Time     `json:"finished_at,omitempty"`
+}
+
+// StepResponse mirrors tenant.Step for API output.
+type StepResponse struct {
+	Name       string     `json:"name"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
 }
 
 // ResourcesResponse mirrors tenant.Resources for API output.
@@ -50,7 +109,143 @@ type ResourcesResponse struct {
 	WorkspaceSize string `json:"workspace_size"`
 }
 
+// SnapshotResponse mirrors tenant.Snapshot for API output.
+type SnapshotResponse struct {
+	ID          string    `json:"id"`
+	TenantID    string    `json:"tenant_id"`
+	OperationID string    `json:"operation_id"`
+	Reason      string    `json:"reason"` // manual, final or clone
+	SizeBytes   int64     `json:"size_bytes"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// UpgradeRequest is the JSON body for POST /api/v1/upgrades. An empty
+// image or template version means the manager's default.
+type UpgradeRequest struct {
+	Image            string            `json:"image,omitempty"`
+	TemplateVersion  string            `json:"template_version,omitempty"`
+	Selector         map[string]string `json:"selector,omitempty"`           // labels of the tenants to upgrade; empty means all
+	Canary           map[string]string `json:"canary,omitempty"`             // labels of the tenants upgraded first
+	BatchSize        int               `json:"batch_size,omitempty"`         // defaults to 5
+	PauseAfterCanary bool              `json:"pause_after_canary,omitempty"` // wait for resume once the canary is ready
+}
+
+// UpgradeResponse mirrors tenant.Upgrade for API output.
+type UpgradeResponse struct {
+	ID               string                  `json:"id"`
+	Image            string                  `json:"image"`
+	TemplateVersion  string                  `json:"template_version"`
+	Selector         map[string]string       `json:"selector,omitempty"`
+	Canary           map[string]string       `json:"canary,omitempty"`
+	BatchSize        int                     `json:"batch_size"`
+	PauseAfterCanary bool                    `json:"pause_after_canary"`
+	Status           string                  `json:"status"`
+	Progress         int                     `json:"progress"` // percent of tenants done
+	Tenants          []UpgradeTenantResponse `json:"tenants"`
+	Error            string                  `json:"error,omitempty"`
+	CreatedAt        time.Time               `json:"created_at"`
+	UpdatedAt        time.Time               `json:"updated_at"`
+	FinishedAt       *time.Time              `json:"finished_at,omitempty"`
+}
+
+// UpgradeTenantResponse mirrors tenant.UpgradeTenant for API output.
+type UpgradeTenantResponse struct {
+	TenantID            string `json:"tenant_id"`
+	Batch               int    `json:"batch"` // 0 is the canary batch when there is one
+	FromImage           string `json:"from_image"`
+	FromTemplateVersion string `json:"from_template_version"`
+	Status              string `json:"status"`
+	OperationID         string `json:"operation_id,omitempty"`
+	Error               string `json:"error,omitempty"`
+}
+
+// EventResponse mirrors tenant.Event for API output.
+type EventResponse struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"` // Normal or Warning
+	Reason  string    `json:"reason"`
+	Object  string    `json:"object"` // kind/name, e.g. Pod/picoclaw-agent-7d9f
+	Message string    `json:"message"`
+	Count   int32     `json:"count"`
+}
+
+// UsageResponse mirrors tenant.UsageReport for API output.
+type UsageResponse struct {
+	TenantID string                `json:"tenant_id"`
+	From     string                `json:"from"` // first day, YYYY-MM-DD (UTC)
+	To       string                `json:"to"`   // last day, included
+	Total    UsageCountersResponse `json:"total"`
+	Days     []UsageDayResponse    `json:"days"` // days without traffic are left out
+	Gateway  *GatewayUsageResponse `json:"gateway,omitempty"`
+}
+
+// UsageCountersResponse mirrors tenant.UsageCounters for API output.
+type UsageCountersResponse struct {
+	MessagesIn       int64 `json:"messages_in"`
+	MessagesOut      int64 `json:"messages_out"`
+	LLMCalls         int64 `json:"llm_calls"`
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	ToolCalls        int64 `json:"tool_calls"`
+}
+
+// UsageDayResponse is a tenant's usage on one UTC day.
+type UsageDayResponse struct {
+	Day string `json:"day"`
+	UsageCountersResponse
+}
+
+// GatewayUsageResponse is the gateway's last report: what it counted since
+// it started.
+type GatewayUsageResponse struct {
+	StartedAt  time.Time `json:"started_at"`
+	ReportedAt time.Time `json:"reported_at"`
+	UsageCountersResponse
+}
+
+// CreateKeyRequest is the JSON body for POST /api/v1/keys.
+type CreateKeyRequest struct {
+	Name    string   `json:"name"`
+	Scopes  []string `json:"scopes"`            // read, write and/or admin
+	Tenants []string `json:"tenants,omitempty"` // limit the key to these tenants
+}
+
+// KeyResponse mirrors access.Key for API output. Token is only set when a
+// key is created or rotated.
+type KeyResponse struct {
+	ID        string     `json:"id"`
+	Name      string     `json:"name"`
+	Scopes    []string   `json:"scopes"`
+	Tenants   []string   `json:"tenants,omitempty"`
+	Token     string     `json:"token,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+	RotatedAt *time.Time `json:"rotated_at,omitempty"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+// AuditEntryResponse mirrors access.AuditEntry for API output.
+type AuditEntryResponse struct {
+	ID          int64     `json:"id"`
+	Time        time.Time `json:"time"`
+	Actor       string    `json:"actor"`
+	KeyID       string    `json:"key_id,omitempty"`
+	Action      string    `json:"action"`
+	TenantID    string    `json:"tenant_id,omitempty"`
+	OperationID string    `json:"operation_id,omitempty"`
+	RequestID   string    `json:"request_id,omitempty"`
+	Status      int       `json:"status"`
+	Result      string    `json:"result"` // succeeded, denied or failed
+	Error       string    `json:"error,omitempty"`
+}
+
 // ErrorResponse is a standard error payload.
 type ErrorResponse struct {
-	Error string `json:"error"`
+	Error  string               `json:"error"`
+	Fields []FieldErrorResponse `json:"fields,omitempty"` // invalid config fields
+}
+
+// FieldErrorResponse mirrors tenant.FieldError for API output.
+type FieldErrorResponse struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
 }
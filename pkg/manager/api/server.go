@@ -5,12 +5,16 @@ import (
 
 	"github.com/go-chi/chi/v5"
 	"github.com/go-chi/chi/v5/middleware"
+	"github.com/sipeed/picoclaw/pkg/manager/access"
 	"github.com/sipeed/picoclaw/pkg/manager/tenant"
 )
 
 // NewServer creates and configures the HTTP router.
-func NewServer(svc *tenant.Service, apiKey string) http.Handler {
-	h := NewHandlers(svc)
+func NewServer(svc *tenant.Service, keys *access.Service) http.Handler {
+	h := NewHandlers(svc, keys)
+	read := RequireScope(access.ScopeRead)
+	write := RequireScope(access.ScopeWrite)
+	admin := RequireScope(access.ScopeAdmin)
 
 	r := chi.NewRouter()
 	r.Use(middleware.Logger)
@@ -22,14 +26,44 @@ func NewServer(svc *tenant.Service, apiKey string) http.Handler {
 
 	// Protected API endpoints.
 	r.Route("/api/v1", func(r chi.Router) {
-		r.Use(APIKeyAuth(apiKey))
-
-		r.Post("/tenants", h.CreateTenant)
-		r.Get("/tenants", h.ListTenants)
-		r.Get("/tenants/{id}", h.GetTenant)
-		r.Put("/tenants/{id}", h.UpdateTenant)
-		r.Delete("/tenants/{id}", h.DeleteTenant)
-		r.Post("/tenants/{id}/restart", h.RestartTenant)
+		r.Use(Audit(keys))
+		r.Use(Authenticate(keys))
+
+		r.With(write).Post("/tenants", h.CreateTenant)
+		r.With(read).Get("/tenants", h.ListTenants)
+		r.Route("/tenants/{id}", func(r chi.Router) {
+			r.Use(TenantAccess)
+			r.With(read).Get("/", h.GetTenant)
+			r.With(write).Put("/", h.UpdateTenant)
+			r.With(write).Delete("/", h.DeleteTenant)
+			r.With(write).Post("/restart", h.RestartTenant)
+			r.With(write).Post("/suspend", h.SuspendTenant)
+			r.With(write).Post("/resume", h.ResumeTenant)
+			r.With(write).Post("/snapshots", h.SnapshotTenant)
+			r.With(read).Get("/snapshots", h.ListSnapshots)
+			r.With(write).Post("/restore", h.RestoreTenant)
+			r.With(write).Post("/clone", h.CloneTenant)
+			r.With(read).Get("/operations", h.ListTenantOperations)
+			r.With(read).Get("/logs", h.TenantLogs)
+			r.With(read).Get("/events", h.TenantEvents)
+			r.With(read).Get("/usage", h.TenantUsage)
+		})
+		r.With(read).Get("/operations/{id}", h.GetOperation)
+		r.With(read).Get("/schema", h.GetSchema)
+
+		r.With(admin).Post("/upgrades", h.StartUpgrade)
+		r.With(admin).Get("/upgrades", h.ListUpgrades)
+		r.With(admin).Get("/upgrades/{id}", h.GetUpgrade)
+		r.With(admin).Post("/upgrades/{id}/pause", h.PauseUpgrade)
+		r.With(admin).Post("/upgrades/{id}/resume", h.ResumeUpgrade)
+		r.With(admin).Post("/upgrades/{id}/abort", h.AbortUpgrade)
+		r.With(admin).Post("/upgrades/{id}/rollback", h.RollbackUpgrade)
+
+		r.With(admin).Post("/keys", h.CreateKey)
+		r.With(admin).Get("/keys", h.ListKeys)
+		r.With(admin).Post("/keys/{id}/rotate", h.RotateKey)
+		r.With(admin).Delete("/keys/{id}", h.RevokeKey)
+		r.With(admin).Get("/audit", h.ListAudit)
 	})
 
 	return r
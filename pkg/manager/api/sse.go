@@ -0,0 +1,81 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// sseKeepAlive is how often an idle event stream sends a comment, so that
+// proxies do not close it.
+const sseKeepAlive = 15 * time.Second
+
+// maxLogLine is the longest log line streamed whole. Longer lines are cut.
+const maxLogLine = 256 * 1024
+
+// streamLines sends each line of r as a server-sent event until r ends or
+// the client goes away. A final "end" event tells the client that the log
+// ended rather than the connection.
+func streamLines(ctx context.Context, w http.ResponseWriter, r io.Reader) {
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	rc := http.NewResponseController(w)
+	rc.Flush()
+
+	lines := make(chan string)
+	done := make(chan error, 1)
+	go func() {
+		br := bufio.NewReader(r)
+		var line []byte
+		for {
+			chunk, more, err := br.ReadLine()
+			if err != nil {
+				if err == io.EOF {
+					err = nil
+				}
+				done <- err
+				return
+			}
+			if room := maxLogLine - len(line); room > 0 {
+				line = append(line, chunk[:min(len(chunk), room)]...)
+			}
+			if more {
+				continue
+			}
+			select {
+			case lines <- string(line):
+			case <-ctx.Done():
+				return
+			}
+			line = line[:0]
+		}
+	}()
+
+	keepAlive := time.NewTicker(sseKeepAlive)
+	defer keepAlive.Stop()
+	for {
+		select {
+		case line := <-lines:
+			// A data field ends at a line break: strip stray carriage returns.
+			fmt.Fprintf(w, "data: %s\n\n", strings.ReplaceAll(line, "\r", ""))
+		case <-keepAlive.C:
+			fmt.Fprint(w, ": keep-alive\n\n")
+		case err := <-done:
+			if err != nil {
+				fmt.Fprintf(w, "event: error\ndata: %s\n\n", err)
+			}
+			fmt.Fprint(w, "event: end\ndata: \n\n")
+			rc.Flush()
+			return
+		case <-ctx.Done():
+			return
+		}
+		rc.Flush()
+	}
+}
@@ -0,0 +1,104 @@
+package archive
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sipeed/picoclaw/pkg/manager/tenant"
+)
+
+func roundTrip(t *testing.T, store tenant.ArchiveStore) {
+	t.Helper()
+	ctx := context.Background()
+	require.NoError(t, store.Put(ctx, "acme/snap-1.tar.gz", strings.NewReader("archive"), 7))
+
+	r, err := store.Get(ctx, "acme/snap-1.tar.gz")
+	require.NoError(t, err)
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, "archive", string(data))
+
+	_, err = store.Get(ctx, "acme/snap-2.tar.gz")
+	assert.Error(t, err, "missing archive")
+}
+
+func TestDir(t *testing.T) {
+	root := t.TempDir()
+	store, err := NewDir(root)
+	require.NoError(t, err)
+	roundTrip(t, store)
+	assert.FileExists(t, filepath.Join(root, "acme", "snap-1.tar.gz"))
+
+	ctx := context.Background()
+	assert.Error(t, store.Put(ctx, "../escaped", strings.NewReader("x"), 1))
+	assert.Error(t, store.Put(ctx, "acme/short", strings.NewReader("x"), 2), "truncated upload")
+	assert.NoFileExists(t, filepath.Join(root, "acme", "short"))
+	entries, err := os.ReadDir(filepath.Join(root, "acme"))
+	require.NoError(t, err)
+	assert.Len(t, entries, 1, "no temporary files are left behind")
+}
+
+func TestS3(t *testing.T) {
+	var mu sync.Mutex
+	objects := make(map[string]string)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=key/") {
+			http.Error(w, "AccessDenied", http.StatusForbidden)
+			return
+		}
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.Method {
+		case http.MethodPut:
+			data, _ := io.ReadAll(r.Body)
+			if int64(len(data)) != r.ContentLength {
+				http.Error(w, "IncompleteBody", http.StatusBadRequest)
+				return
+			}
+			objects[r.URL.Path] = string(data)
+		case http.MethodGet:
+			data, ok := objects[r.URL.Path]
+			if !ok {
+				http.Error(w, "NoSuchKey", http.StatusNotFound)
+				return
+			}
+			io.WriteString(w, data)
+		}
+	}))
+	defer server.Close()
+
+	store, err := NewS3(S3Config{Endpoint: server.URL, Bucket: "backups", Prefix: "picoclaw/", AccessKey: "key", SecretKey: "secret"})
+	require.NoError(t, err)
+	roundTrip(t, store)
+	assert.Equal(t, "archive", objects["/backups/picoclaw/acme/snap-1.tar.gz"])
+
+	_, err = NewS3(S3Config{Endpoint: server.URL, Bucket: "backups"})
+	assert.Error(t, err, "credentials are required")
+}
+
+func TestS3_Sign(t *testing.T) {
+	store, err := NewS3(S3Config{Endpoint: "http://minio:9000", Bucket: "backups", Prefix: "picoclaw/", AccessKey: "key", SecretKey: "secret"})
+	require.NoError(t, err)
+	store.now = func() time.Time { return time.Date(2026, 2, 16, 10, 0, 0, 0, time.UTC) }
+
+	req, err := store.request(context.Background(), http.MethodPut, "acme/snap-1.tar.gz", nil)
+	require.NoError(t, err)
+	assert.Equal(t, "http://minio:9000/backups/picoclaw/acme/snap-1.tar.gz", req.URL.String())
+	assert.Equal(t, "20260216T100000Z", req.Header.Get("X-Amz-Date"))
+	assert.Equal(t, "AWS4-HMAC-SHA256 Credential=key/20260216/us-east-1/s3/aws4_request, "+
+		"SignedHeaders=host;x-amz-content-sha256;x-amz-date, "+
+		"Signature=320bbab1b31c2cbbb3c056ed64a8f475691d370c31ba0189d158cf73c17de858",
+		req.Header.Get("Authorization"))
+}
@@ -0,0 +1,75 @@
+// Package archive stores workspace snapshot archives, either in a local
+// directory or in an S3-compatible object store. Both implement
+// tenant.ArchiveStore.
+package archive
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/sipeed/picoclaw/pkg/manager/tenant"
+)
+
+// Dir stores archives as files below a directory, for single-host installs
+// or a mounted volume.
+type Dir struct {
+	root string
+}
+
+var _ tenant.ArchiveStore = (*Dir)(nil)
+
+// NewDir creates a Dir, creating root if needed.
+func NewDir(root string) (*Dir, error) {
+	if err := os.MkdirAll(root, 0o700); err != nil {
+		return nil, fmt.Errorf("create %s: %w", root, err)
+	}
+	return &Dir{root: root}, nil
+}
+
+func (d *Dir) path(key string) (string, error) {
+	name := filepath.FromSlash(key)
+	if !filepath.IsLocal(name) {
+		return "", fmt.Errorf("invalid archive key %q", key)
+	}
+	return filepath.Join(d.root, name), nil
+}
+
+// Put writes the archive to a temporary file first, so that a failed
+// write never leaves a truncated archive under key.
+func (d *Dir) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	path, err := d.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o700); err != nil {
+		return err
+	}
+	f, err := os.CreateTemp(filepath.Dir(path), ".upload-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	n, err := io.Copy(f, r)
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		return fmt.Errorf("write %s: %w", key, err)
+	}
+	if n != size {
+		return fmt.Errorf("write %s: got %d bytes, want %d", key, n, size)
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// Get opens the archive stored under key.
+func (d *Dir) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	path, err := d.path(key)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
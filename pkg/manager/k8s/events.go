@@ -0,0 +1,70 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Event is a Kubernetes event about an object in a namespace.
+type Event struct {
+	Time    time.Time
+	Type    string // Normal or Warning
+	Reason  string
+	Object  string // kind/name
+	Message string
+	Count   int32
+}
+
+// Events returns the events in a namespace.
+func (a *Applier) Events(ctx context.Context, namespace string) ([]Event, error) {
+	return Events(ctx, a.client.Clientset, namespace)
+}
+
+// Events returns the events in a namespace, newest first. Kubernetes keeps
+// them for an hour by default.
+func Events(ctx context.Context, cs kubernetes.Interface, namespace string) ([]Event, error) {
+	list, err := cs.CoreV1().Events(namespace).List(ctx, metav1.ListOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("list events: %w", err)
+	}
+	events := make([]Event, len(list.Items))
+	for i := range list.Items {
+		e := &list.Items[i]
+		events[i] = Event{
+			Time:    eventTime(e),
+			Type:    e.Type,
+			Reason:  e.Reason,
+			Object:  e.InvolvedObject.Kind + "/" + e.InvolvedObject.Name,
+			Message: e.Message,
+			Count:   e.Count,
+		}
+		if e.Series != nil {
+			events[i].Count = e.Series.Count
+		}
+		if events[i].Count == 0 {
+			events[i].Count = 1
+		}
+	}
+	sort.SliceStable(events, func(i, j int) bool { return events[i].Time.After(events[j].Time) })
+	return events, nil
+}
+
+// eventTime is when an event last happened. Events from the newer events
+// API only set EventTime and the series.
+func eventTime(e *corev1.Event) time.Time {
+	switch {
+	case e.Series != nil && !e.Series.LastObservedTime.IsZero():
+		return e.Series.LastObservedTime.Time
+	case !e.LastTimestamp.IsZero():
+		return e.LastTimestamp.Time
+	case !e.EventTime.IsZero():
+		return e.EventTime.Time
+	}
+	return e.CreationTimestamp.Time
+}
@@ -0,0 +1,48 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+	"io"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+)
+
+// Logs streams the logs of a deployment's main container.
+func (a *Applier) Logs(ctx context.Context, namespace, deployment string, tailLines int64, follow bool) (io.ReadCloser, error) {
+	return Logs(ctx, a.client.Clientset, namespace, deployment, tailLines, follow)
+}
+
+// Logs streams the logs of the container named after the deployment, from
+// its newest pod. The pods are found by the deployment's selector.
+func Logs(ctx context.Context, cs kubernetes.Interface, namespace, deployment string, tailLines int64, follow bool) (io.ReadCloser, error) {
+	dep, err := cs.AppsV1().Deployments(namespace).Get(ctx, deployment, metav1.GetOptions{})
+	if err != nil {
+		return nil, fmt.Errorf("get deployment %s: %w", deployment, err)
+	}
+	selector, err := metav1.LabelSelectorAsSelector(dep.Spec.Selector)
+	if err != nil {
+		return nil, fmt.Errorf("deployment %s selector: %w", deployment, err)
+	}
+	pods, err := cs.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, fmt.Errorf("list pods of %s: %w", deployment, err)
+	}
+	var newest *corev1.Pod
+	for i := range pods.Items {
+		if newest == nil || newest.CreationTimestamp.Before(&pods.Items[i].CreationTimestamp) {
+			newest = &pods.Items[i]
+		}
+	}
+	if newest == nil {
+		return nil, fmt.Errorf("deployment %s has no pods", deployment)
+	}
+
+	opts := &corev1.PodLogOptions{Container: deployment, Follow: follow}
+	if tailLines > 0 {
+		opts.TailLines = &tailLines
+	}
+	return cs.CoreV1().Pods(namespace).GetLogs(newest.Name, opts).Stream(ctx)
+}
@@ -0,0 +1,25 @@
+package k8s
+
+import (
+	"context"
+	"fmt"
+
+	"k8s.io/client-go/kubernetes"
+)
+
+// ProxyGet sends a GET for path to a service's port through the API
+// server, so the manager can reach tenant services from outside the
+// cluster too.
+func (a *Applier) ProxyGet(ctx context.Context, namespace, service, port, path string) ([]byte, error) {
+	return ProxyGet(ctx, a.client.Clientset, namespace, service, port, path)
+}
+
+// ProxyGet sends a GET for path to a service's port through the API
+// server and returns the response body.
+func ProxyGet(ctx context.Context, cs kubernetes.Interface, namespace, service, port, path string) ([]byte, error) {
+	body, err := cs.CoreV1().Services(namespace).ProxyGet("http", service, port, path, nil).DoRaw(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("get %s from service %s: %w", path, service, err)
+	}
+	return body, nil
+}
@@ -0,0 +1,319 @@
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+)
+
+// TenantState is the observed state of a tenant's namespace compared with
+// its rendered manifests.
+type TenantState struct {
+	NamespaceExists bool
+	Terminating     bool // namespace is being deleted
+	Missing         []string
+	Drifted         []string // "<Kind>/<name>: <what differs>"
+	Deployments     []DeploymentState
+}
+
+// DeploymentState is the observed health of one Deployment.
+type DeploymentState struct {
+	Name          string
+	Replicas      int32
+	ReadyReplicas int32
+	Available     bool
+	CrashLooping  bool
+	Stalled       bool   // rollout exceeded its progress deadline or pods cannot start
+	Reason        string // short CamelCase reason when not available
+	Message       string
+}
+
+// InSync reports whether every object of the manifests exists and matches.
+func (s *TenantState) InSync() bool {
+	return len(s.Missing) == 0 && len(s.Drifted) == 0
+}
+
+// ParseManifests splits multi-document YAML into objects the way Apply
+// does.
+func ParseManifests(manifests []byte) ([]*unstructured.Unstructured, error) {
+	return parseYAMLDocuments(manifests)
+}
+
+// Inspect compares the live objects in namespace with manifests.
+func (a *Applier) Inspect(ctx context.Context, namespace string, manifests []byte) (*TenantState, error) {
+	return Inspect(ctx, a.client.Clientset, namespace, manifests)
+}
+
+// Inspect compares the live objects in namespace with manifests, using only
+// the typed clientset so that it works with the client-go fake. Objects of
+// kinds it does not know are assumed to be in sync.
+func Inspect(ctx context.Context, cs kubernetes.Interface, namespace string, manifests []byte) (*TenantState, error) {
+	objects, err := parseYAMLDocuments(manifests)
+	if err != nil {
+		return nil, fmt.Errorf("parse manifests: %w", err)
+	}
+
+	state := &TenantState{}
+	ns, err := cs.CoreV1().Namespaces().Get(ctx, namespace, metav1.GetOptions{})
+	switch {
+	case apierrors.IsNotFound(err):
+		for _, obj := range objects {
+			state.Missing = append(state.Missing, obj.GetKind()+"/"+obj.GetName())
+		}
+		return state, nil
+	case err != nil:
+		return nil, fmt.Errorf("get namespace %s: %w", namespace, err)
+	}
+	state.NamespaceExists = true
+	state.Terminating = ns.DeletionTimestamp != nil || ns.Status.Phase == corev1.NamespaceTerminating
+
+	for _, obj := range objects {
+		ref := obj.GetKind() + "/" + obj.GetName()
+		drift, found, err := inspectObject(ctx, cs, namespace, obj)
+		if err != nil {
+			return nil, fmt.Errorf("inspect %s: %w", ref, err)
+		}
+		if !found {
+			state.Missing = append(state.Missing, ref)
+			continue
+		}
+		if drift != "" {
+			state.Drifted = append(state.Drifted, ref+": "+drift)
+		}
+
+		if obj.GetKind() == "Deployment" {
+			dep, err := cs.AppsV1().Deployments(namespace).Get(ctx, obj.GetName(), metav1.GetOptions{})
+			if err != nil {
+				return nil, fmt.Errorf("get deployment %s: %w", obj.GetName(), err)
+			}
+			ds, err := deploymentState(ctx, cs, dep)
+			if err != nil {
+				return nil, err
+			}
+			state.Deployments = append(state.Deployments, ds)
+		}
+	}
+	return state, nil
+}
+
+// inspectObject fetches the live object for desired and describes how it
+// differs. Only the fields the manager owns and users commonly edit by
+// hand are compared.
+func inspectObject(ctx context.Context, cs kubernetes.Interface, namespace string, desired *unstructured.Unstructured) (drift string, found bool, err error) {
+	name := desired.GetName()
+	get := metav1.GetOptions{}
+	switch desired.GetKind() {
+	case "Namespace":
+		return "", true, nil
+	case "ConfigMap":
+		var want corev1.ConfigMap
+		if err := fromUnstructured(desired, &want); err != nil {
+			return "", false, err
+		}
+		live, err := cs.CoreV1().ConfigMaps(namespace).Get(ctx, name, get)
+		if err != nil {
+			return notFound(err)
+		}
+		for key, value := range want.Data {
+			if live.Data[key] != value {
+				return fmt.Sprintf("data %q changed", key), true, nil
+			}
+		}
+		return "", true, nil
+	case "Secret":
+		var want corev1.Secret
+		if err := fromUnstructured(desired, &want); err != nil {
+			return "", false, err
+		}
+		live, err := cs.CoreV1().Secrets(namespace).Get(ctx, name, get)
+		if err != nil {
+			return notFound(err)
+		}
+		// Keys are compared both ways: a cleared secret must go away.
+		for key, value := range want.Data {
+			if !bytes.Equal(live.Data[key], value) {
+				return fmt.Sprintf("data %q changed", key), true, nil
+			}
+		}
+		for key := range live.Data {
+			if _, ok := want.Data[key]; !ok {
+				return fmt.Sprintf("data %q removed", key), true, nil
+			}
+		}
+		return "", true, nil
+	case "Deployment":
+		var want appsv1.Deployment
+		if err := fromUnstructured(desired, &want); err != nil {
+			return "", false, err
+		}
+		live, err := cs.AppsV1().Deployments(namespace).Get(ctx, name, get)
+		if err != nil {
+			return notFound(err)
+		}
+		return deploymentDrift(&want, live), true, nil
+	case "PersistentVolumeClaim":
+		_, err = cs.CoreV1().PersistentVolumeClaims(namespace).Get(ctx, name, get)
+	case "Service":
+		_, err = cs.CoreV1().Services(namespace).Get(ctx, name, get)
+	case "ServiceAccount":
+		_, err = cs.CoreV1().ServiceAccounts(namespace).Get(ctx, name, get)
+	case "Role":
+		_, err = cs.RbacV1().Roles(namespace).Get(ctx, name, get)
+	case "RoleBinding":
+		_, err = cs.RbacV1().RoleBindings(namespace).Get(ctx, name, get)
+	}
+	if err != nil {
+		return notFound(err)
+	}
+	return "", true, nil
+}
+
+func notFound(err error) (string, bool, error) {
+	if apierrors.IsNotFound(err) {
+		return "", false, nil
+	}
+	return "", false, err
+}
+
+func fromUnstructured(obj *unstructured.Unstructured, into interface{}) error {
+	return runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, into)
+}
+
+func deploymentDrift(want, live *appsv1.Deployment) string {
+	if want.Spec.Replicas != nil && (live.Spec.Replicas == nil || *live.Spec.Replicas != *want.Spec.Replicas) {
+		return "replicas changed"
+	}
+	liveContainers := make(map[string]corev1.Container)
+	for _, c := range podContainers(&live.Spec.Template.Spec) {
+		liveContainers[c.Name] = c
+	}
+	for _, c := range podContainers(&want.Spec.Template.Spec) {
+		got, ok := liveContainers[c.Name]
+		switch {
+		case !ok:
+			return fmt.Sprintf("container %s removed", c.Name)
+		case got.Image != c.Image:
+			return fmt.Sprintf("container %s image changed", c.Name)
+		case c.Command != nil && !reflect.DeepEqual(got.Command, c.Command),
+			c.Args != nil && !reflect.DeepEqual(got.Args, c.Args):
+			return fmt.Sprintf("container %s command changed", c.Name)
+		case !sameQuantities(c.Resources.Requests, got.Resources.Requests),
+			!sameQuantities(c.Resources.Limits, got.Resources.Limits):
+			return fmt.Sprintf("container %s resources changed", c.Name)
+		}
+	}
+	return ""
+}
+
+func podContainers(spec *corev1.PodSpec) []corev1.Container {
+	var containers []corev1.Container
+	containers = append(containers, spec.InitContainers...)
+	return append(containers, spec.Containers...)
+}
+
+// sameQuantities compares the resources listed in want by value, so that
+// "0.5" and "500m" are equal.
+func sameQuantities(want, got corev1.ResourceList) bool {
+	for name, q := range want {
+		g, ok := got[name]
+		if !ok || g.Cmp(q) != 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// waitingProblems are container waiting reasons that will not resolve on
+// their own.
+var waitingProblems = map[string]bool{
+	"ImagePullBackOff":           true,
+	"ErrImagePull":               true,
+	"InvalidImageName":           true,
+	"CreateContainerConfigError": true,
+	"CreateContainerError":       true,
+}
+
+func deploymentState(ctx context.Context, cs kubernetes.Interface, dep *appsv1.Deployment) (DeploymentState, error) {
+	ds := DeploymentState{
+		Name:          dep.Name,
+		Replicas:      1,
+		ReadyReplicas: dep.Status.ReadyReplicas,
+	}
+	if dep.Spec.Replicas != nil {
+		ds.Replicas = *dep.Spec.Replicas
+	}
+	// Until the controller has seen the latest spec, the counts describe
+	// the previous one.
+	ds.Available = dep.Status.ObservedGeneration >= dep.Generation &&
+		ds.ReadyReplicas >= ds.Replicas && dep.Status.UpdatedReplicas >= ds.Replicas
+
+	for _, cond := range dep.Status.Conditions {
+		if cond.Type == appsv1.DeploymentProgressing && cond.Reason == "ProgressDeadlineExceeded" {
+			ds.Stalled = true
+			ds.Reason, ds.Message = cond.Reason, cond.Message
+		}
+	}
+
+	if dep.Spec.Selector != nil && len(dep.Spec.Selector.MatchLabels) > 0 {
+		pods, err := cs.CoreV1().Pods(dep.Namespace).List(ctx, metav1.ListOptions{
+			LabelSelector: labels.SelectorFromSet(dep.Spec.Selector.MatchLabels).String(),
+		})
+		if err != nil {
+			return ds, fmt.Errorf("list pods of %s: %w", dep.Name, err)
+		}
+		sort.Slice(pods.Items, func(i, j int) bool { return pods.Items[i].Name < pods.Items[j].Name })
+		for _, pod := range pods.Items {
+			var statuses []corev1.ContainerStatus
+			statuses = append(statuses, pod.Status.InitContainerStatuses...)
+			statuses = append(statuses, pod.Status.ContainerStatuses...)
+			for _, st := range statuses {
+				waiting := st.State.Waiting
+				if waiting == nil {
+					continue
+				}
+				switch {
+				case waiting.Reason == "CrashLoopBackOff":
+					ds.CrashLooping = true
+					ds.Reason = waiting.Reason
+					ds.Message = crashMessage(pod.Name, st)
+				case waitingProblems[waiting.Reason] && !ds.CrashLooping:
+					ds.Stalled = true
+					ds.Reason = waiting.Reason
+					ds.Message = fmt.Sprintf("pod %s container %s: %s", pod.Name, st.Name, waiting.Message)
+				}
+			}
+		}
+	}
+
+	if !ds.Available && ds.Reason == "" {
+		ds.Reason = "Unavailable"
+		ds.Message = fmt.Sprintf("%d/%d replicas ready", ds.ReadyReplicas, ds.Replicas)
+	}
+	return ds, nil
+}
+
+func crashMessage(pod string, status corev1.ContainerStatus) string {
+	msg := fmt.Sprintf("pod %s container %s restarted %d times", pod, status.Name, status.RestartCount)
+	if term := status.LastTerminationState.Terminated; term != nil {
+		msg += fmt.Sprintf(", last exit code %d", term.ExitCode)
+		if term.Reason != "" {
+			msg += " (" + term.Reason + ")"
+		}
+		if m := strings.TrimSpace(term.Message); m != "" {
+			msg += ": " + m
+		}
+	}
+	return msg
+}
@@ -0,0 +1,166 @@
+package k8s
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+const testManifests = `apiVersion: v1
+kind: Namespace
+metadata:
+  name: tenant-a
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: config
+  namespace: tenant-a
+data:
+  config.json: |
+    {"a": 1}
+---
+apiVersion: v1
+kind: ServiceAccount
+metadata:
+  name: sa
+  namespace: tenant-a
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: agent
+  namespace: tenant-a
+spec:
+  replicas: 1
+  selector:
+    matchLabels:
+      app: agent
+  template:
+    metadata:
+      labels:
+        app: agent
+    spec:
+      containers:
+        - name: agent
+          image: picoclaw:1
+          resources:
+            limits:
+              cpu: "0.5"
+`
+
+func testDeployment(image, cpu string) *appsv1.Deployment {
+	replicas := int32(1)
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "agent", Namespace: "tenant-a"},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: &replicas,
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "agent"}},
+			Template: corev1.PodTemplateSpec{Spec: corev1.PodSpec{Containers: []corev1.Container{{
+				Name:  "agent",
+				Image: image,
+				Resources: corev1.ResourceRequirements{Limits: corev1.ResourceList{
+					corev1.ResourceCPU: resource.MustParse(cpu),
+				}},
+			}}}},
+		},
+		Status: appsv1.DeploymentStatus{Replicas: 1, ReadyReplicas: 1, UpdatedReplicas: 1},
+	}
+}
+
+func TestInspect_NamespaceMissing(t *testing.T) {
+	state, err := Inspect(context.Background(), fake.NewClientset(), "tenant-a", []byte(testManifests))
+	require.NoError(t, err)
+	assert.False(t, state.NamespaceExists)
+	assert.Equal(t, []string{"Namespace/tenant-a", "ConfigMap/config", "ServiceAccount/sa", "Deployment/agent"}, state.Missing)
+}
+
+func TestInspect_InSync(t *testing.T) {
+	cs := fake.NewClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant-a"}},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "tenant-a"},
+			Data:       map[string]string{"config.json": `{"a": 1}`, "extra": "ignored"},
+		},
+		&corev1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "sa", Namespace: "tenant-a"}},
+		// 500m is the same quantity as "0.5" in the manifest.
+		testDeployment("picoclaw:1", "500m"),
+	)
+	state, err := Inspect(context.Background(), cs, "tenant-a", []byte(testManifests))
+	require.NoError(t, err)
+	assert.True(t, state.InSync(), "missing %v, drifted %v", state.Missing, state.Drifted)
+	require.Len(t, state.Deployments, 1)
+	assert.True(t, state.Deployments[0].Available)
+}
+
+func TestInspect_NotObservedYet(t *testing.T) {
+	dep := testDeployment("picoclaw:1", "500m")
+	dep.Generation, dep.Status.ObservedGeneration = 2, 1
+	cs := fake.NewClientset(&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant-a"}}, dep)
+	state, err := Inspect(context.Background(), cs, "tenant-a", []byte(testManifests))
+	require.NoError(t, err)
+	require.Len(t, state.Deployments, 1)
+	assert.False(t, state.Deployments[0].Available)
+}
+
+func TestInspect_Drift(t *testing.T) {
+	dep := testDeployment("picoclaw:1", "1")
+	dep.Status.ReadyReplicas = 0
+	cs := fake.NewClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant-a"}},
+		&corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "config", Namespace: "tenant-a"},
+			Data:       map[string]string{"config.json": "{}"},
+		},
+		dep,
+		&corev1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: "agent-1", Namespace: "tenant-a", Labels: map[string]string{"app": "agent"}},
+			Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+				Name:  "agent",
+				State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{Reason: "ImagePullBackOff", Message: "not found"}},
+			}}},
+		},
+	)
+	state, err := Inspect(context.Background(), cs, "tenant-a", []byte(testManifests))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"ServiceAccount/sa"}, state.Missing)
+	assert.Equal(t, []string{
+		`ConfigMap/config: data "config.json" changed`,
+		"Deployment/agent: container agent resources changed",
+	}, state.Drifted)
+
+	require.Len(t, state.Deployments, 1)
+	ds := state.Deployments[0]
+	assert.False(t, ds.Available)
+	assert.True(t, ds.Stalled)
+	assert.Equal(t, "ImagePullBackOff", ds.Reason)
+	assert.Equal(t, "pod agent-1 container agent: not found", ds.Message)
+}
+
+func TestInspect_SecretDrift(t *testing.T) {
+	const manifests = `apiVersion: v1
+kind: Secret
+metadata:
+  name: secrets
+  namespace: tenant-a
+data:
+  TOKEN: "YWJj"
+`
+	cs := fake.NewClientset(
+		&corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "tenant-a"}},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "secrets", Namespace: "tenant-a"},
+			Data:       map[string][]byte{"TOKEN": []byte("abc"), "OLD_TOKEN": []byte("x")},
+		},
+	)
+	state, err := Inspect(context.Background(), cs, "tenant-a", []byte(manifests))
+	require.NoError(t, err)
+	assert.Equal(t, []string{`Secret/secrets: data "OLD_TOKEN" removed`}, state.Drifted)
+}
@@ -0,0 +1,139 @@
+package k8s
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/remotecommand"
+)
+
+// VolumePath is where ExecInVolume mounts the claim.
+const VolumePath = "/workspace"
+
+const (
+	volumeHelperImage = "busybox:latest"
+	podPollInterval   = time.Second
+)
+
+// ExecInVolume runs command in a short-lived pod mounting a claim.
+func (a *Applier) ExecInVolume(ctx context.Context, namespace, claim string, command []string, stdin io.Reader, stdout io.Writer) error {
+	return ExecInVolume(ctx, a.client.Clientset, a.client.Config, namespace, claim, command, stdin, stdout)
+}
+
+// WaitPodsGone waits until no pod matches selector.
+func (a *Applier) WaitPodsGone(ctx context.Context, namespace, selector string) error {
+	return WaitPodsGone(ctx, a.client.Clientset, namespace, selector)
+}
+
+// ExecInVolume starts a helper pod mounting claim at VolumePath, runs
+// command in it with stdin and stdout attached, and deletes the pod again.
+// The pod does not depend on the tenant's own workloads, so it works while
+// they are scaled to zero.
+func ExecInVolume(ctx context.Context, cs kubernetes.Interface, cfg *rest.Config, namespace, claim string, command []string, stdin io.Reader, stdout io.Writer) error {
+	pods := cs.CoreV1().Pods(namespace)
+	pod, err := pods.Create(ctx, volumeHelperPod(claim), metav1.CreateOptions{})
+	if err != nil {
+		return fmt.Errorf("create helper pod: %w", err)
+	}
+	defer func() {
+		// The operation's context may be done; clean up regardless.
+		cleanupCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		grace := int64(0)
+		_ = pods.Delete(cleanupCtx, pod.Name, metav1.DeleteOptions{GracePeriodSeconds: &grace})
+	}()
+
+	err = wait.PollUntilContextCancel(ctx, podPollInterval, true, func(ctx context.Context) (bool, error) {
+		current, err := pods.Get(ctx, pod.Name, metav1.GetOptions{})
+		if err != nil {
+			return false, err
+		}
+		switch current.Status.Phase {
+		case corev1.PodRunning:
+			return true, nil
+		case corev1.PodFailed, corev1.PodSucceeded:
+			return false, fmt.Errorf("helper pod %s", strings.ToLower(string(current.Status.Phase)))
+		}
+		return false, nil
+	})
+	if err != nil {
+		return fmt.Errorf("wait for helper pod %s: %w", pod.Name, err)
+	}
+
+	req := cs.CoreV1().RESTClient().Post().
+		Resource("pods").Namespace(namespace).Name(pod.Name).SubResource("exec").
+		VersionedParams(&corev1.PodExecOptions{
+			Container: "helper",
+			Command:   command,
+			Stdin:     stdin != nil,
+			Stdout:    true,
+			Stderr:    true,
+		}, scheme.ParameterCodec)
+	exec, err := remotecommand.NewSPDYExecutor(cfg, "POST", req.URL())
+	if err != nil {
+		return fmt.Errorf("exec in helper pod: %w", err)
+	}
+	var stderr bytes.Buffer
+	err = exec.StreamWithContext(ctx, remotecommand.StreamOptions{Stdin: stdin, Stdout: stdout, Stderr: &stderr})
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return fmt.Errorf("%s: %w: %s", command[0], err, msg)
+		}
+		return fmt.Errorf("%s: %w", command[0], err)
+	}
+	return nil
+}
+
+func volumeHelperPod(claim string) *corev1.Pod {
+	b := make([]byte, 4)
+	rand.Read(b)
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "picoclaw-volume-" + hex.EncodeToString(b),
+			Labels: map[string]string{"app": "picoclaw-volume-helper"},
+		},
+		Spec: corev1.PodSpec{
+			RestartPolicy: corev1.RestartPolicyNever,
+			Containers: []corev1.Container{{
+				Name:         "helper",
+				Image:        volumeHelperImage,
+				Command:      []string{"sleep", "3600"},
+				VolumeMounts: []corev1.VolumeMount{{Name: "volume", MountPath: VolumePath}},
+			}},
+			Volumes: []corev1.Volume{{
+				Name: "volume",
+				VolumeSource: corev1.VolumeSource{
+					PersistentVolumeClaim: &corev1.PersistentVolumeClaimVolumeSource{ClaimName: claim},
+				},
+			}},
+		},
+	}
+}
+
+// WaitPodsGone polls until no pod in namespace matches selector, including
+// pods still terminating.
+func WaitPodsGone(ctx context.Context, cs kubernetes.Interface, namespace, selector string) error {
+	err := wait.PollUntilContextCancel(ctx, podPollInterval, true, func(ctx context.Context) (bool, error) {
+		pods, err := cs.CoreV1().Pods(namespace).List(ctx, metav1.ListOptions{LabelSelector: selector})
+		if err != nil {
+			return false, err
+		}
+		return len(pods.Items) == 0, nil
+	})
+	if err != nil {
+		return fmt.Errorf("wait for pods %s to stop: %w", selector, err)
+	}
+	return nil
+}
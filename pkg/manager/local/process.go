@@ -0,0 +1,211 @@
+package local
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// stopTimeout is how long a gateway may take to exit after an interrupt
+// before it is killed.
+const stopTimeout = 10 * time.Second
+
+// process supervises the gateway of one tenant.
+type process struct {
+	tenantID string
+	dir      string
+	port     int
+	env      []string
+	spec     string
+
+	cancel context.CancelFunc
+	done   chan struct{}
+
+	mu sync.Mutex
+	processState
+}
+
+type processState struct {
+	running  bool
+	crashes  int    // exits in a row within CrashWindow of starting
+	lastExit string // why the last run ended
+	startErr string // why the binary could not be started
+}
+
+func (p *process) state() processState {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.processState
+}
+
+// start launches a supervisor for the tenant's gateway.
+func (r *Runtime) start(tenantID, dir string, port int, env []string, spec string) *process {
+	ctx, cancel := context.WithCancel(context.Background())
+	p := &process{
+		tenantID: tenantID,
+		dir:      dir,
+		port:     port,
+		env:      env,
+		spec:     spec,
+		cancel:   cancel,
+		done:     make(chan struct{}),
+	}
+	go r.supervise(ctx, p)
+	return p
+}
+
+// stop stops the gateway and waits for its supervisor to return.
+func (p *process) stop() {
+	p.cancel()
+	<-p.done
+}
+
+// supervise runs the gateway until ctx is done, restarting it with
+// exponential backoff whenever it exits.
+func (r *Runtime) supervise(ctx context.Context, p *process) {
+	defer close(p.done)
+	backoff := r.MinBackoff
+	for {
+		started := time.Now()
+		err := r.run(ctx, p)
+		if ctx.Err() != nil {
+			return
+		}
+
+		p.mu.Lock()
+		if time.Since(started) < r.CrashWindow {
+			p.crashes++
+		} else {
+			p.crashes = 0
+			backoff = r.MinBackoff
+		}
+		p.mu.Unlock()
+		log.Printf("tenant %s: gateway exited (%v), restarting in %s", p.tenantID, err, backoff)
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, r.MaxBackoff)
+	}
+}
+
+// run starts the gateway once and waits for it to exit.
+func (r *Runtime) run(ctx context.Context, p *process) error {
+	out, err := openLog(filepath.Join(p.dir, logFile))
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	cmd := exec.CommandContext(ctx, r.cfg.Binary, "gateway")
+	cmd.Dir = p.dir
+	cmd.Env = p.env
+	cmd.Stdout = out
+	cmd.Stderr = out
+	// The gateway shuts down cleanly on an interrupt, like on Ctrl+C.
+	cmd.Cancel = func() error { return cmd.Process.Signal(os.Interrupt) }
+	cmd.WaitDelay = stopTimeout
+
+	fmt.Fprintf(out, "--- %s starting gateway on port %d\n", time.Now().Format(time.RFC3339), p.port)
+	if err := cmd.Start(); err != nil {
+		p.mu.Lock()
+		p.startErr = err.Error()
+		p.mu.Unlock()
+		return err
+	}
+	p.mu.Lock()
+	p.running, p.startErr = true, ""
+	p.mu.Unlock()
+
+	err = cmd.Wait()
+	exit := "exited normally"
+	if err != nil {
+		exit = err.Error()
+	}
+	p.mu.Lock()
+	p.running, p.lastExit = false, exit
+	p.mu.Unlock()
+	fmt.Fprintf(out, "--- %s gateway %s\n", time.Now().Format(time.RFC3339), exit)
+	return err
+}
+
+// openLog opens the log for appending, first moving it to <path>.1 if it
+// grew past maxLogSize.
+func openLog(path string) (*os.File, error) {
+	if info, err := os.Stat(path); err == nil && info.Size() > maxLogSize {
+		if err := os.Rename(path, path+".1"); err != nil {
+			return nil, fmt.Errorf("rotate log: %w", err)
+		}
+	}
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("open log: %w", err)
+	}
+	return f, nil
+}
+
+// tailOffset returns the offset of the last n lines of f.
+func tailOffset(f *os.File, n int64) (int64, error) {
+	info, err := f.Stat()
+	if err != nil {
+		return 0, err
+	}
+	size := info.Size()
+	buf := make([]byte, 8192)
+	var lines int64
+	for end := size; end > 0; {
+		start := max(end-int64(len(buf)), 0)
+		chunk := buf[:end-start]
+		if _, err := f.ReadAt(chunk, start); err != nil {
+			return 0, err
+		}
+		for i := len(chunk) - 1; i >= 0; i-- {
+			// The newline ending the last line does not start a new one.
+			if chunk[i] != '\n' || start+int64(i) == size-1 {
+				continue
+			}
+			if lines++; lines == n {
+				return start + int64(i) + 1, nil
+			}
+		}
+		end = start
+	}
+	return 0, nil
+}
+
+// followInterval is how often a followed log is checked for new output.
+const followInterval = 500 * time.Millisecond
+
+// logReader reads a log file and, when following, waits for more output at
+// its end until the context is done.
+type logReader struct {
+	ctx    context.Context
+	f      *os.File
+	follow bool
+}
+
+func (l *logReader) Read(p []byte) (int, error) {
+	for {
+		n, err := l.f.Read(p)
+		if n > 0 || err != io.EOF || !l.follow {
+			return n, err
+		}
+		select {
+		case <-l.ctx.Done():
+			return 0, io.EOF
+		case <-time.After(followInterval):
+		}
+	}
+}
+
+func (l *logReader) Close() error {
+	return l.f.Close()
+}
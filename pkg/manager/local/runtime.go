@@ -0,0 +1,455 @@
+// Package local runs tenants as picoclaw gateway processes on the
+// manager's own host, for single-machine installs and for trying the
+// manager out without a cluster.
+package local
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/manager/tenant"
+)
+
+// Config configures a Runtime.
+type Config struct {
+	// Dir holds one directory per tenant, used as the gateway's HOME: the
+	// config is written to <Dir>/<id>/.picoclaw/config.json and the
+	// workspace defaults to <Dir>/<id>/.picoclaw/workspace.
+	Dir string
+	// Binary is the picoclaw executable. Defaults to "picoclaw".
+	Binary string
+	// Host is the address gateways listen on. Defaults to 127.0.0.1.
+	Host string
+	// BasePort is the first port handed out to tenants. Defaults to 18800.
+	BasePort int
+	// Env names further variables of the manager's environment that are
+	// passed on to gateways, besides those in gatewayEnv.
+	Env []string
+}
+
+const (
+	defaultBinary   = "picoclaw"
+	defaultHost     = "127.0.0.1"
+	defaultBasePort = 18800
+
+	portFile   = "port"
+	logFile    = "gateway.log"
+	maxLogSize = 10 << 20
+
+	// crashLoopThreshold is how many quick exits in a row make a gateway
+	// crash-looping.
+	crashLoopThreshold = 3
+)
+
+// gatewayEnv lists the variables of the manager's environment that gateways
+// inherit. Everything else, such as SECRETS_KEY, API_KEY or DATABASE_URL,
+// stays with the manager: a tenant may run commands in its gateway.
+var gatewayEnv = []string{
+	"PATH", "TZ", "LANG", "LC_ALL", "LC_CTYPE", "TMPDIR",
+	"SSL_CERT_FILE", "SSL_CERT_DIR",
+	"HTTP_PROXY", "HTTPS_PROXY", "NO_PROXY", "http_proxy", "https_proxy", "no_proxy",
+}
+
+// Runtime runs each tenant as a supervised "picoclaw gateway" process with
+// its own directory and port. Gateways that exit are restarted with
+// exponential backoff. Runtime implements tenant.Runtime.
+type Runtime struct {
+	cfg Config
+
+	// MinBackoff and MaxBackoff bound the delay before a gateway that
+	// exited is restarted. A gateway that ran for less than CrashWindow
+	// counts as crashed.
+	MinBackoff  time.Duration
+	MaxBackoff  time.Duration
+	CrashWindow time.Duration
+
+	client *http.Client
+
+	mu    sync.Mutex
+	procs map[string]*process
+	ports map[int]string // port -> tenant ID
+}
+
+var _ tenant.Runtime = (*Runtime)(nil)
+
+// New creates a Runtime, creating cfg.Dir if needed. Ports already given to
+// tenants in cfg.Dir stay reserved for them.
+func New(cfg Config) (*Runtime, error) {
+	if cfg.Dir == "" {
+		return nil, fmt.Errorf("local runtime: directory is required")
+	}
+	if cfg.Binary == "" {
+		cfg.Binary = defaultBinary
+	}
+	if cfg.Host == "" {
+		cfg.Host = defaultHost
+	}
+	if cfg.BasePort == 0 {
+		cfg.BasePort = defaultBasePort
+	}
+	if err := os.MkdirAll(cfg.Dir, 0o700); err != nil {
+		return nil, fmt.Errorf("create %s: %w", cfg.Dir, err)
+	}
+
+	r := &Runtime{
+		cfg:         cfg,
+		MinBackoff:  time.Second,
+		MaxBackoff:  5 * time.Minute,
+		CrashWindow: 30 * time.Second,
+		client:      &http.Client{Timeout: 2 * time.Second},
+		procs:       make(map[string]*process),
+		ports:       make(map[int]string),
+	}
+	entries, err := os.ReadDir(cfg.Dir)
+	if err != nil {
+		return nil, fmt.Errorf("read %s: %w", cfg.Dir, err)
+	}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		if port, err := readPort(filepath.Join(cfg.Dir, e.Name())); err == nil {
+			r.ports[port] = e.Name()
+		}
+	}
+	return r, nil
+}
+
+func (r *Runtime) dir(t *tenant.Tenant) string {
+	return filepath.Join(r.cfg.Dir, t.ID)
+}
+
+func configPath(dir string) string {
+	return filepath.Join(dir, ".picoclaw", "config.json")
+}
+
+func readPort(dir string) (int, error) {
+	data, err := os.ReadFile(filepath.Join(dir, portFile))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(strings.TrimSpace(string(data)))
+}
+
+// port returns the tenant's port, allocating the lowest free one from
+// BasePort the first time.
+func (r *Runtime) port(t *tenant.Tenant) (int, error) {
+	dir := r.dir(t)
+	if port, err := readPort(dir); err == nil {
+		return port, nil
+	}
+	for port := r.cfg.BasePort; port <= 65535; port++ {
+		if _, taken := r.ports[port]; taken || !portFree(r.cfg.Host, port) {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(dir, portFile), []byte(strconv.Itoa(port)+"\n"), 0o600); err != nil {
+			return 0, fmt.Errorf("save port: %w", err)
+		}
+		r.ports[port] = t.ID
+		return port, nil
+	}
+	return 0, fmt.Errorf("no free port from %d", r.cfg.BasePort)
+}
+
+func portFree(host string, port int) bool {
+	l, err := net.Listen("tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+	if err != nil {
+		return false
+	}
+	l.Close()
+	return true
+}
+
+// env is the environment of the tenant's gateway: the allowed variables of
+// the manager's own, plus HOME, the listen address and the tenant's secrets.
+func (r *Runtime) env(t *tenant.Tenant, dir string, port int) []string {
+	var env []string
+	for _, name := range append(gatewayEnv, r.cfg.Env...) {
+		if strings.HasPrefix(name, "PICOCLAW_") || name == "HOME" {
+			continue
+		}
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	env = append(env,
+		"HOME="+dir,
+		"PICOCLAW_GATEWAY_HOST="+r.cfg.Host,
+		"PICOCLAW_GATEWAY_PORT="+strconv.Itoa(port),
+	)
+	keys := make([]string, 0, len(t.Secrets))
+	for k := range t.Secrets {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		env = append(env, k+"="+t.Secrets[k])
+	}
+	return env
+}
+
+// spec identifies what a gateway was started with, so that a changed
+// config or secret restarts it.
+func spec(configJSON []byte, env []string) string {
+	h := sha256.New()
+	h.Write(configJSON)
+	for _, kv := range env {
+		h.Write([]byte{0})
+		h.Write([]byte(kv))
+	}
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// Apply writes the tenant's config and starts its gateway, restarting it
+// if the config or secrets changed. The gateway of a suspended tenant is
+// stopped instead.
+func (r *Runtime) Apply(ctx context.Context, t *tenant.Tenant) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	dir := r.dir(t)
+	if err := os.MkdirAll(filepath.Dir(configPath(dir)), 0o700); err != nil {
+		return fmt.Errorf("create tenant directory: %w", err)
+	}
+	port, err := r.port(t)
+	if err != nil {
+		return err
+	}
+	if err := writeIfChanged(configPath(dir), t.ConfigJSON); err != nil {
+		return fmt.Errorf("write config: %w", err)
+	}
+
+	if t.Suspended {
+		if p := r.procs[t.ID]; p != nil {
+			p.stop()
+			delete(r.procs, t.ID)
+		}
+		return nil
+	}
+
+	env := r.env(t, dir, port)
+	s := spec(t.ConfigJSON, env)
+	if p := r.procs[t.ID]; p != nil {
+		if p.spec == s {
+			return nil
+		}
+		p.stop()
+	}
+	r.procs[t.ID] = r.start(t.ID, dir, port, env, s)
+	return nil
+}
+
+func writeIfChanged(path string, data []byte) error {
+	if current, err := os.ReadFile(path); err == nil && bytes.Equal(current, data) {
+		return nil
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Delete stops the tenant's gateway and removes its directory, including
+// the workspace.
+func (r *Runtime) Delete(ctx context.Context, t *tenant.Tenant) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if p := r.procs[t.ID]; p != nil {
+		p.stop()
+		delete(r.procs, t.ID)
+	}
+	for port, id := range r.ports {
+		if id == t.ID {
+			delete(r.ports, port)
+		}
+	}
+	if err := os.RemoveAll(r.dir(t)); err != nil {
+		return fmt.Errorf("remove tenant directory: %w", err)
+	}
+	return nil
+}
+
+// Restart stops the tenant's gateway and starts it again.
+func (r *Runtime) Restart(ctx context.Context, t *tenant.Tenant) error {
+	r.mu.Lock()
+	p := r.procs[t.ID]
+	if p != nil {
+		p.stop()
+		delete(r.procs, t.ID)
+	}
+	r.mu.Unlock()
+	return r.Apply(ctx, t)
+}
+
+// Status compares the tenant's directory and gateway with its spec and
+// probes the gateway's /ready and /health endpoints.
+func (r *Runtime) Status(ctx context.Context, t *tenant.Tenant) (*tenant.RuntimeState, error) {
+	dir := r.dir(t)
+	state := &tenant.RuntimeState{}
+	if _, err := os.Stat(dir); err == nil {
+		state.Exists = true
+	} else if !errors.Is(err, fs.ErrNotExist) {
+		return nil, err
+	}
+
+	switch current, err := os.ReadFile(configPath(dir)); {
+	case errors.Is(err, fs.ErrNotExist):
+		state.Missing = append(state.Missing, "config.json")
+	case err != nil:
+		return nil, err
+	case !bytes.Equal(current, t.ConfigJSON):
+		state.Drifted = append(state.Drifted, "config.json: content changed")
+	}
+
+	r.mu.Lock()
+	p := r.procs[t.ID]
+	r.mu.Unlock()
+	if t.Suspended {
+		gateway := tenant.WorkloadState{Name: tenant.WorkloadGateway, Reason: "Suspended", Message: "the gateway is stopped"}
+		if p != nil {
+			state.Drifted = append(state.Drifted, "gateway process: running while suspended")
+		}
+		state.Workloads = append(state.Workloads, gateway)
+		return state, nil
+	}
+	gateway := tenant.WorkloadState{Name: tenant.WorkloadGateway, Replicas: 1}
+	if p == nil {
+		state.Missing = append(state.Missing, "gateway process")
+		gateway.Reason, gateway.Message = "NotRunning", "the gateway is not running"
+		state.Workloads = append(state.Workloads, gateway)
+		return state, nil
+	}
+	if port, err := readPort(dir); err != nil || p.spec != spec(t.ConfigJSON, r.env(t, dir, port)) {
+		state.Drifted = append(state.Drifted, "gateway process: started with a different config")
+	}
+
+	ps := p.state()
+	switch {
+	case ps.startErr != "":
+		gateway.Stalled = true
+		gateway.Reason, gateway.Message = "StartFailed", ps.startErr
+	case ps.crashes >= crashLoopThreshold:
+		gateway.CrashLooping = true
+		gateway.Reason = "CrashLoopBackOff"
+		gateway.Message = fmt.Sprintf("gateway exited %d times in a row: %s", ps.crashes, ps.lastExit)
+	case !ps.running:
+		gateway.Reason, gateway.Message = "NotRunning", "gateway exited: "+ps.lastExit
+	case !r.ready(ctx, p.port):
+		gateway.Reason = "NotReady"
+		gateway.Message = fmt.Sprintf("gateway on port %d is not ready", p.port)
+	default:
+		gateway.ReadyReplicas = 1
+		gateway.Available = true
+		state.LastActivity, state.Usage = r.gatewayHealth(ctx, t.ID, p.port)
+	}
+	state.Workloads = append(state.Workloads, gateway)
+	return state, nil
+}
+
+func (r *Runtime) get(ctx context.Context, port int, path string) (*http.Response, error) {
+	url := "http://" + net.JoinHostPort(r.cfg.Host, strconv.Itoa(port)) + path
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	return r.client.Do(req)
+}
+
+func (r *Runtime) ready(ctx context.Context, port int) bool {
+	resp, err := r.get(ctx, port, "/ready")
+	if err != nil {
+		return false
+	}
+	resp.Body.Close()
+	return resp.StatusCode == http.StatusOK
+}
+
+// gatewayHealth asks the gateway when it last received a message and what
+// it counted. Failures are only logged: both are advisory.
+func (r *Runtime) gatewayHealth(ctx context.Context, tenantID string, port int) (*time.Time, *tenant.UsageSample) {
+	resp, err := r.get(ctx, port, "/health")
+	if err == nil {
+		var body []byte
+		body, err = io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err == nil {
+			var at *time.Time
+			var usage *tenant.UsageSample
+			if at, usage, err = tenant.ParseGatewayHealth(body); err == nil {
+				return at, usage
+			}
+		}
+	}
+	log.Printf("tenant %s: read gateway activity: %v", tenantID, err)
+	return nil, nil
+}
+
+// Logs returns the gateway's output, which also includes the agent's:
+// locally both run in the one process.
+func (r *Runtime) Logs(ctx context.Context, t *tenant.Tenant, opts tenant.LogOptions) (io.ReadCloser, error) {
+	if opts.Workload != "" && opts.Workload != tenant.WorkloadGateway && opts.Workload != tenant.WorkloadAgent {
+		return nil, fmt.Errorf("%w: unknown workload %q", tenant.ErrInvalid, opts.Workload)
+	}
+	f, err := os.Open(filepath.Join(r.dir(t), logFile))
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil, fmt.Errorf("%w: tenant %s has no logs yet", tenant.ErrNotFound, t.ID)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("open logs: %w", err)
+	}
+	if opts.TailLines > 0 {
+		offset, err := tailOffset(f, opts.TailLines)
+		if err == nil {
+			_, err = f.Seek(offset, io.SeekStart)
+		}
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("seek logs: %w", err)
+		}
+	}
+	return &logReader{ctx: ctx, f: f, follow: opts.Follow}, nil
+}
+
+// Events is not supported: a local gateway is a plain process, whose
+// starts and exits are in its logs.
+func (r *Runtime) Events(ctx context.Context, t *tenant.Tenant) ([]tenant.Event, error) {
+	return nil, fmt.Errorf("%w: the local runtime has no events, see the tenant's logs", tenant.ErrInvalid)
+}
+
+// ResolveVersions only accepts empty versions: every gateway runs
+// cfg.Binary, so images and template versions do not apply.
+func (r *Runtime) ResolveVersions(v tenant.Versions) (tenant.Versions, error) {
+	if v != (tenant.Versions{}) {
+		return v, fmt.Errorf("%w: the local runtime runs %s, images and template versions do not apply", tenant.ErrInvalid, r.cfg.Binary)
+	}
+	return v, nil
+}
+
+// Close stops every gateway. Their directories are kept, and the next
+// manager re-applies them.
+func (r *Runtime) Close() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, p := range r.procs {
+		p.stop()
+		delete(r.procs, id)
+	}
+}
@@ -0,0 +1,335 @@
+package local
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/sipeed/picoclaw/pkg/manager/tenant"
+)
+
+// TestMain lets the test binary stand in for "picoclaw gateway".
+func TestMain(m *testing.M) {
+	if os.Getenv("LOCAL_TEST_GATEWAY") == "1" && len(os.Args) > 1 && os.Args[1] == "gateway" {
+		fakeGateway()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// fakeGateway serves /ready like the real gateway, and exits at once when
+// its config says "crash".
+func fakeGateway() {
+	config, err := os.ReadFile(filepath.Join(os.Getenv("HOME"), ".picoclaw", "config.json"))
+	if err != nil {
+		fmt.Println("read config:", err)
+		os.Exit(1)
+	}
+	if strings.Contains(string(config), "crash") {
+		fmt.Println("crashing")
+		os.Exit(2)
+	}
+	fmt.Printf("config %s\n", config)
+	fmt.Printf("secrets key %q\n", os.Getenv("SECRETS_KEY"))
+	fmt.Printf("token %q\n", os.Getenv("PICOCLAW_CHANNELS_TELEGRAM_TOKEN"))
+
+	addr := net.JoinHostPort(os.Getenv("PICOCLAW_GATEWAY_HOST"), os.Getenv("PICOCLAW_GATEWAY_PORT"))
+	mux := http.NewServeMux()
+	mux.HandleFunc("/ready", func(w http.ResponseWriter, r *http.Request) {})
+	mux.HandleFunc("/health", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"status":"ok","last_activity":"2026-02-16T11:00:00Z","usage":{"since":"2026-02-16T10:00:00Z","messages_in":4,"prompt_tokens":120}}`)
+	})
+	server := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		sig := make(chan os.Signal, 1)
+		signal.Notify(sig, os.Interrupt)
+		<-sig
+		fmt.Println("stopping")
+		server.Close()
+	}()
+	server.ListenAndServe()
+}
+
+func newTestRuntime(t *testing.T) *Runtime {
+	t.Helper()
+	t.Setenv("LOCAL_TEST_GATEWAY", "1")
+	r, err := New(Config{Dir: t.TempDir(), Binary: os.Args[0], BasePort: 28800, Env: []string{"LOCAL_TEST_GATEWAY"}})
+	require.NoError(t, err)
+	r.MinBackoff, r.MaxBackoff = 10*time.Millisecond, 10*time.Millisecond
+	t.Cleanup(r.Close)
+	return r
+}
+
+func newTenant(id, config string) *tenant.Tenant {
+	return &tenant.Tenant{
+		ID:         id,
+		ConfigJSON: []byte(config),
+		Secrets:    map[string]string{"PICOCLAW_CHANNELS_TELEGRAM_TOKEN": "123:abc"},
+	}
+}
+
+// waitFor polls the tenant's gateway until check passes.
+func waitFor(t *testing.T, r *Runtime, tn *tenant.Tenant, check func(tenant.WorkloadState) bool) *tenant.RuntimeState {
+	t.Helper()
+	var state *tenant.RuntimeState
+	require.Eventually(t, func() bool {
+		var err error
+		state, err = r.Status(context.Background(), tn)
+		require.NoError(t, err)
+		return check(state.Workloads[0])
+	}, 10*time.Second, 20*time.Millisecond)
+	return state
+}
+
+func readLogs(t *testing.T, r *Runtime, tn *tenant.Tenant, opts tenant.LogOptions) string {
+	t.Helper()
+	logs, err := r.Logs(context.Background(), tn, opts)
+	require.NoError(t, err)
+	defer logs.Close()
+	data, err := io.ReadAll(logs)
+	require.NoError(t, err)
+	return string(data)
+}
+
+func TestRuntime_Lifecycle(t *testing.T) {
+	t.Setenv("SECRETS_KEY", "manager-only")
+	r := newTestRuntime(t)
+	ctx := context.Background()
+	acme := newTenant("acme", `{"agents":{}}`)
+
+	state, err := r.Status(ctx, acme)
+	require.NoError(t, err)
+	assert.False(t, state.Exists)
+	assert.Equal(t, []string{"config.json", "gateway process"}, state.Missing)
+
+	require.NoError(t, r.Apply(ctx, acme))
+	state = waitFor(t, r, acme, func(w tenant.WorkloadState) bool { return w.Available })
+	assert.True(t, state.Exists)
+	assert.True(t, state.InSync(), "missing %v, drifted %v", state.Missing, state.Drifted)
+	require.NotNil(t, state.LastActivity)
+	assert.Equal(t, "2026-02-16T11:00:00Z", state.LastActivity.Format(time.RFC3339))
+	require.NotNil(t, state.Usage)
+	assert.Equal(t, tenant.UsageCounters{MessagesIn: 4, PromptTokens: 120}, state.Usage.UsageCounters)
+	_, err = r.Events(ctx, acme)
+	assert.ErrorIs(t, err, tenant.ErrInvalid, "no events locally")
+
+	dir := filepath.Join(r.cfg.Dir, "acme")
+	info, err := os.Stat(filepath.Join(dir, ".picoclaw", "config.json"))
+	require.NoError(t, err)
+	assert.Equal(t, os.FileMode(0o600), info.Mode().Perm())
+	port, err := readPort(dir)
+	require.NoError(t, err)
+	assert.Equal(t, 28800, port)
+
+	// A second tenant gets the next port.
+	other := newTenant("other", `{}`)
+	require.NoError(t, r.Apply(ctx, other))
+	port, err = readPort(filepath.Join(r.cfg.Dir, "other"))
+	require.NoError(t, err)
+	assert.Equal(t, 28801, port)
+
+	logs := readLogs(t, r, acme, tenant.LogOptions{})
+	assert.Contains(t, logs, `config {"agents":{}}`)
+	assert.Contains(t, logs, `token "123:abc"`, "secrets are passed in the environment")
+	assert.Contains(t, logs, `secrets key ""`, "the manager's own settings stay with the manager")
+
+	// Editing the config on disk is drift; re-applying restores it and
+	// a changed spec restarts the gateway.
+	require.NoError(t, os.WriteFile(filepath.Join(dir, ".picoclaw", "config.json"), []byte("{}"), 0o600))
+	state, err = r.Status(ctx, acme)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"config.json: content changed"}, state.Drifted)
+
+	acme.Secrets["PICOCLAW_CHANNELS_TELEGRAM_TOKEN"] = "456:def"
+	state, err = r.Status(ctx, acme)
+	require.NoError(t, err)
+	assert.Contains(t, state.Drifted, "gateway process: started with a different config")
+	require.NoError(t, r.Apply(ctx, acme))
+	waitFor(t, r, acme, func(w tenant.WorkloadState) bool { return w.Available })
+	assert.Contains(t, readLogs(t, r, acme, tenant.LogOptions{}), `token "456:def"`)
+
+	require.NoError(t, r.Delete(ctx, acme))
+	state, err = r.Status(ctx, acme)
+	require.NoError(t, err)
+	assert.False(t, state.Exists)
+	_, err = os.Stat(dir)
+	assert.True(t, os.IsNotExist(err))
+	_, err = r.Logs(ctx, acme, tenant.LogOptions{})
+	assert.True(t, errors.Is(err, tenant.ErrNotFound), "logs of a deleted tenant: %v", err)
+}
+
+func TestRuntime_Suspend(t *testing.T) {
+	r := newTestRuntime(t)
+	ctx := context.Background()
+	acme := newTenant("acme", `{}`)
+	require.NoError(t, r.Apply(ctx, acme))
+	waitFor(t, r, acme, func(w tenant.WorkloadState) bool { return w.Available })
+
+	acme.Suspended = true
+	state, err := r.Status(ctx, acme)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"gateway process: running while suspended"}, state.Drifted)
+
+	require.NoError(t, r.Apply(ctx, acme))
+	state, err = r.Status(ctx, acme)
+	require.NoError(t, err)
+	assert.True(t, state.InSync(), "missing %v, drifted %v", state.Missing, state.Drifted)
+	assert.Equal(t, "Suspended", state.Workloads[0].Reason)
+	assert.FileExists(t, filepath.Join(r.cfg.Dir, "acme", ".picoclaw", "config.json"), "the config is kept")
+	assert.Contains(t, readLogs(t, r, acme, tenant.LogOptions{}), "stopping\n")
+
+	acme.Suspended = false
+	require.NoError(t, r.Apply(ctx, acme))
+	waitFor(t, r, acme, func(w tenant.WorkloadState) bool { return w.Available })
+}
+
+func TestRuntime_CrashLoop(t *testing.T) {
+	r := newTestRuntime(t)
+	crashy := newTenant("crashy", `{"crash":true}`)
+
+	require.NoError(t, r.Apply(context.Background(), crashy))
+	waitFor(t, r, crashy, func(w tenant.WorkloadState) bool { return w.CrashLooping })
+	assert.Contains(t, readLogs(t, r, crashy, tenant.LogOptions{TailLines: 10}), "crashing\n")
+}
+
+func TestRuntime_StartFailed(t *testing.T) {
+	r := newTestRuntime(t)
+	r.cfg.Binary = filepath.Join(t.TempDir(), "missing")
+	acme := newTenant("acme", `{}`)
+
+	require.NoError(t, r.Apply(context.Background(), acme))
+	state := waitFor(t, r, acme, func(w tenant.WorkloadState) bool { return w.Stalled })
+	assert.Equal(t, "StartFailed", state.Workloads[0].Reason)
+}
+
+func TestRuntime_FollowLogs(t *testing.T) {
+	r := newTestRuntime(t)
+	acme := newTenant("acme", `{}`)
+	require.NoError(t, r.Apply(context.Background(), acme))
+	waitFor(t, r, acme, func(w tenant.WorkloadState) bool { return w.Available })
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	logs, err := r.Logs(ctx, acme, tenant.LogOptions{TailLines: 1, Follow: true})
+	require.NoError(t, err)
+	defer logs.Close()
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(logs)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+	require.NoError(t, r.Restart(context.Background(), acme))
+
+	var output []string
+	for line := range lines {
+		output = append(output, line)
+		if strings.Contains(line, "starting gateway") {
+			cancel()
+		}
+	}
+	require.NotEmpty(t, output)
+	assert.Equal(t, `token "123:abc"`, output[0], "only the last line is tailed")
+	assert.Contains(t, output, "stopping")
+}
+
+func TestRuntime_Workspace(t *testing.T) {
+	r := newTestRuntime(t)
+	ctx := context.Background()
+	acme := newTenant("acme", `{}`)
+	require.NoError(t, r.Apply(ctx, acme))
+	workspace := filepath.Join(r.cfg.Dir, "acme", ".picoclaw", "workspace")
+	require.NoError(t, os.MkdirAll(filepath.Join(workspace, "memory"), 0o700))
+	require.NoError(t, os.WriteFile(filepath.Join(workspace, "memory", "MEMORY.md"), []byte("remember"), 0o600))
+
+	var archive bytes.Buffer
+	require.NoError(t, r.ExportWorkspace(ctx, acme, &archive))
+
+	copied := newTenant("copy", `{}`)
+	copied.Suspended = true
+	require.NoError(t, r.Apply(ctx, copied))
+	stale := filepath.Join(r.cfg.Dir, "copy", ".picoclaw", "workspace", "stale")
+	require.NoError(t, os.MkdirAll(filepath.Dir(stale), 0o700))
+	require.NoError(t, os.WriteFile(stale, []byte("old"), 0o600))
+	require.NoError(t, r.ImportWorkspace(ctx, copied, bytes.NewReader(archive.Bytes())))
+
+	data, err := os.ReadFile(filepath.Join(r.cfg.Dir, "copy", ".picoclaw", "workspace", "memory", "MEMORY.md"))
+	require.NoError(t, err)
+	assert.Equal(t, "remember", string(data))
+	assert.NoFileExists(t, stale, "the workspace is replaced")
+
+	err = r.ImportWorkspace(ctx, acme, bytes.NewReader(archive.Bytes()))
+	assert.Error(t, err, "the gateway is running")
+
+	// A tenant without a workspace exports an empty archive.
+	archive.Reset()
+	require.NoError(t, r.ExportWorkspace(ctx, newTenant("new", `{}`), &archive))
+	require.NoError(t, r.ImportWorkspace(ctx, copied, &archive))
+	entries, err := os.ReadDir(filepath.Join(r.cfg.Dir, "copy", ".picoclaw", "workspace"))
+	require.NoError(t, err)
+	assert.Empty(t, entries)
+}
+
+func TestRuntime_ImportSkipsUnsafeEntries(t *testing.T) {
+	r := newTestRuntime(t)
+	acme := newTenant("acme", `{}`)
+	acme.Suspended = true
+	require.NoError(t, r.Apply(context.Background(), acme))
+
+	var archive bytes.Buffer
+	zw := gzip.NewWriter(&archive)
+	tw := tar.NewWriter(zw)
+	for _, hdr := range []*tar.Header{
+		{Name: "../escaped", Typeflag: tar.TypeReg, Mode: 0o600, Size: 1},
+		{Name: "link", Typeflag: tar.TypeSymlink, Linkname: "/etc"},
+		{Name: "ok", Typeflag: tar.TypeReg, Mode: 0o600, Size: 1},
+	} {
+		require.NoError(t, tw.WriteHeader(hdr))
+		if hdr.Size > 0 {
+			_, err := tw.Write([]byte("x"))
+			require.NoError(t, err)
+		}
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, zw.Close())
+
+	require.NoError(t, r.ImportWorkspace(context.Background(), acme, &archive))
+	workspace := filepath.Join(r.cfg.Dir, "acme", ".picoclaw", "workspace")
+	assert.FileExists(t, filepath.Join(workspace, "ok"))
+	assert.NoFileExists(t, filepath.Join(workspace, "..", "escaped"))
+	_, err := os.Lstat(filepath.Join(workspace, "link"))
+	assert.True(t, os.IsNotExist(err), "symlinks are skipped")
+}
+
+func TestTailOffset(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "log")
+	require.NoError(t, os.WriteFile(path, []byte("one\ntwo\nthree\n"), 0o600))
+	f, err := os.Open(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	for n, want := range map[int64]int64{1: 8, 2: 4, 3: 0, 10: 0} {
+		offset, err := tailOffset(f, n)
+		require.NoError(t, err)
+		assert.Equal(t, want, offset, "last %d lines", n)
+	}
+}
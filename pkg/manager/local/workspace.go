@@ -0,0 +1,145 @@
+package local
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+
+	"github.com/sipeed/picoclaw/pkg/manager/tenant"
+)
+
+func workspacePath(dir string) string {
+	return filepath.Join(dir, ".picoclaw", "workspace")
+}
+
+// ExportWorkspace archives the tenant's workspace directory. Only
+// directories and regular files are included. A tenant without a workspace
+// yet gives an empty archive.
+func (r *Runtime) ExportWorkspace(ctx context.Context, t *tenant.Tenant, w io.Writer) error {
+	root := workspacePath(r.dir(t))
+	zw := gzip.NewWriter(w)
+	tw := tar.NewWriter(zw)
+	err := filepath.WalkDir(root, func(p string, d fs.DirEntry, err error) error {
+		if errors.Is(err, fs.ErrNotExist) && p == root {
+			return fs.SkipAll
+		}
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if p == root || !(d.IsDir() || d.Type().IsRegular()) {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		hdr, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return err
+		}
+		hdr.Name = filepath.ToSlash(rel)
+		if d.IsDir() {
+			hdr.Name += "/"
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if d.IsDir() {
+			return nil
+		}
+		f, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		_, err = io.Copy(tw, f)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("archive workspace: %w", err)
+	}
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("archive workspace: %w", err)
+	}
+	return zw.Close()
+}
+
+// ImportWorkspace replaces the tenant's workspace directory with the
+// archive's contents. Entries other than directories and regular files,
+// and entries that would land outside the workspace, are skipped.
+func (r *Runtime) ImportWorkspace(ctx context.Context, t *tenant.Tenant, archive io.Reader) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.procs[t.ID] != nil {
+		return fmt.Errorf("the gateway of tenant %s is running", t.ID)
+	}
+
+	root := workspacePath(r.dir(t))
+	if err := os.RemoveAll(root); err != nil {
+		return fmt.Errorf("clear workspace: %w", err)
+	}
+	if err := os.MkdirAll(root, 0o700); err != nil {
+		return fmt.Errorf("create workspace: %w", err)
+	}
+
+	zr, err := gzip.NewReader(archive)
+	if err != nil {
+		return fmt.Errorf("read archive: %w", err)
+	}
+	tr := tar.NewReader(zr)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("read archive: %w", err)
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		name := path.Clean(hdr.Name)
+		if name == "." || !filepath.IsLocal(filepath.FromSlash(name)) {
+			continue
+		}
+		target := filepath.Join(root, filepath.FromSlash(name))
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			err = os.MkdirAll(target, 0o700)
+		case tar.TypeReg:
+			err = extractFile(target, hdr.FileInfo().Mode().Perm(), tr)
+		}
+		if err != nil {
+			return fmt.Errorf("restore %s: %w", name, err)
+		}
+	}
+}
+
+func extractFile(target string, perm fs.FileMode, r io.Reader) error {
+	if err := os.MkdirAll(filepath.Dir(target), 0o700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, perm|0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(f, r); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
@@ -0,0 +1,121 @@
+package templates
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// versionFile names a template set's version. Without it, the set is
+// named after its directory.
+const versionFile = "VERSION"
+
+// Catalog holds every template set the manager can render, by version, so
+// that tenants on different versions can be rendered side by side.
+type Catalog struct {
+	renderers map[string]*Renderer
+	def       string
+}
+
+// LoadCatalog loads the template sets in dir. If dir holds templates
+// itself, it is the only set. Otherwise every subdirectory holding
+// templates is one. defaultVersion picks the set new tenants get; it may
+// be empty when there is only one.
+func LoadCatalog(dir, defaultVersion string) (*Catalog, error) {
+	if _, err := os.Stat(dir); err != nil {
+		return nil, fmt.Errorf("template directory %s: %w", dir, err)
+	}
+	dirs := []string{dir}
+	if !hasTemplates(dir) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return nil, fmt.Errorf("read template directory %s: %w", dir, err)
+		}
+		dirs = dirs[:0]
+		for _, e := range entries {
+			if sub := filepath.Join(dir, e.Name()); e.IsDir() && hasTemplates(sub) {
+				dirs = append(dirs, sub)
+			}
+		}
+		if len(dirs) == 0 {
+			return nil, fmt.Errorf("no templates in %s or its subdirectories", dir)
+		}
+	}
+
+	c := &Catalog{renderers: make(map[string]*Renderer)}
+	for _, d := range dirs {
+		version, err := setVersion(d)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := c.renderers[version]; ok {
+			return nil, fmt.Errorf("template version %q is defined twice", version)
+		}
+		r, err := NewRenderer(d)
+		if err != nil {
+			return nil, err
+		}
+		c.renderers[version] = r
+	}
+
+	switch {
+	case defaultVersion != "":
+		if _, ok := c.renderers[defaultVersion]; !ok {
+			return nil, fmt.Errorf("default template version %q not found in %s (have %s)",
+				defaultVersion, dir, strings.Join(c.Versions(), ", "))
+		}
+		c.def = defaultVersion
+	case len(c.renderers) == 1:
+		c.def = c.Versions()[0]
+	default:
+		return nil, fmt.Errorf("%s holds several template versions (%s), pick the default one",
+			dir, strings.Join(c.Versions(), ", "))
+	}
+	return c, nil
+}
+
+func hasTemplates(dir string) bool {
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.yaml.tmpl"))
+	return len(matches) > 0
+}
+
+func setVersion(dir string) (string, error) {
+	data, err := os.ReadFile(filepath.Join(dir, versionFile))
+	if os.IsNotExist(err) {
+		return filepath.Base(dir), nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("read template version: %w", err)
+	}
+	version := strings.TrimSpace(string(data))
+	if version == "" {
+		return "", fmt.Errorf("%s is empty", filepath.Join(dir, versionFile))
+	}
+	return version, nil
+}
+
+// Default returns the version new tenants get.
+func (c *Catalog) Default() string {
+	return c.def
+}
+
+// Versions returns every version, sorted.
+func (c *Catalog) Versions() []string {
+	versions := make([]string, 0, len(c.renderers))
+	for v := range c.renderers {
+		versions = append(versions, v)
+	}
+	slices.Sort(versions)
+	return versions
+}
+
+// Renderer returns the renderer of a version.
+func (c *Catalog) Renderer(version string) (*Renderer, error) {
+	r, ok := c.renderers[version]
+	if !ok {
+		return nil, fmt.Errorf("unknown template version %q", version)
+	}
+	return r, nil
+}
@@ -17,6 +17,7 @@ type Renderer struct {
 var templateNames = []string{
 	"namespace.yaml.tmpl",
 	"configmap.yaml.tmpl",
+	"secret.yaml.tmpl",
 	"pvc.yaml.tmpl",
 	"rbac.yaml.tmpl",
 	"agent-deployment.yaml.tmpl",
@@ -0,0 +1,224 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"strings"
+	"time"
+
+	k8sclient "github.com/sipeed/picoclaw/pkg/manager/k8s"
+	"github.com/sipeed/picoclaw/pkg/manager/templates"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+)
+
+// Cluster is the part of the Kubernetes applier KubernetesRuntime uses.
+// *k8s.Applier implements it.
+type Cluster interface {
+	Apply(ctx context.Context, manifests []byte) error
+	Inspect(ctx context.Context, namespace string, manifests []byte) (*k8sclient.TenantState, error)
+	DeleteNamespace(ctx context.Context, namespace string) error
+	RestartDeployment(ctx context.Context, namespace, name string) error
+	Logs(ctx context.Context, namespace, deployment string, tailLines int64, follow bool) (io.ReadCloser, error)
+	Events(ctx context.Context, namespace string) ([]k8sclient.Event, error)
+	ProxyGet(ctx context.Context, namespace, service, port, path string) ([]byte, error)
+	ExecInVolume(ctx context.Context, namespace, claim string, command []string, stdin io.Reader, stdout io.Writer) error
+	WaitPodsGone(ctx context.Context, namespace, selector string) error
+}
+
+// kubernetesWorkloads maps workloads to the deployments running them.
+var kubernetesWorkloads = []struct{ workload, deployment string }{
+	{WorkloadAgent, "picoclaw-agent"},
+	{WorkloadGateway, "picoclaw-gateway"},
+}
+
+// The gateway service and port, as in gateway-service.yaml.tmpl.
+const (
+	gatewayService = "picoclaw-gateway"
+	gatewayPort    = "18790"
+)
+
+// workspaceClaim is the workspace volume claim, as in pvc.yaml.tmpl.
+const workspaceClaim = "picoclaw-workspace"
+
+// KubernetesRuntime runs each tenant in its own namespace, from manifests
+// rendered from the tenant's template version.
+type KubernetesRuntime struct {
+	catalog *templates.Catalog
+	cluster Cluster
+	image   string
+}
+
+// NewKubernetesRuntime creates a runtime deploying to cluster. New tenants
+// get image and the catalog's default template version.
+func NewKubernetesRuntime(catalog *templates.Catalog, cluster Cluster, image string) *KubernetesRuntime {
+	return &KubernetesRuntime{catalog: catalog, cluster: cluster, image: image}
+}
+
+// ResolveVersions defaults to the runtime's image and the catalog's
+// default template version, and checks that the template version exists.
+func (k *KubernetesRuntime) ResolveVersions(v Versions) (Versions, error) {
+	if v.Image == "" {
+		v.Image = k.image
+	}
+	if v.TemplateVersion == "" {
+		v.TemplateVersion = k.catalog.Default()
+	}
+	if _, err := k.catalog.Renderer(v.TemplateVersion); err != nil {
+		return v, invalidf("%v (have %s)", err, strings.Join(k.catalog.Versions(), ", "))
+	}
+	return v, nil
+}
+
+func (k *KubernetesRuntime) render(t *Tenant) ([]byte, error) {
+	// Tenants recorded before versions were tracked follow the defaults.
+	v, err := k.ResolveVersions(t.Versions())
+	if err != nil {
+		return nil, err
+	}
+	renderer, err := k.catalog.Renderer(v.TemplateVersion)
+	if err != nil {
+		return nil, err
+	}
+	manifests, err := renderer.RenderAll(t.ToVars(v.Image))
+	if err != nil {
+		return nil, fmt.Errorf("render templates: %w", err)
+	}
+	return manifests, nil
+}
+
+// Apply renders the tenant's manifests and applies them.
+func (k *KubernetesRuntime) Apply(ctx context.Context, t *Tenant) error {
+	manifests, err := k.render(t)
+	if err != nil {
+		return err
+	}
+	return k.cluster.Apply(ctx, manifests)
+}
+
+// Delete deletes the tenant's namespace.
+func (k *KubernetesRuntime) Delete(ctx context.Context, t *Tenant) error {
+	if err := k.cluster.DeleteNamespace(ctx, t.Namespace); err != nil && !apierrors.IsNotFound(err) {
+		return err
+	}
+	return nil
+}
+
+// Restart triggers a rollout restart of every deployment.
+func (k *KubernetesRuntime) Restart(ctx context.Context, t *Tenant) error {
+	for _, w := range kubernetesWorkloads {
+		if err := k.cluster.RestartDeployment(ctx, t.Namespace, w.deployment); err != nil {
+			return fmt.Errorf("restart %s: %w", w.deployment, err)
+		}
+	}
+	return nil
+}
+
+// Status inspects the tenant's namespace against its rendered manifests.
+func (k *KubernetesRuntime) Status(ctx context.Context, t *Tenant) (*RuntimeState, error) {
+	manifests, err := k.render(t)
+	if err != nil {
+		return nil, err
+	}
+	cs, err := k.cluster.Inspect(ctx, t.Namespace, manifests)
+	if err != nil {
+		return nil, err
+	}
+	state := &RuntimeState{
+		Exists:   cs.NamespaceExists,
+		Deleting: cs.Terminating,
+		Missing:  cs.Missing,
+		Drifted:  cs.Drifted,
+	}
+	for _, w := range kubernetesWorkloads {
+		ws := WorkloadState{Name: w.workload, Reason: "NotFound", Message: "deployment " + w.deployment + " does not exist"}
+		for _, ds := range cs.Deployments {
+			if ds.Name == w.deployment {
+				ws = WorkloadState{
+					Name:          w.workload,
+					Replicas:      ds.Replicas,
+					ReadyReplicas: ds.ReadyReplicas,
+					Available:     ds.Available,
+					CrashLooping:  ds.CrashLooping,
+					Stalled:       ds.Stalled,
+					Reason:        ds.Reason,
+					Message:       ds.Message,
+				}
+			}
+		}
+		state.Workloads = append(state.Workloads, ws)
+		if w.workload == WorkloadGateway && ws.Available && ws.ReadyReplicas > 0 {
+			state.LastActivity, state.Usage = k.gatewayHealth(ctx, t)
+		}
+	}
+	return state, nil
+}
+
+// gatewayHealth asks the gateway when it last received a message and what
+// it counted. Failures are only logged: both are advisory.
+func (k *KubernetesRuntime) gatewayHealth(ctx context.Context, t *Tenant) (*time.Time, *UsageSample) {
+	body, err := k.cluster.ProxyGet(ctx, t.Namespace, gatewayService, gatewayPort, "/health")
+	if err == nil {
+		var at *time.Time
+		var usage *UsageSample
+		if at, usage, err = ParseGatewayHealth(body); err == nil {
+			return at, usage
+		}
+	}
+	log.Printf("tenant %s: read gateway activity: %v", t.ID, err)
+	return nil, nil
+}
+
+// Logs streams the logs of the workload's newest pod. Suspended tenants
+// have no pods, and so no logs.
+func (k *KubernetesRuntime) Logs(ctx context.Context, t *Tenant, opts LogOptions) (io.ReadCloser, error) {
+	workload := opts.Workload
+	if workload == "" {
+		workload = WorkloadGateway
+	}
+	for _, w := range kubernetesWorkloads {
+		if w.workload != workload {
+			continue
+		}
+		if t.Suspended {
+			return nil, conflictf("tenant %q is suspended: its pods and their logs are gone", t.ID)
+		}
+		logs, err := k.cluster.Logs(ctx, t.Namespace, w.deployment, opts.TailLines, opts.Follow)
+		if apierrors.IsNotFound(err) {
+			return nil, notFoundf("tenant %q has no %s deployment", t.ID, workload)
+		}
+		return logs, err
+	}
+	return nil, invalidf("unknown workload %q", workload)
+}
+
+// Events returns the events in the tenant's namespace, newest first.
+func (k *KubernetesRuntime) Events(ctx context.Context, t *Tenant) ([]Event, error) {
+	events, err := k.cluster.Events(ctx, t.Namespace)
+	if err != nil {
+		return nil, err
+	}
+	out := make([]Event, len(events))
+	for i, e := range events {
+		out[i] = Event{Time: e.Time, Type: e.Type, Reason: e.Reason, Object: e.Object, Message: e.Message, Count: e.Count}
+	}
+	return out, nil
+}
+
+// ExportWorkspace archives the workspace volume from a helper pod, so it
+// works whether or not the tenant is running.
+func (k *KubernetesRuntime) ExportWorkspace(ctx context.Context, t *Tenant, w io.Writer) error {
+	return k.cluster.ExecInVolume(ctx, t.Namespace, workspaceClaim,
+		[]string{"tar", "czf", "-", "-C", k8sclient.VolumePath, "."}, nil, w)
+}
+
+// ImportWorkspace waits for the tenant's pods to stop, then empties the
+// workspace volume and unpacks the archive into it from a helper pod.
+func (k *KubernetesRuntime) ImportWorkspace(ctx context.Context, t *Tenant, r io.Reader) error {
+	if err := k.cluster.WaitPodsGone(ctx, t.Namespace, "picoclaw.io/tenant="+t.ID); err != nil {
+		return err
+	}
+	script := fmt.Sprintf("find %[1]s -mindepth 1 -delete && tar xzf - -C %[1]s", k8sclient.VolumePath)
+	return k.cluster.ExecInVolume(ctx, t.Namespace, workspaceClaim, []string{"sh", "-c", script}, r, io.Discard)
+}
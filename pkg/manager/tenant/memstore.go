@@ -0,0 +1,330 @@
+package tenant
+
+import (
+	"fmt"
+	"maps"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryStore is a TenantStore that keeps everything in memory, for tests
+// and for trying the manager out. Nothing survives a restart.
+type MemoryStore struct {
+	mu         sync.Mutex
+	tenants    map[string]*Tenant
+	operations map[string]*Operation
+	snapshots  map[string]*Snapshot
+	upgrades   map[string]*Upgrade
+	samples    map[string]UsageSample
+	usage      map[string]map[string]UsageCounters // tenant ID, then day
+}
+
+// NewMemoryStore creates an empty MemoryStore.
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		tenants:    make(map[string]*Tenant),
+		operations: make(map[string]*Operation),
+		snapshots:  make(map[string]*Snapshot),
+		upgrades:   make(map[string]*Upgrade),
+		samples:    make(map[string]UsageSample),
+		usage:      make(map[string]map[string]UsageCounters),
+	}
+}
+
+// cloneTenant copies t so that callers cannot change stored maps and
+// slices.
+func cloneTenant(t *Tenant) *Tenant {
+	c := *t
+	c.Secrets = maps.Clone(t.Secrets)
+	c.Labels = maps.Clone(t.Labels)
+	if t.LastActivityAt != nil {
+		at := *t.LastActivityAt
+		c.LastActivityAt = &at
+	}
+	c.Conditions = cloneConditions(t.Conditions)
+	return &c
+}
+
+func cloneUpgrade(u *Upgrade) *Upgrade {
+	c := *u
+	c.Selector = maps.Clone(u.Selector)
+	c.Canary = maps.Clone(u.Canary)
+	c.Tenants = append([]UpgradeTenant(nil), u.Tenants...)
+	return &c
+}
+
+func cloneOperation(op *Operation) *Operation {
+	c := *op
+	c.Steps = append([]Step(nil), op.Steps...)
+	return &c
+}
+
+// Create inserts a new tenant record.
+func (s *MemoryStore) Create(t *Tenant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.tenants[t.ID]; ok {
+		return fmt.Errorf("tenant %q exists", t.ID)
+	}
+	s.tenants[t.ID] = cloneTenant(t)
+	return nil
+}
+
+// Get retrieves a tenant by ID.
+func (s *MemoryStore) Get(id string) (*Tenant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tenants[id]
+	if !ok {
+		return nil, nil
+	}
+	return cloneTenant(t), nil
+}
+
+// List returns all tenants, oldest first.
+func (s *MemoryStore) List() ([]Tenant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var list []Tenant
+	for _, t := range s.tenants {
+		list = append(list, *cloneTenant(t))
+	}
+	sort.Slice(list, func(i, j int) bool {
+		if !list[i].CreatedAt.Equal(list[j].CreatedAt) {
+			return list[i].CreatedAt.Before(list[j].CreatedAt)
+		}
+		return list[i].ID < list[j].ID
+	})
+	return list, nil
+}
+
+// Update modifies an existing tenant. Tenants being deleted cannot be
+// updated.
+func (s *MemoryStore) Update(t *Tenant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	current, ok := s.tenants[t.ID]
+	if !ok || current.Status == StatusDeleting {
+		return fmt.Errorf("tenant %q not found or being deleted", t.ID)
+	}
+	t.UpdatedAt = time.Now()
+	c := cloneTenant(t)
+	c.Conditions = current.Conditions
+	s.tenants[t.ID] = c
+	return nil
+}
+
+// UpdateStatus sets a tenant's status and conditions. A tenant being
+// deleted stays deleting.
+func (s *MemoryStore) UpdateStatus(id, status string, conditions []Condition) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tenants[id]
+	if !ok || (t.Status == StatusDeleting && status != StatusDeleting) {
+		return fmt.Errorf("tenant %q not found or being deleted", id)
+	}
+	t.Status, t.Conditions = status, cloneConditions(conditions)
+	return nil
+}
+
+// RecordActivity moves a tenant's last activity forward to at.
+func (s *MemoryStore) RecordActivity(id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tenants[id]
+	if ok && (t.LastActivityAt == nil || t.LastActivityAt.Before(at)) {
+		t.LastActivityAt = &at
+	}
+	return nil
+}
+
+// RecordUsage adds what a gateway counted since the last sample to the
+// usage of the sample's day.
+func (s *MemoryStore) RecordUsage(id string, sample UsageSample) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var last *UsageSample
+	if l, ok := s.samples[id]; ok {
+		last = &l
+	}
+	if delta := usageDelta(last, sample); delta != (UsageCounters{}) {
+		if s.usage[id] == nil {
+			s.usage[id] = make(map[string]UsageCounters)
+		}
+		day := sample.SampledAt.UTC().Format(usageDayFormat)
+		counters := s.usage[id][day]
+		counters.Add(delta)
+		s.usage[id][day] = counters
+	}
+	s.samples[id] = sample
+	return nil
+}
+
+// GetUsageSample returns the last usage a tenant's gateway reported.
+func (s *MemoryStore) GetUsageSample(tenantID string) (*UsageSample, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sample, ok := s.samples[tenantID]
+	if !ok {
+		return nil, nil
+	}
+	return &sample, nil
+}
+
+// ListUsage returns a tenant's daily usage from fromDay to toDay, oldest
+// first.
+func (s *MemoryStore) ListUsage(tenantID, fromDay, toDay string) ([]UsageDay, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var days []UsageDay
+	for day, counters := range s.usage[tenantID] {
+		if day >= fromDay && day <= toDay {
+			days = append(days, UsageDay{Day: day, UsageCounters: counters})
+		}
+	}
+	sort.Slice(days, func(i, j int) bool { return days[i].Day < days[j].Day })
+	return days, nil
+}
+
+// Delete removes a tenant record by ID.
+func (s *MemoryStore) Delete(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.tenants, id)
+	return nil
+}
+
+// CreateOperation inserts a new operation record.
+func (s *MemoryStore) CreateOperation(op *Operation) error {
+	return s.UpdateOperation(op)
+}
+
+// UpdateOperation stores the progress of an operation.
+func (s *MemoryStore) UpdateOperation(op *Operation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.operations[op.ID] = cloneOperation(op)
+	return nil
+}
+
+// GetOperation retrieves an operation by ID.
+func (s *MemoryStore) GetOperation(id string) (*Operation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	op, ok := s.operations[id]
+	if !ok {
+		return nil, nil
+	}
+	return cloneOperation(op), nil
+}
+
+// GetOperationByIdempotencyKey retrieves the operation started with key.
+func (s *MemoryStore) GetOperationByIdempotencyKey(key string) (*Operation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, op := range s.operations {
+		if op.IdempotencyKey == key {
+			return cloneOperation(op), nil
+		}
+	}
+	return nil, nil
+}
+
+// ListOperations returns the operations of a tenant, newest first.
+func (s *MemoryStore) ListOperations(tenantID string) ([]Operation, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var ops []Operation
+	for _, op := range s.operations {
+		if op.TenantID == tenantID {
+			ops = append(ops, *cloneOperation(op))
+		}
+	}
+	sort.Slice(ops, func(i, j int) bool { return ops[i].CreatedAt.After(ops[j].CreatedAt) })
+	return ops, nil
+}
+
+// FailInterrupted is a no-op: a new MemoryStore has no operations.
+func (s *MemoryStore) FailInterrupted() (int64, error) {
+	return 0, nil
+}
+
+// CreateSnapshot records a stored snapshot.
+func (s *MemoryStore) CreateSnapshot(sn *Snapshot) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, ok := s.snapshots[sn.ID]; ok {
+		return fmt.Errorf("snapshot %q exists", sn.ID)
+	}
+	c := *sn
+	s.snapshots[sn.ID] = &c
+	return nil
+}
+
+// GetSnapshot retrieves a snapshot by ID.
+func (s *MemoryStore) GetSnapshot(id string) (*Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	sn, ok := s.snapshots[id]
+	if !ok {
+		return nil, nil
+	}
+	c := *sn
+	return &c, nil
+}
+
+// ListSnapshots returns the snapshots of a tenant, newest first.
+func (s *MemoryStore) ListSnapshots(tenantID string) ([]Snapshot, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var snapshots []Snapshot
+	for _, sn := range s.snapshots {
+		if sn.TenantID == tenantID {
+			snapshots = append(snapshots, *sn)
+		}
+	}
+	sort.Slice(snapshots, func(i, j int) bool { return snapshots[i].CreatedAt.After(snapshots[j].CreatedAt) })
+	return snapshots, nil
+}
+
+// CreateUpgrade inserts a new upgrade record.
+func (s *MemoryStore) CreateUpgrade(u *Upgrade) error {
+	return s.UpdateUpgrade(u)
+}
+
+// UpdateUpgrade stores the progress of an upgrade.
+func (s *MemoryStore) UpdateUpgrade(u *Upgrade) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.upgrades[u.ID] = cloneUpgrade(u)
+	return nil
+}
+
+// GetUpgrade retrieves an upgrade by ID.
+func (s *MemoryStore) GetUpgrade(id string) (*Upgrade, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u, ok := s.upgrades[id]
+	if !ok {
+		return nil, nil
+	}
+	return cloneUpgrade(u), nil
+}
+
+// ListUpgrades returns the upgrades, newest first.
+func (s *MemoryStore) ListUpgrades() ([]Upgrade, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var upgrades []Upgrade
+	for _, u := range s.upgrades {
+		upgrades = append(upgrades, *cloneUpgrade(u))
+	}
+	sort.Slice(upgrades, func(i, j int) bool { return upgrades[i].CreatedAt.After(upgrades[j].CreatedAt) })
+	return upgrades, nil
+}
+
+// PauseInterruptedUpgrades is a no-op: a new MemoryStore has no upgrades.
+func (s *MemoryStore) PauseInterruptedUpgrades() (int64, error) {
+	return 0, nil
+}
@@ -1,20 +1,89 @@
 package tenant
 
 import (
+	"encoding/base64"
 	"encoding/json"
 	"time"
 )
 
 // Tenant represents a tenant record in the database.
 type Tenant struct {
-	ID          string          `json:"id"`
-	DisplayName string          `json:"display_name"`
-	Namespace   string          `json:"namespace"`
-	ConfigJSON  json.RawMessage `json:"config_json"`
-	Resources   Resources       `json:"resources"`
-	Status      string          `json:"status"`
-	CreatedAt   time.Time       `json:"created_at"`
-	UpdatedAt   time.Time       `json:"updated_at"`
+	ID          string            `json:"id"`
+	DisplayName string            `json:"display_name"`
+	Namespace   string            `json:"namespace"`
+	ConfigJSON  json.RawMessage   `json:"config_json"`
+	Secrets     map[string]string `json:"-"` // env var -> value, kept out of ConfigJSON
+	Resources   Resources         `json:"resources"`
+	Status      string            `json:"status"`
+	Conditions  []Condition       `json:"conditions,omitempty"`
+	// Suspended tenants have their workloads scaled to zero; their
+	// workspace and config are kept.
+	Suspended bool `json:"suspended"`
+	// LastActivityAt is when the gateway last reported inbound traffic,
+	// or when the tenant was last resumed.
+	LastActivityAt *time.Time `json:"last_activity_at,omitempty"`
+	// Image and TemplateVersion are what the tenant's workloads are
+	// deployed from. They are set at create and changed by upgrades.
+	Image           string `json:"image"`
+	TemplateVersion string `json:"template_version"`
+	// Labels group tenants, e.g. into a canary group, for upgrades.
+	Labels    map[string]string `json:"labels,omitempty"`
+	CreatedAt time.Time         `json:"created_at"`
+	UpdatedAt time.Time         `json:"updated_at"`
+}
+
+// Versions is what a tenant is deployed from: a picoclaw image and a set
+// of manifest templates.
+type Versions struct {
+	Image           string `json:"image"`
+	TemplateVersion string `json:"template_version"`
+}
+
+// Versions returns what the tenant is deployed from.
+func (t *Tenant) Versions() Versions {
+	return Versions{Image: t.Image, TemplateVersion: t.TemplateVersion}
+}
+
+func (t *Tenant) setVersions(v Versions) {
+	t.Image, t.TemplateVersion = v.Image, v.TemplateVersion
+}
+
+// Tenant statuses, as reported by the reconciler.
+const (
+	StatusProvisioning = "provisioning"  // created or changed, workloads not ready yet
+	StatusReady        = "ready"         // all resources in place and workloads available
+	StatusDegraded     = "degraded"      // workloads unavailable, or resources could not be applied
+	StatusCrashLooping = "crash-looping" // a container keeps crashing
+	StatusSuspended    = "suspended"     // scaled to zero, workspace and config kept
+	StatusDeleting     = "deleting"      // namespace is being deleted
+)
+
+// Condition types reported for a tenant.
+const (
+	ConditionApplied          = "Applied"          // cluster resources match the stored spec
+	ConditionAgentAvailable   = "AgentAvailable"   // picoclaw-agent deployment
+	ConditionGatewayAvailable = "GatewayAvailable" // picoclaw-gateway deployment
+	ConditionReady            = "Ready"
+)
+
+// Condition is one aspect of a tenant's observed state, in the style of
+// Kubernetes status conditions.
+type Condition struct {
+	Type               string    `json:"type"`
+	Status             string    `json:"status"` // "True", "False" or "Unknown"
+	Reason             string    `json:"reason,omitempty"`
+	Message            string    `json:"message,omitempty"`
+	LastTransitionTime time.Time `json:"last_transition_time"`
+}
+
+// FindCondition returns the condition of the given type, or nil.
+func FindCondition(conditions []Condition, condType string) *Condition {
+	for i := range conditions {
+		if conditions[i].Type == condType {
+			return &conditions[i]
+		}
+	}
+	return nil
 }
 
 // Resources defines resource limits for a tenant's workloads.
@@ -28,17 +97,19 @@ type Resources struct {
 
 // TenantVars holds template variables for rendering K8s manifests.
 type TenantVars struct {
-	TenantID      string
-	Namespace     string
-	ConfigJSON    string
-	Image         string
-	AgentReplicas int
-	AgentCPU      string
-	AgentMemory   string
-	GatewayCPU    string
-	GatewayMemory string
-	WorkspaceSize string
-	Labels        map[string]string
+	TenantID        string
+	Namespace       string
+	ConfigJSON      string
+	SecretData      map[string]string // env var -> base64 value
+	Image           string
+	AgentReplicas   int
+	GatewayReplicas int
+	AgentCPU        string
+	AgentMemory     string
+	GatewayCPU      string
+	GatewayMemory   string
+	WorkspaceSize   string
+	Labels          map[string]string
 }
 
 // DefaultResources returns sensible defaults for tenant resources.
@@ -54,17 +125,27 @@ func DefaultResources() Resources {
 
 // ToVars converts a Tenant into TenantVars for template rendering.
 func (t *Tenant) ToVars(image string) TenantVars {
+	secretData := make(map[string]string, len(t.Secrets))
+	for env, value := range t.Secrets {
+		secretData[env] = base64.StdEncoding.EncodeToString([]byte(value))
+	}
+	replicas := 1
+	if t.Suspended {
+		replicas = 0
+	}
 	return TenantVars{
-		TenantID:      t.ID,
-		Namespace:     t.Namespace,
-		ConfigJSON:    string(t.ConfigJSON),
-		Image:         image,
-		AgentReplicas: 1,
-		AgentCPU:      t.Resources.AgentCPU,
-		AgentMemory:   t.Resources.AgentMemory,
-		GatewayCPU:    t.Resources.GatewayCPU,
-		GatewayMemory: t.Resources.GatewayMemory,
-		WorkspaceSize: t.Resources.WorkspaceSize,
+		TenantID:        t.ID,
+		Namespace:       t.Namespace,
+		ConfigJSON:      string(t.ConfigJSON),
+		SecretData:      secretData,
+		Image:           image,
+		AgentReplicas:   replicas,
+		GatewayReplicas: replicas,
+		AgentCPU:        t.Resources.AgentCPU,
+		AgentMemory:     t.Resources.AgentMemory,
+		GatewayCPU:      t.Resources.GatewayCPU,
+		GatewayMemory:   t.Resources.GatewayMemory,
+		WorkspaceSize:   t.Resources.WorkspaceSize,
 		Labels: map[string]string{
 			"app.kubernetes.io/managed-by": "picoclaw-manager",
 			"picoclaw.io/tenant":           t.ID,
@@ -0,0 +1,264 @@
+package tenant
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Operation types.
+const (
+	OpCreate   = "create"
+	OpUpdate   = "update"
+	OpDelete   = "delete"
+	OpRestart  = "restart"
+	OpSuspend  = "suspend"
+	OpResume   = "resume"
+	OpSnapshot = "snapshot"
+	OpRestore  = "restore"
+	OpClone    = "clone"
+	OpUpgrade  = "upgrade"
+)
+
+// Operation and step states.
+const (
+	OpPending   = "pending"
+	OpRunning   = "running"
+	OpSucceeded = "succeeded"
+	OpFailed    = "failed"
+	OpSkipped   = "skipped" // steps only: not run because an earlier step failed
+)
+
+// DefaultOperationTimeout bounds how long one operation may run.
+const DefaultOperationTimeout = 10 * time.Minute
+
+// Operation tracks an asynchronous change to a tenant.
+type Operation struct {
+	ID             string     `json:"id"`
+	TenantID       string     `json:"tenant_id"`
+	Type           string     `json:"type"`
+	Status         string     `json:"status"`
+	Steps          []Step     `json:"steps"`
+	Error          string     `json:"error,omitempty"`
+	RolledBack     bool       `json:"rolled_back"`
+	IdempotencyKey string     `json:"-"`
+	RequestHash    string     `json:"-"`
+	CreatedAt      time.Time  `json:"created_at"`
+	UpdatedAt      time.Time  `json:"updated_at"`
+	FinishedAt     *time.Time `json:"finished_at,omitempty"`
+}
+
+// Step is one stage of an operation.
+type Step struct {
+	Name       string     `json:"name"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  *time.Time `json:"started_at,omitempty"`
+	FinishedAt *time.Time `json:"finished_at,omitempty"`
+}
+
+// Done reports whether the operation has finished.
+func (op *Operation) Done() bool {
+	return op.Status == OpSucceeded || op.Status == OpFailed
+}
+
+// Progress returns the percentage of steps finished.
+func (op *Operation) Progress() int {
+	if len(op.Steps) == 0 {
+		return 0
+	}
+	done := 0
+	for _, s := range op.Steps {
+		if s.Status != OpPending && s.Status != OpRunning {
+			done++
+		}
+	}
+	return done * 100 / len(op.Steps)
+}
+
+// step is a named function run by an operation.
+type step struct {
+	name string
+	run  func(ctx context.Context) error
+}
+
+func newOperationID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "op-" + hex.EncodeToString(b)
+}
+
+// startOperation records a new operation for a tenant and runs its steps in
+// the background. Only one operation may run per tenant at a time. If a step
+// fails, the remaining steps are skipped and rollback, when given, runs as
+// an extra step.
+func (s *Service) startOperation(op *Operation, steps []step, rollback func(ctx context.Context) error) (*Operation, error) {
+	if err := s.reserve(op); err != nil {
+		return nil, err
+	}
+	return s.launch(op, steps, rollback)
+}
+
+// reserve takes the operation slot of op's tenant, which also keeps the
+// reconciler away from it. launch hands the slot to the operation; callers
+// that do not launch must release it.
+func (s *Service) reserve(op *Operation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if running, ok := s.active[op.TenantID]; ok {
+		if running == reconcileSlot {
+			return conflictf("tenant %q is being reconciled, retry shortly", op.TenantID)
+		}
+		return conflictf("tenant %q has operation %s in progress", op.TenantID, running)
+	}
+	s.active[op.TenantID] = op.ID
+	return nil
+}
+
+// launch records op, whose tenant slot is reserved, and runs its steps in
+// the background.
+func (s *Service) launch(op *Operation, steps []step, rollback func(ctx context.Context) error) (*Operation, error) {
+	now := time.Now().UTC()
+	op.Status = OpPending
+	op.CreatedAt, op.UpdatedAt = now, now
+	for _, st := range steps {
+		op.Steps = append(op.Steps, Step{Name: st.name, Status: OpPending})
+	}
+	if rollback != nil {
+		op.Steps = append(op.Steps, Step{Name: "rollback", Status: OpPending})
+	}
+	if err := s.store.CreateOperation(op); err != nil {
+		s.release(op.TenantID)
+		return nil, fmt.Errorf("store operation: %w", err)
+	}
+
+	snapshot := *op
+	snapshot.Steps = append([]Step(nil), op.Steps...)
+	s.wg.Add(1)
+	go s.runOperation(op, steps, rollback)
+	return &snapshot, nil
+}
+
+func (s *Service) runOperation(op *Operation, steps []step, rollback func(ctx context.Context) error) {
+	defer s.wg.Done()
+	defer s.release(op.TenantID)
+
+	ctx, cancel := context.WithTimeout(context.Background(), s.OperationTimeout)
+	defer cancel()
+
+	op.Status = OpRunning
+	s.saveOperation(op)
+
+	var failed error
+	for i, st := range steps {
+		if failed != nil {
+			op.Steps[i].Status = OpSkipped
+			continue
+		}
+		if err := s.runStep(ctx, op, i, st.run); err != nil {
+			failed = err
+			op.Error = fmt.Sprintf("%s: %v", st.name, err)
+		}
+	}
+
+	if rollback != nil {
+		last := len(op.Steps) - 1
+		if failed == nil {
+			op.Steps[last].Status = OpSkipped
+		} else if err := s.runStep(ctx, op, last, rollback); err != nil {
+			log.Printf("operation %s: rollback failed: %v", op.ID, err)
+		} else {
+			op.RolledBack = true
+		}
+	}
+
+	finished := time.Now().UTC()
+	op.FinishedAt = &finished
+	op.Status = OpSucceeded
+	if failed != nil {
+		op.Status = OpFailed
+		log.Printf("operation %s (%s %s) failed: %s", op.ID, op.Type, op.TenantID, op.Error)
+	}
+	s.saveOperation(op)
+}
+
+func (s *Service) runStep(ctx context.Context, op *Operation, i int, run func(ctx context.Context) error) error {
+	started := time.Now().UTC()
+	op.Steps[i].Status = OpRunning
+	op.Steps[i].StartedAt = &started
+	s.saveOperation(op)
+
+	err := run(ctx)
+
+	finished := time.Now().UTC()
+	op.Steps[i].FinishedAt = &finished
+	op.Steps[i].Status = OpSucceeded
+	if err != nil {
+		op.Steps[i].Status = OpFailed
+		op.Steps[i].Error = err.Error()
+	}
+	s.saveOperation(op)
+	return err
+}
+
+func (s *Service) saveOperation(op *Operation) {
+	op.UpdatedAt = time.Now().UTC()
+	if err := s.store.UpdateOperation(op); err != nil {
+		log.Printf("operation %s: store progress: %v", op.ID, err)
+	}
+}
+
+func (s *Service) release(tenantID string) {
+	s.mu.Lock()
+	delete(s.active, tenantID)
+	s.mu.Unlock()
+}
+
+// reconcileSlot marks a tenant's slot as held by the reconciler.
+const reconcileSlot = "reconcile"
+
+// TryReserve takes the operation slot of a tenant without an operation in
+// progress, for the reconciler. It reports false if the slot is taken;
+// otherwise the caller must call release when done.
+func (s *Service) TryReserve(tenantID string) (release func(), ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if _, busy := s.active[tenantID]; busy {
+		return nil, false
+	}
+	s.active[tenantID] = reconcileSlot
+	return func() { s.release(tenantID) }, true
+}
+
+// Busy reports whether an operation is running for the tenant.
+func (s *Service) Busy(tenantID string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	_, ok := s.active[tenantID]
+	return ok
+}
+
+// Wait blocks until all running operations have finished.
+func (s *Service) Wait() {
+	s.wg.Wait()
+}
+
+// GetOperation returns an operation by ID.
+func (s *Service) GetOperation(ctx context.Context, id string) (*Operation, error) {
+	op, err := s.store.GetOperation(id)
+	if err != nil {
+		return nil, err
+	}
+	if op == nil {
+		return nil, notFoundf("operation %q not found", id)
+	}
+	return op, nil
+}
+
+// ListOperations returns the operations of a tenant, newest first.
+func (s *Service) ListOperations(ctx context.Context, tenantID string) ([]Operation, error) {
+	return s.store.ListOperations(tenantID)
+}
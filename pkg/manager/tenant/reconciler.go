@@ -0,0 +1,299 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// DefaultProvisioningTimeout is how long a created or changed tenant may
+// take to become ready before it is reported as degraded.
+const DefaultProvisioningTimeout = 10 * time.Minute
+
+// StatusStore is the persistence the reconciler needs. *Store implements it.
+type StatusStore interface {
+	List() ([]Tenant, error)
+	Get(id string) (*Tenant, error)
+	UpdateStatus(id, status string, conditions []Condition) error
+	RecordActivity(id string, at time.Time) error
+	RecordUsage(id string, sample UsageSample) error
+	Delete(id string) error
+}
+
+// workloadConditions are the conditions reporting each workload.
+var workloadConditions = map[string]string{
+	WorkloadAgent:   ConditionAgentAvailable,
+	WorkloadGateway: ConditionGatewayAvailable,
+}
+
+// Reconciler periodically compares each tenant's stored spec with the
+// runtime, re-applies missing or drifted resources and records the
+// observed status and conditions.
+type Reconciler struct {
+	store               StatusStore
+	runtime             Runtime
+	ProvisioningTimeout time.Duration
+	// Hold, if set, takes a tenant's operation slot for the length of its
+	// reconcile, so that no operation changes the tenant meanwhile. It
+	// reports false for tenants to leave alone this pass, such as those
+	// with an operation in progress.
+	Hold func(tenantID string) (release func(), ok bool)
+	now  func() time.Time
+}
+
+// NewReconciler creates a reconciler.
+func NewReconciler(store StatusStore, runtime Runtime) *Reconciler {
+	return &Reconciler{
+		store:               store,
+		runtime:             runtime,
+		ProvisioningTimeout: DefaultProvisioningTimeout,
+		now:                 time.Now,
+	}
+}
+
+// Run reconciles all tenants every interval until ctx is done.
+func (r *Reconciler) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if err := r.ReconcileAll(ctx); err != nil {
+			log.Printf("reconcile tenants: %v", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// ReconcileAll reconciles every tenant. A failure on one tenant does not
+// stop the others; the errors are joined.
+func (r *Reconciler) ReconcileAll(ctx context.Context) error {
+	tenants, err := r.store.List()
+	if err != nil {
+		return err
+	}
+	var failed []string
+	for i := range tenants {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if err := r.reconcileHeld(ctx, &tenants[i]); err != nil {
+			failed = append(failed, fmt.Sprintf("%s: %v", tenants[i].ID, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("%s", strings.Join(failed, "; "))
+	}
+	return nil
+}
+
+// reconcileHeld reconciles t while holding its operation slot. t is read
+// again once held, since an operation may have changed or deleted it after
+// the tenants were listed.
+func (r *Reconciler) reconcileHeld(ctx context.Context, t *Tenant) error {
+	if r.Hold == nil {
+		return r.Reconcile(ctx, t)
+	}
+	release, ok := r.Hold(t.ID)
+	if !ok {
+		return nil
+	}
+	defer release()
+	current, err := r.store.Get(t.ID)
+	if err != nil || current == nil {
+		return err
+	}
+	return r.Reconcile(ctx, current)
+}
+
+// Reconcile brings one tenant's resources in line with its spec and
+// stores the observed status. t is updated in place.
+func (r *Reconciler) Reconcile(ctx context.Context, t *Tenant) error {
+	state, err := r.runtime.Status(ctx, t)
+	if err != nil {
+		return err
+	}
+
+	if t.Status == StatusDeleting {
+		return r.reconcileDeleting(ctx, t, state)
+	}
+
+	conditions := cloneConditions(t.Conditions)
+	switch {
+	case state.Deleting:
+		r.setCondition(&conditions, ConditionApplied, "False", "Terminating",
+			"tenant resources are being deleted outside the manager")
+	case !state.InSync():
+		what := describeDrift(state)
+		if err := r.runtime.Apply(ctx, t); err != nil {
+			log.Printf("tenant %s: re-apply failed: %v", t.ID, err)
+			r.setCondition(&conditions, ConditionApplied, "False", "ApplyFailed", err.Error())
+			break
+		}
+		log.Printf("tenant %s: re-applied (%s)", t.ID, what)
+		r.setCondition(&conditions, ConditionApplied, "True", "Reapplied", "re-applied: "+what)
+		if state, err = r.runtime.Status(ctx, t); err != nil {
+			return err
+		}
+	default:
+		r.setCondition(&conditions, ConditionApplied, "True", "InSync", "")
+	}
+
+	if err := r.recordActivity(t, state); err != nil {
+		return err
+	}
+	if state.Usage != nil {
+		sample := *state.Usage
+		sample.SampledAt = r.now()
+		if err := r.store.RecordUsage(t.ID, sample); err != nil {
+			return err
+		}
+	}
+
+	if t.Suspended {
+		for _, w := range state.Workloads {
+			r.setCondition(&conditions, workloadConditions[w.Name], "False", "Suspended", "scaled to zero")
+		}
+		r.setCondition(&conditions, ConditionReady, "False", "Suspended", "the tenant is suspended")
+		return r.save(t, StatusSuspended, conditions)
+	}
+
+	for _, w := range state.Workloads {
+		condition := workloadConditions[w.Name]
+		if w.Available {
+			r.setCondition(&conditions, condition, "True", "MinimumReplicasAvailable",
+				fmt.Sprintf("%d/%d replicas ready", w.ReadyReplicas, w.Replicas))
+		} else {
+			r.setCondition(&conditions, condition, "False", w.Reason, w.Message)
+		}
+	}
+
+	status, reason, message := r.deriveStatus(t, state, conditions)
+	if status == StatusReady {
+		r.setCondition(&conditions, ConditionReady, "True", "Ready", "")
+	} else {
+		r.setCondition(&conditions, ConditionReady, "False", reason, message)
+	}
+	return r.save(t, status, conditions)
+}
+
+// recordActivity stores the gateway's last inbound traffic if it is newer
+// than what the tenant has.
+func (r *Reconciler) recordActivity(t *Tenant, state *RuntimeState) error {
+	at := state.LastActivity
+	if at == nil || (t.LastActivityAt != nil && !at.After(*t.LastActivityAt)) {
+		return nil
+	}
+	if err := r.store.RecordActivity(t.ID, *at); err != nil {
+		return err
+	}
+	t.LastActivityAt = at
+	return nil
+}
+
+// deriveStatus summarizes the conditions into a tenant status and the
+// reason it is not ready.
+func (r *Reconciler) deriveStatus(t *Tenant, state *RuntimeState, conditions []Condition) (status, reason, message string) {
+	for _, w := range state.Workloads {
+		if w.CrashLooping {
+			return StatusCrashLooping, w.Reason, w.Message
+		}
+	}
+	if applied := FindCondition(conditions, ConditionApplied); applied.Status != "True" {
+		return StatusDegraded, applied.Reason, applied.Message
+	}
+
+	var unavailable *Condition
+	for _, w := range state.Workloads {
+		if c := FindCondition(conditions, workloadConditions[w.Name]); c.Status != "True" && unavailable == nil {
+			unavailable = c
+		}
+	}
+	if unavailable == nil {
+		return StatusReady, "", ""
+	}
+	message = unavailable.Type + ": " + unavailable.Message
+
+	stalled := false
+	for _, w := range state.Workloads {
+		stalled = stalled || w.Stalled
+	}
+	provisioning := t.Status == StatusProvisioning || t.Status == ""
+	switch {
+	case stalled:
+	case provisioning && r.now().Sub(t.UpdatedAt) < r.ProvisioningTimeout:
+		return StatusProvisioning, "Provisioning", message
+	case provisioning:
+		return StatusDegraded, "ProvisioningTimeout", message
+	}
+	return StatusDegraded, unavailable.Reason, message
+}
+
+// reconcileDeleting finishes a deletion started by Service.Delete: the
+// record is removed once the runtime has removed the tenant.
+func (r *Reconciler) reconcileDeleting(ctx context.Context, t *Tenant, state *RuntimeState) error {
+	if !state.Exists {
+		log.Printf("tenant %s: resources deleted, removing record", t.ID)
+		return r.store.Delete(t.ID)
+	}
+	if !state.Deleting {
+		// The delete call failed or raced with a re-apply; try again.
+		if err := r.runtime.Delete(ctx, t); err != nil {
+			return err
+		}
+	}
+	conditions := cloneConditions(t.Conditions)
+	r.setCondition(&conditions, ConditionReady, "False", "Deleting", "waiting for the tenant's resources to be deleted")
+	return r.save(t, StatusDeleting, conditions)
+}
+
+func (r *Reconciler) save(t *Tenant, status string, conditions []Condition) error {
+	if status == t.Status && reflect.DeepEqual(conditions, t.Conditions) {
+		return nil
+	}
+	if status != t.Status {
+		log.Printf("tenant %s: %s -> %s", t.ID, t.Status, status)
+	}
+	if err := r.store.UpdateStatus(t.ID, status, conditions); err != nil {
+		return err
+	}
+	t.Status, t.Conditions = status, conditions
+	return nil
+}
+
+// setCondition adds or updates a condition, keeping its transition time
+// when the status does not change.
+func (r *Reconciler) setCondition(conditions *[]Condition, condType, status, reason, message string) {
+	if c := FindCondition(*conditions, condType); c != nil {
+		if c.Status != status {
+			c.LastTransitionTime = r.now().UTC()
+		}
+		c.Status, c.Reason, c.Message = status, reason, message
+		return
+	}
+	*conditions = append(*conditions, Condition{
+		Type:               condType,
+		Status:             status,
+		Reason:             reason,
+		Message:            message,
+		LastTransitionTime: r.now().UTC(),
+	})
+}
+
+func cloneConditions(conditions []Condition) []Condition {
+	return append([]Condition(nil), conditions...)
+}
+
+func describeDrift(state *RuntimeState) string {
+	var parts []string
+	if len(state.Missing) > 0 {
+		parts = append(parts, "missing "+strings.Join(state.Missing, ", "))
+	}
+	parts = append(parts, state.Drifted...)
+	return strings.Join(parts, "; ")
+}
@@ -0,0 +1,434 @@
+package tenant
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	k8sclient "github.com/sipeed/picoclaw/pkg/manager/k8s"
+	"github.com/sipeed/picoclaw/pkg/manager/templates"
+)
+
+// fakeCluster applies manifests to a fake clientset by creating or
+// replacing each object, keeping the status of existing deployments the
+// way server-side apply would.
+type fakeCluster struct {
+	mu        sync.Mutex
+	cs        *fake.Clientset
+	applied   int
+	applyErr  error
+	applyHook func(manifests []byte) error
+	deleted   []string
+	health    string            // gateway /health response
+	volumes   map[string]string // namespace -> workspace archive
+	execErr   error
+	rollout   func(dep *appsv1.Deployment) // sets the status of applied deployments
+}
+
+func (c *fakeCluster) Apply(ctx context.Context, manifests []byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.applied++
+	if c.applyErr != nil {
+		return c.applyErr
+	}
+	if c.applyHook != nil {
+		if err := c.applyHook(manifests); err != nil {
+			return err
+		}
+	}
+	objects, err := k8sclient.ParseManifests(manifests)
+	if err != nil {
+		return err
+	}
+	for _, obj := range objects {
+		typed, err := scheme.Scheme.New(obj.GroupVersionKind())
+		if err != nil {
+			return err
+		}
+		if err := runtime.DefaultUnstructuredConverter.FromUnstructured(obj.Object, typed); err != nil {
+			return err
+		}
+		gvr, _ := meta.UnsafeGuessKindToResource(obj.GroupVersionKind())
+		tracker := c.cs.Tracker()
+		existing, err := tracker.Get(gvr, obj.GetNamespace(), obj.GetName())
+		dep, isDeployment := typed.(*appsv1.Deployment)
+		if err != nil {
+			if isDeployment && c.rollout != nil {
+				c.rollout(dep)
+			}
+			if err := tracker.Create(gvr, typed, obj.GetNamespace()); err != nil {
+				return err
+			}
+			continue
+		}
+		if isDeployment {
+			dep.Status = existing.(*appsv1.Deployment).Status
+			if c.rollout != nil {
+				c.rollout(dep)
+			}
+		}
+		if err := tracker.Update(gvr, typed, obj.GetNamespace()); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (c *fakeCluster) Inspect(ctx context.Context, namespace string, manifests []byte) (*k8sclient.TenantState, error) {
+	return k8sclient.Inspect(ctx, c.cs, namespace, manifests)
+}
+
+func (c *fakeCluster) DeleteNamespace(ctx context.Context, namespace string) error {
+	c.deleted = append(c.deleted, namespace)
+	return c.cs.CoreV1().Namespaces().Delete(ctx, namespace, metav1.DeleteOptions{})
+}
+
+func (c *fakeCluster) RestartDeployment(ctx context.Context, namespace, name string) error {
+	return nil
+}
+
+func (c *fakeCluster) ProxyGet(ctx context.Context, namespace, service, port, path string) ([]byte, error) {
+	if c.health == "" {
+		return []byte(`{"status":"ok"}`), nil
+	}
+	return []byte(c.health), nil
+}
+
+func (c *fakeCluster) Events(ctx context.Context, namespace string) ([]k8sclient.Event, error) {
+	return k8sclient.Events(ctx, c.cs, namespace)
+}
+
+func (c *fakeCluster) Logs(ctx context.Context, namespace, deployment string, tailLines int64, follow bool) (io.ReadCloser, error) {
+	return k8sclient.Logs(ctx, c.cs, namespace, deployment, tailLines, follow)
+}
+
+// ExecInVolume stands in for tar: it writes the namespace's workspace
+// archive when exporting and replaces it when importing.
+func (c *fakeCluster) ExecInVolume(ctx context.Context, namespace, claim string, command []string, stdin io.Reader, stdout io.Writer) error {
+	if c.execErr != nil {
+		return c.execErr
+	}
+	if c.volumes == nil {
+		c.volumes = make(map[string]string)
+	}
+	if stdin == nil {
+		_, err := io.WriteString(stdout, c.volumes[namespace])
+		return err
+	}
+	data, err := io.ReadAll(stdin)
+	c.volumes[namespace] = string(data)
+	return err
+}
+
+func (c *fakeCluster) WaitPodsGone(ctx context.Context, namespace, selector string) error {
+	return nil
+}
+
+type reconcilerTest struct {
+	t          *testing.T
+	cluster    *fakeCluster
+	store      *MemoryStore
+	reconciler *Reconciler
+	now        time.Time
+}
+
+func newReconcilerTest(t *testing.T) *reconcilerTest {
+	catalog, err := templates.LoadCatalog("../../../k8s/base", "")
+	require.NoError(t, err)
+
+	rt := &reconcilerTest{
+		t:       t,
+		cluster: &fakeCluster{cs: fake.NewClientset()},
+		now:     time.Date(2026, 2, 16, 10, 0, 0, 0, time.UTC),
+	}
+	configJSON, _, err := buildConfigJSON(nil, nil, nil)
+	require.NoError(t, err)
+	rt.store = NewMemoryStore()
+	rt.store.tenants = map[string]*Tenant{
+		"acme": {
+			ID:         "acme",
+			Namespace:  "picoclaw-tenant-acme",
+			ConfigJSON: configJSON,
+			Resources:  DefaultResources(),
+			Status:     StatusProvisioning,
+			CreatedAt:  rt.now,
+			UpdatedAt:  rt.now,
+		},
+	}
+	rt.reconciler = NewReconciler(rt.store, NewKubernetesRuntime(catalog, rt.cluster, "picoclaw:test"))
+	rt.reconciler.now = func() time.Time { return rt.now }
+	return rt
+}
+
+func (rt *reconcilerTest) reconcile() *Tenant {
+	rt.t.Helper()
+	require.NoError(rt.t, rt.reconciler.ReconcileAll(context.Background()))
+	return rt.store.tenants["acme"]
+}
+
+func (rt *reconcilerTest) setReady(name string, ready int32) {
+	rt.t.Helper()
+	deps := rt.cluster.cs.AppsV1().Deployments("picoclaw-tenant-acme")
+	dep, err := deps.Get(context.Background(), name, metav1.GetOptions{})
+	require.NoError(rt.t, err)
+	dep.Status.ReadyReplicas, dep.Status.UpdatedReplicas, dep.Status.Replicas = ready, 1, 1
+	_, err = deps.UpdateStatus(context.Background(), dep, metav1.UpdateOptions{})
+	require.NoError(rt.t, err)
+}
+
+func condition(t *Tenant, condType string) Condition {
+	if c := FindCondition(t.Conditions, condType); c != nil {
+		return *c
+	}
+	return Condition{}
+}
+
+func TestReconciler_ProvisioningToReady(t *testing.T) {
+	rt := newReconcilerTest(t)
+
+	// Nothing exists yet: everything is applied and the pods are pending.
+	tenant := rt.reconcile()
+	assert.Equal(t, 1, rt.cluster.applied)
+	assert.Equal(t, StatusProvisioning, tenant.Status)
+	assert.Equal(t, "Reapplied", condition(tenant, ConditionApplied).Reason)
+	assert.Equal(t, "False", condition(tenant, ConditionReady).Status)
+
+	rt.setReady("picoclaw-agent", 1)
+	rt.setReady("picoclaw-gateway", 1)
+	rt.now = rt.now.Add(time.Minute)
+	tenant = rt.reconcile()
+	assert.Equal(t, 1, rt.cluster.applied, "nothing drifted")
+	assert.Equal(t, StatusReady, tenant.Status)
+	assert.Equal(t, "InSync", condition(tenant, ConditionApplied).Reason)
+	ready := condition(tenant, ConditionReady)
+	assert.Equal(t, "True", ready.Status)
+	assert.Equal(t, rt.now, ready.LastTransitionTime)
+
+	// A pass with no change keeps the transition time.
+	rt.now = rt.now.Add(time.Minute)
+	tenant = rt.reconcile()
+	assert.Equal(t, rt.now.Add(-time.Minute), condition(tenant, ConditionReady).LastTransitionTime)
+}
+
+func TestReconciler_ProvisioningTimeout(t *testing.T) {
+	rt := newReconcilerTest(t)
+	rt.reconcile()
+	rt.setReady("picoclaw-gateway", 1)
+
+	rt.now = rt.now.Add(DefaultProvisioningTimeout + time.Second)
+	tenant := rt.reconcile()
+	assert.Equal(t, StatusDegraded, tenant.Status)
+	ready := condition(tenant, ConditionReady)
+	assert.Equal(t, "ProvisioningTimeout", ready.Reason)
+	assert.Contains(t, ready.Message, "AgentAvailable: 0/1 replicas ready")
+}
+
+func TestReconciler_ReappliesDrift(t *testing.T) {
+	rt := newReconcilerTest(t)
+	ctx := context.Background()
+	rt.reconcile()
+	rt.setReady("picoclaw-agent", 1)
+	rt.setReady("picoclaw-gateway", 1)
+	rt.reconcile()
+
+	ns := "picoclaw-tenant-acme"
+	require.NoError(t, rt.cluster.cs.CoreV1().ConfigMaps(ns).Delete(ctx, "picoclaw-config", metav1.DeleteOptions{}))
+	dep, err := rt.cluster.cs.AppsV1().Deployments(ns).Get(ctx, "picoclaw-agent", metav1.GetOptions{})
+	require.NoError(t, err)
+	dep.Spec.Template.Spec.Containers[0].Image = "picoclaw:hacked"
+	_, err = rt.cluster.cs.AppsV1().Deployments(ns).Update(ctx, dep, metav1.UpdateOptions{})
+	require.NoError(t, err)
+
+	tenant := rt.reconcile()
+	assert.Equal(t, 2, rt.cluster.applied)
+	applied := condition(tenant, ConditionApplied)
+	assert.Equal(t, "Reapplied", applied.Reason)
+	assert.Contains(t, applied.Message, "missing ConfigMap/picoclaw-config")
+	assert.Contains(t, applied.Message, "container picoclaw-agent image changed")
+	assert.Equal(t, StatusReady, tenant.Status)
+
+	_, err = rt.cluster.cs.CoreV1().ConfigMaps(ns).Get(ctx, "picoclaw-config", metav1.GetOptions{})
+	assert.NoError(t, err)
+	dep, _ = rt.cluster.cs.AppsV1().Deployments(ns).Get(ctx, "picoclaw-agent", metav1.GetOptions{})
+	assert.Equal(t, "picoclaw:test", dep.Spec.Template.Spec.Containers[0].Image)
+}
+
+func TestReconciler_HoldsTenantSlot(t *testing.T) {
+	svc, store, cluster := newTestService(t)
+	ctx := context.Background()
+	op, err := svc.Create(ctx, acmeRequest)
+	require.NoError(t, err)
+	finish(t, svc, op)
+	r := NewReconciler(store, svc.runtime)
+	r.Hold = svc.TryReserve
+
+	ns := "picoclaw-tenant-acme"
+	require.NoError(t, cluster.cs.CoreV1().ConfigMaps(ns).Delete(ctx, "picoclaw-config", metav1.DeleteOptions{}))
+	var heldDuringApply bool
+	cluster.applyHook = func([]byte) error {
+		heldDuringApply = svc.Busy("acme")
+		return nil
+	}
+	require.NoError(t, r.ReconcileAll(ctx))
+	assert.True(t, heldDuringApply, "operations must wait for the re-apply")
+	assert.False(t, svc.Busy("acme"), "the slot is released afterwards")
+	_, err = svc.Update(ctx, "acme", UpdateRequest{DisplayName: "ACME Inc"})
+	require.NoError(t, err)
+	svc.Wait()
+
+	// A tenant deleted after the list was taken is not applied again.
+	require.NoError(t, cluster.cs.CoreV1().ConfigMaps(ns).Delete(ctx, "picoclaw-config", metav1.DeleteOptions{}))
+	r.Hold = func(id string) (func(), bool) {
+		release, ok := svc.TryReserve(id)
+		require.NoError(t, store.Delete(id))
+		return release, ok
+	}
+	applied := cluster.applied
+	require.NoError(t, r.ReconcileAll(ctx))
+	assert.Equal(t, applied, cluster.applied)
+}
+
+func TestReconciler_ApplyFailure(t *testing.T) {
+	rt := newReconcilerTest(t)
+	rt.cluster.applyErr = fmt.Errorf("forbidden")
+
+	tenant := rt.reconcile()
+	assert.Equal(t, StatusDegraded, tenant.Status)
+	assert.Equal(t, "ApplyFailed", condition(tenant, ConditionApplied).Reason)
+	assert.Equal(t, "forbidden", condition(tenant, ConditionReady).Message)
+}
+
+func TestReconciler_CrashLooping(t *testing.T) {
+	rt := newReconcilerTest(t)
+	rt.reconcile()
+	rt.setReady("picoclaw-gateway", 1)
+
+	_, err := rt.cluster.cs.CoreV1().Pods("picoclaw-tenant-acme").Create(context.Background(), &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   "picoclaw-agent-7d9f",
+			Labels: map[string]string{"app": "picoclaw-agent"},
+		},
+		Status: corev1.PodStatus{ContainerStatuses: []corev1.ContainerStatus{{
+			Name:         "picoclaw-agent",
+			RestartCount: 6,
+			State: corev1.ContainerState{Waiting: &corev1.ContainerStateWaiting{
+				Reason: "CrashLoopBackOff",
+			}},
+			LastTerminationState: corev1.ContainerState{Terminated: &corev1.ContainerStateTerminated{
+				ExitCode: 1,
+				Reason:   "Error",
+			}},
+		}}},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	tenant := rt.reconcile()
+	assert.Equal(t, StatusCrashLooping, tenant.Status)
+	agent := condition(tenant, ConditionAgentAvailable)
+	assert.Equal(t, "CrashLoopBackOff", agent.Reason)
+	assert.Equal(t, "pod picoclaw-agent-7d9f container picoclaw-agent restarted 6 times, last exit code 1 (Error)", agent.Message)
+	assert.Equal(t, "True", condition(tenant, ConditionGatewayAvailable).Status)
+}
+
+func TestReconciler_Deleting(t *testing.T) {
+	rt := newReconcilerTest(t)
+	ctx := context.Background()
+	rt.reconcile()
+
+	// A namespace that still exists and is not terminating is deleted again.
+	rt.store.tenants["acme"].Status = StatusDeleting
+	tenant := rt.reconcile()
+	assert.Equal(t, []string{"picoclaw-tenant-acme"}, rt.cluster.deleted)
+	assert.Equal(t, StatusDeleting, tenant.Status)
+	assert.Equal(t, 1, rt.cluster.applied, "deleting tenants are not re-applied")
+
+	// Once the namespace is gone, the record is removed.
+	_, err := rt.cluster.cs.CoreV1().Namespaces().Get(ctx, "picoclaw-tenant-acme", metav1.GetOptions{})
+	require.Error(t, err)
+	rt.reconcile()
+	assert.NotContains(t, rt.store.tenants, "acme")
+}
+
+func TestReconciler_RecordsActivity(t *testing.T) {
+	rt := newReconcilerTest(t)
+	rt.reconcile()
+	rt.setReady("picoclaw-agent", 1)
+	rt.setReady("picoclaw-gateway", 1)
+
+	rt.cluster.health = `{"status":"ok","last_activity":"2026-02-16T11:00:00Z"}`
+	tenant := rt.reconcile()
+	require.NotNil(t, tenant.LastActivityAt)
+	assert.Equal(t, "2026-02-16T11:00:00Z", tenant.LastActivityAt.Format(time.RFC3339))
+
+	// A restarted gateway that has seen no traffic keeps the stored time.
+	rt.cluster.health = `{"status":"ok"}`
+	tenant = rt.reconcile()
+	require.NotNil(t, tenant.LastActivityAt)
+	assert.Equal(t, "2026-02-16T11:00:00Z", tenant.LastActivityAt.Format(time.RFC3339))
+}
+
+func TestReconciler_RecordsUsage(t *testing.T) {
+	rt := newReconcilerTest(t)
+	rt.reconcile()
+	rt.setReady("picoclaw-agent", 1)
+	rt.setReady("picoclaw-gateway", 1)
+	report := func(since string, in, tokens int) {
+		rt.cluster.health = fmt.Sprintf(`{"status":"ok","usage":{"since":%q,"messages_in":%d,"messages_out":%d,"llm_calls":1,"prompt_tokens":%d,"tool_calls":2}}`,
+			since, in, in, tokens)
+		rt.reconcile()
+	}
+
+	report("2026-02-16T09:00:00Z", 3, 100)
+	report("2026-02-16T09:00:00Z", 5, 250)
+	rt.now = rt.now.Add(24 * time.Hour)
+	report("2026-02-16T09:00:00Z", 5, 250) // nothing new
+	// The gateway restarted: its counters start over.
+	report("2026-02-17T09:30:00Z", 2, 40)
+
+	days, err := rt.store.ListUsage("acme", "2026-02-01", "2026-02-28")
+	require.NoError(t, err)
+	assert.Equal(t, []UsageDay{
+		{Day: "2026-02-16", UsageCounters: UsageCounters{MessagesIn: 5, MessagesOut: 5, LLMCalls: 1, PromptTokens: 250, ToolCalls: 2}},
+		{Day: "2026-02-17", UsageCounters: UsageCounters{MessagesIn: 2, MessagesOut: 2, LLMCalls: 1, PromptTokens: 40, ToolCalls: 2}},
+	}, days)
+
+	last, err := rt.store.GetUsageSample("acme")
+	require.NoError(t, err)
+	require.NotNil(t, last)
+	assert.Equal(t, "2026-02-17T09:30:00Z", last.Since.Format(time.RFC3339))
+	assert.Equal(t, rt.now, last.SampledAt)
+}
+
+func TestReconciler_Suspended(t *testing.T) {
+	rt := newReconcilerTest(t)
+	rt.reconcile()
+	rt.setReady("picoclaw-agent", 1)
+	rt.setReady("picoclaw-gateway", 1)
+
+	rt.store.tenants["acme"].Suspended = true
+	tenant := rt.reconcile()
+	assert.Equal(t, StatusSuspended, tenant.Status)
+	assert.Equal(t, "Reapplied", condition(tenant, ConditionApplied).Reason)
+	assert.Equal(t, "Suspended", condition(tenant, ConditionReady).Reason)
+	assert.Equal(t, "Suspended", condition(tenant, ConditionGatewayAvailable).Reason)
+
+	for _, name := range []string{"picoclaw-agent", "picoclaw-gateway"} {
+		dep, err := rt.cluster.cs.AppsV1().Deployments("picoclaw-tenant-acme").Get(context.Background(), name, metav1.GetOptions{})
+		require.NoError(t, err)
+		assert.Equal(t, int32(0), *dep.Spec.Replicas, name)
+	}
+}
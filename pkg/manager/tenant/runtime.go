@@ -0,0 +1,111 @@
+package tenant
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/health"
+)
+
+// Runtime runs the workloads of tenants. KubernetesRuntime deploys each
+// tenant into its own namespace; the local runtime in pkg/manager/local
+// runs each tenant as a picoclaw gateway process on the manager's host.
+type Runtime interface {
+	// Apply creates or updates the tenant's workloads to match its spec.
+	Apply(ctx context.Context, t *Tenant) error
+	// Delete starts removing the tenant's workloads and data. Status
+	// reports Exists until they are gone.
+	Delete(ctx context.Context, t *Tenant) error
+	// Restart restarts every workload of the tenant.
+	Restart(ctx context.Context, t *Tenant) error
+	// Status compares what runs with the tenant's spec, and reports the
+	// gateway's last inbound traffic and usage when it is up.
+	Status(ctx context.Context, t *Tenant) (*RuntimeState, error)
+	// Logs returns the output of one workload.
+	Logs(ctx context.Context, t *Tenant, opts LogOptions) (io.ReadCloser, error)
+	// Events returns the recent events of the tenant's workloads, newest
+	// first.
+	Events(ctx context.Context, t *Tenant) ([]Event, error)
+	// ExportWorkspace writes the tenant's workspace to w as a gzipped tar
+	// archive.
+	ExportWorkspace(ctx context.Context, t *Tenant, w io.Writer) error
+	// ImportWorkspace replaces the tenant's workspace with the contents of
+	// a gzipped tar archive. The tenant's workloads must be stopped first,
+	// by applying it suspended.
+	ImportWorkspace(ctx context.Context, t *Tenant, r io.Reader) error
+	// ResolveVersions fills the fields of v left empty with the runtime's
+	// defaults, and checks that it can deploy the result.
+	ResolveVersions(v Versions) (Versions, error)
+}
+
+// Workload names.
+const (
+	WorkloadAgent   = "agent"
+	WorkloadGateway = "gateway"
+)
+
+// RuntimeState is the observed state of a tenant compared with its spec.
+type RuntimeState struct {
+	Exists    bool     // the tenant's namespace or directory exists
+	Deleting  bool     // it is being removed
+	Missing   []string // resources that should exist but do not
+	Drifted   []string // "<resource>: <what differs>"
+	Workloads []WorkloadState
+	// LastActivity is when the gateway last received an inbound message,
+	// if it is running and has received one since it started.
+	LastActivity *time.Time
+	// Usage is what the gateway counted since it started, if it is
+	// running. SampledAt is left to the caller.
+	Usage *UsageSample
+}
+
+// InSync reports whether every resource exists and matches the spec.
+func (s *RuntimeState) InSync() bool {
+	return len(s.Missing) == 0 && len(s.Drifted) == 0
+}
+
+// WorkloadState is the health of one workload. A runtime reports every
+// workload it runs for a tenant, including those that do not exist yet.
+type WorkloadState struct {
+	Name          string // WorkloadAgent or WorkloadGateway
+	Replicas      int32
+	ReadyReplicas int32
+	Available     bool
+	CrashLooping  bool
+	Stalled       bool   // cannot start, retrying will not help
+	Reason        string // short CamelCase reason when not available
+	Message       string
+}
+
+// LogOptions selects the logs returned by Runtime.Logs.
+type LogOptions struct {
+	Workload  string // defaults to WorkloadGateway
+	TailLines int64  // 0 returns everything
+	Follow    bool   // keep streaming until the context is done
+}
+
+// ParseGatewayHealth extracts the last inbound message time and the usage
+// counters from a gateway's /health response. Gateways older than usage
+// reporting return a nil usage.
+func ParseGatewayHealth(body []byte) (lastActivity *time.Time, usage *UsageSample, err error) {
+	var resp health.StatusResponse
+	if err := json.Unmarshal(body, &resp); err != nil {
+		return nil, nil, err
+	}
+	if u := resp.Usage; u != nil {
+		usage = &UsageSample{
+			Since: u.Since,
+			UsageCounters: UsageCounters{
+				MessagesIn:       u.MessagesIn,
+				MessagesOut:      u.MessagesOut,
+				LLMCalls:         u.LLMCalls,
+				PromptTokens:     u.PromptTokens,
+				CompletionTokens: u.CompletionTokens,
+				ToolCalls:        u.ToolCalls,
+			},
+		}
+	}
+	return resp.LastActivity, usage, nil
+}
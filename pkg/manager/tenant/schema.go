@@ -0,0 +1,221 @@
+package tenant
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+// Schema is the subset of JSON Schema generated from config.Config. The
+// same schema validates tenant config, so the published schema and the
+// checks the manager runs cannot disagree.
+type Schema struct {
+	Schema               string             `json:"$schema,omitempty"`
+	Title                string             `json:"title,omitempty"`
+	Description          string             `json:"description,omitempty"`
+	Type                 interface{}        `json:"type,omitempty"` // a type name or a list of them
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	AdditionalProperties interface{}        `json:"additionalProperties,omitempty"` // false or *Schema
+	Items                *Schema            `json:"items,omitempty"`
+	Enum                 []interface{}      `json:"enum,omitempty"`
+	Default              interface{}        `json:"default,omitempty"`
+	WriteOnly            bool               `json:"writeOnly,omitempty"`
+}
+
+var (
+	configSchemaOnce sync.Once
+	configSchema     *Schema
+)
+
+// ConfigSchema returns the JSON Schema of the picoclaw config. Secret
+// fields are marked writeOnly: they are accepted but never returned.
+func ConfigSchema() *Schema {
+	configSchemaOnce.Do(func() {
+		secret := make(map[string]bool)
+		for _, f := range secretFields {
+			secret[f.String()] = true
+		}
+		s := schemaFor(reflect.TypeOf(config.Config{}), reflect.ValueOf(*config.DefaultConfig()), nil, secret)
+		s.Schema = "https://json-schema.org/draft/2020-12/schema"
+		s.Title = "PicoClaw config"
+		s.Description = "Tenants set providers, agents and channels; the manager owns the rest."
+		configSchema = s
+	})
+	return configSchema
+}
+
+var flexibleStringSliceType = reflect.TypeOf(config.FlexibleStringSlice{})
+
+// schemaFor describes t. def holds the default value from
+// config.DefaultConfig, when there is one, and path the JSON path of t.
+func schemaFor(t reflect.Type, def reflect.Value, path []string, secret map[string]bool) *Schema {
+	if t == flexibleStringSliceType {
+		// Accepts numbers too, e.g. Telegram user IDs.
+		return &Schema{Type: "array", Items: &Schema{Type: []string{"string", "number"}}}
+	}
+	s := &Schema{}
+	switch t.Kind() {
+	case reflect.Ptr:
+		return schemaFor(t.Elem(), reflect.Value{}, path, secret)
+	case reflect.Struct:
+		s.Type = "object"
+		s.AdditionalProperties = false
+		s.Properties = make(map[string]*Schema)
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+			if !f.IsExported() || name == "" || name == "-" {
+				continue
+			}
+			var fieldDef reflect.Value
+			if def.IsValid() {
+				fieldDef = def.Field(i)
+			}
+			s.Properties[name] = schemaFor(f.Type, fieldDef, append(path, name), secret)
+		}
+		return s
+	case reflect.Map:
+		s.Type = "object"
+		s.AdditionalProperties = schemaFor(t.Elem(), reflect.Value{}, append(path, "*"), secret)
+		return s
+	case reflect.Slice, reflect.Array:
+		s.Type = "array"
+		s.Items = schemaFor(t.Elem(), reflect.Value{}, append(path, "*"), secret)
+		return s
+	case reflect.String:
+		s.Type = "string"
+		if name := path[len(path)-1]; name == "provider" {
+			s.Enum = append([]interface{}{""}, providerNames()...)
+		}
+	case reflect.Bool:
+		s.Type = "boolean"
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		s.Type = "integer"
+	case reflect.Float32, reflect.Float64:
+		s.Type = "number"
+	default:
+		return s
+	}
+	s.WriteOnly = secret[strings.Join(path, ".")]
+	if def.IsValid() && !def.IsZero() && !s.WriteOnly {
+		s.Default = def.Interface()
+	}
+	return s
+}
+
+// validate checks v, decoded from JSON, against s and appends an error for
+// every field that does not match.
+func (s *Schema) validate(path string, v interface{}, errs *[]FieldError) {
+	if v == nil {
+		return // null leaves the default in place
+	}
+	if !s.allows(jsonType(v)) {
+		*errs = append(*errs, FieldError{Field: path, Message: fmt.Sprintf("expected %s, got %s", s.typeNames(), jsonType(v))})
+		return
+	}
+	if len(s.Enum) > 0 && !containsValue(s.Enum, v) {
+		*errs = append(*errs, FieldError{Field: path, Message: fmt.Sprintf("unknown value %v", mustJSON(v))})
+		return
+	}
+	switch v := v.(type) {
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			field := joinPath(path, key)
+			if prop, ok := s.Properties[key]; ok {
+				prop.validate(field, v[key], errs)
+			} else if extra, ok := s.AdditionalProperties.(*Schema); ok {
+				extra.validate(field, v[key], errs)
+			} else {
+				*errs = append(*errs, FieldError{Field: field, Message: "unknown field"})
+			}
+		}
+	case []interface{}:
+		if s.Items != nil {
+			for i, item := range v {
+				s.Items.validate(fmt.Sprintf("%s[%d]", path, i), item, errs)
+			}
+		}
+	}
+}
+
+func (s *Schema) types() []string {
+	switch t := s.Type.(type) {
+	case string:
+		return []string{t}
+	case []string:
+		return t
+	}
+	return nil
+}
+
+func (s *Schema) typeNames() string {
+	return strings.Join(s.types(), " or ")
+}
+
+func (s *Schema) allows(got string) bool {
+	types := s.types()
+	if len(types) == 0 {
+		return true
+	}
+	for _, t := range types {
+		if t == got || (t == "number" && got == "integer") {
+			return true
+		}
+	}
+	return false
+}
+
+// jsonType names the JSON Schema type of a value decoded by encoding/json.
+func jsonType(v interface{}) string {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case float64:
+		if v == math.Trunc(v) {
+			return "integer"
+		}
+		return "number"
+	case nil:
+		return "null"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+func containsValue(values []interface{}, v interface{}) bool {
+	for _, candidate := range values {
+		if candidate == v {
+			return true
+		}
+	}
+	return false
+}
+
+func joinPath(path, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func mustJSON(v interface{}) string {
+	data, _ := json.Marshal(v)
+	return string(data)
+}
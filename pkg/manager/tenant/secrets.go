@@ -0,0 +1,177 @@
+package tenant
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+// secretField is a credential in the picoclaw config. The manager keeps it
+// out of the ConfigMap and passes it to the pods through the environment
+// variable picoclaw reads it from.
+type secretField struct {
+	path  []string // JSON path, e.g. providers.anthropic.api_key
+	index []int    // field index path in config.Config
+	env   string
+}
+
+func (f secretField) String() string { return strings.Join(f.path, ".") }
+
+// secretFields lists every credential field of config.Config.
+var secretFields = collectSecretFields(reflect.TypeOf(config.Config{}), nil, nil, "")
+
+// collectSecretFields walks the config structs for string fields that look
+// like credentials and have an env tag, honouring envPrefix on nested
+// structs.
+func collectSecretFields(t reflect.Type, path []string, index []int, prefix string) []secretField {
+	var fields []secretField
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || name == "" || name == "-" {
+			continue
+		}
+		fieldPath := append(append([]string(nil), path...), name)
+		fieldIndex := append(append([]int(nil), index...), i)
+		switch {
+		case f.Type.Kind() == reflect.Struct:
+			fields = append(fields, collectSecretFields(f.Type, fieldPath, fieldIndex, prefix+f.Tag.Get("envPrefix"))...)
+		case f.Type.Kind() == reflect.String && f.Tag.Get("env") != "" && isSecretName(name):
+			fields = append(fields, secretField{path: fieldPath, index: fieldIndex, env: prefix + f.Tag.Get("env")})
+		}
+	}
+	return fields
+}
+
+func isSecretName(name string) bool {
+	return name == "api_key" || name == "encrypt_key" ||
+		strings.HasSuffix(name, "token") || strings.HasSuffix(name, "secret")
+}
+
+// lookupParent returns the object holding the field at path in cfg.
+func lookupParent(cfg map[string]interface{}, path []string) (map[string]interface{}, bool) {
+	obj := cfg
+	for _, key := range path[:len(path)-1] {
+		next, ok := obj[key].(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		obj = next
+	}
+	return obj, true
+}
+
+// splitSecrets removes the credential fields from cfg. It returns the
+// values that were set, keyed by environment variable, and the variables
+// whose field was given as "", which clears a stored secret.
+func splitSecrets(cfg map[string]interface{}) (secrets map[string]string, cleared []string) {
+	secrets = make(map[string]string)
+	for _, f := range secretFields {
+		parent, ok := lookupParent(cfg, f.path)
+		if !ok {
+			continue
+		}
+		key := f.path[len(f.path)-1]
+		value, ok := parent[key].(string)
+		if !ok {
+			continue
+		}
+		delete(parent, key)
+		if value == "" {
+			cleared = append(cleared, f.env)
+		} else {
+			secrets[f.env] = value
+		}
+	}
+	return secrets, cleared
+}
+
+// pruneSecrets drops secrets whose config section was removed, so that
+// deleting a provider or channel also deletes its credentials.
+func pruneSecrets(cfg map[string]interface{}, secrets map[string]string) {
+	for _, f := range secretFields {
+		if _, ok := secrets[f.env]; !ok {
+			continue
+		}
+		if _, ok := lookupParent(cfg, f.path); !ok {
+			delete(secrets, f.env)
+		}
+	}
+}
+
+// SecretPaths returns the config paths of the tenant's stored secrets,
+// sorted, without their values.
+func (t *Tenant) SecretPaths() []string {
+	paths := []string{}
+	for _, f := range secretFields {
+		if _, ok := t.Secrets[f.env]; ok {
+			paths = append(paths, f.String())
+		}
+	}
+	sort.Strings(paths)
+	return paths
+}
+
+// SecretBox encrypts tenant secrets at rest with AES-256-GCM.
+type SecretBox struct {
+	aead cipher.AEAD
+}
+
+// NewSecretBox creates a SecretBox from a base64-encoded 32-byte key.
+func NewSecretBox(encodedKey string) (*SecretBox, error) {
+	key, err := base64.StdEncoding.DecodeString(encodedKey)
+	if err != nil {
+		return nil, fmt.Errorf("decode secrets key: %w", err)
+	}
+	if len(key) != 32 {
+		return nil, fmt.Errorf("secrets key must be 32 bytes, got %d", len(key))
+	}
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &SecretBox{aead: aead}, nil
+}
+
+// Seal encrypts secrets. The random nonce is prepended to the ciphertext.
+func (b *SecretBox) Seal(secrets map[string]string) ([]byte, error) {
+	plaintext, err := json.Marshal(secrets)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, b.aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return b.aead.Seal(nonce, nonce, plaintext, nil), nil
+}
+
+// Open decrypts what Seal produced.
+func (b *SecretBox) Open(sealed []byte) (map[string]string, error) {
+	n := b.aead.NonceSize()
+	if len(sealed) < n {
+		return nil, errors.New("sealed secrets too short")
+	}
+	plaintext, err := b.aead.Open(nil, sealed[:n], sealed[n:], nil)
+	if err != nil {
+		return nil, fmt.Errorf("decrypt secrets: %w", err)
+	}
+	var secrets map[string]string
+	if err := json.Unmarshal(plaintext, &secrets); err != nil {
+		return nil, fmt.Errorf("unmarshal secrets: %w", err)
+	}
+	return secrets, nil
+}
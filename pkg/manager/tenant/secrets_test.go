@@ -0,0 +1,79 @@
+package tenant
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSecretFields(t *testing.T) {
+	envs := make(map[string]string)
+	for _, f := range secretFields {
+		envs[f.String()] = f.env
+	}
+	assert.Equal(t, "PICOCLAW_PROVIDERS_ANTHROPIC_API_KEY", envs["providers.anthropic.api_key"])
+	assert.Equal(t, "PICOCLAW_PROVIDERS_GITHUB_COPILOT_API_KEY", envs["providers.github_copilot.api_key"])
+	assert.Equal(t, "PICOCLAW_CHANNELS_TELEGRAM_TOKEN", envs["channels.telegram.token"])
+	assert.Equal(t, "PICOCLAW_CHANNELS_FEISHU_ENCRYPT_KEY", envs["channels.feishu.encrypt_key"])
+	assert.Equal(t, "PICOCLAW_CHANNELS_SLACK_APP_TOKEN", envs["channels.slack.app_token"])
+	assert.Equal(t, "PICOCLAW_TOOLS_WEB_BRAVE_API_KEY", envs["tools.web.brave.api_key"])
+	assert.NotContains(t, envs, "providers.anthropic.api_base")
+	assert.NotContains(t, envs, "agents.defaults.max_tokens")
+}
+
+func TestSplitSecrets(t *testing.T) {
+	cfg := map[string]interface{}{
+		"providers": map[string]interface{}{
+			"anthropic": map[string]interface{}{"api_key": "sk-ant", "api_base": "https://api.anthropic.com"},
+			"openai":    map[string]interface{}{"api_key": ""},
+		},
+		"channels": map[string]interface{}{
+			"telegram": map[string]interface{}{"enabled": true, "token": "123:abc"},
+		},
+	}
+	secrets, cleared := splitSecrets(cfg)
+
+	assert.Equal(t, map[string]string{
+		"PICOCLAW_PROVIDERS_ANTHROPIC_API_KEY": "sk-ant",
+		"PICOCLAW_CHANNELS_TELEGRAM_TOKEN":     "123:abc",
+	}, secrets)
+	assert.Equal(t, []string{"PICOCLAW_PROVIDERS_OPENAI_API_KEY"}, cleared)
+	assert.Equal(t, map[string]interface{}{
+		"providers": map[string]interface{}{
+			"anthropic": map[string]interface{}{"api_base": "https://api.anthropic.com"},
+			"openai":    map[string]interface{}{},
+		},
+		"channels": map[string]interface{}{
+			"telegram": map[string]interface{}{"enabled": true},
+		},
+	}, cfg)
+
+	// Removing a section drops its secrets.
+	delete(cfg["channels"].(map[string]interface{}), "telegram")
+	pruneSecrets(cfg, secrets)
+	assert.Equal(t, map[string]string{"PICOCLAW_PROVIDERS_ANTHROPIC_API_KEY": "sk-ant"}, secrets)
+}
+
+func TestSecretBox(t *testing.T) {
+	key := base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef"))
+	box, err := NewSecretBox(key)
+	require.NoError(t, err)
+
+	secrets := map[string]string{"PICOCLAW_CHANNELS_TELEGRAM_TOKEN": "123:abc"}
+	sealed, err := box.Seal(secrets)
+	require.NoError(t, err)
+	assert.NotContains(t, string(sealed), "123:abc")
+
+	opened, err := box.Open(sealed)
+	require.NoError(t, err)
+	assert.Equal(t, secrets, opened)
+
+	sealed[len(sealed)-1] ^= 1
+	_, err = box.Open(sealed)
+	assert.Error(t, err, "tampered ciphertext")
+
+	_, err = NewSecretBox(base64.StdEncoding.EncodeToString([]byte("short")))
+	assert.Error(t, err)
+}
@@ -2,32 +2,59 @@ package tenant
 
 import (
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+	"maps"
 	"regexp"
+	"strings"
+	"sync"
 	"time"
 
-	k8sclient "github.com/sipeed/picoclaw/pkg/manager/k8s"
-	"github.com/sipeed/picoclaw/pkg/manager/templates"
+	"k8s.io/apimachinery/pkg/util/validation"
 )
 
 var dnsNameRegex = regexp.MustCompile(`^[a-z0-9]([a-z0-9\-]{0,61}[a-z0-9])?$`)
 
-// Service orchestrates tenant lifecycle operations.
+// Service orchestrates tenant lifecycle operations. Changes run as
+// asynchronous operations, one at a time per tenant.
 type Service struct {
-	store    *Store
-	renderer *templates.Renderer
-	applier  *k8sclient.Applier
-	image    string
+	store            TenantStore
+	runtime          Runtime
+	OperationTimeout time.Duration
+	// HealthTimeout bounds how long an upgraded tenant may take to become
+	// ready.
+	HealthTimeout time.Duration
+	pollInterval  time.Duration
+
+	// Archives stores workspace snapshots. Without it snapshots are
+	// unavailable and tenants are deleted without a final snapshot.
+	Archives ArchiveStore
+
+	mu     sync.Mutex
+	active map[string]string // tenant ID -> running operation ID
+	wg     sync.WaitGroup
+
+	// createMu makes the idempotency and existence checks of a create or
+	// clone atomic with storing its tenant and operation.
+	createMu sync.Mutex
+
+	upgradeMu sync.Mutex
+	upgrade   *upgradeRun // the upgrade this process drives, if any
 }
 
 // NewService creates a tenant service.
-func NewService(store *Store, renderer *templates.Renderer, applier *k8sclient.Applier, image string) *Service {
+func NewService(store TenantStore, runtime Runtime) *Service {
 	return &Service{
-		store:    store,
-		renderer: renderer,
-		applier:  applier,
-		image:    image,
+		store:            store,
+		runtime:          runtime,
+		OperationTimeout: DefaultOperationTimeout,
+		HealthTimeout:    DefaultHealthTimeout,
+		pollInterval:     2 * time.Second,
+		active:           make(map[string]string),
 	}
 }
 
@@ -39,24 +66,56 @@ type CreateRequest struct {
 	Agents      map[string]interface{} `json:"agents"`
 	Channels    map[string]interface{} `json:"channels"`
 	Resources   *Resources             `json:"resources,omitempty"`
+	Labels      map[string]string      `json:"labels,omitempty"`
+
+	// IdempotencyKey makes retrying the same create safe.
+	IdempotencyKey string `json:"-"`
 }
 
-// UpdateRequest holds the parameters for updating a tenant.
+// UpdateRequest holds the parameters for updating a tenant. Labels, when
+// not nil, replace the tenant's.
 type UpdateRequest struct {
 	DisplayName string                 `json:"display_name,omitempty"`
 	Providers   map[string]interface{} `json:"providers,omitempty"`
 	Agents      map[string]interface{} `json:"agents,omitempty"`
 	Channels    map[string]interface{} `json:"channels,omitempty"`
 	Resources   *Resources             `json:"resources,omitempty"`
+	Labels      map[string]string      `json:"labels,omitempty"`
 }
 
-// Create provisions a new tenant: validate → render → apply → store.
-func (s *Service) Create(ctx context.Context, req CreateRequest) (*Tenant, error) {
+// Create validates the request, records the tenant as provisioning and
+// applies it to the runtime in the background. If applying fails, whatever
+// was created is deleted again. A request repeated with the
+// same idempotency key returns the original operation.
+func (s *Service) Create(ctx context.Context, req CreateRequest) (*Operation, error) {
 	if err := validateTenantID(req.TenantID); err != nil {
 		return nil, err
 	}
 	if req.DisplayName == "" {
-		return nil, fmt.Errorf("display_name is required")
+		return nil, invalidf("display_name is required")
+	}
+	if err := validateLabels("labels", req.Labels); err != nil {
+		return nil, err
+	}
+
+	hash, err := requestHash(req)
+	if err != nil {
+		return nil, err
+	}
+
+	s.createMu.Lock()
+	defer s.createMu.Unlock()
+	if req.IdempotencyKey != "" {
+		op, err := s.store.GetOperationByIdempotencyKey(req.IdempotencyKey)
+		if err != nil {
+			return nil, fmt.Errorf("check idempotency key: %w", err)
+		}
+		if op != nil {
+			if op.RequestHash != hash {
+				return nil, conflictf("idempotency key %q was used for a different request", req.IdempotencyKey)
+			}
+			return op, nil
+		}
 	}
 
 	existing, err := s.store.Get(req.TenantID)
@@ -64,18 +123,25 @@ func (s *Service) Create(ctx context.Context, req CreateRequest) (*Tenant, error
 		return nil, fmt.Errorf("check existing: %w", err)
 	}
 	if existing != nil {
-		return nil, fmt.Errorf("tenant %q already exists", req.TenantID)
+		return nil, conflictf("tenant %q already exists", req.TenantID)
 	}
 
-	configJSON, err := buildConfigJSON(req.Providers, req.Agents, req.Channels)
+	configJSON, secrets, err := buildConfigJSON(req.Providers, req.Agents, req.Channels)
 	if err != nil {
-		return nil, fmt.Errorf("build config: %w", err)
+		return nil, invalidf("build config: %v", err)
+	}
+	if err := validateConfig(configJSON, secrets); err != nil {
+		return nil, err
 	}
 
 	resources := DefaultResources()
 	if req.Resources != nil {
 		resources = mergeResources(resources, *req.Resources)
 	}
+	versions, err := s.runtime.ResolveVersions(Versions{})
+	if err != nil {
+		return nil, err
+	}
 
 	now := time.Now()
 	t := &Tenant{
@@ -83,32 +149,45 @@ func (s *Service) Create(ctx context.Context, req CreateRequest) (*Tenant, error
 		DisplayName: req.DisplayName,
 		Namespace:   "picoclaw-tenant-" + req.TenantID,
 		ConfigJSON:  configJSON,
+		Secrets:     secrets,
 		Resources:   resources,
-		Status:      "provisioning",
+		Status:      StatusProvisioning,
+		Labels:      req.Labels,
 		CreatedAt:   now,
 		UpdatedAt:   now,
 	}
+	t.setVersions(versions)
 
-	vars := t.ToVars(s.image)
-	manifests, err := s.renderer.RenderAll(vars)
-	if err != nil {
-		return nil, fmt.Errorf("render templates: %w", err)
+	// Take the operation slot before the tenant is stored, so the
+	// reconciler does not apply the new tenant alongside the operation.
+	op := &Operation{
+		ID:             newOperationID(),
+		TenantID:       t.ID,
+		Type:           OpCreate,
+		IdempotencyKey: req.IdempotencyKey,
+		RequestHash:    hash,
 	}
-
-	if err := s.applier.Apply(ctx, manifests); err != nil {
-		return nil, fmt.Errorf("apply manifests: %w", err)
+	if err := s.reserve(op); err != nil {
+		return nil, err
 	}
-
 	if err := s.store.Create(t); err != nil {
-		// Best-effort cleanup: delete the namespace if DB insert fails.
-		_ = s.applier.DeleteNamespace(ctx, t.Namespace)
+		s.release(t.ID)
 		return nil, fmt.Errorf("store tenant: %w", err)
 	}
 
-	t.Status = "active"
-	_ = s.store.Update(t)
-
-	return t, nil
+	// The reconciler reports the tenant ready once its workloads are up.
+	op, err = s.launch(op, []step{
+		{"apply", func(ctx context.Context) error {
+			return s.runtime.Apply(ctx, t)
+		}},
+	}, func(ctx context.Context) error {
+		return s.abandon(ctx, t)
+	})
+	if err != nil {
+		_ = s.store.Delete(t.ID)
+		return nil, err
+	}
+	return op, nil
 }
 
 // Get retrieves a tenant with optional K8s status enrichment.
@@ -128,15 +207,15 @@ func (s *Service) List(ctx context.Context) ([]Tenant, error) {
 	return s.store.List()
 }
 
-// Update modifies a tenant's config and re-applies manifests.
-func (s *Service) Update(ctx context.Context, id string, req UpdateRequest) (*Tenant, error) {
-	t, err := s.store.Get(id)
+// Update modifies a tenant's config and re-applies it in the background.
+// If applying fails, the previous spec is applied again. A suspended
+// tenant stays suspended.
+func (s *Service) Update(ctx context.Context, id string, req UpdateRequest) (*Operation, error) {
+	t, err := s.getActive(id)
 	if err != nil {
 		return nil, err
 	}
-	if t == nil {
-		return nil, fmt.Errorf("tenant %q not found", id)
-	}
+	previous := *t
 
 	if req.DisplayName != "" {
 		t.DisplayName = req.DisplayName
@@ -157,84 +236,332 @@ func (s *Service) Update(ctx context.Context, id string, req UpdateRequest) (*Te
 		if req.Channels != nil {
 			existing["channels"] = req.Channels
 		}
-		configJSON, err := json.MarshalIndent(existing, "    ", "  ")
+		cfg, secrets, cleared, err := splitConfig(existing)
 		if err != nil {
-			return nil, fmt.Errorf("marshal config: %w", err)
+			return nil, invalidf("build config: %v", err)
+		}
+		configJSON, err := json.MarshalIndent(cfg, "    ", "  ")
+		if err != nil {
+			return nil, invalidf("marshal config: %v", err)
 		}
 		t.ConfigJSON = configJSON
+
+		// Secrets left out of the request are kept; "" clears one.
+		merged := make(map[string]string, len(t.Secrets)+len(secrets))
+		maps.Copy(merged, t.Secrets)
+		maps.Copy(merged, secrets)
+		for _, env := range cleared {
+			delete(merged, env)
+		}
+		pruneSecrets(cfg, merged)
+		t.Secrets = merged
+		if err := validateConfig(t.ConfigJSON, t.Secrets); err != nil {
+			return nil, err
+		}
 	}
 
 	if req.Resources != nil {
 		t.Resources = mergeResources(t.Resources, *req.Resources)
 	}
+	if req.Labels != nil {
+		if err := validateLabels("labels", req.Labels); err != nil {
+			return nil, err
+		}
+		t.Labels = req.Labels
+	}
+	if (req.Providers != nil || req.Agents != nil || req.Channels != nil || req.Resources != nil) && !t.Suspended {
+		// The workloads roll out again.
+		t.Status = StatusProvisioning
+	}
+
+	return s.startOperation(&Operation{ID: newOperationID(), TenantID: id, Type: OpUpdate}, []step{
+		{"apply", func(ctx context.Context) error {
+			return s.runtime.Apply(ctx, t)
+		}},
+		{"save tenant", func(ctx context.Context) error {
+			return s.store.Update(t)
+		}},
+	}, func(ctx context.Context) error {
+		return s.runtime.Apply(ctx, &previous)
+	})
+}
+
+// Delete takes a final snapshot of a tenant's workspace, marks it as
+// deleting, deletes its resources and removes the record once they are
+// gone. If the snapshot fails, the tenant is left as it was.
+func (s *Service) Delete(ctx context.Context, id string, req DeleteRequest) (*Operation, error) {
+	t, err := s.getActive(id)
+	if err != nil {
+		return nil, err
+	}
 
-	vars := t.ToVars(s.image)
-	manifests, err := s.renderer.RenderAll(vars)
+	opID := newOperationID()
+	var steps []step
+	if s.Archives != nil && !req.SkipSnapshot {
+		steps = append(steps, step{"final snapshot", func(ctx context.Context) error {
+			_, err := s.takeSnapshot(ctx, t, opID, SnapshotFinal)
+			return err
+		}})
+	}
+	return s.startOperation(&Operation{ID: opID, TenantID: id, Type: OpDelete}, append(steps, []step{
+		{"mark deleting", func(ctx context.Context) error {
+			return s.store.UpdateStatus(id, StatusDeleting, t.Conditions)
+		}},
+		{"delete resources", func(ctx context.Context) error {
+			return s.runtime.Delete(ctx, t)
+		}},
+		{"wait for removal", func(ctx context.Context) error {
+			return s.waitGone(ctx, t)
+		}},
+		{"remove record", func(ctx context.Context) error {
+			// The reconciler may have removed it already.
+			if current, err := s.store.Get(id); err != nil || current == nil {
+				return err
+			}
+			return s.store.Delete(id)
+		}},
+	}...), nil)
+}
+
+// Restart restarts every workload of a tenant.
+func (s *Service) Restart(ctx context.Context, id string) (*Operation, error) {
+	t, err := s.getActive(id)
 	if err != nil {
-		return nil, fmt.Errorf("render templates: %w", err)
+		return nil, err
 	}
+	if t.Suspended {
+		return nil, conflictf("tenant %q is suspended, resume it instead", id)
+	}
+
+	return s.startOperation(&Operation{ID: newOperationID(), TenantID: id, Type: OpRestart}, []step{
+		{"restart", func(ctx context.Context) error {
+			return s.runtime.Restart(ctx, t)
+		}},
+	}, nil)
+}
 
-	if err := s.applier.Apply(ctx, manifests); err != nil {
-		return nil, fmt.Errorf("apply manifests: %w", err)
+// Suspend scales a tenant's workloads to zero in the background. Its
+// workspace, config and secrets are kept, so Resume brings it back as it
+// was.
+func (s *Service) Suspend(ctx context.Context, id string) (*Operation, error) {
+	t, err := s.getActive(id)
+	if err != nil {
+		return nil, err
+	}
+	if t.Suspended {
+		return nil, conflictf("tenant %q is already suspended", id)
 	}
+	return s.setSuspended(t, OpSuspend, true)
+}
 
-	if err := s.store.Update(t); err != nil {
-		return nil, fmt.Errorf("store update: %w", err)
+// Resume starts the workloads of a suspended tenant again. The idle time
+// counts from the resume.
+func (s *Service) Resume(ctx context.Context, id string) (*Operation, error) {
+	t, err := s.getActive(id)
+	if err != nil {
+		return nil, err
 	}
+	if !t.Suspended {
+		return nil, conflictf("tenant %q is not suspended", id)
+	}
+	return s.setSuspended(t, OpResume, false)
+}
 
-	return t, nil
+func (s *Service) setSuspended(t *Tenant, opType string, suspended bool) (*Operation, error) {
+	previous := *t
+	t.Suspended = suspended
+	if suspended {
+		t.Status = StatusSuspended
+	} else {
+		now := time.Now()
+		t.Status = StatusProvisioning
+		t.LastActivityAt = &now
+	}
+
+	return s.startOperation(&Operation{ID: newOperationID(), TenantID: t.ID, Type: opType}, []step{
+		{"apply", func(ctx context.Context) error {
+			return s.runtime.Apply(ctx, t)
+		}},
+		{"save tenant", func(ctx context.Context) error {
+			return s.store.Update(t)
+		}},
+	}, func(ctx context.Context) error {
+		return s.runtime.Apply(ctx, &previous)
+	})
 }
 
-// Delete removes a tenant and its namespace.
-func (s *Service) Delete(ctx context.Context, id string) error {
-	t, err := s.store.Get(id)
+// SuspendIdle suspends every running tenant without inbound traffic for
+// longer than idleAfter, counted from its last activity or, if it never
+// had any, from its last change. Tenants with an operation in progress are
+// left alone. It returns the suspend operations started.
+func (s *Service) SuspendIdle(ctx context.Context, idleAfter time.Duration) ([]*Operation, error) {
+	tenants, err := s.store.List()
 	if err != nil {
-		return err
+		return nil, err
 	}
-	if t == nil {
-		return fmt.Errorf("tenant %q not found", id)
+	now := time.Now()
+	var ops []*Operation
+	for i := range tenants {
+		t := &tenants[i]
+		if t.Suspended || t.Status == StatusDeleting || s.Busy(t.ID) {
+			continue
+		}
+		since := t.UpdatedAt
+		if t.LastActivityAt != nil && t.LastActivityAt.After(since) {
+			since = *t.LastActivityAt
+		}
+		if now.Sub(since) < idleAfter {
+			continue
+		}
+		op, err := s.Suspend(ctx, t.ID)
+		if errors.Is(err, ErrConflict) {
+			continue // changed since listed
+		}
+		if err != nil {
+			return ops, fmt.Errorf("suspend %s: %w", t.ID, err)
+		}
+		log.Printf("tenant %s: no inbound traffic since %s, suspending", t.ID, since.Format(time.RFC3339))
+		ops = append(ops, op)
 	}
+	return ops, nil
+}
 
-	if err := s.applier.DeleteNamespace(ctx, t.Namespace); err != nil {
-		return fmt.Errorf("delete namespace: %w", err)
+// RunIdleSuspend calls SuspendIdle every interval until ctx is done.
+func (s *Service) RunIdleSuspend(ctx context.Context, idleAfter, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if _, err := s.SuspendIdle(ctx, idleAfter); err != nil {
+			log.Printf("suspend idle tenants: %v", err)
+		}
 	}
+}
 
-	return s.store.Delete(id)
+// MigrateSecrets moves credentials still stored in the config of tenants
+// created before secrets were split out into their secrets. The reconciler
+// then applies the new Secret and ConfigMap.
+func (s *Service) MigrateSecrets(ctx context.Context) error {
+	tenants, err := s.store.List()
+	if err != nil {
+		return err
+	}
+	for i := range tenants {
+		t := &tenants[i]
+		if t.Status == StatusDeleting {
+			continue
+		}
+		var cfg map[string]interface{}
+		if err := json.Unmarshal(t.ConfigJSON, &cfg); err != nil {
+			return fmt.Errorf("tenant %s: unmarshal config: %w", t.ID, err)
+		}
+		secrets, cleared := splitSecrets(cfg)
+		if len(secrets) == 0 && len(cleared) == 0 {
+			continue
+		}
+		configJSON, err := json.MarshalIndent(cfg, "    ", "  ")
+		if err != nil {
+			return fmt.Errorf("tenant %s: marshal config: %w", t.ID, err)
+		}
+		// Secrets stored separately take precedence over leftovers.
+		maps.Copy(secrets, t.Secrets)
+		t.Secrets = secrets
+		t.ConfigJSON = configJSON
+		if err := s.store.Update(t); err != nil {
+			return fmt.Errorf("tenant %s: %w", t.ID, err)
+		}
+		log.Printf("moved %d secrets of tenant %s out of its config", len(secrets), t.ID)
+	}
+	return nil
 }
 
-// Restart triggers a rollout restart for both agent and gateway deployments.
-func (s *Service) Restart(ctx context.Context, id string) error {
+// getActive returns a tenant that exists and is not being deleted.
+func (s *Service) getActive(id string) (*Tenant, error) {
 	t, err := s.store.Get(id)
 	if err != nil {
-		return err
+		return nil, err
 	}
 	if t == nil {
-		return fmt.Errorf("tenant %q not found", id)
+		return nil, notFoundf("tenant %q not found", id)
+	}
+	if t.Status == StatusDeleting {
+		return nil, conflictf("tenant %q is being deleted", id)
 	}
+	return t, nil
+}
 
-	if err := s.applier.RestartDeployment(ctx, t.Namespace, "picoclaw-agent"); err != nil {
-		return fmt.Errorf("restart agent: %w", err)
+// abandon rolls back a failed create: the tenant is marked deleting and
+// its resources deleted, and the reconciler removes the record once they
+// are gone.
+func (s *Service) abandon(ctx context.Context, t *Tenant) error {
+	if err := s.store.UpdateStatus(t.ID, StatusDeleting, t.Conditions); err != nil {
+		return err
+	}
+	return s.runtime.Delete(ctx, t)
+}
+
+func (s *Service) waitGone(ctx context.Context, t *Tenant) error {
+	for {
+		state, err := s.runtime.Status(ctx, t)
+		if err != nil {
+			return err
+		}
+		if !state.Exists {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("resources of tenant %s still exist: %w", t.ID, ctx.Err())
+		case <-time.After(s.pollInterval):
+		}
 	}
-	if err := s.applier.RestartDeployment(ctx, t.Namespace, "picoclaw-gateway"); err != nil {
-		return fmt.Errorf("restart gateway: %w", err)
+}
+
+// requestHash fingerprints a create request to detect a reused idempotency
+// key.
+func requestHash(req CreateRequest) (string, error) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return "", invalidf("encode request: %v", err)
 	}
-	return nil
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
 }
 
 func validateTenantID(id string) error {
 	if id == "" {
-		return fmt.Errorf("tenant_id is required")
+		return invalidf("tenant_id is required")
 	}
 	if len(id) > 53 { // 63 - len("picoclaw-tenant-")
-		return fmt.Errorf("tenant_id too long (max 53 characters)")
+		return invalidf("tenant_id too long (max 53 characters)")
 	}
 	if !dnsNameRegex.MatchString(id) {
-		return fmt.Errorf("tenant_id must be a valid DNS subdomain (lowercase alphanumeric and hyphens)")
+		return invalidf("tenant_id must be a valid DNS subdomain (lowercase alphanumeric and hyphens)")
 	}
 	return nil
 }
 
-func buildConfigJSON(providers, agents, channels map[string]interface{}) (json.RawMessage, error) {
+// validateLabels checks that labels are valid Kubernetes labels, so that
+// selectors work the same way.
+func validateLabels(field string, labels map[string]string) error {
+	for k, v := range labels {
+		if errs := validation.IsQualifiedName(k); len(errs) > 0 {
+			return invalidf("%s: invalid key %q: %s", field, k, strings.Join(errs, "; "))
+		}
+		if errs := validation.IsValidLabelValue(v); len(errs) > 0 {
+			return invalidf("%s: invalid value %q of %s: %s", field, v, k, strings.Join(errs, "; "))
+		}
+	}
+	return nil
+}
+
+// buildConfigJSON assembles a tenant config and splits its credentials off
+// into secrets.
+func buildConfigJSON(providers, agents, channels map[string]interface{}) (json.RawMessage, map[string]string, error) {
 	config := map[string]interface{}{
 		"gateway": map[string]interface{}{
 			"host": "0.0.0.0",
@@ -250,11 +577,30 @@ func buildConfigJSON(providers, agents, channels map[string]interface{}) (json.R
 	if channels != nil {
 		config["channels"] = channels
 	}
-	data, err := json.MarshalIndent(config, "    ", "  ")
+	cfg, secrets, _, err := splitConfig(config)
 	if err != nil {
-		return nil, err
+		return nil, nil, err
+	}
+	data, err := json.MarshalIndent(cfg, "    ", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, secrets, nil
+}
+
+// splitConfig returns a deep copy of cfg without its credentials, along
+// with the secrets and cleared variables found by splitSecrets.
+func splitConfig(cfg map[string]interface{}) (map[string]interface{}, map[string]string, []string, error) {
+	data, err := json.Marshal(cfg)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	var copied map[string]interface{}
+	if err := json.Unmarshal(data, &copied); err != nil {
+		return nil, nil, nil, err
 	}
-	return data, nil
+	secrets, cleared := splitSecrets(copied)
+	return copied, secrets, cleared, nil
 }
 
 func mergeResources(base, override Resources) Resources {
@@ -0,0 +1,436 @@
+package tenant
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/sipeed/picoclaw/pkg/manager/templates"
+)
+
+func newTestService(t *testing.T) (*Service, *MemoryStore, *fakeCluster) {
+	t.Helper()
+	catalog, err := templates.LoadCatalog("../../../k8s/base", "")
+	require.NoError(t, err)
+	store := NewMemoryStore()
+	cluster := &fakeCluster{cs: fake.NewClientset()}
+	svc := NewService(store, NewKubernetesRuntime(catalog, cluster, "picoclaw:test"))
+	svc.pollInterval = 0
+	return svc, store, cluster
+}
+
+// finish waits for the background operations and returns op as stored.
+func finish(t *testing.T, svc *Service, op *Operation) *Operation {
+	t.Helper()
+	svc.Wait()
+	done, err := svc.GetOperation(context.Background(), op.ID)
+	require.NoError(t, err)
+	require.True(t, done.Done(), "operation %s is %s", op.ID, done.Status)
+	return done
+}
+
+func stepStatuses(op *Operation) map[string]string {
+	statuses := make(map[string]string)
+	for _, st := range op.Steps {
+		statuses[st.Name] = st.Status
+	}
+	return statuses
+}
+
+var acmeRequest = CreateRequest{TenantID: "acme", DisplayName: "ACME"}
+
+func TestService_Create(t *testing.T) {
+	svc, store, cluster := newTestService(t)
+	ctx := context.Background()
+
+	op, err := svc.Create(ctx, acmeRequest)
+	require.NoError(t, err)
+	assert.Equal(t, OpCreate, op.Type)
+	assert.Equal(t, OpPending, op.Status)
+	tenant, _ := store.Get("acme")
+	require.NotNil(t, tenant, "the tenant is recorded before the operation runs")
+	assert.Equal(t, StatusProvisioning, tenant.Status)
+
+	op = finish(t, svc, op)
+	assert.Equal(t, OpSucceeded, op.Status)
+	assert.Equal(t, 100, op.Progress())
+	assert.Equal(t, map[string]string{
+		"apply":    OpSucceeded,
+		"rollback": OpSkipped,
+	}, stepStatuses(op))
+	_, err = cluster.cs.CoreV1().Namespaces().Get(ctx, "picoclaw-tenant-acme", metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	_, err = svc.Create(ctx, acmeRequest)
+	assert.True(t, errors.Is(err, ErrConflict), "duplicate create: %v", err)
+	_, err = svc.Create(ctx, CreateRequest{TenantID: "Bad_ID", DisplayName: "x"})
+	assert.True(t, errors.Is(err, ErrInvalid), "invalid id: %v", err)
+}
+
+func TestService_CreateRollsBack(t *testing.T) {
+	svc, store, cluster := newTestService(t)
+	ctx := context.Background()
+	// The namespace gets created, then a later object fails.
+	cluster.applyHook = func([]byte) error {
+		ns := &corev1.Namespace{ObjectMeta: metav1.ObjectMeta{Name: "picoclaw-tenant-acme"}}
+		_, err := cluster.cs.CoreV1().Namespaces().Create(ctx, ns, metav1.CreateOptions{})
+		assert.NoError(t, err)
+		return fmt.Errorf("apply Deployment/picoclaw-agent: quota exceeded")
+	}
+
+	op, err := svc.Create(ctx, acmeRequest)
+	require.NoError(t, err)
+	op = finish(t, svc, op)
+
+	assert.Equal(t, OpFailed, op.Status)
+	assert.Contains(t, op.Error, "apply: apply Deployment/picoclaw-agent: quota exceeded")
+	assert.True(t, op.RolledBack)
+	assert.Equal(t, OpSucceeded, stepStatuses(op)["rollback"])
+	assert.Equal(t, []string{"picoclaw-tenant-acme"}, cluster.deleted)
+
+	// The reconciler removes the record once the namespace is gone.
+	tenant, _ := store.Get("acme")
+	require.NotNil(t, tenant)
+	assert.Equal(t, StatusDeleting, tenant.Status)
+}
+
+// busyCheckStore records whether the tenant's operation slot was taken when
+// the tenant was stored.
+type busyCheckStore struct {
+	*MemoryStore
+	svc  *Service
+	busy bool
+}
+
+func (s *busyCheckStore) Create(t *Tenant) error {
+	s.busy = s.svc.Busy(t.ID)
+	return s.MemoryStore.Create(t)
+}
+
+func TestService_CreateReservesBeforeStore(t *testing.T) {
+	catalog, err := templates.LoadCatalog("../../../k8s/base", "")
+	require.NoError(t, err)
+	store := &busyCheckStore{MemoryStore: NewMemoryStore()}
+	svc := NewService(store, NewKubernetesRuntime(catalog, &fakeCluster{cs: fake.NewClientset()}, "picoclaw:test"))
+	svc.pollInterval = 0
+	store.svc = svc
+
+	op, err := svc.Create(context.Background(), acmeRequest)
+	require.NoError(t, err)
+	assert.True(t, store.busy, "the reconciler must skip the tenant as soon as it is stored")
+	assert.Equal(t, OpSucceeded, finish(t, svc, op).Status)
+}
+
+func TestService_CreateIdempotency(t *testing.T) {
+	svc, _, cluster := newTestService(t)
+	ctx := context.Background()
+
+	req := acmeRequest
+	req.IdempotencyKey = "key-1"
+	first, err := svc.Create(ctx, req)
+	require.NoError(t, err)
+	svc.Wait()
+
+	again, err := svc.Create(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, first.ID, again.ID)
+	assert.Equal(t, OpSucceeded, again.Status)
+	assert.Equal(t, 1, cluster.applied)
+
+	req.DisplayName = "Someone else"
+	_, err = svc.Create(ctx, req)
+	assert.True(t, errors.Is(err, ErrConflict), "reused key: %v", err)
+}
+
+// slowKeyStore widens the window between a create's idempotency lookup and
+// its insert.
+type slowKeyStore struct {
+	*MemoryStore
+}
+
+func (s slowKeyStore) GetOperationByIdempotencyKey(key string) (*Operation, error) {
+	op, err := s.MemoryStore.GetOperationByIdempotencyKey(key)
+	time.Sleep(20 * time.Millisecond)
+	return op, err
+}
+
+func TestService_CreateIdempotencyConcurrent(t *testing.T) {
+	catalog, err := templates.LoadCatalog("../../../k8s/base", "")
+	require.NoError(t, err)
+	cluster := &fakeCluster{cs: fake.NewClientset()}
+	svc := NewService(slowKeyStore{NewMemoryStore()}, NewKubernetesRuntime(catalog, cluster, "picoclaw:test"))
+	svc.pollInterval = 0
+	ctx := context.Background()
+
+	req := acmeRequest
+	req.IdempotencyKey = "key-1"
+	const n = 8
+	ids := make([]string, n)
+	errs := make([]error, n)
+	var wg sync.WaitGroup
+	for i := range n {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			op, err := svc.Create(ctx, req)
+			errs[i] = err
+			if op != nil {
+				ids[i] = op.ID
+			}
+		}()
+	}
+	wg.Wait()
+	svc.Wait()
+
+	for i := range n {
+		require.NoError(t, errs[i])
+		assert.Equal(t, ids[0], ids[i], "every retry gets the original operation")
+	}
+	assert.Equal(t, 1, cluster.applied)
+}
+
+func TestService_UpdateRollsBack(t *testing.T) {
+	svc, store, cluster := newTestService(t)
+	ctx := context.Background()
+	op, err := svc.Create(ctx, acmeRequest)
+	require.NoError(t, err)
+	finish(t, svc, op)
+
+	cluster.applyHook = func(manifests []byte) error {
+		if bytes.Contains(manifests, []byte("memory: 64Gi")) {
+			return fmt.Errorf("exceeds quota")
+		}
+		return nil
+	}
+	op, err = svc.Update(ctx, "acme", UpdateRequest{Resources: &Resources{AgentMemory: "64Gi"}})
+	require.NoError(t, err)
+	op = finish(t, svc, op)
+
+	assert.Equal(t, OpFailed, op.Status)
+	assert.True(t, op.RolledBack)
+	assert.Equal(t, OpSkipped, stepStatuses(op)["save tenant"])
+	assert.Equal(t, 3, cluster.applied, "create, failed update, rollback")
+	tenant, _ := store.Get("acme")
+	assert.Equal(t, "1Gi", tenant.Resources.AgentMemory)
+
+	_, err = svc.Update(ctx, "nobody", UpdateRequest{DisplayName: "x"})
+	assert.True(t, errors.Is(err, ErrNotFound), "missing tenant: %v", err)
+}
+
+func TestService_OneOperationPerTenant(t *testing.T) {
+	svc, _, cluster := newTestService(t)
+	ctx := context.Background()
+
+	release := make(chan struct{})
+	cluster.applyHook = func([]byte) error {
+		<-release
+		return nil
+	}
+	op, err := svc.Create(ctx, acmeRequest)
+	require.NoError(t, err)
+	assert.True(t, svc.Busy("acme"))
+
+	_, err = svc.Restart(ctx, "acme")
+	assert.True(t, errors.Is(err, ErrConflict), "concurrent operation: %v", err)
+
+	close(release)
+	finish(t, svc, op)
+	assert.False(t, svc.Busy("acme"))
+	op, err = svc.Restart(ctx, "acme")
+	require.NoError(t, err)
+	assert.Equal(t, OpSucceeded, finish(t, svc, op).Status)
+}
+
+func TestService_Delete(t *testing.T) {
+	svc, store, _ := newTestService(t)
+	ctx := context.Background()
+	op, err := svc.Create(ctx, acmeRequest)
+	require.NoError(t, err)
+	finish(t, svc, op)
+
+	op, err = svc.Delete(ctx, "acme", DeleteRequest{})
+	require.NoError(t, err)
+	op = finish(t, svc, op)
+	assert.Equal(t, OpSucceeded, op.Status)
+	tenant, _ := store.Get("acme")
+	assert.Nil(t, tenant)
+
+	ops, err := svc.ListOperations(ctx, "acme")
+	require.NoError(t, err)
+	assert.Len(t, ops, 2, "the operation log outlives the tenant")
+}
+
+func TestService_SuspendResume(t *testing.T) {
+	svc, store, cluster := newTestService(t)
+	ctx := context.Background()
+	ns := "picoclaw-tenant-acme"
+	op, err := svc.Create(ctx, acmeRequest)
+	require.NoError(t, err)
+	finish(t, svc, op)
+
+	replicas := func(name string) int32 {
+		dep, err := cluster.cs.AppsV1().Deployments(ns).Get(ctx, name, metav1.GetOptions{})
+		require.NoError(t, err)
+		return *dep.Spec.Replicas
+	}
+
+	op, err = svc.Suspend(ctx, "acme")
+	require.NoError(t, err)
+	assert.Equal(t, OpSuspend, op.Type)
+	assert.Equal(t, OpSucceeded, finish(t, svc, op).Status)
+	assert.Equal(t, int32(0), replicas("picoclaw-agent"))
+	assert.Equal(t, int32(0), replicas("picoclaw-gateway"))
+	_, err = cluster.cs.CoreV1().PersistentVolumeClaims(ns).Get(ctx, "picoclaw-workspace", metav1.GetOptions{})
+	assert.NoError(t, err, "the workspace is kept")
+	tenant, _ := store.Get("acme")
+	assert.True(t, tenant.Suspended)
+	assert.Equal(t, StatusSuspended, tenant.Status)
+
+	_, err = svc.Suspend(ctx, "acme")
+	assert.True(t, errors.Is(err, ErrConflict), "suspend twice: %v", err)
+	_, err = svc.Restart(ctx, "acme")
+	assert.True(t, errors.Is(err, ErrConflict), "restart while suspended: %v", err)
+
+	// Updates apply while suspended and keep the tenant scaled to zero.
+	op, err = svc.Update(ctx, "acme", UpdateRequest{Resources: &Resources{AgentMemory: "2Gi"}})
+	require.NoError(t, err)
+	assert.Equal(t, OpSucceeded, finish(t, svc, op).Status)
+	assert.Equal(t, int32(0), replicas("picoclaw-agent"))
+	tenant, _ = store.Get("acme")
+	assert.Equal(t, StatusSuspended, tenant.Status)
+
+	op, err = svc.Resume(ctx, "acme")
+	require.NoError(t, err)
+	assert.Equal(t, OpSucceeded, finish(t, svc, op).Status)
+	assert.Equal(t, int32(1), replicas("picoclaw-agent"))
+	assert.Equal(t, int32(1), replicas("picoclaw-gateway"))
+	tenant, _ = store.Get("acme")
+	assert.False(t, tenant.Suspended)
+	assert.Equal(t, StatusProvisioning, tenant.Status)
+	require.NotNil(t, tenant.LastActivityAt, "the idle time counts from the resume")
+
+	_, err = svc.Resume(ctx, "acme")
+	assert.True(t, errors.Is(err, ErrConflict), "resume twice: %v", err)
+}
+
+func TestService_SuspendIdle(t *testing.T) {
+	svc, store, _ := newTestService(t)
+	ctx := context.Background()
+	for _, id := range []string{"acme", "stale", "quiet"} {
+		req := acmeRequest
+		req.TenantID = id
+		op, err := svc.Create(ctx, req)
+		require.NoError(t, err)
+		finish(t, svc, op)
+	}
+
+	long := time.Now().Add(-48 * time.Hour)
+	recent := time.Now().Add(-time.Hour)
+	store.tenants["acme"].UpdatedAt = long
+	store.tenants["acme"].LastActivityAt = &recent
+	store.tenants["stale"].UpdatedAt = long
+	store.tenants["stale"].LastActivityAt = &long
+	store.tenants["quiet"].UpdatedAt = long
+
+	ops, err := svc.SuspendIdle(ctx, 24*time.Hour)
+	require.NoError(t, err)
+	require.Len(t, ops, 2)
+	svc.Wait()
+	for id, suspended := range map[string]bool{"acme": false, "stale": true, "quiet": true} {
+		tenant, _ := store.Get(id)
+		assert.Equal(t, suspended, tenant.Suspended, id)
+	}
+
+	// Suspended tenants are not suspended again.
+	ops, err = svc.SuspendIdle(ctx, 24*time.Hour)
+	require.NoError(t, err)
+	assert.Empty(t, ops)
+}
+
+func TestService_Secrets(t *testing.T) {
+	svc, store, cluster := newTestService(t)
+	ctx := context.Background()
+	ns := "picoclaw-tenant-acme"
+
+	req := acmeRequest
+	req.Providers = map[string]interface{}{
+		"anthropic": map[string]interface{}{"api_key": "sk-ant", "api_base": "https://api.anthropic.com"},
+	}
+	req.Channels = map[string]interface{}{
+		"telegram": map[string]interface{}{"enabled": true, "token": "123:abc"},
+	}
+	op, err := svc.Create(ctx, req)
+	require.NoError(t, err)
+	finish(t, svc, op)
+
+	tenant, _ := store.Get("acme")
+	assert.NotContains(t, string(tenant.ConfigJSON), "sk-ant")
+	assert.NotContains(t, string(tenant.ConfigJSON), "123:abc")
+	assert.Equal(t, []string{"channels.telegram.token", "providers.anthropic.api_key"}, tenant.SecretPaths())
+
+	secret, err := cluster.cs.CoreV1().Secrets(ns).Get(ctx, "picoclaw-secrets", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, map[string][]byte{
+		"PICOCLAW_PROVIDERS_ANTHROPIC_API_KEY": []byte("sk-ant"),
+		"PICOCLAW_CHANNELS_TELEGRAM_TOKEN":     []byte("123:abc"),
+	}, secret.Data)
+	cm, err := cluster.cs.CoreV1().ConfigMaps(ns).Get(ctx, "picoclaw-config", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.NotContains(t, cm.Data["config.json"], "sk-ant")
+	dep, err := cluster.cs.AppsV1().Deployments(ns).Get(ctx, "picoclaw-gateway", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, "picoclaw-secrets", dep.Spec.Template.Spec.Containers[0].EnvFrom[0].SecretRef.Name)
+
+	// Omitted secrets are kept, "" clears one, and a removed section takes
+	// its secrets with it.
+	op, err = svc.Update(ctx, "acme", UpdateRequest{
+		Providers: map[string]interface{}{
+			"anthropic": map[string]interface{}{"api_base": "https://proxy.example.com"},
+			"openai":    map[string]interface{}{"api_key": "sk-openai"},
+		},
+		Channels: map[string]interface{}{},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, OpSucceeded, finish(t, svc, op).Status)
+	tenant, _ = store.Get("acme")
+	assert.Equal(t, map[string]string{
+		"PICOCLAW_PROVIDERS_ANTHROPIC_API_KEY": "sk-ant",
+		"PICOCLAW_PROVIDERS_OPENAI_API_KEY":    "sk-openai",
+	}, tenant.Secrets)
+
+	op, err = svc.Update(ctx, "acme", UpdateRequest{
+		Providers: map[string]interface{}{"anthropic": map[string]interface{}{"api_key": ""}},
+	})
+	require.NoError(t, err)
+	finish(t, svc, op)
+	tenant, _ = store.Get("acme")
+	assert.Empty(t, tenant.Secrets)
+	secret, err = cluster.cs.CoreV1().Secrets(ns).Get(ctx, "picoclaw-secrets", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Empty(t, secret.Data)
+}
+
+func TestService_MigrateSecrets(t *testing.T) {
+	svc, store, _ := newTestService(t)
+	store.tenants["legacy"] = &Tenant{
+		ID:         "legacy",
+		ConfigJSON: []byte(`{"providers": {"openai": {"api_key": "sk-old", "api_base": "https://api.openai.com/v1"}}}`),
+		Status:     StatusReady,
+	}
+
+	require.NoError(t, svc.MigrateSecrets(context.Background()))
+	tenant, _ := store.Get("legacy")
+	assert.NotContains(t, string(tenant.ConfigJSON), "sk-old")
+	assert.Contains(t, string(tenant.ConfigJSON), "https://api.openai.com/v1")
+	assert.Equal(t, map[string]string{"PICOCLAW_PROVIDERS_OPENAI_API_KEY": "sk-old"}, tenant.Secrets)
+}
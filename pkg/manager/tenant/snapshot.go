@@ -0,0 +1,305 @@
+package tenant
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"log"
+	"maps"
+	"os"
+	"time"
+)
+
+// Snapshot is an archive of a tenant's workspace: memory, sessions,
+// skills and whatever else the agent keeps there.
+type Snapshot struct {
+	ID          string    `json:"id"`
+	TenantID    string    `json:"tenant_id"`
+	OperationID string    `json:"operation_id"` // the operation that took it
+	Reason      string    `json:"reason"`
+	Key         string    `json:"-"` // archive key in the ArchiveStore
+	SizeBytes   int64     `json:"size_bytes"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// Snapshot reasons.
+const (
+	SnapshotManual = "manual" // requested through the API
+	SnapshotFinal  = "final"  // taken before the tenant was deleted
+	SnapshotClone  = "clone"  // taken to clone the tenant
+)
+
+// ArchiveStore keeps snapshot archives under keys of the form
+// "<tenant>/<snapshot>.tar.gz". The stores in pkg/manager/archive
+// implement it.
+type ArchiveStore interface {
+	Put(ctx context.Context, key string, r io.Reader, size int64) error
+	Get(ctx context.Context, key string) (io.ReadCloser, error)
+}
+
+// CloneRequest holds the parameters for cloning a tenant.
+type CloneRequest struct {
+	TenantID    string `json:"tenant_id"`
+	DisplayName string `json:"display_name"` // defaults to the source's
+	// SnapshotID selects a snapshot of the source tenant to start from.
+	// By default a new one is taken.
+	SnapshotID string `json:"snapshot_id"`
+	// Suspended creates the clone without starting it, e.g. to give it
+	// its own channel credentials first.
+	Suspended bool `json:"suspended"`
+}
+
+// DeleteRequest holds the parameters for deleting a tenant.
+type DeleteRequest struct {
+	// SkipSnapshot deletes the tenant without a final snapshot, e.g. when
+	// its workspace can no longer be read.
+	SkipSnapshot bool
+}
+
+func newSnapshotID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "snap-" + hex.EncodeToString(b)
+}
+
+func (s *Service) checkArchives() error {
+	if s.Archives == nil {
+		return invalidf("snapshot storage is not configured")
+	}
+	return nil
+}
+
+// Snapshot archives a tenant's workspace in the background. The tenant
+// keeps running while it is read.
+func (s *Service) Snapshot(ctx context.Context, id string) (*Operation, error) {
+	if err := s.checkArchives(); err != nil {
+		return nil, err
+	}
+	t, err := s.getActive(id)
+	if err != nil {
+		return nil, err
+	}
+	opID := newOperationID()
+	return s.startOperation(&Operation{ID: opID, TenantID: id, Type: OpSnapshot}, []step{
+		{"snapshot workspace", func(ctx context.Context) error {
+			_, err := s.takeSnapshot(ctx, t, opID, SnapshotManual)
+			return err
+		}},
+	}, nil)
+}
+
+// ListSnapshots returns the snapshots of a tenant, newest first. The
+// snapshots of deleted tenants are listed too.
+func (s *Service) ListSnapshots(ctx context.Context, tenantID string) ([]Snapshot, error) {
+	return s.store.ListSnapshots(tenantID)
+}
+
+// GetSnapshot returns a snapshot by ID.
+func (s *Service) GetSnapshot(ctx context.Context, id string) (*Snapshot, error) {
+	return s.getSnapshot(id)
+}
+
+// Restore replaces a tenant's workspace with a snapshot in the background.
+// The snapshot may be of any tenant, including a deleted one. The
+// workloads are stopped while the workspace is replaced; a suspended
+// tenant stays suspended.
+func (s *Service) Restore(ctx context.Context, id, snapshotID string) (*Operation, error) {
+	if err := s.checkArchives(); err != nil {
+		return nil, err
+	}
+	t, err := s.getActive(id)
+	if err != nil {
+		return nil, err
+	}
+	sn, err := s.getSnapshot(snapshotID)
+	if err != nil {
+		return nil, err
+	}
+	previous := *t
+	stopped := *t
+	stopped.Suspended = true
+	if !t.Suspended {
+		t.Status = StatusProvisioning
+	}
+
+	return s.startOperation(&Operation{ID: newOperationID(), TenantID: id, Type: OpRestore}, []step{
+		{"stop workloads", func(ctx context.Context) error {
+			return s.runtime.Apply(ctx, &stopped)
+		}},
+		{"restore workspace", func(ctx context.Context) error {
+			return s.restoreWorkspace(ctx, t, sn)
+		}},
+		{"start workloads", func(ctx context.Context) error {
+			return s.runtime.Apply(ctx, t)
+		}},
+		{"save tenant", func(ctx context.Context) error {
+			return s.store.Update(t)
+		}},
+	}, func(ctx context.Context) error {
+		return s.runtime.Apply(ctx, &previous)
+	})
+}
+
+// Clone creates a new tenant with the config, secrets and resources of an
+// existing one and a copy of its workspace. The clone is recorded as
+// provisioning at once and set up in the background; if that fails it is
+// deleted again.
+func (s *Service) Clone(ctx context.Context, sourceID string, req CloneRequest) (*Operation, error) {
+	if err := s.checkArchives(); err != nil {
+		return nil, err
+	}
+	if err := validateTenantID(req.TenantID); err != nil {
+		return nil, err
+	}
+	source, err := s.getActive(sourceID)
+	if err != nil {
+		return nil, err
+	}
+	var sn *Snapshot
+	if req.SnapshotID != "" {
+		if sn, err = s.getSnapshot(req.SnapshotID); err != nil {
+			return nil, err
+		}
+		if sn.TenantID != sourceID {
+			return nil, invalidf("snapshot %q is not of tenant %q", sn.ID, sourceID)
+		}
+	}
+
+	s.createMu.Lock()
+	defer s.createMu.Unlock()
+	existing, err := s.store.Get(req.TenantID)
+	if err != nil {
+		return nil, fmt.Errorf("check existing: %w", err)
+	}
+	if existing != nil {
+		return nil, conflictf("tenant %q already exists", req.TenantID)
+	}
+
+	now := time.Now()
+	t := &Tenant{
+		ID:          req.TenantID,
+		DisplayName: req.DisplayName,
+		Namespace:   "picoclaw-tenant-" + req.TenantID,
+		ConfigJSON:  source.ConfigJSON,
+		Secrets:     maps.Clone(source.Secrets),
+		Resources:   source.Resources,
+		Status:      StatusProvisioning,
+		Suspended:   req.Suspended,
+		Labels:      maps.Clone(source.Labels),
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	t.setVersions(source.Versions())
+	if t.DisplayName == "" {
+		t.DisplayName = source.DisplayName
+	}
+	if t.Suspended {
+		t.Status = StatusSuspended
+	}
+	// As in Create, take the operation slot before the clone is stored, so
+	// the reconciler does not start it before its workspace is restored.
+	op := &Operation{ID: newOperationID(), TenantID: t.ID, Type: OpClone}
+	if err := s.reserve(op); err != nil {
+		return nil, err
+	}
+	if err := s.store.Create(t); err != nil {
+		s.release(t.ID)
+		return nil, fmt.Errorf("store tenant: %w", err)
+	}
+	stopped := *t
+	stopped.Suspended = true
+
+	opID := op.ID
+	var steps []step
+	if sn == nil {
+		steps = append(steps, step{"snapshot source", func(ctx context.Context) error {
+			var err error
+			sn, err = s.takeSnapshot(ctx, source, opID, SnapshotClone)
+			return err
+		}})
+	}
+	steps = append(steps,
+		step{"create stopped", func(ctx context.Context) error {
+			return s.runtime.Apply(ctx, &stopped)
+		}},
+		step{"restore workspace", func(ctx context.Context) error {
+			return s.restoreWorkspace(ctx, t, sn)
+		}},
+		step{"start workloads", func(ctx context.Context) error {
+			return s.runtime.Apply(ctx, t)
+		}},
+	)
+	op, err = s.launch(op, steps, func(ctx context.Context) error {
+		return s.abandon(ctx, t)
+	})
+	if err != nil {
+		_ = s.store.Delete(t.ID)
+		return nil, err
+	}
+	return op, nil
+}
+
+func (s *Service) getSnapshot(id string) (*Snapshot, error) {
+	if id == "" {
+		return nil, invalidf("snapshot_id is required")
+	}
+	sn, err := s.store.GetSnapshot(id)
+	if err != nil {
+		return nil, err
+	}
+	if sn == nil {
+		return nil, notFoundf("snapshot %q not found", id)
+	}
+	return sn, nil
+}
+
+// takeSnapshot exports the tenant's workspace to a temporary file, so that
+// its size is known, stores it in the archive store and records it.
+func (s *Service) takeSnapshot(ctx context.Context, t *Tenant, operationID, reason string) (*Snapshot, error) {
+	f, err := os.CreateTemp("", "picoclaw-snapshot-*.tar.gz")
+	if err != nil {
+		return nil, fmt.Errorf("create temporary archive: %w", err)
+	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if err := s.runtime.ExportWorkspace(ctx, t, f); err != nil {
+		return nil, fmt.Errorf("export workspace: %w", err)
+	}
+	size, err := f.Seek(0, io.SeekCurrent)
+	if err == nil {
+		_, err = f.Seek(0, io.SeekStart)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("rewind archive: %w", err)
+	}
+
+	sn := &Snapshot{
+		ID:          newSnapshotID(),
+		TenantID:    t.ID,
+		OperationID: operationID,
+		Reason:      reason,
+		SizeBytes:   size,
+		CreatedAt:   time.Now().UTC(),
+	}
+	sn.Key = t.ID + "/" + sn.ID + ".tar.gz"
+	if err := s.Archives.Put(ctx, sn.Key, f, size); err != nil {
+		return nil, fmt.Errorf("store archive: %w", err)
+	}
+	if err := s.store.CreateSnapshot(sn); err != nil {
+		return nil, fmt.Errorf("record snapshot: %w", err)
+	}
+	log.Printf("tenant %s: stored %s snapshot %s (%d bytes)", t.ID, reason, sn.ID, size)
+	return sn, nil
+}
+
+func (s *Service) restoreWorkspace(ctx context.Context, t *Tenant, sn *Snapshot) error {
+	archive, err := s.Archives.Get(ctx, sn.Key)
+	if err != nil {
+		return fmt.Errorf("read snapshot %s: %w", sn.ID, err)
+	}
+	defer archive.Close()
+	return s.runtime.ImportWorkspace(ctx, t, archive)
+}
@@ -0,0 +1,256 @@
+package tenant
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/sipeed/picoclaw/pkg/manager/templates"
+)
+
+// memArchives is an ArchiveStore in memory.
+type memArchives struct {
+	mu       sync.Mutex
+	archives map[string]string
+}
+
+func (a *memArchives) Put(ctx context.Context, key string, r io.Reader, size int64) error {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if int64(len(data)) != size {
+		return fmt.Errorf("got %d bytes, want %d", len(data), size)
+	}
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.archives[key] = string(data)
+	return nil
+}
+
+func (a *memArchives) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	data, ok := a.archives[key]
+	if !ok {
+		return nil, fmt.Errorf("no archive %s", key)
+	}
+	return io.NopCloser(bytes.NewBufferString(data)), nil
+}
+
+func newSnapshotTest(t *testing.T) (*Service, *MemoryStore, *fakeCluster, *memArchives) {
+	t.Helper()
+	svc, store, cluster := newTestService(t)
+	archives := &memArchives{archives: make(map[string]string)}
+	svc.Archives = archives
+	op, err := svc.Create(context.Background(), acmeRequest)
+	require.NoError(t, err)
+	finish(t, svc, op)
+	cluster.volumes = map[string]string{"picoclaw-tenant-acme": "acme workspace"}
+	return svc, store, cluster, archives
+}
+
+func TestService_SnapshotRestore(t *testing.T) {
+	svc, store, cluster, archives := newSnapshotTest(t)
+	ctx := context.Background()
+
+	op, err := svc.Snapshot(ctx, "acme")
+	require.NoError(t, err)
+	assert.Equal(t, OpSnapshot, op.Type)
+	assert.Equal(t, OpSucceeded, finish(t, svc, op).Status)
+	snapshots, err := svc.ListSnapshots(ctx, "acme")
+	require.NoError(t, err)
+	require.Len(t, snapshots, 1)
+	sn := snapshots[0]
+	assert.Equal(t, SnapshotManual, sn.Reason)
+	assert.Equal(t, op.ID, sn.OperationID)
+	assert.Equal(t, int64(len("acme workspace")), sn.SizeBytes)
+	assert.Equal(t, "acme workspace", archives.archives["acme/"+sn.ID+".tar.gz"])
+
+	cluster.volumes["picoclaw-tenant-acme"] = "changed"
+	op, err = svc.Restore(ctx, "acme", sn.ID)
+	require.NoError(t, err)
+	op = finish(t, svc, op)
+	assert.Equal(t, OpSucceeded, op.Status, op.Error)
+	assert.Equal(t, map[string]string{
+		"stop workloads":    OpSucceeded,
+		"restore workspace": OpSucceeded,
+		"start workloads":   OpSucceeded,
+		"save tenant":       OpSucceeded,
+		"rollback":          OpSkipped,
+	}, stepStatuses(op))
+	assert.Equal(t, "acme workspace", cluster.volumes["picoclaw-tenant-acme"])
+	dep, err := cluster.cs.AppsV1().Deployments("picoclaw-tenant-acme").Get(ctx, "picoclaw-gateway", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), *dep.Spec.Replicas, "the workloads are started again")
+	tenant, _ := store.Get("acme")
+	assert.Equal(t, StatusProvisioning, tenant.Status)
+
+	_, err = svc.Restore(ctx, "acme", "snap-missing")
+	assert.True(t, errors.Is(err, ErrNotFound), "missing snapshot: %v", err)
+	_, err = svc.Restore(ctx, "acme", "")
+	assert.True(t, errors.Is(err, ErrInvalid), "no snapshot: %v", err)
+}
+
+func TestService_RestoreRollsBack(t *testing.T) {
+	svc, _, cluster, _ := newSnapshotTest(t)
+	ctx := context.Background()
+	op, err := svc.Snapshot(ctx, "acme")
+	require.NoError(t, err)
+	finish(t, svc, op)
+	snapshots, _ := svc.ListSnapshots(ctx, "acme")
+
+	cluster.execErr = errors.New("volume unavailable")
+	op, err = svc.Restore(ctx, "acme", snapshots[0].ID)
+	require.NoError(t, err)
+	op = finish(t, svc, op)
+	assert.Equal(t, OpFailed, op.Status)
+	assert.True(t, op.RolledBack)
+	dep, err := cluster.cs.AppsV1().Deployments("picoclaw-tenant-acme").Get(ctx, "picoclaw-gateway", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), *dep.Spec.Replicas, "the previous spec is applied again")
+}
+
+func TestService_Clone(t *testing.T) {
+	svc, store, cluster, _ := newSnapshotTest(t)
+	ctx := context.Background()
+	store.tenants["acme"].Secrets = map[string]string{"PICOCLAW_CHANNELS_TELEGRAM_TOKEN": "123:abc"}
+
+	op, err := svc.Clone(ctx, "acme", CloneRequest{TenantID: "copy", Suspended: true})
+	require.NoError(t, err)
+	assert.Equal(t, OpClone, op.Type)
+	assert.Equal(t, "copy", op.TenantID)
+	op = finish(t, svc, op)
+	assert.Equal(t, OpSucceeded, op.Status, op.Error)
+	assert.Equal(t, []string{"snapshot source", "create stopped", "restore workspace", "start workloads", "rollback"},
+		stepNames(op))
+
+	assert.Equal(t, "acme workspace", cluster.volumes["picoclaw-tenant-copy"])
+	clone, _ := store.Get("copy")
+	require.NotNil(t, clone)
+	assert.Equal(t, "ACME", clone.DisplayName)
+	assert.Equal(t, "picoclaw-tenant-copy", clone.Namespace)
+	assert.Equal(t, map[string]string{"PICOCLAW_CHANNELS_TELEGRAM_TOKEN": "123:abc"}, clone.Secrets)
+	assert.True(t, clone.Suspended)
+	assert.Equal(t, StatusSuspended, clone.Status)
+	snapshots, _ := svc.ListSnapshots(ctx, "acme")
+	require.Len(t, snapshots, 1)
+	assert.Equal(t, SnapshotClone, snapshots[0].Reason)
+
+	// Cloning from an existing snapshot skips taking one.
+	op, err = svc.Clone(ctx, "acme", CloneRequest{TenantID: "second", SnapshotID: snapshots[0].ID})
+	require.NoError(t, err)
+	op = finish(t, svc, op)
+	assert.Equal(t, OpSucceeded, op.Status, op.Error)
+	assert.NotContains(t, stepNames(op), "snapshot source")
+	dep, err := cluster.cs.AppsV1().Deployments("picoclaw-tenant-second").Get(ctx, "picoclaw-gateway", metav1.GetOptions{})
+	require.NoError(t, err)
+	assert.Equal(t, int32(1), *dep.Spec.Replicas)
+
+	_, err = svc.Clone(ctx, "acme", CloneRequest{TenantID: "copy"})
+	assert.True(t, errors.Is(err, ErrConflict), "existing tenant: %v", err)
+	_, err = svc.Clone(ctx, "copy", CloneRequest{TenantID: "third", SnapshotID: snapshots[0].ID})
+	assert.True(t, errors.Is(err, ErrInvalid), "snapshot of another tenant: %v", err)
+
+	// A failed clone is removed again.
+	cluster.execErr = errors.New("volume unavailable")
+	op, err = svc.Clone(ctx, "acme", CloneRequest{TenantID: "broken"})
+	require.NoError(t, err)
+	op = finish(t, svc, op)
+	assert.Equal(t, OpFailed, op.Status)
+	assert.True(t, op.RolledBack)
+	broken, _ := store.Get("broken")
+	require.NotNil(t, broken)
+	assert.Equal(t, StatusDeleting, broken.Status, "the reconciler removes the record")
+}
+
+func TestService_CloneReservesBeforeStore(t *testing.T) {
+	catalog, err := templates.LoadCatalog("../../../k8s/base", "")
+	require.NoError(t, err)
+	store := &busyCheckStore{MemoryStore: NewMemoryStore()}
+	svc := NewService(store, NewKubernetesRuntime(catalog, &fakeCluster{cs: fake.NewClientset()}, "picoclaw:test"))
+	svc.pollInterval = 0
+	svc.Archives = &memArchives{archives: make(map[string]string)}
+	store.svc = svc
+	ctx := context.Background()
+	op, err := svc.Create(ctx, acmeRequest)
+	require.NoError(t, err)
+	finish(t, svc, op)
+
+	store.busy = false
+	op, err = svc.Clone(ctx, "acme", CloneRequest{TenantID: "copy"})
+	require.NoError(t, err)
+	assert.True(t, store.busy, "the reconciler must skip the clone as soon as it is stored")
+	finish(t, svc, op)
+}
+
+func TestService_DeleteTakesFinalSnapshot(t *testing.T) {
+	svc, store, cluster, archives := newSnapshotTest(t)
+	ctx := context.Background()
+
+	// Without a final snapshot the tenant is kept.
+	cluster.execErr = errors.New("volume unavailable")
+	op, err := svc.Delete(ctx, "acme", DeleteRequest{})
+	require.NoError(t, err)
+	op = finish(t, svc, op)
+	assert.Equal(t, OpFailed, op.Status)
+	assert.Equal(t, OpSkipped, stepStatuses(op)["delete resources"])
+	tenant, _ := store.Get("acme")
+	require.NotNil(t, tenant)
+	assert.NotEqual(t, StatusDeleting, tenant.Status)
+
+	cluster.execErr = nil
+	op, err = svc.Delete(ctx, "acme", DeleteRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, OpSucceeded, finish(t, svc, op).Status)
+	tenant, _ = store.Get("acme")
+	assert.Nil(t, tenant)
+
+	snapshots, err := svc.ListSnapshots(ctx, "acme")
+	require.NoError(t, err)
+	require.Len(t, snapshots, 1, "snapshots outlive the tenant")
+	assert.Equal(t, SnapshotFinal, snapshots[0].Reason)
+	assert.Equal(t, "acme workspace", archives.archives[snapshots[0].Key])
+
+	// The final snapshot restores into another tenant.
+	req := acmeRequest
+	req.TenantID = "revived"
+	op, err = svc.Create(ctx, req)
+	require.NoError(t, err)
+	finish(t, svc, op)
+	op, err = svc.Restore(ctx, "revived", snapshots[0].ID)
+	require.NoError(t, err)
+	assert.Equal(t, OpSucceeded, finish(t, svc, op).Status)
+	assert.Equal(t, "acme workspace", cluster.volumes["picoclaw-tenant-revived"])
+}
+
+func TestService_SnapshotsNotConfigured(t *testing.T) {
+	svc, _, _ := newTestService(t)
+	ctx := context.Background()
+	op, err := svc.Create(ctx, acmeRequest)
+	require.NoError(t, err)
+	finish(t, svc, op)
+
+	_, err = svc.Snapshot(ctx, "acme")
+	assert.True(t, errors.Is(err, ErrInvalid), "snapshot: %v", err)
+	op, err = svc.Delete(ctx, "acme", DeleteRequest{})
+	require.NoError(t, err)
+	assert.NotContains(t, stepNames(finish(t, svc, op)), "final snapshot")
+}
+
+func stepNames(op *Operation) []string {
+	var names []string
+	for _, st := range op.Steps {
+		names = append(names, st.Name)
+	}
+	return names
+}
@@ -4,12 +4,14 @@ import (
 	"database/sql"
 	"encoding/json"
 	"fmt"
+	"strings"
 	"time"
 
 	_ "github.com/lib/pq"
+	_ "modernc.org/sqlite"
 )
 
-const createTableSQL = `
+const postgresSchema = `
 CREATE TABLE IF NOT EXISTS tenants (
     id           TEXT PRIMARY KEY,
     display_name TEXT NOT NULL,
@@ -19,19 +21,247 @@ CREATE TABLE IF NOT EXISTS tenants (
     status       TEXT NOT NULL DEFAULT 'provisioning',
     created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW(),
     updated_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS conditions JSONB NOT NULL DEFAULT '[]';
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS secrets BYTEA;
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS suspended BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS last_activity_at TIMESTAMPTZ;
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS image TEXT NOT NULL DEFAULT '';
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS template_version TEXT NOT NULL DEFAULT '';
+ALTER TABLE tenants ADD COLUMN IF NOT EXISTS labels JSONB NOT NULL DEFAULT '{}';
+
+CREATE TABLE IF NOT EXISTS operations (
+    id              TEXT PRIMARY KEY,
+    tenant_id       TEXT NOT NULL,
+    type            TEXT NOT NULL,
+    status          TEXT NOT NULL,
+    steps           JSONB NOT NULL,
+    error           TEXT NOT NULL DEFAULT '',
+    rolled_back     BOOLEAN NOT NULL DEFAULT FALSE,
+    idempotency_key TEXT UNIQUE,
+    request_hash    TEXT NOT NULL DEFAULT '',
+    created_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at      TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    finished_at     TIMESTAMPTZ
+);
+CREATE INDEX IF NOT EXISTS operations_tenant_idx ON operations (tenant_id, created_at);
+
+CREATE TABLE IF NOT EXISTS snapshots (
+    id           TEXT PRIMARY KEY,
+    tenant_id    TEXT NOT NULL,
+    operation_id TEXT NOT NULL,
+    reason       TEXT NOT NULL,
+    archive_key  TEXT NOT NULL,
+    size_bytes   BIGINT NOT NULL,
+    created_at   TIMESTAMPTZ NOT NULL DEFAULT NOW()
+);
+CREATE INDEX IF NOT EXISTS snapshots_tenant_idx ON snapshots (tenant_id, created_at);
+
+CREATE TABLE IF NOT EXISTS upgrades (
+    id                 TEXT PRIMARY KEY,
+    status             TEXT NOT NULL,
+    target             JSONB NOT NULL,
+    selector           JSONB NOT NULL,
+    canary             JSONB NOT NULL,
+    batch_size         INTEGER NOT NULL,
+    pause_after_canary BOOLEAN NOT NULL DEFAULT FALSE,
+    tenants            JSONB NOT NULL,
+    error              TEXT NOT NULL DEFAULT '',
+    created_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    updated_at         TIMESTAMPTZ NOT NULL DEFAULT NOW(),
+    finished_at        TIMESTAMPTZ
+);
+
+CREATE TABLE IF NOT EXISTS usage_samples (
+    tenant_id         TEXT PRIMARY KEY,
+    since             TIMESTAMPTZ NOT NULL,
+    sampled_at        TIMESTAMPTZ NOT NULL,
+    messages_in       BIGINT NOT NULL,
+    messages_out      BIGINT NOT NULL,
+    llm_calls         BIGINT NOT NULL,
+    prompt_tokens     BIGINT NOT NULL,
+    completion_tokens BIGINT NOT NULL,
+    tool_calls        BIGINT NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS usage_daily (
+    tenant_id         TEXT NOT NULL,
+    day               TEXT NOT NULL,
+    messages_in       BIGINT NOT NULL,
+    messages_out      BIGINT NOT NULL,
+    llm_calls         BIGINT NOT NULL,
+    prompt_tokens     BIGINT NOT NULL,
+    completion_tokens BIGINT NOT NULL,
+    tool_calls        BIGINT NOT NULL,
+    PRIMARY KEY (tenant_id, day)
 );`
 
-// Store provides PostgreSQL persistence for tenants.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS tenants (
+    id           TEXT PRIMARY KEY,
+    display_name TEXT NOT NULL,
+    namespace    TEXT NOT NULL UNIQUE,
+    config_json  TEXT NOT NULL,
+    resources    TEXT NOT NULL,
+    status       TEXT NOT NULL DEFAULT 'provisioning',
+    conditions   TEXT NOT NULL DEFAULT '[]',
+    secrets      BLOB,
+    created_at   TIMESTAMP NOT NULL,
+    updated_at   TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS operations (
+    id              TEXT PRIMARY KEY,
+    tenant_id       TEXT NOT NULL,
+    type            TEXT NOT NULL,
+    status          TEXT NOT NULL,
+    steps           TEXT NOT NULL,
+    error           TEXT NOT NULL DEFAULT '',
+    rolled_back     BOOLEAN NOT NULL DEFAULT FALSE,
+    idempotency_key TEXT UNIQUE,
+    request_hash    TEXT NOT NULL DEFAULT '',
+    created_at      TIMESTAMP NOT NULL,
+    updated_at      TIMESTAMP NOT NULL,
+    finished_at     TIMESTAMP
+);
+CREATE INDEX IF NOT EXISTS operations_tenant_idx ON operations (tenant_id, created_at);
+
+CREATE TABLE IF NOT EXISTS snapshots (
+    id           TEXT PRIMARY KEY,
+    tenant_id    TEXT NOT NULL,
+    operation_id TEXT NOT NULL,
+    reason       TEXT NOT NULL,
+    archive_key  TEXT NOT NULL,
+    size_bytes   INTEGER NOT NULL,
+    created_at   TIMESTAMP NOT NULL
+);
+CREATE INDEX IF NOT EXISTS snapshots_tenant_idx ON snapshots (tenant_id, created_at);
+
+CREATE TABLE IF NOT EXISTS upgrades (
+    id                 TEXT PRIMARY KEY,
+    status             TEXT NOT NULL,
+    target             TEXT NOT NULL,
+    selector           TEXT NOT NULL,
+    canary             TEXT NOT NULL,
+    batch_size         INTEGER NOT NULL,
+    pause_after_canary BOOLEAN NOT NULL DEFAULT FALSE,
+    tenants            TEXT NOT NULL,
+    error              TEXT NOT NULL DEFAULT '',
+    created_at         TIMESTAMP NOT NULL,
+    updated_at         TIMESTAMP NOT NULL,
+    finished_at        TIMESTAMP
+);
+
+CREATE TABLE IF NOT EXISTS usage_samples (
+    tenant_id         TEXT PRIMARY KEY,
+    since             TIMESTAMP NOT NULL,
+    sampled_at        TIMESTAMP NOT NULL,
+    messages_in       INTEGER NOT NULL,
+    messages_out      INTEGER NOT NULL,
+    llm_calls         INTEGER NOT NULL,
+    prompt_tokens     INTEGER NOT NULL,
+    completion_tokens INTEGER NOT NULL,
+    tool_calls        INTEGER NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS usage_daily (
+    tenant_id         TEXT NOT NULL,
+    day               TEXT NOT NULL,
+    messages_in       INTEGER NOT NULL,
+    messages_out      INTEGER NOT NULL,
+    llm_calls         INTEGER NOT NULL,
+    prompt_tokens     INTEGER NOT NULL,
+    completion_tokens INTEGER NOT NULL,
+    tool_calls        INTEGER NOT NULL,
+    PRIMARY KEY (tenant_id, day)
+);`
+
+// sqliteMigrations add the columns introduced after sqliteSchema to
+// existing databases. SQLite has no ADD COLUMN IF NOT EXISTS, so
+// duplicate column errors are ignored.
+var sqliteMigrations = []string{
+	`ALTER TABLE tenants ADD COLUMN suspended BOOLEAN NOT NULL DEFAULT FALSE`,
+	`ALTER TABLE tenants ADD COLUMN last_activity_at TIMESTAMP`,
+	`ALTER TABLE tenants ADD COLUMN image TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE tenants ADD COLUMN template_version TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE tenants ADD COLUMN labels TEXT NOT NULL DEFAULT '{}'`,
+}
+
+// TenantStore persists tenants, their operations and snapshots, and
+// upgrades. *Store and *MemoryStore implement it.
+type TenantStore interface {
+	StatusStore
+	Create(t *Tenant) error
+	Get(id string) (*Tenant, error)
+	Update(t *Tenant) error
+
+	CreateOperation(op *Operation) error
+	UpdateOperation(op *Operation) error
+	GetOperation(id string) (*Operation, error)
+	GetOperationByIdempotencyKey(key string) (*Operation, error)
+	ListOperations(tenantID string) ([]Operation, error)
+	FailInterrupted() (int64, error)
+
+	CreateSnapshot(sn *Snapshot) error
+	GetSnapshot(id string) (*Snapshot, error)
+	ListSnapshots(tenantID string) ([]Snapshot, error)
+
+	CreateUpgrade(u *Upgrade) error
+	UpdateUpgrade(u *Upgrade) error
+	GetUpgrade(id string) (*Upgrade, error)
+	ListUpgrades() ([]Upgrade, error)
+	PauseInterruptedUpgrades() (int64, error)
+
+	GetUsageSample(tenantID string) (*UsageSample, error)
+	ListUsage(tenantID, fromDay, toDay string) ([]UsageDay, error)
+}
+
+// Store provides PostgreSQL or SQLite persistence for tenants. Tenant
+// secrets are encrypted with box before they are written.
 type Store struct {
-	db *sql.DB
+	db  *sql.DB
+	box *SecretBox
+}
+
+// NewStore creates a Store on a PostgreSQL database and initializes the
+// schema.
+func NewStore(db *sql.DB, box *SecretBox) (*Store, error) {
+	return newStore(db, box, postgresSchema)
+}
+
+// NewSQLiteStore creates a Store on a SQLite database opened with the
+// "sqlite" driver and initializes the schema. SQLite allows one writer at
+// a time, so db is limited to a single connection.
+func NewSQLiteStore(db *sql.DB, box *SecretBox) (*Store, error) {
+	db.SetMaxOpenConns(1)
+	s, err := newStore(db, box, sqliteSchema)
+	if err != nil {
+		return nil, err
+	}
+	for _, m := range sqliteMigrations {
+		if _, err := db.Exec(m); err != nil && !strings.Contains(err.Error(), "duplicate column") {
+			return nil, fmt.Errorf("migrate schema: %w", err)
+		}
+	}
+	return s, nil
 }
 
-// NewStore creates a new Store and initializes the schema.
-func NewStore(db *sql.DB) (*Store, error) {
-	if _, err := db.Exec(createTableSQL); err != nil {
+func newStore(db *sql.DB, box *SecretBox, schema string) (*Store, error) {
+	if _, err := db.Exec(schema); err != nil {
 		return nil, fmt.Errorf("init schema: %w", err)
 	}
-	return &Store{db: db}, nil
+	return &Store{db: db, box: box}, nil
+}
+
+func (s *Store) sealSecrets(secrets map[string]string) ([]byte, error) {
+	if len(secrets) == 0 {
+		return nil, nil
+	}
+	sealed, err := s.box.Seal(secrets)
+	if err != nil {
+		return nil, fmt.Errorf("encrypt secrets: %w", err)
+	}
+	return sealed, nil
 }
 
 // Create inserts a new tenant record.
@@ -40,10 +270,20 @@ func (s *Store) Create(t *Tenant) error {
 	if err != nil {
 		return fmt.Errorf("marshal resources: %w", err)
 	}
+	labelsJSON, err := marshalLabels(t.Labels)
+	if err != nil {
+		return err
+	}
+	secrets, err := s.sealSecrets(t.Secrets)
+	if err != nil {
+		return err
+	}
 	_, err = s.db.Exec(`
-		INSERT INTO tenants (id, display_name, namespace, config_json, resources, status, created_at, updated_at)
-		VALUES ($1, $2, $3, $4, $5, $6, $7, $8)`,
-		t.ID, t.DisplayName, t.Namespace, t.ConfigJSON, resourcesJSON, t.Status, t.CreatedAt, t.UpdatedAt,
+		INSERT INTO tenants (id, display_name, namespace, config_json, secrets, resources, status, suspended, last_activity_at,
+			image, template_version, labels, created_at, updated_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14)`,
+		t.ID, t.DisplayName, t.Namespace, t.ConfigJSON, secrets, resourcesJSON, t.Status, t.Suspended, t.LastActivityAt,
+		t.Image, t.TemplateVersion, labelsJSON, t.CreatedAt, t.UpdatedAt,
 	)
 	if err != nil {
 		return fmt.Errorf("insert tenant: %w", err)
@@ -51,31 +291,35 @@ func (s *Store) Create(t *Tenant) error {
 	return nil
 }
 
+const tenantColumns = `id, display_name, namespace, config_json, secrets, resources, status, conditions,
+	suspended, last_activity_at, image, template_version, labels, created_at, updated_at`
+
+func marshalLabels(labels map[string]string) ([]byte, error) {
+	if labels == nil {
+		labels = map[string]string{}
+	}
+	data, err := json.Marshal(labels)
+	if err != nil {
+		return nil, fmt.Errorf("marshal labels: %w", err)
+	}
+	return data, nil
+}
+
 // Get retrieves a tenant by ID.
 func (s *Store) Get(id string) (*Tenant, error) {
-	t := &Tenant{}
-	var resourcesJSON []byte
-	err := s.db.QueryRow(`
-		SELECT id, display_name, namespace, config_json, resources, status, created_at, updated_at
-		FROM tenants WHERE id = $1`, id,
-	).Scan(&t.ID, &t.DisplayName, &t.Namespace, &t.ConfigJSON, &resourcesJSON, &t.Status, &t.CreatedAt, &t.UpdatedAt)
+	t, err := s.scanTenant(s.db.QueryRow(`SELECT `+tenantColumns+` FROM tenants WHERE id = $1`, id))
 	if err == sql.ErrNoRows {
 		return nil, nil
 	}
 	if err != nil {
 		return nil, fmt.Errorf("get tenant: %w", err)
 	}
-	if err := json.Unmarshal(resourcesJSON, &t.Resources); err != nil {
-		return nil, fmt.Errorf("unmarshal resources: %w", err)
-	}
 	return t, nil
 }
 
 // List returns all tenants.
 func (s *Store) List() ([]Tenant, error) {
-	rows, err := s.db.Query(`
-		SELECT id, display_name, namespace, config_json, resources, status, created_at, updated_at
-		FROM tenants ORDER BY created_at`)
+	rows, err := s.db.Query(`SELECT ` + tenantColumns + ` FROM tenants ORDER BY created_at`)
 	if err != nil {
 		return nil, fmt.Errorf("list tenants: %w", err)
 	}
@@ -83,41 +327,221 @@ func (s *Store) List() ([]Tenant, error) {
 
 	var tenants []Tenant
 	for rows.Next() {
-		var t Tenant
-		var resourcesJSON []byte
-		if err := rows.Scan(&t.ID, &t.DisplayName, &t.Namespace, &t.ConfigJSON, &resourcesJSON, &t.Status, &t.CreatedAt, &t.UpdatedAt); err != nil {
+		t, err := s.scanTenant(rows)
+		if err != nil {
 			return nil, fmt.Errorf("scan tenant: %w", err)
 		}
-		if err := json.Unmarshal(resourcesJSON, &t.Resources); err != nil {
-			return nil, fmt.Errorf("unmarshal resources: %w", err)
-		}
-		tenants = append(tenants, t)
+		tenants = append(tenants, *t)
 	}
 	return tenants, rows.Err()
 }
 
-// Update modifies an existing tenant's config, resources, and status.
+func (s *Store) scanTenant(row rowScanner) (*Tenant, error) {
+	t := &Tenant{}
+	var secrets, resourcesJSON, conditionsJSON, labelsJSON []byte
+	err := row.Scan(&t.ID, &t.DisplayName, &t.Namespace, &t.ConfigJSON, &secrets, &resourcesJSON, &t.Status, &conditionsJSON,
+		&t.Suspended, &t.LastActivityAt, &t.Image, &t.TemplateVersion, &labelsJSON, &t.CreatedAt, &t.UpdatedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(labelsJSON, &t.Labels); err != nil {
+		return nil, fmt.Errorf("unmarshal labels: %w", err)
+	}
+	if len(t.Labels) == 0 {
+		t.Labels = nil
+	}
+	if err := json.Unmarshal(resourcesJSON, &t.Resources); err != nil {
+		return nil, fmt.Errorf("unmarshal resources: %w", err)
+	}
+	if err := json.Unmarshal(conditionsJSON, &t.Conditions); err != nil {
+		return nil, fmt.Errorf("unmarshal conditions: %w", err)
+	}
+	if len(secrets) > 0 {
+		if t.Secrets, err = s.box.Open(secrets); err != nil {
+			return nil, fmt.Errorf("tenant %s: %w", t.ID, err)
+		}
+	}
+	return t, nil
+}
+
+// Update modifies an existing tenant's config, secrets, resources,
+// suspension, versions, labels and status. Tenants being deleted cannot be
+// updated.
 func (s *Store) Update(t *Tenant) error {
 	resourcesJSON, err := json.Marshal(t.Resources)
 	if err != nil {
 		return fmt.Errorf("marshal resources: %w", err)
 	}
+	labelsJSON, err := marshalLabels(t.Labels)
+	if err != nil {
+		return err
+	}
+	secrets, err := s.sealSecrets(t.Secrets)
+	if err != nil {
+		return err
+	}
 	t.UpdatedAt = time.Now()
 	res, err := s.db.Exec(`
-		UPDATE tenants SET display_name=$1, config_json=$2, resources=$3, status=$4, updated_at=$5
-		WHERE id=$6`,
-		t.DisplayName, t.ConfigJSON, resourcesJSON, t.Status, t.UpdatedAt, t.ID,
+		UPDATE tenants SET display_name=$1, config_json=$2, secrets=$3, resources=$4, status=$5, suspended=$6,
+			last_activity_at=$7, image=$8, template_version=$9, labels=$10, updated_at=$11
+		WHERE id=$12 AND status <> 'deleting'`,
+		t.DisplayName, t.ConfigJSON, secrets, resourcesJSON, t.Status, t.Suspended, t.LastActivityAt,
+		t.Image, t.TemplateVersion, labelsJSON, t.UpdatedAt, t.ID,
 	)
 	if err != nil {
 		return fmt.Errorf("update tenant: %w", err)
 	}
 	n, _ := res.RowsAffected()
 	if n == 0 {
-		return fmt.Errorf("tenant %q not found", t.ID)
+		return fmt.Errorf("tenant %q not found or being deleted", t.ID)
+	}
+	return nil
+}
+
+// UpdateStatus sets a tenant's status and conditions without touching its
+// spec, so that it cannot overwrite a concurrent Update. A tenant being
+// deleted stays deleting.
+func (s *Store) UpdateStatus(id, status string, conditions []Condition) error {
+	if conditions == nil {
+		conditions = []Condition{}
+	}
+	conditionsJSON, err := json.Marshal(conditions)
+	if err != nil {
+		return fmt.Errorf("marshal conditions: %w", err)
+	}
+	res, err := s.db.Exec(`
+		UPDATE tenants SET status=$1, conditions=$2
+		WHERE id=$3 AND (status <> 'deleting' OR $1 = 'deleting')`,
+		status, conditionsJSON, id,
+	)
+	if err != nil {
+		return fmt.Errorf("update tenant status: %w", err)
+	}
+	n, _ := res.RowsAffected()
+	if n == 0 {
+		return fmt.Errorf("tenant %q not found or being deleted", id)
+	}
+	return nil
+}
+
+// RecordActivity moves a tenant's last activity forward to at. Older
+// times are ignored.
+func (s *Store) RecordActivity(id string, at time.Time) error {
+	_, err := s.db.Exec(`
+		UPDATE tenants SET last_activity_at=$1
+		WHERE id=$2 AND (last_activity_at IS NULL OR last_activity_at < $1)`,
+		at, id,
+	)
+	if err != nil {
+		return fmt.Errorf("record tenant activity: %w", err)
+	}
+	return nil
+}
+
+// RecordUsage adds what a gateway counted since the last sample to the
+// usage of the sample's day, and keeps the sample for the next one.
+func (s *Store) RecordUsage(id string, sample UsageSample) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("record usage: %w", err)
+	}
+	defer tx.Rollback()
+
+	last, err := scanUsageSample(tx.QueryRow(`SELECT `+usageSampleColumns+` FROM usage_samples WHERE tenant_id = $1`, id))
+	if err == sql.ErrNoRows {
+		last, err = nil, nil
+	}
+	if err != nil {
+		return fmt.Errorf("get usage sample: %w", err)
+	}
+	if delta := usageDelta(last, sample); delta != (UsageCounters{}) {
+		_, err = tx.Exec(`
+			INSERT INTO usage_daily (tenant_id, day, messages_in, messages_out, llm_calls, prompt_tokens, completion_tokens, tool_calls)
+			VALUES ($1, $2, $3, $4, $5, $6, $7, $8)
+			ON CONFLICT (tenant_id, day) DO UPDATE SET
+				messages_in = usage_daily.messages_in + excluded.messages_in,
+				messages_out = usage_daily.messages_out + excluded.messages_out,
+				llm_calls = usage_daily.llm_calls + excluded.llm_calls,
+				prompt_tokens = usage_daily.prompt_tokens + excluded.prompt_tokens,
+				completion_tokens = usage_daily.completion_tokens + excluded.completion_tokens,
+				tool_calls = usage_daily.tool_calls + excluded.tool_calls`,
+			id, sample.SampledAt.UTC().Format(usageDayFormat), delta.MessagesIn, delta.MessagesOut, delta.LLMCalls,
+			delta.PromptTokens, delta.CompletionTokens, delta.ToolCalls,
+		)
+		if err != nil {
+			return fmt.Errorf("add daily usage: %w", err)
+		}
+	}
+	_, err = tx.Exec(`
+		INSERT INTO usage_samples (tenant_id, since, sampled_at, messages_in, messages_out, llm_calls, prompt_tokens, completion_tokens, tool_calls)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9)
+		ON CONFLICT (tenant_id) DO UPDATE SET
+			since = excluded.since, sampled_at = excluded.sampled_at,
+			messages_in = excluded.messages_in, messages_out = excluded.messages_out, llm_calls = excluded.llm_calls,
+			prompt_tokens = excluded.prompt_tokens, completion_tokens = excluded.completion_tokens, tool_calls = excluded.tool_calls`,
+		id, sample.Since.Truncate(usageTimePrecision), sample.SampledAt.Truncate(usageTimePrecision),
+		sample.MessagesIn, sample.MessagesOut, sample.LLMCalls,
+		sample.PromptTokens, sample.CompletionTokens, sample.ToolCalls,
+	)
+	if err != nil {
+		return fmt.Errorf("store usage sample: %w", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("record usage: %w", err)
 	}
 	return nil
 }
 
+const usageSampleColumns = `since, sampled_at, messages_in, messages_out, llm_calls, prompt_tokens, completion_tokens, tool_calls`
+
+func scanUsageSample(row rowScanner) (*UsageSample, error) {
+	u := &UsageSample{}
+	err := row.Scan(&u.Since, &u.SampledAt, &u.MessagesIn, &u.MessagesOut, &u.LLMCalls,
+		&u.PromptTokens, &u.CompletionTokens, &u.ToolCalls)
+	if err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// GetUsageSample returns the last usage a tenant's gateway reported, or
+// nil if it never reported any.
+func (s *Store) GetUsageSample(tenantID string) (*UsageSample, error) {
+	u, err := scanUsageSample(s.db.QueryRow(`SELECT `+usageSampleColumns+` FROM usage_samples WHERE tenant_id = $1`, tenantID))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get usage sample: %w", err)
+	}
+	return u, nil
+}
+
+// ListUsage returns a tenant's daily usage from fromDay to toDay, both
+// included, oldest first. Days are formatted as YYYY-MM-DD.
+func (s *Store) ListUsage(tenantID, fromDay, toDay string) ([]UsageDay, error) {
+	rows, err := s.db.Query(`
+		SELECT day, messages_in, messages_out, llm_calls, prompt_tokens, completion_tokens, tool_calls
+		FROM usage_daily WHERE tenant_id = $1 AND day >= $2 AND day <= $3 ORDER BY day`,
+		tenantID, fromDay, toDay,
+	)
+	if err != nil {
+		return nil, fmt.Errorf("list usage: %w", err)
+	}
+	defer rows.Close()
+
+	var days []UsageDay
+	for rows.Next() {
+		var d UsageDay
+		if err := rows.Scan(&d.Day, &d.MessagesIn, &d.MessagesOut, &d.LLMCalls,
+			&d.PromptTokens, &d.CompletionTokens, &d.ToolCalls); err != nil {
+			return nil, fmt.Errorf("scan usage: %w", err)
+		}
+		days = append(days, d)
+	}
+	return days, rows.Err()
+}
+
 // Delete removes a tenant record by ID.
 func (s *Store) Delete(id string) error {
 	res, err := s.db.Exec(`DELETE FROM tenants WHERE id=$1`, id)
@@ -130,3 +554,287 @@ func (s *Store) Delete(id string) error {
 	}
 	return nil
 }
+
+const operationColumns = `id, tenant_id, type, status, steps, error, rolled_back,
+	COALESCE(idempotency_key, ''), request_hash, created_at, updated_at, finished_at`
+
+// CreateOperation inserts a new operation record.
+func (s *Store) CreateOperation(op *Operation) error {
+	stepsJSON, err := json.Marshal(op.Steps)
+	if err != nil {
+		return fmt.Errorf("marshal steps: %w", err)
+	}
+	var key sql.NullString
+	if op.IdempotencyKey != "" {
+		key = sql.NullString{String: op.IdempotencyKey, Valid: true}
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO operations (id, tenant_id, type, status, steps, error, rolled_back, idempotency_key, request_hash, created_at, updated_at, finished_at)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		op.ID, op.TenantID, op.Type, op.Status, stepsJSON, op.Error, op.RolledBack, key, op.RequestHash,
+		op.CreatedAt, op.UpdatedAt, op.FinishedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("insert operation: %w", err)
+	}
+	return nil
+}
+
+// UpdateOperation stores the progress of an operation.
+func (s *Store) UpdateOperation(op *Operation) error {
+	stepsJSON, err := json.Marshal(op.Steps)
+	if err != nil {
+		return fmt.Errorf("marshal steps: %w", err)
+	}
+	_, err = s.db.Exec(`
+		UPDATE operations SET status=$1, steps=$2, error=$3, rolled_back=$4, updated_at=$5, finished_at=$6
+		WHERE id=$7`,
+		op.Status, stepsJSON, op.Error, op.RolledBack, op.UpdatedAt, op.FinishedAt, op.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("update operation: %w", err)
+	}
+	return nil
+}
+
+// GetOperation retrieves an operation by ID.
+func (s *Store) GetOperation(id string) (*Operation, error) {
+	return s.getOperation(`SELECT `+operationColumns+` FROM operations WHERE id = $1`, id)
+}
+
+// GetOperationByIdempotencyKey retrieves the operation started with key.
+func (s *Store) GetOperationByIdempotencyKey(key string) (*Operation, error) {
+	return s.getOperation(`SELECT `+operationColumns+` FROM operations WHERE idempotency_key = $1`, key)
+}
+
+func (s *Store) getOperation(query string, arg string) (*Operation, error) {
+	op, err := scanOperation(s.db.QueryRow(query, arg))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get operation: %w", err)
+	}
+	return op, nil
+}
+
+// ListOperations returns the most recent operations of a tenant, newest
+// first.
+func (s *Store) ListOperations(tenantID string) ([]Operation, error) {
+	rows, err := s.db.Query(`SELECT `+operationColumns+` FROM operations
+		WHERE tenant_id = $1 ORDER BY created_at DESC LIMIT 100`, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("list operations: %w", err)
+	}
+	defer rows.Close()
+
+	var ops []Operation
+	for rows.Next() {
+		op, err := scanOperation(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan operation: %w", err)
+		}
+		ops = append(ops, *op)
+	}
+	return ops, rows.Err()
+}
+
+// FailInterrupted marks operations left pending or running by a previous
+// manager process as failed, and returns how many there were.
+func (s *Store) FailInterrupted() (int64, error) {
+	res, err := s.db.Exec(`
+		UPDATE operations SET status='failed', error='interrupted by manager restart', updated_at=$1, finished_at=$1
+		WHERE status IN ('pending', 'running')`, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("fail interrupted operations: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanOperation(row rowScanner) (*Operation, error) {
+	op := &Operation{}
+	var stepsJSON []byte
+	err := row.Scan(&op.ID, &op.TenantID, &op.Type, &op.Status, &stepsJSON, &op.Error, &op.RolledBack,
+		&op.IdempotencyKey, &op.RequestHash, &op.CreatedAt, &op.UpdatedAt, &op.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(stepsJSON, &op.Steps); err != nil {
+		return nil, fmt.Errorf("unmarshal steps: %w", err)
+	}
+	return op, nil
+}
+
+const snapshotColumns = `id, tenant_id, operation_id, reason, archive_key, size_bytes, created_at`
+
+// CreateSnapshot records a stored snapshot.
+func (s *Store) CreateSnapshot(sn *Snapshot) error {
+	_, err := s.db.Exec(`
+		INSERT INTO snapshots (`+snapshotColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7)`,
+		sn.ID, sn.TenantID, sn.OperationID, sn.Reason, sn.Key, sn.SizeBytes, sn.CreatedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("insert snapshot: %w", err)
+	}
+	return nil
+}
+
+// GetSnapshot retrieves a snapshot by ID.
+func (s *Store) GetSnapshot(id string) (*Snapshot, error) {
+	sn := &Snapshot{}
+	err := s.db.QueryRow(`SELECT `+snapshotColumns+` FROM snapshots WHERE id = $1`, id).
+		Scan(&sn.ID, &sn.TenantID, &sn.OperationID, &sn.Reason, &sn.Key, &sn.SizeBytes, &sn.CreatedAt)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get snapshot: %w", err)
+	}
+	return sn, nil
+}
+
+// ListSnapshots returns the snapshots of a tenant, newest first. They
+// outlive the tenant.
+func (s *Store) ListSnapshots(tenantID string) ([]Snapshot, error) {
+	rows, err := s.db.Query(`SELECT `+snapshotColumns+` FROM snapshots WHERE tenant_id = $1 ORDER BY created_at DESC`, tenantID)
+	if err != nil {
+		return nil, fmt.Errorf("list snapshots: %w", err)
+	}
+	defer rows.Close()
+
+	var snapshots []Snapshot
+	for rows.Next() {
+		var sn Snapshot
+		if err := rows.Scan(&sn.ID, &sn.TenantID, &sn.OperationID, &sn.Reason, &sn.Key, &sn.SizeBytes, &sn.CreatedAt); err != nil {
+			return nil, fmt.Errorf("scan snapshot: %w", err)
+		}
+		snapshots = append(snapshots, sn)
+	}
+	return snapshots, rows.Err()
+}
+
+const upgradeColumns = `id, status, target, selector, canary, batch_size, pause_after_canary, tenants, error,
+	created_at, updated_at, finished_at`
+
+// CreateUpgrade inserts a new upgrade record.
+func (s *Store) CreateUpgrade(u *Upgrade) error {
+	target, selector, canary, tenants, err := marshalUpgrade(u)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`
+		INSERT INTO upgrades (`+upgradeColumns+`)
+		VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12)`,
+		u.ID, u.Status, target, selector, canary, u.BatchSize, u.PauseAfterCanary, tenants, u.Error,
+		u.CreatedAt, u.UpdatedAt, u.FinishedAt,
+	)
+	if err != nil {
+		return fmt.Errorf("insert upgrade: %w", err)
+	}
+	return nil
+}
+
+// UpdateUpgrade stores the progress of an upgrade.
+func (s *Store) UpdateUpgrade(u *Upgrade) error {
+	_, _, _, tenants, err := marshalUpgrade(u)
+	if err != nil {
+		return err
+	}
+	_, err = s.db.Exec(`
+		UPDATE upgrades SET status=$1, tenants=$2, error=$3, updated_at=$4, finished_at=$5
+		WHERE id=$6`,
+		u.Status, tenants, u.Error, u.UpdatedAt, u.FinishedAt, u.ID,
+	)
+	if err != nil {
+		return fmt.Errorf("update upgrade: %w", err)
+	}
+	return nil
+}
+
+// GetUpgrade retrieves an upgrade by ID.
+func (s *Store) GetUpgrade(id string) (*Upgrade, error) {
+	u, err := scanUpgrade(s.db.QueryRow(`SELECT `+upgradeColumns+` FROM upgrades WHERE id = $1`, id))
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("get upgrade: %w", err)
+	}
+	return u, nil
+}
+
+// ListUpgrades returns the most recent upgrades, newest first.
+func (s *Store) ListUpgrades() ([]Upgrade, error) {
+	rows, err := s.db.Query(`SELECT ` + upgradeColumns + ` FROM upgrades ORDER BY created_at DESC LIMIT 100`)
+	if err != nil {
+		return nil, fmt.Errorf("list upgrades: %w", err)
+	}
+	defer rows.Close()
+
+	var upgrades []Upgrade
+	for rows.Next() {
+		u, err := scanUpgrade(rows)
+		if err != nil {
+			return nil, fmt.Errorf("scan upgrade: %w", err)
+		}
+		upgrades = append(upgrades, *u)
+	}
+	return upgrades, rows.Err()
+}
+
+// PauseInterruptedUpgrades pauses the upgrades a previous manager process
+// was running, so that they are only resumed on request, and returns how
+// many there were.
+func (s *Store) PauseInterruptedUpgrades() (int64, error) {
+	res, err := s.db.Exec(`UPDATE upgrades SET status='paused', updated_at=$1 WHERE status = 'running'`, time.Now())
+	if err != nil {
+		return 0, fmt.Errorf("pause interrupted upgrades: %w", err)
+	}
+	return res.RowsAffected()
+}
+
+func marshalUpgrade(u *Upgrade) (target, selector, canary, tenants []byte, err error) {
+	if target, err = json.Marshal(u.Target); err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("marshal target: %w", err)
+	}
+	if selector, err = marshalLabels(u.Selector); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if canary, err = marshalLabels(u.Canary); err != nil {
+		return nil, nil, nil, nil, err
+	}
+	if tenants, err = json.Marshal(u.Tenants); err != nil {
+		return nil, nil, nil, nil, fmt.Errorf("marshal upgrade tenants: %w", err)
+	}
+	return target, selector, canary, tenants, nil
+}
+
+func scanUpgrade(row rowScanner) (*Upgrade, error) {
+	u := &Upgrade{}
+	var target, selector, canary, tenants []byte
+	err := row.Scan(&u.ID, &u.Status, &target, &selector, &canary, &u.BatchSize, &u.PauseAfterCanary, &tenants, &u.Error,
+		&u.CreatedAt, &u.UpdatedAt, &u.FinishedAt)
+	if err != nil {
+		return nil, err
+	}
+	for _, f := range []struct {
+		data []byte
+		v    any
+	}{{target, &u.Target}, {selector, &u.Selector}, {canary, &u.Canary}, {tenants, &u.Tenants}} {
+		if err := json.Unmarshal(f.data, f.v); err != nil {
+			return nil, fmt.Errorf("unmarshal upgrade: %w", err)
+		}
+	}
+	if len(u.Selector) == 0 {
+		u.Selector = nil
+	}
+	if len(u.Canary) == 0 {
+		u.Canary = nil
+	}
+	return u, nil
+}
@@ -0,0 +1,253 @@
+package tenant
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newSQLiteStore(t *testing.T) *Store {
+	t.Helper()
+	db, err := sql.Open("sqlite", ":memory:")
+	require.NoError(t, err)
+	t.Cleanup(func() { db.Close() })
+	box, err := NewSecretBox(base64.StdEncoding.EncodeToString([]byte("0123456789abcdef0123456789abcdef")))
+	require.NoError(t, err)
+	store, err := NewSQLiteStore(db, box)
+	require.NoError(t, err)
+	return store
+}
+
+func TestSQLiteStore_Tenants(t *testing.T) {
+	store := newSQLiteStore(t)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	tenant := &Tenant{
+		ID:          "acme",
+		DisplayName: "ACME",
+		Namespace:   "picoclaw-tenant-acme",
+		ConfigJSON:  []byte(`{"agents":{}}`),
+		Secrets:     map[string]string{"PICOCLAW_CHANNELS_TELEGRAM_TOKEN": "123:abc"},
+		Resources:   DefaultResources(),
+		Image:       "picoclaw:1",
+		Labels:      map[string]string{"ring": "canary"},
+		Status:      StatusProvisioning,
+		CreatedAt:   now,
+		UpdatedAt:   now,
+	}
+	require.NoError(t, store.Create(tenant))
+	assert.Error(t, store.Create(tenant), "duplicate id")
+
+	got, err := store.Get("acme")
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, tenant.Secrets, got.Secrets)
+	assert.JSONEq(t, `{"agents":{}}`, string(got.ConfigJSON))
+	assert.Equal(t, "picoclaw:1", got.Image)
+	assert.Equal(t, map[string]string{"ring": "canary"}, got.Labels)
+	assert.True(t, got.CreatedAt.Equal(now), "created_at %s", got.CreatedAt)
+
+	got.DisplayName = "ACME Corp"
+	got.Suspended = true
+	got.TemplateVersion = "v2"
+	require.NoError(t, store.Update(got))
+	require.NoError(t, store.RecordActivity("acme", now))
+	require.NoError(t, store.RecordActivity("acme", now.Add(-time.Hour)), "older activity is ignored")
+	conditions := []Condition{{Type: ConditionReady, Status: "True", LastTransitionTime: now}}
+	require.NoError(t, store.UpdateStatus("acme", StatusReady, conditions))
+
+	list, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, list, 1)
+	assert.Equal(t, "ACME Corp", list[0].DisplayName)
+	assert.Equal(t, StatusReady, list[0].Status)
+	assert.Equal(t, conditions, list[0].Conditions)
+	assert.True(t, list[0].Suspended)
+	assert.Equal(t, "v2", list[0].TemplateVersion)
+	require.NotNil(t, list[0].LastActivityAt)
+	assert.True(t, list[0].LastActivityAt.Equal(now), "last activity %s", list[0].LastActivityAt)
+
+	require.NoError(t, store.UpdateStatus("acme", StatusDeleting, nil))
+	assert.Error(t, store.Update(got), "deleting tenants cannot be updated")
+	assert.Error(t, store.UpdateStatus("acme", StatusReady, nil))
+
+	require.NoError(t, store.Delete("acme"))
+	got, err = store.Get("acme")
+	require.NoError(t, err)
+	assert.Nil(t, got)
+}
+
+func TestSQLiteStore_Operations(t *testing.T) {
+	store := newSQLiteStore(t)
+	now := time.Now().UTC()
+
+	op := &Operation{
+		ID: "op-1", TenantID: "acme", Type: OpCreate, Status: OpPending,
+		Steps:          []Step{{Name: "apply", Status: OpPending}},
+		IdempotencyKey: "key-1", CreatedAt: now, UpdatedAt: now,
+	}
+	require.NoError(t, store.CreateOperation(op))
+	running := &Operation{
+		ID: "op-2", TenantID: "acme", Type: OpRestart, Status: OpRunning,
+		Steps:     []Step{{Name: "restart", Status: OpRunning}},
+		CreatedAt: now.Add(time.Second), UpdatedAt: now,
+	}
+	require.NoError(t, store.CreateOperation(running))
+
+	op.Status = OpSucceeded
+	op.FinishedAt = &now
+	require.NoError(t, store.UpdateOperation(op))
+
+	got, err := store.GetOperationByIdempotencyKey("key-1")
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, OpSucceeded, got.Status)
+	assert.NotNil(t, got.FinishedAt)
+	assert.Equal(t, "apply", got.Steps[0].Name)
+
+	n, err := store.FailInterrupted()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	ops, err := store.ListOperations("acme")
+	require.NoError(t, err)
+	require.Len(t, ops, 2)
+	assert.Equal(t, running.ID, ops[0].ID, "newest first")
+	assert.Equal(t, OpFailed, ops[0].Status)
+	assert.Equal(t, "interrupted by manager restart", ops[0].Error)
+}
+
+func TestSQLiteStore_Snapshots(t *testing.T) {
+	store := newSQLiteStore(t)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	for i, id := range []string{"snap-1", "snap-2"} {
+		require.NoError(t, store.CreateSnapshot(&Snapshot{
+			ID: id, TenantID: "acme", OperationID: "op-" + id, Reason: SnapshotManual,
+			Key: "acme/" + id + ".tar.gz", SizeBytes: int64(100 * (i + 1)), CreatedAt: now.Add(time.Duration(i) * time.Second),
+		}))
+	}
+
+	got, err := store.GetSnapshot("snap-1")
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, "acme/snap-1.tar.gz", got.Key)
+	assert.Equal(t, int64(100), got.SizeBytes)
+	missing, err := store.GetSnapshot("snap-3")
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+
+	list, err := store.ListSnapshots("acme")
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, "snap-2", list[0].ID, "newest first")
+	list, err = store.ListSnapshots("other")
+	require.NoError(t, err)
+	assert.Empty(t, list)
+}
+
+func TestSQLiteStore_Upgrades(t *testing.T) {
+	store := newSQLiteStore(t)
+	now := time.Now().UTC().Truncate(time.Second)
+
+	for i, id := range []string{"upg-1", "upg-2"} {
+		require.NoError(t, store.CreateUpgrade(&Upgrade{
+			ID: id, Target: Versions{Image: "picoclaw:2"}, Selector: map[string]string{"plan": "pro"},
+			Canary: map[string]string{"ring": "canary"}, BatchSize: 2, PauseAfterCanary: true, Status: UpgradeRunning,
+			Tenants:   []UpgradeTenant{{TenantID: "acme", From: Versions{Image: "picoclaw:1", TemplateVersion: "v1"}, Status: UpgradeTenantPending}},
+			CreatedAt: now.Add(time.Duration(i) * time.Second), UpdatedAt: now,
+		}))
+	}
+
+	got, err := store.GetUpgrade("upg-1")
+	require.NoError(t, err)
+	require.NotNil(t, got)
+	assert.Equal(t, Versions{Image: "picoclaw:2"}, got.Target)
+	assert.Equal(t, map[string]string{"plan": "pro"}, got.Selector)
+	assert.Equal(t, map[string]string{"ring": "canary"}, got.Canary)
+	assert.True(t, got.PauseAfterCanary)
+	assert.Equal(t, "v1", got.Tenants[0].From.TemplateVersion)
+
+	got.Status = UpgradeFailed
+	got.Error = "tenant acme: not ready"
+	got.Tenants[0].Status = UpgradeTenantFailed
+	got.FinishedAt = &now
+	require.NoError(t, store.UpdateUpgrade(got))
+
+	n, err := store.PauseInterruptedUpgrades()
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), n)
+
+	list, err := store.ListUpgrades()
+	require.NoError(t, err)
+	require.Len(t, list, 2)
+	assert.Equal(t, "upg-2", list[0].ID, "newest first")
+	assert.Equal(t, UpgradePaused, list[0].Status)
+	assert.Equal(t, UpgradeFailed, list[1].Status)
+	assert.Equal(t, "tenant acme: not ready", list[1].Error)
+	assert.Equal(t, UpgradeTenantFailed, list[1].Tenants[0].Status)
+	require.NotNil(t, list[1].FinishedAt)
+
+	missing, err := store.GetUpgrade("upg-3")
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+}
+
+func TestSQLiteStore_Usage(t *testing.T) {
+	store := newSQLiteStore(t)
+	since := time.Date(2026, 2, 16, 9, 0, 0, 0, time.UTC)
+	day1 := time.Date(2026, 2, 16, 23, 59, 0, 0, time.UTC)
+
+	for _, sample := range []UsageSample{
+		{Since: since, SampledAt: day1.Add(-time.Hour), UsageCounters: UsageCounters{MessagesIn: 3, PromptTokens: 100}},
+		{Since: since, SampledAt: day1, UsageCounters: UsageCounters{MessagesIn: 4, PromptTokens: 150, ToolCalls: 1}},
+		// Restarted: the counters went down, so all of them are new.
+		{Since: since.Add(24 * time.Hour), SampledAt: day1.Add(time.Hour), UsageCounters: UsageCounters{MessagesIn: 1, MessagesOut: 1}},
+	} {
+		require.NoError(t, store.RecordUsage("acme", sample))
+	}
+
+	days, err := store.ListUsage("acme", "2026-02-01", "2026-02-28")
+	require.NoError(t, err)
+	assert.Equal(t, []UsageDay{
+		{Day: "2026-02-16", UsageCounters: UsageCounters{MessagesIn: 4, PromptTokens: 150, ToolCalls: 1}},
+		{Day: "2026-02-17", UsageCounters: UsageCounters{MessagesIn: 1, MessagesOut: 1}},
+	}, days)
+	days, err = store.ListUsage("acme", "2026-02-17", "2026-02-17")
+	require.NoError(t, err)
+	assert.Len(t, days, 1)
+
+	last, err := store.GetUsageSample("acme")
+	require.NoError(t, err)
+	require.NotNil(t, last)
+	assert.True(t, last.Since.Equal(since.Add(24*time.Hour)), "since %s", last.Since)
+	assert.Equal(t, int64(1), last.MessagesOut)
+	missing, err := store.GetUsageSample("other")
+	require.NoError(t, err)
+	assert.Nil(t, missing)
+}
+
+func TestSQLiteStore_UsageSinceRoundTrip(t *testing.T) {
+	store := newSQLiteStore(t)
+	// Gateways report nanoseconds; the stored sample keeps microseconds.
+	since := time.Date(2026, 2, 16, 9, 0, 0, 123456789, time.UTC)
+	sampled := time.Date(2026, 2, 16, 12, 0, 0, 0, time.UTC)
+
+	for i := 0; i < 3; i++ {
+		sample := UsageSample{Since: since, SampledAt: sampled.Add(time.Duration(i) * time.Minute), UsageCounters: UsageCounters{MessagesIn: 5}}
+		require.NoError(t, store.RecordUsage("acme", sample))
+	}
+
+	last, err := store.GetUsageSample("acme")
+	require.NoError(t, err)
+	require.NotNil(t, last)
+	assert.True(t, last.Since.Equal(since.Truncate(time.Microsecond)), "since %s", last.Since)
+	days, err := store.ListUsage("acme", "2026-02-16", "2026-02-16")
+	require.NoError(t, err)
+	assert.Equal(t, []UsageDay{{Day: "2026-02-16", UsageCounters: UsageCounters{MessagesIn: 5}}}, days,
+		"the same running totals are counted once")
+}
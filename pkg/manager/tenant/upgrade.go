@@ -0,0 +1,702 @@
+package tenant
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+)
+
+// Upgrade statuses.
+const (
+	UpgradeRunning     = "running"
+	UpgradePaused      = "paused"
+	UpgradeSucceeded   = "succeeded"
+	UpgradeFailed      = "failed"
+	UpgradeAborted     = "aborted"
+	UpgradeRollingBack = "rolling-back"
+	UpgradeRolledBack  = "rolled-back"
+)
+
+// States of a tenant in an upgrade.
+const (
+	UpgradeTenantPending   = "pending"
+	UpgradeTenantUpgrading = "upgrading"
+	UpgradeTenantUpgraded  = "upgraded"
+	UpgradeTenantFailed    = "failed"   // its operation failed and put the tenant back
+	UpgradeTenantSkipped   = "skipped"  // deleted, or changed by someone else meanwhile
+	UpgradeTenantReverted  = "reverted" // put back by a rollback
+)
+
+// Upgrade defaults.
+const (
+	DefaultUpgradeBatchSize = 5
+	DefaultHealthTimeout    = 5 * time.Minute
+)
+
+// Upgrade rolls tenants to a new image and template version, batch by
+// batch. A batch starts once every tenant of the previous one is ready.
+type Upgrade struct {
+	ID       string            `json:"id"`
+	Target   Versions          `json:"target"`
+	Selector map[string]string `json:"selector,omitempty"` // labels of the tenants upgraded; empty means all
+	Canary   map[string]string `json:"canary,omitempty"`   // labels of the tenants upgraded first, in batch 0
+	// BatchSize is how many tenants are upgraded at once after the canary
+	// batch.
+	BatchSize        int             `json:"batch_size"`
+	PauseAfterCanary bool            `json:"pause_after_canary"`
+	Status           string          `json:"status"`
+	Tenants          []UpgradeTenant `json:"tenants"`
+	Error            string          `json:"error,omitempty"`
+	CreatedAt        time.Time       `json:"created_at"`
+	UpdatedAt        time.Time       `json:"updated_at"`
+	FinishedAt       *time.Time      `json:"finished_at,omitempty"`
+}
+
+// UpgradeTenant is the progress of one tenant in an upgrade.
+type UpgradeTenant struct {
+	TenantID    string   `json:"tenant_id"`
+	Batch       int      `json:"batch"`
+	From        Versions `json:"from"` // what the tenant ran before, restored by a rollback
+	Status      string   `json:"status"`
+	OperationID string   `json:"operation_id,omitempty"` // the last operation run on the tenant
+	Error       string   `json:"error,omitempty"`
+}
+
+// UpgradeRequest holds the parameters of an upgrade. An empty image or
+// template version means the runtime's default.
+type UpgradeRequest struct {
+	Image            string            `json:"image,omitempty"`
+	TemplateVersion  string            `json:"template_version,omitempty"`
+	Selector         map[string]string `json:"selector,omitempty"`
+	Canary           map[string]string `json:"canary,omitempty"`
+	BatchSize        int               `json:"batch_size,omitempty"`
+	PauseAfterCanary bool              `json:"pause_after_canary,omitempty"`
+}
+
+// Progress returns the percentage of tenants no longer pending or being
+// worked on.
+func (u *Upgrade) Progress() int {
+	if len(u.Tenants) == 0 {
+		return 0
+	}
+	done := 0
+	for _, ut := range u.Tenants {
+		if ut.Status != UpgradeTenantPending && ut.Status != UpgradeTenantUpgrading {
+			done++
+		}
+	}
+	return done * 100 / len(u.Tenants)
+}
+
+// upgradeRun is the upgrade this process is driving.
+type upgradeRun struct {
+	id      string
+	request string // UpgradePaused, UpgradeAborted or UpgradeRollingBack once asked
+}
+
+func newUpgradeID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return "upg-" + hex.EncodeToString(b)
+}
+
+// matchLabels reports whether labels has every label of selector.
+func matchLabels(labels, selector map[string]string) bool {
+	for k, v := range selector {
+		if got, ok := labels[k]; !ok || got != v {
+			return false
+		}
+	}
+	return true
+}
+
+// StartUpgrade starts rolling the tenants matching the selector to the
+// target versions in the background. Tenants matching the canary selector
+// form the first batch. Only one upgrade may be unfinished at a time.
+func (s *Service) StartUpgrade(ctx context.Context, req UpgradeRequest) (*Upgrade, error) {
+	target, err := s.runtime.ResolveVersions(Versions{Image: req.Image, TemplateVersion: req.TemplateVersion})
+	if err != nil {
+		return nil, err
+	}
+	if req.BatchSize < 0 {
+		return nil, invalidf("batch_size must be positive")
+	}
+	if req.BatchSize == 0 {
+		req.BatchSize = DefaultUpgradeBatchSize
+	}
+	if err := validateLabels("selector", req.Selector); err != nil {
+		return nil, err
+	}
+	if err := validateLabels("canary", req.Canary); err != nil {
+		return nil, err
+	}
+
+	s.upgradeMu.Lock()
+	defer s.upgradeMu.Unlock()
+	if err := s.checkNoUpgrade(""); err != nil {
+		return nil, err
+	}
+
+	tenants, err := s.store.List()
+	if err != nil {
+		return nil, err
+	}
+	var canary, rest []UpgradeTenant
+	for i := range tenants {
+		t := &tenants[i]
+		if t.Status == StatusDeleting || !matchLabels(t.Labels, req.Selector) || t.Versions() == target {
+			continue
+		}
+		ut := UpgradeTenant{TenantID: t.ID, From: t.Versions(), Status: UpgradeTenantPending}
+		if len(req.Canary) > 0 && matchLabels(t.Labels, req.Canary) {
+			canary = append(canary, ut)
+		} else {
+			rest = append(rest, ut)
+		}
+	}
+	if len(canary)+len(rest) == 0 {
+		return nil, invalidf("no tenant to upgrade: every tenant selected runs image %s with templates %s",
+			target.Image, target.TemplateVersion)
+	}
+	if len(req.Canary) > 0 && len(canary) == 0 {
+		return nil, invalidf("no tenant to upgrade matches the canary selector")
+	}
+	first := 0
+	if len(canary) > 0 {
+		first = 1
+	}
+	for i := range rest {
+		rest[i].Batch = first + i/req.BatchSize
+	}
+
+	now := time.Now().UTC()
+	u := &Upgrade{
+		ID:               newUpgradeID(),
+		Target:           target,
+		Selector:         req.Selector,
+		Canary:           req.Canary,
+		BatchSize:        req.BatchSize,
+		PauseAfterCanary: req.PauseAfterCanary,
+		Status:           UpgradeRunning,
+		Tenants:          append(canary, rest...),
+		CreatedAt:        now,
+		UpdatedAt:        now,
+	}
+	if err := s.store.CreateUpgrade(u); err != nil {
+		return nil, fmt.Errorf("store upgrade: %w", err)
+	}
+	log.Printf("upgrade %s: rolling %d tenants to image %s with templates %s", u.ID, len(u.Tenants), target.Image, target.TemplateVersion)
+	return s.driveUpgrade(u), nil
+}
+
+// GetUpgrade returns an upgrade by ID.
+func (s *Service) GetUpgrade(ctx context.Context, id string) (*Upgrade, error) {
+	u, err := s.store.GetUpgrade(id)
+	if err != nil {
+		return nil, err
+	}
+	if u == nil {
+		return nil, notFoundf("upgrade %q not found", id)
+	}
+	return u, nil
+}
+
+// ListUpgrades returns the most recent upgrades, newest first.
+func (s *Service) ListUpgrades(ctx context.Context) ([]Upgrade, error) {
+	return s.store.ListUpgrades()
+}
+
+// PauseUpgrade stops a running upgrade once its current batch is done.
+// ResumeUpgrade continues it.
+func (s *Service) PauseUpgrade(ctx context.Context, id string) (*Upgrade, error) {
+	s.upgradeMu.Lock()
+	defer s.upgradeMu.Unlock()
+	u, err := s.GetUpgrade(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if u.Status != UpgradeRunning || !s.driving(id) {
+		return nil, conflictf("upgrade %s is %s, not running", id, u.Status)
+	}
+	if err := s.requestStop(UpgradePaused); err != nil {
+		return nil, err
+	}
+	return u, nil
+}
+
+// ResumeUpgrade continues a paused upgrade with the tenants not upgraded
+// yet.
+func (s *Service) ResumeUpgrade(ctx context.Context, id string) (*Upgrade, error) {
+	s.upgradeMu.Lock()
+	defer s.upgradeMu.Unlock()
+	u, err := s.GetUpgrade(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if u.Status != UpgradePaused {
+		return nil, conflictf("upgrade %s is %s, not paused", id, u.Status)
+	}
+	if err := s.checkNoUpgrade(id); err != nil {
+		return nil, err
+	}
+	u.Status = UpgradeRunning
+	s.saveUpgrade(u)
+	return s.driveUpgrade(u), nil
+}
+
+// AbortUpgrade ends an upgrade for good: a paused one at once, a running
+// one once its current batch is done. Tenants already upgraded keep the
+// new versions.
+func (s *Service) AbortUpgrade(ctx context.Context, id string) (*Upgrade, error) {
+	s.upgradeMu.Lock()
+	defer s.upgradeMu.Unlock()
+	u, err := s.GetUpgrade(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	switch {
+	case u.Status == UpgradeRunning && s.driving(id):
+		if err := s.requestStop(UpgradeAborted); err != nil {
+			return nil, err
+		}
+	case u.Status == UpgradePaused:
+		s.endUpgrade(u, UpgradeAborted)
+	default:
+		return nil, conflictf("upgrade %s is %s, not running or paused", id, u.Status)
+	}
+	return u, nil
+}
+
+// RollbackUpgrade puts the tenants an upgrade changed back on the versions
+// they ran before, batch by batch with the same health checks. A running
+// upgrade stops first, once its current batch is done. Tenants changed
+// since are left alone. An interrupted rollback is continued by asking
+// again.
+func (s *Service) RollbackUpgrade(ctx context.Context, id string) (*Upgrade, error) {
+	s.upgradeMu.Lock()
+	defer s.upgradeMu.Unlock()
+	u, err := s.GetUpgrade(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if s.driving(id) {
+		if u.Status != UpgradeRunning {
+			return nil, conflictf("upgrade %s is %s", id, u.Status)
+		}
+		if err := s.requestStop(UpgradeRollingBack); err != nil {
+			return nil, err
+		}
+		return u, nil
+	}
+	if u.Status == UpgradeRolledBack || u.Status == UpgradeRunning {
+		return nil, conflictf("upgrade %s is %s", id, u.Status)
+	}
+	if err := s.checkNoUpgrade(id); err != nil {
+		return nil, err
+	}
+	u.Status = UpgradeRollingBack
+	u.FinishedAt = nil
+	s.saveUpgrade(u)
+	return s.driveUpgrade(u), nil
+}
+
+// checkNoUpgrade fails if an upgrade other than id is running, paused or
+// rolling back. Callers hold upgradeMu.
+func (s *Service) checkNoUpgrade(id string) error {
+	if s.upgrade != nil && s.upgrade.id != id {
+		return conflictf("upgrade %s is in progress", s.upgrade.id)
+	}
+	upgrades, err := s.store.ListUpgrades()
+	if err != nil {
+		return err
+	}
+	for _, u := range upgrades {
+		if u.ID != id && (u.Status == UpgradeRunning || u.Status == UpgradePaused || u.Status == UpgradeRollingBack) {
+			return conflictf("upgrade %s is %s, finish or abort it first", u.ID, u.Status)
+		}
+	}
+	return nil
+}
+
+// driving reports whether this process drives upgrade id. Callers hold
+// upgradeMu.
+func (s *Service) driving(id string) bool {
+	return s.upgrade != nil && s.upgrade.id == id
+}
+
+// requestStop asks the driven upgrade to stop after its current batch.
+// Callers hold upgradeMu.
+func (s *Service) requestStop(request string) error {
+	if s.upgrade.request != "" {
+		return conflictf("upgrade %s is already stopping (%s)", s.upgrade.id, s.upgrade.request)
+	}
+	s.upgrade.request = request
+	return nil
+}
+
+// stopRequested returns what the driven upgrade was asked to do, and
+// clears the request.
+func (s *Service) stopRequested() string {
+	s.upgradeMu.Lock()
+	defer s.upgradeMu.Unlock()
+	request := s.upgrade.request
+	s.upgrade.request = ""
+	return request
+}
+
+// driveUpgrade runs u in the background and returns a copy of it as
+// started. Callers hold upgradeMu.
+func (s *Service) driveUpgrade(u *Upgrade) *Upgrade {
+	s.upgrade = &upgradeRun{id: u.ID}
+	started := cloneUpgrade(u)
+	go func() {
+		if u.Status == UpgradeRollingBack {
+			s.revertUpgrade(u)
+		} else {
+			s.runUpgrade(u)
+		}
+	}()
+	return started
+}
+
+// runUpgrade upgrades the pending tenants batch by batch until they are
+// done, one fails, or it is asked to stop.
+func (s *Service) runUpgrade(u *Upgrade) {
+	status := UpgradeSucceeded
+	for batch := 0; batch <= lastBatch(u); batch++ {
+		pending := u.batch(batch, UpgradeTenantPending, UpgradeTenantUpgrading)
+		if len(pending) == 0 {
+			continue
+		}
+		if request := s.stopRequested(); request != "" {
+			status = request
+			break
+		}
+		if err := s.runBatch(u, pending, true); err != nil {
+			u.Error = err.Error()
+			log.Printf("upgrade %s: batch %d failed: %v", u.ID, batch, err)
+			status = UpgradeFailed
+			break
+		}
+		if batch == 0 && len(u.Canary) > 0 && u.PauseAfterCanary && batch < lastBatch(u) {
+			log.Printf("upgrade %s: canary batch is ready, pausing", u.ID)
+			status = UpgradePaused
+			break
+		}
+	}
+	if s.settleUpgrade(u, status) {
+		s.revertUpgrade(u)
+	}
+}
+
+// settleUpgrade ends the upgrade in status, unless it is to be rolled
+// back, which it reports instead.
+func (s *Service) settleUpgrade(u *Upgrade, status string) bool {
+	s.upgradeMu.Lock()
+	defer s.upgradeMu.Unlock()
+	if status == UpgradeRollingBack || s.upgrade.request == UpgradeRollingBack {
+		s.upgrade.request = ""
+		u.Status = UpgradeRollingBack
+		s.saveUpgrade(u)
+		log.Printf("upgrade %s: rolling back", u.ID)
+		return true
+	}
+	s.endUpgrade(u, status)
+	s.upgrade = nil
+	log.Printf("upgrade %s: %s", u.ID, status)
+	return false
+}
+
+// revertUpgrade puts the upgraded tenants back, last batch first.
+func (s *Service) revertUpgrade(u *Upgrade) {
+	var failed error
+	for batch := lastBatch(u); batch >= 0; batch-- {
+		upgraded := u.batch(batch, UpgradeTenantUpgraded, UpgradeTenantUpgrading)
+		if len(upgraded) == 0 {
+			continue
+		}
+		if err := s.runBatch(u, upgraded, false); err != nil && failed == nil {
+			failed = err
+		}
+	}
+	if failed != nil {
+		u.Error = "rollback: " + failed.Error()
+		s.finishUpgrade(u, UpgradeFailed)
+		return
+	}
+	s.finishUpgrade(u, UpgradeRolledBack)
+}
+
+func lastBatch(u *Upgrade) int {
+	last := 0
+	for _, ut := range u.Tenants {
+		last = max(last, ut.Batch)
+	}
+	return last
+}
+
+// batch returns the indexes of the tenants of a batch in one of the given
+// states.
+func (u *Upgrade) batch(batch int, states ...string) []int {
+	var indexes []int
+	for i, ut := range u.Tenants {
+		if ut.Batch != batch {
+			continue
+		}
+		for _, state := range states {
+			if ut.Status == state {
+				indexes = append(indexes, i)
+				break
+			}
+		}
+	}
+	return indexes
+}
+
+// runBatch starts an upgrade operation for every tenant of a batch, or a
+// rollback one when forward is false, and waits for all of them. It
+// returns the first failure.
+func (s *Service) runBatch(u *Upgrade, indexes []int, forward bool) error {
+	ops := make(map[int]string)
+	for _, i := range indexes {
+		ut := &u.Tenants[i]
+		op, err := s.startTenantUpgrade(u, ut, forward)
+		switch {
+		case err != nil:
+			ut.Status, ut.Error = UpgradeTenantFailed, err.Error()
+		case op != nil:
+			ut.OperationID = op.ID
+			ops[i] = op.ID
+			if forward {
+				ut.Status = UpgradeTenantUpgrading
+			}
+		}
+	}
+	s.saveUpgrade(u)
+
+	for _, i := range indexes {
+		ut := &u.Tenants[i]
+		id, ok := ops[i]
+		if !ok {
+			continue
+		}
+		op, err := s.waitOperation(id)
+		switch {
+		case err != nil:
+			ut.Status, ut.Error = UpgradeTenantFailed, err.Error()
+		case op.Status == OpFailed:
+			ut.Status, ut.Error = UpgradeTenantFailed, op.Error
+		case forward:
+			ut.Status, ut.Error = UpgradeTenantUpgraded, ""
+		default:
+			ut.Status, ut.Error = UpgradeTenantReverted, ""
+		}
+	}
+	s.saveUpgrade(u)
+
+	for _, i := range indexes {
+		if ut := u.Tenants[i]; ut.Status == UpgradeTenantFailed {
+			return fmt.Errorf("tenant %s: %s", ut.TenantID, ut.Error)
+		}
+	}
+	return nil
+}
+
+// startTenantUpgrade starts the operation moving one tenant to the target
+// versions, or back to its previous ones. It returns no operation when
+// there is nothing to do, and waits up to HealthTimeout for another
+// operation on the tenant to finish.
+func (s *Service) startTenantUpgrade(u *Upgrade, ut *UpgradeTenant, forward bool) (*Operation, error) {
+	deadline := time.Now().Add(s.HealthTimeout)
+	for {
+		t, err := s.store.Get(ut.TenantID)
+		if err != nil {
+			return nil, err
+		}
+		if t == nil || t.Status == StatusDeleting {
+			ut.Status, ut.Error = UpgradeTenantSkipped, "tenant was deleted"
+			return nil, nil
+		}
+
+		to := u.Target
+		switch {
+		case forward && t.Versions() == u.Target:
+			ut.Status = UpgradeTenantUpgraded
+			return nil, nil
+		case forward:
+			ut.From = t.Versions()
+		case t.Versions() == ut.From:
+			ut.Status = UpgradeTenantReverted
+			return nil, nil
+		case t.Versions() != u.Target:
+			ut.Status, ut.Error = UpgradeTenantSkipped, "tenant was changed since the upgrade"
+			return nil, nil
+		default:
+			to = ut.From
+		}
+
+		op, err := s.setVersions(t, to)
+		if !errors.Is(err, ErrConflict) || time.Now().After(deadline) {
+			return op, err
+		}
+		time.Sleep(s.pollInterval)
+	}
+}
+
+// setVersions applies a tenant with new versions, waits for it to be
+// ready and saves it. If it does not become ready, the previous versions
+// are applied again.
+func (s *Service) setVersions(t *Tenant, v Versions) (*Operation, error) {
+	previous := *t
+	t.setVersions(v)
+	if !t.Suspended {
+		t.Status = StatusProvisioning
+	}
+	return s.startOperation(&Operation{ID: newOperationID(), TenantID: t.ID, Type: OpUpgrade}, []step{
+		{"apply", func(ctx context.Context) error {
+			return s.runtime.Apply(ctx, t)
+		}},
+		{"wait ready", func(ctx context.Context) error {
+			return s.waitReady(ctx, t)
+		}},
+		{"save tenant", func(ctx context.Context) error {
+			return s.store.Update(t)
+		}},
+	}, func(ctx context.Context) error {
+		return s.runtime.Apply(ctx, &previous)
+	})
+}
+
+// waitReady waits up to HealthTimeout for the tenant's resources to match
+// its spec and every workload to be available. Crash loops and stalled
+// rollouts fail at once. Suspended tenants have nothing to wait for.
+func (s *Service) waitReady(ctx context.Context, t *Tenant) error {
+	if t.Suspended {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(ctx, s.HealthTimeout)
+	defer cancel()
+	waiting := "status unknown"
+	for {
+		state, err := s.runtime.Status(ctx, t)
+		if err != nil {
+			waiting = err.Error()
+		} else if ready, err := rolledOut(state); err != nil {
+			return err
+		} else if ready {
+			return nil
+		} else {
+			waiting = notReady(state)
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("not ready after %s: %s", s.HealthTimeout, waiting)
+		case <-time.After(s.pollInterval):
+		}
+	}
+}
+
+// rolledOut reports whether every resource matches the spec and every
+// workload is available, or why it never will be.
+func rolledOut(state *RuntimeState) (bool, error) {
+	for _, w := range state.Workloads {
+		if w.CrashLooping || w.Stalled {
+			return false, fmt.Errorf("%s: %s: %s", w.Name, w.Reason, w.Message)
+		}
+	}
+	if !state.InSync() {
+		return false, nil
+	}
+	for _, w := range state.Workloads {
+		if !w.Available {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func notReady(state *RuntimeState) string {
+	if !state.InSync() {
+		return "resources not applied yet"
+	}
+	for _, w := range state.Workloads {
+		if !w.Available {
+			return fmt.Sprintf("%s: %s", w.Name, w.Message)
+		}
+	}
+	return "status unknown"
+}
+
+// waitOperation waits for an operation to finish.
+func (s *Service) waitOperation(id string) (*Operation, error) {
+	for {
+		op, err := s.store.GetOperation(id)
+		if err != nil {
+			return nil, err
+		}
+		if op != nil && op.Done() {
+			return op, nil
+		}
+		time.Sleep(s.pollInterval)
+	}
+}
+
+func (s *Service) saveUpgrade(u *Upgrade) {
+	u.UpdatedAt = time.Now().UTC()
+	if err := s.store.UpdateUpgrade(u); err != nil {
+		log.Printf("upgrade %s: store progress: %v", u.ID, err)
+	}
+}
+
+// endUpgrade saves an upgrade in its final status. Callers hold
+// upgradeMu.
+func (s *Service) endUpgrade(u *Upgrade, status string) {
+	u.Status = status
+	if status != UpgradePaused {
+		finished := time.Now().UTC()
+		u.FinishedAt = &finished
+	}
+	s.saveUpgrade(u)
+}
+
+// finishUpgrade saves the upgrade this process drove in the status it
+// stopped in.
+func (s *Service) finishUpgrade(u *Upgrade, status string) {
+	s.upgradeMu.Lock()
+	defer s.upgradeMu.Unlock()
+	s.endUpgrade(u, status)
+	s.upgrade = nil
+	log.Printf("upgrade %s: %s", u.ID, status)
+}
+
+// MigrateVersions records the default versions on tenants created before
+// versions were tracked, so that later upgrades know what they run.
+func (s *Service) MigrateVersions(ctx context.Context) error {
+	tenants, err := s.store.List()
+	if err != nil {
+		return err
+	}
+	for i := range tenants {
+		t := &tenants[i]
+		if t.Status == StatusDeleting || t.Versions() != (Versions{}) {
+			continue
+		}
+		v, err := s.runtime.ResolveVersions(Versions{})
+		if err != nil {
+			return err
+		}
+		if v == (Versions{}) {
+			return nil // the runtime has no versions
+		}
+		t.setVersions(v)
+		if err := s.store.Update(t); err != nil {
+			return fmt.Errorf("tenant %s: %w", t.ID, err)
+		}
+		log.Printf("tenant %s: recorded image %s with templates %s", t.ID, v.Image, v.TemplateVersion)
+	}
+	return nil
+}
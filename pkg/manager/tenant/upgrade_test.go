@@ -0,0 +1,313 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/sipeed/picoclaw/pkg/manager/templates"
+)
+
+// newUpgradeTest creates a service with template versions v1 and v2 and
+// the tenants acme (in the canary group), beta and gamma on picoclaw:1 and
+// v1. Deployments become ready when applied, unless their image is
+// picoclaw:bad.
+func newUpgradeTest(t *testing.T) (*Service, *MemoryStore, *fakeCluster) {
+	t.Helper()
+	dir := t.TempDir()
+	files, err := filepath.Glob("../../../k8s/base/*.yaml.tmpl")
+	require.NoError(t, err)
+	for _, version := range []string{"v1", "v2"} {
+		require.NoError(t, os.Mkdir(filepath.Join(dir, version), 0o755))
+		for _, f := range files {
+			data, err := os.ReadFile(f)
+			require.NoError(t, err)
+			require.NoError(t, os.WriteFile(filepath.Join(dir, version, filepath.Base(f)), data, 0o644))
+		}
+	}
+	catalog, err := templates.LoadCatalog(dir, "v1")
+	require.NoError(t, err)
+
+	store := NewMemoryStore()
+	cluster := &fakeCluster{cs: fake.NewClientset()}
+	cluster.rollout = func(dep *appsv1.Deployment) {
+		ready := int32(1)
+		if dep.Spec.Template.Spec.Containers[0].Image == "picoclaw:bad" {
+			ready = 0
+		}
+		dep.Status.Replicas, dep.Status.UpdatedReplicas, dep.Status.ReadyReplicas = 1, 1, ready
+	}
+	svc := NewService(store, NewKubernetesRuntime(catalog, cluster, "picoclaw:1"))
+	svc.pollInterval = 0
+	svc.HealthTimeout = 100 * time.Millisecond
+
+	for _, req := range []CreateRequest{
+		{TenantID: "acme", DisplayName: "ACME", Labels: map[string]string{"ring": "canary"}},
+		{TenantID: "beta", DisplayName: "Beta"},
+		{TenantID: "gamma", DisplayName: "Gamma"},
+	} {
+		op, err := svc.Create(context.Background(), req)
+		require.NoError(t, err)
+		finish(t, svc, op)
+	}
+	return svc, store, cluster
+}
+
+// waitUpgrade waits until the service stops driving the upgrade and
+// returns it as stored.
+func waitUpgrade(t *testing.T, svc *Service, id string) *Upgrade {
+	t.Helper()
+	require.Eventually(t, func() bool {
+		svc.upgradeMu.Lock()
+		defer svc.upgradeMu.Unlock()
+		return svc.upgrade == nil
+	}, 10*time.Second, time.Millisecond)
+	svc.Wait()
+	u, err := svc.GetUpgrade(context.Background(), id)
+	require.NoError(t, err)
+	return u
+}
+
+func deployedImage(t *testing.T, cluster *fakeCluster, tenantID string) string {
+	t.Helper()
+	dep, err := cluster.cs.AppsV1().Deployments("picoclaw-tenant-"+tenantID).
+		Get(context.Background(), "picoclaw-gateway", metav1.GetOptions{})
+	require.NoError(t, err)
+	return dep.Spec.Template.Spec.Containers[0].Image
+}
+
+func upgradeStatuses(u *Upgrade) map[string]string {
+	statuses := make(map[string]string)
+	for _, ut := range u.Tenants {
+		statuses[ut.TenantID] = ut.Status
+	}
+	return statuses
+}
+
+var v2 = Versions{Image: "picoclaw:2", TemplateVersion: "v2"}
+
+func TestService_CreateRecordsVersions(t *testing.T) {
+	_, store, _ := newUpgradeTest(t)
+	acme, _ := store.Get("acme")
+	assert.Equal(t, Versions{Image: "picoclaw:1", TemplateVersion: "v1"}, acme.Versions())
+	assert.Equal(t, map[string]string{"ring": "canary"}, acme.Labels)
+}
+
+func TestService_Upgrade(t *testing.T) {
+	svc, store, cluster := newUpgradeTest(t)
+	ctx := context.Background()
+
+	u, err := svc.StartUpgrade(ctx, UpgradeRequest{
+		Image:           "picoclaw:2",
+		TemplateVersion: "v2",
+		Canary:          map[string]string{"ring": "canary"},
+		BatchSize:       1,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, UpgradeRunning, u.Status)
+	require.Len(t, u.Tenants, 3)
+	assert.Equal(t, UpgradeTenant{TenantID: "acme", Batch: 0, From: Versions{"picoclaw:1", "v1"}, Status: UpgradeTenantPending}, u.Tenants[0])
+	assert.Equal(t, 1, u.Tenants[1].Batch)
+	assert.Equal(t, 2, u.Tenants[2].Batch)
+	assert.Equal(t, 0, u.Progress())
+
+	u = waitUpgrade(t, svc, u.ID)
+	assert.Equal(t, UpgradeSucceeded, u.Status, u.Error)
+	assert.NotNil(t, u.FinishedAt)
+	assert.Equal(t, 100, u.Progress())
+	for _, ut := range u.Tenants {
+		assert.Equal(t, UpgradeTenantUpgraded, ut.Status)
+		tenant, _ := store.Get(ut.TenantID)
+		assert.Equal(t, v2, tenant.Versions())
+		assert.Equal(t, "picoclaw:2", deployedImage(t, cluster, ut.TenantID))
+
+		op, err := svc.GetOperation(ctx, ut.OperationID)
+		require.NoError(t, err)
+		assert.Equal(t, OpUpgrade, op.Type)
+		assert.Equal(t, map[string]string{
+			"apply":       OpSucceeded,
+			"wait ready":  OpSucceeded,
+			"save tenant": OpSucceeded,
+			"rollback":    OpSkipped,
+		}, stepStatuses(op))
+	}
+
+	_, err = svc.StartUpgrade(ctx, UpgradeRequest{Image: "picoclaw:2", TemplateVersion: "v2"})
+	assert.True(t, errors.Is(err, ErrInvalid), "nothing to upgrade: %v", err)
+}
+
+func TestService_UpgradeHealthGate(t *testing.T) {
+	svc, store, cluster := newUpgradeTest(t)
+
+	u, err := svc.StartUpgrade(context.Background(), UpgradeRequest{Image: "picoclaw:bad", BatchSize: 1})
+	require.NoError(t, err)
+	u = waitUpgrade(t, svc, u.ID)
+
+	assert.Equal(t, UpgradeFailed, u.Status)
+	assert.Contains(t, u.Error, "tenant acme: wait ready: not ready after")
+	assert.Equal(t, map[string]string{
+		"acme":  UpgradeTenantFailed,
+		"beta":  UpgradeTenantPending,
+		"gamma": UpgradeTenantPending,
+	}, upgradeStatuses(u))
+
+	// The failed tenant is back on what it ran.
+	acme, _ := store.Get("acme")
+	assert.Equal(t, "picoclaw:1", acme.Image)
+	assert.Equal(t, "picoclaw:1", deployedImage(t, cluster, "acme"))
+	assert.Equal(t, "picoclaw:1", deployedImage(t, cluster, "beta"))
+}
+
+func TestService_UpgradeCanaryPauseResumeRollback(t *testing.T) {
+	svc, store, cluster := newUpgradeTest(t)
+	ctx := context.Background()
+
+	u, err := svc.StartUpgrade(ctx, UpgradeRequest{
+		Image:            "picoclaw:2",
+		Canary:           map[string]string{"ring": "canary"},
+		PauseAfterCanary: true,
+	})
+	require.NoError(t, err)
+	u = waitUpgrade(t, svc, u.ID)
+	assert.Equal(t, UpgradePaused, u.Status)
+	assert.Nil(t, u.FinishedAt)
+	assert.Equal(t, map[string]string{
+		"acme":  UpgradeTenantUpgraded,
+		"beta":  UpgradeTenantPending,
+		"gamma": UpgradeTenantPending,
+	}, upgradeStatuses(u))
+	assert.Equal(t, 33, u.Progress())
+
+	_, err = svc.StartUpgrade(ctx, UpgradeRequest{Image: "picoclaw:3"})
+	assert.True(t, errors.Is(err, ErrConflict), "second upgrade: %v", err)
+	_, err = svc.PauseUpgrade(ctx, u.ID)
+	assert.True(t, errors.Is(err, ErrConflict), "pause paused: %v", err)
+
+	_, err = svc.ResumeUpgrade(ctx, u.ID)
+	require.NoError(t, err)
+	u = waitUpgrade(t, svc, u.ID)
+	assert.Equal(t, UpgradeSucceeded, u.Status)
+	assert.Equal(t, "picoclaw:2", deployedImage(t, cluster, "gamma"))
+
+	// beta was changed by hand since: the rollback leaves it alone.
+	beta, _ := store.Get("beta")
+	beta.Image = "picoclaw:custom"
+	require.NoError(t, store.Update(beta))
+
+	_, err = svc.RollbackUpgrade(ctx, u.ID)
+	require.NoError(t, err)
+	u = waitUpgrade(t, svc, u.ID)
+	assert.Equal(t, UpgradeRolledBack, u.Status, u.Error)
+	assert.Equal(t, map[string]string{
+		"acme":  UpgradeTenantReverted,
+		"beta":  UpgradeTenantSkipped,
+		"gamma": UpgradeTenantReverted,
+	}, upgradeStatuses(u))
+	for _, id := range []string{"acme", "gamma"} {
+		tenant, _ := store.Get(id)
+		assert.Equal(t, "picoclaw:1", tenant.Image)
+		assert.Equal(t, "picoclaw:1", deployedImage(t, cluster, id))
+	}
+
+	_, err = svc.RollbackUpgrade(ctx, u.ID)
+	assert.True(t, errors.Is(err, ErrConflict), "rollback twice: %v", err)
+}
+
+func TestService_UpgradeRollbackWhileRunning(t *testing.T) {
+	svc, store, cluster := newUpgradeTest(t)
+	ctx := context.Background()
+
+	// Hold the first batch until the rollback is requested.
+	applying, release := make(chan struct{}, 1), make(chan struct{})
+	cluster.applyHook = func([]byte) error {
+		select {
+		case applying <- struct{}{}:
+		default:
+		}
+		<-release
+		return nil
+	}
+	u, err := svc.StartUpgrade(ctx, UpgradeRequest{Image: "picoclaw:2", BatchSize: 2})
+	require.NoError(t, err)
+	<-applying
+	_, err = svc.RollbackUpgrade(ctx, u.ID)
+	require.NoError(t, err)
+	_, err = svc.PauseUpgrade(ctx, u.ID)
+	assert.True(t, errors.Is(err, ErrConflict), "pause while stopping: %v", err)
+	close(release)
+
+	u = waitUpgrade(t, svc, u.ID)
+	assert.Equal(t, UpgradeRolledBack, u.Status, u.Error)
+	assert.Equal(t, map[string]string{
+		"acme":  UpgradeTenantReverted,
+		"beta":  UpgradeTenantReverted,
+		"gamma": UpgradeTenantPending,
+	}, upgradeStatuses(u))
+	for _, id := range []string{"acme", "beta", "gamma"} {
+		tenant, _ := store.Get(id)
+		assert.Equal(t, "picoclaw:1", tenant.Image)
+	}
+}
+
+func TestService_UpgradeAbort(t *testing.T) {
+	svc, _, _ := newUpgradeTest(t)
+	ctx := context.Background()
+
+	u, err := svc.StartUpgrade(ctx, UpgradeRequest{
+		TemplateVersion:  "v2",
+		Canary:           map[string]string{"ring": "canary"},
+		PauseAfterCanary: true,
+	})
+	require.NoError(t, err)
+	waitUpgrade(t, svc, u.ID)
+
+	u, err = svc.AbortUpgrade(ctx, u.ID)
+	require.NoError(t, err)
+	assert.Equal(t, UpgradeAborted, u.Status)
+	assert.NotNil(t, u.FinishedAt)
+	_, err = svc.ResumeUpgrade(ctx, u.ID)
+	assert.True(t, errors.Is(err, ErrConflict), "resume aborted: %v", err)
+
+	// An aborted upgrade no longer blocks new ones.
+	next, err := svc.StartUpgrade(ctx, UpgradeRequest{TemplateVersion: "v2"})
+	require.NoError(t, err)
+	assert.Len(t, next.Tenants, 2, "acme already runs v2")
+	waitUpgrade(t, svc, next.ID)
+}
+
+func TestService_UpgradeValidation(t *testing.T) {
+	svc, _, _ := newUpgradeTest(t)
+	ctx := context.Background()
+
+	for name, req := range map[string]UpgradeRequest{
+		"unknown template version": {TemplateVersion: "v9"},
+		"negative batch size":      {Image: "picoclaw:2", BatchSize: -1},
+		"invalid selector":         {Image: "picoclaw:2", Selector: map[string]string{"bad key!": "x"}},
+		"no tenant selected":       {Image: "picoclaw:2", Selector: map[string]string{"ring": "none"}},
+		"empty canary group":       {Image: "picoclaw:2", Canary: map[string]string{"ring": "none"}},
+	} {
+		_, err := svc.StartUpgrade(ctx, req)
+		assert.True(t, errors.Is(err, ErrInvalid), "%s: %v", name, err)
+	}
+	_, err := svc.PauseUpgrade(ctx, "upg-missing")
+	assert.True(t, errors.Is(err, ErrNotFound), "missing upgrade: %v", err)
+}
+
+func TestService_MigrateVersions(t *testing.T) {
+	svc, store, _ := newTestService(t)
+	now := time.Now()
+	require.NoError(t, store.Create(&Tenant{ID: "old", Namespace: "picoclaw-tenant-old", Status: StatusReady, CreatedAt: now, UpdatedAt: now}))
+
+	require.NoError(t, svc.MigrateVersions(context.Background()))
+	old, _ := store.Get("old")
+	assert.Equal(t, Versions{Image: "picoclaw:test", TemplateVersion: "v1"}, old.Versions())
+}
@@ -0,0 +1,167 @@
+package tenant
+
+import (
+	"context"
+	"io"
+	"time"
+)
+
+// Usage report limits.
+const (
+	DefaultUsageDays = 30
+	MaxUsageDays     = 366
+)
+
+// usageDayFormat is the layout of UsageDay.Day: days are UTC.
+const usageDayFormat = "2006-01-02"
+
+// UsageCounters counts a tenant's traffic and LLM use.
+type UsageCounters struct {
+	MessagesIn       int64 `json:"messages_in"`
+	MessagesOut      int64 `json:"messages_out"`
+	LLMCalls         int64 `json:"llm_calls"`
+	PromptTokens     int64 `json:"prompt_tokens"`
+	CompletionTokens int64 `json:"completion_tokens"`
+	ToolCalls        int64 `json:"tool_calls"`
+}
+
+// Add adds o to c.
+func (c *UsageCounters) Add(o UsageCounters) {
+	c.MessagesIn += o.MessagesIn
+	c.MessagesOut += o.MessagesOut
+	c.LLMCalls += o.LLMCalls
+	c.PromptTokens += o.PromptTokens
+	c.CompletionTokens += o.CompletionTokens
+	c.ToolCalls += o.ToolCalls
+}
+
+// covers reports whether no counter of c is below o's.
+func (c UsageCounters) covers(o UsageCounters) bool {
+	return c.MessagesIn >= o.MessagesIn && c.MessagesOut >= o.MessagesOut && c.LLMCalls >= o.LLMCalls &&
+		c.PromptTokens >= o.PromptTokens && c.CompletionTokens >= o.CompletionTokens && c.ToolCalls >= o.ToolCalls
+}
+
+func (c UsageCounters) sub(o UsageCounters) UsageCounters {
+	return UsageCounters{
+		MessagesIn:       c.MessagesIn - o.MessagesIn,
+		MessagesOut:      c.MessagesOut - o.MessagesOut,
+		LLMCalls:         c.LLMCalls - o.LLMCalls,
+		PromptTokens:     c.PromptTokens - o.PromptTokens,
+		CompletionTokens: c.CompletionTokens - o.CompletionTokens,
+		ToolCalls:        c.ToolCalls - o.ToolCalls,
+	}
+}
+
+// UsageSample is what a tenant's gateway reported: its counters since it
+// started.
+type UsageSample struct {
+	Since     time.Time `json:"since"`      // when the gateway started
+	SampledAt time.Time `json:"sampled_at"` // when the manager read the counters
+	UsageCounters
+}
+
+// usageTimePrecision is the precision usage samples are stored with, that
+// of a Postgres TIMESTAMPTZ. Gateways report nanoseconds.
+const usageTimePrecision = time.Microsecond
+
+// usageDelta returns the part of sample not counted yet, given the last
+// sample stored: all of it when the gateway restarted in between.
+func usageDelta(last *UsageSample, sample UsageSample) UsageCounters {
+	if last == nil || !last.Since.Truncate(usageTimePrecision).Equal(sample.Since.Truncate(usageTimePrecision)) ||
+		!sample.covers(last.UsageCounters) {
+		return sample.UsageCounters
+	}
+	return sample.sub(last.UsageCounters)
+}
+
+// UsageDay is a tenant's usage on one UTC day.
+type UsageDay struct {
+	Day string `json:"day"` // YYYY-MM-DD
+	UsageCounters
+}
+
+// UsageReport sums up a tenant's usage over whole UTC days.
+type UsageReport struct {
+	TenantID string        `json:"tenant_id"`
+	From     string        `json:"from"` // first day, YYYY-MM-DD
+	To       string        `json:"to"`   // last day, included
+	Total    UsageCounters `json:"total"`
+	Days     []UsageDay    `json:"days"` // days without traffic are left out
+	// Last is the gateway's last report, if it reported any.
+	Last *UsageSample `json:"last,omitempty"`
+}
+
+// Event is something that happened to a tenant's workloads, such as a
+// Kubernetes event in its namespace.
+type Event struct {
+	Time    time.Time `json:"time"`
+	Type    string    `json:"type"`   // Normal or Warning
+	Reason  string    `json:"reason"` // short CamelCase reason, such as BackOff
+	Object  string    `json:"object"` // kind/name of the object concerned
+	Message string    `json:"message"`
+	Count   int32     `json:"count"` // how many times it happened
+}
+
+// Logs returns the output of one of a tenant's workloads. With Follow, the
+// reader streams until ctx is done or the workload stops.
+func (s *Service) Logs(ctx context.Context, id string, opts LogOptions) (io.ReadCloser, error) {
+	if opts.TailLines < 0 {
+		return nil, invalidf("tail must not be negative")
+	}
+	t, err := s.getActive(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.runtime.Logs(ctx, t, opts)
+}
+
+// Events returns the recent events of a tenant's workloads, newest first.
+func (s *Service) Events(ctx context.Context, id string) ([]Event, error) {
+	t, err := s.getActive(id)
+	if err != nil {
+		return nil, err
+	}
+	return s.runtime.Events(ctx, t)
+}
+
+// Usage reports a tenant's usage from the day of from to the day of to,
+// both included. A zero to means today, and a zero from the
+// DefaultUsageDays up to to.
+func (s *Service) Usage(ctx context.Context, id string, from, to time.Time) (*UsageReport, error) {
+	t, err := s.store.Get(id)
+	if err != nil {
+		return nil, err
+	}
+	if t == nil {
+		return nil, notFoundf("tenant %q not found", id)
+	}
+	if to.IsZero() {
+		to = time.Now()
+	}
+	to = to.UTC().Truncate(24 * time.Hour)
+	if from.IsZero() {
+		from = to.AddDate(0, 0, 1-DefaultUsageDays)
+	}
+	from = from.UTC().Truncate(24 * time.Hour)
+	if from.After(to) {
+		return nil, invalidf("from must not be after to")
+	}
+	if to.Sub(from) >= MaxUsageDays*24*time.Hour {
+		return nil, invalidf("usage can be reported for at most %d days", MaxUsageDays)
+	}
+
+	report := &UsageReport{TenantID: id, From: from.Format(usageDayFormat), To: to.Format(usageDayFormat)}
+	if report.Days, err = s.store.ListUsage(id, report.From, report.To); err != nil {
+		return nil, err
+	}
+	if report.Days == nil {
+		report.Days = []UsageDay{}
+	}
+	for _, d := range report.Days {
+		report.Total.Add(d.UsageCounters)
+	}
+	if report.Last, err = s.store.GetUsageSample(id); err != nil {
+		return nil, err
+	}
+	return report, nil
+}
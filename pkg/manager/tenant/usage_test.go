@@ -0,0 +1,148 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func createAcme(t *testing.T, svc *Service) {
+	t.Helper()
+	op, err := svc.Create(context.Background(), CreateRequest{TenantID: "acme", DisplayName: "ACME"})
+	require.NoError(t, err)
+	finish(t, svc, op)
+}
+
+func TestService_Logs(t *testing.T) {
+	svc, store, cluster := newTestService(t)
+	ctx := context.Background()
+	createAcme(t, svc)
+
+	dep, err := cluster.cs.AppsV1().Deployments("picoclaw-tenant-acme").Get(ctx, "picoclaw-gateway", metav1.GetOptions{})
+	require.NoError(t, err)
+	_, err = cluster.cs.CoreV1().Pods("picoclaw-tenant-acme").Create(ctx, &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "picoclaw-gateway-5f7c", Labels: dep.Spec.Selector.MatchLabels},
+	}, metav1.CreateOptions{})
+	require.NoError(t, err)
+
+	logs, err := svc.Logs(ctx, "acme", LogOptions{TailLines: 10})
+	require.NoError(t, err)
+	data, err := io.ReadAll(logs)
+	logs.Close()
+	require.NoError(t, err)
+	assert.Equal(t, "fake logs", string(data))
+
+	for name, tc := range map[string]struct {
+		id   string
+		opts LogOptions
+		want error
+	}{
+		"unknown workload": {"acme", LogOptions{Workload: "db"}, ErrInvalid},
+		"negative tail":    {"acme", LogOptions{TailLines: -1}, ErrInvalid},
+		"unknown tenant":   {"other", LogOptions{}, ErrNotFound},
+	} {
+		_, err := svc.Logs(ctx, tc.id, tc.opts)
+		assert.True(t, errors.Is(err, tc.want), "%s: %v", name, err)
+	}
+
+	acme, _ := store.Get("acme")
+	acme.Suspended = true
+	require.NoError(t, store.Update(acme))
+	_, err = svc.Logs(ctx, "acme", LogOptions{})
+	assert.True(t, errors.Is(err, ErrConflict), "suspended: %v", err)
+}
+
+func TestService_Events(t *testing.T) {
+	svc, _, cluster := newTestService(t)
+	ctx := context.Background()
+	createAcme(t, svc)
+
+	base := time.Date(2026, 2, 16, 10, 0, 0, 0, time.UTC)
+	for _, e := range []corev1.Event{
+		{
+			ObjectMeta:     metav1.ObjectMeta{Name: "e1"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "picoclaw-agent-7d9f"},
+			Type:           corev1.EventTypeNormal, Reason: "Pulled", Message: "Successfully pulled image",
+			LastTimestamp: metav1.NewTime(base),
+		},
+		{
+			ObjectMeta:     metav1.ObjectMeta{Name: "e2"},
+			InvolvedObject: corev1.ObjectReference{Kind: "Pod", Name: "picoclaw-agent-7d9f"},
+			Type:           corev1.EventTypeWarning, Reason: "BackOff", Message: "Back-off restarting failed container",
+			LastTimestamp: metav1.NewTime(base.Add(time.Minute)), Count: 6,
+		},
+	} {
+		_, err := cluster.cs.CoreV1().Events("picoclaw-tenant-acme").Create(ctx, &e, metav1.CreateOptions{})
+		require.NoError(t, err)
+	}
+
+	events, err := svc.Events(ctx, "acme")
+	require.NoError(t, err)
+	require.Len(t, events, 2)
+	assert.Equal(t, Event{
+		Time: base.Add(time.Minute), Type: "Warning", Reason: "BackOff", Object: "Pod/picoclaw-agent-7d9f",
+		Message: "Back-off restarting failed container", Count: 6,
+	}, events[0])
+	assert.Equal(t, int32(1), events[1].Count)
+
+	_, err = svc.Events(ctx, "other")
+	assert.True(t, errors.Is(err, ErrNotFound), "unknown tenant: %v", err)
+}
+
+func TestService_Usage(t *testing.T) {
+	svc, store, _ := newTestService(t)
+	ctx := context.Background()
+	createAcme(t, svc)
+
+	today := time.Now().UTC()
+	for _, sample := range []UsageSample{
+		{Since: today.AddDate(0, 0, -40), SampledAt: today.AddDate(0, 0, -40), UsageCounters: UsageCounters{MessagesIn: 7}},
+		{Since: today.AddDate(0, 0, -2), SampledAt: today.AddDate(0, 0, -2), UsageCounters: UsageCounters{MessagesIn: 2, PromptTokens: 10}},
+		{Since: today.AddDate(0, 0, -2), SampledAt: today, UsageCounters: UsageCounters{MessagesIn: 5, PromptTokens: 30, ToolCalls: 1}},
+	} {
+		require.NoError(t, store.RecordUsage("acme", sample))
+	}
+
+	report, err := svc.Usage(ctx, "acme", time.Time{}, time.Time{})
+	require.NoError(t, err)
+	assert.Equal(t, today.Format(time.DateOnly), report.To)
+	assert.Equal(t, today.AddDate(0, 0, 1-DefaultUsageDays).Format(time.DateOnly), report.From)
+	assert.Equal(t, UsageCounters{MessagesIn: 5, PromptTokens: 30, ToolCalls: 1}, report.Total, "the 40 days old usage is left out")
+	assert.Len(t, report.Days, 2)
+	require.NotNil(t, report.Last)
+	assert.Equal(t, int64(5), report.Last.MessagesIn)
+
+	report, err = svc.Usage(ctx, "acme", today.AddDate(0, 0, -60), today.AddDate(0, 0, -1))
+	require.NoError(t, err)
+	assert.Equal(t, int64(9), report.Total.MessagesIn)
+
+	_, err = svc.Usage(ctx, "acme", today, today.AddDate(0, 0, -1))
+	assert.True(t, errors.Is(err, ErrInvalid), "from after to: %v", err)
+	_, err = svc.Usage(ctx, "acme", today.AddDate(-2, 0, 0), today)
+	assert.True(t, errors.Is(err, ErrInvalid), "too long: %v", err)
+	_, err = svc.Usage(ctx, "other", time.Time{}, time.Time{})
+	assert.True(t, errors.Is(err, ErrNotFound), "unknown tenant: %v", err)
+
+	report, err = svc.Usage(ctx, "acme", today.AddDate(0, 0, -10), today.AddDate(0, 0, -5))
+	require.NoError(t, err)
+	assert.NotNil(t, report.Days, "no traffic is an empty list")
+	assert.Empty(t, report.Days)
+}
+
+func TestUsageDelta_StoredSinceLosesNanoseconds(t *testing.T) {
+	since := time.Date(2026, 2, 16, 9, 0, 0, 123456789, time.UTC)
+	// Postgres gives the last sample back with microseconds only.
+	last := &UsageSample{Since: since.Truncate(time.Microsecond), UsageCounters: UsageCounters{MessagesIn: 5}}
+	sample := UsageSample{Since: since, UsageCounters: UsageCounters{MessagesIn: 7}}
+
+	assert.Equal(t, UsageCounters{MessagesIn: 2}, usageDelta(last, sample))
+	sample.Since = since.Add(time.Second)
+	assert.Equal(t, UsageCounters{MessagesIn: 7}, usageDelta(last, sample), "a restarted gateway counts anew")
+}
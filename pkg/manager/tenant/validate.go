@@ -0,0 +1,221 @@
+package tenant
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+// providerAliases maps the provider names picoclaw accepts in
+// agents.defaults.provider to the providers section they read. CLI-backed
+// providers are left out: the tenant image does not ship their binaries.
+var providerAliases = map[string]string{
+	"anthropic":      "anthropic",
+	"claude":         "anthropic",
+	"openai":         "openai",
+	"gpt":            "openai",
+	"openrouter":     "openrouter",
+	"groq":           "groq",
+	"zhipu":          "zhipu",
+	"glm":            "zhipu",
+	"vllm":           "vllm",
+	"gemini":         "gemini",
+	"google":         "gemini",
+	"nvidia":         "nvidia",
+	"ollama":         "ollama",
+	"moonshot":       "moonshot",
+	"shengsuanyun":   "shengsuanyun",
+	"deepseek":       "deepseek",
+	"github_copilot": "github_copilot",
+	"copilot":        "github_copilot",
+}
+
+func providerNames() []interface{} {
+	names := make([]string, 0, len(providerAliases))
+	for name := range providerAliases {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	values := make([]interface{}, len(names))
+	for i, name := range names {
+		values[i] = name
+	}
+	return values
+}
+
+// modelNameRegex accepts names like "gpt-4o", "anthropic/claude-sonnet-4"
+// and "llama3.1:8b".
+var modelNameRegex = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._:/@+-]{0,199}$`)
+
+// validateConfig checks a tenant config and its secrets against the config
+// schema, then against the rules picoclaw needs to start: known and
+// configured providers, valid model names, credentials for enabled
+// channels and distinct listen ports. It returns a *ValidationError.
+func validateConfig(configJSON json.RawMessage, secrets map[string]string) error {
+	var raw map[string]interface{}
+	if err := json.Unmarshal(configJSON, &raw); err != nil {
+		return invalidf("config is not a JSON object: %v", err)
+	}
+	var errs []FieldError
+	ConfigSchema().validate("", raw, &errs)
+	if len(errs) > 0 {
+		return &ValidationError{Fields: errs}
+	}
+
+	cfg := config.DefaultConfig()
+	if err := json.Unmarshal(configJSON, cfg); err != nil {
+		return invalidf("decode config: %v", err)
+	}
+	root := reflect.ValueOf(cfg).Elem()
+	for _, f := range secretFields {
+		if value, ok := secrets[f.env]; ok {
+			root.FieldByIndex(f.index).SetString(value)
+		}
+	}
+
+	errs = append(errs, checkProviders(cfg)...)
+	errs = append(errs, checkModels(cfg)...)
+	errs = append(errs, checkChannels(&cfg.Channels)...)
+	errs = append(errs, checkPorts(cfg)...)
+	if len(errs) > 0 {
+		return &ValidationError{Fields: errs}
+	}
+	return nil
+}
+
+func checkProviders(cfg *config.Config) []FieldError {
+	var errs []FieldError
+	check := func(field, name string) {
+		if name == "" {
+			return
+		}
+		section := providerAliases[name]
+		p := providerConfig(&cfg.Providers, section)
+		switch {
+		case section == "github_copilot":
+		case section == "vllm" && p.APIBase == "":
+			errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf("provider %q needs providers.vllm.api_base", name)})
+		case section != "vllm" && p.APIKey == "":
+			errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf("provider %q needs providers.%s.api_key", name, section)})
+		}
+	}
+	check("agents.defaults.provider", cfg.Agents.Defaults.Provider)
+	for _, name := range sortedKeys(cfg.Agents.Instances) {
+		check("agents.instances."+name+".provider", cfg.Agents.Instances[name].Provider)
+	}
+	return errs
+}
+
+// providerConfig returns the providers section with the given JSON name.
+func providerConfig(providers *config.ProvidersConfig, name string) config.ProviderConfig {
+	v := reflect.ValueOf(providers).Elem()
+	for i := 0; i < v.NumField(); i++ {
+		if tag, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ","); tag == name {
+			return v.Field(i).Interface().(config.ProviderConfig)
+		}
+	}
+	return config.ProviderConfig{}
+}
+
+func checkModels(cfg *config.Config) []FieldError {
+	var errs []FieldError
+	check := func(field, model string, required bool) {
+		switch {
+		case model == "" && required:
+			errs = append(errs, FieldError{Field: field, Message: "is required"})
+		case model != "" && !modelNameRegex.MatchString(model):
+			errs = append(errs, FieldError{Field: field, Message: fmt.Sprintf("invalid model name %q", model)})
+		}
+	}
+	check("agents.defaults.model", cfg.Agents.Defaults.Model, true)
+	for _, name := range sortedKeys(cfg.Agents.Profiles) {
+		check("agents.profiles."+name+".model", cfg.Agents.Profiles[name].Model, false)
+	}
+	for _, name := range sortedKeys(cfg.Agents.Instances) {
+		check("agents.instances."+name+".model", cfg.Agents.Instances[name].Model, false)
+	}
+	return errs
+}
+
+// checkChannels requires the credentials and endpoints of every enabled
+// channel.
+func checkChannels(c *config.ChannelsConfig) []FieldError {
+	type required struct {
+		field string
+		value string
+	}
+	channels := []struct {
+		name     string
+		enabled  bool
+		required []required
+	}{
+		{"whatsapp", c.WhatsApp.Enabled, []required{{"bridge_url", c.WhatsApp.BridgeURL}}},
+		{"telegram", c.Telegram.Enabled, []required{{"token", c.Telegram.Token}}},
+		{"feishu", c.Feishu.Enabled, []required{{"app_id", c.Feishu.AppID}, {"app_secret", c.Feishu.AppSecret}}},
+		{"discord", c.Discord.Enabled, []required{{"token", c.Discord.Token}}},
+		{"qq", c.QQ.Enabled, []required{{"app_id", c.QQ.AppID}, {"app_secret", c.QQ.AppSecret}}},
+		{"dingtalk", c.DingTalk.Enabled, []required{{"client_id", c.DingTalk.ClientID}, {"client_secret", c.DingTalk.ClientSecret}}},
+		{"slack", c.Slack.Enabled, []required{{"bot_token", c.Slack.BotToken}, {"app_token", c.Slack.AppToken}}},
+		{"line", c.LINE.Enabled, []required{{"channel_secret", c.LINE.ChannelSecret}, {"channel_access_token", c.LINE.ChannelAccessToken}}},
+		{"onebot", c.OneBot.Enabled, []required{{"ws_url", c.OneBot.WSUrl}}},
+	}
+	var errs []FieldError
+	for _, ch := range channels {
+		if !ch.enabled {
+			continue
+		}
+		for _, r := range ch.required {
+			if r.value == "" {
+				errs = append(errs, FieldError{
+					Field:   "channels." + ch.name + "." + r.field,
+					Message: fmt.Sprintf("is required when %s is enabled", ch.name),
+				})
+			}
+		}
+	}
+	return errs
+}
+
+// checkPorts rejects ports out of range and enabled listeners sharing a
+// port with each other or the gateway.
+func checkPorts(cfg *config.Config) []FieldError {
+	type listener struct {
+		field string
+		port  int
+	}
+	listeners := []listener{{"gateway.port", cfg.Gateway.Port}}
+	if cfg.Channels.MaixCam.Enabled {
+		listeners = append(listeners, listener{"channels.maixcam.port", cfg.Channels.MaixCam.Port})
+	}
+	if cfg.Channels.LINE.Enabled {
+		listeners = append(listeners, listener{"channels.line.webhook_port", cfg.Channels.LINE.WebhookPort})
+	}
+
+	var errs []FieldError
+	used := make(map[int]string)
+	for _, l := range listeners {
+		switch other, taken := used[l.port]; {
+		case l.port < 1 || l.port > 65535:
+			errs = append(errs, FieldError{Field: l.field, Message: fmt.Sprintf("port %d out of range", l.port)})
+		case taken:
+			errs = append(errs, FieldError{Field: l.field, Message: fmt.Sprintf("port %d is already used by %s", l.port, other)})
+		default:
+			used[l.port] = l.field
+		}
+	}
+	return errs
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
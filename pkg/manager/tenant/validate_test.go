@@ -0,0 +1,106 @@
+package tenant
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func validate(t *testing.T, providers, agents, channels map[string]interface{}) []FieldError {
+	t.Helper()
+	configJSON, secrets, err := buildConfigJSON(providers, agents, channels)
+	require.NoError(t, err)
+	err = validateConfig(configJSON, secrets)
+	if err == nil {
+		return nil
+	}
+	var invalid *ValidationError
+	require.True(t, errors.As(err, &invalid), "unexpected error: %v", err)
+	assert.True(t, errors.Is(err, ErrInvalid))
+	return invalid.Fields
+}
+
+func TestValidateConfig_Valid(t *testing.T) {
+	fields := validate(t,
+		map[string]interface{}{"anthropic": map[string]interface{}{"api_key": "sk-ant"}},
+		map[string]interface{}{"defaults": map[string]interface{}{
+			"provider": "anthropic", "model": "claude-sonnet-4", "max_tokens": 8192, "temperature": 0.2,
+		}},
+		map[string]interface{}{"telegram": map[string]interface{}{
+			"enabled": true, "token": "123:abc", "allow_from": []interface{}{123456, "alice"},
+		}},
+	)
+	assert.Empty(t, fields)
+}
+
+func TestValidateConfig_Schema(t *testing.T) {
+	fields := validate(t,
+		map[string]interface{}{"anthropc": map[string]interface{}{"api_key": "sk"}},
+		map[string]interface{}{"defaults": map[string]interface{}{"max_tokens": 1.5, "provider": "mistral"}},
+		map[string]interface{}{"telegram": map[string]interface{}{"enabled": "yes", "allow_from": []interface{}{true}}},
+	)
+	assert.Equal(t, []FieldError{
+		{Field: "agents.defaults.max_tokens", Message: "expected integer, got number"},
+		{Field: "agents.defaults.provider", Message: `unknown value "mistral"`},
+		{Field: "channels.telegram.allow_from[0]", Message: "expected string or number, got boolean"},
+		{Field: "channels.telegram.enabled", Message: "expected boolean, got string"},
+		{Field: "providers.anthropc", Message: "unknown field"},
+	}, fields)
+}
+
+func TestValidateConfig_Rules(t *testing.T) {
+	fields := validate(t,
+		map[string]interface{}{"openai": map[string]interface{}{"api_base": "https://example.com"}},
+		map[string]interface{}{
+			"defaults": map[string]interface{}{"provider": "gpt", "model": "gpt 4o"},
+		},
+		map[string]interface{}{
+			"telegram": map[string]interface{}{"enabled": true},
+			"slack":    map[string]interface{}{"enabled": true, "bot_token": "xoxb"},
+			"maixcam":  map[string]interface{}{"enabled": true},
+			"line":     map[string]interface{}{"enabled": true, "channel_secret": "s", "channel_access_token": "t", "webhook_port": 70000},
+		},
+	)
+	assert.Equal(t, []FieldError{
+		{Field: "agents.defaults.provider", Message: `provider "gpt" needs providers.openai.api_key`},
+		{Field: "agents.defaults.model", Message: `invalid model name "gpt 4o"`},
+		{Field: "channels.telegram.token", Message: "is required when telegram is enabled"},
+		{Field: "channels.slack.app_token", Message: "is required when slack is enabled"},
+		{Field: "channels.maixcam.port", Message: "port 18790 is already used by gateway.port"},
+		{Field: "channels.line.webhook_port", Message: "port 70000 out of range"},
+	}, fields)
+}
+
+func TestService_RejectsInvalidConfig(t *testing.T) {
+	svc, store, _ := newTestService(t)
+	ctx := context.Background()
+
+	req := acmeRequest
+	req.Channels = map[string]interface{}{"discord": map[string]interface{}{"enabled": true}}
+	_, err := svc.Create(ctx, req)
+	var invalid *ValidationError
+	require.True(t, errors.As(err, &invalid), "create: %v", err)
+	assert.Equal(t, "channels.discord.token", invalid.Fields[0].Field)
+	tenant, _ := store.Get("acme")
+	assert.Nil(t, tenant, "nothing is stored")
+
+	// A stored secret satisfies the rules on update.
+	req.Channels = map[string]interface{}{"discord": map[string]interface{}{"enabled": false, "token": "abc"}}
+	op, err := svc.Create(ctx, req)
+	require.NoError(t, err)
+	finish(t, svc, op)
+	op, err = svc.Update(ctx, "acme", UpdateRequest{
+		Channels: map[string]interface{}{"discord": map[string]interface{}{"enabled": true}},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, OpSucceeded, finish(t, svc, op).Status)
+
+	_, err = svc.Update(ctx, "acme", UpdateRequest{
+		Agents: map[string]interface{}{"defaults": map[string]interface{}{"modle": "gpt-4o"}},
+	})
+	assert.True(t, errors.As(err, &invalid), "update: %v", err)
+	assert.Equal(t, []FieldError{{Field: "agents.defaults.modle", Message: "unknown field"}}, invalid.Fields)
+}
@@ -0,0 +1,116 @@
+package memory
+
+import (
+	"math"
+	"strings"
+	"unicode"
+)
+
+// BM25 parameters, the usual defaults.
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+var stopWords = map[string]bool{
+	"a": true, "an": true, "and": true, "are": true, "as": true, "at": true,
+	"be": true, "by": true, "for": true, "from": true, "has": true, "have": true,
+	"i": true, "in": true, "is": true, "it": true, "its": true, "me": true,
+	"my": true, "of": true, "on": true, "or": true, "that": true, "the": true,
+	"this": true, "to": true, "was": true, "were": true, "what": true,
+	"when": true, "where": true, "which": true, "who": true, "with": true,
+	"you": true, "your": true,
+}
+
+// tokenize lowercases text and splits it into words. Han, kana and hangul
+// characters have no spaces between words, so each becomes its own token.
+func tokenize(text string) []string {
+	var tokens []string
+	var word strings.Builder
+	flush := func() {
+		if word.Len() > 0 {
+			if w := word.String(); !stopWords[w] {
+				tokens = append(tokens, w)
+			}
+			word.Reset()
+		}
+	}
+
+	for _, r := range strings.ToLower(text) {
+		switch {
+		case unicode.In(r, unicode.Han, unicode.Hiragana, unicode.Katakana, unicode.Hangul):
+			flush()
+			tokens = append(tokens, string(r))
+		case unicode.IsLetter(r) || unicode.IsDigit(r):
+			word.WriteRune(r)
+		default:
+			flush()
+		}
+	}
+	flush()
+	return tokens
+}
+
+// bm25Scores scores every document against the query terms.
+func bm25Scores(docs [][]string, query []string) []float64 {
+	scores := make([]float64, len(docs))
+	if len(docs) == 0 || len(query) == 0 {
+		return scores
+	}
+
+	var totalLen int
+	df := make(map[string]int)
+	tfs := make([]map[string]int, len(docs))
+	for i, doc := range docs {
+		totalLen += len(doc)
+		tf := make(map[string]int, len(doc))
+		for _, term := range doc {
+			tf[term]++
+		}
+		for term := range tf {
+			df[term]++
+		}
+		tfs[i] = tf
+	}
+	avgLen := float64(totalLen) / float64(len(docs))
+	if avgLen == 0 {
+		return scores
+	}
+
+	seen := make(map[string]bool, len(query))
+	n := float64(len(docs))
+	for _, term := range query {
+		if seen[term] || df[term] == 0 {
+			continue
+		}
+		seen[term] = true
+		idf := math.Log(1 + (n-float64(df[term])+0.5)/(float64(df[term])+0.5))
+		for i, tf := range tfs {
+			f := float64(tf[term])
+			if f == 0 {
+				continue
+			}
+			norm := 1 - bm25B + bm25B*float64(len(docs[i]))/avgLen
+			scores[i] += idf * f * (bm25K1 + 1) / (f + bm25K1*norm)
+		}
+	}
+	return scores
+}
+
+// cosine returns the cosine similarity of two vectors, or 0 when they
+// cannot be compared.
+func cosine(a, b []float32) float64 {
+	if len(a) == 0 || len(a) != len(b) {
+		return 0
+	}
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+	if na == 0 || nb == 0 {
+		return 0
+	}
+	return dot / (math.Sqrt(na) * math.Sqrt(nb))
+}
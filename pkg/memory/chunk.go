@@ -0,0 +1,87 @@
+package memory
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strings"
+)
+
+// maxChunkLen caps the size of a paragraph chunk; longer paragraphs are
+// split on line boundaries.
+const maxChunkLen = 1000
+
+// Chunk is a searchable piece of memory: one list item or paragraph of a
+// memory file, or one session summary.
+type Chunk struct {
+	ID      string    `json:"id"`
+	Source  string    `json:"source"`            // path relative to the workspace, or "session:<key>"
+	Heading string    `json:"heading,omitempty"` // nearest markdown heading above the chunk
+	Text    string    `json:"text"`
+	Vector  []float32 `json:"vector,omitempty"`
+}
+
+// chunkID derives a stable ID from the chunk's source and text, so IDs
+// survive a reindex as long as the text does not change.
+func chunkID(source, text string) string {
+	sum := sha256.Sum256([]byte(source + "\n" + text))
+	return hex.EncodeToString(sum[:4])
+}
+
+func isListItem(line string) bool {
+	trimmed := strings.TrimLeft(line, " \t")
+	if len(trimmed) != len(line) {
+		return false
+	}
+	if strings.HasPrefix(line, "- ") || strings.HasPrefix(line, "* ") || strings.HasPrefix(line, "+ ") {
+		return true
+	}
+	i := 0
+	for i < len(line) && line[i] >= '0' && line[i] <= '9' {
+		i++
+	}
+	return i > 0 && strings.HasPrefix(line[i:], ". ")
+}
+
+// chunkMarkdown splits a markdown document into chunks. Every top-level list
+// item, together with its indented continuation lines, is a chunk of its
+// own, so single facts can be recalled and forgotten. Other text is chunked
+// by paragraph. Headings are not chunks but label the chunks below them.
+func chunkMarkdown(source, content string) []Chunk {
+	var chunks []Chunk
+	var heading string
+	var block []string
+
+	flush := func() {
+		text := strings.TrimSpace(strings.Join(block, "\n"))
+		block = nil
+		if text == "" {
+			return
+		}
+		for len(text) > maxChunkLen {
+			cut := strings.LastIndex(text[:maxChunkLen], "\n")
+			if cut <= 0 {
+				break
+			}
+			chunks = append(chunks, Chunk{ID: chunkID(source, text[:cut]), Source: source, Heading: heading, Text: text[:cut]})
+			text = strings.TrimSpace(text[cut:])
+		}
+		chunks = append(chunks, Chunk{ID: chunkID(source, text), Source: source, Heading: heading, Text: text})
+	}
+
+	for _, line := range strings.Split(content, "\n") {
+		switch {
+		case strings.HasPrefix(line, "#"):
+			flush()
+			heading = strings.TrimSpace(strings.TrimLeft(line, "#"))
+		case strings.TrimSpace(line) == "":
+			flush()
+		case isListItem(line):
+			flush()
+			block = append(block, line)
+		default:
+			block = append(block, line)
+		}
+	}
+	flush()
+	return chunks
+}
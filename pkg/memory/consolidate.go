@@ -0,0 +1,473 @@
+package memory
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+const (
+	// ArchiveDir holds daily notes that have been consolidated, relative to
+	// the workspace. It is not indexed: its facts live on in MEMORY.md.
+	ArchiveDir = "memory/archive"
+	// DiffDir holds one diff of MEMORY.md per consolidation run.
+	DiffDir = "memory/consolidation"
+
+	// DefaultConsolidateAfterDays is how old a daily note must be before it
+	// is consolidated.
+	DefaultConsolidateAfterDays = 7
+
+	consolidationStateFile = "memory/.consolidation.json"
+	consolidateInterval    = 24 * time.Hour
+	// consolidateCheckInterval is how often Start checks whether a run is due.
+	consolidateCheckInterval = time.Hour
+	// maxConsolidateInput caps the notes and summaries sent in one run; the
+	// rest waits for the next run.
+	maxConsolidateInput = 60000
+	// minKeptFraction guards against a reply that drops most of MEMORY.md.
+	minKeptFraction = 0.3
+)
+
+const consolidatePrompt = `You maintain the long-term memory of a personal AI assistant. You are given the current MEMORY.md and new material: old daily notes and summaries of past conversations.
+
+Rewrite MEMORY.md so it also contains every durable fact from the new material: preferences, personal details, people, decisions, ongoing projects, important dates.
+
+Rules:
+- Start with the "# Long-term Memory" heading and group facts under "## " sections by topic. Keep the existing sections and add new ones as needed.
+- Write one fact per "- " list item.
+- Merge duplicate and near-duplicate facts into one item. When facts conflict, keep the newer one.
+- Keep existing facts unless the new material shows they are outdated.
+- Leave out transient details such as greetings, finished one-off tasks and tool output.
+
+Reply with the complete new MEMORY.md only, without commentary or code fences.`
+
+type consolidationState struct {
+	LastRun  time.Time         `json:"last_run"`
+	Sessions map[string]string `json:"sessions"` // session key -> hash of the summary last consolidated
+}
+
+// ConsolidationReport describes what a consolidation run did.
+type ConsolidationReport struct {
+	Notes    []string // archived daily notes, relative to the workspace
+	Sessions []string // keys of the session summaries that were consolidated
+	DiffFile string   // diff of MEMORY.md relative to the workspace; "" if unchanged
+	Diff     string
+}
+
+// Consolidator distills old daily notes and session summaries into
+// MEMORY.md with the LLM, then archives the notes. Every run that changes
+// MEMORY.md leaves a diff in DiffDir for review.
+type Consolidator struct {
+	workspace string
+	provider  providers.LLMProvider
+	model     string
+	afterDays int
+	index     *Index
+	sessions  bool
+
+	running sync.Mutex
+	now     func() time.Time
+}
+
+// NewConsolidator creates a consolidator for workspace that uses model on
+// provider.
+func NewConsolidator(workspace string, provider providers.LLMProvider, model string) *Consolidator {
+	return &Consolidator{
+		workspace: workspace,
+		provider:  provider,
+		model:     model,
+		afterDays: DefaultConsolidateAfterDays,
+		sessions:  true,
+		now:       time.Now,
+	}
+}
+
+// SetAfterDays sets how many days old a daily note must be to be
+// consolidated.
+func (c *Consolidator) SetAfterDays(days int) {
+	if days > 0 {
+		c.afterDays = days
+	}
+}
+
+// SetIncludeSessions sets whether session summaries are consolidated. With
+// per-user memory they are left out, since MEMORY.md is shared by everyone.
+func (c *Consolidator) SetIncludeSessions(include bool) {
+	c.sessions = include
+}
+
+// SetIndex sets the index to refresh after a run.
+func (c *Consolidator) SetIndex(index *Index) {
+	c.index = index
+}
+
+// Start checks for a due consolidation now and then every hour in the
+// background, until ctx is cancelled.
+func (c *Consolidator) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(consolidateCheckInterval)
+		defer ticker.Stop()
+		for {
+			c.RunIfDue(ctx)
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// RunIfDue runs a consolidation if none has run in the last day. It is meant
+// to be called periodically, as Start does.
+func (c *Consolidator) RunIfDue(ctx context.Context) {
+	if !c.running.TryLock() {
+		return
+	}
+	state := c.loadState()
+	c.running.Unlock()
+	if c.now().Sub(state.LastRun) < consolidateInterval {
+		return
+	}
+
+	report, err := c.Run(ctx)
+	if err != nil {
+		logger.WarnCF("memory", "Memory consolidation failed", map[string]interface{}{"error": err.Error()})
+		return
+	}
+	if len(report.Notes) > 0 || len(report.Sessions) > 0 {
+		logger.InfoCF("memory", "Memory consolidated", map[string]interface{}{
+			"notes":     len(report.Notes),
+			"sessions":  len(report.Sessions),
+			"diff_file": report.DiffFile,
+		})
+	}
+}
+
+// Run consolidates now, regardless of when the last run was.
+func (c *Consolidator) Run(ctx context.Context) (*ConsolidationReport, error) {
+	if !c.running.TryLock() {
+		return nil, fmt.Errorf("consolidation already running")
+	}
+	defer c.running.Unlock()
+
+	state := c.loadState()
+	report := &ConsolidationReport{}
+
+	var material strings.Builder
+	size := 0
+	var notesText strings.Builder
+	for _, note := range c.oldNotes() {
+		data, err := os.ReadFile(filepath.Join(c.workspace, note))
+		if err != nil {
+			continue
+		}
+		if size > 0 && size+len(data) > maxConsolidateInput {
+			break
+		}
+		size += len(data)
+		fmt.Fprintf(&notesText, "### %s\n\n%s\n\n", note, strings.TrimSpace(string(data)))
+		report.Notes = append(report.Notes, note)
+	}
+
+	var summaries map[string]string
+	if c.sessions {
+		summaries = c.newSummaries(state)
+	}
+	var sessionsText strings.Builder
+	hashes := make(map[string]string)
+	keys := make([]string, 0, len(summaries))
+	for key := range summaries {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		summary := summaries[key]
+		if size > 0 && size+len(summary) > maxConsolidateInput {
+			break
+		}
+		size += len(summary)
+		fmt.Fprintf(&sessionsText, "### %s\n\n%s\n\n", key, strings.TrimSpace(summary))
+		report.Sessions = append(report.Sessions, key)
+		hashes[key] = summaryHash(summary)
+	}
+
+	if len(report.Notes) == 0 && len(report.Sessions) == 0 {
+		state.LastRun = c.now()
+		return report, c.saveState(state)
+	}
+
+	memoryPath := filepath.Join(c.workspace, LongTermFile)
+	oldMemory := ""
+	if data, err := os.ReadFile(memoryPath); err == nil {
+		oldMemory = string(data)
+	}
+
+	fmt.Fprintf(&material, "## Current MEMORY.md\n\n%s\n\n", strings.TrimSpace(oldMemory))
+	if notesText.Len() > 0 {
+		material.WriteString("## Daily notes\n\n" + notesText.String())
+	}
+	if sessionsText.Len() > 0 {
+		material.WriteString("## Conversation summaries\n\n" + sessionsText.String())
+	}
+
+	response, err := c.provider.Chat(ctx, []providers.Message{
+		{Role: "system", Content: consolidatePrompt},
+		{Role: "user", Content: material.String()},
+	}, nil, c.model, map[string]interface{}{
+		"max_tokens":  8192,
+		"temperature": 0.2,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("LLM call failed: %w", err)
+	}
+
+	newMemory := dedupeFacts(stripCodeFence(response.Content))
+	if strings.TrimSpace(newMemory) == "" {
+		return nil, fmt.Errorf("LLM returned an empty memory file")
+	}
+	if len(oldMemory) > 500 && float64(len(newMemory)) < minKeptFraction*float64(len(oldMemory)) {
+		return nil, fmt.Errorf("LLM reply would shrink MEMORY.md from %d to %d bytes; not applied", len(oldMemory), len(newMemory))
+	}
+
+	if err := c.apply(memoryPath, oldMemory, newMemory, report); err != nil {
+		return nil, err
+	}
+
+	for _, note := range report.Notes {
+		if err := c.archive(note); err != nil {
+			logger.WarnCF("memory", "Failed to archive daily note", map[string]interface{}{"note": note, "error": err.Error()})
+		}
+	}
+
+	if state.Sessions == nil {
+		state.Sessions = make(map[string]string)
+	}
+	for key, hash := range hashes {
+		state.Sessions[key] = hash
+	}
+	state.LastRun = c.now()
+	if err := c.saveState(state); err != nil {
+		return nil, err
+	}
+
+	if c.index != nil {
+		if err := c.index.Refresh(ctx); err != nil {
+			logger.WarnCF("memory", "Failed to save memory index", map[string]interface{}{"error": err.Error()})
+		}
+	}
+	return report, nil
+}
+
+// apply writes the consolidated MEMORY.md and its diff. The LLM call takes
+// a while, so the file is read again under the index lock that remember and
+// forget write under: facts appended in the meantime are kept, and any other
+// change aborts the run so that nothing is lost.
+func (c *Consolidator) apply(memoryPath, oldMemory, newMemory string, report *ConsolidationReport) error {
+	if c.index != nil {
+		c.index.mu.Lock()
+		defer c.index.mu.Unlock()
+	}
+
+	current := ""
+	if data, err := os.ReadFile(memoryPath); err == nil {
+		current = string(data)
+	}
+	newMemory, err := mergeConcurrentEdits(oldMemory, current, newMemory)
+	if err != nil {
+		return err
+	}
+
+	stamp := c.now().Format("20060102-150405")
+	report.Diff = UnifiedDiff("a/"+LongTermFile, "b/"+LongTermFile, current, newMemory)
+	if report.Diff == "" {
+		return nil
+	}
+	report.DiffFile = DiffDir + "/" + stamp + ".diff"
+	var header strings.Builder
+	fmt.Fprintf(&header, "# Memory consolidation %s\n", c.now().Format("2006-01-02 15:04:05"))
+	for _, note := range report.Notes {
+		fmt.Fprintf(&header, "# note: %s\n", note)
+	}
+	for _, key := range report.Sessions {
+		fmt.Fprintf(&header, "# session: %s\n", key)
+	}
+	if err := writeFileAtomic(filepath.Join(c.workspace, report.DiffFile), []byte(header.String()+report.Diff), 0644); err != nil {
+		return fmt.Errorf("failed to write diff: %w", err)
+	}
+	if err := writeFileAtomic(memoryPath, []byte(newMemory), 0644); err != nil {
+		return fmt.Errorf("failed to write MEMORY.md: %w", err)
+	}
+	return nil
+}
+
+// mergeConcurrentEdits reconciles the consolidated memory with changes made
+// to MEMORY.md since old was read. Lines appended since then, as remember
+// does, are added to updated; any other edit is reported as an error.
+func mergeConcurrentEdits(old, current, updated string) (string, error) {
+	if current == old {
+		return updated, nil
+	}
+	if !strings.HasPrefix(current, old) {
+		return "", fmt.Errorf("MEMORY.md changed during consolidation; not applied")
+	}
+	appended := strings.TrimLeft(current[len(old):], "\n")
+	if !strings.HasSuffix(updated, "\n") {
+		updated += "\n"
+	}
+	return dedupeFacts(updated + appended), nil
+}
+
+var dailyNotePattern = regexp.MustCompile(`^\d{6}/(\d{8})\.md$`)
+
+// oldNotes returns the daily notes older than afterDays, oldest first.
+func (c *Consolidator) oldNotes() []string {
+	today := c.now()
+	cutoff := time.Date(today.Year(), today.Month(), today.Day(), 0, 0, 0, 0, today.Location()).AddDate(0, 0, -c.afterDays)
+
+	paths, _ := filepath.Glob(filepath.Join(c.workspace, "memory", "*", "*.md"))
+	var notes []string
+	for _, path := range paths {
+		rel, _ := filepath.Rel(filepath.Join(c.workspace, "memory"), path)
+		m := dailyNotePattern.FindStringSubmatch(filepath.ToSlash(rel))
+		if m == nil {
+			continue
+		}
+		date, err := time.ParseInLocation("20060102", m[1], today.Location())
+		if err != nil || !date.Before(cutoff) {
+			continue
+		}
+		notes = append(notes, "memory/"+filepath.ToSlash(rel))
+	}
+	sort.Strings(notes)
+	return notes
+}
+
+// newSummaries returns the session summaries that changed since they were
+// last consolidated, keyed by session.
+func (c *Consolidator) newSummaries(state consolidationState) map[string]string {
+	paths, _ := filepath.Glob(filepath.Join(c.workspace, "sessions", "*.json"))
+	summaries := make(map[string]string)
+	for _, path := range paths {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			continue
+		}
+		var stored struct {
+			Key     string `json:"key"`
+			Summary string `json:"summary"`
+		}
+		if json.Unmarshal(data, &stored) != nil || strings.TrimSpace(stored.Summary) == "" {
+			continue
+		}
+		if state.Sessions[stored.Key] == summaryHash(stored.Summary) {
+			continue
+		}
+		summaries[stored.Key] = stored.Summary
+	}
+	return summaries
+}
+
+func summaryHash(summary string) string {
+	sum := sha256.Sum256([]byte(summary))
+	return hex.EncodeToString(sum[:8])
+}
+
+// archive moves a consolidated daily note into ArchiveDir.
+func (c *Consolidator) archive(note string) error {
+	src := filepath.Join(c.workspace, filepath.FromSlash(note))
+	dst := filepath.Join(c.workspace, ArchiveDir, filepath.FromSlash(strings.TrimPrefix(note, "memory/")))
+	if err := os.MkdirAll(filepath.Dir(dst), 0755); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		return err
+	}
+	// Drop the month directory once it is empty.
+	os.Remove(filepath.Dir(src))
+	return nil
+}
+
+func (c *Consolidator) loadState() consolidationState {
+	var state consolidationState
+	if data, err := os.ReadFile(filepath.Join(c.workspace, consolidationStateFile)); err == nil {
+		json.Unmarshal(data, &state)
+	}
+	return state
+}
+
+func (c *Consolidator) saveState(state consolidationState) error {
+	data, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(c.workspace, consolidationStateFile), data, 0600)
+}
+
+func writeFileAtomic(path string, data []byte, perm os.FileMode) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, perm); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// stripCodeFence removes a ``` fence around the whole reply, which models
+// add despite being asked not to.
+func stripCodeFence(s string) string {
+	s = strings.TrimSpace(s)
+	if !strings.HasPrefix(s, "```") {
+		return s + "\n"
+	}
+	if i := strings.Index(s, "\n"); i >= 0 {
+		s = s[i+1:]
+	}
+	s = strings.TrimSuffix(strings.TrimSpace(s), "```")
+	return strings.TrimSpace(s) + "\n"
+}
+
+var (
+	listMarker     = regexp.MustCompile(`^(?:[-*+]|\d+\.)\s+`)
+	factDateSuffix = regexp.MustCompile(`\s*\(\d{4}-\d{2}-\d{2}\)$`)
+)
+
+// normalizeFact reduces a list item to a comparable form: lowercase, single
+// spaces, without the date remember appends or trailing punctuation.
+func normalizeFact(line string) string {
+	fact := listMarker.ReplaceAllString(strings.TrimSpace(line), "")
+	fact = factDateSuffix.ReplaceAllString(fact, "")
+	fact = strings.ToLower(strings.Join(strings.Fields(fact), " "))
+	return strings.TrimRight(fact, ".;!")
+}
+
+// dedupeFacts drops list items that repeat an earlier item.
+func dedupeFacts(content string) string {
+	seen := make(map[string]bool)
+	var out []string
+	for _, line := range strings.Split(content, "\n") {
+		if isListItem(line) {
+			key := normalizeFact(line)
+			if key != "" && seen[key] {
+				continue
+			}
+			seen[key] = true
+		}
+		out = append(out, line)
+	}
+	return strings.Join(out, "\n")
+}
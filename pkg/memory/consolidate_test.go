@@ -0,0 +1,253 @@
+package memory
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// scriptedProvider replies with a fixed memory file and records prompts.
+type scriptedProvider struct {
+	reply   string
+	prompts []string
+}
+
+func (p *scriptedProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, options map[string]interface{}) (*providers.LLMResponse, error) {
+	p.prompts = append(p.prompts, messages[len(messages)-1].Content)
+	return &providers.LLMResponse{Content: p.reply}, nil
+}
+
+func (p *scriptedProvider) GetDefaultModel() string {
+	return "test"
+}
+
+func newConsolidationWorkspace(t *testing.T) string {
+	t.Helper()
+	ws := t.TempDir()
+	writeFile(t, filepath.Join(ws, "memory", "MEMORY.md"), "# Long-term Memory\n\n## Preferences\n\n- Likes green tea\n")
+	writeFile(t, filepath.Join(ws, "memory", "202401", "20240102.md"), "# 2024-01-02\n\nUser adopted a dog called Biscuit.\n")
+	writeFile(t, filepath.Join(ws, "memory", "202401", "20240125.md"), "# 2024-01-25\n\nUser is learning Portuguese.\n")
+	writeFile(t, filepath.Join(ws, "sessions", "telegram_1.json"), `{"key":"telegram:1","summary":"User plans a trip to Lisbon in March."}`)
+	return ws
+}
+
+func TestConsolidate(t *testing.T) {
+	ws := newConsolidationWorkspace(t)
+	provider := &scriptedProvider{reply: "```markdown\n# Long-term Memory\n\n## Preferences\n\n- Likes green tea\n- likes green tea.\n\n## Pets\n\n- Has a dog called Biscuit\n\n## Travel\n\n- Trip to Lisbon in March\n```"}
+	c := NewConsolidator(ws, provider, "test")
+	c.now = func() time.Time { return time.Date(2024, 1, 28, 10, 0, 0, 0, time.Local) }
+
+	report, err := c.Run(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Only the note older than a week is consolidated.
+	if len(report.Notes) != 1 || report.Notes[0] != "memory/202401/20240102.md" {
+		t.Errorf("notes = %v", report.Notes)
+	}
+	if len(report.Sessions) != 1 || report.Sessions[0] != "telegram:1" {
+		t.Errorf("sessions = %v", report.Sessions)
+	}
+	prompt := provider.prompts[0]
+	for _, want := range []string{"Likes green tea", "Biscuit", "Lisbon"} {
+		if !strings.Contains(prompt, want) {
+			t.Errorf("prompt is missing %q", want)
+		}
+	}
+	if strings.Contains(prompt, "Portuguese") {
+		t.Error("prompt includes a recent daily note")
+	}
+
+	data, _ := os.ReadFile(filepath.Join(ws, LongTermFile))
+	memory := string(data)
+	if strings.Contains(memory, "```") || strings.Count(strings.ToLower(memory), "likes green tea") != 1 {
+		t.Errorf("MEMORY.md not cleaned up:\n%s", memory)
+	}
+	if !strings.Contains(memory, "- Has a dog called Biscuit") {
+		t.Errorf("MEMORY.md missing consolidated fact:\n%s", memory)
+	}
+
+	if _, err := os.Stat(filepath.Join(ws, ArchiveDir, "202401", "20240102.md")); err != nil {
+		t.Errorf("note not archived: %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(ws, "memory", "202401", "20240102.md")); !os.IsNotExist(err) {
+		t.Error("archived note still in place")
+	}
+	if _, err := os.Stat(filepath.Join(ws, "memory", "202401", "20240125.md")); err != nil {
+		t.Error("recent note was moved")
+	}
+
+	if report.DiffFile != DiffDir+"/20240128-100000.diff" {
+		t.Errorf("diff file = %q", report.DiffFile)
+	}
+	diff, _ := os.ReadFile(filepath.Join(ws, report.DiffFile))
+	for _, want := range []string{"# note: memory/202401/20240102.md", "# session: telegram:1", "+- Has a dog called Biscuit", " - Likes green tea"} {
+		if !strings.Contains(string(diff), want) {
+			t.Errorf("diff missing %q:\n%s", want, diff)
+		}
+	}
+
+	// Nothing new: no LLM call. A changed summary is picked up again.
+	if report, err := c.Run(context.Background()); err != nil || len(report.Notes)+len(report.Sessions) != 0 {
+		t.Errorf("second run = %+v, %v", report, err)
+	}
+	if len(provider.prompts) != 1 {
+		t.Errorf("LLM called %d times, want 1", len(provider.prompts))
+	}
+	writeFile(t, filepath.Join(ws, "sessions", "telegram_1.json"), `{"key":"telegram:1","summary":"User moved the Lisbon trip to April."}`)
+	if report, _ := c.Run(context.Background()); len(report.Sessions) != 1 {
+		t.Errorf("changed summary not consolidated: %+v", report)
+	}
+}
+
+func TestConsolidate_RejectsDestructiveReply(t *testing.T) {
+	ws := newConsolidationWorkspace(t)
+	long := "# Long-term Memory\n\n" + strings.Repeat("- An important fact worth keeping\n", 40)
+	writeFile(t, filepath.Join(ws, LongTermFile), long)
+
+	c := NewConsolidator(ws, &scriptedProvider{reply: "# Long-term Memory\n\n- Only this\n"}, "test")
+	if _, err := c.Run(context.Background()); err == nil {
+		t.Fatal("expected the shrinking reply to be rejected")
+	}
+	data, _ := os.ReadFile(filepath.Join(ws, LongTermFile))
+	if string(data) != long {
+		t.Error("MEMORY.md changed despite the rejected reply")
+	}
+	if _, err := os.Stat(filepath.Join(ws, "memory", "202401", "20240102.md")); err != nil {
+		t.Error("note archived despite the rejected reply")
+	}
+}
+
+// editingProvider changes MEMORY.md while the consolidation call is in flight.
+type editingProvider struct {
+	scriptedProvider
+	edit func()
+}
+
+func (p *editingProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, options map[string]interface{}) (*providers.LLMResponse, error) {
+	p.edit()
+	return p.scriptedProvider.Chat(ctx, messages, tools, model, options)
+}
+
+func TestConsolidate_KeepsFactsRememberedDuringRun(t *testing.T) {
+	ws := newConsolidationWorkspace(t)
+	index := NewIndex(ws)
+	provider := &editingProvider{
+		scriptedProvider: scriptedProvider{reply: "# Long-term Memory\n\n## Preferences\n\n- Likes green tea\n\n## Pets\n\n- Has a dog called Biscuit\n"},
+		edit: func() {
+			if _, err := index.RememberIn(context.Background(), LongTermFile, "Prefers window seats"); err != nil {
+				t.Errorf("RememberIn failed: %v", err)
+			}
+		},
+	}
+	c := NewConsolidator(ws, provider, "test")
+	c.SetIndex(index)
+	c.now = func() time.Time { return time.Date(2024, 1, 28, 10, 0, 0, 0, time.Local) }
+
+	if _, err := c.Run(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(filepath.Join(ws, LongTermFile))
+	for _, want := range []string{"Biscuit", "Prefers window seats"} {
+		if !strings.Contains(string(data), want) {
+			t.Errorf("MEMORY.md is missing %q:\n%s", want, data)
+		}
+	}
+}
+
+func TestConsolidate_AbortsOnConcurrentRewrite(t *testing.T) {
+	ws := newConsolidationWorkspace(t)
+	rewritten := "# Long-term Memory\n\n- Edited by hand\n"
+	provider := &editingProvider{
+		scriptedProvider: scriptedProvider{reply: "# Long-term Memory\n\n- Likes green tea\n- Has a dog called Biscuit\n"},
+		edit:             func() { writeFile(t, filepath.Join(ws, LongTermFile), rewritten) },
+	}
+	c := NewConsolidator(ws, provider, "test")
+
+	if _, err := c.Run(context.Background()); err == nil {
+		t.Fatal("expected the run to abort")
+	}
+	data, _ := os.ReadFile(filepath.Join(ws, LongTermFile))
+	if string(data) != rewritten {
+		t.Errorf("MEMORY.md was overwritten:\n%s", data)
+	}
+	if _, err := os.Stat(filepath.Join(ws, "memory", "202401", "20240102.md")); err != nil {
+		t.Error("note archived despite the aborted run")
+	}
+}
+
+func TestConsolidate_RunIfDue(t *testing.T) {
+	ws := newConsolidationWorkspace(t)
+	provider := &scriptedProvider{reply: "# Long-term Memory\n\n- Likes green tea\n"}
+	c := NewConsolidator(ws, provider, "test")
+	now := time.Date(2024, 1, 28, 10, 0, 0, 0, time.Local)
+	c.now = func() time.Time { return now }
+
+	c.RunIfDue(context.Background())
+	writeFile(t, filepath.Join(ws, "memory", "202401", "20240103.md"), "# 2024-01-03\n\nNew fact.\n")
+	now = now.Add(time.Hour)
+	c.RunIfDue(context.Background())
+	if len(provider.prompts) != 1 {
+		t.Fatalf("ran %d times within a day, want 1", len(provider.prompts))
+	}
+
+	now = now.Add(24 * time.Hour)
+	c.RunIfDue(context.Background())
+	if len(provider.prompts) != 2 {
+		t.Errorf("did not run again after a day")
+	}
+}
+
+func TestConsolidate_Start(t *testing.T) {
+	ws := newConsolidationWorkspace(t)
+	c := NewConsolidator(ws, &scriptedProvider{reply: "# Long-term Memory\n\n- Likes green tea\n"}, "test")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	c.Start(ctx)
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, err := os.Stat(filepath.Join(ws, consolidationStateFile)); err == nil {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("Start did not run a due consolidation")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestUnifiedDiff(t *testing.T) {
+	old := "a\nb\nc\nd\ne\nf\ng\nh\ni\nj\n"
+	updated := "a\nB\nc\nd\ne\nf\ng\nh\ni\nj\nk\n"
+	want := `--- old
++++ new
+@@ -1,5 +1,5 @@
+ a
+-b
++B
+ c
+ d
+ e
+@@ -8,3 +8,4 @@
+ h
+ i
+ j
++k
+`
+	if got := UnifiedDiff("old", "new", old, updated); got != want {
+		t.Errorf("diff =\n%s\nwant\n%s", got, want)
+	}
+	if got := UnifiedDiff("old", "new", old, old); got != "" {
+		t.Errorf("diff of equal texts = %q", got)
+	}
+	if got := UnifiedDiff("old", "new", "", "x\n"); got != "--- old\n+++ new\n@@ -0,0 +1,1 @@\n+x\n" {
+		t.Errorf("diff from empty = %q", got)
+	}
+}
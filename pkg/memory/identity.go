@@ -0,0 +1,288 @@
+package memory
+
+import (
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	identitiesFile = UsersDir + "/identities.json"
+	linkCodeTTL    = 10 * time.Minute
+	// linkCodeLength is the number of random characters in a link code,
+	// drawn from linkCodeAlphabet (about 59 bits).
+	linkCodeLength = 12
+	// maxAccountLinkFailures is how many wrong codes an account may send
+	// within linkCodeTTL before it is locked out of linking until then.
+	// Guesses from many accounts are left to the size of the code space.
+	maxAccountLinkFailures = 5
+)
+
+// linkCodeAlphabet leaves out characters that are easy to confuse.
+const linkCodeAlphabet = "ABCDEFGHJKMNPQRSTUVWXYZ23456789"
+
+type linkCode struct {
+	identity string
+	expires  time.Time
+}
+
+// linkFailures counts an account's wrong codes since since.
+type linkFailures struct {
+	count int
+	since time.Time
+}
+
+// Identities maps chat accounts ("channel:sender_id") to the person behind
+// them, so one person shares a profile and memory across channels. Accounts
+// are linked in config or at runtime with a one-time code; an unlinked
+// account is its own identity.
+type Identities struct {
+	workspace  string
+	configured map[string]string // account -> identity, from config
+	links      map[string]string // account -> identity, from /link
+
+	mu       sync.Mutex
+	pending  map[string]*linkCode
+	failures map[string]*linkFailures // account -> recent wrong codes
+	now      func() time.Time
+}
+
+// NewIdentities loads the identity links of workspace. configured maps an
+// identity name to its accounts, as in the memory.identities config.
+func NewIdentities(workspace string, configured map[string][]string) *Identities {
+	ids := &Identities{
+		workspace:  workspace,
+		configured: make(map[string]string),
+		links:      make(map[string]string),
+		pending:    make(map[string]*linkCode),
+		failures:   make(map[string]*linkFailures),
+		now:        time.Now,
+	}
+	for identity, accounts := range configured {
+		for _, account := range accounts {
+			ids.configured[account] = identity
+		}
+	}
+	if data, err := os.ReadFile(filepath.Join(workspace, identitiesFile)); err == nil {
+		json.Unmarshal(data, &ids.links)
+	}
+	return ids
+}
+
+// accountKey builds the account of a sender. Sender IDs may be
+// "id|username"; only the stable ID part is used.
+func accountKey(channel, senderID string) string {
+	id, _, _ := strings.Cut(senderID, "|")
+	return channel + ":" + id
+}
+
+// Resolve returns the identity of a sender.
+func (ids *Identities) Resolve(channel, senderID string) string {
+	account := accountKey(channel, senderID)
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+	return ids.resolveLocked(account)
+}
+
+func (ids *Identities) resolveLocked(account string) string {
+	if identity, ok := ids.configured[account]; ok {
+		return identity
+	}
+	if identity, ok := ids.links[account]; ok {
+		return identity
+	}
+	return account
+}
+
+// Accounts returns the accounts that belong to identity, sorted.
+func (ids *Identities) Accounts(identity string) []string {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+
+	set := make(map[string]bool)
+	for account, id := range ids.configured {
+		if id == identity {
+			set[account] = true
+		}
+	}
+	for account, id := range ids.links {
+		if id == identity && ids.resolveLocked(account) == identity {
+			set[account] = true
+		}
+	}
+	// An identity named after an account includes that account.
+	if strings.Contains(identity, ":") && ids.resolveLocked(identity) == identity {
+		set[identity] = true
+	}
+
+	accounts := make([]string, 0, len(set))
+	for account := range set {
+		accounts = append(accounts, account)
+	}
+	sort.Strings(accounts)
+	return accounts
+}
+
+// NewLinkCode returns a one-time code that links another account to
+// identity when sent from that account within ten minutes. Codes are shown
+// in groups of four, e.g. "ABCD-EFGH-JKMN".
+func (ids *Identities) NewLinkCode(identity string) string {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+
+	now := ids.now()
+	for code, pending := range ids.pending {
+		if now.After(pending.expires) {
+			delete(ids.pending, code)
+		}
+	}
+
+	code := make([]byte, linkCodeLength)
+	for i := range code {
+		n, _ := rand.Int(rand.Reader, big.NewInt(int64(len(linkCodeAlphabet))))
+		code[i] = linkCodeAlphabet[n.Int64()]
+	}
+	ids.pending[string(code)] = &linkCode{identity: identity, expires: now.Add(linkCodeTTL)}
+	return fmt.Sprintf("%s-%s-%s", code[:4], code[4:8], code[8:])
+}
+
+// normalizeLinkCode accepts a code with or without separators, in any case.
+func normalizeLinkCode(code string) string {
+	code = strings.ToUpper(code)
+	return strings.NewReplacer("-", "", " ", "").Replace(code)
+}
+
+// Link adds the sender's account to the identity that issued code. Profile
+// and memory the account had on its own are merged into that identity.
+// Wrong codes are limited per account.
+func (ids *Identities) Link(code, channel, senderID string) (string, error) {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+
+	account := accountKey(channel, senderID)
+	now := ids.now()
+	failures := ids.failures[account]
+	if failures != nil && now.Sub(failures.since) > linkCodeTTL {
+		delete(ids.failures, account)
+		failures = nil
+	}
+	if failures != nil && failures.count >= maxAccountLinkFailures {
+		return "", fmt.Errorf("too many wrong link codes; try again later")
+	}
+
+	code = normalizeLinkCode(code)
+	pending, ok := ids.pending[code]
+	if !ok || now.After(pending.expires) {
+		delete(ids.pending, code)
+		ids.recordLinkFailureLocked(account, now)
+		return "", fmt.Errorf("invalid or expired link code")
+	}
+
+	if _, ok := ids.configured[account]; ok {
+		return "", fmt.Errorf("%s is linked in the config and cannot be relinked", account)
+	}
+	previous := ids.resolveLocked(account)
+	if previous == pending.identity {
+		return "", fmt.Errorf("%s already belongs to this identity", account)
+	}
+
+	delete(ids.pending, code)
+	ids.links[account] = pending.identity
+	if err := ids.saveLocked(); err != nil {
+		return "", err
+	}
+
+	// An account that was its own identity brings its data along.
+	if previous == account {
+		from := filepath.Join(ids.workspace, filepath.FromSlash(Scope{User: previous}.UserDir()))
+		to := filepath.Join(ids.workspace, filepath.FromSlash(Scope{User: pending.identity}.UserDir()))
+		if err := mergeDir(from, to); err != nil {
+			return pending.identity, fmt.Errorf("linked, but merging %s failed: %w", account, err)
+		}
+	}
+	return pending.identity, nil
+}
+
+// recordLinkFailureLocked counts a wrong code against the sending account.
+func (ids *Identities) recordLinkFailureLocked(account string, now time.Time) {
+	failures := ids.failures[account]
+	if failures == nil {
+		failures = &linkFailures{since: now}
+		ids.failures[account] = failures
+	}
+	failures.count++
+}
+
+// Forget deletes the profile and memory of identity and its runtime links.
+// Links from the config stay.
+func (ids *Identities) Forget(identity string) error {
+	ids.mu.Lock()
+	defer ids.mu.Unlock()
+
+	for account, id := range ids.links {
+		if id == identity {
+			delete(ids.links, account)
+		}
+	}
+	if err := ids.saveLocked(); err != nil {
+		return err
+	}
+	return os.RemoveAll(filepath.Join(ids.workspace, filepath.FromSlash(Scope{User: identity}.UserDir())))
+}
+
+func (ids *Identities) saveLocked() error {
+	data, err := json.MarshalIndent(ids.links, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeFileAtomic(filepath.Join(ids.workspace, identitiesFile), data, 0600)
+}
+
+// mergeDir moves the files of from into to, appending to files that exist
+// in both, and removes from.
+func mergeDir(from, to string) error {
+	entries, err := os.ReadDir(from)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(to, 0755); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.IsDir() {
+			continue
+		}
+		src := filepath.Join(from, e.Name())
+		dst := filepath.Join(to, e.Name())
+		existing, err := os.ReadFile(dst)
+		if os.IsNotExist(err) {
+			if err := os.Rename(src, dst); err != nil {
+				return err
+			}
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		data, err := os.ReadFile(src)
+		if err != nil {
+			return err
+		}
+		merged := strings.TrimRight(string(existing), "\n") + "\n\n" + string(data)
+		if err := os.WriteFile(dst, []byte(merged), 0644); err != nil {
+			return err
+		}
+	}
+	return os.RemoveAll(from)
+}
@@ -0,0 +1,205 @@
+package memory
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIdentities_Resolve(t *testing.T) {
+	ids := NewIdentities(t.TempDir(), map[string][]string{
+		"alice": {"telegram:123", "slack:U1"},
+	})
+
+	tests := []struct {
+		channel, sender, want string
+	}{
+		{"telegram", "123|alice_tg", "alice"},
+		{"slack", "U1", "alice"},
+		{"telegram", "456|bob", "telegram:456"},
+		{"discord", "123", "discord:123"},
+	}
+	for _, tt := range tests {
+		if got := ids.Resolve(tt.channel, tt.sender); got != tt.want {
+			t.Errorf("Resolve(%s, %s) = %s, want %s", tt.channel, tt.sender, got, tt.want)
+		}
+	}
+	if got := strings.Join(ids.Accounts("alice"), ","); got != "slack:U1,telegram:123" {
+		t.Errorf("Accounts(alice) = %s", got)
+	}
+}
+
+func TestIdentities_Link(t *testing.T) {
+	ws := t.TempDir()
+	ids := NewIdentities(ws, nil)
+
+	// Both accounts have memory of their own before linking.
+	writeFile(t, filepath.Join(ws, "users", "telegram_1", "MEMORY.md"), "# Memory\n\n- Likes jazz\n")
+	writeFile(t, filepath.Join(ws, "users", "slack_U9", "MEMORY.md"), "# Memory\n\n- Works night shifts\n")
+	writeFile(t, filepath.Join(ws, "users", "slack_U9", "USER.md"), "Name: Sam\n")
+
+	code := ids.NewLinkCode("telegram:1")
+	if _, err := ids.Link("000000x", "slack", "U9"); err == nil {
+		t.Error("wrong code accepted")
+	}
+	identity, err := ids.Link(code, "slack", "U9|sam")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity != "telegram:1" || ids.Resolve("slack", "U9") != "telegram:1" {
+		t.Errorf("slack account resolves to %s", ids.Resolve("slack", "U9"))
+	}
+	if _, err := ids.Link(code, "discord", "5"); err == nil {
+		t.Error("code accepted twice")
+	}
+
+	data, _ := os.ReadFile(filepath.Join(ws, "users", "telegram_1", "MEMORY.md"))
+	if !strings.Contains(string(data), "Likes jazz") || !strings.Contains(string(data), "Works night shifts") {
+		t.Errorf("memory not merged:\n%s", data)
+	}
+	if _, err := os.Stat(filepath.Join(ws, "users", "telegram_1", "USER.md")); err != nil {
+		t.Error("profile not moved")
+	}
+	if _, err := os.Stat(filepath.Join(ws, "users", "slack_U9")); !os.IsNotExist(err) {
+		t.Error("old identity directory left behind")
+	}
+
+	// Links survive a restart.
+	if got := NewIdentities(ws, nil).Resolve("slack", "U9"); got != "telegram:1" {
+		t.Errorf("after reload slack resolves to %s", got)
+	}
+
+	if err := ids.Forget("telegram:1"); err != nil {
+		t.Fatal(err)
+	}
+	if got := ids.Resolve("slack", "U9"); got != "slack:U9" {
+		t.Errorf("after forget slack resolves to %s", got)
+	}
+	if _, err := os.Stat(filepath.Join(ws, "users", "telegram_1")); !os.IsNotExist(err) {
+		t.Error("user directory not deleted")
+	}
+}
+
+func TestIdentities_LinkCodeExpires(t *testing.T) {
+	ids := NewIdentities(t.TempDir(), nil)
+	now := time.Now()
+	ids.now = func() time.Time { return now }
+
+	code := ids.NewLinkCode("telegram:1")
+	now = now.Add(linkCodeTTL + time.Second)
+	if _, err := ids.Link(code, "slack", "U9"); err == nil {
+		t.Error("expired code accepted")
+	}
+}
+
+func TestIdentities_LinkCodeFormat(t *testing.T) {
+	ids := NewIdentities(t.TempDir(), nil)
+	code := ids.NewLinkCode("telegram:1")
+	if len(normalizeLinkCode(code)) != linkCodeLength {
+		t.Fatalf("unexpected code %q", code)
+	}
+
+	// Codes are accepted without separators and in lower case.
+	typed := strings.ToLower(strings.ReplaceAll(code, "-", ""))
+	if _, err := ids.Link(typed, "slack", "U9"); err != nil {
+		t.Errorf("typed code rejected: %v", err)
+	}
+}
+
+func TestIdentities_LinkAttemptLimits(t *testing.T) {
+	ids := NewIdentities(t.TempDir(), nil)
+
+	// An account that keeps guessing is locked out, even with the right code.
+	code := ids.NewLinkCode("telegram:1")
+	for i := 0; i < maxAccountLinkFailures; i++ {
+		ids.Link("WRONG", "slack", "U9")
+	}
+	if _, err := ids.Link(code, "slack", "U9"); err == nil || !strings.Contains(err.Error(), "too many") {
+		t.Errorf("expected the account to be locked out, got %v", err)
+	}
+
+	// Wrong guesses from other accounts do not touch anyone's pending code.
+	for i := 0; i < 2*maxAccountLinkFailures; i++ {
+		ids.Link("WRONG", "discord", fmt.Sprintf("%d", i))
+	}
+	if _, err := ids.Link(code, "matrix", "alice"); err != nil {
+		t.Errorf("expected the code to survive other accounts' guesses: %v", err)
+	}
+}
+
+func TestScope(t *testing.T) {
+	scope := Scope{User: "telegram:1", Chat: "telegram:-100", Session: "telegram:-100"}
+
+	tests := []struct {
+		source            string
+		allows, canModify bool
+	}{
+		{"memory/MEMORY.md", true, false},
+		{"users/telegram_1/MEMORY.md", true, true},
+		{"users/telegram_2/MEMORY.md", false, false},
+		{"chats/telegram_-100/MEMORY.md", true, true},
+		{"chats/telegram_-200/MEMORY.md", false, false},
+		{"session:telegram:-100", true, false},
+		{"session:telegram:2", false, false},
+	}
+	for _, tt := range tests {
+		if got := scope.Allows(tt.source); got != tt.allows {
+			t.Errorf("Allows(%s) = %v", tt.source, got)
+		}
+		if got := scope.CanModify(tt.source); got != tt.canModify {
+			t.Errorf("CanModify(%s) = %v", tt.source, got)
+		}
+	}
+
+	if !(Scope{}).Allows("users/anyone/MEMORY.md") {
+		t.Error("zero scope should see everything")
+	}
+	if got := scope.MemoryFile(false); got != "users/telegram_1/MEMORY.md" {
+		t.Errorf("MemoryFile(false) = %s", got)
+	}
+	if got := scope.MemoryFile(true); got != "chats/telegram_-100/MEMORY.md" {
+		t.Errorf("MemoryFile(true) = %s", got)
+	}
+	if got := (Scope{Session: "x"}).MemoryFile(false); got != "" {
+		t.Errorf("scope without user or chat remembers to %q", got)
+	}
+	if got := (Scope{User: "../../etc"}).UserDir(); got != "users/_.._etc" {
+		t.Errorf("unsafe identity dir = %s", got)
+	}
+}
+
+func TestSearchScoped(t *testing.T) {
+	ws := t.TempDir()
+	idx := NewIndex(ws)
+	ctx := context.Background()
+	alice := Scope{User: "alice", Session: "telegram:1"}
+	bob := Scope{User: "bob", Session: "telegram:2"}
+
+	if _, err := idx.RememberIn(ctx, alice.MemoryFile(false), "Alice is allergic to cats"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := idx.RememberIn(ctx, bob.MemoryFile(false), "Bob has two cats"); err != nil {
+		t.Fatal(err)
+	}
+
+	results, _ := idx.SearchScoped(ctx, alice, "cats", 5)
+	if len(results) != 1 || !strings.Contains(results[0].Text, "Alice") {
+		t.Errorf("alice sees %+v", results)
+	}
+	results, _ = idx.SearchScoped(ctx, Scope{}, "cats", 5)
+	if len(results) != 2 {
+		t.Errorf("unscoped search found %d results, want 2", len(results))
+	}
+
+	bobs, _ := idx.SearchScoped(ctx, bob, "cats", 1)
+	if _, err := idx.ForgetScoped(ctx, alice, bobs[0].ID); err == nil {
+		t.Error("alice forgot bob's memory")
+	}
+	if _, err := idx.ForgetScoped(ctx, bob, bobs[0].ID); err != nil {
+		t.Errorf("bob cannot forget his own memory: %v", err)
+	}
+}
@@ -0,0 +1,547 @@
+// Package memory indexes the agent's long-term memory, daily notes and past
+// session summaries for retrieval. Search is lexical (BM25) and can be
+// combined with embeddings from the configured provider.
+package memory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+const (
+	// LongTermFile is where remembered facts are written, relative to the
+	// workspace.
+	LongTermFile = "memory/MEMORY.md"
+
+	indexFile      = "memory/.index.json"
+	indexVersion   = 1
+	embedBatchSize = 64
+
+	// Weight of the embedding similarity in hybrid scores, and the
+	// similarity a chunk needs to match without sharing any query term.
+	vectorWeight  = 0.5
+	minSimilarity = 0.5
+)
+
+// Embedder turns texts into vectors for semantic search.
+type Embedder interface {
+	Embed(ctx context.Context, texts []string) ([][]float32, error)
+}
+
+type providerEmbedder struct {
+	provider providers.EmbeddingProvider
+	model    string
+}
+
+func (e *providerEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	return e.provider.Embed(ctx, texts, e.model)
+}
+
+// NewProviderEmbedder embeds texts with model through provider.
+func NewProviderEmbedder(provider providers.EmbeddingProvider, model string) Embedder {
+	return &providerEmbedder{provider: provider, model: model}
+}
+
+// Result is a chunk matched by Search.
+type Result struct {
+	Chunk
+	Score float64 `json:"score"`
+}
+
+type indexedFile struct {
+	ModTime int64   `json:"mod_time"`
+	Size    int64   `json:"size"`
+	Chunks  []Chunk `json:"chunks"`
+}
+
+type indexState struct {
+	Version        int                     `json:"version"`
+	EmbeddingModel string                  `json:"embedding_model,omitempty"`
+	Files          map[string]*indexedFile `json:"files"`
+}
+
+// Index is an on-disk search index over the memory files
+// (memory/**/*.md, users/*/MEMORY.md, chats/*/MEMORY.md) and session
+// summaries (sessions/*.json) of a workspace.
+// It is refreshed incrementally before every search, so files edited by
+// hand or with the file tools are picked up.
+type Index struct {
+	workspace  string
+	embedder   Embedder
+	embedModel string
+
+	mu     sync.Mutex
+	files  map[string]*indexedFile // keyed by path relative to the workspace
+	loaded bool
+
+	// embedding is held by the one embedPending pass allowed at a time.
+	embedding sync.Mutex
+}
+
+// NewIndex creates an index for workspace. Nothing is read until the first
+// search.
+func NewIndex(workspace string) *Index {
+	return &Index{
+		workspace: workspace,
+		files:     make(map[string]*indexedFile),
+	}
+}
+
+// SetEmbedder enables semantic search. model identifies the embedding model,
+// so stored vectors are recomputed when it changes.
+func (idx *Index) SetEmbedder(embedder Embedder, model string) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	idx.embedder = embedder
+	idx.embedModel = model
+}
+
+func (idx *Index) loadLocked() {
+	idx.loaded = true
+	data, err := os.ReadFile(filepath.Join(idx.workspace, indexFile))
+	if err != nil {
+		return
+	}
+	var state indexState
+	if err := json.Unmarshal(data, &state); err != nil || state.Version != indexVersion {
+		return
+	}
+	if state.EmbeddingModel != idx.embedModel {
+		for _, f := range state.Files {
+			for i := range f.Chunks {
+				f.Chunks[i].Vector = nil
+			}
+		}
+	}
+	if state.Files != nil {
+		idx.files = state.Files
+	}
+}
+
+func (idx *Index) saveLocked() error {
+	data, err := json.Marshal(indexState{
+		Version:        indexVersion,
+		EmbeddingModel: idx.embedModel,
+		Files:          idx.files,
+	})
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(idx.workspace, indexFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Refresh brings the index up to date with the files on disk.
+func (idx *Index) Refresh(ctx context.Context) error {
+	idx.mu.Lock()
+	err := idx.refreshLocked(ctx)
+	idx.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return idx.embedPending(ctx)
+}
+
+// refreshLocked reindexes the files that changed on disk. New chunks are
+// left without vectors for embedPending.
+func (idx *Index) refreshLocked(ctx context.Context) error {
+	if !idx.loaded {
+		idx.loadLocked()
+	}
+
+	changed := false
+	seen := make(map[string]bool)
+	index := func(rel string, info fs.FileInfo, chunk func([]byte) []Chunk) {
+		seen[rel] = true
+		old := idx.files[rel]
+		if old != nil && old.ModTime == info.ModTime().UnixNano() && old.Size == info.Size() {
+			return
+		}
+		data, err := os.ReadFile(filepath.Join(idx.workspace, rel))
+		if err != nil {
+			return
+		}
+
+		chunks := chunk(data)
+		if old != nil {
+			vectors := make(map[string][]float32, len(old.Chunks))
+			for _, c := range old.Chunks {
+				vectors[c.ID] = c.Vector
+			}
+			for i := range chunks {
+				chunks[i].Vector = vectors[chunks[i].ID]
+			}
+		}
+		idx.files[rel] = &indexedFile{ModTime: info.ModTime().UnixNano(), Size: info.Size(), Chunks: chunks}
+		changed = true
+	}
+
+	memoryDir := filepath.Join(idx.workspace, "memory")
+	filepath.WalkDir(memoryDir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return nil
+		}
+		if d.IsDir() {
+			// Archived notes have been consolidated into MEMORY.md.
+			if path != memoryDir && (strings.HasPrefix(d.Name(), ".") || path == filepath.Join(idx.workspace, ArchiveDir)) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !strings.HasSuffix(d.Name(), ".md") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		rel, _ := filepath.Rel(idx.workspace, path)
+		rel = filepath.ToSlash(rel)
+		index(rel, info, func(data []byte) []Chunk {
+			return chunkMarkdown(rel, string(data))
+		})
+		return nil
+	})
+
+	// Per-user and per-chat memory
+	for _, dir := range []string{UsersDir, ChatsDir} {
+		files, _ := filepath.Glob(filepath.Join(idx.workspace, dir, "*", "MEMORY.md"))
+		for _, path := range files {
+			info, err := os.Stat(path)
+			if err != nil {
+				continue
+			}
+			rel, _ := filepath.Rel(idx.workspace, path)
+			rel = filepath.ToSlash(rel)
+			index(rel, info, func(data []byte) []Chunk {
+				return chunkMarkdown(rel, string(data))
+			})
+		}
+	}
+
+	sessionFiles, _ := filepath.Glob(filepath.Join(idx.workspace, "sessions", "*.json"))
+	for _, path := range sessionFiles {
+		info, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		rel := "sessions/" + filepath.Base(path)
+		index(rel, info, func(data []byte) []Chunk {
+			var stored struct {
+				Key     string `json:"key"`
+				Summary string `json:"summary"`
+			}
+			if json.Unmarshal(data, &stored) != nil || strings.TrimSpace(stored.Summary) == "" {
+				return nil
+			}
+			chunks := chunkMarkdown("session:"+stored.Key, stored.Summary)
+			for i := range chunks {
+				chunks[i].Heading = "Session summary"
+			}
+			return chunks
+		})
+	}
+
+	for rel := range idx.files {
+		if !seen[rel] {
+			delete(idx.files, rel)
+			changed = true
+		}
+	}
+
+	if !changed {
+		return nil
+	}
+	return idx.saveLocked()
+}
+
+// pendingChunk is a chunk waiting for its vector, found at Chunks[pos] of
+// its file.
+type pendingChunk struct {
+	file string
+	pos  int
+	id   string
+	text string
+}
+
+// embedPending computes vectors for chunks that have none. The embedder is
+// called without holding idx.mu, so searches do not queue behind a slow
+// request; the vectors are stored afterwards for the chunks that are still
+// unchanged. Only one pass runs at a time, and a search that finds one
+// running goes ahead without it. Failures are logged and leave the chunks
+// to lexical search.
+func (idx *Index) embedPending(ctx context.Context) error {
+	if !idx.embedding.TryLock() {
+		return nil
+	}
+	defer idx.embedding.Unlock()
+
+	idx.mu.Lock()
+	embedder, model := idx.embedder, idx.embedModel
+	var pending []pendingChunk
+	if embedder != nil {
+		for rel, f := range idx.files {
+			for i, c := range f.Chunks {
+				if c.Vector == nil {
+					pending = append(pending, pendingChunk{file: rel, pos: i, id: c.ID, text: c.Text})
+				}
+			}
+		}
+	}
+	idx.mu.Unlock()
+
+	embedded := false
+	for start := 0; start < len(pending); start += embedBatchSize {
+		batch := pending[start:min(start+embedBatchSize, len(pending))]
+		texts := make([]string, 0, len(batch))
+		for _, c := range batch {
+			texts = append(texts, c.text)
+		}
+		vectors, err := embedder.Embed(ctx, texts)
+		if err != nil || len(vectors) != len(texts) {
+			logger.WarnCF("memory", "Failed to embed memory chunks", map[string]interface{}{
+				"chunks": len(texts),
+				"error":  fmt.Sprint(err),
+			})
+			break
+		}
+
+		idx.mu.Lock()
+		if idx.embedModel == model {
+			for i, c := range batch {
+				f := idx.files[c.file]
+				if f != nil && c.pos < len(f.Chunks) && f.Chunks[c.pos].ID == c.id && f.Chunks[c.pos].Vector == nil {
+					f.Chunks[c.pos].Vector = vectors[i]
+					embedded = true
+				}
+			}
+		}
+		idx.mu.Unlock()
+	}
+
+	if !embedded {
+		return nil
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+	return idx.saveLocked()
+}
+
+// Search returns up to limit chunks relevant to query, best first.
+func (idx *Index) Search(ctx context.Context, query string, limit int) ([]Result, error) {
+	return idx.SearchScoped(ctx, Scope{}, query, limit)
+}
+
+// SearchScoped is Search limited to the chunks visible in scope.
+func (idx *Index) SearchScoped(ctx context.Context, scope Scope, query string, limit int) ([]Result, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	if err := idx.Refresh(ctx); err != nil {
+		logger.WarnCF("memory", "Failed to save memory index", map[string]interface{}{"error": err.Error()})
+	}
+
+	// The query is embedded before taking the lock, like the chunks.
+	idx.mu.Lock()
+	embedder := idx.embedder
+	idx.mu.Unlock()
+	var queryVector []float32
+	if embedder != nil && strings.TrimSpace(query) != "" {
+		vectors, err := embedder.Embed(ctx, []string{query})
+		if err == nil && len(vectors) == 1 {
+			queryVector = vectors[0]
+		} else {
+			logger.WarnCF("memory", "Failed to embed query, using lexical search only", map[string]interface{}{"error": fmt.Sprint(err)})
+		}
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	chunks := idx.chunksLocked(scope)
+	terms := tokenize(query)
+	docs := make([][]string, len(chunks))
+	for i, c := range chunks {
+		docs[i] = tokenize(c.Heading + "\n" + c.Text)
+	}
+	scores := bm25Scores(docs, terms)
+
+	var best float64
+	for _, s := range scores {
+		best = max(best, s)
+	}
+
+	var results []Result
+	for i, c := range chunks {
+		lexical := 0.0
+		if best > 0 {
+			lexical = scores[i] / best
+		}
+		score := lexical
+		if queryVector != nil && c.Vector != nil {
+			similarity := cosine(queryVector, c.Vector)
+			if lexical == 0 && similarity < minSimilarity {
+				continue
+			}
+			score = (1-vectorWeight)*lexical + vectorWeight*similarity
+		} else if lexical == 0 {
+			continue
+		}
+		results = append(results, Result{Chunk: c, Score: score})
+	}
+
+	sort.SliceStable(results, func(i, j int) bool { return results[i].Score > results[j].Score })
+	if limit > 0 && len(results) > limit {
+		results = results[:limit]
+	}
+	for i := range results {
+		results[i].Vector = nil
+	}
+	return results, nil
+}
+
+// chunksLocked returns the chunks visible in scope in a stable order.
+func (idx *Index) chunksLocked(scope Scope) []Chunk {
+	paths := make([]string, 0, len(idx.files))
+	for rel := range idx.files {
+		paths = append(paths, rel)
+	}
+	sort.Strings(paths)
+
+	var chunks []Chunk
+	for _, rel := range paths {
+		for _, c := range idx.files[rel].Chunks {
+			if scope.Allows(c.Source) {
+				chunks = append(chunks, c)
+			}
+		}
+	}
+	return chunks
+}
+
+// Remember appends a fact to the long-term memory file as a list item and
+// returns the chunk it became.
+func (idx *Index) Remember(ctx context.Context, fact string) (Chunk, error) {
+	return idx.RememberIn(ctx, LongTermFile, fact)
+}
+
+// RememberIn is Remember for another memory file, such as a user's, given
+// relative to the workspace.
+func (idx *Index) RememberIn(ctx context.Context, file, fact string) (Chunk, error) {
+	fact = strings.Join(strings.Fields(fact), " ")
+	if fact == "" {
+		return Chunk{}, fmt.Errorf("nothing to remember")
+	}
+
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	path := filepath.Join(idx.workspace, filepath.FromSlash(file))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return Chunk{}, err
+	}
+	existing, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return Chunk{}, err
+	}
+
+	content := string(existing)
+	if content == "" {
+		content = "# Long-term Memory\n\n"
+		if file != LongTermFile {
+			content = "# Memory\n\n"
+		}
+	} else if !strings.HasSuffix(content, "\n") {
+		content += "\n"
+	}
+	item := fmt.Sprintf("- %s (%s)", fact, time.Now().Format("2006-01-02"))
+	if err := os.WriteFile(path, []byte(content+item+"\n"), 0644); err != nil {
+		return Chunk{}, err
+	}
+
+	if err := idx.refreshLocked(ctx); err != nil {
+		logger.WarnCF("memory", "Failed to save memory index", map[string]interface{}{"error": err.Error()})
+	}
+	return Chunk{ID: chunkID(file, item), Source: file, Text: item}, nil
+}
+
+// Forget removes the chunk with the given ID from its memory file. Session
+// summaries cannot be forgotten this way.
+func (idx *Index) Forget(ctx context.Context, id string) (Chunk, error) {
+	return idx.ForgetScoped(ctx, Scope{}, id)
+}
+
+// ForgetScoped is Forget limited to the memory scope may modify.
+func (idx *Index) ForgetScoped(ctx context.Context, scope Scope, id string) (Chunk, error) {
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	if err := idx.refreshLocked(ctx); err != nil {
+		logger.WarnCF("memory", "Failed to save memory index", map[string]interface{}{"error": err.Error()})
+	}
+
+	var chunk *Chunk
+	for _, c := range idx.chunksLocked(scope) {
+		if c.ID == id {
+			chunk = &c
+			break
+		}
+	}
+	if chunk == nil {
+		return Chunk{}, fmt.Errorf("memory %s not found", id)
+	}
+	if strings.HasPrefix(chunk.Source, "session:") {
+		return Chunk{}, fmt.Errorf("memory %s is a session summary; clear the session to forget it", id)
+	}
+	if !scope.CanModify(chunk.Source) {
+		return Chunk{}, fmt.Errorf("memory %s is shared memory and cannot be forgotten from this chat", id)
+	}
+
+	path := filepath.Join(idx.workspace, filepath.FromSlash(chunk.Source))
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Chunk{}, err
+	}
+	content := string(data)
+	pos := strings.Index(content, chunk.Text)
+	if pos < 0 {
+		return Chunk{}, fmt.Errorf("memory %s changed on disk; search again", id)
+	}
+	end := pos + len(chunk.Text)
+	if end < len(content) && content[end] == '\n' {
+		end++
+	}
+	content = content[:pos] + content[end:]
+	for strings.Contains(content, "\n\n\n") {
+		content = strings.ReplaceAll(content, "\n\n\n", "\n\n")
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		return Chunk{}, err
+	}
+
+	if err := idx.refreshLocked(ctx); err != nil {
+		logger.WarnCF("memory", "Failed to save memory index", map[string]interface{}{"error": err.Error()})
+	}
+	result := *chunk
+	result.Vector = nil
+	return result, nil
+}
@@ -0,0 +1,278 @@
+package memory
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeFile(t *testing.T, path, content string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func newTestWorkspace(t *testing.T) string {
+	t.Helper()
+	ws := t.TempDir()
+	writeFile(t, filepath.Join(ws, "memory", "MEMORY.md"), `# Long-term Memory
+
+## Preferences
+
+- The user prefers green tea over coffee
+- The user's dog is called Biscuit
+
+## Projects
+
+The garden irrigation project uses a Raspberry Pi
+and a moisture sensor on the tomato bed.
+`)
+	writeFile(t, filepath.Join(ws, "memory", "202401", "20240105.md"), "# 2024-01-05\n\nBooked flights to Lisbon for the conference in March.\n")
+	writeFile(t, filepath.Join(ws, "sessions", "telegram_1.json"), `{"key":"telegram:1","messages":[],"summary":"Discussed replacing the router firmware with OpenWrt."}`)
+	return ws
+}
+
+func TestChunkMarkdown(t *testing.T) {
+	chunks := chunkMarkdown("memory/MEMORY.md", "# Title\n\n- one\n- two\n  continued\n\nA paragraph\nover two lines.\n")
+	want := []struct{ heading, text string }{
+		{"Title", "- one"},
+		{"Title", "- two\n  continued"},
+		{"Title", "A paragraph\nover two lines."},
+	}
+	if len(chunks) != len(want) {
+		t.Fatalf("got %d chunks, want %d: %+v", len(chunks), len(want), chunks)
+	}
+	for i, w := range want {
+		if chunks[i].Heading != w.heading || chunks[i].Text != w.text {
+			t.Errorf("chunk %d = %q/%q, want %q/%q", i, chunks[i].Heading, chunks[i].Text, w.heading, w.text)
+		}
+		if chunks[i].ID != chunkID("memory/MEMORY.md", w.text) {
+			t.Errorf("chunk %d has unstable ID %s", i, chunks[i].ID)
+		}
+	}
+}
+
+func TestTokenize(t *testing.T) {
+	got := strings.Join(tokenize("The user's Dog, 是 Biscuit!"), " ")
+	if got != "user s dog 是 biscuit" {
+		t.Errorf("tokenize = %q", got)
+	}
+}
+
+func TestSearch(t *testing.T) {
+	idx := NewIndex(newTestWorkspace(t))
+
+	tests := []struct {
+		query      string
+		wantSource string
+		wantText   string
+	}{
+		{"what tea does the user like", "memory/MEMORY.md", "green tea"},
+		{"dog name", "memory/MEMORY.md", "Biscuit"},
+		{"tomato moisture sensor", "memory/MEMORY.md", "irrigation"},
+		{"Lisbon flights", "memory/202401/20240105.md", "Lisbon"},
+		{"openwrt router", "session:telegram:1", "OpenWrt"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			results, err := idx.Search(context.Background(), tt.query, 3)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(results) == 0 {
+				t.Fatal("no results")
+			}
+			top := results[0]
+			if top.Source != tt.wantSource || !strings.Contains(top.Text, tt.wantText) {
+				t.Errorf("top result = %s %q, want %s containing %q", top.Source, top.Text, tt.wantSource, tt.wantText)
+			}
+		})
+	}
+
+	results, _ := idx.Search(context.Background(), "quantum chromodynamics", 3)
+	if len(results) != 0 {
+		t.Errorf("unrelated query matched %+v", results)
+	}
+}
+
+func TestSearchPicksUpChanges(t *testing.T) {
+	ws := newTestWorkspace(t)
+	idx := NewIndex(ws)
+	idx.Search(context.Background(), "tea", 3)
+
+	writeFile(t, filepath.Join(ws, "memory", "202401", "20240106.md"), "# 2024-01-06\n\nThe car needs new winter tyres.\n")
+	os.Remove(filepath.Join(ws, "memory", "202401", "20240105.md"))
+
+	if results, _ := idx.Search(context.Background(), "winter tyres", 3); len(results) == 0 {
+		t.Error("new daily note not indexed")
+	}
+	if results, _ := idx.Search(context.Background(), "Lisbon", 3); len(results) != 0 {
+		t.Errorf("deleted daily note still matches: %+v", results)
+	}
+	if _, err := os.Stat(filepath.Join(ws, indexFile)); err != nil {
+		t.Errorf("index not persisted: %v", err)
+	}
+}
+
+func TestRememberAndForget(t *testing.T) {
+	ws := newTestWorkspace(t)
+	idx := NewIndex(ws)
+	ctx := context.Background()
+
+	chunk, err := idx.Remember(ctx, "The user's  birthday is\non 12 May")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(chunk.Text, "- The user's birthday is on 12 May (") {
+		t.Errorf("remembered text = %q", chunk.Text)
+	}
+
+	results, _ := idx.Search(ctx, "birthday", 1)
+	if len(results) != 1 || results[0].ID != chunk.ID {
+		t.Fatalf("remembered fact not found by ID %s: %+v", chunk.ID, results)
+	}
+
+	if _, err := idx.Forget(ctx, chunk.ID); err != nil {
+		t.Fatal(err)
+	}
+	if results, _ := idx.Search(ctx, "birthday", 1); len(results) != 0 {
+		t.Errorf("forgotten fact still found: %+v", results)
+	}
+	data, _ := os.ReadFile(filepath.Join(ws, LongTermFile))
+	if strings.Contains(string(data), "birthday") || !strings.Contains(string(data), "green tea") {
+		t.Errorf("MEMORY.md after forget:\n%s", data)
+	}
+
+	if _, err := idx.Forget(ctx, chunk.ID); err == nil {
+		t.Error("forgetting twice should fail")
+	}
+	session, _ := idx.Search(ctx, "openwrt", 1)
+	if _, err := idx.Forget(ctx, session[0].ID); err == nil {
+		t.Error("forgetting a session summary should fail")
+	}
+}
+
+func TestRememberCreatesMemoryFile(t *testing.T) {
+	ws := t.TempDir()
+	idx := NewIndex(ws)
+	if _, err := idx.Remember(context.Background(), "first fact"); err != nil {
+		t.Fatal(err)
+	}
+	data, err := os.ReadFile(filepath.Join(ws, LongTermFile))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "# Long-term Memory\n\n- first fact (") {
+		t.Errorf("MEMORY.md = %q", data)
+	}
+}
+
+// keywordEmbedder maps texts onto two axes, "pets" and "travel", so
+// semantic matches can be tested without a model.
+type keywordEmbedder struct {
+	calls int
+}
+
+func (e *keywordEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	e.calls++
+	vectors := make([][]float32, len(texts))
+	for i, text := range texts {
+		text = strings.ToLower(text)
+		v := []float32{0.01, 0.01}
+		for _, w := range []string{"dog", "puppy", "pet", "biscuit"} {
+			if strings.Contains(text, w) {
+				v[0] = 1
+			}
+		}
+		for _, w := range []string{"flight", "trip", "lisbon"} {
+			if strings.Contains(text, w) {
+				v[1] = 1
+			}
+		}
+		vectors[i] = v
+	}
+	return vectors, nil
+}
+
+func TestSearchWithEmbeddings(t *testing.T) {
+	ws := newTestWorkspace(t)
+	embedder := &keywordEmbedder{}
+	idx := NewIndex(ws)
+	idx.SetEmbedder(embedder, "test-model")
+
+	// No query term appears in the note, only the embedding matches.
+	results, err := idx.Search(context.Background(), "upcoming trip", 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || !strings.Contains(results[0].Text, "Lisbon") {
+		t.Fatalf("semantic search = %+v", results)
+	}
+
+	// Vectors are persisted and reused by a new index with the same model.
+	reloaded := NewIndex(ws)
+	reused := &keywordEmbedder{}
+	reloaded.SetEmbedder(reused, "test-model")
+	if results, _ := reloaded.Search(context.Background(), "puppy", 1); len(results) != 1 || !strings.Contains(results[0].Text, "Biscuit") {
+		t.Errorf("semantic search after reload = %+v", results)
+	}
+	if reused.calls != 1 {
+		t.Errorf("embedder called %d times after reload, want 1 (query only)", reused.calls)
+	}
+}
+
+// slowEmbedder blocks on memory chunks until released; queries are
+// embedded at once.
+type slowEmbedder struct {
+	keywordEmbedder
+	started chan struct{}
+	release chan struct{}
+}
+
+func (e *slowEmbedder) Embed(ctx context.Context, texts []string) ([][]float32, error) {
+	if len(texts) > 1 {
+		close(e.started)
+		<-e.release
+	}
+	return e.keywordEmbedder.Embed(ctx, texts)
+}
+
+func TestSearchNotHeldUpByEmbedding(t *testing.T) {
+	idx := NewIndex(newTestWorkspace(t))
+	embedder := &slowEmbedder{started: make(chan struct{}), release: make(chan struct{})}
+	idx.SetEmbedder(embedder, "test-model")
+
+	first := make(chan []Result)
+	go func() {
+		results, _ := idx.Search(context.Background(), "trip", 1)
+		first <- results
+	}()
+	<-embedder.started
+
+	// Another search runs lexically while the chunks are being embedded.
+	done := make(chan []Result)
+	go func() {
+		results, _ := idx.Search(context.Background(), "green tea", 1)
+		done <- results
+	}()
+	select {
+	case results := <-done:
+		if len(results) != 1 || !strings.Contains(results[0].Text, "green tea") {
+			t.Errorf("search during embedding = %+v", results)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("search waited for the embedding request")
+	}
+
+	close(embedder.release)
+	if results := <-first; len(results) != 1 || !strings.Contains(results[0].Text, "Lisbon") {
+		t.Errorf("semantic search = %+v", results)
+	}
+}
@@ -0,0 +1,126 @@
+package memory
+
+import (
+	"context"
+	"path"
+	"regexp"
+	"strings"
+)
+
+const (
+	// UsersDir holds one directory per identity with its USER.md profile and
+	// MEMORY.md, relative to the workspace.
+	UsersDir = "users"
+	// ChatsDir holds one directory per group chat with the chat's MEMORY.md.
+	ChatsDir = "chats"
+)
+
+// Scope limits memory to what one conversation may see. The zero Scope is
+// unscoped: everything is visible, as in a single-user deployment. With
+// per-user memory, a scope sees the shared workspace memory, the memory of
+// its user and group chat, and the summary of its own session only.
+type Scope struct {
+	User    string // identity of the sender; "" when unknown
+	Chat    string // "channel:chat_id" of a group chat; "" for direct chats
+	Session string // session key whose summary is visible
+}
+
+// IsZero reports whether the scope is unscoped.
+func (s Scope) IsZero() bool {
+	return s == Scope{}
+}
+
+// UserDir returns the directory of the scope's user relative to the
+// workspace, or "" when there is none.
+func (s Scope) UserDir() string {
+	if s.User == "" {
+		return ""
+	}
+	return UsersDir + "/" + safeName(s.User)
+}
+
+// ChatDir returns the directory of the scope's group chat relative to the
+// workspace, or "" when there is none.
+func (s Scope) ChatDir() string {
+	if s.Chat == "" {
+		return ""
+	}
+	return ChatsDir + "/" + safeName(s.Chat)
+}
+
+// Allows reports whether a chunk from source is visible in the scope.
+func (s Scope) Allows(source string) bool {
+	if s.IsZero() {
+		return true
+	}
+	if key, ok := strings.CutPrefix(source, "session:"); ok {
+		return s.Session != "" && key == s.Session
+	}
+	if strings.HasPrefix(source, "memory/") {
+		return true
+	}
+	return s.owns(source)
+}
+
+// CanModify reports whether memory from source may be changed in the scope.
+// Scoped conversations may only change their own user and chat memory.
+func (s Scope) CanModify(source string) bool {
+	if strings.HasPrefix(source, "session:") {
+		return false
+	}
+	return s.IsZero() || s.owns(source)
+}
+
+func (s Scope) owns(source string) bool {
+	if dir := s.UserDir(); dir != "" && strings.HasPrefix(source, dir+"/") {
+		return true
+	}
+	if dir := s.ChatDir(); dir != "" && strings.HasPrefix(source, dir+"/") {
+		return true
+	}
+	return false
+}
+
+// MemoryFile returns the file remembered facts go to, relative to the
+// workspace. shared picks the group chat's memory over the user's. It
+// returns "" when the scope has no place to remember things.
+func (s Scope) MemoryFile(shared bool) string {
+	switch {
+	case s.IsZero():
+		return LongTermFile
+	case shared && s.Chat != "":
+		return path.Join(s.ChatDir(), "MEMORY.md")
+	case s.User != "":
+		return path.Join(s.UserDir(), "MEMORY.md")
+	case s.Chat != "":
+		return path.Join(s.ChatDir(), "MEMORY.md")
+	}
+	return ""
+}
+
+var unsafeNameChars = regexp.MustCompile(`[^A-Za-z0-9._@-]+`)
+
+// safeName turns an identity or chat key into a directory name.
+func safeName(name string) string {
+	name = unsafeNameChars.ReplaceAllString(name, "_")
+	name = strings.Trim(name, ".")
+	if name == "" {
+		return "_"
+	}
+	return name
+}
+
+type scopeKey struct{}
+
+// WithScope returns a context carrying the memory scope of a conversation,
+// for the memory tools.
+func WithScope(ctx context.Context, scope Scope) context.Context {
+	return context.WithValue(ctx, scopeKey{}, scope)
+}
+
+// ScopeFromContext returns the memory scope set by WithScope, or the zero
+// Scope.
+func ScopeFromContext(ctx context.Context) Scope {
+	scope, _ := ctx.Value(scopeKey{}).(Scope)
+	return scope
+}
@@ -0,0 +1,267 @@
+// Package permissions resolves which role a sender has and what that role
+// is allowed to use: tools, skills, slash commands and models.
+package permissions
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/constants"
+)
+
+// Role is the resolved set of grants for a sender.
+// A nil *Role means permissions are not enforced and everything is allowed.
+type Role struct {
+	Name     string
+	tools    []string
+	skills   []string
+	commands []string
+	models   []string
+	parent   *Role // further limits this role; see Restrict
+}
+
+type member struct {
+	channel string // empty or "*" matches any channel
+	id      string
+}
+
+// Policy maps senders to roles based on the permissions config.
+type Policy struct {
+	roles       map[string]*Role
+	members     map[string][]member
+	order       []string
+	defaultRole string
+	triggerRole string
+}
+
+// NewPolicy builds a Policy from config. It returns nil when permissions are
+// disabled, so callers can treat a nil policy as "allow everything".
+func NewPolicy(cfg config.PermissionsConfig) *Policy {
+	if !cfg.Enabled {
+		return nil
+	}
+
+	p := &Policy{
+		roles:       make(map[string]*Role, len(cfg.Roles)),
+		members:     make(map[string][]member, len(cfg.Roles)),
+		defaultRole: cfg.DefaultRole,
+		triggerRole: cfg.TriggerRole,
+	}
+
+	for name, rc := range cfg.Roles {
+		p.roles[name] = &Role{
+			Name:     name,
+			tools:    rc.Tools,
+			skills:   rc.Skills,
+			commands: rc.Commands,
+			models:   rc.Models,
+		}
+		for _, m := range rc.Members {
+			p.members[name] = append(p.members[name], parseMember(m))
+		}
+		p.order = append(p.order, name)
+	}
+	// Map iteration order is random; sort so that a sender listed in several
+	// roles always resolves to the same one.
+	sort.Strings(p.order)
+
+	return p
+}
+
+// RoleFor returns the role of a sender on a channel. Internal channels (cli,
+// system, subagent) are trusted and get a nil role. Senders that match no
+// role fall back to the default role, or to an empty role with no grants.
+func (p *Policy) RoleFor(channel, senderID string) *Role {
+	if p == nil || constants.IsInternalChannel(channel) {
+		return nil
+	}
+
+	for _, name := range p.order {
+		for _, m := range p.members[name] {
+			if m.matches(channel, senderID) {
+				return p.roles[name]
+			}
+		}
+	}
+
+	if role, ok := p.roles[p.defaultRole]; ok {
+		return role
+	}
+	return &Role{Name: "none"}
+}
+
+// TriggerRole returns the role for event-triggered jobs that no chat sender
+// caused, such as webhook calls and file changes. It falls back to the
+// default role, then to an empty role with no grants.
+func (p *Policy) TriggerRole() *Role {
+	if p == nil {
+		return nil
+	}
+	if role, ok := p.roles[p.triggerRole]; ok {
+		return role
+	}
+	if role, ok := p.roles[p.defaultRole]; ok {
+		return role
+	}
+	return &Role{Name: "none"}
+}
+
+// Role returns the configured role called name. A name that is no longer
+// configured gets an empty role with no grants, so removing a role from the
+// config also takes the rights away from jobs it created.
+func (p *Policy) Role(name string) *Role {
+	if p == nil {
+		return nil
+	}
+	if role, ok := p.roles[name]; ok {
+		return role
+	}
+	return &Role{Name: "none"}
+}
+
+// Roles returns the configured role names in sorted order.
+func (p *Policy) Roles() []string {
+	if p == nil {
+		return nil
+	}
+	return append([]string(nil), p.order...)
+}
+
+// Restrict returns a role that allows only what both role and the given
+// tool and skill lists allow. An empty list leaves that kind unrestricted.
+// It is used to narrow a sender's role to an agent profile's toolset.
+func Restrict(role *Role, name string, tools, skills []string) *Role {
+	if len(tools) == 0 && len(skills) == 0 {
+		return role
+	}
+	if role != nil {
+		name = role.Name
+	}
+	restricted := &Role{
+		Name:     name,
+		tools:    tools,
+		skills:   skills,
+		commands: []string{"*"},
+		models:   []string{"*"},
+		parent:   role,
+	}
+	if len(tools) == 0 {
+		restricted.tools = []string{"*"}
+	}
+	if len(skills) == 0 {
+		restricted.skills = []string{"*"}
+	}
+	return restricted
+}
+
+// Intersect returns a role that allows only what both a and b allow. A nil
+// role allows everything, so intersecting with nil returns the other role.
+func Intersect(a, b *Role) *Role {
+	if a == nil {
+		return b
+	}
+	if b == nil {
+		return a
+	}
+	narrowed := *a
+	narrowed.parent = Intersect(a.parent, b)
+	return &narrowed
+}
+
+// AllowsTool reports whether the role may see and execute the named tool.
+func (r *Role) AllowsTool(name string) bool {
+	return r == nil || (matchAny(r.tools, name) && r.parent.AllowsTool(name))
+}
+
+// AllowsSkill reports whether the role may see the named skill.
+func (r *Role) AllowsSkill(name string) bool {
+	return r == nil || (matchAny(r.skills, name) && r.parent.AllowsSkill(name))
+}
+
+// AllowsCommand reports whether the role may run a slash command.
+// The command may be given with or without its leading "/".
+func (r *Role) AllowsCommand(cmd string) bool {
+	if r == nil {
+		return true
+	}
+	cmd = "/" + strings.TrimPrefix(cmd, "/")
+	for _, pattern := range r.commands {
+		if matchPattern("/"+strings.TrimPrefix(pattern, "/"), cmd) {
+			return r.parent.AllowsCommand(cmd)
+		}
+	}
+	return false
+}
+
+// AllowsModel reports whether the role may switch to the named model.
+func (r *Role) AllowsModel(model string) bool {
+	return r == nil || (matchAny(r.models, model) && r.parent.AllowsModel(model))
+}
+
+type roleKey struct{}
+
+// WithRole returns a context carrying the sender's role. Tool execution and
+// tool definitions built from this context are limited to the role's grants.
+func WithRole(ctx context.Context, role *Role) context.Context {
+	return context.WithValue(ctx, roleKey{}, role)
+}
+
+// RoleFromContext returns the role stored by WithRole, or nil if none.
+func RoleFromContext(ctx context.Context) *Role {
+	if ctx == nil {
+		return nil
+	}
+	role, _ := ctx.Value(roleKey{}).(*Role)
+	return role
+}
+
+func parseMember(s string) member {
+	s = strings.TrimSpace(s)
+	if idx := strings.Index(s, ":"); idx > 0 {
+		return member{channel: s[:idx], id: s[idx+1:]}
+	}
+	return member{id: s}
+}
+
+// matches compares a member entry against a sender using the same rules as
+// channel allow lists: "123456|alice" matches "123456", "alice" and "@alice".
+func (m member) matches(channel, senderID string) bool {
+	if m.channel != "" && m.channel != "*" && m.channel != channel {
+		return false
+	}
+	if m.id == "*" {
+		return true
+	}
+
+	want := strings.TrimPrefix(m.id, "@")
+	if senderID == m.id || senderID == want {
+		return true
+	}
+	idPart, userPart := senderID, ""
+	if idx := strings.Index(senderID, "|"); idx > 0 {
+		idPart = senderID[:idx]
+		userPart = senderID[idx+1:]
+	}
+	return idPart == want || (userPart != "" && userPart == want)
+}
+
+func matchAny(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matchPattern(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+func matchPattern(pattern, name string) bool {
+	if pattern == "*" || pattern == name {
+		return true
+	}
+	if prefix, ok := strings.CutSuffix(pattern, "*"); ok {
+		return strings.HasPrefix(name, prefix)
+	}
+	return false
+}
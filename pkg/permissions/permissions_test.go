@@ -0,0 +1,224 @@
+package permissions
+
+import (
+	"context"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+)
+
+func testPolicy() *Policy {
+	return NewPolicy(config.PermissionsConfig{
+		Enabled:     true,
+		DefaultRole: "guest",
+		Roles: map[string]config.RoleConfig{
+			"admin": {
+				Members:  config.FlexibleStringSlice{"telegram:123456", "@alice"},
+				Tools:    []string{"*"},
+				Skills:   []string{"*"},
+				Commands: []string{"*"},
+				Models:   []string{"*"},
+			},
+			"member": {
+				Members:  config.FlexibleStringSlice{"slack:U999"},
+				Tools:    []string{"web_*", "read_file"},
+				Skills:   []string{"weather"},
+				Commands: []string{"show", "/list"},
+				Models:   []string{"gpt-4o-mini"},
+			},
+			"guest": {
+				Tools: []string{"web_search"},
+			},
+		},
+	})
+}
+
+func TestNewPolicy_DisabledReturnsNil(t *testing.T) {
+	p := NewPolicy(config.PermissionsConfig{Enabled: false})
+	if p != nil {
+		t.Fatal("expected nil policy when permissions are disabled")
+	}
+	if role := p.RoleFor("telegram", "123"); role != nil {
+		t.Fatalf("nil policy should resolve nil role, got %q", role.Name)
+	}
+}
+
+func TestPolicy_RoleFor(t *testing.T) {
+	p := testPolicy()
+
+	tests := []struct {
+		name     string
+		channel  string
+		senderID string
+		want     string
+	}{
+		{"channel scoped member", "telegram", "123456", "admin"},
+		{"compound telegram sender", "telegram", "123456|bob", "admin"},
+		{"channel scope does not leak", "discord", "123456", "guest"},
+		{"username on any channel", "discord", "777|alice", "admin"},
+		{"slack member", "slack", "U999", "member"},
+		{"unknown sender gets default", "slack", "U000", "guest"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			role := p.RoleFor(tt.channel, tt.senderID)
+			if role == nil || role.Name != tt.want {
+				t.Fatalf("RoleFor(%q, %q) = %v, want %q", tt.channel, tt.senderID, role, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicy_InternalChannelsAreTrusted(t *testing.T) {
+	p := testPolicy()
+	if role := p.RoleFor("cli", "anyone"); role != nil {
+		t.Fatalf("cli should be unrestricted, got role %q", role.Name)
+	}
+}
+
+func TestPolicy_MissingDefaultRoleGrantsNothing(t *testing.T) {
+	p := NewPolicy(config.PermissionsConfig{Enabled: true, DefaultRole: "nope"})
+	role := p.RoleFor("telegram", "1")
+	if role == nil {
+		t.Fatal("expected a restrictive role, got nil")
+	}
+	if role.AllowsTool("message") || role.AllowsCommand("/show") {
+		t.Error("role without grants should not allow anything")
+	}
+}
+
+func TestPolicy_TriggerRole(t *testing.T) {
+	if role := (*Policy)(nil).TriggerRole(); role != nil {
+		t.Fatalf("nil policy should resolve nil role, got %q", role.Name)
+	}
+
+	if role := testPolicy().TriggerRole(); role == nil || role.Name != "guest" {
+		t.Fatalf("expected the default role without trigger_role, got %+v", role)
+	}
+
+	p := NewPolicy(config.PermissionsConfig{
+		Enabled:     true,
+		DefaultRole: "guest",
+		TriggerRole: "automation",
+		Roles: map[string]config.RoleConfig{
+			"automation": {Tools: []string{"message"}},
+		},
+	})
+	if role := p.TriggerRole(); role == nil || role.Name != "automation" {
+		t.Fatalf("expected the configured trigger role, got %+v", role)
+	}
+}
+
+func TestRole_Grants(t *testing.T) {
+	member := testPolicy().RoleFor("slack", "U999")
+
+	checks := []struct {
+		name string
+		got  bool
+		want bool
+	}{
+		{"prefix tool", member.AllowsTool("web_fetch"), true},
+		{"exact tool", member.AllowsTool("read_file"), true},
+		{"denied tool", member.AllowsTool("exec"), false},
+		{"skill", member.AllowsSkill("weather"), true},
+		{"denied skill", member.AllowsSkill("github"), false},
+		{"command without slash in config", member.AllowsCommand("/show"), true},
+		{"command without slash in input", member.AllowsCommand("list"), true},
+		{"denied command", member.AllowsCommand("/switch"), false},
+		{"model", member.AllowsModel("gpt-4o-mini"), true},
+		{"denied model", member.AllowsModel("claude-opus"), false},
+	}
+	for _, c := range checks {
+		if c.got != c.want {
+			t.Errorf("%s: got %v, want %v", c.name, c.got, c.want)
+		}
+	}
+
+	var unrestricted *Role
+	if !unrestricted.AllowsTool("exec") || !unrestricted.AllowsModel("anything") {
+		t.Error("nil role should allow everything")
+	}
+}
+
+func TestRoleContext(t *testing.T) {
+	if RoleFromContext(context.Background()) != nil {
+		t.Fatal("expected no role on empty context")
+	}
+
+	role := testPolicy().RoleFor("slack", "U999")
+	ctx := WithRole(context.Background(), role)
+	if got := RoleFromContext(ctx); got != role {
+		t.Fatalf("RoleFromContext = %v, want %v", got, role)
+	}
+}
+
+func TestRestrict(t *testing.T) {
+	if Restrict(nil, "coder", nil, nil) != nil {
+		t.Error("restricting with no lists should leave a nil role unrestricted")
+	}
+
+	researcher := Restrict(nil, "researcher", []string{"web_*"}, []string{"weather"})
+	if !researcher.AllowsTool("web_search") || researcher.AllowsTool("exec") {
+		t.Error("expected only web tools to be allowed")
+	}
+	if !researcher.AllowsSkill("weather") || researcher.AllowsSkill("github") {
+		t.Error("expected only the weather skill to be allowed")
+	}
+	if !researcher.AllowsCommand("/switch") || !researcher.AllowsModel("anything") {
+		t.Error("profiles should not restrict commands or models")
+	}
+
+	// A restricted member role keeps the member's own limits.
+	member := testPolicy().RoleFor("slack", "U999")
+	narrowed := Restrict(member, "researcher", []string{"web_*", "exec"}, nil)
+	if narrowed.Name != "member" {
+		t.Errorf("expected the sender's role name, got %q", narrowed.Name)
+	}
+	tools := map[string]bool{
+		"web_search": true,  // allowed by both
+		"exec":       false, // allowed by the profile only
+		"read_file":  false, // allowed by the role only
+	}
+	for tool, want := range tools {
+		if got := narrowed.AllowsTool(tool); got != want {
+			t.Errorf("AllowsTool(%q) = %v, want %v", tool, got, want)
+		}
+	}
+	if narrowed.AllowsCommand("/switch") || !narrowed.AllowsCommand("/show") {
+		t.Error("expected the member's command grants to apply")
+	}
+}
+
+func TestPolicy_Role(t *testing.T) {
+	if role := (*Policy)(nil).Role("admin"); role != nil {
+		t.Fatalf("nil policy should resolve nil role, got %q", role.Name)
+	}
+
+	p := testPolicy()
+	if role := p.Role("member"); role == nil || !role.AllowsTool("read_file") {
+		t.Fatalf("expected the member role, got %+v", role)
+	}
+	if role := p.Role("removed"); role == nil || role.AllowsTool("web_search") {
+		t.Fatalf("expected a removed role to grant nothing, got %+v", role)
+	}
+}
+
+func TestIntersect(t *testing.T) {
+	member := testPolicy().RoleFor("slack", "U999")
+	guest := testPolicy().Role("guest")
+	if Intersect(nil, member) != member || Intersect(member, nil) != member {
+		t.Error("intersecting with nil should return the other role")
+	}
+
+	both := Intersect(member, guest)
+	if both.Name != "member" {
+		t.Errorf("expected the first role's name, got %q", both.Name)
+	}
+	if !both.AllowsTool("web_search") || both.AllowsTool("web_fetch") || both.AllowsTool("read_file") {
+		t.Error("expected only tools allowed by both roles")
+	}
+	if !member.AllowsTool("web_fetch") {
+		t.Error("intersecting must not change the original role")
+	}
+}
@@ -0,0 +1,35 @@
+package providers
+
+import "context"
+
+// observedProvider calls observe with every successful response of the
+// provider it wraps.
+type observedProvider struct {
+	LLMProvider
+	observe func(*LLMResponse)
+}
+
+func (p *observedProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	resp, err := p.LLMProvider.Chat(ctx, messages, tools, model, options)
+	if err == nil && resp != nil {
+		p.observe(resp)
+	}
+	return resp, err
+}
+
+// observedEmbedder keeps the EmbeddingProvider of the wrapped provider.
+type observedEmbedder struct {
+	*observedProvider
+	EmbeddingProvider
+}
+
+// Observe wraps provider so that observe is called with every response,
+// such as to count tokens. The wrapper embeds text if provider does.
+// observe must not block.
+func Observe(provider LLMProvider, observe func(*LLMResponse)) LLMProvider {
+	p := &observedProvider{LLMProvider: provider, observe: observe}
+	if embedder, ok := provider.(EmbeddingProvider); ok {
+		return &observedEmbedder{observedProvider: p, EmbeddingProvider: embedder}
+	}
+	return p
+}
@@ -0,0 +1,57 @@
+package providers
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+type stubProvider struct {
+	resp *LLMResponse
+	err  error
+}
+
+func (p *stubProvider) Chat(ctx context.Context, messages []Message, tools []ToolDefinition, model string, options map[string]interface{}) (*LLMResponse, error) {
+	return p.resp, p.err
+}
+
+func (p *stubProvider) GetDefaultModel() string { return "stub" }
+
+type stubEmbedder struct{ stubProvider }
+
+func (p *stubEmbedder) Embed(ctx context.Context, texts []string, model string) ([][]float32, error) {
+	return [][]float32{{1}}, nil
+}
+
+func TestObserve(t *testing.T) {
+	stub := &stubProvider{resp: &LLMResponse{Content: "hi", Usage: &UsageInfo{PromptTokens: 3}}}
+	var seen []*LLMResponse
+	p := Observe(stub, func(resp *LLMResponse) { seen = append(seen, resp) })
+
+	resp, err := p.Chat(context.Background(), nil, nil, "", nil)
+	if err != nil || resp != stub.resp {
+		t.Fatalf("Chat() = %v, %v; want the wrapped response", resp, err)
+	}
+	stub.err = errors.New("boom")
+	if _, err := p.Chat(context.Background(), nil, nil, "", nil); err == nil {
+		t.Fatal("Chat() error = nil, want the wrapped error")
+	}
+	if len(seen) != 1 || seen[0] != stub.resp {
+		t.Errorf("observed %d responses, want only the successful one", len(seen))
+	}
+	if p.GetDefaultModel() != "stub" {
+		t.Errorf("GetDefaultModel() = %q, want %q", p.GetDefaultModel(), "stub")
+	}
+	if _, ok := p.(EmbeddingProvider); ok {
+		t.Error("wrapper of a provider without embeddings should not embed")
+	}
+
+	embedding := Observe(&stubEmbedder{}, func(*LLMResponse) {})
+	embedder, ok := embedding.(EmbeddingProvider)
+	if !ok {
+		t.Fatal("wrapper of an embedding provider should embed")
+	}
+	if vectors, err := embedder.Embed(context.Background(), []string{"x"}, ""); err != nil || len(vectors) != 1 {
+		t.Errorf("Embed() = %v, %v", vectors, err)
+	}
+}
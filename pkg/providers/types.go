@@ -40,6 +40,12 @@ type LLMProvider interface {
 	GetDefaultModel() string
 }
 
+// EmbeddingProvider is implemented by providers that can embed text, such as
+// OpenAI-compatible APIs with an /embeddings endpoint.
+type EmbeddingProvider interface {
+	Embed(ctx context.Context, texts []string, model string) ([][]float32, error)
+}
+
 type ToolDefinition struct {
 	Type     string                 `json:"type"`
 	Function ToolFunctionDefinition `json:"function"`
@@ -3,17 +3,22 @@ package skills
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
 	"net/http"
 	"os"
+	"path"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
 	"time"
 )
 
 type SkillInstaller struct {
 	workspace string
+	mu        sync.Mutex
 }
 
 type AvailableSkill struct {
@@ -36,49 +41,288 @@ func NewSkillInstaller(workspace string) *SkillInstaller {
 	}
 }
 
+// Workspace returns the workspace skills are installed into.
+func (si *SkillInstaller) Workspace() string {
+	return si.workspace
+}
+
+// InstallFromGitHub installs a skill from a GitHub repository given as
+// owner/repo[/path][@ref].
 func (si *SkillInstaller) InstallFromGitHub(ctx context.Context, repo string) error {
-	skillDir := filepath.Join(si.workspace, "skills", filepath.Base(repo))
+	_, err := si.Install(ctx, repo)
+	return err
+}
+
+// Install installs the skill at source (see Source for the accepted forms)
+// into the workspace and records it in skills.lock. It returns the name
+// the skill was installed under.
+func (si *SkillInstaller) Install(ctx context.Context, source string) (string, error) {
+	src, err := ParseSource(source)
+	if err != nil {
+		return "", err
+	}
+
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
+	lock, err := LoadLock(si.workspace)
+	if err != nil {
+		return "", err
+	}
+	staged, entry, err := si.stage(ctx, src)
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(staged)
+	entry.Source = source
 
+	name, err := stagedSkillName(staged, src)
+	if err != nil {
+		return "", err
+	}
+	skillDir := filepath.Join(si.workspace, "skills", name)
 	if _, err := os.Stat(skillDir); err == nil {
-		return fmt.Errorf("skill '%s' already exists", filepath.Base(repo))
+		return "", fmt.Errorf("skill '%s' already exists", name)
+	}
+	if err := os.Rename(staged, skillDir); err != nil {
+		return "", fmt.Errorf("failed to install skill: %w", err)
 	}
 
-	url := fmt.Sprintf("https://raw.githubusercontent.com/%s/main/SKILL.md", repo)
+	lock.Skills[name] = entry
+	if err := lock.Save(si.workspace); err != nil {
+		return name, fmt.Errorf("installed, but failed to update %s: %w", LockFile, err)
+	}
+	return name, nil
+}
 
-	client := &http.Client{Timeout: 15 * time.Second}
-	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+// stage fetches src into a new directory next to the installed skills and
+// checks that it is a valid skill.
+func (si *SkillInstaller) stage(ctx context.Context, src Source) (string, *LockEntry, error) {
+	skillsDir := filepath.Join(si.workspace, "skills")
+	if err := os.MkdirAll(skillsDir, 0755); err != nil {
+		return "", nil, fmt.Errorf("failed to create skills directory: %w", err)
+	}
+	staged := filepath.Join(skillsDir, fmt.Sprintf(".staging-%d", time.Now().UnixNano()))
+
+	commit, err := src.fetch(ctx, staged)
+	if err != nil {
+		return "", nil, fmt.Errorf("failed to fetch skill: %w", err)
+	}
+	if _, err := LoadManifest(staged); err != nil {
+		os.RemoveAll(staged)
+		return "", nil, err
+	}
+	sum, err := HashDir(staged)
 	if err != nil {
-		return fmt.Errorf("failed to create request: %w", err)
+		os.RemoveAll(staged)
+		return "", nil, err
 	}
+	return staged, &LockEntry{
+		Type:        src.Type,
+		Ref:         src.Ref,
+		Commit:      commit,
+		SHA256:      sum,
+		InstalledAt: time.Now().UTC(),
+	}, nil
+}
 
-	resp, err := client.Do(req)
+// stagedSkillName returns the name a staged skill installs under: the name
+// in its SKILL.md, or else the last element of its source.
+func stagedSkillName(staged string, src Source) (string, error) {
+	var name string
+	if meta := (&SkillsLoader{}).getSkillMetadata(filepath.Join(staged, "SKILL.md")); meta != nil {
+		name = meta.Name
+	}
+	if name == "" || name == filepath.Base(staged) {
+		base := src.Subdir
+		if base == "" {
+			base = strings.TrimSuffix(src.URL, "/")
+		}
+		name = path.Base(filepath.ToSlash(base))
+		for _, suffix := range []string{".git", ".tar.gz", ".tgz"} {
+			name = strings.TrimSuffix(name, suffix)
+		}
+	}
+	if !namePattern.MatchString(name) || len(name) > MaxNameLength {
+		return "", fmt.Errorf("invalid skill name %q: must be alphanumeric with hyphens", name)
+	}
+	return name, nil
+}
+
+// SkillUpdate describes an installed skill that has a newer version.
+type SkillUpdate struct {
+	Name    string
+	Current string // installed tag, or commit for branches
+	Latest  string
+}
+
+// Outdated lists the locked skills that have a newer version: a higher
+// release tag for skills pinned to one, new commits for skills that follow
+// a branch, and changed content for tarballs and local directories.
+func (si *SkillInstaller) Outdated(ctx context.Context) ([]SkillUpdate, error) {
+	lock, err := LoadLock(si.workspace)
 	if err != nil {
-		return fmt.Errorf("failed to fetch skill: %w", err)
+		return nil, err
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != 200 {
-		return fmt.Errorf("failed to fetch skill: HTTP %d", resp.StatusCode)
+	names := make([]string, 0, len(lock.Skills))
+	for name := range lock.Skills {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+
+	var updates []SkillUpdate
+	var errs error
+	for _, name := range names {
+		update, err := si.checkUpdate(ctx, name, lock.Skills[name])
+		if err != nil {
+			errs = errors.Join(errs, fmt.Errorf("%s: %w", name, err))
+			continue
+		}
+		if update != nil {
+			updates = append(updates, *update)
+		}
+	}
+	return updates, errs
+}
 
-	body, err := io.ReadAll(resp.Body)
+func (si *SkillInstaller) checkUpdate(ctx context.Context, name string, entry *LockEntry) (*SkillUpdate, error) {
+	src, err := ParseSource(entry.Source)
 	if err != nil {
-		return fmt.Errorf("failed to read response: %w", err)
+		return nil, err
 	}
 
-	if err := os.MkdirAll(skillDir, 0755); err != nil {
-		return fmt.Errorf("failed to create skill directory: %w", err)
+	if src.Type == SourceGit {
+		if _, pinned := parseVersion(src.Ref); pinned {
+			latest, err := latestTag(ctx, src.URL)
+			if err != nil {
+				return nil, err
+			}
+			if latest != "" && compareVersions(latest, entry.Ref) > 0 {
+				return &SkillUpdate{Name: name, Current: entry.Ref, Latest: latest}, nil
+			}
+			return nil, nil
+		}
+		commit, err := remoteCommit(ctx, src.URL, src.Ref)
+		if err != nil {
+			return nil, err
+		}
+		if commit != entry.Commit {
+			return &SkillUpdate{Name: name, Current: shortCommit(entry.Commit), Latest: shortCommit(commit)}, nil
+		}
+		return nil, nil
 	}
 
-	skillPath := filepath.Join(skillDir, "SKILL.md")
-	if err := os.WriteFile(skillPath, body, 0644); err != nil {
-		return fmt.Errorf("failed to write skill file: %w", err)
+	staged, fetched, err := si.stage(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	os.RemoveAll(staged)
+	if fetched.SHA256 != entry.SHA256 {
+		return &SkillUpdate{Name: name, Current: entry.SHA256[:12], Latest: fetched.SHA256[:12]}, nil
 	}
+	return nil, nil
+}
 
-	return nil
+func shortCommit(commit string) string {
+	if len(commit) > 12 {
+		return commit[:12]
+	}
+	return commit
+}
+
+// Update reinstalls a locked skill from its source, moving skills pinned to
+// a release tag to the highest tag. It returns the update applied, or nil
+// if the skill was already current.
+func (si *SkillInstaller) Update(ctx context.Context, name string) (*SkillUpdate, error) {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
+	lock, err := LoadLock(si.workspace)
+	if err != nil {
+		return nil, err
+	}
+	entry, ok := lock.Skills[name]
+	if !ok {
+		return nil, fmt.Errorf("skill '%s' was not installed from a source and cannot be updated", name)
+	}
+	src, err := ParseSource(entry.Source)
+	if err != nil {
+		return nil, err
+	}
+
+	source := entry.Source
+	if src.Type == SourceGit {
+		if _, pinned := parseVersion(src.Ref); pinned {
+			latest, err := latestTag(ctx, src.URL)
+			if err != nil {
+				return nil, err
+			}
+			if latest != "" && compareVersions(latest, src.Ref) > 0 {
+				src.Ref = latest
+				source = strings.TrimSuffix(source, "@"+entry.Ref) + "@" + latest
+			}
+		}
+	}
+
+	staged, fetched, err := si.stage(ctx, src)
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(staged)
+	skillDir := filepath.Join(si.workspace, "skills", name)
+	// A skill modified on disk is restored even without a new version.
+	if fetched.SHA256 == entry.SHA256 && verifySkill(skillDir, entry) == nil {
+		return nil, nil
+	}
+
+	// Swap the directories so a failure leaves the old version in place.
+	backup := staged + ".old"
+	if err := os.Rename(skillDir, backup); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to replace skill: %w", err)
+	}
+	if err := os.Rename(staged, skillDir); err != nil {
+		os.Rename(backup, skillDir)
+		return nil, fmt.Errorf("failed to replace skill: %w", err)
+	}
+	os.RemoveAll(backup)
+
+	update := &SkillUpdate{Name: name, Current: entry.Ref, Latest: src.Ref}
+	if update.Current == update.Latest {
+		update.Current, update.Latest = shortCommit(entry.Commit), shortCommit(fetched.Commit)
+		if src.Type != SourceGit {
+			update.Current, update.Latest = entry.SHA256[:12], fetched.SHA256[:12]
+		}
+	}
+
+	fetched.Source = source
+	lock.Skills[name] = fetched
+	if err := lock.Save(si.workspace); err != nil {
+		return update, fmt.Errorf("updated, but failed to update %s: %w", LockFile, err)
+	}
+	return update, nil
+}
+
+// Verify checks the installed skills against skills.lock and returns the
+// problems found, keyed by skill name.
+func (si *SkillInstaller) Verify() (map[string]error, error) {
+	lock, err := LoadLock(si.workspace)
+	if err != nil {
+		return nil, err
+	}
+	problems := make(map[string]error)
+	for name, entry := range lock.Skills {
+		if err := verifySkill(filepath.Join(si.workspace, "skills", name), entry); err != nil {
+			problems[name] = err
+		}
+	}
+	return problems, nil
 }
 
 func (si *SkillInstaller) Uninstall(skillName string) error {
+	si.mu.Lock()
+	defer si.mu.Unlock()
+
 	skillDir := filepath.Join(si.workspace, "skills", skillName)
 
 	if _, err := os.Stat(skillDir); os.IsNotExist(err) {
@@ -89,6 +333,14 @@ func (si *SkillInstaller) Uninstall(skillName string) error {
 		return fmt.Errorf("failed to remove skill: %w", err)
 	}
 
+	lock, err := LoadLock(si.workspace)
+	if err != nil {
+		return err
+	}
+	if _, ok := lock.Skills[skillName]; ok {
+		delete(lock.Skills, skillName)
+		return lock.Save(si.workspace)
+	}
 	return nil
 }
 
@@ -9,6 +9,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 )
 
 var namePattern = regexp.MustCompile(`^[a-zA-Z0-9]+(-[a-zA-Z0-9]+)*$`)
@@ -56,6 +57,14 @@ type SkillsLoader struct {
 	workspaceSkills string // workspace skills (项目级别)
 	globalSkills    string // 全局 skills (~/.picoclaw/skills)
 	builtinSkills   string // 内置 skills
+
+	mu       sync.Mutex
+	verified map[string]verification // skill dir -> last check against skills.lock
+}
+
+type verification struct {
+	fingerprint string
+	err         error
 }
 
 func NewSkillsLoader(workspace string, globalSkills string, builtinSkills string) *SkillsLoader {
@@ -64,9 +73,37 @@ func NewSkillsLoader(workspace string, globalSkills string, builtinSkills string
 		workspaceSkills: filepath.Join(workspace, "skills"),
 		globalSkills:    globalSkills, // ~/.picoclaw/skills
 		builtinSkills:   builtinSkills,
+		verified:        make(map[string]verification),
 	}
 }
 
+// verify checks a workspace skill against skills.lock. Skills installed
+// with "picoclaw skills install" are refused when their files no longer
+// match the recorded hash; skills created by hand are not locked.
+func (sl *SkillsLoader) verify(name string) error {
+	lock, err := LoadLock(sl.workspace)
+	if err != nil {
+		return err
+	}
+	entry, ok := lock.Skills[name]
+	if !ok {
+		return nil
+	}
+
+	dir := filepath.Join(sl.workspaceSkills, name)
+	fp := fingerprint(dir)
+	key := dir + "\x00" + entry.SHA256
+
+	sl.mu.Lock()
+	defer sl.mu.Unlock()
+	if v, ok := sl.verified[key]; ok && v.fingerprint == fp {
+		return v.err
+	}
+	err = verifySkill(dir, entry)
+	sl.verified[key] = verification{fingerprint: fp, err: err}
+	return err
+}
+
 func (sl *SkillsLoader) ListSkills() []SkillInfo {
 	skills := make([]SkillInfo, 0)
 
@@ -90,6 +127,10 @@ func (sl *SkillsLoader) ListSkills() []SkillInfo {
 							slog.Warn("invalid skill from workspace", "name", info.Name, "error", err)
 							continue
 						}
+						if err := sl.verify(dir.Name()); err != nil {
+							slog.Warn("refusing skill that fails verification", "name", info.Name, "error", err)
+							continue
+						}
 						skills = append(skills, info)
 					}
 				}
@@ -183,7 +224,7 @@ func (sl *SkillsLoader) LoadSkill(name string) (string, bool) {
 	// 1. 优先从 workspace skills 加载（项目级别）
 	if sl.workspaceSkills != "" {
 		skillFile := filepath.Join(sl.workspaceSkills, name, "SKILL.md")
-		if content, err := os.ReadFile(skillFile); err == nil {
+		if content, err := os.ReadFile(skillFile); err == nil && sl.verify(name) == nil {
 			return sl.stripFrontmatter(string(content)), true
 		}
 	}
@@ -224,7 +265,22 @@ func (sl *SkillsLoader) LoadSkillsForContext(skillNames []string) string {
 }
 
 func (sl *SkillsLoader) BuildSkillsSummary() string {
+	return sl.BuildSkillsSummaryFiltered(nil)
+}
+
+// BuildSkillsSummaryFiltered builds the skills summary, listing only skills
+// for which allow returns true. A nil allow function lists every skill.
+func (sl *SkillsLoader) BuildSkillsSummaryFiltered(allow func(name string) bool) string {
 	allSkills := sl.ListSkills()
+	if allow != nil {
+		filtered := allSkills[:0]
+		for _, s := range allSkills {
+			if allow(s.Name) {
+				filtered = append(filtered, s)
+			}
+		}
+		allSkills = filtered
+	}
 	if len(allSkills) == 0 {
 		return ""
 	}
@@ -241,6 +297,9 @@ func (sl *SkillsLoader) BuildSkillsSummary() string {
 		lines = append(lines, fmt.Sprintf("    <description>%s</description>", escapedDesc))
 		lines = append(lines, fmt.Sprintf("    <location>%s</location>", escapedPath))
 		lines = append(lines, fmt.Sprintf("    <source>%s</source>", s.Source))
+		if names := toolNames(s.Path); len(names) > 0 {
+			lines = append(lines, fmt.Sprintf("    <tools>%s</tools>", escapeXML(strings.Join(names, ", "))))
+		}
 		lines = append(lines, "  </skill>")
 	}
 	lines = append(lines, "</skills>")
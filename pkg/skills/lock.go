@@ -0,0 +1,176 @@
+package skills
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// LockFile records the installed skills of a workspace, relative to it.
+const LockFile = "skills.lock"
+
+const lockVersion = 1
+
+// Lock is the content of skills.lock.
+type Lock struct {
+	Version int                   `json:"version"`
+	Skills  map[string]*LockEntry `json:"skills"`
+}
+
+// LockEntry records where an installed skill came from and the hash of
+// its files at install time.
+type LockEntry struct {
+	Source      string    `json:"source"`           // as given to install
+	Type        string    `json:"type"`             // git, tarball or local
+	Ref         string    `json:"ref,omitempty"`    // git tag or branch
+	Commit      string    `json:"commit,omitempty"` // git commit installed
+	SHA256      string    `json:"sha256"`           // HashDir of the skill directory
+	InstalledAt time.Time `json:"installed_at"`
+}
+
+// LoadLock reads the lock file of workspace. A missing file is an empty
+// lock.
+func LoadLock(workspace string) (*Lock, error) {
+	lock := &Lock{Version: lockVersion, Skills: make(map[string]*LockEntry)}
+	data, err := os.ReadFile(filepath.Join(workspace, LockFile))
+	if os.IsNotExist(err) {
+		return lock, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, lock); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", LockFile, err)
+	}
+	if lock.Skills == nil {
+		lock.Skills = make(map[string]*LockEntry)
+	}
+	return lock, nil
+}
+
+// Save writes the lock file of workspace atomically.
+func (l *Lock) Save(workspace string) error {
+	data, err := json.MarshalIndent(l, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(workspace, LockFile)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(data, '\n'), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// HashDir returns the SHA-256 of a skill directory: the hash of a sorted
+// list of "<sha256 of file>  <relative path>" lines, with the executable
+// bit recorded so that a script cannot silently become runnable. Version
+// control directories are ignored.
+func HashDir(dir string) (string, error) {
+	var lines []string
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%s: only regular files are allowed in a skill", filepath.ToSlash(rel))
+		}
+
+		f, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+		h := sha256.New()
+		_, err = io.Copy(h, f)
+		f.Close()
+		if err != nil {
+			return err
+		}
+		mode := ""
+		if info.Mode()&0111 != 0 {
+			mode = " x"
+		}
+		lines = append(lines, fmt.Sprintf("%x  %s%s\n", h.Sum(nil), filepath.ToSlash(rel), mode))
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+
+	sort.Strings(lines)
+	sum := sha256.Sum256([]byte(strings.Join(lines, "")))
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// verifySkill checks that the skill in dir still matches its lock entry.
+func verifySkill(dir string, entry *LockEntry) error {
+	sum, err := HashDir(dir)
+	if err != nil {
+		return err
+	}
+	if sum != entry.SHA256 {
+		return fmt.Errorf("files changed since install (sha256 %.12s, locked %.12s)", sum, entry.SHA256)
+	}
+	return nil
+}
+
+// VerifyInstalled checks the skill in dir against the skills.lock of
+// workspace, hashing its files again. Only skills installed into the
+// workspace skills directory are locked; any other dir passes.
+func VerifyInstalled(workspace, dir string) error {
+	rel, err := filepath.Rel(filepath.Join(workspace, "skills"), dir)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") || strings.ContainsRune(rel, filepath.Separator) {
+		return nil
+	}
+	lock, err := LoadLock(workspace)
+	if err != nil {
+		return err
+	}
+	entry, ok := lock.Skills[rel]
+	if !ok {
+		return nil
+	}
+	return verifySkill(dir, entry)
+}
+
+// fingerprint summarizes the names, sizes, modes and modification times of
+// the files in dir, to tell cheaply whether it needs hashing again.
+func fingerprint(dir string) string {
+	h := sha256.New()
+	filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			fmt.Fprintf(h, "%s error %v\n", path, err)
+			return nil
+		}
+		if d.IsDir() && d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if info, err := d.Info(); err == nil {
+			fmt.Fprintf(h, "%s %d %v %d\n", path, info.Size(), info.Mode(), info.ModTime().UnixNano())
+		}
+		return nil
+	})
+	return hex.EncodeToString(h.Sum(nil))
+}
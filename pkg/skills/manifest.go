@@ -0,0 +1,164 @@
+package skills
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// ManifestFile declares the tools a skill ships, next to its SKILL.md.
+const ManifestFile = "skill.json"
+
+const (
+	DefaultToolTimeout = 30  // seconds
+	MaxToolTimeout     = 600 // seconds
+)
+
+var toolNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// Manifest is the content of a skill's skill.json.
+//
+//	{
+//	  "tools": [{
+//	    "name": "weather_forecast",
+//	    "description": "Get the forecast for a city",
+//	    "parameters": {"type": "object", "properties": {"city": {"type": "string"}}, "required": ["city"]},
+//	    "entrypoint": "python3 scripts/forecast.py",
+//	    "timeout": 20
+//	  }]
+//	}
+type Manifest struct {
+	Tools []ToolSpec `json:"tools"`
+}
+
+// ToolSpec declares one executable tool. The entrypoint is a command line
+// run from the skill directory; it receives the tool arguments as a JSON
+// object on stdin and writes its result as JSON to stdout.
+type ToolSpec struct {
+	Name        string                 `json:"name"`
+	Description string                 `json:"description"`
+	Parameters  map[string]interface{} `json:"parameters,omitempty"`
+	Entrypoint  string                 `json:"entrypoint"`
+	Timeout     int                    `json:"timeout,omitempty"` // seconds
+
+	Skill string `json:"-"` // name of the skill declaring the tool
+	Dir   string `json:"-"` // skill directory, the entrypoint's working directory
+}
+
+func (spec ToolSpec) validate(dir string) error {
+	var errs error
+	if !toolNamePattern.MatchString(spec.Name) {
+		errs = errors.Join(errs, fmt.Errorf("tool name %q must be 1-64 letters, digits, '_' or '-'", spec.Name))
+	}
+	if spec.Description == "" {
+		errs = errors.Join(errs, fmt.Errorf("tool %s: description is required", spec.Name))
+	}
+	if t, ok := spec.Parameters["type"]; ok && t != "object" {
+		errs = errors.Join(errs, fmt.Errorf("tool %s: parameters must be an object schema", spec.Name))
+	}
+	if spec.Timeout < 0 || spec.Timeout > MaxToolTimeout {
+		errs = errors.Join(errs, fmt.Errorf("tool %s: timeout must be between 0 and %d seconds", spec.Name, MaxToolTimeout))
+	}
+
+	fields := strings.Fields(spec.Entrypoint)
+	if len(fields) == 0 {
+		errs = errors.Join(errs, fmt.Errorf("tool %s: entrypoint is required", spec.Name))
+		return errs
+	}
+	// The script is the first argument that names a file in the skill
+	// directory, e.g. "run.sh" or the "scripts/run.py" of "python3 scripts/run.py".
+	for _, f := range fields {
+		if !filepath.IsLocal(f) {
+			if strings.Contains(f, "..") {
+				errs = errors.Join(errs, fmt.Errorf("tool %s: entrypoint must stay inside the skill directory", spec.Name))
+			}
+			continue
+		}
+		if _, err := os.Stat(filepath.Join(dir, f)); err == nil {
+			return errs
+		}
+	}
+	return errors.Join(errs, fmt.Errorf("tool %s: entrypoint %q names no script in the skill directory", spec.Name, spec.Entrypoint))
+}
+
+// LoadManifest reads the manifest of the skill in dir. A skill without
+// skill.json has no tools and returns a nil manifest.
+func LoadManifest(dir string) (*Manifest, error) {
+	data, err := os.ReadFile(filepath.Join(dir, ManifestFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var manifest Manifest
+	if err := json.Unmarshal(data, &manifest); err != nil {
+		return nil, fmt.Errorf("invalid %s: %w", ManifestFile, err)
+	}
+
+	var errs error
+	seen := make(map[string]bool)
+	for i := range manifest.Tools {
+		spec := &manifest.Tools[i]
+		if err := spec.validate(dir); err != nil {
+			errs = errors.Join(errs, err)
+		}
+		if seen[spec.Name] {
+			errs = errors.Join(errs, fmt.Errorf("tool %s is declared twice", spec.Name))
+		}
+		seen[spec.Name] = true
+
+		if spec.Parameters == nil {
+			spec.Parameters = map[string]interface{}{"type": "object", "properties": map[string]interface{}{}}
+		}
+		if spec.Timeout == 0 {
+			spec.Timeout = DefaultToolTimeout
+		}
+		spec.Dir = dir
+	}
+	if errs != nil {
+		return nil, errs
+	}
+	return &manifest, nil
+}
+
+// ListTools returns the tools declared by the installed skills, with the
+// same precedence as ListSkills. Skills with an invalid manifest are
+// skipped as a whole.
+func (sl *SkillsLoader) ListTools() []ToolSpec {
+	var specs []ToolSpec
+	for _, info := range sl.ListSkills() {
+		manifest, err := LoadManifest(filepath.Dir(info.Path))
+		if err != nil {
+			slog.Warn("invalid skill manifest", "name", info.Name, "error", err)
+			continue
+		}
+		if manifest == nil {
+			continue
+		}
+		for _, spec := range manifest.Tools {
+			spec.Skill = info.Name
+			specs = append(specs, spec)
+		}
+	}
+	return specs
+}
+
+// toolNames returns the names of the tools the skill at skillFile declares.
+func toolNames(skillFile string) []string {
+	manifest, err := LoadManifest(filepath.Dir(skillFile))
+	if err != nil || manifest == nil {
+		return nil
+	}
+	names := make([]string, 0, len(manifest.Tools))
+	for _, spec := range manifest.Tools {
+		names = append(names, spec.Name)
+	}
+	return names
+}
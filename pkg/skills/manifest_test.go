@@ -0,0 +1,115 @@
+package skills
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeSkill(t *testing.T, dir, manifest string, files ...string) {
+	t.Helper()
+	require.NoError(t, os.MkdirAll(dir, 0755))
+	skill := "---\nname: " + filepath.Base(dir) + "\ndescription: test skill\n---\n# Skill\n"
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "SKILL.md"), []byte(skill), 0644))
+	if manifest != "" {
+		require.NoError(t, os.WriteFile(filepath.Join(dir, ManifestFile), []byte(manifest), 0644))
+	}
+	for _, f := range files {
+		require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(dir, f)), 0755))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, f), []byte("#!/bin/sh\n"), 0755))
+	}
+}
+
+func TestLoadManifest(t *testing.T) {
+	testcases := []struct {
+		name        string
+		manifest    string
+		files       []string
+		errContains []string
+	}{
+		{
+			name:     "valid",
+			manifest: `{"tools": [{"name": "forecast", "description": "Get the forecast", "entrypoint": "python3 scripts/forecast.py"}]}`,
+			files:    []string{"scripts/forecast.py"},
+		},
+		{
+			name:        "missing-script",
+			manifest:    `{"tools": [{"name": "forecast", "description": "Get the forecast", "entrypoint": "python3 forecast.py"}]}`,
+			errContains: []string{"names no script"},
+		},
+		{
+			name:        "escapes-skill-dir",
+			manifest:    `{"tools": [{"name": "forecast", "description": "Get the forecast", "entrypoint": "sh ../other/run.sh"}]}`,
+			errContains: []string{"inside the skill directory"},
+		},
+		{
+			name:        "bad-name-and-timeout",
+			manifest:    `{"tools": [{"name": "get forecast", "description": "d", "entrypoint": "./run.sh", "timeout": 9999}]}`,
+			files:       []string{"run.sh"},
+			errContains: []string{"tool name", "timeout"},
+		},
+		{
+			name:        "duplicate",
+			manifest:    `{"tools": [{"name": "a", "description": "d", "entrypoint": "./run.sh"}, {"name": "a", "description": "d", "entrypoint": "./run.sh"}]}`,
+			files:       []string{"run.sh"},
+			errContains: []string{"declared twice"},
+		},
+		{
+			name:        "not-an-object-schema",
+			manifest:    `{"tools": [{"name": "a", "description": "d", "entrypoint": "./run.sh", "parameters": {"type": "string"}}]}`,
+			files:       []string{"run.sh"},
+			errContains: []string{"object schema"},
+		},
+	}
+
+	for _, tc := range testcases {
+		t.Run(tc.name, func(t *testing.T) {
+			dir := filepath.Join(t.TempDir(), "weather")
+			writeSkill(t, dir, tc.manifest, tc.files...)
+
+			manifest, err := LoadManifest(dir)
+			if len(tc.errContains) > 0 {
+				for _, msg := range tc.errContains {
+					assert.ErrorContains(t, err, msg)
+				}
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, manifest.Tools, 1)
+			spec := manifest.Tools[0]
+			assert.Equal(t, DefaultToolTimeout, spec.Timeout)
+			assert.Equal(t, "object", spec.Parameters["type"])
+			assert.Equal(t, dir, spec.Dir)
+		})
+	}
+
+	manifest, err := LoadManifest(t.TempDir())
+	assert.NoError(t, err)
+	assert.Nil(t, manifest, "a skill without a manifest has no tools")
+}
+
+func TestSkillsLoader_ListTools(t *testing.T) {
+	workspace := t.TempDir()
+	global := t.TempDir()
+
+	writeSkill(t, filepath.Join(workspace, "skills", "weather"),
+		`{"tools": [{"name": "forecast", "description": "Get the forecast", "entrypoint": "./forecast.sh"}]}`, "forecast.sh")
+	writeSkill(t, filepath.Join(workspace, "skills", "notes"), "")
+	writeSkill(t, filepath.Join(workspace, "skills", "broken"), `{"tools": [{"name": "x"}]}`)
+	// Shadowed by the workspace skill of the same name.
+	writeSkill(t, filepath.Join(global, "weather"),
+		`{"tools": [{"name": "old_forecast", "description": "d", "entrypoint": "./forecast.sh"}]}`, "forecast.sh")
+
+	loader := NewSkillsLoader(workspace, global, "")
+	specs := loader.ListTools()
+	require.Len(t, specs, 1)
+	assert.Equal(t, "forecast", specs[0].Name)
+	assert.Equal(t, "weather", specs[0].Skill)
+
+	summary := loader.BuildSkillsSummary()
+	assert.True(t, strings.Contains(summary, "<tools>forecast</tools>"), summary)
+}
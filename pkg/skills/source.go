@@ -0,0 +1,418 @@
+package skills
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SourceGit     = "git"
+	SourceTarball = "tarball"
+	SourceLocal   = "local"
+
+	maxTarballSize = 50 << 20 // bytes, compressed
+	maxSkillSize   = 50 << 20 // bytes, extracted
+)
+
+// Source is where a skill is installed from.
+//
+//	owner/repo[/subdir][@ref]                 GitHub repository
+//	https://host/repo.git[//subdir][@ref]     any git URL (also ssh://, git@host:, file://)
+//	https://host/skill.tar.gz[//subdir]       tarball
+//	./path/to/skill                           local directory
+type Source struct {
+	Type   string
+	URL    string // repository or tarball URL, or absolute local path
+	Ref    string // git tag or branch; empty for the default branch
+	Subdir string // directory of the skill within the repository or tarball
+}
+
+// ParseSource parses an install source as accepted by "picoclaw skills install".
+func ParseSource(spec string) (Source, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" {
+		return Source{}, fmt.Errorf("empty skill source")
+	}
+
+	if isLocalPath(spec) {
+		abs, err := filepath.Abs(expandHome(spec))
+		if err != nil {
+			return Source{}, err
+		}
+		return Source{Type: SourceLocal, URL: abs}, nil
+	}
+
+	rest, ref := spec, ""
+	if at := strings.LastIndex(spec, "@"); at > strings.LastIndex(spec, "/") {
+		rest, ref = spec[:at], spec[at+1:]
+	}
+
+	scheme, hasScheme := "", false
+	if i := strings.Index(rest, "://"); i > 0 {
+		scheme, hasScheme = rest[:i], true
+	}
+	switch {
+	case hasScheme || strings.HasPrefix(rest, "git@"):
+		url, subdir := splitSubdir(rest)
+		lower := strings.ToLower(strings.SplitN(url, "?", 2)[0])
+		if (scheme == "http" || scheme == "https") && (strings.HasSuffix(lower, ".tar.gz") || strings.HasSuffix(lower, ".tgz")) {
+			if ref != "" {
+				return Source{}, fmt.Errorf("tarball sources cannot have an @ref")
+			}
+			return Source{Type: SourceTarball, URL: url, Subdir: subdir}, nil
+		}
+		return Source{Type: SourceGit, URL: url, Ref: ref, Subdir: subdir}, nil
+
+	default:
+		parts := strings.Split(strings.Trim(rest, "/"), "/")
+		if len(parts) < 2 || parts[0] == "" || parts[1] == "" {
+			return Source{}, fmt.Errorf("invalid skill source %q: want owner/repo[/path][@ref], a git or tarball URL, or a local path", spec)
+		}
+		return Source{
+			Type:   SourceGit,
+			URL:    fmt.Sprintf("https://github.com/%s/%s.git", parts[0], strings.TrimSuffix(parts[1], ".git")),
+			Ref:    ref,
+			Subdir: strings.Join(parts[2:], "/"),
+		}, nil
+	}
+}
+
+func isLocalPath(spec string) bool {
+	if strings.HasPrefix(spec, ".") || strings.HasPrefix(spec, "/") || strings.HasPrefix(spec, "~") || filepath.IsAbs(spec) {
+		return true
+	}
+	info, err := os.Stat(spec)
+	return err == nil && info.IsDir()
+}
+
+func expandHome(path string) string {
+	if strings.HasPrefix(path, "~") {
+		if home, err := os.UserHomeDir(); err == nil {
+			return filepath.Join(home, path[1:])
+		}
+	}
+	return path
+}
+
+// splitSubdir splits "scheme://host/path//subdir" into URL and subdir.
+func splitSubdir(url string) (string, string) {
+	start := 0
+	if i := strings.Index(url, "://"); i >= 0 {
+		start = i + 3
+	}
+	if i := strings.Index(url[start:], "//"); i >= 0 {
+		return url[:start+i], strings.Trim(url[start+i+2:], "/")
+	}
+	return url, ""
+}
+
+// String returns the canonical form of the source.
+func (s Source) String() string {
+	str := s.URL
+	if s.Subdir != "" {
+		str += "//" + s.Subdir
+	}
+	if s.Ref != "" {
+		str += "@" + s.Ref
+	}
+	return str
+}
+
+// fetch copies the skill directory of the source into dest, which must not
+// exist. For git sources it returns the commit fetched.
+func (s Source) fetch(ctx context.Context, dest string) (string, error) {
+	if s.Subdir != "" && !filepath.IsLocal(s.Subdir) {
+		return "", fmt.Errorf("invalid subdirectory %q", s.Subdir)
+	}
+
+	tmp, err := os.MkdirTemp(filepath.Dir(dest), ".fetch-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(tmp)
+
+	var root, commit string
+	switch s.Type {
+	case SourceLocal:
+		root = s.URL
+	case SourceGit:
+		root = filepath.Join(tmp, "repo")
+		if commit, err = gitClone(ctx, s.URL, s.Ref, root); err != nil {
+			return "", err
+		}
+	case SourceTarball:
+		root = filepath.Join(tmp, "archive")
+		if err := downloadTarball(ctx, s.URL, root); err != nil {
+			return "", err
+		}
+		root = unwrapSingleDir(root)
+	default:
+		return "", fmt.Errorf("unknown source type %q", s.Type)
+	}
+
+	dir := filepath.Join(root, filepath.FromSlash(s.Subdir))
+	if _, err := os.Stat(filepath.Join(dir, "SKILL.md")); err != nil {
+		return "", fmt.Errorf("no SKILL.md in %s", s)
+	}
+	if err := copySkillDir(dir, dest); err != nil {
+		os.RemoveAll(dest)
+		return "", err
+	}
+	return commit, nil
+}
+
+func gitClone(ctx context.Context, url, ref, dest string) (string, error) {
+	args := []string{"clone", "--quiet", "--depth", "1"}
+	if ref != "" {
+		args = append(args, "--branch", ref)
+	}
+	args = append(args, "--", url, dest)
+	if _, err := runGit(ctx, args...); err != nil {
+		return "", err
+	}
+	commit, err := runGit(ctx, "-C", dest, "rev-parse", "HEAD")
+	return strings.TrimSpace(commit), err
+}
+
+func runGit(ctx context.Context, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("git %s: %s", args[0], msg)
+		}
+		return "", fmt.Errorf("git %s: %w", args[0], err)
+	}
+	return stdout.String(), nil
+}
+
+func downloadTarball(ctx context.Context, url, dest string) error {
+	client := &http.Client{Timeout: 2 * time.Minute}
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %w", err)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to download %s: %w", url, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != 200 {
+		return fmt.Errorf("failed to download %s: HTTP %d", url, resp.StatusCode)
+	}
+
+	gz, err := gzip.NewReader(io.LimitReader(resp.Body, maxTarballSize))
+	if err != nil {
+		return fmt.Errorf("invalid tarball: %w", err)
+	}
+	return extractTar(tar.NewReader(gz), dest)
+}
+
+// extractTar extracts regular files and directories into dest. Entries
+// that would land outside dest, links and devices are refused.
+func extractTar(tr *tar.Reader, dest string) error {
+	var total int64
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("invalid tarball: %w", err)
+		}
+
+		name := strings.TrimPrefix(filepath.ToSlash(hdr.Name), "./")
+		if name == "" || name == "." {
+			continue
+		}
+		if !filepath.IsLocal(filepath.FromSlash(name)) {
+			return fmt.Errorf("tarball entry %q escapes the skill directory", hdr.Name)
+		}
+		target := filepath.Join(dest, filepath.FromSlash(name))
+
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := os.MkdirAll(target, 0755); err != nil {
+				return err
+			}
+		case tar.TypeReg:
+			total += hdr.Size
+			if total > maxSkillSize {
+				return fmt.Errorf("skill exceeds %d MB", maxSkillSize>>20)
+			}
+			if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+				return err
+			}
+			f, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, fileMode(hdr.FileInfo().Mode()))
+			if err != nil {
+				return err
+			}
+			_, err = io.Copy(f, io.LimitReader(tr, hdr.Size))
+			if cerr := f.Close(); err == nil {
+				err = cerr
+			}
+			if err != nil {
+				return err
+			}
+		case tar.TypeXGlobalHeader:
+			// pax metadata, e.g. the commit of a GitHub archive
+		default:
+			return fmt.Errorf("tarball entry %q: links and special files are not allowed", hdr.Name)
+		}
+	}
+}
+
+// unwrapSingleDir returns the only directory in root when it holds nothing
+// else, as in archives that wrap everything in "<name>-<version>/".
+func unwrapSingleDir(root string) string {
+	entries, err := os.ReadDir(root)
+	if err == nil && len(entries) == 1 && entries[0].IsDir() {
+		return filepath.Join(root, entries[0].Name())
+	}
+	return root
+}
+
+// fileMode keeps only the executable bit of a file's mode.
+func fileMode(mode os.FileMode) os.FileMode {
+	if mode&0111 != 0 {
+		return 0755
+	}
+	return 0644
+}
+
+// copySkillDir copies the regular files of src to dest, skipping version
+// control directories.
+func copySkillDir(src, dest string) error {
+	var total int64
+	return filepath.Walk(src, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(src, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(dest, rel)
+
+		if info.IsDir() {
+			if info.Name() == ".git" && rel != "." {
+				return filepath.SkipDir
+			}
+			return os.MkdirAll(target, 0755)
+		}
+		if !info.Mode().IsRegular() {
+			return fmt.Errorf("%s: only regular files are allowed in a skill", filepath.ToSlash(rel))
+		}
+		total += info.Size()
+		if total > maxSkillSize {
+			return fmt.Errorf("skill exceeds %d MB", maxSkillSize>>20)
+		}
+
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		return os.WriteFile(target, data, fileMode(info.Mode()))
+	})
+}
+
+// remoteCommit returns the commit ref points to in a git repository, or the
+// default branch's when ref is empty.
+func remoteCommit(ctx context.Context, url, ref string) (string, error) {
+	if ref == "" {
+		ref = "HEAD"
+	}
+	out, err := runGit(ctx, "ls-remote", "--", url, ref, ref+"^{}")
+	if err != nil {
+		return "", err
+	}
+	// Annotated tags list the tag object and, with ^{}, the commit.
+	var commit string
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) != 2 {
+			continue
+		}
+		if commit == "" || strings.HasSuffix(fields[1], "^{}") {
+			commit = fields[0]
+		}
+	}
+	if commit == "" {
+		return "", fmt.Errorf("%s not found in %s", ref, url)
+	}
+	return commit, nil
+}
+
+// latestTag returns the highest version tag of a git repository, or "" if
+// it has none.
+func latestTag(ctx context.Context, url string) (string, error) {
+	out, err := runGit(ctx, "ls-remote", "--tags", "--refs", "--", url)
+	if err != nil {
+		return "", err
+	}
+	var tags []string
+	for _, line := range strings.Split(strings.TrimSpace(out), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 {
+			tag := strings.TrimPrefix(fields[1], "refs/tags/")
+			if _, ok := parseVersion(tag); ok {
+				tags = append(tags, tag)
+			}
+		}
+	}
+	if len(tags) == 0 {
+		return "", nil
+	}
+	sort.Slice(tags, func(i, j int) bool { return compareVersions(tags[i], tags[j]) < 0 })
+	return tags[len(tags)-1], nil
+}
+
+// parseVersion parses release tags such as "v1.2.3" or "1.2". Pre-releases
+// are not versions an update should move to.
+func parseVersion(tag string) ([3]int, bool) {
+	var v [3]int
+	parts := strings.Split(strings.TrimPrefix(tag, "v"), ".")
+	if len(parts) == 0 || len(parts) > 3 {
+		return v, false
+	}
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return v, false
+		}
+		v[i] = n
+	}
+	return v, true
+}
+
+func compareVersions(a, b string) int {
+	va, _ := parseVersion(a)
+	vb, _ := parseVersion(b)
+	for i := range va {
+		if va[i] != vb[i] {
+			if va[i] < vb[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+	return 0
+}
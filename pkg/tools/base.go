@@ -17,6 +17,36 @@ type ContextualTool interface {
 	SetContext(channel, chatID string)
 }
 
+// ConcurrentTool is an optional interface for tools that can run in parallel
+// with other calls from the same LLM response. Tools that change state the
+// model may read back in the same turn, or that use per-call context set
+// through SetContext, must not report true.
+type ConcurrentTool interface {
+	Tool
+	ConcurrencySafe() bool
+}
+
+// SkillProvidedTool is implemented by tools declared by a skill. Roles
+// that may not use the skill can neither see nor execute its tools.
+type SkillProvidedTool interface {
+	Tool
+	SkillName() string
+}
+
+type sessionKeyCtxKey struct{}
+
+// WithSessionKey returns a copy of ctx carrying the session key of the
+// conversation the tools are executing for.
+func WithSessionKey(ctx context.Context, sessionKey string) context.Context {
+	return context.WithValue(ctx, sessionKeyCtxKey{}, sessionKey)
+}
+
+// SessionKeyFromContext returns the session key set by WithSessionKey, or "".
+func SessionKeyFromContext(ctx context.Context) string {
+	key, _ := ctx.Value(sessionKeyCtxKey{}).(string)
+	return key
+}
+
 // AsyncCallback is a function type that async tools use to notify completion.
 // When an async tool finishes its work, it calls this callback with the result.
 //
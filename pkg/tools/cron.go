@@ -3,17 +3,31 @@ package tools
 import (
 	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"sync"
 	"time"
 
 	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/constants"
 	"github.com/sipeed/picoclaw/pkg/cron"
+	"github.com/sipeed/picoclaw/pkg/devices/events"
+	"github.com/sipeed/picoclaw/pkg/permissions"
 	"github.com/sipeed/picoclaw/pkg/utils"
 )
 
 // JobExecutor is the interface for executing cron jobs through the agent
 type JobExecutor interface {
-	ProcessDirectWithChannel(ctx context.Context, content, sessionKey, channel, chatID string) (string, error)
+	// ProcessJobWithChannel runs a scheduled job with the permissions of role.
+	ProcessJobWithChannel(ctx context.Context, content, sessionKey, channel, chatID string, role *permissions.Role) (string, error)
+	// ProcessEventWithChannel runs an event job with the permissions of
+	// sender ("channel:sender_id"), or of the trigger role when sender is
+	// empty, since the event text comes from outside picoclaw. The job never
+	// gets more than role allows.
+	ProcessEventWithChannel(ctx context.Context, content, sessionKey, channel, chatID, sender string, role *permissions.Role) (string, error)
+	// JobRole returns the role a job runs with, given the role name saved on
+	// it when it was created and the channel it belongs to.
+	JobRole(name, channel string) *permissions.Role
 }
 
 // CronTool provides scheduling capabilities for the agent
@@ -22,6 +36,8 @@ type CronTool struct {
 	executor    JobExecutor
 	msgBus      *bus.MessageBus
 	execTool    *ExecTool
+	workspace   string
+	restrict    bool
 	channel     string
 	chatID      string
 	mu          sync.RWMutex
@@ -34,6 +50,8 @@ func NewCronTool(cronService *cron.CronService, executor JobExecutor, msgBus *bu
 		executor:    executor,
 		msgBus:      msgBus,
 		execTool:    NewExecTool(workspace, restrict),
+		workspace:   workspace,
+		restrict:    restrict,
 	}
 }
 
@@ -44,7 +62,7 @@ func (t *CronTool) Name() string {
 
 // Description returns the tool description
 func (t *CronTool) Description() string {
-	return "Schedule reminders, tasks, or system commands. IMPORTANT: When user asks to be reminded or scheduled, you MUST call this tool. Use 'at_seconds' for one-time reminders (e.g., 'remind me in 10 minutes' → at_seconds=600). Use 'every_seconds' ONLY for recurring tasks (e.g., 'every 2 hours' → every_seconds=7200). Use 'cron_expr' for complex recurring schedules. Use 'command' to execute shell commands directly."
+	return "Schedule reminders, tasks, or system commands. IMPORTANT: When user asks to be reminded or scheduled, you MUST call this tool. Use 'at_seconds' for one-time reminders (e.g., 'remind me in 10 minutes' → at_seconds=600). Use 'every_seconds' ONLY for recurring tasks (e.g., 'every 2 hours' → every_seconds=7200). Use 'cron_expr' for complex recurring schedules. Use 'trigger' instead of a schedule to run the task when something happens (a workspace file changes, a USB device is plugged in, the camera detects something, a webhook is called, or a chat message contains a keyword). Use 'command' to execute shell commands directly."
 }
 
 // Parameters returns the tool parameters schema
@@ -54,8 +72,8 @@ func (t *CronTool) Parameters() map[string]interface{} {
 		"properties": map[string]interface{}{
 			"action": map[string]interface{}{
 				"type":        "string",
-				"enum":        []string{"add", "list", "remove", "enable", "disable"},
-				"description": "Action to perform. Use 'add' when user wants to schedule a reminder or task.",
+				"enum":        []string{"add", "list", "remove", "enable", "disable", "history"},
+				"description": "Action to perform. Use 'add' when user wants to schedule a reminder or task. Use 'history' to see a job's recent runs.",
 			},
 			"message": map[string]interface{}{
 				"type":        "string",
@@ -79,12 +97,70 @@ func (t *CronTool) Parameters() map[string]interface{} {
 			},
 			"job_id": map[string]interface{}{
 				"type":        "string",
-				"description": "Job ID (for remove/enable/disable)",
+				"description": "Job ID (for remove/enable/disable/history)",
 			},
 			"deliver": map[string]interface{}{
 				"type":        "boolean",
 				"description": "If true, send message directly to channel. If false, let agent process message (for complex tasks). Default: true",
 			},
+			"output": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{cron.OutputChat, cron.OutputOnChange, cron.OutputOnError, cron.OutputFile, cron.OutputSilent},
+				"description": "Where the job's result goes: 'chat' (default, always send), 'on_change' (only when the result differs from the last run, e.g. monitoring), 'on_error' (only failures), 'file' (append to output_file), 'silent' (only keep it in the job state).",
+			},
+			"output_file": map[string]interface{}{
+				"type":        "string",
+				"description": "Workspace-relative file to append results to when output is 'file' (e.g. 'reports/disk.md').",
+			},
+			"trigger": map[string]interface{}{
+				"type":        "object",
+				"description": "Run the job on an event instead of a schedule.",
+				"properties": map[string]interface{}{
+					"type": map[string]interface{}{
+						"type": "string",
+						"enum": []string{cron.TriggerFile, cron.TriggerUSB, cron.TriggerMaixCam, cron.TriggerWebhook, cron.TriggerKeyword},
+					},
+					"path": map[string]interface{}{
+						"type":        "string",
+						"description": "file: workspace-relative glob, e.g. 'inbox/*.pdf'",
+					},
+					"action": map[string]interface{}{
+						"type":        "string",
+						"description": "Optional filter. file: created, changed, removed. usb: add, remove.",
+					},
+					"match": map[string]interface{}{
+						"type":        "string",
+						"description": "usb: device vendor/product substring. maixcam: detected class (e.g. 'person'). keyword: the keyword to watch for.",
+					},
+					"min_score": map[string]interface{}{
+						"type":        "number",
+						"description": "maixcam: minimum detection confidence between 0 and 1.",
+					},
+					"cooldown_seconds": map[string]interface{}{
+						"type":        "integer",
+						"description": "Minimum seconds between runs (default 60 for maixcam, otherwise 0).",
+					},
+				},
+				"required": []string{"type"},
+			},
+			"timeout_seconds": map[string]interface{}{
+				"type":        "integer",
+				"description": "Optional: abort a run after this many seconds (default 600).",
+			},
+			"max_retries": map[string]interface{}{
+				"type":        "integer",
+				"description": "Optional: retry a failed run up to this many times with exponential backoff (default 0).",
+			},
+			"concurrency": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{cron.ConcurrencySkip, cron.ConcurrencyQueue},
+				"description": "Optional: what to do if the job is due while its previous run is still going: 'skip' (default) or 'queue'.",
+			},
+			"missed_run": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{cron.MissedRunSkip, cron.MissedRunOnce},
+				"description": "Optional: what to do with runs missed while picoclaw was offline: 'skip' (default) or 'run_once' on startup.",
+			},
 		},
 		"required": []string{"action"},
 	}
@@ -107,7 +183,7 @@ func (t *CronTool) Execute(ctx context.Context, args map[string]interface{}) *To
 
 	switch action {
 	case "add":
-		return t.addJob(args)
+		return t.addJob(ctx, args)
 	case "list":
 		return t.listJobs()
 	case "remove":
@@ -116,12 +192,14 @@ func (t *CronTool) Execute(ctx context.Context, args map[string]interface{}) *To
 		return t.enableJob(args, true)
 	case "disable":
 		return t.enableJob(args, false)
+	case "history":
+		return t.jobHistory(args)
 	default:
 		return ErrorResult(fmt.Sprintf("unknown action: %s", action))
 	}
 }
 
-func (t *CronTool) addJob(args map[string]interface{}) *ToolResult {
+func (t *CronTool) addJob(ctx context.Context, args map[string]interface{}) *ToolResult {
 	t.mu.RLock()
 	channel := t.channel
 	chatID := t.chatID
@@ -161,8 +239,13 @@ func (t *CronTool) addJob(args map[string]interface{}) *ToolResult {
 			Kind: "cron",
 			Expr: cronExpr,
 		}
+	} else if raw, ok := args["trigger"].(map[string]interface{}); ok {
+		schedule = cron.CronSchedule{
+			Kind:    "event",
+			Trigger: parseTrigger(raw),
+		}
 	} else {
-		return ErrorResult("one of at_seconds, every_seconds, or cron_expr is required")
+		return ErrorResult("one of at_seconds, every_seconds, cron_expr or trigger is required")
 	}
 
 	// Read deliver parameter, default to true
@@ -171,7 +254,40 @@ func (t *CronTool) addJob(args map[string]interface{}) *ToolResult {
 		deliver = d
 	}
 
+	output, _ := args["output"].(string)
+	outputFile, _ := args["output_file"].(string)
+	if !cron.ValidOutputMode(output) {
+		return ErrorResult(fmt.Sprintf("invalid output mode: %s", output))
+	}
+	if output == cron.OutputFile {
+		if outputFile == "" {
+			return ErrorResult("output_file is required when output is 'file'")
+		}
+		if _, err := validatePath(outputFile, t.workspace, t.restrict); err != nil {
+			return ErrorResult(fmt.Sprintf("invalid output_file: %v", err))
+		}
+	}
+
+	policy := cron.CronPolicy{}
+	if v, ok := args["timeout_seconds"].(float64); ok {
+		policy.TimeoutSec = int(v)
+	}
+	if v, ok := args["max_retries"].(float64); ok {
+		policy.MaxRetries = int(v)
+	}
+	policy.Concurrency, _ = args["concurrency"].(string)
+	policy.MissedRun, _ = args["missed_run"].(string)
+	if err := policy.Validate(); err != nil {
+		return ErrorResult(err.Error())
+	}
+
+	// The job runs with the creator's role, so scheduling cannot be used to
+	// get tools the creator is not allowed to use.
+	role := permissions.RoleFromContext(ctx)
 	command, _ := args["command"].(string)
+	if command != "" && !role.AllowsTool("exec") {
+		return ErrorResult("command jobs require permission to use the exec tool")
+	}
 	if command != "" {
 		// Commands must be processed by agent/exec tool, so deliver must be false (or handled specifically)
 		// Actually, let's keep deliver=false to let the system know it's not a simple chat message
@@ -195,15 +311,80 @@ func (t *CronTool) addJob(args map[string]interface{}) *ToolResult {
 		return ErrorResult(fmt.Sprintf("Error adding job: %v", err))
 	}
 
-	if command != "" {
+	if command != "" || output != "" || policy != (cron.CronPolicy{}) || role != nil {
 		job.Payload.Command = command
+		job.Payload.Output = output
+		job.Payload.OutputFile = outputFile
+		if role != nil {
+			job.Payload.Role = role.Name
+		}
+		job.Policy = policy
 		// Need to save the updated payload
 		t.cronService.UpdateJob(job)
 	}
 
+	if trigger := job.Schedule.Trigger; trigger != nil && trigger.Type == cron.TriggerWebhook {
+		return SilentResult(fmt.Sprintf("Cron job added: %s (id: %s). Trigger it with an HTTP POST to /hooks/%s on the gateway port, sending header X-Picoclaw-Token: %s",
+			job.Name, job.ID, job.ID, trigger.Token))
+	}
 	return SilentResult(fmt.Sprintf("Cron job added: %s (id: %s)", job.Name, job.ID))
 }
 
+func parseTrigger(raw map[string]interface{}) *cron.CronTrigger {
+	trigger := &cron.CronTrigger{}
+	trigger.Type, _ = raw["type"].(string)
+	trigger.Path, _ = raw["path"].(string)
+	trigger.Action, _ = raw["action"].(string)
+	trigger.Match, _ = raw["match"].(string)
+	trigger.MinScore, _ = raw["min_score"].(float64)
+	if v, ok := raw["cooldown_seconds"].(float64); ok {
+		trigger.CooldownSec = int(v)
+	}
+	return trigger
+}
+
+// ObserveInbound fires keyword and maixcam triggers for inbound messages.
+// Register it with MessageBus.OnInbound.
+func (t *CronTool) ObserveInbound(msg bus.InboundMessage) {
+	if constants.IsInternalChannel(msg.Channel) {
+		return
+	}
+
+	if msg.Channel == "maixcam" && msg.Metadata["class_name"] != "" {
+		var score float64
+		fmt.Sscanf(msg.Metadata["score"], "%f", &score)
+		t.cronService.HandleEvent(cron.TriggerEvent{
+			Type:    cron.TriggerMaixCam,
+			Subject: msg.Metadata["class_name"],
+			Score:   score,
+			Summary: msg.Content,
+			Sender:  msg.Channel + ":" + msg.SenderID,
+		})
+		return
+	}
+
+	t.cronService.HandleEvent(cron.TriggerEvent{
+		Type:    cron.TriggerKeyword,
+		Subject: msg.Content,
+		Summary: fmt.Sprintf("message on %s (chat %s): %s", msg.Channel, msg.ChatID, utils.Truncate(msg.Content, 500)),
+		Sender:  msg.Channel + ":" + msg.SenderID,
+	})
+}
+
+// HandleDeviceEvent fires usb triggers. Register it with
+// devices.Service.SetEventHandler.
+func (t *CronTool) HandleDeviceEvent(ev *events.DeviceEvent) {
+	if ev.Kind != events.KindUSB {
+		return
+	}
+	t.cronService.HandleEvent(cron.TriggerEvent{
+		Type:    cron.TriggerUSB,
+		Action:  string(ev.Action),
+		Subject: ev.Vendor + " " + ev.Product,
+		Summary: ev.FormatMessage(),
+	})
+}
+
 func (t *CronTool) listJobs() *ToolResult {
 	jobs := t.cronService.ListJobs(false)
 
@@ -220,10 +401,23 @@ func (t *CronTool) listJobs() *ToolResult {
 			scheduleInfo = j.Schedule.Expr
 		} else if j.Schedule.Kind == "at" {
 			scheduleInfo = "one-time"
+		} else if j.Schedule.Kind == "event" && j.Schedule.Trigger != nil {
+			scheduleInfo = describeTrigger(j.Schedule.Trigger)
 		} else {
 			scheduleInfo = "unknown"
 		}
-		result += fmt.Sprintf("- %s (id: %s, %s)\n", j.Name, j.ID, scheduleInfo)
+		output := j.Payload.Output
+		if output == "" {
+			output = cron.OutputChat
+		}
+		result += fmt.Sprintf("- %s (id: %s, %s, output: %s)\n", j.Name, j.ID, scheduleInfo, output)
+		if j.State.LastStatus != "" {
+			last := j.State.LastOutput
+			if j.State.LastStatus == "error" {
+				last = j.State.LastError
+			}
+			result += fmt.Sprintf("  last run: %s: %s\n", j.State.LastStatus, utils.Truncate(last, 100))
+		}
 	}
 
 	return SilentResult(result)
@@ -259,8 +453,40 @@ func (t *CronTool) enableJob(args map[string]interface{}, enable bool) *ToolResu
 	return SilentResult(fmt.Sprintf("Cron job '%s' %s", job.Name, status))
 }
 
-// ExecuteJob executes a cron job through the agent
-func (t *CronTool) ExecuteJob(ctx context.Context, job *cron.CronJob) string {
+func (t *CronTool) jobHistory(args map[string]interface{}) *ToolResult {
+	jobID, ok := args["job_id"].(string)
+	if !ok || jobID == "" {
+		return ErrorResult("job_id is required for history")
+	}
+
+	runs, err := t.cronService.History(jobID, 10)
+	if err != nil {
+		return ErrorResult(fmt.Sprintf("failed to read history: %v", err)).WithError(err)
+	}
+	if len(runs) == 0 {
+		return SilentResult(fmt.Sprintf("No runs recorded for job %s", jobID))
+	}
+
+	result := fmt.Sprintf("Recent runs of job %s (newest first):\n", jobID)
+	for _, r := range runs {
+		started := time.UnixMilli(r.StartedAtMS).Format("2006-01-02 15:04:05")
+		result += fmt.Sprintf("- %s %s (%dms)", started, r.Status, r.DurationMS)
+		if r.Attempt > 1 {
+			result += fmt.Sprintf(", attempt %d", r.Attempt)
+		}
+		if r.Error != "" {
+			result += ": " + r.Error
+		} else if r.Output != "" {
+			result += ": " + utils.Truncate(r.Output, 100)
+		}
+		result += "\n"
+	}
+	return SilentResult(result)
+}
+
+// ExecuteJob executes a cron job and routes its result according to the
+// job's output mode. The returned output is stored in the job state.
+func (t *CronTool) ExecuteJob(ctx context.Context, job *cron.CronJob) (string, error) {
 	// Get channel/chatID from job payload
 	channel := job.Payload.Channel
 	chatID := job.Payload.To
@@ -273,55 +499,119 @@ func (t *CronTool) ExecuteJob(ctx context.Context, job *cron.CronJob) string {
 		chatID = "direct"
 	}
 
+	ctx = WithSentMessages(ctx)
+	output, err := t.runJob(ctx, job, channel, chatID)
+	t.routeOutput(job, channel, chatID, output, err, MessageSentTo(ctx, channel, chatID))
+	return output, err
+}
+
+func (t *CronTool) runJob(ctx context.Context, job *cron.CronJob, channel, chatID string) (string, error) {
+	role := t.executor.JobRole(job.Payload.Role, channel)
+
 	// Execute command if present
 	if job.Payload.Command != "" {
+		if !role.AllowsTool("exec") {
+			return "", fmt.Errorf("scheduled command refused: role %q may not use the exec tool", role.Name)
+		}
 		args := map[string]interface{}{
 			"command": job.Payload.Command,
 		}
 
 		result := t.execTool.Execute(ctx, args)
-		var output string
 		if result.IsError {
-			output = fmt.Sprintf("Error executing scheduled command: %s", result.ForLLM)
-		} else {
-			output = fmt.Sprintf("Scheduled command '%s' executed:\n%s", job.Payload.Command, result.ForLLM)
+			return "", fmt.Errorf("scheduled command failed: %s", result.ForLLM)
 		}
-
-		t.msgBus.PublishOutbound(bus.OutboundMessage{
-			Channel: channel,
-			ChatID:  chatID,
-			Content: output,
-		})
-		return "ok"
+		return fmt.Sprintf("Scheduled command '%s' executed:\n%s", job.Payload.Command, result.ForLLM), nil
 	}
 
-	// If deliver=true, send message directly without agent processing
+	// If deliver=true, the message itself is the output
 	if job.Payload.Deliver {
-		t.msgBus.PublishOutbound(bus.OutboundMessage{
-			Channel: channel,
-			ChatID:  chatID,
-			Content: job.Payload.Message,
-		})
-		return "ok"
+		return job.Payload.Message, nil
 	}
 
 	// For deliver=false, process through agent (for complex tasks)
 	sessionKey := fmt.Sprintf("cron-%s", job.ID)
-
-	// Call agent with job's message
-	response, err := t.executor.ProcessDirectWithChannel(
+	if job.Schedule.Kind == "event" {
+		return t.executor.ProcessEventWithChannel(ctx, job.Payload.Message, sessionKey, channel, chatID, job.State.LastEventSender, role)
+	}
+	return t.executor.ProcessJobWithChannel(
 		ctx,
 		job.Payload.Message,
 		sessionKey,
 		channel,
 		chatID,
+		role,
 	)
+}
 
+// routeOutput delivers a job result according to job.Payload.Output.
+// job.State still holds the previous run's state at this point. sentToChat
+// reports that the agent already sent to the job's chat with the message
+// tool during the run; a successful result is then not sent there again.
+func (t *CronTool) routeOutput(job *cron.CronJob, channel, chatID, output string, runErr error, sentToChat bool) {
+	content := output
+	if runErr != nil {
+		content = fmt.Sprintf("Scheduled task '%s' failed: %v", job.Name, runErr)
+	}
+
+	switch job.Payload.Output {
+	case cron.OutputSilent:
+		return
+	case cron.OutputOnError:
+		if runErr == nil {
+			return
+		}
+	case cron.OutputOnChange:
+		if runErr == nil && cron.OutputHash(output) == job.State.LastOutputHash {
+			return
+		}
+	case cron.OutputFile:
+		if err := t.appendOutputFile(job, content); err != nil {
+			// Fall back to chat so the result is not lost.
+			content = fmt.Sprintf("%s\n\n(could not write %s: %v)", content, job.Payload.OutputFile, err)
+			break
+		}
+		return
+	}
+
+	if content == "" || (runErr == nil && sentToChat) {
+		return
+	}
+	t.msgBus.PublishOutbound(bus.OutboundMessage{
+		Channel: channel,
+		ChatID:  chatID,
+		Content: content,
+	})
+}
+
+func (t *CronTool) appendOutputFile(job *cron.CronJob, content string) error {
+	path, err := validatePath(job.Payload.OutputFile, t.workspace, t.restrict)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
 	if err != nil {
-		return fmt.Sprintf("Error: %v", err)
+		return err
 	}
+	defer f.Close()
 
-	// Response is automatically sent via MessageBus by AgentLoop
-	_ = response // Will be sent by AgentLoop
-	return "ok"
+	_, err = fmt.Fprintf(f, "## %s (%s)\n\n%s\n\n", job.Name, time.Now().Format("2006-01-02 15:04:05"), content)
+	return err
+}
+
+func describeTrigger(trigger *cron.CronTrigger) string {
+	desc := "on " + trigger.Type
+	if trigger.Action != "" {
+		desc += " " + trigger.Action
+	}
+	if trigger.Path != "" {
+		desc += " " + trigger.Path
+	}
+	if trigger.Match != "" {
+		desc += fmt.Sprintf(" %q", trigger.Match)
+	}
+	return desc
 }
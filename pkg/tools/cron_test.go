@@ -0,0 +1,282 @@
+package tools
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/cron"
+	"github.com/sipeed/picoclaw/pkg/permissions"
+)
+
+type stubJobExecutor struct {
+	response string
+	err      error
+	sender   string            // sender of the last event job
+	role     *permissions.Role // role of the last job
+	roles    map[string]*permissions.Role
+	ran      bool
+	during   func(ctx context.Context) // called while the job runs
+}
+
+func (e *stubJobExecutor) ProcessJobWithChannel(ctx context.Context, content, sessionKey, channel, chatID string, role *permissions.Role) (string, error) {
+	e.ran, e.role = true, role
+	if e.during != nil {
+		e.during(ctx)
+	}
+	return e.response, e.err
+}
+
+func (e *stubJobExecutor) ProcessEventWithChannel(ctx context.Context, content, sessionKey, channel, chatID, sender string, role *permissions.Role) (string, error) {
+	e.ran, e.sender, e.role = true, sender, role
+	return e.response, e.err
+}
+
+func (e *stubJobExecutor) JobRole(name, channel string) *permissions.Role {
+	return e.roles[name]
+}
+
+func newTestCronTool(t *testing.T, exec JobExecutor) (*CronTool, *bus.MessageBus, string) {
+	t.Helper()
+	workspace := t.TempDir()
+	msgBus := bus.NewMessageBus()
+	cs := cron.NewCronService(filepath.Join(workspace, "cron", "jobs.json"), nil)
+	return NewCronTool(cs, exec, msgBus, workspace, true), msgBus, workspace
+}
+
+func agentJob(output string) *cron.CronJob {
+	return &cron.CronJob{
+		ID:   "job1",
+		Name: "check disk",
+		Payload: cron.CronPayload{
+			Kind:    "agent_turn",
+			Message: "check disk usage",
+			Channel: "telegram",
+			To:      "42",
+			Output:  output,
+		},
+	}
+}
+
+func receiveOutbound(mb *bus.MessageBus) (bus.OutboundMessage, bool) {
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	return mb.SubscribeOutbound(ctx)
+}
+
+func TestCronTool_ExecuteJob_DeliversAgentResponse(t *testing.T) {
+	tool, msgBus, _ := newTestCronTool(t, &stubJobExecutor{response: "disk is 40% full"})
+
+	output, err := tool.ExecuteJob(context.Background(), agentJob(""))
+	if err != nil {
+		t.Fatalf("ExecuteJob failed: %v", err)
+	}
+	if output != "disk is 40% full" {
+		t.Errorf("expected agent response as output, got %q", output)
+	}
+
+	msg, ok := receiveOutbound(msgBus)
+	if !ok {
+		t.Fatal("expected agent response to be sent to chat")
+	}
+	if msg.Channel != "telegram" || msg.ChatID != "42" || msg.Content != "disk is 40% full" {
+		t.Errorf("unexpected outbound message: %+v", msg)
+	}
+}
+
+func TestCronTool_ExecuteJob_SkipsResultAlreadySentByAgent(t *testing.T) {
+	tests := []struct {
+		name     string
+		sendTo   string
+		wantSent bool
+	}{
+		{"same chat", "42", false},
+		{"other chat", "43", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgTool := NewMessageTool()
+			msgTool.SetSendCallback(func(channel, chatID, content string) error { return nil })
+			exec := &stubJobExecutor{
+				response: "disk is 40% full",
+				during: func(ctx context.Context) {
+					msgTool.Execute(ctx, map[string]interface{}{
+						"content": "disk is 40% full", "channel": "telegram", "chat_id": tt.sendTo,
+					})
+				},
+			}
+			tool, msgBus, _ := newTestCronTool(t, exec)
+
+			if _, err := tool.ExecuteJob(context.Background(), agentJob("")); err != nil {
+				t.Fatalf("ExecuteJob failed: %v", err)
+			}
+			if _, sent := receiveOutbound(msgBus); sent != tt.wantSent {
+				t.Errorf("result published to the job's chat = %v, want %v", sent, tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestCronTool_ExecuteJob_EventJobRunsAsSender(t *testing.T) {
+	exec := &stubJobExecutor{response: "deployed"}
+	tool, _, _ := newTestCronTool(t, exec)
+
+	job := agentJob(cron.OutputSilent)
+	job.Schedule = cron.CronSchedule{Kind: "event", Trigger: &cron.CronTrigger{Type: cron.TriggerKeyword, Match: "deploy"}}
+	job.State.LastEventSender = "telegram:99"
+
+	if _, err := tool.ExecuteJob(context.Background(), job); err != nil {
+		t.Fatalf("ExecuteJob failed: %v", err)
+	}
+	if exec.sender != "telegram:99" {
+		t.Errorf("expected the event job to run as the triggering sender, got %q", exec.sender)
+	}
+}
+
+func TestCronTool_ExecuteJob_OutputModes(t *testing.T) {
+	tests := []struct {
+		name     string
+		output   string
+		lastHash string
+		execErr  error
+		wantSent bool
+	}{
+		{"silent", cron.OutputSilent, "", nil, false},
+		{"on_error without error", cron.OutputOnError, "", nil, false},
+		{"on_error with error", cron.OutputOnError, "", errors.New("provider down"), true},
+		{"on_change unchanged", cron.OutputOnChange, cron.OutputHash("same"), nil, false},
+		{"on_change changed", cron.OutputOnChange, cron.OutputHash("before"), nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tool, msgBus, _ := newTestCronTool(t, &stubJobExecutor{response: "same", err: tt.execErr})
+			job := agentJob(tt.output)
+			job.State.LastOutputHash = tt.lastHash
+
+			tool.ExecuteJob(context.Background(), job)
+
+			_, sent := receiveOutbound(msgBus)
+			if sent != tt.wantSent {
+				t.Errorf("sent = %v, want %v", sent, tt.wantSent)
+			}
+		})
+	}
+}
+
+func TestCronTool_ExecuteJob_WritesOutputFile(t *testing.T) {
+	tool, msgBus, workspace := newTestCronTool(t, &stubJobExecutor{response: "all good"})
+	job := agentJob(cron.OutputFile)
+	job.Payload.OutputFile = "reports/disk.md"
+
+	tool.ExecuteJob(context.Background(), job)
+	tool.ExecuteJob(context.Background(), job)
+
+	data, err := os.ReadFile(filepath.Join(workspace, "reports", "disk.md"))
+	if err != nil {
+		t.Fatalf("expected output file: %v", err)
+	}
+	if n := strings.Count(string(data), "all good"); n != 2 {
+		t.Errorf("expected 2 appended results, found %d", n)
+	}
+	if _, sent := receiveOutbound(msgBus); sent {
+		t.Error("file output should not be sent to chat")
+	}
+}
+
+func TestCronTool_AddJob_RejectsOutputFileOutsideWorkspace(t *testing.T) {
+	tool, _, _ := newTestCronTool(t, &stubJobExecutor{})
+	tool.SetContext("telegram", "42")
+
+	result := tool.Execute(context.Background(), map[string]interface{}{
+		"action":        "add",
+		"message":       "report",
+		"every_seconds": float64(60),
+		"output":        "file",
+		"output_file":   "../outside.md",
+	})
+	if !result.IsError {
+		t.Fatalf("expected error for output_file outside workspace, got %q", result.ForLLM)
+	}
+}
+
+func cronOnlyPolicy() *permissions.Policy {
+	return permissions.NewPolicy(config.PermissionsConfig{
+		Enabled: true,
+		Roles: map[string]config.RoleConfig{
+			"scheduler": {Tools: []string{"cron"}},
+		},
+	})
+}
+
+func TestCronTool_AddJob_CommandRequiresExec(t *testing.T) {
+	tool, _, _ := newTestCronTool(t, &stubJobExecutor{})
+	tool.SetContext("telegram", "42")
+	ctx := permissions.WithRole(context.Background(), cronOnlyPolicy().Role("scheduler"))
+
+	result := tool.Execute(ctx, map[string]interface{}{
+		"action":        "add",
+		"message":       "who am i",
+		"command":       "id",
+		"every_seconds": float64(60),
+	})
+	if !result.IsError {
+		t.Fatalf("expected a cron-only role to be refused a command job, got %q", result.ForLLM)
+	}
+	if jobs := tool.cronService.ListJobs(true); len(jobs) != 0 {
+		t.Errorf("expected no job to be added, got %d", len(jobs))
+	}
+}
+
+func TestCronTool_AddJob_RecordsCreatorRole(t *testing.T) {
+	tool, _, _ := newTestCronTool(t, &stubJobExecutor{})
+	tool.SetContext("telegram", "42")
+	ctx := permissions.WithRole(context.Background(), cronOnlyPolicy().Role("scheduler"))
+
+	result := tool.Execute(ctx, map[string]interface{}{
+		"action":        "add",
+		"message":       "check disk",
+		"deliver":       false,
+		"every_seconds": float64(60),
+	})
+	if result.IsError {
+		t.Fatalf("add failed: %s", result.ForLLM)
+	}
+	jobs := tool.cronService.ListJobs(true)
+	if len(jobs) != 1 || jobs[0].Payload.Role != "scheduler" {
+		t.Fatalf("expected the job to record the creator's role, got %+v", jobs)
+	}
+}
+
+func TestCronTool_ExecuteJob_RunsWithCreatorRole(t *testing.T) {
+	scheduler := cronOnlyPolicy().Role("scheduler")
+	exec := &stubJobExecutor{response: "ok", roles: map[string]*permissions.Role{"scheduler": scheduler}}
+	tool, _, _ := newTestCronTool(t, exec)
+
+	job := agentJob(cron.OutputSilent)
+	job.Payload.Role = "scheduler"
+	if _, err := tool.ExecuteJob(context.Background(), job); err != nil {
+		t.Fatalf("ExecuteJob failed: %v", err)
+	}
+	if exec.role != scheduler {
+		t.Errorf("expected the job to run with the creator's role, got %+v", exec.role)
+	}
+
+	// A command job saved before the check, or whose role lost exec since,
+	// is refused when it runs.
+	exec.ran = false
+	job.Payload.Command = "id"
+	if _, err := tool.ExecuteJob(context.Background(), job); err == nil || !strings.Contains(err.Error(), "refused") {
+		t.Fatalf("expected the command to be refused, got %v", err)
+	}
+	if exec.ran {
+		t.Error("a refused command job must not run")
+	}
+}
@@ -90,6 +90,11 @@ func (t *ReadFileTool) Name() string {
 	return "read_file"
 }
 
+// ConcurrencySafe implements ConcurrentTool.
+func (t *ReadFileTool) ConcurrencySafe() bool {
+	return true
+}
+
 func (t *ReadFileTool) Description() string {
 	return "Read the contents of a file"
 }
@@ -201,6 +206,11 @@ func (t *ListDirTool) Name() string {
 	return "list_dir"
 }
 
+// ConcurrencySafe implements ConcurrentTool.
+func (t *ListDirTool) ConcurrencySafe() bool {
+	return true
+}
+
 func (t *ListDirTool) Description() string {
 	return "List files and directories in a path"
 }
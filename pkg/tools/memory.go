@@ -0,0 +1,188 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/sipeed/picoclaw/pkg/memory"
+)
+
+// defaultRecallLimit is how many memories recall returns when not asked.
+const defaultRecallLimit = 5
+
+// RememberTool stores a fact in long-term memory.
+type RememberTool struct {
+	index *memory.Index
+}
+
+func NewRememberTool(index *memory.Index) *RememberTool {
+	return &RememberTool{index: index}
+}
+
+func (t *RememberTool) Name() string {
+	return "remember"
+}
+
+func (t *RememberTool) Description() string {
+	return "Save a fact to long-term memory so it can be recalled in later conversations, e.g. user preferences, decisions, names and dates. Store one self-contained fact per call."
+}
+
+func (t *RememberTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"fact": map[string]interface{}{
+				"type":        "string",
+				"description": "The fact to remember, written so it makes sense on its own",
+			},
+			"shared": map[string]interface{}{
+				"type":        "boolean",
+				"description": "In a group chat, save to the chat's shared memory instead of the sender's own",
+			},
+		},
+		"required": []string{"fact"},
+	}
+}
+
+func (t *RememberTool) Execute(ctx context.Context, args map[string]interface{}) *ToolResult {
+	fact, _ := args["fact"].(string)
+	if strings.TrimSpace(fact) == "" {
+		return ErrorResult("fact is required")
+	}
+
+	shared, _ := args["shared"].(bool)
+	file := memory.ScopeFromContext(ctx).MemoryFile(shared)
+	if file == "" {
+		return ErrorResult("no user or chat to remember this for")
+	}
+
+	chunk, err := t.index.RememberIn(ctx, file, fact)
+	if err != nil {
+		return ErrorResult(fmt.Sprintf("failed to remember: %v", err)).WithError(err)
+	}
+	return SilentResult(fmt.Sprintf("Remembered [%s]: %s", chunk.ID, chunk.Text))
+}
+
+// RecallTool searches long-term memory, daily notes and past session
+// summaries.
+type RecallTool struct {
+	index *memory.Index
+}
+
+func NewRecallTool(index *memory.Index) *RecallTool {
+	return &RecallTool{index: index}
+}
+
+func (t *RecallTool) Name() string {
+	return "recall"
+}
+
+func (t *RecallTool) Description() string {
+	return "Search long-term memory, daily notes and summaries of past conversations. Returns the most relevant snippets with their IDs."
+}
+
+func (t *RecallTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"query": map[string]interface{}{
+				"type":        "string",
+				"description": "What to look for",
+			},
+			"limit": map[string]interface{}{
+				"type":        "integer",
+				"description": "Maximum number of results (default 5)",
+				"minimum":     1.0,
+				"maximum":     20.0,
+			},
+		},
+		"required": []string{"query"},
+	}
+}
+
+// ConcurrencySafe implements ConcurrentTool.
+func (t *RecallTool) ConcurrencySafe() bool {
+	return true
+}
+
+func (t *RecallTool) Execute(ctx context.Context, args map[string]interface{}) *ToolResult {
+	query, _ := args["query"].(string)
+	if strings.TrimSpace(query) == "" {
+		return ErrorResult("query is required")
+	}
+	limit := defaultRecallLimit
+	if l, ok := args["limit"].(float64); ok && l >= 1 {
+		limit = min(int(l), 20)
+	}
+
+	results, err := t.index.SearchScoped(ctx, memory.ScopeFromContext(ctx), query, limit)
+	if err != nil {
+		return ErrorResult(fmt.Sprintf("recall failed: %v", err)).WithError(err)
+	}
+	if len(results) == 0 {
+		return SilentResult(fmt.Sprintf("No memories found for: %s", query))
+	}
+	return SilentResult(FormatMemories(results))
+}
+
+// ForgetTool deletes a memory found with recall.
+type ForgetTool struct {
+	index *memory.Index
+}
+
+func NewForgetTool(index *memory.Index) *ForgetTool {
+	return &ForgetTool{index: index}
+}
+
+func (t *ForgetTool) Name() string {
+	return "forget"
+}
+
+func (t *ForgetTool) Description() string {
+	return "Delete a memory by the ID shown by recall or remember. Use recall first to find the ID."
+}
+
+func (t *ForgetTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"id": map[string]interface{}{
+				"type":        "string",
+				"description": "ID of the memory to delete",
+			},
+		},
+		"required": []string{"id"},
+	}
+}
+
+func (t *ForgetTool) Execute(ctx context.Context, args map[string]interface{}) *ToolResult {
+	id, _ := args["id"].(string)
+	id = strings.Trim(strings.TrimSpace(id), "[]")
+	if id == "" {
+		return ErrorResult("id is required")
+	}
+
+	chunk, err := t.index.ForgetScoped(ctx, memory.ScopeFromContext(ctx), id)
+	if err != nil {
+		return ErrorResult(err.Error()).WithError(err)
+	}
+	return SilentResult(fmt.Sprintf("Forgot [%s] from %s: %s", chunk.ID, chunk.Source, chunk.Text))
+}
+
+// FormatMemories renders search results for the agent, one snippet per
+// memory with its ID and source.
+func FormatMemories(results []memory.Result) string {
+	var sb strings.Builder
+	for i, r := range results {
+		if i > 0 {
+			sb.WriteString("\n")
+		}
+		source := r.Source
+		if r.Heading != "" {
+			source += " > " + r.Heading
+		}
+		fmt.Fprintf(&sb, "[%s] (%s)\n%s\n", r.ID, source, r.Text)
+	}
+	return sb.String()
+}
@@ -0,0 +1,49 @@
+package tools
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/memory"
+)
+
+func TestMemoryTools(t *testing.T) {
+	index := memory.NewIndex(t.TempDir())
+	ctx := context.Background()
+	remember := NewRememberTool(index)
+	recall := NewRecallTool(index)
+	forget := NewForgetTool(index)
+
+	result := remember.Execute(ctx, map[string]interface{}{"fact": "The office wifi password is on the fridge"})
+	if result.IsError || !result.Silent {
+		t.Fatalf("remember failed: %+v", result)
+	}
+	id := regexp.MustCompile(`\[([0-9a-f]+)\]`).FindStringSubmatch(result.ForLLM)
+	if id == nil {
+		t.Fatalf("remember result has no ID: %q", result.ForLLM)
+	}
+
+	result = recall.Execute(ctx, map[string]interface{}{"query": "wifi password"})
+	if result.IsError || !strings.Contains(result.ForLLM, "["+id[1]+"] (memory/MEMORY.md") {
+		t.Fatalf("recall = %q", result.ForLLM)
+	}
+
+	result = forget.Execute(ctx, map[string]interface{}{"id": id[1]})
+	if result.IsError {
+		t.Fatalf("forget failed: %q", result.ForLLM)
+	}
+
+	result = recall.Execute(ctx, map[string]interface{}{"query": "wifi password"})
+	if !strings.HasPrefix(result.ForLLM, "No memories found") {
+		t.Errorf("recall after forget = %q", result.ForLLM)
+	}
+
+	if result := forget.Execute(ctx, map[string]interface{}{"id": "deadbeef"}); !result.IsError {
+		t.Error("forgetting an unknown ID should fail")
+	}
+	if result := remember.Execute(ctx, map[string]interface{}{"fact": "  "}); !result.IsError {
+		t.Error("remembering an empty fact should fail")
+	}
+}
@@ -3,6 +3,7 @@ package tools
 import (
 	"context"
 	"fmt"
+	"sync"
 )
 
 type SendCallback func(channel, chatID, content string) error
@@ -58,6 +59,32 @@ func (t *MessageTool) HasSentInRound() bool {
 	return t.sentInRound
 }
 
+type sentMessagesCtxKey struct{}
+
+// sentMessages records the chats the message tool sent to during one run.
+type sentMessages struct {
+	mu    sync.Mutex
+	chats map[string]bool
+}
+
+// WithSentMessages returns a copy of ctx in which the message tool records
+// the chats it sends to, for MessageSentTo.
+func WithSentMessages(ctx context.Context) context.Context {
+	return context.WithValue(ctx, sentMessagesCtxKey{}, &sentMessages{chats: make(map[string]bool)})
+}
+
+// MessageSentTo reports whether the message tool sent to channel:chatID
+// under ctx, which must come from WithSentMessages.
+func MessageSentTo(ctx context.Context, channel, chatID string) bool {
+	sent, ok := ctx.Value(sentMessagesCtxKey{}).(*sentMessages)
+	if !ok {
+		return false
+	}
+	sent.mu.Lock()
+	defer sent.mu.Unlock()
+	return sent.chats[channel+":"+chatID]
+}
+
 func (t *MessageTool) SetSendCallback(callback SendCallback) {
 	t.sendCallback = callback
 }
@@ -95,6 +122,11 @@ func (t *MessageTool) Execute(ctx context.Context, args map[string]interface{})
 	}
 
 	t.sentInRound = true
+	if sent, ok := ctx.Value(sentMessagesCtxKey{}).(*sentMessages); ok {
+		sent.mu.Lock()
+		sent.chats[channel+":"+chatID] = true
+		sent.mu.Unlock()
+	}
 	// Silent: user already received the message directly
 	return &ToolResult{
 		ForLLM: fmt.Sprintf("Message sent to %s:%s", channel, chatID),
@@ -0,0 +1,65 @@
+package tools
+
+import (
+	"sync"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// DefaultMaxParallelTools caps parallel tool calls when no cap is configured.
+const DefaultMaxParallelTools = 4
+
+// IsConcurrencySafe reports whether the named tool may run in parallel with
+// other tool calls.
+func (r *ToolRegistry) IsConcurrencySafe(name string) bool {
+	tool, ok := r.Get(name)
+	if !ok {
+		return false
+	}
+	ct, ok := tool.(ConcurrentTool)
+	return ok && ct.ConcurrencySafe()
+}
+
+// ExecuteToolCalls runs the tool calls of one LLM response with exec and
+// returns their results in the order of calls. Consecutive calls to
+// concurrency-safe tools run in parallel, at most maxParallel at a time.
+// Any other call runs alone, after the calls before it have finished, so
+// side effects keep the order the model asked for. A maxParallel of 0 uses
+// DefaultMaxParallelTools; 1 runs every call sequentially.
+func ExecuteToolCalls(registry *ToolRegistry, calls []providers.ToolCall, maxParallel int, exec func(providers.ToolCall) *ToolResult) []*ToolResult {
+	if maxParallel == 0 {
+		maxParallel = DefaultMaxParallelTools
+	}
+	safe := func(tc providers.ToolCall) bool {
+		return maxParallel > 1 && registry != nil && registry.IsConcurrencySafe(tc.Name)
+	}
+
+	results := make([]*ToolResult, len(calls))
+	for i := 0; i < len(calls); {
+		if !safe(calls[i]) {
+			results[i] = exec(calls[i])
+			i++
+			continue
+		}
+
+		end := i
+		for end < len(calls) && safe(calls[end]) {
+			end++
+		}
+
+		sem := make(chan struct{}, maxParallel)
+		var wg sync.WaitGroup
+		for k := i; k < end; k++ {
+			wg.Add(1)
+			sem <- struct{}{}
+			go func(k int) {
+				defer wg.Done()
+				defer func() { <-sem }()
+				results[k] = exec(calls[k])
+			}(k)
+		}
+		wg.Wait()
+		i = end
+	}
+	return results
+}
@@ -0,0 +1,122 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// sleepTool records how many calls run at once.
+type sleepTool struct {
+	name    string
+	safe    bool
+	running *int32
+	peak    *int32
+}
+
+func (t *sleepTool) Name() string        { return t.name }
+func (t *sleepTool) Description() string { return "sleeps briefly" }
+func (t *sleepTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{"type": "object"}
+}
+func (t *sleepTool) ConcurrencySafe() bool { return t.safe }
+
+func (t *sleepTool) Execute(ctx context.Context, args map[string]interface{}) *ToolResult {
+	n := atomic.AddInt32(t.running, 1)
+	for {
+		peak := atomic.LoadInt32(t.peak)
+		if n <= peak || atomic.CompareAndSwapInt32(t.peak, peak, n) {
+			break
+		}
+	}
+	time.Sleep(20 * time.Millisecond)
+	atomic.AddInt32(t.running, -1)
+	return NewToolResult(fmt.Sprintf("%s %v", t.name, args["n"]))
+}
+
+func newSleepRegistry() (*ToolRegistry, *int32) {
+	var running, peak int32
+	registry := NewToolRegistry()
+	registry.Register(&sleepTool{name: "read", safe: true, running: &running, peak: &peak})
+	registry.Register(&sleepTool{name: "write", safe: false, running: &running, peak: &peak})
+	return registry, &peak
+}
+
+func toolCalls(names ...string) []providers.ToolCall {
+	calls := make([]providers.ToolCall, len(names))
+	for i, name := range names {
+		calls[i] = providers.ToolCall{
+			ID:        fmt.Sprintf("call-%d", i),
+			Name:      name,
+			Arguments: map[string]interface{}{"n": i},
+		}
+	}
+	return calls
+}
+
+func TestExecuteToolCalls(t *testing.T) {
+	tests := []struct {
+		name        string
+		calls       []string
+		maxParallel int
+		wantPeak    int32
+	}{
+		{"safe calls run in parallel", []string{"read", "read", "read"}, 0, 3},
+		{"cap limits parallelism", []string{"read", "read", "read", "read", "read", "read"}, 2, 2},
+		{"cap of one is sequential", []string{"read", "read", "read"}, 1, 1},
+		{"unsafe calls run alone", []string{"write", "write", "write"}, 4, 1},
+		{"unsafe call splits batches", []string{"read", "write", "read"}, 4, 1},
+		{"unknown tools run alone", []string{"missing", "missing"}, 4, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			registry, peak := newSleepRegistry()
+			calls := toolCalls(tt.calls...)
+
+			results := ExecuteToolCalls(registry, calls, tt.maxParallel, func(tc providers.ToolCall) *ToolResult {
+				return registry.Execute(context.Background(), tc.Name, tc.Arguments)
+			})
+
+			if len(results) != len(calls) {
+				t.Fatalf("got %d results, want %d", len(results), len(calls))
+			}
+			for i, tc := range calls {
+				if _, ok := registry.Get(tc.Name); !ok {
+					continue
+				}
+				want := fmt.Sprintf("%s %d", tc.Name, i)
+				if results[i].ForLLM != want {
+					t.Errorf("result %d = %q, want %q", i, results[i].ForLLM, want)
+				}
+			}
+			if got := atomic.LoadInt32(peak); got != tt.wantPeak {
+				t.Errorf("peak concurrency = %d, want %d", got, tt.wantPeak)
+			}
+		})
+	}
+}
+
+func TestExecuteToolCalls_UnsafeWaitsForEarlierCalls(t *testing.T) {
+	registry, _ := newSleepRegistry()
+	calls := toolCalls("read", "read", "write")
+
+	var mu sync.Mutex
+	var order []string
+	ExecuteToolCalls(registry, calls, 4, func(tc providers.ToolCall) *ToolResult {
+		result := registry.Execute(context.Background(), tc.Name, tc.Arguments)
+		mu.Lock()
+		order = append(order, tc.Name)
+		mu.Unlock()
+		return result
+	})
+
+	if len(order) != 3 || order[2] != "write" {
+		t.Errorf("write should finish last, got order %v", order)
+	}
+}
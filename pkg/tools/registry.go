@@ -7,6 +7,7 @@ import (
 	"time"
 
 	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/permissions"
 	"github.com/sipeed/picoclaw/pkg/providers"
 )
 
@@ -41,6 +42,7 @@ func (r *ToolRegistry) Execute(ctx context.Context, name string, args map[string
 // ExecuteWithContext executes a tool with channel/chatID context and optional async callback.
 // If the tool implements AsyncTool and a non-nil callback is provided,
 // the callback will be set on the tool before execution.
+// If ctx carries a permissions.Role, tools outside the role's grants are refused.
 func (r *ToolRegistry) ExecuteWithContext(ctx context.Context, name string, args map[string]interface{}, channel, chatID string, asyncCallback AsyncCallback) *ToolResult {
 	logger.InfoCF("tool", "Tool execution started",
 		map[string]interface{}{
@@ -57,6 +59,16 @@ func (r *ToolRegistry) ExecuteWithContext(ctx context.Context, name string, args
 		return ErrorResult(fmt.Sprintf("tool %q not found", name)).WithError(fmt.Errorf("tool not found"))
 	}
 
+	if role := permissions.RoleFromContext(ctx); !roleAllows(role, tool) {
+		logger.WarnCF("tool", "Tool denied by role",
+			map[string]interface{}{
+				"tool": name,
+				"role": role.Name,
+			})
+		return ErrorResult(fmt.Sprintf("permission denied: role %q may not use tool %q", role.Name, name)).
+			WithError(fmt.Errorf("permission denied"))
+	}
+
 	// If tool implements ContextualTool, set context
 	if contextualTool, ok := tool.(ContextualTool); ok && channel != "" && chatID != "" {
 		contextualTool.SetContext(channel, chatID)
@@ -115,11 +127,22 @@ func (r *ToolRegistry) GetDefinitions() []map[string]interface{} {
 // ToProviderDefs converts tool definitions to provider-compatible format.
 // This is the format expected by LLM provider APIs.
 func (r *ToolRegistry) ToProviderDefs() []providers.ToolDefinition {
+	return r.ToProviderDefsWithContext(context.Background())
+}
+
+// ToProviderDefsWithContext is like ToProviderDefs but omits tools that the
+// permissions.Role carried by ctx is not allowed to use.
+func (r *ToolRegistry) ToProviderDefsWithContext(ctx context.Context) []providers.ToolDefinition {
+	role := permissions.RoleFromContext(ctx)
+
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	definitions := make([]providers.ToolDefinition, 0, len(r.tools))
 	for _, tool := range r.tools {
+		if !roleAllows(role, tool) {
+			continue
+		}
 		schema := ToolToSchema(tool)
 
 		// Safely extract nested values with type checks
@@ -144,6 +167,18 @@ func (r *ToolRegistry) ToProviderDefs() []providers.ToolDefinition {
 	return definitions
 }
 
+// roleAllows reports whether role may use tool, and the skill that
+// declared it if any.
+func roleAllows(role *permissions.Role, tool Tool) bool {
+	if !role.AllowsTool(tool.Name()) {
+		return false
+	}
+	if st, ok := tool.(SkillProvidedTool); ok {
+		return role.AllowsSkill(st.SkillName())
+	}
+	return true
+}
+
 // List returns a list of all registered tool names.
 func (r *ToolRegistry) List() []string {
 	r.mu.RLock()
@@ -156,6 +191,20 @@ func (r *ToolRegistry) List() []string {
 	return names
 }
 
+// Filter returns a new registry holding the tools allow accepts.
+func (r *ToolRegistry) Filter(allow func(name string) bool) *ToolRegistry {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	filtered := NewToolRegistry()
+	for name, tool := range r.tools {
+		if allow(name) {
+			filtered.tools[name] = tool
+		}
+	}
+	return filtered
+}
+
 // Count returns the number of registered tools.
 func (r *ToolRegistry) Count() int {
 	r.mu.RLock()
@@ -166,11 +215,20 @@ func (r *ToolRegistry) Count() int {
 // GetSummaries returns human-readable summaries of all registered tools.
 // Returns a slice of "name - description" strings.
 func (r *ToolRegistry) GetSummaries() []string {
+	return r.GetSummariesForRole(nil)
+}
+
+// GetSummariesForRole returns summaries of the tools the role may use.
+// A nil role returns every tool.
+func (r *ToolRegistry) GetSummariesForRole(role *permissions.Role) []string {
 	r.mu.RLock()
 	defer r.mu.RUnlock()
 
 	summaries := make([]string, 0, len(r.tools))
-	for _, tool := range r.tools {
+	for name, tool := range r.tools {
+		if !role.AllowsTool(name) {
+			continue
+		}
 		summaries = append(summaries, fmt.Sprintf("- `%s` - %s", tool.Name(), tool.Description()))
 	}
 	return summaries
@@ -0,0 +1,142 @@
+package tools
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/skills"
+)
+
+// maxSkillOutput caps the result a skill tool returns to the model.
+const maxSkillOutput = 10000
+
+// skillWaitDelay is how long a skill tool waits for leftover child
+// processes after its entrypoint exits or times out.
+const skillWaitDelay = 2 * time.Second
+
+// SkillTool runs a tool declared in a skill's manifest. The entrypoint goes
+// through the same safety guard and timeout as exec, runs from the skill
+// directory, gets the arguments as JSON on stdin and answers on stdout with
+// either any JSON value or an object of the form
+//
+//	{"result": "text for the model", "for_user": "optional", "error": "optional"}
+type SkillTool struct {
+	spec      skills.ToolSpec
+	exec      *ExecTool
+	workspace string
+}
+
+func NewSkillTool(spec skills.ToolSpec, workspace string, restrict bool) *SkillTool {
+	exec := NewExecTool(spec.Dir, restrict)
+	exec.SetTimeout(time.Duration(spec.Timeout) * time.Second)
+	// Scripts often run interpreters as children; don't let them outlive
+	// the timeout by keeping stdout open
+	exec.waitDelay = skillWaitDelay
+	return &SkillTool{spec: spec, exec: exec, workspace: workspace}
+}
+
+func (t *SkillTool) Name() string {
+	return t.spec.Name
+}
+
+func (t *SkillTool) Description() string {
+	return t.spec.Description
+}
+
+func (t *SkillTool) Parameters() map[string]interface{} {
+	return t.spec.Parameters
+}
+
+// SkillName implements SkillProvidedTool.
+func (t *SkillTool) SkillName() string {
+	return t.spec.Skill
+}
+
+// skillOutput is the object form of a skill tool's stdout.
+type skillOutput struct {
+	Result  json.RawMessage `json:"result"`
+	ForUser string          `json:"for_user"`
+	Error   string          `json:"error"`
+}
+
+func (t *SkillTool) Execute(ctx context.Context, args map[string]interface{}) *ToolResult {
+	if args == nil {
+		args = map[string]interface{}{}
+	}
+	input, err := json.Marshal(args)
+	if err != nil {
+		return ErrorResult(fmt.Sprintf("invalid arguments: %v", err)).WithError(err)
+	}
+
+	// skills.lock was checked when the skill loaded; check again so that a
+	// script changed since then does not run.
+	if err := skills.VerifyInstalled(t.workspace, t.spec.Dir); err != nil {
+		return ErrorResult(fmt.Sprintf("refusing to run %s: skill %s failed verification: %v", t.spec.Name, t.spec.Skill, err)).WithError(err)
+	}
+
+	if guardError := t.exec.guardCommand(t.spec.Entrypoint, t.spec.Dir); guardError != "" {
+		return ErrorResult(guardError)
+	}
+
+	env := []string{
+		"PICOCLAW_WORKSPACE=" + t.workspace,
+		"PICOCLAW_SKILL_DIR=" + t.spec.Dir,
+		"PICOCLAW_SESSION=" + SessionKeyFromContext(ctx),
+	}
+	stdout, stderr, err := t.exec.runCommand(ctx, t.spec.Entrypoint, t.spec.Dir, bytes.NewReader(input), env)
+	if errors.Is(err, errCommandTimeout) {
+		return ErrorResult(fmt.Sprintf("%s timed out after %v", t.spec.Name, t.exec.timeout)).WithError(err)
+	}
+	if err != nil {
+		msg := fmt.Sprintf("%s failed: %v", t.spec.Name, err)
+		if detail := strings.TrimSpace(stderr + "\n" + stdout); detail != "" {
+			msg += "\n" + truncateSkillOutput(detail)
+		}
+		return ErrorResult(msg).WithError(err)
+	}
+
+	return parseSkillOutput(t.spec.Name, stdout, stderr)
+}
+
+// parseSkillOutput turns the stdout of a skill tool into a ToolResult.
+func parseSkillOutput(name, stdout, stderr string) *ToolResult {
+	trimmed := strings.TrimSpace(stdout)
+	if !json.Valid([]byte(trimmed)) {
+		msg := fmt.Sprintf("%s returned invalid JSON", name)
+		if detail := strings.TrimSpace(trimmed + "\n" + stderr); detail != "" {
+			msg += ":\n" + truncateSkillOutput(detail)
+		}
+		return ErrorResult(msg)
+	}
+
+	var out skillOutput
+	if strings.HasPrefix(trimmed, "{") && json.Unmarshal([]byte(trimmed), &out) == nil &&
+		(out.Result != nil || out.Error != "" || out.ForUser != "") {
+		if out.Error != "" {
+			return ErrorResult(truncateSkillOutput(out.Error))
+		}
+		forLLM := string(out.Result)
+		var text string
+		if json.Unmarshal(out.Result, &text) == nil {
+			forLLM = text
+		}
+		result := NewToolResult(truncateSkillOutput(forLLM))
+		if out.ForUser != "" {
+			result.ForUser = out.ForUser
+		}
+		return result
+	}
+	return NewToolResult(truncateSkillOutput(trimmed))
+}
+
+func truncateSkillOutput(s string) string {
+	if len(s) > maxSkillOutput {
+		return s[:maxSkillOutput] + fmt.Sprintf("\n... (truncated, %d more chars)", len(s)-maxSkillOutput)
+	}
+	return s
+}
@@ -0,0 +1,136 @@
+package tools
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/permissions"
+	"github.com/sipeed/picoclaw/pkg/skills"
+)
+
+func newTestSkillTool(t *testing.T, script string, timeout int) *SkillTool {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("skill scripts are shell scripts")
+	}
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "run.sh"), []byte("#!/bin/sh\n"+script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	return NewSkillTool(skills.ToolSpec{
+		Name:        "echo_args",
+		Description: "test tool",
+		Parameters:  map[string]interface{}{"type": "object"},
+		Entrypoint:  "sh run.sh",
+		Timeout:     timeout,
+		Skill:       "testing",
+		Dir:         dir,
+	}, "/workspace", true)
+}
+
+func TestSkillTool_Execute(t *testing.T) {
+	tests := []struct {
+		name    string
+		script  string
+		want    string
+		isError bool
+	}{
+		{"args on stdin", `cat`, `{"city":"Oslo"}`, false},
+		{"result object", `echo '{"result": "Sunny in Oslo"}'`, "Sunny in Oslo", false},
+		{"structured result", `echo '{"result": {"temp": 21}}'`, `{"temp": 21}`, false},
+		{"error object", `echo '{"error": "city not found"}'`, "city not found", true},
+		{"invalid json", `echo 'not json'; echo oops >&2`, "returned invalid JSON", true},
+		{"exit code", `echo 'bad input' >&2; exit 3`, "bad input", true},
+		{"environment", `printf '"%s"' "$PICOCLAW_WORKSPACE"`, "/workspace", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tool := newTestSkillTool(t, tt.script, 5)
+			result := tool.Execute(context.Background(), map[string]interface{}{"city": "Oslo"})
+			if result.IsError != tt.isError {
+				t.Errorf("IsError = %v: %s", result.IsError, result.ForLLM)
+			}
+			if !strings.Contains(result.ForLLM, tt.want) {
+				t.Errorf("ForLLM = %q, want it to contain %q", result.ForLLM, tt.want)
+			}
+		})
+	}
+}
+
+func TestSkillTool_Timeout(t *testing.T) {
+	tool := newTestSkillTool(t, "exec sleep 5", 1)
+	result := tool.Execute(context.Background(), nil)
+	if !result.IsError || !strings.Contains(result.ForLLM, "timed out") {
+		t.Errorf("expected timeout, got %q", result.ForLLM)
+	}
+}
+
+func TestSkillTool_RoleNeedsSkill(t *testing.T) {
+	tool := newTestSkillTool(t, `echo '"ok"'`, 5)
+	registry := NewToolRegistry()
+	registry.Register(tool)
+
+	policy := permissions.NewPolicy(config.PermissionsConfig{
+		Enabled: true,
+		Roles: map[string]config.RoleConfig{
+			"guest": {Members: []string{"*"}, Tools: []string{"*"}, Skills: []string{"weather"}},
+		},
+	})
+	ctx := permissions.WithRole(context.Background(), policy.RoleFor("telegram", "1"))
+
+	if defs := registry.ToProviderDefsWithContext(ctx); len(defs) != 0 {
+		t.Errorf("role without the skill sees %d tool definitions", len(defs))
+	}
+	if result := registry.ExecuteWithContext(ctx, "echo_args", nil, "", "", nil); !result.IsError {
+		t.Error("role without the skill executed its tool")
+	}
+	if result := registry.Execute(context.Background(), "echo_args", nil); result.IsError {
+		t.Errorf("unrestricted execution failed: %s", result.ForLLM)
+	}
+}
+
+func TestSkillTool_RefusesSkillChangedSinceInstall(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("skill scripts are shell scripts")
+	}
+	workspace := t.TempDir()
+	dir := filepath.Join(workspace, "skills", "testing")
+	script := filepath.Join(dir, "run.sh")
+	os.MkdirAll(dir, 0755)
+	if err := os.WriteFile(script, []byte("#!/bin/sh\necho '\"ok\"'\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	sum, err := skills.HashDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lock := &skills.Lock{Version: 1, Skills: map[string]*skills.LockEntry{
+		"testing": {Source: "./testing", Type: "local", SHA256: sum},
+	}}
+	if err := lock.Save(workspace); err != nil {
+		t.Fatal(err)
+	}
+
+	tool := NewSkillTool(skills.ToolSpec{
+		Name:       "echo_ok",
+		Entrypoint: "sh run.sh",
+		Timeout:    5,
+		Skill:      "testing",
+		Dir:        dir,
+	}, workspace, true)
+
+	if result := tool.Execute(context.Background(), nil); result.IsError {
+		t.Fatalf("locked skill failed to run: %s", result.ForLLM)
+	}
+
+	os.WriteFile(script, []byte("#!/bin/sh\necho '\"tampered\"'\n"), 0755)
+	result := tool.Execute(context.Background(), nil)
+	if !result.IsError || !strings.Contains(result.ForLLM, "failed verification") {
+		t.Errorf("expected the changed skill to be refused, got %q", result.ForLLM)
+	}
+}
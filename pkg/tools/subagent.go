@@ -2,27 +2,69 @@ package tools
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/logger"
+	"github.com/sipeed/picoclaw/pkg/permissions"
 	"github.com/sipeed/picoclaw/pkg/providers"
 )
 
 type SubagentTask struct {
-	ID            string
-	Task          string
-	Label         string
-	OriginChannel string
-	OriginChatID  string
-	Status        string
-	Result        string
-	Created       int64
+	ID            string `json:"id"`
+	Task          string `json:"task"`
+	Label         string `json:"label,omitempty"`
+	Agent         string `json:"agent,omitempty"` // agent profile the task runs as; empty for the default subagent
+	OriginChannel string `json:"origin_channel"`
+	OriginChatID  string `json:"origin_chat_id"`
+	SessionKey    string `json:"session_key,omitempty"` // session the task was spawned from; results are fed back into it
+	Status        string `json:"status"`
+	Result        string `json:"result,omitempty"`
+	Created       int64  `json:"created"`
+	Finished      int64  `json:"finished,omitempty"`
+	// Transcript is the subagent's conversation, including tool calls and results.
+	Transcript []providers.Message `json:"transcript,omitempty"`
+	// Role is the role of the sender the task was spawned for. The task text
+	// comes from that sender, so the result is handled with the same role.
+	Role *permissions.Role `json:"-"`
 }
 
+// Subagent task statuses. Running tasks that were lost to a restart are
+// marked interrupted when the manager loads them again.
+const (
+	SubagentRunning     = "running"
+	SubagentCompleted   = "completed"
+	SubagentFailed      = "failed"
+	SubagentCancelled   = "cancelled"
+	SubagentInterrupted = "interrupted"
+)
+
+// SubagentProfile configures a named agent that spawn and subagent can run.
+// Zero fields fall back to the manager's defaults.
+type SubagentProfile struct {
+	Description   string
+	SystemPrompt  string // prepended to the subagent instructions
+	Model         string
+	LLMOptions    map[string]any
+	MaxIterations int
+	Tools         *ToolRegistry
+}
+
+// SubagentResultKind is the "kind" metadata value of the system message that
+// reports a finished subagent task to the main agent.
+const SubagentResultKind = "subagent_result"
+
 type SubagentManager struct {
 	tasks         map[string]*SubagentTask
+	cancels       map[string]context.CancelFunc
 	mu            sync.RWMutex
 	provider      providers.LLMProvider
 	defaultModel  string
@@ -30,12 +72,19 @@ type SubagentManager struct {
 	workspace     string
 	tools         *ToolRegistry
 	maxIterations int
+	profiles      map[string]SubagentProfile
+	maxConcurrent int    // 0 means unlimited
+	maxParallel   int    // cap on parallel tool calls; see ExecuteToolCalls
+	storageDir    string // where task records are persisted; empty disables persistence
+	owner         string // name of the agent hosting the manager; see SetOwner
 	nextID        int
 }
 
 func NewSubagentManager(provider providers.LLMProvider, defaultModel, workspace string, bus *bus.MessageBus) *SubagentManager {
 	return &SubagentManager{
 		tasks:         make(map[string]*SubagentTask),
+		cancels:       make(map[string]context.CancelFunc),
+		profiles:      make(map[string]SubagentProfile),
 		provider:      provider,
 		defaultModel:  defaultModel,
 		bus:           bus,
@@ -61,9 +110,184 @@ func (sm *SubagentManager) RegisterTool(tool Tool) {
 	sm.tools.Register(tool)
 }
 
-func (sm *SubagentManager) Spawn(ctx context.Context, task, label, originChannel, originChatID string, callback AsyncCallback) (string, error) {
+// SetOwner records the name of the agent that hosts the manager. Results are
+// announced with it so a gateway running several agents delivers them back
+// to that agent, whichever route chose it.
+func (sm *SubagentManager) SetOwner(name string) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.owner = name
+}
+
+// SetProfile registers a named agent profile that tasks can run as.
+func (sm *SubagentManager) SetProfile(name string, profile SubagentProfile) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.profiles[name] = profile
+}
+
+// Profiles returns the registered profile names with their descriptions.
+func (sm *SubagentManager) Profiles() map[string]string {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+	profiles := make(map[string]string, len(sm.profiles))
+	for name, profile := range sm.profiles {
+		profiles[name] = profile.Description
+	}
+	return profiles
+}
+
+// loopConfig returns the tool loop configuration and system prompt for a
+// task run as agent, or as the default subagent when agent is empty.
+func (sm *SubagentManager) loopConfig(agent, instructions string) (ToolLoopConfig, string, error) {
+	sm.mu.RLock()
+	defer sm.mu.RUnlock()
+
+	config := ToolLoopConfig{
+		Provider:      sm.provider,
+		Model:         sm.defaultModel,
+		Tools:         sm.tools,
+		MaxIterations: sm.maxIterations,
+		LLMOptions: map[string]any{
+			"max_tokens":  4096,
+			"temperature": 0.7,
+		},
+		MaxParallelTools: sm.maxParallel,
+	}
+	if agent == "" {
+		return config, instructions, nil
+	}
+
+	profile, ok := sm.profiles[agent]
+	if !ok {
+		return config, "", fmt.Errorf("unknown agent %q", agent)
+	}
+	if profile.Model != "" {
+		config.Model = profile.Model
+	}
+	for key, value := range profile.LLMOptions {
+		config.LLMOptions[key] = value
+	}
+	if profile.MaxIterations > 0 {
+		config.MaxIterations = profile.MaxIterations
+	}
+	if profile.Tools != nil {
+		config.Tools = profile.Tools
+	}
+	if profile.SystemPrompt != "" {
+		instructions = profile.SystemPrompt + "\n\n" + instructions
+	}
+	return config, instructions, nil
+}
+
+// SetMaxConcurrent caps the number of spawned tasks running at once.
+// Zero or less removes the cap.
+func (sm *SubagentManager) SetMaxConcurrent(n int) {
 	sm.mu.Lock()
 	defer sm.mu.Unlock()
+	sm.maxConcurrent = n
+}
+
+// SetMaxParallelTools caps how many concurrency-safe tool calls a subagent
+// runs at once.
+func (sm *SubagentManager) SetMaxParallelTools(n int) {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+	sm.maxParallel = n
+}
+
+// SetStorage enables persistence of task records and transcripts in dir and
+// loads the tasks stored there. Tasks that were still running when the
+// process stopped are marked interrupted.
+func (sm *SubagentManager) SetStorage(dir string) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("failed to create subagent storage: %w", err)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to read subagent storage: %w", err)
+	}
+
+	sm.mu.Lock()
+	sm.storageDir = dir
+	var interrupted []*SubagentTask
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != ".json" {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, entry.Name()))
+		if err != nil {
+			continue
+		}
+		var task SubagentTask
+		if err := json.Unmarshal(data, &task); err != nil || task.ID == "" {
+			continue
+		}
+		if task.Status == SubagentRunning {
+			task.Status = SubagentInterrupted
+			task.Result = "Task interrupted by a restart"
+			interrupted = append(interrupted, &task)
+		}
+		sm.tasks[task.ID] = &task
+		if n, err := strconv.Atoi(strings.TrimPrefix(task.ID, "subagent-")); err == nil && n >= sm.nextID {
+			sm.nextID = n + 1
+		}
+	}
+	sm.mu.Unlock()
+
+	for _, task := range interrupted {
+		sm.saveTask(task)
+	}
+	return nil
+}
+
+// saveTask writes the task record to storage, if persistence is enabled.
+func (sm *SubagentManager) saveTask(task *SubagentTask) {
+	sm.mu.RLock()
+	dir := sm.storageDir
+	data, err := json.MarshalIndent(task, "", "  ")
+	sm.mu.RUnlock()
+	if dir == "" {
+		return
+	}
+	if err != nil {
+		logger.WarnCF("subagent", "Failed to encode task", map[string]interface{}{"task_id": task.ID, "error": err.Error()})
+		return
+	}
+
+	path := filepath.Join(dir, task.ID+".json")
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err == nil {
+		err = os.Rename(tmp, path)
+	}
+	if err != nil {
+		logger.WarnCF("subagent", "Failed to persist task", map[string]interface{}{"task_id": task.ID, "error": err.Error()})
+	}
+}
+
+func (sm *SubagentManager) runningCount() int {
+	n := 0
+	for _, task := range sm.tasks {
+		if task.Status == SubagentRunning {
+			n++
+		}
+	}
+	return n
+}
+
+func (sm *SubagentManager) Spawn(ctx context.Context, task, label, agent, originChannel, originChatID string, callback AsyncCallback) (string, error) {
+	sm.mu.Lock()
+
+	if _, ok := sm.profiles[agent]; agent != "" && !ok {
+		sm.mu.Unlock()
+		return "", fmt.Errorf("unknown agent %q", agent)
+	}
+
+	if sm.maxConcurrent > 0 && sm.runningCount() >= sm.maxConcurrent {
+		sm.mu.Unlock()
+		return "", fmt.Errorf("too many subagents running (limit %d); wait for one to finish or cancel one", sm.maxConcurrent)
+	}
 
 	taskID := fmt.Sprintf("subagent-%d", sm.nextID)
 	sm.nextID++
@@ -72,30 +296,56 @@ func (sm *SubagentManager) Spawn(ctx context.Context, task, label, originChannel
 		ID:            taskID,
 		Task:          task,
 		Label:         label,
+		Agent:         agent,
 		OriginChannel: originChannel,
 		OriginChatID:  originChatID,
-		Status:        "running",
+		SessionKey:    SessionKeyFromContext(ctx),
+		Role:          permissions.RoleFromContext(ctx),
+		Status:        SubagentRunning,
 		Created:       time.Now().UnixMilli(),
 	}
 	sm.tasks[taskID] = subagentTask
 
+	// The task outlives the turn or job that spawned it, so it keeps the
+	// caller's values but is only ever cancelled through Cancel.
+	taskCtx, cancel := context.WithCancel(context.WithoutCancel(ctx))
+	sm.cancels[taskID] = cancel
+	sm.mu.Unlock()
+
+	sm.saveTask(subagentTask)
+
 	// Start task in background with context cancellation support
-	go sm.runTask(ctx, subagentTask, callback)
+	go sm.runTask(taskCtx, subagentTask, callback)
 
 	if label != "" {
-		return fmt.Sprintf("Spawned subagent '%s' for task: %s", label, task), nil
+		return fmt.Sprintf("Spawned subagent '%s' (%s) for task: %s", label, taskID, task), nil
 	}
-	return fmt.Sprintf("Spawned subagent for task: %s", task), nil
+	return fmt.Sprintf("Spawned subagent %s for task: %s", taskID, task), nil
 }
 
-func (sm *SubagentManager) runTask(ctx context.Context, task *SubagentTask, callback AsyncCallback) {
-	task.Status = "running"
-	task.Created = time.Now().UnixMilli()
+// Cancel stops a running task. Its outcome is reported to the originating
+// session like any other.
+func (sm *SubagentManager) Cancel(taskID string) error {
+	sm.mu.Lock()
+	defer sm.mu.Unlock()
+
+	task, ok := sm.tasks[taskID]
+	if !ok {
+		return fmt.Errorf("task %s not found", taskID)
+	}
+	cancel, running := sm.cancels[taskID]
+	if !running || task.Status != SubagentRunning {
+		return fmt.Errorf("task %s is not running (status: %s)", taskID, task.Status)
+	}
+	cancel()
+	return nil
+}
 
+func (sm *SubagentManager) runTask(ctx context.Context, task *SubagentTask, callback AsyncCallback) {
 	// Build system prompt for subagent
-	systemPrompt := `You are a subagent. Complete the given task independently and report the result.
+	loopConfig, systemPrompt, _ := sm.loopConfig(task.Agent, `You are a subagent. Complete the given task independently and report the result.
 You have access to tools - use them as needed to complete your task.
-After completing the task, provide a clear summary of what was done.`
+After completing the task, provide a clear summary of what was done.`)
 
 	messages := []providers.Message{
 		{
@@ -108,50 +358,41 @@ After completing the task, provide a clear summary of what was done.`
 		},
 	}
 
+	sm.mu.Lock()
+	task.Transcript = append(task.Transcript, messages[1])
+	sm.mu.Unlock()
+
 	// Check if context is already cancelled before starting
 	select {
 	case <-ctx.Done():
 		sm.mu.Lock()
-		task.Status = "cancelled"
+		task.Status = SubagentCancelled
 		task.Result = "Task cancelled before execution"
+		sm.finishLocked(task)
 		sm.mu.Unlock()
+		sm.saveTask(task)
+		sm.announce(task)
 		return
 	default:
 	}
 
 	// Run tool loop with access to tools
-	sm.mu.RLock()
-	tools := sm.tools
-	maxIter := sm.maxIterations
-	sm.mu.RUnlock()
-
-	loopResult, err := RunToolLoop(ctx, ToolLoopConfig{
-		Provider:      sm.provider,
-		Model:         sm.defaultModel,
-		Tools:         tools,
-		MaxIterations: maxIter,
-		LLMOptions: map[string]any{
-			"max_tokens":  4096,
-			"temperature": 0.7,
-		},
-	}, messages, task.OriginChannel, task.OriginChatID)
+	loopConfig.OnMessage = func(msg providers.Message) {
+		sm.mu.Lock()
+		task.Transcript = append(task.Transcript, msg)
+		sm.mu.Unlock()
+		sm.saveTask(task)
+	}
+	loopResult, err := RunToolLoop(ctx, loopConfig, messages, task.OriginChannel, task.OriginChatID)
 
 	sm.mu.Lock()
 	var result *ToolResult
-	defer func() {
-		sm.mu.Unlock()
-		// Call callback if provided and result is set
-		if callback != nil && result != nil {
-			callback(ctx, result)
-		}
-	}()
-
 	if err != nil {
-		task.Status = "failed"
+		task.Status = SubagentFailed
 		task.Result = fmt.Sprintf("Error: %v", err)
 		// Check if it was cancelled
 		if ctx.Err() != nil {
-			task.Status = "cancelled"
+			task.Status = SubagentCancelled
 			task.Result = "Task cancelled during execution"
 		}
 		result = &ToolResult{
@@ -163,7 +404,7 @@ After completing the task, provide a clear summary of what was done.`
 			Err:     err,
 		}
 	} else {
-		task.Status = "completed"
+		task.Status = SubagentCompleted
 		task.Result = loopResult.Content
 		result = &ToolResult{
 			ForLLM:  fmt.Sprintf("Subagent '%s' completed (iterations: %d): %s", task.Label, loopResult.Iterations, loopResult.Content),
@@ -173,38 +414,127 @@ After completing the task, provide a clear summary of what was done.`
 			Async:   false,
 		}
 	}
+	sm.finishLocked(task)
+	sm.mu.Unlock()
+
+	sm.saveTask(task)
+
+	// Call callback if provided
+	if callback != nil {
+		callback(ctx, result)
+	}
 
 	// Send announce message back to main agent
-	if sm.bus != nil {
-		announceContent := fmt.Sprintf("Task '%s' completed.\n\nResult:\n%s", task.Label, task.Result)
-		sm.bus.PublishInbound(bus.InboundMessage{
-			Channel:  "system",
-			SenderID: fmt.Sprintf("subagent:%s", task.ID),
-			// Format: "original_channel:original_chat_id" for routing back
-			ChatID:  fmt.Sprintf("%s:%s", task.OriginChannel, task.OriginChatID),
-			Content: announceContent,
-		})
+	sm.announce(task)
+}
+
+// finishLocked records the end of a task and releases its context.
+// sm.mu must be held.
+func (sm *SubagentManager) finishLocked(task *SubagentTask) {
+	task.Finished = time.Now().UnixMilli()
+	if cancel, ok := sm.cancels[task.ID]; ok {
+		cancel()
+		delete(sm.cancels, task.ID)
+	}
+}
+
+// announce reports a finished task to the main agent as a system message.
+// The metadata identifies the originating session so the result can be fed
+// back into that conversation, whatever the outcome.
+func (sm *SubagentManager) announce(task *SubagentTask) {
+	if sm.bus == nil {
+		return
 	}
+
+	label := task.Label
+	if label == "" {
+		label = task.ID
+	}
+	content := fmt.Sprintf("[Subagent task %s]\nLabel: %s\nStatus: %s\nTask: %s\n\nResult:\n%s",
+		task.ID, label, task.Status, task.Task, task.Result)
+
+	sm.mu.RLock()
+	owner := sm.owner
+	sm.mu.RUnlock()
+
+	sm.bus.PublishInbound(bus.InboundMessage{
+		Channel:  "system",
+		SenderID: fmt.Sprintf("subagent:%s", task.ID),
+		// Format: "original_channel:original_chat_id" for routing back
+		ChatID:  fmt.Sprintf("%s:%s", task.OriginChannel, task.OriginChatID),
+		Content: content,
+		Metadata: map[string]string{
+			"kind":           SubagentResultKind,
+			"task_id":        task.ID,
+			"label":          task.Label,
+			"status":         task.Status,
+			"session_key":    task.SessionKey,
+			"origin_channel": task.OriginChannel,
+			"origin_chat_id": task.OriginChatID,
+			"agent":          owner,
+		},
+	})
 }
 
+// GetTask returns a copy of the task, safe to read while it keeps running.
 func (sm *SubagentManager) GetTask(taskID string) (*SubagentTask, bool) {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
 	task, ok := sm.tasks[taskID]
-	return task, ok
+	if !ok {
+		return nil, false
+	}
+	snapshot := *task
+	snapshot.Transcript = append([]providers.Message(nil), task.Transcript...)
+	return &snapshot, true
 }
 
+// ListTasks returns copies of all tasks, newest first, without transcripts.
 func (sm *SubagentManager) ListTasks() []*SubagentTask {
 	sm.mu.RLock()
 	defer sm.mu.RUnlock()
-
 	tasks := make([]*SubagentTask, 0, len(sm.tasks))
 	for _, task := range sm.tasks {
-		tasks = append(tasks, task)
+		snapshot := *task
+		snapshot.Transcript = nil
+		tasks = append(tasks, &snapshot)
 	}
+	sort.Slice(tasks, func(i, j int) bool {
+		if tasks[i].Created != tasks[j].Created {
+			return tasks[i].Created > tasks[j].Created
+		}
+		return tasks[i].ID > tasks[j].ID
+	})
 	return tasks
 }
 
+// agentParameter describes the "agent" parameter of spawn and subagent,
+// listing the configured profiles.
+func agentParameter(manager *SubagentManager) map[string]interface{} {
+	description := "Optional named agent profile to run the task as"
+	if manager != nil {
+		profiles := manager.Profiles()
+		names := make([]string, 0, len(profiles))
+		for name := range profiles {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		if len(names) > 0 {
+			description += ". Available:"
+			for _, name := range names {
+				description += "\n- " + name
+				if profiles[name] != "" {
+					description += ": " + profiles[name]
+				}
+			}
+		}
+	}
+	return map[string]interface{}{
+		"type":        "string",
+		"description": description,
+	}
+}
+
 // SubagentTool executes a subagent task synchronously and returns the result.
 // Unlike SpawnTool which runs tasks asynchronously, SubagentTool waits for completion
 // and returns the result directly in the ToolResult.
@@ -242,6 +572,7 @@ func (t *SubagentTool) Parameters() map[string]interface{} {
 				"type":        "string",
 				"description": "Optional short label for the task (for display)",
 			},
+			"agent": agentParameter(t.manager),
 		},
 		"required": []string{"task"},
 	}
@@ -259,16 +590,24 @@ func (t *SubagentTool) Execute(ctx context.Context, args map[string]interface{})
 	}
 
 	label, _ := args["label"].(string)
+	agent, _ := args["agent"].(string)
 
 	if t.manager == nil {
 		return ErrorResult("Subagent manager not configured").WithError(fmt.Errorf("manager is nil"))
 	}
 
+	// Resolve the agent profile's model, tools and prompt
+	loopConfig, systemPrompt, err := t.manager.loopConfig(agent,
+		"You are a subagent. Complete the given task independently and provide a clear, concise result.")
+	if err != nil {
+		return ErrorResult(err.Error()).WithError(err)
+	}
+
 	// Build messages for subagent
 	messages := []providers.Message{
 		{
 			Role:    "system",
-			Content: "You are a subagent. Complete the given task independently and provide a clear, concise result.",
+			Content: systemPrompt,
 		},
 		{
 			Role:    "user",
@@ -277,23 +616,7 @@ func (t *SubagentTool) Execute(ctx context.Context, args map[string]interface{})
 	}
 
 	// Use RunToolLoop to execute with tools (same as async SpawnTool)
-	sm := t.manager
-	sm.mu.RLock()
-	tools := sm.tools
-	maxIter := sm.maxIterations
-	sm.mu.RUnlock()
-
-	loopResult, err := RunToolLoop(ctx, ToolLoopConfig{
-		Provider:      sm.provider,
-		Model:         sm.defaultModel,
-		Tools:         tools,
-		MaxIterations: maxIter,
-		LLMOptions: map[string]any{
-			"max_tokens":  4096,
-			"temperature": 0.7,
-		},
-	}, messages, t.originChannel, t.originChatID)
-
+	loopResult, err := RunToolLoop(ctx, loopConfig, messages, t.originChannel, t.originChatID)
 	if err != nil {
 		return ErrorResult(fmt.Sprintf("Subagent execution failed: %v", err)).WithError(err)
 	}
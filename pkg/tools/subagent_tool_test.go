@@ -2,10 +2,13 @@ package tools
 
 import (
 	"context"
+	"fmt"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/sipeed/picoclaw/pkg/bus"
+	"github.com/sipeed/picoclaw/pkg/permissions"
 	"github.com/sipeed/picoclaw/pkg/providers"
 )
 
@@ -313,3 +316,141 @@ func TestSubagentTool_ForUserTruncation(t *testing.T) {
 		t.Error("ForLLM should contain reference to original task")
 	}
 }
+
+type failingLLMProvider struct{ MockLLMProvider }
+
+func (m *failingLLMProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, options map[string]interface{}) (*providers.LLMResponse, error) {
+	return nil, fmt.Errorf("provider unavailable")
+}
+
+// TestSubagentManager_AnnouncesOutcomeToOriginSession verifies finished
+// spawn tasks report back with their status and originating session
+func TestSubagentManager_AnnouncesOutcomeToOriginSession(t *testing.T) {
+	tests := []struct {
+		name       string
+		provider   providers.LLMProvider
+		cancel     bool
+		wantStatus string
+	}{
+		{"completed", &MockLLMProvider{}, false, SubagentCompleted},
+		{"failed", &failingLLMProvider{}, false, SubagentFailed},
+		{"cancelled", &blockingLLMProvider{}, true, SubagentCancelled},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msgBus := bus.NewMessageBus()
+			manager := NewSubagentManager(tt.provider, "test-model", "/tmp/test", msgBus)
+			manager.SetOwner("family")
+
+			ctx := WithSessionKey(context.Background(), "telegram:42")
+			if _, err := manager.Spawn(ctx, "write the report", "report", "", "telegram", "42", nil); err != nil {
+				t.Fatalf("Spawn failed: %v", err)
+			}
+			if tt.cancel {
+				manager.Cancel("subagent-1")
+			}
+
+			recvCtx, recvCancel := context.WithTimeout(context.Background(), 2*time.Second)
+			defer recvCancel()
+			msg, ok := msgBus.ConsumeInbound(recvCtx)
+			if !ok {
+				t.Fatal("expected an announce message")
+			}
+
+			if msg.Channel != "system" || msg.ChatID != "telegram:42" {
+				t.Errorf("unexpected routing: channel=%q chat=%q", msg.Channel, msg.ChatID)
+			}
+			if msg.Metadata["kind"] != SubagentResultKind || msg.Metadata["status"] != tt.wantStatus {
+				t.Errorf("unexpected metadata: %v", msg.Metadata)
+			}
+			if msg.Metadata["agent"] != "family" {
+				t.Errorf("expected the owning agent in metadata, got %q", msg.Metadata["agent"])
+			}
+			if msg.Metadata["session_key"] != "telegram:42" {
+				t.Errorf("expected origin session key, got %q", msg.Metadata["session_key"])
+			}
+			if !strings.Contains(msg.Content, "Status: "+tt.wantStatus) {
+				t.Errorf("expected status in content, got %q", msg.Content)
+			}
+		})
+	}
+}
+
+func TestSubagentManager_SpawnRecordsOriginRole(t *testing.T) {
+	manager := NewSubagentManager(&MockLLMProvider{}, "test-model", "/tmp/test", nil)
+	role := permissions.Restrict(nil, "guest", []string{"web_search"}, nil)
+
+	ctx := permissions.WithRole(context.Background(), role)
+	if _, err := manager.Spawn(ctx, "look it up", "", "", "telegram", "42", nil); err != nil {
+		t.Fatalf("Spawn failed: %v", err)
+	}
+
+	task, ok := manager.GetTask("subagent-1")
+	if !ok {
+		t.Fatal("expected the spawned task")
+	}
+	if task.Role != role {
+		t.Errorf("expected the task to keep the sender's role, got %+v", task.Role)
+	}
+}
+
+type modelRecordingProvider struct {
+	MockLLMProvider
+	model  string
+	system string
+	tools  []string
+}
+
+func (m *modelRecordingProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, options map[string]interface{}) (*providers.LLMResponse, error) {
+	m.model = model
+	m.system = messages[0].Content
+	m.tools = nil
+	for _, tool := range tools {
+		m.tools = append(m.tools, tool.Function.Name)
+	}
+	return &providers.LLMResponse{Content: "done"}, nil
+}
+
+// TestSubagentTool_Execute_AgentProfile verifies the agent parameter selects
+// the profile's model, prompt and tools
+func TestSubagentTool_Execute_AgentProfile(t *testing.T) {
+	provider := &modelRecordingProvider{}
+	manager := NewSubagentManager(provider, "default-model", "/tmp/test", nil)
+	manager.RegisterTool(NewMessageTool())
+
+	researchTools := NewToolRegistry()
+	researchTools.Register(NewWebFetchTool(1000))
+	manager.SetProfile("researcher", SubagentProfile{
+		Description:  "Web research",
+		SystemPrompt: "You research things.",
+		Model:        "cheap-model",
+		Tools:        researchTools,
+	})
+	tool := NewSubagentTool(manager)
+
+	params := tool.Parameters()["properties"].(map[string]interface{})
+	agentParam := params["agent"].(map[string]interface{})
+	if !strings.Contains(agentParam["description"].(string), "researcher: Web research") {
+		t.Errorf("expected profiles listed in the agent parameter, got %q", agentParam["description"])
+	}
+
+	result := tool.Execute(context.Background(), map[string]interface{}{"task": "look it up", "agent": "researcher"})
+	if result.IsError {
+		t.Fatalf("unexpected error: %s", result.ForLLM)
+	}
+	if provider.model != "cheap-model" || !strings.HasPrefix(provider.system, "You research things.") {
+		t.Errorf("expected profile model and prompt, got model=%q system=%q", provider.model, provider.system)
+	}
+	if len(provider.tools) != 1 || provider.tools[0] != "web_fetch" {
+		t.Errorf("expected only the profile's tools, got %v", provider.tools)
+	}
+
+	result = tool.Execute(context.Background(), map[string]interface{}{"task": "x", "agent": "nobody"})
+	if !result.IsError {
+		t.Error("expected an error for an unknown agent")
+	}
+	if _, err := manager.Spawn(context.Background(), "x", "", "nobody", "cli", "direct", nil); err == nil {
+		t.Error("expected spawn to reject an unknown agent")
+	}
+}
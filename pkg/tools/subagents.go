@@ -0,0 +1,175 @@
+package tools
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/constants"
+	"github.com/sipeed/picoclaw/pkg/permissions"
+	"github.com/sipeed/picoclaw/pkg/utils"
+)
+
+// SubagentsTool lets the agent list, inspect and cancel spawned tasks. It
+// only covers tasks spawned from the current chat; see CanSeeTask.
+type SubagentsTool struct {
+	manager       *SubagentManager
+	originChannel string
+	originChatID  string
+}
+
+func NewSubagentsTool(manager *SubagentManager) *SubagentsTool {
+	return &SubagentsTool{
+		manager:       manager,
+		originChannel: "cli",
+		originChatID:  "direct",
+	}
+}
+
+// CanSeeTask reports whether a sender with role in the given chat may list,
+// inspect or cancel task: the local CLI and roles granted the "/tasks all"
+// command see every task, anyone else only the tasks spawned from the same
+// chat.
+func CanSeeTask(channel, chatID string, role *permissions.Role, task *SubagentTask) bool {
+	if role == nil && constants.IsInternalChannel(channel) {
+		return true
+	}
+	if role != nil && role.AllowsCommand("/tasks all") {
+		return true
+	}
+	return task.OriginChannel == channel && task.OriginChatID == chatID
+}
+
+func (t *SubagentsTool) Name() string {
+	return "subagents"
+}
+
+func (t *SubagentsTool) Description() string {
+	return "Manage background subagent tasks started with spawn. Use 'list' to see running and finished tasks, 'show' to read a task's full transcript, 'cancel' to stop a running task."
+}
+
+func (t *SubagentsTool) Parameters() map[string]interface{} {
+	return map[string]interface{}{
+		"type": "object",
+		"properties": map[string]interface{}{
+			"action": map[string]interface{}{
+				"type":        "string",
+				"enum":        []string{"list", "show", "cancel"},
+				"description": "Action to perform",
+			},
+			"task_id": map[string]interface{}{
+				"type":        "string",
+				"description": "Task ID (e.g. subagent-3), required for show and cancel",
+			},
+		},
+		"required": []string{"action"},
+	}
+}
+
+func (t *SubagentsTool) SetContext(channel, chatID string) {
+	t.originChannel = channel
+	t.originChatID = chatID
+}
+
+func (t *SubagentsTool) Execute(ctx context.Context, args map[string]interface{}) *ToolResult {
+	if t.manager == nil {
+		return ErrorResult("Subagent manager not configured").WithError(fmt.Errorf("manager is nil"))
+	}
+
+	action, _ := args["action"].(string)
+	taskID, _ := args["task_id"].(string)
+	role := permissions.RoleFromContext(ctx)
+	visible := func(task *SubagentTask) bool {
+		return CanSeeTask(t.originChannel, t.originChatID, role, task)
+	}
+
+	switch action {
+	case "list":
+		var tasks []*SubagentTask
+		for _, task := range t.manager.ListTasks() {
+			if visible(task) {
+				tasks = append(tasks, task)
+			}
+		}
+		return SilentResult(FormatSubagentTasks(tasks))
+	case "show":
+		if taskID == "" {
+			return ErrorResult("task_id is required for show")
+		}
+		task, ok := t.manager.GetTask(taskID)
+		if !ok || !visible(task) {
+			return ErrorResult(fmt.Sprintf("Task %s not found", taskID))
+		}
+		return SilentResult(FormatSubagentTranscript(task))
+	case "cancel":
+		if taskID == "" {
+			return ErrorResult("task_id is required for cancel")
+		}
+		if task, ok := t.manager.GetTask(taskID); ok && !visible(task) {
+			return ErrorResult(fmt.Sprintf("task %s not found", taskID))
+		}
+		if err := t.manager.Cancel(taskID); err != nil {
+			return ErrorResult(err.Error()).WithError(err)
+		}
+		return SilentResult(fmt.Sprintf("Cancellation requested for %s", taskID))
+	default:
+		return ErrorResult(fmt.Sprintf("unknown action: %s", action))
+	}
+}
+
+// FormatSubagentTasks renders a task list for the agent and the /tasks command.
+func FormatSubagentTasks(tasks []*SubagentTask) string {
+	if len(tasks) == 0 {
+		return "No subagent tasks"
+	}
+
+	var sb strings.Builder
+	sb.WriteString("Subagent tasks:\n")
+	for _, task := range tasks {
+		label := task.Label
+		if label == "" {
+			label = utils.Truncate(task.Task, 60)
+		}
+		if task.Agent != "" {
+			label += " (agent: " + task.Agent + ")"
+		}
+		started := time.UnixMilli(task.Created).Format("2006-01-02 15:04")
+		fmt.Fprintf(&sb, "- %s [%s] %s (started %s)\n", task.ID, task.Status, label, started)
+	}
+	return sb.String()
+}
+
+// FormatSubagentTranscript renders a task with its full transcript.
+func FormatSubagentTranscript(task *SubagentTask) string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "Task %s [%s]\n", task.ID, task.Status)
+	if task.Label != "" {
+		fmt.Fprintf(&sb, "Label: %s\n", task.Label)
+	}
+	if task.Agent != "" {
+		fmt.Fprintf(&sb, "Agent: %s\n", task.Agent)
+	}
+	fmt.Fprintf(&sb, "Task: %s\n", task.Task)
+	if task.Result != "" {
+		fmt.Fprintf(&sb, "Result: %s\n", task.Result)
+	}
+
+	sb.WriteString("\nTranscript:\n")
+	for _, msg := range task.Transcript {
+		switch {
+		case len(msg.ToolCalls) > 0:
+			for _, tc := range msg.ToolCalls {
+				if tc.Function != nil {
+					fmt.Fprintf(&sb, "[tool call] %s(%s)\n", tc.Function.Name, tc.Function.Arguments)
+				}
+			}
+			if msg.Content != "" {
+				fmt.Fprintf(&sb, "[assistant] %s\n", msg.Content)
+			}
+		default:
+			fmt.Fprintf(&sb, "[%s] %s\n", msg.Role, msg.Content)
+		}
+	}
+	return sb.String()
+}
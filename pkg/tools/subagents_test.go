@@ -0,0 +1,203 @@
+package tools
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sipeed/picoclaw/pkg/config"
+	"github.com/sipeed/picoclaw/pkg/permissions"
+	"github.com/sipeed/picoclaw/pkg/providers"
+)
+
+// blockingLLMProvider blocks every call until the context is cancelled.
+type blockingLLMProvider struct{ MockLLMProvider }
+
+func (m *blockingLLMProvider) Chat(ctx context.Context, messages []providers.Message, tools []providers.ToolDefinition, model string, options map[string]interface{}) (*providers.LLMResponse, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func waitForStatus(t *testing.T, manager *SubagentManager, taskID, status string) *SubagentTask {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		if task, ok := manager.GetTask(taskID); ok && task.Status == status {
+			return task
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	task, _ := manager.GetTask(taskID)
+	t.Fatalf("task %s did not reach status %s: %+v", taskID, status, task)
+	return nil
+}
+
+// waitForStored waits until the persisted record of taskID has status.
+func waitForStored(t *testing.T, dir, taskID, status string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for time.Now().Before(deadline) {
+		var task SubagentTask
+		if data, err := os.ReadFile(filepath.Join(dir, taskID+".json")); err == nil &&
+			json.Unmarshal(data, &task) == nil && task.Status == status {
+			return
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	t.Fatalf("stored task %s did not reach status %s", taskID, status)
+}
+
+func TestSubagentsTool_CancelRunningTask(t *testing.T) {
+	manager := NewSubagentManager(&blockingLLMProvider{}, "test-model", t.TempDir(), nil)
+	tool := NewSubagentsTool(manager)
+
+	if _, err := manager.Spawn(context.Background(), "wait forever", "waiter", "", "telegram", "42", nil); err != nil {
+		t.Fatalf("Spawn failed: %v", err)
+	}
+
+	list := tool.Execute(context.Background(), map[string]interface{}{"action": "list"})
+	if !strings.Contains(list.ForLLM, "subagent-1 [running] waiter") {
+		t.Errorf("expected running task in list, got %q", list.ForLLM)
+	}
+
+	result := tool.Execute(context.Background(), map[string]interface{}{"action": "cancel", "task_id": "subagent-1"})
+	if result.IsError {
+		t.Fatalf("cancel failed: %s", result.ForLLM)
+	}
+	waitForStatus(t, manager, "subagent-1", SubagentCancelled)
+
+	again := tool.Execute(context.Background(), map[string]interface{}{"action": "cancel", "task_id": "subagent-1"})
+	if !again.IsError {
+		t.Error("expected cancelling a finished task to fail")
+	}
+}
+
+func TestSubagentManager_TaskOutlivesSpawningContext(t *testing.T) {
+	manager := NewSubagentManager(&blockingLLMProvider{}, "test-model", t.TempDir(), nil)
+
+	ctx, cancel := context.WithCancel(WithSessionKey(context.Background(), "cron-job"))
+	if _, err := manager.Spawn(ctx, "wait forever", "", "", "telegram", "42", nil); err != nil {
+		t.Fatalf("Spawn failed: %v", err)
+	}
+	// The cron job (or turn) that spawned the task returns.
+	cancel()
+	time.Sleep(50 * time.Millisecond)
+
+	task, _ := manager.GetTask("subagent-1")
+	if task.Status != SubagentRunning {
+		t.Fatalf("task stopped with its spawning context: %+v", task)
+	}
+	if task.SessionKey != "cron-job" {
+		t.Errorf("SessionKey = %q, want the caller's", task.SessionKey)
+	}
+
+	if err := manager.Cancel("subagent-1"); err != nil {
+		t.Fatalf("Cancel failed: %v", err)
+	}
+	waitForStatus(t, manager, "subagent-1", SubagentCancelled)
+}
+
+func TestSubagentsTool_ScopedToCurrentChat(t *testing.T) {
+	manager := NewSubagentManager(&blockingLLMProvider{}, "test-model", t.TempDir(), nil)
+	tool := NewSubagentsTool(manager)
+
+	manager.Spawn(context.Background(), "mine", "mine", "", "telegram", "42", nil)
+	manager.Spawn(context.Background(), "theirs", "theirs", "", "telegram", "43", nil)
+	defer func() {
+		manager.Cancel("subagent-1")
+		manager.Cancel("subagent-2")
+	}()
+
+	tool.SetContext("telegram", "42")
+	guest := permissions.NewPolicy(config.PermissionsConfig{
+		Enabled: true,
+		Roles:   map[string]config.RoleConfig{"guest": {Tools: []string{"subagents"}}},
+	}).Role("guest")
+	ctx := permissions.WithRole(context.Background(), guest)
+
+	list := tool.Execute(ctx, map[string]interface{}{"action": "list"})
+	if !strings.Contains(list.ForLLM, "subagent-1") || strings.Contains(list.ForLLM, "subagent-2") {
+		t.Errorf("expected only this chat's task, got %q", list.ForLLM)
+	}
+	if show := tool.Execute(ctx, map[string]interface{}{"action": "show", "task_id": "subagent-2"}); !show.IsError {
+		t.Errorf("expected another chat's transcript to be hidden, got %q", show.ForLLM)
+	}
+	if cancel := tool.Execute(ctx, map[string]interface{}{"action": "cancel", "task_id": "subagent-2"}); !cancel.IsError {
+		t.Error("expected another chat's task to be protected")
+	}
+	if task, _ := manager.GetTask("subagent-2"); task.Status != SubagentRunning {
+		t.Errorf("expected the other task to keep running, got %s", task.Status)
+	}
+}
+
+func TestSubagentManager_ConcurrencyCap(t *testing.T) {
+	manager := NewSubagentManager(&blockingLLMProvider{}, "test-model", t.TempDir(), nil)
+	manager.SetMaxConcurrent(1)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	if _, err := manager.Spawn(ctx, "first", "", "", "cli", "direct", nil); err != nil {
+		t.Fatalf("first Spawn failed: %v", err)
+	}
+	if _, err := manager.Spawn(ctx, "second", "", "", "cli", "direct", nil); err == nil {
+		t.Fatal("expected second spawn to be rejected by the cap")
+	}
+
+	manager.Cancel("subagent-1")
+	waitForStatus(t, manager, "subagent-1", SubagentCancelled)
+
+	if _, err := manager.Spawn(ctx, "third", "", "", "cli", "direct", nil); err != nil {
+		t.Errorf("expected spawn to succeed once a slot is free: %v", err)
+	}
+}
+
+func TestSubagentManager_PersistsTasksAndTranscripts(t *testing.T) {
+	dir := t.TempDir()
+
+	manager := NewSubagentManager(&MockLLMProvider{}, "test-model", t.TempDir(), nil)
+	if err := manager.SetStorage(dir); err != nil {
+		t.Fatalf("SetStorage failed: %v", err)
+	}
+	manager.Spawn(context.Background(), "summarize the logs", "logs", "", "telegram", "42", nil)
+	waitForStatus(t, manager, "subagent-1", SubagentCompleted)
+
+	blocked := NewSubagentManager(&blockingLLMProvider{}, "test-model", t.TempDir(), nil)
+	blocked.SetStorage(dir)
+	blocked.Spawn(context.Background(), "never finishes", "", "", "telegram", "42", nil)
+	waitForStatus(t, blocked, "subagent-2", SubagentRunning)
+
+	// A new manager over the same storage sees both tasks; the one that
+	// was still running is marked interrupted.
+	restarted := NewSubagentManager(&MockLLMProvider{}, "test-model", t.TempDir(), nil)
+	if err := restarted.SetStorage(dir); err != nil {
+		t.Fatalf("SetStorage failed: %v", err)
+	}
+
+	done, ok := restarted.GetTask("subagent-1")
+	if !ok || done.Status != SubagentCompleted {
+		t.Fatalf("expected completed task to be restored, got %+v", done)
+	}
+	transcript := FormatSubagentTranscript(done)
+	if !strings.Contains(transcript, "[user] summarize the logs") || !strings.Contains(transcript, "[assistant] Task completed: summarize the logs") {
+		t.Errorf("expected full transcript, got %q", transcript)
+	}
+
+	if lost, _ := restarted.GetTask("subagent-2"); lost == nil || lost.Status != SubagentInterrupted {
+		t.Errorf("expected running task to be marked interrupted, got %+v", lost)
+	}
+
+	result, _ := restarted.Spawn(context.Background(), "next", "", "", "cli", "direct", nil)
+	if !strings.Contains(result, "subagent-3") {
+		t.Errorf("expected IDs to continue after restored tasks, got %q", result)
+	}
+
+	// Let background tasks finish writing before the storage is removed.
+	blocked.Cancel("subagent-2")
+	waitForStored(t, dir, "subagent-2", SubagentCancelled)
+	waitForStored(t, dir, "subagent-3", SubagentCompleted)
+}
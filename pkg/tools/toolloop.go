@@ -23,6 +23,12 @@ type ToolLoopConfig struct {
 	Tools         *ToolRegistry
 	MaxIterations int
 	LLMOptions    map[string]any
+	// MaxParallelTools caps how many concurrency-safe tool calls run at
+	// once; see ExecuteToolCalls.
+	MaxParallelTools int
+	// OnMessage, if set, is called with each assistant and tool message
+	// as it is added to the conversation.
+	OnMessage func(providers.Message)
 }
 
 // ToolLoopResult contains the result of running the tool loop.
@@ -49,7 +55,7 @@ func RunToolLoop(ctx context.Context, config ToolLoopConfig, messages []provider
 		// 1. Build tool definitions
 		var providerToolDefs []providers.ToolDefinition
 		if config.Tools != nil {
-			providerToolDefs = config.Tools.ToProviderDefs()
+			providerToolDefs = config.Tools.ToProviderDefsWithContext(ctx)
 		}
 
 		// 2. Set default LLM options
@@ -75,6 +81,9 @@ func RunToolLoop(ctx context.Context, config ToolLoopConfig, messages []provider
 		// 4. If no tool calls, we're done
 		if len(response.ToolCalls) == 0 {
 			finalContent = response.Content
+			if config.OnMessage != nil {
+				config.OnMessage(providers.Message{Role: "assistant", Content: finalContent})
+			}
 			logger.InfoCF("toolloop", "LLM response without tool calls (direct answer)",
 				map[string]any{
 					"iteration":     iteration,
@@ -112,9 +121,12 @@ func RunToolLoop(ctx context.Context, config ToolLoopConfig, messages []provider
 			})
 		}
 		messages = append(messages, assistantMsg)
+		if config.OnMessage != nil {
+			config.OnMessage(assistantMsg)
+		}
 
-		// 7. Execute tool calls
-		for _, tc := range response.ToolCalls {
+		// 7. Execute tool calls, in parallel where the tools allow it
+		results := ExecuteToolCalls(config.Tools, response.ToolCalls, config.MaxParallelTools, func(tc providers.ToolCall) *ToolResult {
 			argsJSON, _ := json.Marshal(tc.Arguments)
 			argsPreview := utils.Truncate(string(argsJSON), 200)
 			logger.InfoCF("toolloop", fmt.Sprintf("Tool call: %s(%s)", tc.Name, argsPreview),
@@ -124,12 +136,15 @@ func RunToolLoop(ctx context.Context, config ToolLoopConfig, messages []provider
 				})
 
 			// Execute tool (no async callback for subagents - they run independently)
-			var toolResult *ToolResult
-			if config.Tools != nil {
-				toolResult = config.Tools.ExecuteWithContext(ctx, tc.Name, tc.Arguments, channel, chatID, nil)
-			} else {
-				toolResult = ErrorResult("No tools available")
+			if config.Tools == nil {
+				return ErrorResult("No tools available")
 			}
+			return config.Tools.ExecuteWithContext(ctx, tc.Name, tc.Arguments, channel, chatID, nil)
+		})
+
+		// 8. Add tool results in the order of the calls
+		for i, tc := range response.ToolCalls {
+			toolResult := results[i]
 
 			// Determine content for LLM
 			contentForLLM := toolResult.ForLLM
@@ -144,6 +159,9 @@ func RunToolLoop(ctx context.Context, config ToolLoopConfig, messages []provider
 				ToolCallID: tc.ID,
 			}
 			messages = append(messages, toolResultMsg)
+			if config.OnMessage != nil {
+				config.OnMessage(toolResultMsg)
+			}
 		}
 	}
 
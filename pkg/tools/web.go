@@ -218,6 +218,11 @@ func (t *WebSearchTool) Name() string {
 	return "web_search"
 }
 
+// ConcurrencySafe implements ConcurrentTool.
+func (t *WebSearchTool) ConcurrencySafe() bool {
+	return true
+}
+
 func (t *WebSearchTool) Description() string {
 	return "Search the web for current information. Returns titles, URLs, and snippets from search results."
 }
@@ -282,6 +287,11 @@ func (t *WebFetchTool) Name() string {
 	return "web_fetch"
 }
 
+// ConcurrencySafe implements ConcurrentTool.
+func (t *WebFetchTool) ConcurrencySafe() bool {
+	return true
+}
+
 func (t *WebFetchTool) Description() string {
 	return "Fetch a URL and extract readable content (HTML to text). Use this to get weather info, news, articles, or any web content."
 }